@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	handler "github.com/JMURv/media-server/internal/hdl/http"
+	"github.com/JMURv/media-server/internal/migrate"
 	cfg "github.com/JMURv/media-server/pkg/config"
+	"github.com/JMURv/media-server/pkg/version"
 	"log"
 	"os"
 	"os/signal"
@@ -28,6 +31,87 @@ func handleGracefulShutdown(ctx context.Context, cancel context.CancelFunc, h *h
 	os.Exit(0)
 }
 
+// handleReload re-reads the config on SIGHUP and applies the storage roots
+// it lists, so a disk added to them takes new uploads without a restart.
+// Other settings still need one.
+func handleReload(ctx context.Context, h *handler.Handler) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	defer signal.Stop(ch)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+		}
+
+		conf, err := cfg.Load(configPath)
+		if err != nil {
+			log.Printf("Error reloading config: %s\n", err)
+			continue
+		}
+		var roots *cfg.RootsConfig
+		if conf.HTTP != nil {
+			roots = conf.HTTP.Roots
+		}
+		if err = h.ReloadRoots(roots); err != nil {
+			log.Printf("Error reloading storage roots: %s\n", err)
+		}
+	}
+}
+
+// reportMigrations prints the migrations pending for savePath without applying
+// them and returns the exit code.
+func reportMigrations(savePath string) int {
+	v, err := migrate.Version(savePath)
+	if err != nil {
+		log.Printf("Error reading on-disk version: %s\n", err)
+		return 1
+	}
+
+	pending, err := migrate.Pending(savePath)
+	if err != nil {
+		log.Printf("Error checking migrations: %s\n", err)
+		return 1
+	}
+	fmt.Printf("On-disk version %d, latest %d\n", v, migrate.Latest())
+	for _, s := range pending {
+		fmt.Printf("Pending migration %d: %s\n", s.Version, s.Name)
+	}
+	return 0
+}
+
+// runEncryption encrypts the files stored in the clear, or with rotate
+// re-wraps the data keys of the encrypted ones, and returns the exit code.
+// Interrupted runs pick up where they stopped when run again.
+func runEncryption(conf *cfg.Config, rotate bool) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	h := handler.New(fmt.Sprintf(":%v", conf.Port), conf.SavePath, conf.HTTP)
+	run, done := h.EncryptStored, "Encrypted"
+	if rotate {
+		run, done = h.RotateKeys, "Rewrapped"
+	}
+
+	res, err := run(ctx)
+	if res != nil {
+		fmt.Printf("%s %d files, skipped %d, failed %d\n", done, res.Done, res.Skipped, len(res.Failed))
+		for _, name := range res.Failed {
+			fmt.Printf("Failed: %s\n", name)
+		}
+	}
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+		return 1
+	}
+	if len(res.Failed) > 0 {
+		return 1
+	}
+	return 0
+}
+
 func main() {
 	defer func() {
 		if err := recover(); err != nil {
@@ -36,17 +120,31 @@ func main() {
 		}
 	}()
 
-	conf := cfg.MustLoad(configPath)
-	ctx, cancel := context.WithCancel(context.Background())
+	checkMigrations := flag.Bool("check-migrations", false, "report pending save path migrations and exit")
+	showVersion := flag.Bool("version", false, "print the build version and exit")
+	encrypt := flag.Bool("encrypt", false, "encrypt the files stored in the clear and exit; stop the server first")
+	rotateKeys := flag.Bool("rotate-keys", false, "re-wrap the data keys of encrypted files with the active key and exit")
+	flag.Parse()
 
-	if _, err := os.Stat(conf.SavePath); os.IsNotExist(err) {
-		err = os.MkdirAll(conf.SavePath, os.ModePerm)
-		if err != nil {
-			log.Fatalf("Error creating save path: %s\n", err)
-		}
+	if *showVersion {
+		v := version.Get()
+		fmt.Printf("media-server %s (commit %s, %s)\n", v.Version, v.Commit, v.GoVersion)
+		return
 	}
 
+	conf := cfg.MustLoad(configPath)
+	if *checkMigrations {
+		os.Exit(reportMigrations(conf.SavePath))
+	}
+	if *encrypt || *rotateKeys {
+		os.Exit(runEncryption(conf, *rotateKeys))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
 	h := handler.New(fmt.Sprintf(":%v", conf.Port), conf.SavePath, conf.HTTP)
+	h.SetConfigSource(conf.Path, conf.LoadedAt)
 	go handleGracefulShutdown(ctx, cancel, h)
+	go handleReload(ctx, h)
 	h.Start()
 }
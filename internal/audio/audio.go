@@ -0,0 +1,214 @@
+package audio
+
+import (
+	"errors"
+	"math"
+	"strings"
+)
+
+const (
+	FormatWAV  = "wav"
+	FormatMP3  = "mp3"
+	FormatFLAC = "flac"
+)
+
+const (
+	Resolution = 8000
+	Silence    = -70.0
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported audio format")
+
+type Analysis struct {
+	Peaks    []float64 `json:"peaks"`
+	Loudness float64   `json:"loudness"`
+	Duration float64   `json:"duration"`
+}
+
+func FormatFor(contentType string) string {
+	switch strings.ToLower(contentType) {
+	case "audio/wav", "audio/wave", "audio/x-wav", "audio/vnd.wave":
+		return FormatWAV
+	case "audio/mpeg", "audio/mp3":
+		return FormatMP3
+	case "audio/flac", "audio/x-flac":
+		return FormatFLAC
+	default:
+		return ""
+	}
+}
+
+func Resample(peaks []float64, n int) []float64 {
+	if n <= 0 || n >= len(peaks) {
+		res := make([]float64, len(peaks))
+		copy(res, peaks)
+		return res
+	}
+
+	res := make([]float64, n)
+	for i := range res {
+		start, end := i*len(peaks)/n, (i+1)*len(peaks)/n
+		for _, p := range peaks[start:max(end, start+1)] {
+			res[i] = max(res[i], p)
+		}
+	}
+	return res
+}
+
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// kWeighting returns the BS.1770 pre-filter and RLB high-pass for rate.
+func kWeighting(rate int) [2]biquad {
+	f0, g, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / float64(rate))
+	vh := math.Pow(10, g/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / float64(rate))
+	a0 = 1 + k/q + k*k
+	highPass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return [2]biquad{shelf, highPass}
+}
+
+type analyzer struct {
+	rate     int
+	channels int
+	samples  int64
+
+	peakSize int
+	peakLen  int
+	peak     float64
+	peaks    []float64
+
+	filters  [][2]biquad
+	stepSize int
+	stepLen  int
+	step     float64
+	steps    []float64
+}
+
+func newAnalyzer(rate, channels int) *analyzer {
+	a := &analyzer{
+		rate:     rate,
+		channels: channels,
+		peakSize: max(rate/100, 1),
+		stepSize: max(rate/10, 1),
+		filters:  make([][2]biquad, channels),
+	}
+	for i := range a.filters {
+		a.filters[i] = kWeighting(rate)
+	}
+	return a
+}
+
+func (a *analyzer) add(frame []float64) {
+	a.samples++
+	for ch, v := range frame {
+		a.peak = max(a.peak, math.Abs(v))
+
+		f := &a.filters[ch]
+		y := f[1].process(f[0].process(v))
+		a.step += y * y
+	}
+
+	if a.peakLen++; a.peakLen == a.peakSize {
+		a.peaks = append(a.peaks, a.peak)
+		a.peak, a.peakLen = 0, 0
+	}
+	if a.stepLen++; a.stepLen == a.stepSize {
+		a.steps = append(a.steps, a.step/float64(a.stepSize))
+		a.step, a.stepLen = 0, 0
+	}
+}
+
+func blockLoudness(power float64) float64 {
+	return -0.691 + 10*math.Log10(power)
+}
+
+// loudness gates 400ms blocks with 75% overlap as described in BS.1770-4.
+func (a *analyzer) loudness() float64 {
+	var blocks []float64
+	for i := 0; i+4 <= len(a.steps); i++ {
+		blocks = append(blocks, (a.steps[i]+a.steps[i+1]+a.steps[i+2]+a.steps[i+3])/4)
+	}
+	if len(blocks) == 0 && len(a.steps) > 0 {
+		sum := 0.0
+		for _, s := range a.steps {
+			sum += s
+		}
+		blocks = append(blocks, sum/float64(len(a.steps)))
+	}
+
+	gated := func(threshold float64) (float64, int) {
+		sum, n := 0.0, 0
+		for _, b := range blocks {
+			if b > 0 && blockLoudness(b) > threshold {
+				sum, n = sum+b, n+1
+			}
+		}
+		return sum, n
+	}
+
+	sum, n := gated(Silence)
+	if n == 0 {
+		return Silence
+	}
+	sum, n = gated(blockLoudness(sum/float64(n)) - 10)
+	if n == 0 {
+		return Silence
+	}
+	return math.Round(blockLoudness(sum/float64(n))*10) / 10
+}
+
+func (a *analyzer) result() *Analysis {
+	if a.peakLen > 0 {
+		a.peaks = append(a.peaks, a.peak)
+	}
+
+	peaks := Resample(a.peaks, Resolution)
+	top := 0.0
+	for _, p := range peaks {
+		top = max(top, p)
+	}
+	for i, p := range peaks {
+		if top > 0 {
+			p /= top
+		}
+		peaks[i] = math.Round(p*1000) / 1000
+	}
+
+	duration := 0.0
+	if a.rate > 0 {
+		duration = float64(a.samples) / float64(a.rate)
+	}
+	return &Analysis{
+		Peaks:    peaks,
+		Loudness: a.loudness(),
+		Duration: math.Round(duration*1000) / 1000,
+	}
+}
@@ -0,0 +1,215 @@
+package audio
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/mewkiz/flac"
+	"io"
+	"math"
+	"os/exec"
+)
+
+const (
+	wavePCM        = 1
+	waveFloat      = 3
+	waveExtensible = 0xfffe
+
+	ffmpegRate = 48000
+)
+
+func Analyze(r io.Reader, format string) (*Analysis, error) {
+	switch format {
+	case FormatWAV:
+		return analyzeWAV(r)
+	case FormatMP3:
+		return analyzeMP3(r)
+	case FormatFLAC:
+		return analyzeFLAC(r)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+}
+
+func AnalyzeFFmpeg(ctx context.Context, ffmpeg string, r io.Reader) (*Analysis, error) {
+	cmd := exec.CommandContext(
+		ctx, ffmpeg, "-hide_banner", "-nostdin", "-i", "pipe:0",
+		"-vn", "-ac", "2", "-ar", fmt.Sprint(ffmpegRate), "-f", "s16le", "pipe:1",
+	)
+	cmd.Stdin = r
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	res, err := analyzePCM16(bufio.NewReader(out), ffmpegRate, 2)
+	if err = errors.Join(err, cmd.Wait()); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w", err)
+	}
+	return res, nil
+}
+
+func analyzePCM16(r io.Reader, rate, channels int) (*Analysis, error) {
+	a := newAnalyzer(rate, channels)
+	buf := make([]byte, 2*channels)
+	frame := make([]float64, channels)
+	for {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return a.result(), nil
+			}
+			return nil, err
+		}
+
+		for ch := range frame {
+			frame[ch] = float64(int16(binary.LittleEndian.Uint16(buf[2*ch:]))) / 32768
+		}
+		a.add(frame)
+	}
+}
+
+func analyzeMP3(r io.Reader) (*Analysis, error) {
+	dec, err := mp3.NewDecoder(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedFormat, err)
+	}
+	return analyzePCM16(bufio.NewReader(dec), dec.SampleRate(), 2)
+}
+
+func analyzeFLAC(r io.Reader) (*Analysis, error) {
+	stream, err := flac.New(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedFormat, err)
+	}
+
+	channels := int(stream.Info.NChannels)
+	scale := math.Ldexp(1, int(stream.Info.BitsPerSample)-1)
+	a := newAnalyzer(int(stream.Info.SampleRate), channels)
+	frame := make([]float64, channels)
+	for {
+		f, err := stream.ParseNext()
+		if errors.Is(err, io.EOF) {
+			return a.result(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range int(f.BlockSize) {
+			for ch := range frame {
+				frame[ch] = float64(f.Subframes[ch].Samples[i]) / scale
+			}
+			a.add(frame)
+		}
+	}
+}
+
+type waveFormat struct {
+	format   uint16
+	channels int
+	rate     int
+	align    int
+	bits     int
+}
+
+func (f waveFormat) sample(b []byte) (float64, error) {
+	switch {
+	case f.format == waveFloat && f.bits == 32:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), nil
+	case f.format == waveFloat && f.bits == 64:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case f.format != wavePCM:
+	case f.bits == 8:
+		return (float64(b[0]) - 128) / 128, nil
+	case f.bits == 16:
+		return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15), nil
+	case f.bits == 24:
+		v := int32(b[0])<<8 | int32(b[1])<<16 | int32(b[2])<<24
+		return float64(v>>8) / (1 << 23), nil
+	case f.bits == 32:
+		return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31), nil
+	}
+	return 0, fmt.Errorf("%w: wav format %d with %d bits", ErrUnsupportedFormat, f.format, f.bits)
+}
+
+func analyzeWAV(r io.Reader) (*Analysis, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:4]) != "RIFF" || string(header[8:]) != "WAVE" {
+		return nil, fmt.Errorf("%w: not a wav file", ErrUnsupportedFormat)
+	}
+
+	var f *waveFormat
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, fmt.Errorf("%w: missing data chunk", ErrUnsupportedFormat)
+		}
+		id, size := string(chunk[:4]), int64(binary.LittleEndian.Uint32(chunk[4:]))
+
+		switch id {
+		case "fmt ":
+			data := make([]byte, size)
+			if _, err := io.ReadFull(br, data); err != nil || size < 16 {
+				return nil, fmt.Errorf("%w: invalid fmt chunk", ErrUnsupportedFormat)
+			}
+			f = &waveFormat{
+				format:   binary.LittleEndian.Uint16(data[0:]),
+				channels: int(binary.LittleEndian.Uint16(data[2:])),
+				rate:     int(binary.LittleEndian.Uint32(data[4:])),
+				align:    int(binary.LittleEndian.Uint16(data[12:])),
+				bits:     int(binary.LittleEndian.Uint16(data[14:])),
+			}
+			if f.format == waveExtensible && size >= 26 {
+				f.format = binary.LittleEndian.Uint16(data[24:])
+			}
+			if f.channels == 0 || f.rate == 0 || f.align < f.channels*f.bits/8 {
+				return nil, fmt.Errorf("%w: invalid fmt chunk", ErrUnsupportedFormat)
+			}
+			if _, err := f.sample(make([]byte, 8)); err != nil {
+				return nil, err
+			}
+		case "data":
+			if f == nil {
+				return nil, fmt.Errorf("%w: data before fmt chunk", ErrUnsupportedFormat)
+			}
+			return analyzeWAVData(io.LimitReader(br, size), f)
+		default:
+			if _, err := br.Discard(int(size + size%2)); err != nil {
+				return nil, fmt.Errorf("%w: truncated chunk", ErrUnsupportedFormat)
+			}
+			continue
+		}
+
+		if size%2 == 1 {
+			br.Discard(1)
+		}
+	}
+}
+
+func analyzeWAVData(r io.Reader, f *waveFormat) (*Analysis, error) {
+	a := newAnalyzer(f.rate, f.channels)
+	width := f.bits / 8
+	buf := make([]byte, f.align)
+	frame := make([]float64, f.channels)
+	for {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return a.result(), nil
+			}
+			return nil, err
+		}
+
+		for ch := range frame {
+			frame[ch], _ = f.sample(buf[ch*width:])
+		}
+		a.add(frame)
+	}
+}
@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+type Entry struct {
+	Time    time.Time      `json:"time"`
+	Action  string         `json:"action"`
+	Name    string         `json:"name"`
+	Actor   string         `json:"actor"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+type Logger struct {
+	mu   sync.Mutex
+	w    io.Writer
+	file *os.File
+}
+
+func New(path string) *Logger {
+	if path == "" {
+		return &Logger{w: log.Writer()}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("Error opening audit log %s, falling back to stderr: %s\n", path, err)
+		return &Logger{w: log.Writer()}
+	}
+	return &Logger{w: file, file: file}
+}
+
+func (l *Logger) Log(action, name, actor string, details map[string]any) {
+	data, err := json.Marshal(
+		&Entry{
+			Time:    time.Now().UTC(),
+			Action:  action,
+			Name:    name,
+			Actor:   actor,
+			Details: details,
+		},
+	)
+	if err != nil {
+		log.Printf("Error encoding audit entry: %s\n", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err = l.w.Write(append(data, '\n')); err != nil {
+		log.Printf("Error writing audit entry: %s\n", err)
+	}
+}
+
+func (l *Logger) Close() error {
+	if l.file != nil {
+		return l.file.Close()
+	}
+	return nil
+}
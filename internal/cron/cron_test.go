@@ -0,0 +1,48 @@
+package cron
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse(time.RFC3339, s)
+		assert.Nil(t, err)
+		return v
+	}
+
+	for _, tc := range []struct {
+		spec, from, next string
+	}{
+		{"@daily", "2024-03-01T12:30:00Z", "2024-03-02T00:00:00Z"},
+		{"@hourly", "2024-03-01T12:00:00Z", "2024-03-01T13:00:00Z"},
+		{"0 8 * * *", "2024-03-01T07:59:59Z", "2024-03-01T08:00:00Z"},
+		{"0 8 * * *", "2024-03-01T08:00:00Z", "2024-03-02T08:00:00Z"},
+		{"*/15 * * * *", "2024-03-01T12:31:00Z", "2024-03-01T12:45:00Z"},
+		{"30 9 * * 1-5", "2024-03-01T10:00:00Z", "2024-03-04T09:30:00Z"},
+		{"0 0 * * 7", "2024-03-01T00:00:00Z", "2024-03-03T00:00:00Z"},
+		{"0 0 1 * *", "2024-12-15T00:00:00Z", "2025-01-01T00:00:00Z"},
+		{"0 0 29 2 *", "2024-03-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+		{"0 6 1 * 1", "2024-03-01T07:00:00Z", "2024-03-04T06:00:00Z"},
+		{"0 12,18 * * *", "2024-03-01T12:00:00Z", "2024-03-01T18:00:00Z"},
+	} {
+		s, err := Parse(tc.spec)
+		if assert.Nil(t, err, tc.spec) {
+			assert.Equal(t, at(tc.next), s.Next(at(tc.from)), tc.spec)
+		}
+	}
+
+	s, err := Parse("0 0 30 2 *")
+	assert.Nil(t, err)
+	assert.True(t, s.Next(at("2024-01-01T00:00:00Z")).IsZero())
+}
+
+func TestParse(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@yearly"} {
+		_, err := Parse(spec)
+		assert.ErrorIs(t, err, ErrInvalidSpec, spec)
+	}
+}
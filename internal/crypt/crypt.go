@@ -0,0 +1,389 @@
+// Package crypt encrypts stored files at rest. Every file has a data key of
+// its own, wrapped by a master key, and its content is sealed with AES-GCM in
+// chunks, so ranges can be read without decrypting what comes before them.
+//
+// A file starts with a fixed prefix naming the format version and the file,
+// followed by two header slots holding the wrapped data key. Rewrapping writes
+// the slot not in use, so a crash part way leaves the other one intact. The
+// sealed chunks follow at HeaderSize; the last one is flagged as such, so
+// a file cut short at a chunk boundary fails to decrypt rather than reading
+// as a shorter one.
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+)
+
+const (
+	Version = 1
+
+	// ChunkSize is the plaintext held by each sealed chunk but the last.
+	ChunkSize = 64 << 10
+	// Overhead is the size the GCM tag adds to each chunk.
+	Overhead = 16
+	// HeaderSize is where the first chunk starts.
+	HeaderSize = prefixSize + 2*slotSize
+
+	magic      = "MSENC"
+	prefixSize = 32
+	slotSize   = 496
+	idSize     = 16
+	keySize    = 32
+)
+
+var (
+	ErrNotEncrypted       = errors.New("file is not encrypted")
+	ErrCorrupt            = errors.New("encrypted file is corrupt")
+	ErrUnsupportedVersion = errors.New("unsupported encryption format version")
+	ErrUnknownKey         = errors.New("unknown master key")
+)
+
+type header struct {
+	id      []byte
+	slot    int
+	gen     uint32
+	keyID   string
+	wrapped []byte
+}
+
+func encodeSlot(gen uint32, keyID string, wrapped []byte) ([]byte, error) {
+	if len(keyID) > 255 || 4+1+len(keyID)+2+len(wrapped) > slotSize-4 {
+		return nil, fmt.Errorf("wrapped key of %d bytes does not fit the header", len(wrapped))
+	}
+	slot := make([]byte, slotSize)
+	binary.BigEndian.PutUint32(slot, gen)
+	slot[4] = byte(len(keyID))
+	n := 5 + copy(slot[5:], keyID)
+	binary.BigEndian.PutUint16(slot[n:], uint16(len(wrapped)))
+	copy(slot[n+2:], wrapped)
+	binary.BigEndian.PutUint32(slot[slotSize-4:], crc32.ChecksumIEEE(slot[:slotSize-4]))
+	return slot, nil
+}
+
+// decodeSlot reports false for a slot never written, or torn by a crash.
+func decodeSlot(slot []byte) (gen uint32, keyID string, wrapped []byte, ok bool) {
+	if binary.BigEndian.Uint32(slot[slotSize-4:]) != crc32.ChecksumIEEE(slot[:slotSize-4]) {
+		return 0, "", nil, false
+	}
+	gen = binary.BigEndian.Uint32(slot)
+	n := 5 + int(slot[4])
+	if gen == 0 || n+2 > slotSize-4 {
+		return 0, "", nil, false
+	}
+	size := int(binary.BigEndian.Uint16(slot[n:]))
+	if n+2+size > slotSize-4 {
+		return 0, "", nil, false
+	}
+	return gen, string(slot[5:n]), slot[n+2 : n+2+size], true
+}
+
+// IsEncrypted reports whether r starts with the prefix of an encrypted file.
+func IsEncrypted(r io.ReaderAt) bool {
+	prefix := make([]byte, len(magic))
+	n, _ := r.ReadAt(prefix, 0)
+	return n == len(magic) && string(prefix) == magic
+}
+
+func readHeader(r io.ReaderAt) (*header, error) {
+	buf := make([]byte, HeaderSize)
+	if n, err := r.ReadAt(buf, 0); n < len(magic) || string(buf[:len(magic)]) != magic {
+		return nil, ErrNotEncrypted
+	} else if n < HeaderSize {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, cmpErr(err, io.ErrUnexpectedEOF))
+	}
+	if buf[len(magic)] != Version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, buf[len(magic)])
+	}
+
+	h := &header{id: buf[8 : 8+idSize], slot: -1}
+	for i := range 2 {
+		off := prefixSize + i*slotSize
+		gen, keyID, wrapped, ok := decodeSlot(buf[off : off+slotSize])
+		if ok && gen > h.gen {
+			h.slot, h.gen, h.keyID, h.wrapped = i, gen, keyID, wrapped
+		}
+	}
+	if h.slot < 0 {
+		return nil, fmt.Errorf("%w: no valid key slot", ErrCorrupt)
+	}
+	return h, nil
+}
+
+func cmpErr(err, fallback error) error {
+	if err == nil || err == io.EOF {
+		return fallback
+	}
+	return err
+}
+
+// PlainSize returns the size of the content sealed in an encrypted file of
+// size bytes.
+func PlainSize(size int64) (int64, error) {
+	n := size - HeaderSize
+	if n < Overhead {
+		return 0, fmt.Errorf("%w: %d bytes", ErrCorrupt, size)
+	}
+	full, rest := n/(ChunkSize+Overhead), n%(ChunkSize+Overhead)
+	switch {
+	case rest == 0:
+		return full * ChunkSize, nil
+	case rest < Overhead:
+		return 0, fmt.Errorf("%w: %d bytes", ErrCorrupt, size)
+	}
+	return full*ChunkSize + rest - Overhead, nil
+}
+
+type plainInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i plainInfo) Size() int64 {
+	return i.size
+}
+
+// PlainInfo returns info, of an encrypted file, with the size of its content.
+func PlainInfo(info fs.FileInfo) (fs.FileInfo, error) {
+	size, err := PlainSize(info.Size())
+	if err != nil {
+		return nil, err
+	}
+	return plainInfo{FileInfo: info, size: size}, nil
+}
+
+func nonce(index int64) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint64(n, uint64(index))
+	return n
+}
+
+func chunkAAD(id []byte, final bool) []byte {
+	aad := append(bytes.Clone(id), 0)
+	if final {
+		aad[len(id)] = 1
+	}
+	return aad
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt writes src to dst sealed under a new data key, wrapped by the
+// keyring's wrapper.
+func (k *Keyring) Encrypt(ctx context.Context, dst io.Writer, src io.Reader) error {
+	key, id := make([]byte, keySize), make([]byte, idSize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	keyID, wrapped, err := k.w.Wrap(ctx, key)
+	if err != nil {
+		return fmt.Errorf("wrap data key: %w", err)
+	}
+
+	head := make([]byte, HeaderSize)
+	copy(head, magic)
+	head[len(magic)] = Version
+	copy(head[8:], id)
+	slot, err := encodeSlot(1, keyID, wrapped)
+	if err != nil {
+		return err
+	}
+	copy(head[prefixSize:], slot)
+	if _, err = dst.Write(head); err != nil {
+		return err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	// Each chunk is read ahead of sealing the one before, to flag the last.
+	cur, next := make([]byte, ChunkSize), make([]byte, ChunkSize)
+	sealed := make([]byte, 0, ChunkSize+Overhead)
+	n, err := readChunk(src, cur)
+	for index := int64(0); ; index++ {
+		if err != nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		m := 0
+		if n == ChunkSize {
+			if m, err = readChunk(src, next); err != nil {
+				return err
+			}
+		}
+		final := m == 0
+		sealed = aead.Seal(sealed[:0], nonce(index), cur[:n], chunkAAD(id, final))
+		if _, err = dst.Write(sealed); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+		cur, next, n = next, cur, m
+	}
+}
+
+func readChunk(r io.Reader, buf []byte) (int, error) {
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return n, err
+}
+
+// File reads the content of an encrypted file, decrypting the chunks it
+// reads from.
+type File struct {
+	f    *os.File
+	aead cipher.AEAD
+	id   []byte
+	size int64
+	pos  int64
+
+	chunk  int64
+	buf    []byte
+	sealed []byte
+}
+
+// Open unwraps the data key of the encrypted file f, which the returned File
+// takes over. f is left open on error.
+func (k *Keyring) Open(ctx context.Context, f *os.File) (*File, error) {
+	h, err := readHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size, err := PlainSize(info.Size())
+	if err != nil {
+		return nil, err
+	}
+	key, err := k.unwrap(ctx, h.keyID, h.wrapped)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &File{f: f, aead: aead, id: h.id, size: size, chunk: -1}, nil
+}
+
+func (f *File) load(index int64) error {
+	if f.chunk == index {
+		return nil
+	}
+	if f.buf == nil {
+		f.buf = make([]byte, 0, ChunkSize)
+		f.sealed = make([]byte, ChunkSize+Overhead)
+	}
+
+	n := min(f.size-index*ChunkSize, ChunkSize)
+	sealed := f.sealed[:n+Overhead]
+	if _, err := f.f.ReadAt(sealed, HeaderSize+index*(ChunkSize+Overhead)); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, cmpErr(err, io.ErrUnexpectedEOF))
+	}
+	final := index == max(f.size-1, 0)/ChunkSize
+	buf, err := f.aead.Open(f.buf[:0], nonce(index), sealed, chunkAAD(f.id, final))
+	if err != nil {
+		f.chunk = -1
+		return fmt.Errorf("%w: chunk %d: %v", ErrCorrupt, index, err)
+	}
+	f.buf, f.chunk = buf, index
+	return nil
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	if f.pos >= f.size {
+		return 0, io.EOF
+	}
+	index := f.pos / ChunkSize
+	if err := f.load(index); err != nil {
+		return 0, err
+	}
+	n := copy(p, f.buf[f.pos-index*ChunkSize:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before start of file")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+// Stat returns the file's info with the size of its content.
+func (f *File) Stat() (fs.FileInfo, error) {
+	info, err := f.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return plainInfo{FileInfo: info, size: f.size}, nil
+}
+
+func (f *File) Close() error {
+	return f.f.Close()
+}
+
+// Rewrap wraps the data key of the encrypted file f anew, leaving its content
+// as it is. It reports false, writing nothing, when the wrapper returns the
+// key id the file is wrapped with already.
+func (k *Keyring) Rewrap(ctx context.Context, f *os.File) (bool, error) {
+	h, err := readHeader(f)
+	if err != nil {
+		return false, err
+	}
+	key, err := k.unwrap(ctx, h.keyID, h.wrapped)
+	if err != nil {
+		return false, err
+	}
+	keyID, wrapped, err := k.w.Wrap(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("wrap data key: %w", err)
+	}
+	if keyID == h.keyID {
+		return false, nil
+	}
+
+	slot, err := encodeSlot(h.gen+1, keyID, wrapped)
+	if err != nil {
+		return false, err
+	}
+	if _, err = f.WriteAt(slot, int64(prefixSize+(1-h.slot)*slotSize)); err != nil {
+		return false, err
+	}
+	return true, f.Sync()
+}
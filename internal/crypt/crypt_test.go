@@ -0,0 +1,290 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func masterKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, keySize))
+}
+
+func encryptFile(t *testing.T, k *Keyring, data []byte) string {
+	p := filepath.Join(t.TempDir(), "file")
+	f, err := os.Create(p)
+	assert.Nil(t, err)
+	assert.Nil(t, k.Encrypt(context.Background(), f, bytes.NewReader(data)))
+	assert.Nil(t, f.Close())
+	return p
+}
+
+func openFile(t *testing.T, k *Keyring, p string) (*File, error) {
+	f, err := os.Open(p)
+	assert.Nil(t, err)
+	file, err := k.Open(context.Background(), f)
+	if err != nil {
+		f.Close()
+	}
+	return file, err
+}
+
+func TestCrypt(t *testing.T) {
+	keys, err := NewMasterKeys(map[string]string{"k1": masterKey(1)}, "k1")
+	assert.Nil(t, err)
+	k := NewKeyring(keys)
+
+	data := make([]byte, 3*ChunkSize-5)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	t.Run(
+		"Round trip", func(t *testing.T) {
+			for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 2 * ChunkSize, len(data)} {
+				p := encryptFile(t, k, data[:size])
+
+				raw, err := os.ReadFile(p)
+				assert.Nil(t, err)
+				if size > 16 {
+					assert.False(t, bytes.Contains(raw, data[:16]))
+				}
+				plain, err := PlainSize(int64(len(raw)))
+				assert.Nil(t, err)
+				assert.Equal(t, int64(size), plain)
+
+				f, err := openFile(t, k, p)
+				if !assert.Nil(t, err) {
+					continue
+				}
+				got, err := io.ReadAll(f)
+				assert.Nil(t, err)
+				assert.Equal(t, data[:size], got, "size %d", size)
+
+				info, err := f.Stat()
+				assert.Nil(t, err)
+				assert.Equal(t, int64(size), info.Size())
+				assert.Nil(t, f.Close())
+			}
+		},
+	)
+
+	t.Run(
+		"Seeks", func(t *testing.T) {
+			f, err := openFile(t, k, encryptFile(t, k, data))
+			assert.Nil(t, err)
+			defer f.Close()
+
+			for _, off := range []int64{ChunkSize + 10, 5, 2*ChunkSize - 3, int64(len(data)) - 4} {
+				_, err = f.Seek(off, io.SeekStart)
+				assert.Nil(t, err)
+				buf := make([]byte, 8)
+				n, _ := io.ReadFull(f, buf)
+				assert.Equal(t, data[off:off+int64(n)], buf[:n])
+			}
+
+			end, err := f.Seek(0, io.SeekEnd)
+			assert.Nil(t, err)
+			assert.Equal(t, int64(len(data)), end)
+			_, err = f.Read(make([]byte, 1))
+			assert.Equal(t, io.EOF, err)
+		},
+	)
+
+	t.Run(
+		"Tampering is detected", func(t *testing.T) {
+			p := encryptFile(t, k, data)
+			raw, _ := os.ReadFile(p)
+			raw[HeaderSize+ChunkSize+Overhead+3] ^= 1
+			assert.Nil(t, os.WriteFile(p, raw, 0644))
+
+			f, err := openFile(t, k, p)
+			assert.Nil(t, err)
+			defer f.Close()
+			assert.Equal(t, data[:10], readN(t, f, 10))
+			_, err = f.Seek(ChunkSize, io.SeekStart)
+			assert.Nil(t, err)
+			_, err = f.Read(make([]byte, 10))
+			assert.True(t, errors.Is(err, ErrCorrupt), err)
+		},
+	)
+
+	t.Run(
+		"Truncation is detected", func(t *testing.T) {
+			p := encryptFile(t, k, data)
+			assert.Nil(t, os.Truncate(p, HeaderSize+2*(ChunkSize+Overhead)))
+
+			f, err := openFile(t, k, p)
+			assert.Nil(t, err)
+			defer f.Close()
+			_, err = io.ReadAll(f)
+			assert.True(t, errors.Is(err, ErrCorrupt), err)
+		},
+	)
+
+	t.Run(
+		"Plain files", func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "plain")
+			assert.Nil(t, os.WriteFile(p, []byte("hello"), 0644))
+			f, _ := os.Open(p)
+			defer f.Close()
+			assert.False(t, IsEncrypted(f))
+			_, err := k.Open(context.Background(), f)
+			assert.True(t, errors.Is(err, ErrNotEncrypted), err)
+		},
+	)
+
+	t.Run(
+		"Unknown keys", func(t *testing.T) {
+			other, err := NewMasterKeys(map[string]string{"k9": masterKey(9)}, "k9")
+			assert.Nil(t, err)
+			_, err = openFile(t, NewKeyring(other), encryptFile(t, k, data[:10]))
+			assert.True(t, errors.Is(err, ErrUnknownKey), err)
+
+			_, err = NewMasterKeys(map[string]string{"k1": masterKey(1)}, "k2")
+			assert.True(t, errors.Is(err, ErrUnknownKey), err)
+			_, err = NewMasterKeys(map[string]string{"k1": "c2hvcnQ="}, "k1")
+			assert.NotNil(t, err)
+		},
+	)
+}
+
+func readN(t *testing.T, r io.Reader, n int) []byte {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r, buf)
+	assert.Nil(t, err)
+	return buf
+}
+
+func TestRewrap(t *testing.T) {
+	old, err := NewMasterKeys(map[string]string{"k1": masterKey(1)}, "k1")
+	assert.Nil(t, err)
+	both, err := NewMasterKeys(map[string]string{"k1": masterKey(1), "k2": masterKey(2)}, "k2")
+	assert.Nil(t, err)
+	rotated, err := NewMasterKeys(map[string]string{"k2": masterKey(2)}, "k2")
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	data := bytes.Repeat([]byte("media"), ChunkSize/2)
+	p := encryptFile(t, NewKeyring(old), data)
+	before, _ := os.ReadFile(p)
+
+	rewrap := func(k *Keyring) (bool, error) {
+		f, err := os.OpenFile(p, os.O_RDWR, 0)
+		assert.Nil(t, err)
+		defer f.Close()
+		return k.Rewrap(ctx, f)
+	}
+	read := func(k *Keyring) ([]byte, error) {
+		f, err := openFile(t, k, p)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+
+	t.Run(
+		"Content is left as it is", func(t *testing.T) {
+			ok, err := rewrap(NewKeyring(both))
+			assert.Nil(t, err)
+			assert.True(t, ok)
+
+			after, _ := os.ReadFile(p)
+			assert.Equal(t, before[HeaderSize:], after[HeaderSize:])
+			assert.NotEqual(t, before[:HeaderSize], after[:HeaderSize])
+
+			got, err := read(NewKeyring(rotated))
+			assert.Nil(t, err)
+			assert.Equal(t, data, got)
+			_, err = read(NewKeyring(old))
+			assert.True(t, errors.Is(err, ErrUnknownKey), err)
+		},
+	)
+
+	t.Run(
+		"Files on the active key are skipped", func(t *testing.T) {
+			ok, err := rewrap(NewKeyring(rotated))
+			assert.Nil(t, err)
+			assert.False(t, ok)
+		},
+	)
+
+	t.Run(
+		"Torn slots fall back", func(t *testing.T) {
+			ok, err := rewrap(NewKeyring(both))
+			assert.Nil(t, err)
+			assert.False(t, ok)
+
+			// Rewrap back to k1 and tear the slot it wrote, as a crash would.
+			back, err := NewMasterKeys(map[string]string{"k1": masterKey(1), "k2": masterKey(2)}, "k1")
+			assert.Nil(t, err)
+			ok, err = rewrap(NewKeyring(back))
+			assert.Nil(t, err)
+			assert.True(t, ok)
+
+			raw, _ := os.ReadFile(p)
+			raw[prefixSize+10] ^= 0xff
+			assert.Nil(t, os.WriteFile(p, raw, 0644))
+
+			got, err := read(NewKeyring(rotated))
+			assert.Nil(t, err)
+			assert.Equal(t, data, got)
+		},
+	)
+}
+
+func TestCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell fake")
+	}
+
+	// A KMS that "wraps" by passing the key through, under key id kms-1.
+	script := filepath.Join(t.TempDir(), "kms.sh")
+	assert.Nil(
+		t, os.WriteFile(
+			script, []byte(`#!/bin/sh
+read v
+case "$1" in
+wrap) echo "kms-1 $v" ;;
+unwrap) [ "$2" = "kms-1" ] || { echo "no key $2" >&2; exit 1; }; echo "$v" ;;
+esac
+`), 0755,
+		),
+	)
+
+	k := NewKeyring(NewCommand([]string{"/bin/sh", script}))
+	data := []byte("sealed by an external kms")
+	p := encryptFile(t, k, data)
+
+	f, err := openFile(t, k, p)
+	assert.Nil(t, err)
+	got, err := io.ReadAll(f)
+	f.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, data, got)
+
+	h, err := readHeaderAt(p)
+	assert.Nil(t, err)
+	assert.Equal(t, "kms-1", h.keyID)
+
+	failing := NewKeyring(NewCommand([]string{"/bin/sh", "-c", "exit 3", "kms"}))
+	_, err = openFile(t, failing, p)
+	assert.NotNil(t, err)
+}
+
+func readHeaderAt(p string) (*header, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readHeader(f)
+}
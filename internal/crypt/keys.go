@@ -0,0 +1,192 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/pkg/config"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	commandTimeout = 30 * time.Second
+	maxCachedKeys  = 4096
+)
+
+// Wrapper wraps data keys under a master key, returning the id of the key
+// used so the data key can be unwrapped after the active one changes.
+type Wrapper interface {
+	Wrap(ctx context.Context, key []byte) (keyID string, wrapped []byte, err error)
+	Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// MasterKeys wraps data keys with AES-GCM under master keys held in memory.
+type MasterKeys struct {
+	keys   map[string][]byte
+	active string
+}
+
+func NewMasterKeys(keys map[string]string, active string) (*MasterKeys, error) {
+	m := &MasterKeys{keys: make(map[string][]byte, len(keys)), active: active}
+	for id, v := range keys {
+		key, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("master key %s: %w", id, err)
+		}
+		if len(key) != keySize {
+			return nil, fmt.Errorf("master key %s: %d bytes, want %d", id, len(key), keySize)
+		}
+		m.keys[id] = key
+	}
+	if _, ok := m.keys[active]; !ok {
+		return nil, fmt.Errorf("%w: active key %q", ErrUnknownKey, active)
+	}
+	return m, nil
+}
+
+func (m *MasterKeys) Wrap(_ context.Context, key []byte) (string, []byte, error) {
+	aead, err := newAEAD(m.keys[m.active])
+	if err != nil {
+		return "", nil, err
+	}
+	n := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(n); err != nil {
+		return "", nil, err
+	}
+	return m.active, aead.Seal(n, n, key, []byte(m.active)), nil
+}
+
+func (m *MasterKeys) Unwrap(_ context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	master, ok := m.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: short wrapped key", ErrCorrupt)
+	}
+	n := aead.NonceSize()
+	key, err := aead.Open(nil, wrapped[:n], wrapped[n:], []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("%w: unwrap with %s: %v", ErrCorrupt, keyID, err)
+	}
+	return key, nil
+}
+
+// Command wraps data keys by running an external KMS client. It is run as
+// "<command> wrap" with the base64 data key on stdin, and prints the key id
+// and the base64 wrapped key separated by a space; and as "<command> unwrap
+// <keyID>" with the base64 wrapped key on stdin, printing the base64 data
+// key.
+type Command struct {
+	args []string
+}
+
+func NewCommand(args []string) *Command {
+	return &Command{args: args}
+}
+
+func (c *Command) run(ctx context.Context, stdin string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, c.args[0], append(c.args[1:len(c.args):len(c.args)], args...)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = strings.NewReader(stdin+"\n"), stdout, stderr
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("kms %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func (c *Command) Wrap(ctx context.Context, key []byte) (string, []byte, error) {
+	out, err := c.run(ctx, base64.StdEncoding.EncodeToString(key), "wrap")
+	if err != nil {
+		return "", nil, err
+	}
+	keyID, v, ok := strings.Cut(out, " ")
+	if !ok || keyID == "" {
+		return "", nil, fmt.Errorf("kms wrap: unexpected output %q", out)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+	if err != nil {
+		return "", nil, fmt.Errorf("kms wrap: %w", err)
+	}
+	return keyID, wrapped, nil
+}
+
+func (c *Command) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	out, err := c.run(ctx, base64.StdEncoding.EncodeToString(wrapped), "unwrap", keyID)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(out)
+	if err != nil {
+		return nil, fmt.Errorf("kms unwrap: %w", err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("kms unwrap: %d byte key, want %d", len(key), keySize)
+	}
+	return key, nil
+}
+
+// Keyring encrypts and decrypts files with data keys wrapped by its Wrapper,
+// keeping the keys it unwraps so reading a file again does not reach the
+// KMS.
+type Keyring struct {
+	w Wrapper
+
+	mu    sync.Mutex
+	cache map[string][]byte
+}
+
+func NewKeyring(w Wrapper) *Keyring {
+	return &Keyring{w: w, cache: make(map[string][]byte)}
+}
+
+// New builds the keyring cfg describes.
+func New(cfg *config.EncryptionConfig) (*Keyring, error) {
+	if len(cfg.KMS) > 0 {
+		return NewKeyring(NewCommand(cfg.KMS)), nil
+	}
+	if len(cfg.Keys) == 0 {
+		return nil, errors.New("encryption needs keys or a kms command")
+	}
+	keys, err := NewMasterKeys(cfg.Keys, cfg.ActiveKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyring(keys), nil
+}
+
+func (k *Keyring) unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	id := keyID + "\x00" + string(wrapped)
+	k.mu.Lock()
+	key, ok := k.cache[id]
+	k.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	key, err := k.w.Unwrap(ctx, keyID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	if len(k.cache) >= maxCachedKeys {
+		clear(k.cache)
+	}
+	k.cache[id] = key
+	k.mu.Unlock()
+	return key, nil
+}
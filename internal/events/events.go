@@ -0,0 +1,211 @@
+// Package events publishes file events to a message broker.
+//
+// Every file event (file.created, file.updated, file.deleted,
+// file.transitioned) is serialized as a single JSON message:
+//
+//	{
+//	  "id":   "3f2a9c0e8b1d4e6f9a7c5b3d1e0f2a4c",
+//	  "type": "file.created",
+//	  "name": "photos/cat.jpg",
+//	  "time": "2024-06-10T12:00:00Z",
+//	  "data": {"size": 1024, "checksum": "..."}
+//	}
+//
+// The file name is used as the message key (Kafka) so events for a single
+// file stay ordered within a partition. On NATS the message is published to
+// the configured subject as is.
+//
+// Events are written to an on-disk outbox before being published and are
+// removed only after the broker acknowledged them, so broker downtime or a
+// restart does not lose events. Delivery is at-least-once: consumers must
+// deduplicate by id.
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/internal/metrics"
+	"github.com/JMURv/media-server/internal/queue"
+	"github.com/JMURv/media-server/pkg/config"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	TypeNATS  = "nats"
+	TypeKafka = "kafka"
+)
+
+const (
+	initialBackoff    = time.Second
+	defaultMaxBackoff = time.Minute
+)
+
+var ErrUnsupportedType = errors.New("unsupported event bus type")
+var ErrTopicRequired = errors.New("event bus topic is required")
+
+type Event struct {
+	ID   string    `json:"id"`
+	Type string    `json:"type"`
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, key string, msg []byte) error
+	Health() error
+	Close() error
+}
+
+type Bus struct {
+	pub        Publisher
+	queue      *queue.Queue
+	maxBackoff time.Duration
+
+	mu        sync.Mutex
+	lastError error
+
+	published *metrics.Counter
+	errors    *metrics.Counter
+}
+
+func New(cfg *config.EventsConfig, dir string, reg *metrics.Registry) (*Bus, error) {
+	if cfg.Topic == "" {
+		return nil, ErrTopicRequired
+	}
+
+	var pub Publisher
+	var err error
+	switch cfg.Type {
+	case TypeNATS:
+		pub, err = NewNATS(cfg.URL, cfg.Topic)
+	case TypeKafka:
+		pub = NewKafka(cfg.Brokers, cfg.Topic)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedType, cfg.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewWithPublisher(pub, cfg.MaxBackoff, dir, reg)
+}
+
+func NewWithPublisher(pub Publisher, maxBackoff time.Duration, dir string, reg *metrics.Registry) (*Bus, error) {
+	q, err := queue.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+
+	b := &Bus{
+		pub:        pub,
+		queue:      q,
+		maxBackoff: maxBackoff,
+		published:  reg.Counter("events_published_total", "Events acknowledged by the broker."),
+		errors:     reg.Counter("events_errors_total", "Failed event publish attempts."),
+	}
+	reg.GaugeFunc("events_pending", "Events waiting in the outbox.", func() float64 { return float64(q.Len()) })
+	reg.GaugeFunc(
+		"events_broker_connected", "Whether the event broker is reachable.", func() float64 {
+			if b.Health() != nil {
+				return 0
+			}
+			return 1
+		},
+	)
+	return b, nil
+}
+
+func (b *Bus) Publish(typ, name string, data any) {
+	ev := &Event{ID: newID(), Type: typ, Name: name, Time: time.Now().UTC(), Data: data}
+	if _, err := b.queue.Push(ev); err != nil {
+		log.Printf("Error persisting event %s for %s: %s\n", typ, name, err)
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (b *Bus) Pending() int {
+	return b.queue.Len()
+}
+
+func (b *Bus) Health() error {
+	if err := b.pub.Health(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lastError
+}
+
+func (b *Bus) Close() error {
+	return b.pub.Close()
+}
+
+func (b *Bus) Run(ctx context.Context) {
+	backoff := initialBackoff
+	for {
+		ev := &Event{}
+		seq, err := b.queue.Peek(ev)
+		if err != nil {
+			if !errors.Is(err, queue.ErrEmpty) {
+				log.Printf("Error reading event outbox: %s\n", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-b.queue.Notify():
+			case <-time.After(b.maxBackoff):
+			}
+			continue
+		}
+
+		msg, err := json.Marshal(ev)
+		if err == nil {
+			err = b.pub.Publish(ctx, ev.Name, msg)
+		}
+
+		b.mu.Lock()
+		b.lastError = err
+		b.mu.Unlock()
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			b.errors.Inc()
+			log.Printf("Error publishing event %s for %s: %s\n", ev.Type, ev.Name, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, b.maxBackoff)
+			continue
+		}
+
+		b.published.Inc()
+		backoff = initialBackoff
+		if err = b.queue.Pop(seq); err != nil {
+			log.Printf("Error removing event %d from outbox: %s\n", seq, err)
+		}
+	}
+}
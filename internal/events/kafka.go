@@ -0,0 +1,45 @@
+package events
+
+import (
+	"context"
+	"github.com/segmentio/kafka-go"
+	"sync"
+)
+
+type Kafka struct {
+	writer *kafka.Writer
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+func NewKafka(brokers []string, topic string) *Kafka {
+	return &Kafka{
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(brokers...),
+			Topic:                  topic,
+			Balancer:               &kafka.Hash{},
+			RequiredAcks:           kafka.RequireAll,
+			AllowAutoTopicCreation: true,
+		},
+	}
+}
+
+func (k *Kafka) Publish(ctx context.Context, key string, msg []byte) error {
+	err := k.writer.WriteMessages(ctx, kafka.Message{Key: []byte(key), Value: msg})
+
+	k.mu.Lock()
+	k.lastErr = err
+	k.mu.Unlock()
+	return err
+}
+
+func (k *Kafka) Health() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.lastErr
+}
+
+func (k *Kafka) Close() error {
+	return k.writer.Close()
+}
@@ -0,0 +1,59 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"github.com/nats-io/nats.go"
+	"time"
+)
+
+var ErrNotConnected = errors.New("not connected to broker")
+
+type NATS struct {
+	conn    *nats.Conn
+	subject string
+}
+
+func NewNATS(url, subject string) (*NATS, error) {
+	if url == "" {
+		url = nats.DefaultURL
+	}
+
+	conn, err := nats.Connect(
+		url,
+		nats.Name("media-server"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &NATS{conn: conn, subject: subject}, nil
+}
+
+func (n *NATS) Publish(ctx context.Context, _ string, msg []byte) error {
+	if err := n.Health(); err != nil {
+		return err
+	}
+	if err := n.conn.Publish(n.subject, msg); err != nil {
+		return err
+	}
+
+	timeout := 5 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	return n.conn.FlushTimeout(timeout)
+}
+
+func (n *NATS) Health() error {
+	if !n.conn.IsConnected() {
+		return ErrNotConnected
+	}
+	return nil
+}
+
+func (n *NATS) Close() error {
+	n.conn.Close()
+	return nil
+}
@@ -0,0 +1,538 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/JMURv/media-server/internal/jobs"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/webhook"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TaskAdopt = "adopt"
+	adoptDir  = ".adopt"
+
+	AdoptLink = "link"
+	AdoptMove = "move"
+
+	AdoptAdopted = "adopted"
+)
+
+// Adoption is a POST /admin/adopt run. Its job is named after its ID, and
+// each entry's result is appended to a log next to it as it is processed,
+// so a run interrupted by a restart resumes where it stopped.
+type Adoption struct {
+	ID         string     `json:"id"`
+	Source     string     `json:"source"`
+	Mode       string     `json:"mode"`
+	Conflict   string     `json:"conflict"`
+	State      string     `json:"state"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type AdoptResult struct {
+	Source string `json:"source"`
+	Name   string `json:"name,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type AdoptReport struct {
+	Adoption
+	Job         string        `json:"job,omitempty"`
+	Progress    float64       `json:"progress"`
+	Adopted     int           `json:"adopted"`
+	Overwritten int           `json:"overwritten"`
+	Skipped     int           `json:"skipped"`
+	Failed      int           `json:"failed"`
+	Results     []AdoptResult `json:"results"`
+}
+
+type adoptRequest struct {
+	Source   string `json:"source"`
+	Mode     string `json:"mode"`
+	Conflict string `json:"conflict"`
+}
+
+func (h *Handler) adoptionPath(id string) string {
+	return filepath.Join(h.savePath, adoptDir, id+".json")
+}
+
+func (h *Handler) adoptionLog(id string) string {
+	return filepath.Join(h.savePath, adoptDir, id+".log")
+}
+
+func (h *Handler) saveAdoption(a *Adoption) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(h.adoptionPath(a.ID), data)
+}
+
+func (h *Handler) loadAdoption(id string) (*Adoption, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return nil, ErrAdoptionNotFound
+	}
+	data, err := os.ReadFile(h.adoptionPath(id))
+	if err != nil {
+		return nil, ErrAdoptionNotFound
+	}
+
+	a := &Adoption{}
+	if err = json.Unmarshal(data, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// adoptResults reads the results logged so far. A line cut short by a crash
+// is dropped, and its entry processed again.
+func (h *Handler) adoptResults(id string) ([]AdoptResult, error) {
+	file, err := os.Open(h.adoptionLog(id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var res []AdoptResult
+	sc := bufio.NewScanner(file)
+	for sc.Scan() {
+		r := AdoptResult{}
+		if json.Unmarshal(sc.Bytes(), &r) == nil {
+			res = append(res, r)
+		}
+	}
+	return res, sc.Err()
+}
+
+// adoptSource resolves source and checks it lies within an allowed source
+// and does not overlap any storage root.
+func (h *Handler) adoptSource(source string) (string, error) {
+	if source == "" || !filepath.IsAbs(source) {
+		return "", ErrSourceNotAllowed
+	}
+	resolved, err := resolvePath(source)
+	if err != nil {
+		return "", ErrSourceNotAllowed
+	}
+	if info, err := os.Stat(resolved); err != nil || !info.IsDir() {
+		return "", ErrSourceNotAllowed
+	}
+	for _, root := range h.rootDirs() {
+		if within(resolved, root) || within(root, resolved) {
+			return "", ErrSourceNotAllowed
+		}
+	}
+
+	for _, allowed := range h.config.Adopt.AllowedSources {
+		if root, err := resolvePath(allowed); err == nil && within(resolved, root) {
+			return resolved, nil
+		}
+	}
+	return "", ErrSourceNotAllowed
+}
+
+func (h *Handler) adopt(w http.ResponseWriter, r *http.Request) {
+	if h.config.Adopt == nil {
+		writeError(w, ErrAdoptDisabled)
+		return
+	}
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+	// Adopted files are put in place as they are, which would leave them in
+	// the clear.
+	if h.crypt != nil {
+		writeError(w, ErrEncryptionUnsupported)
+		return
+	}
+
+	req := adoptRequest{}
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+
+	switch req.Mode {
+	case "":
+		req.Mode = AdoptLink
+	case AdoptLink, AdoptMove:
+	default:
+		writeError(w, ErrInvalidAdoptMode)
+		return
+	}
+
+	if req.Conflict == "" {
+		req.Conflict = h.config.ImportConflict
+	}
+	switch req.Conflict {
+	case "":
+		req.Conflict = ConflictSkip
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+	default:
+		writeError(w, ErrInvalidConflictPolicy)
+		return
+	}
+
+	source, err := h.adoptSource(req.Source)
+	if err != nil {
+		writeError(w, withParams(err, map[string]any{"source": req.Source}))
+		return
+	}
+
+	a := &Adoption{
+		ID:        newUUID(),
+		Source:    source,
+		Mode:      req.Mode,
+		Conflict:  req.Conflict,
+		State:     jobs.StatePending,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err = h.saveAdoption(a); err != nil {
+		log.Printf("Error saving adoption %s: %s\n", a.ID, err)
+		writeError(w, ErrInternal)
+		return
+	}
+
+	job, err := h.jobs.Enqueue(a.ID, []string{TaskAdopt})
+	if err != nil {
+		os.Remove(h.adoptionPath(a.ID))
+		if errors.Is(err, jobs.ErrQueueFull) {
+			w.Header().Set("Retry-After", processingRetryAfter)
+			writeError(w, err)
+			return
+		}
+		writeError(w, ErrInternal)
+		return
+	}
+
+	h.emit("admin.adopt", "", r, map[string]any{"id": a.ID, "source": source, "mode": a.Mode, "conflict": a.Conflict})
+	log.Printf("Adopting files from %s (%s), job %s\n", source, a.Mode, job.ID)
+	utils.JSONResponse(w, http.StatusAccepted, &AdoptReport{Adoption: *a, Job: job.ID, Results: []AdoptResult{}})
+}
+
+func (h *Handler) adoptionReport(w http.ResponseWriter, r *http.Request) {
+	if h.config.Adopt == nil {
+		writeError(w, ErrAdoptDisabled)
+		return
+	}
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+
+	a, err := h.loadAdoption(r.PathValue("id"))
+	if err != nil {
+		writeError(w, ErrAdoptionNotFound)
+		return
+	}
+	results, err := h.adoptResults(a.ID)
+	if err != nil {
+		log.Printf("Error reading adoption log %s: %s\n", a.ID, err)
+		writeError(w, ErrInternal)
+		return
+	}
+
+	res := &AdoptReport{Adoption: *a, Results: make([]AdoptResult, 0, len(results))}
+	if a.FinishedAt != nil {
+		res.Progress = 1
+	}
+	if list := h.jobs.List(a.ID); len(list) > 0 {
+		job := list[len(list)-1]
+		res.Job, res.Progress = job.ID, job.Progress
+		if a.FinishedAt == nil {
+			res.State = job.State
+		}
+	}
+	for _, e := range results {
+		switch e.Status {
+		case AdoptAdopted:
+			res.Adopted++
+		case ImportOverwritten:
+			res.Overwritten++
+		case ImportSkipped:
+			res.Skipped++
+		case ImportFailed:
+			res.Failed++
+		}
+		res.Results = append(res.Results, e)
+	}
+	utils.JSONResponse(w, http.StatusOK, res)
+}
+
+// adoptTask walks the adoption's source and adopts every regular file not
+// already in its log. Entries are logged as they complete, so a run stopped
+// part way resumes with the rest.
+func (h *Handler) adoptTask(ctx context.Context, id string) error {
+	a, err := h.loadAdoption(id)
+	if err != nil {
+		return err
+	}
+	results, err := h.adoptResults(id)
+	if err != nil {
+		return err
+	}
+	done := make(map[string]bool, len(results))
+	for _, e := range results {
+		done[e.Source] = true
+	}
+
+	var pending []string
+	total := 0
+	err = filepath.WalkDir(
+		a.Source, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return ctx.Err()
+			}
+
+			rel, err := filepath.Rel(a.Source, p)
+			if err != nil {
+				return err
+			}
+			total++
+			if rel = filepath.ToSlash(rel); !done[rel] {
+				pending = append(pending, rel)
+			}
+			return ctx.Err()
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if a.State != jobs.StateRunning {
+		a.State = jobs.StateRunning
+		if err = h.saveAdoption(a); err != nil {
+			return err
+		}
+	}
+
+	out, err := os.OpenFile(h.adoptionLog(id), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	enc := json.NewEncoder(out)
+	for i, rel := range pending {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		res := h.adoptEntry(ctx, a, rel)
+		if err = enc.Encode(res); err != nil {
+			return err
+		}
+		jobs.ReportProgress(ctx, float64(total-len(pending)+i+1)/float64(max(total, 1)))
+
+		if a.Conflict == ConflictFail && res.Error == ErrAlreadyExists.Error() {
+			return ErrAlreadyExists
+		}
+	}
+	return nil
+}
+
+// adoptEntry moves or links the file at rel below the adoption's source into
+// the store, under the name an upload of it would get, and catalogs it.
+func (h *Handler) adoptEntry(ctx context.Context, a *Adoption, rel string) AdoptResult {
+	res := AdoptResult{Source: rel}
+	fail := func(err error) AdoptResult {
+		res.Status, res.Error = ImportFailed, err.Error()
+		return res
+	}
+	skip := func(err error) AdoptResult {
+		res.Status, res.Error = ImportSkipped, err.Error()
+		return res
+	}
+
+	src := filepath.Join(a.Source, filepath.FromSlash(rel))
+	info, err := os.Lstat(src)
+	if err != nil {
+		return fail(err)
+	}
+	if !info.Mode().IsRegular() {
+		return skip(ErrNotRegularFile)
+	}
+
+	name, err := h.cleanName(rel)
+	if err != nil {
+		return fail(err)
+	}
+	if h.hidden(rel) || h.hidden(name) {
+		return skip(ErrInternalPath)
+	}
+	if limit := h.maxFileSize(); limit > 0 && info.Size() > limit {
+		return fail(ErrFileTooBig)
+	}
+	if existing, ok := h.resolveName(name); ok {
+		name = existing
+	}
+	res.Name = name
+
+	dst := h.filePath(name)
+	unlock := h.fileMu.Lock(name)
+	defer unlock()
+
+	current, err := os.Stat(dst)
+	exists := err == nil
+	if exists && os.SameFile(info, current) {
+		// Linked by a run interrupted before it logged the entry.
+		if _, err = h.meta.Get(name); err == nil {
+			res.Status = AdoptAdopted
+			return res
+		}
+		exists = false
+	} else if exists {
+		if m, err := h.meta.Get(name); err == nil && m.Lock.Active() {
+			return fail(ErrLocked)
+		}
+		if h.activeHold(name) != nil {
+			return fail(ErrHeld)
+		}
+
+		switch a.Conflict {
+		case ConflictSkip:
+			return skip(ErrAlreadyExists)
+		case ConflictFail:
+			return fail(ErrAlreadyExists)
+		}
+	}
+
+	checksum, err := fileChecksum(ctx, src)
+	if err != nil {
+		return fail(err)
+	}
+
+	var rep *replacement
+	if exists {
+		prev, err := h.meta.Get(name)
+		if err != nil {
+			prev = nil
+		}
+		if rep, err = h.setAside(name, dst, prev, current); err != nil {
+			return fail(err)
+		}
+	}
+
+	undo, err := h.placeAdopted(a.Mode, src, dst)
+	if err != nil {
+		if rep != nil {
+			rep.discard()
+		}
+		return fail(err)
+	}
+	if rep != nil {
+		place := undo
+		undo = func() {
+			place()
+			h.restore(rep)
+		}
+	}
+
+	now := time.Now().UTC()
+	m := &meta.File{
+		Name:         name,
+		OriginalName: path.Base(rel),
+		Size:         info.Size(),
+		Checksum:     checksum,
+		CreatedAt:    info.ModTime().UTC(),
+		UpdatedAt:    now,
+	}
+	if exists {
+		m.ID = h.overwriteID(name)
+	}
+	if err = h.meta.Put(m); err != nil {
+		undo()
+		return fail(err)
+	}
+
+	if err = h.runUploaded(ctx, fileInfo(name, m)); err != nil {
+		log.Printf("Adoption of %s rejected by hook: %s\n", name, err)
+		undo()
+		if rep == nil {
+			if err := h.meta.Delete(name); err != nil {
+				log.Printf("Error removing metadata for %s: %s\n", name, err)
+			}
+		}
+		h.invalidateFile(name)
+		return fail(classify(err, ErrUploadRejected))
+	}
+	if rep != nil {
+		h.keep(rep, strconv.Quote(checksum))
+	}
+
+	res.Status = AdoptAdopted
+	typ := webhook.FileCreated
+	if exists {
+		res.Status, typ = ImportOverwritten, webhook.FileUpdated
+	}
+	h.emit(typ, name, nil, map[string]any{"size": m.Size, "checksum": checksum, "source": "adopt"})
+	return res
+}
+
+// placeAdopted puts src at dst without copying it and returns a func that
+// takes it back out.
+func (h *Handler) placeAdopted(mode, src, dst string) (func(), error) {
+	if mode == AdoptMove {
+		if err := os.Rename(src, dst); err != nil {
+			return nil, err
+		}
+		return func() {
+			if err := os.Rename(dst, src); err != nil {
+				log.Printf("Error returning %s to %s: %s\n", dst, src, err)
+			}
+		}, nil
+	}
+
+	tmp := filepath.Join(filepath.Dir(dst), ".adopt-"+newUUID())
+	if err := os.Link(src, tmp); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return func() {
+		if err := os.Remove(dst); err != nil {
+			log.Printf("Error removing adopted %s: %s\n", dst, err)
+		}
+	}, nil
+}
+
+// finishAdoption records the outcome of an adoption's job.
+func (h *Handler) finishAdoption(job *jobs.Job) {
+	a, err := h.loadAdoption(job.Name)
+	if err != nil {
+		log.Printf("Error loading adoption %s: %s\n", job.Name, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	a.State, a.Error, a.FinishedAt = job.State, job.Error, &now
+	if err = h.saveAdoption(a); err != nil {
+		log.Printf("Error saving adoption %s: %s\n", a.ID, err)
+	}
+	h.emit("admin.adopt.done", "", nil, map[string]any{"id": a.ID, "state": a.State})
+	log.Printf("Adoption %s from %s %s\n", a.ID, a.Source, a.State)
+}
@@ -0,0 +1,194 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/jobs"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAdopt(t *testing.T) {
+	testDir := t.TempDir()
+
+	// Sources sit next to the save path, on the same filesystem, so they can
+	// be hard-linked.
+	sources, err := filepath.Abs("./test_adopt")
+	assert.Nil(t, err)
+	defer os.RemoveAll(sources)
+
+	write := func(path, content string) {
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.Nil(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	write(filepath.Join(sources, "resume", "x1.mp4"), "one")
+	write(filepath.Join(sources, "resume", "x2.mp4"), "two")
+	write(filepath.Join(sources, "resume", "x3.mp4"), "three")
+	write(filepath.Join(sources, "library", "a.mp4"), "alpha")
+	write(filepath.Join(sources, "library", "nested", "b.mp4"), "bravo")
+	write(filepath.Join(sources, "library", ".DS_Store"), "junk")
+	write(filepath.Join(sources, "library", "taken.mp4"), "library copy")
+	write(filepath.Join(sources, "inbox", "taken.mp4"), "inbox copy")
+	write(filepath.Join(testDir, "taken.mp4"), "stored copy")
+	outside := t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			DefaultPage: 1,
+			DefaultSize: 10,
+			AdminToken:  "admin-secret",
+			Jobs:        &config.JobsConfig{Workers: 1},
+			Adopt:       &config.AdoptConfig{AllowedSources: []string{sources}},
+		},
+	)
+
+	do := func(method, target string, body any, admin bool) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, target, bytes.NewReader(data))
+		if admin {
+			req.Header.Set("Authorization", "Bearer admin-secret")
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	start := func(body map[string]string) AdoptReport {
+		rec := do(http.MethodPost, "/admin/adopt", body, true)
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+
+		res := AdoptReport{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		assert.NotEmpty(t, res.Job)
+		return res
+	}
+	report := func(id string) AdoptReport {
+		rec := do(http.MethodGet, "/admin/adopt/"+id, nil, true)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		res := AdoptReport{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res
+	}
+	finished := func(id string) AdoptReport {
+		var res AdoptReport
+		assert.Eventually(
+			t, func() bool {
+				res = report(id)
+				return res.FinishedAt != nil
+			}, 5*time.Second, 10*time.Millisecond,
+		)
+		return res
+	}
+	errorCode := func(rec *httptest.ResponseRecorder) string {
+		res := utils.ErrorResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res.Code
+	}
+
+	t.Run(
+		"Requests are validated", func(t *testing.T) {
+			rec := do(http.MethodPost, "/admin/adopt", map[string]string{"source": sources}, false)
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+
+			for _, source := range []string{outside, "relative/path", filepath.Join(sources, "missing")} {
+				rec = do(http.MethodPost, "/admin/adopt", map[string]string{"source": source}, true)
+				assert.Equal(t, http.StatusForbidden, rec.Code)
+				assert.Equal(t, "source_not_allowed", errorCode(rec))
+			}
+
+			rec = do(http.MethodPost, "/admin/adopt", map[string]string{"source": sources, "mode": "copy"}, true)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Equal(t, "invalid_adopt_mode", errorCode(rec))
+
+			rec = do(http.MethodGet, "/admin/adopt/missing", nil, true)
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Interrupted adoptions resume", func(t *testing.T) {
+			started := start(map[string]string{"source": filepath.Join(sources, "resume")})
+			a, err := hdl.loadAdoption(started.ID)
+			assert.Nil(t, err)
+
+			// A run that logged x1, then stopped after linking x2.
+			data, _ := json.Marshal(hdl.adoptEntry(context.Background(), a, "x1.mp4"))
+			assert.Nil(t, os.WriteFile(hdl.adoptionLog(a.ID), append(data, '\n'), 0644))
+			assert.Equal(t, AdoptAdopted, hdl.adoptEntry(context.Background(), a, "x2.mp4").Status)
+
+			go hdl.jobs.Run(ctx)
+
+			res := finished(a.ID)
+			assert.Equal(t, jobs.StateDone, res.State)
+			assert.Equal(t, 1.0, res.Progress)
+			assert.Equal(t, 3, res.Adopted)
+			assert.Len(t, res.Results, 3)
+			assert.Equal(t, "x1.mp4", res.Results[0].Source)
+		},
+	)
+
+	t.Run(
+		"Linking keeps sources and applies the conflict policy", func(t *testing.T) {
+			res := finished(start(map[string]string{"source": filepath.Join(sources, "library")}).ID)
+			assert.Equal(t, jobs.StateDone, res.State)
+			assert.Equal(t, AdoptLink, res.Mode)
+			assert.Equal(t, 2, res.Adopted)
+			assert.Equal(t, 2, res.Skipped)
+			assert.Equal(t, 0, res.Failed)
+
+			src, err := os.Stat(filepath.Join(sources, "library", "nested", "b.mp4"))
+			assert.Nil(t, err)
+			dst, err := os.Stat(filepath.Join(testDir, "nested_b.mp4"))
+			assert.Nil(t, err)
+			assert.True(t, os.SameFile(src, dst))
+
+			m, err := hdl.meta.Get("nested_b.mp4")
+			assert.Nil(t, err)
+			assert.Equal(t, "b.mp4", m.OriginalName)
+			assert.Equal(t, int64(5), m.Size)
+			assert.NotEmpty(t, m.Checksum)
+			assert.NoFileExists(t, filepath.Join(testDir, ".DS_Store"))
+
+			data, err := os.ReadFile(filepath.Join(testDir, "taken.mp4"))
+			assert.Nil(t, err)
+			assert.Equal(t, "stored copy", string(data))
+		},
+	)
+
+	t.Run(
+		"Moving overwrites when asked", func(t *testing.T) {
+			started := start(
+				map[string]string{"source": filepath.Join(sources, "inbox"), "mode": AdoptMove, "conflict": ConflictOverwrite},
+			)
+			res := finished(started.ID)
+			assert.Equal(t, jobs.StateDone, res.State)
+			assert.Equal(t, 1, res.Overwritten)
+			assert.Equal(t, "taken.mp4", res.Results[0].Name)
+
+			assert.NoFileExists(t, filepath.Join(sources, "inbox", "taken.mp4"))
+			data, err := os.ReadFile(filepath.Join(testDir, "taken.mp4"))
+			assert.Nil(t, err)
+			assert.Equal(t, "inbox copy", string(data))
+		},
+	)
+
+	t.Run(
+		"Failing on conflicts fails the job", func(t *testing.T) {
+			started := start(map[string]string{"source": filepath.Join(sources, "library"), "conflict": ConflictFail})
+			res := finished(started.ID)
+			assert.Equal(t, jobs.StateFailed, res.State)
+			assert.Equal(t, 1, res.Failed)
+		},
+	)
+}
@@ -0,0 +1,280 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/webhook"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const defaultAvailabilitySweep = 10 * time.Minute
+
+func (h *Handler) availabilitySkew() time.Duration {
+	if h.config.Availability == nil {
+		return 0
+	}
+	return h.config.Availability.ClockSkew
+}
+
+func (h *Handler) available(name string, now time.Time) bool {
+	m, err := h.meta.Get(name)
+	return err != nil || m.Available(now, h.availabilitySkew())
+}
+
+// checkAvailable answers requests for files outside their availability
+// window with 404, as if they did not exist, or with 403 and the window when
+// so configured. Admins are let through.
+func (h *Handler) checkAvailable(w http.ResponseWriter, r *http.Request, name string) bool {
+	if h.isAdmin(r) {
+		return true
+	}
+	m, err := h.meta.Get(name)
+	if err != nil || m.Available(time.Now(), h.availabilitySkew()) {
+		return true
+	}
+
+	if h.config.Availability != nil && h.config.Availability.Forbidden {
+		writeError(
+			w, withParams(
+				ErrUnavailable, map[string]any{"available_from": m.AvailableFrom, "available_until": m.AvailableUntil},
+			),
+		)
+		return false
+	}
+	writeError(w, withParams(ErrNotFound, map[string]any{"name": name}))
+	return false
+}
+
+// servable reports why the static file server must not serve name, which
+// has no request to tell admins apart: outside its availability window it
+// does not exist, or is forbidden when so configured, and files that are not
+// ready are forbidden.
+func (h *Handler) servable(name string) error {
+	if !h.available(name, time.Now()) {
+		if h.config.Availability != nil && h.config.Availability.Forbidden {
+			return fs.ErrPermission
+		}
+		return fs.ErrNotExist
+	}
+	if h.fileState(name) != meta.StateReady {
+		return fs.ErrPermission
+	}
+	return nil
+}
+
+func validWindow(from, until *time.Time) error {
+	if from != nil && until != nil && !until.After(*from) {
+		return ErrInvalidAvailability
+	}
+	return nil
+}
+
+// parseAvailability parses the available_from and available_until form
+// fields of an upload. Times are RFC 3339 in any offset and stored in UTC.
+func parseAvailability(from, until string) (*time.Time, *time.Time, error) {
+	parse := func(v string) (*time.Time, error) {
+		if v == "" {
+			return nil, nil
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, ErrInvalidAvailability
+		}
+		t = t.UTC()
+		return &t, nil
+	}
+
+	f, err := parse(from)
+	if err != nil {
+		return nil, nil, err
+	}
+	u, err := parse(until)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, u, validWindow(f, u)
+}
+
+func availableFilter(r *http.Request) (*bool, error) {
+	v := r.URL.Query().Get("available")
+	if v == "" {
+		return nil, nil
+	}
+	res, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, ErrInvalidAvailability
+	}
+	return &res, nil
+}
+
+// optionalTime tells a field left out of a PATCH body, which keeps the
+// current value, from an explicit null, which clears it.
+type optionalTime struct {
+	set   bool
+	value *time.Time
+}
+
+func (t *optionalTime) UnmarshalJSON(data []byte) error {
+	t.set = true
+	if string(data) == "null" {
+		t.value = nil
+		return nil
+	}
+
+	v := time.Time{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	v = v.UTC()
+	t.value = &v
+	return nil
+}
+
+type metaPatch struct {
+	AvailableFrom  optionalTime `json:"available_from"`
+	AvailableUntil optionalTime `json:"available_until"`
+}
+
+func (h *Handler) patchMeta(w http.ResponseWriter, r *http.Request) {
+	name, err := h.pathName(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !h.checkInternal(w, r, name) {
+		return
+	}
+
+	req := metaPatch{}
+	if !h.decodeJSON(w, r, &req) {
+		return
+	}
+
+	unlock := h.fileMu.Lock(name)
+	defer unlock()
+
+	info, err := h.statFile(name)
+	if err != nil || info.IsDir() {
+		writeError(w, withParams(ErrNotFound, map[string]any{"name": name}))
+		return
+	}
+	if !h.checkLock(w, r, name) {
+		return
+	}
+
+	m, err := h.meta.Get(name)
+	if errors.Is(err, meta.ErrNotFound) {
+		m = &meta.File{Name: name, OriginalName: name, Size: info.Size(), CreatedAt: info.ModTime().UTC()}
+	} else if err != nil {
+		writeError(w, ErrInternal)
+		return
+	}
+
+	if req.AvailableFrom.set {
+		m.AvailableFrom = req.AvailableFrom.value
+	}
+	if req.AvailableUntil.set {
+		m.AvailableUntil = req.AvailableUntil.value
+	}
+	if err = validWindow(m.AvailableFrom, m.AvailableUntil); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	m.UpdatedAt = time.Now().UTC()
+	if err = h.meta.Put(m); err != nil {
+		log.Printf("Error saving metadata for %s: %s\n", name, err)
+		writeError(w, ErrInternal)
+		return
+	}
+
+	h.emit(
+		webhook.FileUpdated, name, r,
+		map[string]any{"available_from": m.AvailableFrom, "available_until": m.AvailableUntil},
+	)
+	utils.JSONResponse(w, http.StatusOK, withoutExpiredHold(m))
+}
+
+// sweepExpired permanently deletes files whose availability ended more than
+// DeleteAfter ago. Locked and held files are kept.
+func (h *Handler) sweepExpired(ctx context.Context, now time.Time) {
+	grace := h.config.Availability.DeleteAfter + h.availabilitySkew()
+
+	var expired []string
+	err := h.meta.Walk(
+		func(m *meta.File) error {
+			if m.AvailableUntil != nil && now.After(m.AvailableUntil.Add(grace)) {
+				expired = append(expired, m.Name)
+			}
+			return ctx.Err()
+		},
+	)
+	if err != nil {
+		log.Printf("Error sweeping expired files: %s\n", err)
+		return
+	}
+
+	for _, name := range expired {
+		if err = h.deleteExpired(ctx, name, now); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error deleting expired file %s: %s\n", name, err)
+		}
+	}
+}
+
+func (h *Handler) deleteExpired(ctx context.Context, name string, now time.Time) error {
+	unlock := h.fileMu.Lock(name)
+	defer unlock()
+
+	m, err := h.meta.Get(name)
+	if err != nil {
+		return err
+	}
+	grace := h.config.Availability.DeleteAfter + h.availabilitySkew()
+	if m.AvailableUntil == nil || !now.After(m.AvailableUntil.Add(grace)) || m.Lock.Active() || m.Hold.Active() {
+		return nil
+	}
+
+	if _, err = h.removeFile(h.filePath(name)); err != nil {
+		return err
+	}
+	if err = h.meta.Delete(name); err != nil {
+		log.Printf("Error removing metadata for %s: %s\n", name, err)
+	}
+	if err = os.Remove(h.waveformPath(name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing waveform for %s: %s\n", name, err)
+	}
+	h.removePreview(name)
+	h.removeOriginal(name)
+
+	runFileHooks(ctx, h.hooks.deleted, fileInfo(name, m))
+	h.emit(webhook.FileDeleted, name, nil, map[string]any{"reason": "expired"})
+	log.Printf("File %s deleted after its availability ended\n", name)
+	return nil
+}
+
+func (h *Handler) runAvailabilitySweeper(ctx context.Context) {
+	interval := h.config.Availability.SweepInterval
+	if interval <= 0 {
+		interval = defaultAvailabilitySweep
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.sweepExpired(ctx, now)
+		}
+	}
+}
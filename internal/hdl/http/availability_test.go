@@ -0,0 +1,187 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAvailability(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize: 1024,
+			DefaultPage:   1,
+			DefaultSize:   10,
+			AdminToken:    "admin-secret",
+			Availability: &config.AvailabilityConfig{
+				ClockSkew:   30 * time.Second,
+				DeleteAfter: time.Hour,
+			},
+		},
+	)
+
+	do := func(req *http.Request, admin bool) *httptest.ResponseRecorder {
+		if admin {
+			req.Header.Set("Authorization", "Bearer admin-secret")
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	stream := func(name string, admin bool) int {
+		return do(httptest.NewRequest(http.MethodGet, "/stream/"+name, nil), admin).Code
+	}
+	patch := func(name string, body map[string]any) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		return do(httptest.NewRequest(http.MethodPatch, "/files/"+name+"/meta", bytes.NewReader(data)), false)
+	}
+	errorCode := func(rec *httptest.ResponseRecorder) string {
+		res := utils.ErrorResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res.Code
+	}
+	listed := func(query string) []ListEntry {
+		rec := do(httptest.NewRequest(http.MethodGet, "/list?include=availability&"+query, nil), false)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var res struct {
+			Data []ListEntry `json:"data"`
+		}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res.Data
+	}
+
+	plus5 := time.FixedZone("UTC+5", 5*60*60)
+	from := time.Now().Add(time.Hour).In(plus5).Truncate(time.Second)
+
+	t.Run(
+		"Scheduled uploads are stored in UTC and hidden until they go live", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(
+				rec, newUploadRequest(
+					"launch.mp4", "video", map[string]string{"available_from": from.Format(time.RFC3339)},
+				),
+			)
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			m, err := hdl.meta.Get("launch.mp4")
+			assert.Nil(t, err)
+			assert.True(t, m.AvailableFrom.Equal(from))
+			assert.Equal(t, time.UTC, m.AvailableFrom.Location())
+
+			rec = do(httptest.NewRequest(http.MethodGet, "/files/launch.mp4/meta", nil), false)
+			assert.Contains(t, rec.Body.String(), `"available_from":"`+from.UTC().Format(time.RFC3339)+`"`)
+
+			assert.Equal(t, http.StatusNotFound, stream("launch.mp4", false))
+			assert.Equal(t, http.StatusNotFound, do(httptest.NewRequest(http.MethodGet, "/uploads/launch.mp4", nil), false).Code)
+			assert.Equal(t, http.StatusOK, stream("launch.mp4", true))
+
+			body, _ := json.Marshal(map[string]string{"name": "launch.mp4"})
+			rec = do(httptest.NewRequest(http.MethodPost, "/downloads", bytes.NewReader(body)), false)
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		},
+	)
+
+	t.Run(
+		"List includes and filters by availability", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("evergreen.mp4", "video", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			all := listed("")
+			assert.Len(t, all, 2)
+			for _, e := range all {
+				assert.NotNil(t, e.Available)
+				assert.Equal(t, filepath.Base(e.URL) == "evergreen.mp4", *e.Available)
+			}
+
+			live := listed("available=true")
+			assert.Len(t, live, 1)
+			assert.Contains(t, live[0].URL, "evergreen.mp4")
+
+			scheduled := listed("available=false")
+			assert.Len(t, scheduled, 1)
+			assert.Contains(t, scheduled[0].URL, "launch.mp4")
+
+			rec = do(httptest.NewRequest(http.MethodGet, "/list?available=maybe", nil), false)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Equal(t, "invalid_availability", errorCode(rec))
+		},
+	)
+
+	t.Run(
+		"Clock skew widens both ends of the window", func(t *testing.T) {
+			now := time.Now()
+			assert.Equal(t, http.StatusOK, patch("launch.mp4", map[string]any{"available_from": now.Add(20 * time.Second)}).Code)
+			assert.Equal(t, http.StatusOK, stream("launch.mp4", false))
+
+			rec := patch(
+				"launch.mp4", map[string]any{"available_from": nil, "available_until": now.Add(-20 * time.Second)},
+			)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, http.StatusOK, stream("launch.mp4", false))
+
+			assert.Equal(t, http.StatusOK, patch("launch.mp4", map[string]any{"available_until": now.Add(-time.Minute)}).Code)
+			assert.Equal(t, http.StatusNotFound, stream("launch.mp4", false))
+
+			m, err := hdl.meta.Get("launch.mp4")
+			assert.Nil(t, err)
+			assert.Nil(t, m.AvailableFrom)
+			assert.Equal(t, time.UTC, m.AvailableUntil.Location())
+		},
+	)
+
+	t.Run(
+		"Invalid windows are rejected", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("bad.mp4", "video", map[string]string{"available_from": "tomorrow"}))
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Equal(t, "invalid_availability", errorCode(rec))
+
+			now := time.Now()
+			rec = patch(
+				"evergreen.mp4", map[string]any{"available_from": now, "available_until": now.Add(-time.Hour)},
+			)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Equal(t, "invalid_availability", errorCode(rec))
+
+			assert.Equal(t, http.StatusNotFound, patch("missing.mp4", map[string]any{"available_from": nil}).Code)
+		},
+	)
+
+	t.Run(
+		"Forbidden instead of not found", func(t *testing.T) {
+			hdl.config.Availability.Forbidden = true
+			defer func() { hdl.config.Availability.Forbidden = false }()
+
+			rec := do(httptest.NewRequest(http.MethodGet, "/stream/launch.mp4", nil), false)
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+			assert.Equal(t, "unavailable", errorCode(rec))
+			assert.Equal(t, http.StatusForbidden, do(httptest.NewRequest(http.MethodGet, "/uploads/launch.mp4", nil), false).Code)
+		},
+	)
+
+	t.Run(
+		"Expired files are deleted after the grace period", func(t *testing.T) {
+			hdl.sweepExpired(context.Background(), time.Now())
+			assert.FileExists(t, filepath.Join(testDir, "launch.mp4"))
+
+			hdl.sweepExpired(context.Background(), time.Now().Add(2*time.Hour))
+			assert.NoFileExists(t, filepath.Join(testDir, "launch.mp4"))
+			_, err := hdl.meta.Get("launch.mp4")
+			assert.ErrorIs(t, err, meta.ErrNotFound)
+			assert.FileExists(t, filepath.Join(testDir, "evergreen.mp4"))
+		},
+	)
+}
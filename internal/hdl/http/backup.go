@@ -0,0 +1,444 @@
+package http
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/storage"
+	"github.com/JMURv/media-server/internal/webhook"
+	"github.com/JMURv/media-server/pkg/filename"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	manifestName = "manifest.json"
+	filesPrefix  = "files/"
+
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictFail      = "fail"
+
+	ImportImported    = "imported"
+	ImportOverwritten = "overwritten"
+	ImportSkipped     = "skipped"
+	ImportFailed      = "failed"
+	ImportMissing     = "missing"
+)
+
+type Manifest struct {
+	CreatedAt time.Time       `json:"created_at"`
+	Files     []ManifestEntry `json:"files"`
+}
+
+type ManifestEntry struct {
+	Name     string     `json:"name"`
+	Size     int64      `json:"size"`
+	Checksum string     `json:"checksum"`
+	ModTime  time.Time  `json:"mod_time"`
+	Meta     *meta.File `json:"meta,omitempty"`
+}
+
+type ImportResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func validPath(name string) bool {
+	for _, seg := range strings.Split(name, "/") {
+		if res, err := filename.Validate(seg, 0); err != nil || res != seg {
+			return false
+		}
+	}
+	return name != ""
+}
+
+func (h *Handler) snapshotEntry(ctx context.Context, name string, size int64, modTime time.Time) (ManifestEntry, error) {
+	unlock := h.fileMu.Lock(name)
+	defer unlock()
+
+	entry := ManifestEntry{Name: name, Size: size, ModTime: modTime.UTC()}
+	if m, err := h.meta.Get(name); err == nil {
+		m.Lock = nil
+		entry.Meta = m
+		if m.Size == size {
+			entry.Checksum = m.Checksum
+		}
+	}
+
+	if entry.Checksum == "" {
+		file, err := h.openFile(ctx, name)
+		if err != nil {
+			return entry, err
+		}
+		defer file.Close()
+
+		hash := sha256.New()
+		if _, err = io.Copy(hash, storage.Reader(ctx, file)); err != nil {
+			return entry, err
+		}
+		entry.Checksum = hex.EncodeToString(hash.Sum(nil))
+	}
+	return entry, nil
+}
+
+func (h *Handler) snapshot(ctx context.Context) (*Manifest, error) {
+	res := &Manifest{
+		CreatedAt: time.Now().UTC(),
+		Files:     make([]ManifestEntry, 0),
+	}
+
+	_, err := h.walkRootEntries(
+		ctx, true, 0, func(root, p string, d fs.DirEntry) error {
+			if p != root && strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.Type()&fs.ModeSymlink != 0 {
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			info = h.storedInfo(p, info)
+
+			rel, _ := filepath.Rel(root, p)
+			entry, err := h.snapshotEntry(ctx, filepath.ToSlash(rel), info.Size(), info.ModTime())
+			if err != nil {
+				return err
+			}
+			res.Files = append(res.Files, entry)
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.meta.Walk(
+		func(m *meta.File) error {
+			if m.Backend == "" {
+				return nil
+			}
+			if _, err := os.Stat(h.filePath(m.Name)); err == nil {
+				return nil
+			}
+
+			entry, err := h.snapshotEntry(ctx, m.Name, m.Size, m.UpdatedAt)
+			if err != nil {
+				return err
+			}
+			res.Files = append(res.Files, entry)
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(res.Files, func(i, j int) bool { return res.Files[i].Name < res.Files[j].Name })
+	return res, nil
+}
+
+func (h *Handler) exportEntry(ctx context.Context, tw *tar.Writer, entry ManifestEntry) error {
+	unlock := h.fileMu.Lock(entry.Name)
+	defer unlock()
+
+	if err := tw.WriteHeader(
+		&tar.Header{
+			Name:    filesPrefix + entry.Name,
+			Mode:    0644,
+			Size:    entry.Size,
+			ModTime: entry.ModTime,
+		},
+	); err != nil {
+		return err
+	}
+
+	var n int64
+	hash := sha256.New()
+	file, err := h.openFile(ctx, entry.Name)
+	if err == nil {
+		n, err = io.CopyN(tw, io.TeeReader(storage.Reader(ctx, file), hash), entry.Size)
+		file.Close()
+	}
+	if cerr := storage.Canceled(ctx); cerr != nil {
+		return cerr
+	}
+
+	if n < entry.Size {
+		log.Printf("File %s changed during export: %v\n", entry.Name, err)
+		_, err = io.CopyN(tw, zeroReader{}, entry.Size-n)
+		return err
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != entry.Checksum {
+		log.Printf("File %s changed during export, checksum mismatch\n", entry.Name)
+	}
+	return nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func (h *Handler) exportArchive(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+
+	manifest, err := h.snapshot(r.Context())
+	if err != nil {
+		log.Printf("Error creating export snapshot: %s\n", err)
+		writeError(w, canceledOr(err, ErrInternal))
+		return
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		writeError(w, ErrInternal)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set(
+		"Content-Disposition",
+		fmt.Sprintf("attachment; filename=export-%s.tar", manifest.CreatedAt.Format("20060102T150405Z")),
+	)
+
+	tw := tar.NewWriter(w)
+	if err = tw.WriteHeader(
+		&tar.Header{
+			Name:    manifestName,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: manifest.CreatedAt,
+		},
+	); err == nil {
+		_, err = tw.Write(data)
+	}
+
+	for _, entry := range manifest.Files {
+		if err != nil {
+			break
+		}
+		err = h.exportEntry(r.Context(), tw, entry)
+	}
+
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		log.Printf("Error streaming export: %s\n", err)
+		return
+	}
+
+	h.emit("admin.export", "", r, map[string]any{"files": len(manifest.Files)})
+	log.Printf("Exported %d files\n", len(manifest.Files))
+}
+
+func (h *Handler) importEntry(r *http.Request, tr *tar.Reader, entry ManifestEntry, conflict string) ImportResult {
+	res := ImportResult{Name: entry.Name}
+	fail := func(err error) ImportResult {
+		res.Status, res.Error = ImportFailed, err.Error()
+		return res
+	}
+
+	path := h.filePath(entry.Name)
+	unlock := h.fileMu.Lock(entry.Name)
+	defer unlock()
+
+	info, err := h.statStored(path)
+	exists := err == nil
+	if exists {
+		if m, err := h.meta.Get(entry.Name); err == nil && m.Lock.Active() {
+			return fail(ErrLocked)
+		}
+		if h.activeHold(entry.Name) != nil {
+			return fail(ErrHeld)
+		}
+
+		switch conflict {
+		case ConflictSkip:
+			res.Status = ImportSkipped
+			return res
+		case ConflictFail:
+			return fail(ErrAlreadyExists)
+		}
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fail(err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".import-*")
+	if err != nil {
+		return fail(err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), storage.Reader(r.Context(), tr))
+	if err = errors.Join(err, tmp.Close()); err != nil {
+		return fail(err)
+	}
+
+	if n != entry.Size || hex.EncodeToString(hash.Sum(nil)) != entry.Checksum {
+		return fail(ErrChecksumMismatch)
+	}
+	if _, err = h.sealFile(r.Context(), tmp.Name(), false); err != nil {
+		return fail(err)
+	}
+
+	var rep *replacement
+	if exists {
+		prev, err := h.meta.Get(entry.Name)
+		if err != nil {
+			prev = nil
+		}
+		if rep, err = h.setAside(entry.Name, path, prev, info); err != nil {
+			return fail(err)
+		}
+	}
+
+	os.Chtimes(tmp.Name(), entry.ModTime, entry.ModTime)
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		if rep != nil {
+			rep.discard()
+		}
+		return fail(err)
+	}
+	if rep != nil {
+		h.keep(rep, strconv.Quote(entry.Checksum))
+	}
+
+	m := entry.Meta
+	if m == nil {
+		m = &meta.File{Name: entry.Name, OriginalName: entry.Name, CreatedAt: entry.ModTime}
+	}
+	m.Name, m.Size, m.Checksum, m.Backend, m.Lock = entry.Name, entry.Size, entry.Checksum, "", nil
+	if exists {
+		m.ID = h.overwriteID(entry.Name)
+	}
+	m.UpdatedAt = time.Now().UTC()
+	if err = h.meta.Put(m); err != nil {
+		log.Printf("Error saving metadata for %s: %s\n", entry.Name, err)
+	}
+
+	res.Status = ImportImported
+	typ := webhook.FileCreated
+	if exists {
+		res.Status, typ = ImportOverwritten, webhook.FileUpdated
+	}
+
+	h.emit(typ, entry.Name, r, map[string]any{"size": entry.Size, "checksum": entry.Checksum, "source": "import"})
+	return res
+}
+
+func (h *Handler) importArchive(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+
+	conflict := r.URL.Query().Get("conflict")
+	if conflict == "" {
+		conflict = h.config.ImportConflict
+	}
+
+	switch conflict {
+	case "":
+		conflict = ConflictSkip
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+	default:
+		writeError(w, ErrInvalidConflictPolicy)
+		return
+	}
+
+	tr := tar.NewReader(r.Body)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != manifestName {
+		writeError(w, ErrInvalidArchive)
+		return
+	}
+
+	manifest := &Manifest{}
+	if err = json.NewDecoder(tr).Decode(manifest); err != nil {
+		writeError(w, ErrInvalidArchive)
+		return
+	}
+
+	entries := make(map[string]ManifestEntry, len(manifest.Files))
+	for _, entry := range manifest.Files {
+		entries[entry.Name] = entry
+	}
+
+	status := http.StatusOK
+	results := make([]ImportResult, 0, len(manifest.Files))
+	for {
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			status = http.StatusBadRequest
+			results = append(results, ImportResult{Status: ImportFailed, Error: ErrInvalidArchive.Error()})
+			break
+		}
+
+		name, ok := strings.CutPrefix(hdr.Name, filesPrefix)
+		if hdr.Typeflag != tar.TypeReg || !ok {
+			continue
+		}
+
+		entry, ok := entries[name]
+		delete(entries, name)
+		if !ok || !validPath(name) {
+			results = append(results, ImportResult{Name: name, Status: ImportFailed, Error: ErrInvalidArchive.Error()})
+			continue
+		}
+
+		res := h.importEntry(r, tr, entry, conflict)
+		results = append(results, res)
+		if conflict == ConflictFail && res.Error == ErrAlreadyExists.Error() {
+			status = http.StatusConflict
+			break
+		}
+	}
+
+	if status == http.StatusOK {
+		for name := range entries {
+			results = append(results, ImportResult{Name: name, Status: ImportMissing})
+		}
+	}
+
+	h.emit("admin.import", "", r, map[string]any{"files": len(results), "conflict": conflict})
+	log.Printf("Imported archive with %d entries\n", len(results))
+	utils.JSONResponse(w, status, results)
+}
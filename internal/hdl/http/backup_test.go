@@ -0,0 +1,190 @@
+package http
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func buildArchive(t *testing.T, manifest *Manifest, files map[string]string) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+
+	data, err := json.Marshal(manifest)
+	assert.Nil(t, err)
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Name: manifestName, Mode: 0644, Size: int64(len(data))}))
+	tw.Write(data)
+
+	for name, content := range files {
+		assert.Nil(t, tw.WriteHeader(&tar.Header{Name: filesPrefix + name, Mode: 0644, Size: int64(len(content))}))
+		tw.Write([]byte(content))
+	}
+	assert.Nil(t, tw.Close())
+	return buf
+}
+
+// importInto posts an archive to /admin/import as admin, hdl having
+// "admin-secret" as its admin token.
+func importInto(hdl *Handler, body io.Reader, query string) (*httptest.ResponseRecorder, map[string]ImportResult) {
+	req := httptest.NewRequest(http.MethodPost, "/admin/import"+query, body)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	rec := httptest.NewRecorder()
+	hdl.ServeHTTP(rec, req)
+
+	var results []ImportResult
+	json.NewDecoder(bytes.NewReader(rec.Body.Bytes())).Decode(&results)
+
+	res := make(map[string]ImportResult)
+	for _, r := range results {
+		res[r.Name] = r
+	}
+	return rec, res
+}
+
+func TestExportImport(t *testing.T) {
+	testDir := t.TempDir()
+	src := setupTestHandler(testDir)
+	src.config.AdminToken = "admin-secret"
+
+	rec := httptest.NewRecorder()
+	src.createFile(rec, newUploadRequest("Photo.JPG", "photo", map[string]string{"naming": NamingSlug}))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	assert.Nil(t, os.MkdirAll(filepath.Join(testDir, "tmp"), os.ModePerm))
+	assert.Nil(t, os.WriteFile(filepath.Join(testDir, "tmp", "nested.txt"), []byte("nested"), 0644))
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/export", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	rec = httptest.NewRecorder()
+	src.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-tar", rec.Header().Get("Content-Type"))
+	archive := rec.Body.Bytes()
+
+	t.Run(
+		"Archive layout", func(t *testing.T) {
+			tr := tar.NewReader(bytes.NewReader(archive))
+			hdr, err := tr.Next()
+			assert.Nil(t, err)
+			assert.Equal(t, manifestName, hdr.Name)
+
+			manifest := &Manifest{}
+			assert.Nil(t, json.NewDecoder(tr).Decode(manifest))
+			assert.Len(t, manifest.Files, 2)
+			assert.Equal(t, "photo.jpg", manifest.Files[0].Name)
+			assert.Equal(t, "Photo.JPG", manifest.Files[0].Meta.OriginalName)
+			assert.Equal(t, "tmp/nested.txt", manifest.Files[1].Name)
+			assert.NotEmpty(t, manifest.Files[1].Checksum)
+
+			var names []string
+			for {
+				hdr, err = tr.Next()
+				if err == io.EOF {
+					break
+				}
+				assert.Nil(t, err)
+				names = append(names, hdr.Name)
+			}
+			assert.Equal(t, []string{"files/photo.jpg", "files/tmp/nested.txt"}, names)
+		},
+	)
+
+	dst := New(port, t.TempDir(), &config.HTTPConfig{AdminToken: "admin-secret"})
+
+	t.Run(
+		"Admin only", func(t *testing.T) {
+			for _, token := range []string{"", "wrong"} {
+				req := httptest.NewRequest(http.MethodGet, "/admin/export", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				rec := httptest.NewRecorder()
+				src.ServeHTTP(rec, req)
+				assert.Equal(t, http.StatusForbidden, rec.Code)
+
+				req = httptest.NewRequest(http.MethodPost, "/admin/import?conflict=overwrite", bytes.NewReader(archive))
+				req.Header.Set("Authorization", "Bearer "+token)
+				rec = httptest.NewRecorder()
+				dst.ServeHTTP(rec, req)
+				assert.Equal(t, http.StatusForbidden, rec.Code)
+			}
+			assert.NoFileExists(t, filepath.Join(dst.savePath, "photo.jpg"))
+		},
+	)
+
+	t.Run(
+		"Import restores files and metadata", func(t *testing.T) {
+			rec, res := importInto(dst, bytes.NewReader(archive), "")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, ImportImported, res["photo.jpg"].Status)
+			assert.Equal(t, ImportImported, res["tmp/nested.txt"].Status)
+
+			data, err := os.ReadFile(filepath.Join(dst.savePath, "tmp", "nested.txt"))
+			assert.Nil(t, err)
+			assert.Equal(t, "nested", string(data))
+
+			m, err := dst.meta.Get("photo.jpg")
+			assert.Nil(t, err)
+			assert.Equal(t, "Photo.JPG", m.OriginalName)
+		},
+	)
+
+	t.Run(
+		"Conflict handling", func(t *testing.T) {
+			rec, res := importInto(dst, bytes.NewReader(archive), "")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, ImportSkipped, res["photo.jpg"].Status)
+
+			rec, res = importInto(dst, bytes.NewReader(archive), "?conflict=overwrite")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, ImportOverwritten, res["photo.jpg"].Status)
+
+			rec, res = importInto(dst, bytes.NewReader(archive), "?conflict=fail")
+			assert.Equal(t, http.StatusConflict, rec.Code)
+			assert.Equal(t, ImportFailed, res["photo.jpg"].Status)
+			assert.Len(t, res, 1)
+
+			rec, _ = importInto(dst, bytes.NewReader(archive), "?conflict=merge")
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Checksum and name verification", func(t *testing.T) {
+			manifest := &Manifest{
+				CreatedAt: time.Now(),
+				Files: []ManifestEntry{
+					{Name: "corrupt.txt", Size: 4, Checksum: strings.Repeat("0", 64)},
+					{Name: "../evil.txt", Size: 4, Checksum: strings.Repeat("0", 64)},
+					{Name: "absent.txt", Size: 1, Checksum: strings.Repeat("0", 64)},
+				},
+			}
+			archive := buildArchive(t, manifest, map[string]string{"corrupt.txt": "data", "../evil.txt": "evil"})
+
+			rec, res := importInto(dst, archive, "")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, ImportFailed, res["corrupt.txt"].Status)
+			assert.Equal(t, ErrChecksumMismatch.Error(), res["corrupt.txt"].Error)
+			assert.Equal(t, ImportFailed, res["../evil.txt"].Status)
+			assert.Equal(t, ImportMissing, res["absent.txt"].Status)
+
+			assert.NoFileExists(t, filepath.Join(dst.savePath, "corrupt.txt"))
+			assert.NoFileExists(t, filepath.Join(filepath.Dir(dst.savePath), "evil.txt"))
+		},
+	)
+
+	t.Run(
+		"Invalid archive", func(t *testing.T) {
+			rec, _ := importInto(dst, strings.NewReader("not a tar"), "")
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		},
+	)
+}
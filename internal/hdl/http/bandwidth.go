@@ -0,0 +1,157 @@
+package http
+
+import (
+	"context"
+	"github.com/JMURv/media-server/internal/metrics"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StreamSmall = "small"
+	StreamLarge = "large"
+)
+
+const (
+	// bandwidthQuantum is the share of a weight 1 stream per turn.
+	bandwidthQuantum       = 32 << 10
+	defaultSmallFileSize   = 1 << 20
+	defaultSmallFileWeight = 4
+)
+
+type streamClass struct {
+	weight int
+	active atomic.Int64
+	bytes  *metrics.Counter
+}
+
+// streamScheduler hands out a global byte budget in turns. Every turn
+// reserves the next free slot on a shared clock, so active streams take
+// turns in order and each gets a share proportional to its turn size, its
+// weight. Streams that are blocked on a slow client reserve nothing, and the
+// budget goes to the others; shares follow as streams start and finish.
+type streamScheduler struct {
+	budget int64
+
+	mu   sync.Mutex
+	next time.Time
+
+	smallSize int64
+	classes   map[string]*streamClass
+}
+
+func newStreamScheduler(budget, smallSize int64, smallWeight int, reg *metrics.Registry) *streamScheduler {
+	if smallSize <= 0 {
+		smallSize = defaultSmallFileSize
+	}
+	if smallWeight <= 0 {
+		smallWeight = defaultSmallFileWeight
+	}
+
+	s := &streamScheduler{budget: budget, smallSize: smallSize, classes: make(map[string]*streamClass)}
+	for class, weight := range map[string]int{StreamSmall: smallWeight, StreamLarge: 1} {
+		c := &streamClass{
+			weight: weight,
+			bytes:  reg.Counter("stream_"+class+"_bytes_total", "Bytes sent by paced "+class+" file streams."),
+		}
+		reg.GaugeFunc(
+			"stream_"+class+"_active", "Paced "+class+" file streams in progress.",
+			func() float64 { return float64(c.active.Load()) },
+		)
+		s.classes[class] = c
+	}
+	reg.GaugeFunc(
+		"stream_budget_bytes", "Bytes per second shared by paced streams.", func() float64 { return float64(budget) },
+	)
+	return s
+}
+
+func (s *streamScheduler) class(size int64) *streamClass {
+	if size >= 0 && size <= s.smallSize {
+		return s.classes[StreamSmall]
+	}
+	return s.classes[StreamLarge]
+}
+
+// wait reserves n bytes of the budget and blocks until their slot starts.
+func (s *streamScheduler) wait(ctx context.Context, n int) error {
+	s.mu.Lock()
+	now := time.Now()
+	start := s.next
+	if start.Before(now) {
+		start = now
+	}
+	s.next = start.Add(time.Duration(int64(n) * int64(time.Second) / s.budget))
+	s.mu.Unlock()
+
+	d := start.Sub(now)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pacedWriter takes turns of its class's weight in quanta, spending each over
+// as many writes as it takes.
+type pacedWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	s      *streamScheduler
+	class  *streamClass
+	credit int
+}
+
+func (w *pacedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if w.credit == 0 {
+			turn := bandwidthQuantum * w.class.weight
+			if err := w.s.wait(w.ctx, turn); err != nil {
+				return written, err
+			}
+			w.credit = turn
+		}
+
+		n, err := w.ResponseWriter.Write(p[:min(len(p), w.credit)])
+		written += n
+		w.credit -= n
+		w.class.bytes.Add(uint64(n))
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *pacedWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *pacedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// paceStream returns w paced by the bandwidth budget for a response of size
+// bytes, or -1 when unknown, and a func to call when the response is done.
+func (h *Handler) paceStream(r *http.Request, w http.ResponseWriter, size int64) (http.ResponseWriter, func()) {
+	if h.streams == nil || r.Method == http.MethodHead {
+		return w, func() {}
+	}
+
+	c := h.streams.class(size)
+	c.active.Add(1)
+	return &pacedWriter{ResponseWriter: w, ctx: r.Context(), s: h.streams, class: c}, func() { c.active.Add(-1) }
+}
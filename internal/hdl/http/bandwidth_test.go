@@ -0,0 +1,131 @@
+package http
+
+import (
+	"context"
+	"github.com/JMURv/media-server/internal/metrics"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowWriter is a client that takes delay to accept every write.
+type slowWriter struct {
+	http.ResponseWriter
+	delay time.Duration
+	n     atomic.Int64
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.delay)
+	w.n.Add(int64(len(p)))
+	return len(p), nil
+}
+
+func TestBandwidthFairness(t *testing.T) {
+	const (
+		budget = 8 << 20
+		period = 600 * time.Millisecond
+	)
+
+	// run streams one client per size for period and returns the bytes each
+	// received.
+	run := func(sizes []int64, delays []time.Duration) []int64 {
+		s := newStreamScheduler(budget, 0, 0, metrics.NewRegistry())
+		ctx, cancel := context.WithTimeout(context.Background(), period)
+		defer cancel()
+
+		clients := make([]*slowWriter, len(sizes))
+		wg := sync.WaitGroup{}
+		for i, size := range sizes {
+			clients[i] = &slowWriter{ResponseWriter: httptest.NewRecorder(), delay: delays[i]}
+			w := &pacedWriter{ResponseWriter: clients[i], ctx: ctx, s: s, class: s.class(size)}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				buf := make([]byte, 64<<10)
+				for ctx.Err() == nil {
+					if _, err := w.Write(buf); err != nil {
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		res := make([]int64, len(clients))
+		for i, c := range clients {
+			res[i] = c.n.Load()
+		}
+		return res
+	}
+	sum := func(v []int64) (total int64) {
+		for _, n := range v {
+			total += n
+		}
+		return total
+	}
+	expected := float64(budget) * period.Seconds()
+
+	t.Run(
+		"Equal streams get equal shares of the budget", func(t *testing.T) {
+			got := run([]int64{1 << 30, 1 << 30, 1 << 30}, []time.Duration{0, 0, 0})
+			assert.InEpsilon(t, expected, float64(sum(got)), 0.25)
+			for _, n := range got {
+				assert.InEpsilon(t, float64(sum(got))/3, float64(n), 0.25)
+			}
+		},
+	)
+
+	t.Run(
+		"Small files get a larger share", func(t *testing.T) {
+			got := run([]int64{1 << 30, 1 << 30, 64 << 10}, []time.Duration{0, 0, 0})
+			assert.InEpsilon(t, expected, float64(sum(got)), 0.25)
+			assert.InEpsilon(t, float64(got[0]), float64(got[1]), 0.25)
+			assert.InEpsilon(t, float64(defaultSmallFileWeight), float64(got[2])/float64(got[0]), 0.3)
+		},
+	)
+
+	t.Run(
+		"Slow clients leave their share to the others", func(t *testing.T) {
+			got := run([]int64{1 << 30, 1 << 30, 1 << 30}, []time.Duration{0, 0, 100 * time.Millisecond})
+			assert.Less(t, got[2], int64(expected/6))
+			assert.InEpsilon(t, expected, float64(sum(got)), 0.25)
+			assert.InEpsilon(t, float64(got[0]), float64(got[1]), 0.25)
+		},
+	)
+
+	t.Run(
+		"Streams are counted by class", func(t *testing.T) {
+			testDir := t.TempDir()
+
+			hdl := New(
+				port, testDir, &config.HTTPConfig{
+					DefaultPage: 1,
+					DefaultSize: 10,
+					Bandwidth:   &config.BandwidthConfig{Budget: budget},
+				},
+			)
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, "clip.mp4"), []byte(strings.Repeat("v", 1000)), 0644))
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream/clip.mp4", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, 1000, rec.Body.Len())
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			assert.Contains(t, rec.Body.String(), "stream_small_bytes_total 1000\n")
+			assert.Contains(t, rec.Body.String(), "stream_small_active 0\n")
+			assert.Contains(t, rec.Body.String(), "stream_large_active 0\n")
+		},
+	)
+}
@@ -0,0 +1,251 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/pkg/config"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	BasicScopeWrite = "write"
+	BasicScopeAll   = "all"
+
+	defaultBasicRealm = "media-server"
+)
+
+type basicUserKey struct{}
+
+// basicAuth checks usernames and passwords against the inline users and
+// those of the htpasswd file, which it re-reads once its mtime or size
+// changes.
+type basicAuth struct {
+	realm  string
+	all    bool
+	inline map[string]string
+	file   string
+
+	mu        sync.Mutex
+	fileUsers map[string]string
+	modTime   time.Time
+	size      int64
+
+	// dummy is compared against for unknown users, so they take as long to
+	// refuse as wrong passwords.
+	dummy []byte
+}
+
+func newBasicAuth(cfg *config.BasicAuthConfig) (*basicAuth, error) {
+	b := &basicAuth{
+		realm:  cfg.Realm,
+		inline: make(map[string]string, len(cfg.Users)),
+		file:   cfg.HtpasswdFile,
+	}
+	if b.realm == "" {
+		b.realm = defaultBasicRealm
+	}
+	if strings.ContainsAny(b.realm, "\"\\") {
+		return nil, errors.New("realm must not contain quotes or backslashes")
+	}
+
+	switch cfg.Scope {
+	case "", BasicScopeWrite:
+	case BasicScopeAll:
+		b.all = true
+	default:
+		return nil, fmt.Errorf("unknown scope %q", cfg.Scope)
+	}
+
+	for _, u := range cfg.Users {
+		if err := validBasicUser(u.Name); err != nil {
+			return nil, err
+		}
+		if u.Password == "" {
+			return nil, fmt.Errorf("user %q has no password", u.Name)
+		}
+		if _, ok := b.inline[u.Name]; ok {
+			return nil, fmt.Errorf("user %q is defined twice", u.Name)
+		}
+		b.inline[u.Name] = u.Password
+	}
+
+	if b.file != "" {
+		info, err := os.Stat(b.file)
+		if err != nil {
+			return nil, err
+		}
+		if b.fileUsers, err = readHtpasswd(b.file); err != nil {
+			return nil, err
+		}
+		b.modTime, b.size = info.ModTime(), info.Size()
+	}
+
+	secret := make([]byte, 16)
+	rand.Read(secret)
+	dummy, err := bcrypt.GenerateFromPassword(secret, bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	b.dummy = dummy
+	return b, nil
+}
+
+func validBasicUser(name string) error {
+	switch {
+	case name == "":
+		return errors.New("user without a name")
+	case name == principalAdmin || name == principalAnonymous || name == "*":
+		return fmt.Errorf("user name %q is reserved", name)
+	case strings.Contains(name, ":"):
+		return fmt.Errorf("user name %q contains a colon", name)
+	}
+	return nil
+}
+
+// readHtpasswd reads the user:hash lines of an htpasswd file. Only bcrypt
+// hashes are accepted, as written by htpasswd -B.
+func readHtpasswd(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: missing colon", path, n)
+		}
+		if err = validBasicUser(name); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		if _, err = bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s:%d: user %q: not a bcrypt hash", path, n, name)
+		}
+		users[name] = hash
+	}
+	return users, sc.Err()
+}
+
+// mayExist reports whether name is a user, or may become one once added to
+// the htpasswd file, for policy rules to name.
+func (b *basicAuth) mayExist(name string) bool {
+	if _, ok := b.inline[name]; ok {
+		return true
+	}
+	return b.file != "" && validBasicUser(name) == nil
+}
+
+// password returns the password or hash of name, inline users first. A
+// changed htpasswd file is read again; when it no longer parses the users
+// read before are kept.
+func (b *basicAuth) password(name string) (string, bool) {
+	if pw, ok := b.inline[name]; ok {
+		return pw, true
+	}
+	if b.file == "" {
+		return "", false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if info, err := os.Stat(b.file); err != nil {
+		log.Printf("Error reading htpasswd file: %s\n", err)
+	} else if !info.ModTime().Equal(b.modTime) || info.Size() != b.size {
+		b.modTime, b.size = info.ModTime(), info.Size()
+		if users, err := readHtpasswd(b.file); err != nil {
+			log.Printf("Error reading htpasswd file: %s\n", err)
+		} else {
+			b.fileUsers = users
+		}
+	}
+	pw, ok := b.fileUsers[name]
+	return pw, ok
+}
+
+// check reports whether pass is the password of name, in time that does not
+// depend on how much of it is right or on whether name exists.
+func (b *basicAuth) check(name, pass string) bool {
+	secret, ok := b.password(name)
+	if !ok {
+		bcrypt.CompareHashAndPassword(b.dummy, []byte(pass))
+		return false
+	}
+	if _, err := bcrypt.Cost([]byte(secret)); err == nil {
+		return bcrypt.CompareHashAndPassword([]byte(secret), []byte(pass)) == nil
+	}
+
+	want, got := sha256.Sum256([]byte(secret)), sha256.Sum256([]byte(pass))
+	return subtle.ConstantTimeCompare(want[:], got[:]) == 1
+}
+
+// requires reports whether requests needing action must authenticate.
+func (b *basicAuth) requires(action string) bool {
+	switch action {
+	case "":
+		return false
+	case ActionDownload, ActionList:
+		return b.all
+	default:
+		return true
+	}
+}
+
+// authenticate checks the Basic credentials a request to pattern carries
+// and keeps the user on the request for principal. Requests without any,
+// and without a token, are refused with 401 where the scope covers the
+// route; wrong credentials are refused everywhere but on public routes.
+func (h *Handler) authenticate(pattern string, next http.Handler) http.Handler {
+	action := routeAction(pattern)
+	if h.basic == nil || action == "" {
+		return next
+	}
+
+	required := h.basic.requires(action)
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if name, pass, ok := r.BasicAuth(); ok {
+				if !h.basic.check(name, pass) {
+					h.audit.Log("access.unauthenticated", "", r.RemoteAddr, map[string]any{"user": name})
+					h.challenge(w)
+					return
+				}
+				r = r.WithContext(context.WithValue(r.Context(), basicUserKey{}, name))
+			} else if required && h.principal(r) == principalAnonymous && !bearerMatches(r, h.config.ReadToken) {
+				h.challenge(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		},
+	)
+}
+
+func (h *Handler) challenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="`+h.basic.realm+`", charset="UTF-8"`)
+	writeError(w, ErrAuthRequired)
+}
+
+// basicUser returns the user r signed in as with Basic credentials.
+func basicUser(r *http.Request) (string, bool) {
+	name, ok := r.Context().Value(basicUserKey{}).(string)
+	return name, ok
+}
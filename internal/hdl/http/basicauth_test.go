@@ -0,0 +1,172 @@
+package http
+
+import (
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBasicAuth(t *testing.T) {
+	testDir := t.TempDir()
+
+	hash := func(pass string) string {
+		h, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.MinCost)
+		assert.Nil(t, err)
+		return string(h)
+	}
+	htpasswd := filepath.Join(t.TempDir(), ".htpasswd")
+	assert.Nil(t, os.WriteFile(htpasswd, []byte("# users\nviewer:"+hash("viewer-pw")+"\n"), 0644))
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize: 1024,
+			AdminToken:    "admin-secret",
+			BasicAuth: &config.BasicAuthConfig{
+				Realm: "home",
+				Users: []*config.BasicUserConfig{
+					{Name: "me", Password: "plain-pw"},
+					{Name: "hashed", Password: hash("hashed-pw")},
+				},
+				HtpasswdFile: htpasswd,
+			},
+			Policy: &config.PolicyConfig{
+				Rules: []*config.PolicyRule{
+					{Principals: []string{"me", "hashed"}, Actions: []string{"*"}},
+					{Principals: []string{"viewer", "late"}, Actions: []string{"download", "list"}},
+					{Principals: []string{"anonymous"}, Actions: []string{"download"}},
+				},
+			},
+		},
+	)
+	do := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	upload := func(name, user, pass string) *httptest.ResponseRecorder {
+		req := newUploadRequest(name, "content", nil)
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		return do(req)
+	}
+
+	t.Run(
+		"Writes need credentials", func(t *testing.T) {
+			rec := upload("anon.txt", "", "")
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+			assert.Equal(t, `Basic realm="home", charset="UTF-8"`, rec.Header().Get("WWW-Authenticate"))
+			assert.Contains(t, rec.Body.String(), "auth_required")
+
+			rec = upload("wrong.txt", "me", "not-it")
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+			rec = upload("nobody.txt", "nobody", "plain-pw")
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+			assert.Equal(t, http.StatusCreated, upload("plain.txt", "me", "plain-pw").Code)
+			assert.Equal(t, http.StatusCreated, upload("hashed.txt", "hashed", "hashed-pw").Code)
+		},
+	)
+
+	t.Run(
+		"Reads stay open", func(t *testing.T) {
+			rec := do(httptest.NewRequest(http.MethodGet, "/uploads/plain.txt", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			rec = do(httptest.NewRequest(http.MethodGet, "/capabilities", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Tokens still work", func(t *testing.T) {
+			req := newUploadRequest("token.txt", "content", nil)
+			req.Header.Set("Authorization", "Bearer admin-secret")
+			assert.Equal(t, http.StatusCreated, do(req).Code)
+		},
+	)
+
+	t.Run(
+		"Read-only user", func(t *testing.T) {
+			rec := upload("viewer.txt", "viewer", "viewer-pw")
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+			assert.Contains(t, rec.Body.String(), "access_denied")
+
+			req := httptest.NewRequest(http.MethodGet, "/list?page=1&size=10", nil)
+			req.SetBasicAuth("viewer", "viewer-pw")
+			assert.Equal(t, http.StatusOK, do(req).Code)
+
+			req = httptest.NewRequest(http.MethodDelete, "/files/plain.txt", nil)
+			req.SetBasicAuth("viewer", "viewer-pw")
+			assert.Equal(t, http.StatusForbidden, do(req).Code)
+		},
+	)
+
+	t.Run(
+		"Htpasswd is re-read", func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/list?page=1&size=10", nil)
+			req.SetBasicAuth("late", "late-pw")
+			assert.Equal(t, http.StatusUnauthorized, do(req).Code)
+
+			data := "viewer:" + hash("viewer-pw") + "\nlate:" + hash("late-pw") + "\n"
+			assert.Nil(t, os.WriteFile(htpasswd, []byte(data), 0644))
+			later := time.Now().Add(time.Minute)
+			assert.Nil(t, os.Chtimes(htpasswd, later, later))
+			assert.Equal(t, http.StatusOK, do(req).Code)
+
+			assert.Nil(t, os.WriteFile(htpasswd, []byte("late:md5-is-not-accepted\n"), 0644))
+			later = later.Add(time.Minute)
+			assert.Nil(t, os.Chtimes(htpasswd, later, later))
+			assert.Equal(t, http.StatusOK, do(req).Code)
+		},
+	)
+
+	t.Run(
+		"Scope all", func(t *testing.T) {
+			all := New(
+				port, testDir, &config.HTTPConfig{
+					MaxUploadSize: 1024,
+					BasicAuth: &config.BasicAuthConfig{
+						Scope: BasicScopeAll,
+						Users: []*config.BasicUserConfig{{Name: "me", Password: "plain-pw"}},
+					},
+				},
+			)
+			serve := func(req *http.Request) int {
+				rec := httptest.NewRecorder()
+				all.ServeHTTP(rec, req)
+				return rec.Code
+			}
+
+			assert.Equal(t, http.StatusUnauthorized, serve(httptest.NewRequest(http.MethodGet, "/uploads/plain.txt", nil)))
+			assert.Equal(t, http.StatusOK, serve(httptest.NewRequest(http.MethodGet, "/readyz", nil)))
+
+			req := httptest.NewRequest(http.MethodGet, "/uploads/plain.txt", nil)
+			req.SetBasicAuth("me", "plain-pw")
+			assert.Equal(t, http.StatusOK, serve(req))
+
+			req = httptest.NewRequest(http.MethodGet, "/stats", nil)
+			req.SetBasicAuth("me", "plain-pw")
+			assert.Equal(t, http.StatusOK, serve(req))
+		},
+	)
+
+	t.Run(
+		"Invalid config", func(t *testing.T) {
+			_, err := newBasicAuth(&config.BasicAuthConfig{Scope: "some"})
+			assert.NotNil(t, err)
+			_, err = newBasicAuth(&config.BasicAuthConfig{Users: []*config.BasicUserConfig{{Name: "admin", Password: "x"}}})
+			assert.NotNil(t, err)
+
+			bad := filepath.Join(t.TempDir(), "htpasswd")
+			assert.Nil(t, os.WriteFile(bad, []byte("me:{SHA}abc\n"), 0644))
+			_, err = newBasicAuth(&config.BasicAuthConfig{HtpasswdFile: bad})
+			assert.NotNil(t, err)
+		},
+	)
+}
@@ -0,0 +1,310 @@
+package http
+
+import (
+	"cmp"
+	"errors"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BrowseSortName     = "name"
+	BrowseSortSize     = "size"
+	BrowseSortModified = "mtime"
+
+	defaultBrowsePageSize = 1000
+)
+
+// BrowseEntry is a file or directory in a browsed directory. Name is its
+// path from the top of the save path.
+type BrowseEntry struct {
+	Name    string    `json:"name"`
+	Dir     bool      `json:"dir,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	URL     string    `json:"url"`
+}
+
+type browseLink struct {
+	Name string
+	URL  string
+}
+
+type browseRow struct {
+	BrowseEntry
+	Base   string
+	Stream string
+}
+
+type browsePage struct {
+	Dir     string
+	Crumbs  []browseLink
+	Columns []browseLink
+	Rows    []browseRow
+	Prev    string
+	Next    string
+	Hint    string
+}
+
+var browseTemplate = template.Must(
+	template.New("browse").Parse(
+		`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Index of /{{.Dir}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.2em 1em; text-align: left; }
+td.size { text-align: right; }
+</style>
+</head>
+<body>
+<h1>Index of {{range $i, $c := .Crumbs}}{{if $i}} / {{end}}<a href="{{$c.URL}}">{{$c.Name}}</a>{{end}}</h1>
+<table>
+<tr>{{range .Columns}}<th><a href="{{.URL}}">{{.Name}}</a></th>{{end}}<th></th></tr>
+{{range .Rows}}<tr>
+<td><a href="{{.URL}}">{{.Base}}{{if .Dir}}/{{end}}</a></td>
+<td class="size">{{if not .Dir}}{{.Size}}{{end}}</td>
+<td>{{.ModTime.Format "2006-01-02 15:04:05"}}</td>
+<td>{{if .Stream}}<a href="{{.Stream}}">stream</a>{{end}}</td>
+</tr>
+{{end}}</table>
+{{if .Hint}}<p>{{.Hint}}</p>{{end}}
+<p>{{if .Prev}}<a href="{{.Prev}}">previous</a>{{end}} {{if .Next}}<a href="{{.Next}}">next</a>{{end}}</p>
+</body>
+</html>
+`,
+	),
+)
+
+func (h *Handler) browsePageSize() int {
+	if h.config.Browse.PageSize > 0 {
+		return h.config.Browse.PageSize
+	}
+	return defaultBrowsePageSize
+}
+
+// readDir lists dir, a slash path from the top of the save path, merging
+// its entries under every storage root. Hidden entries are left out unless
+// withHidden, and so are the ones the principal of r may not list. It
+// reports fs.ErrNotExist when no root has dir.
+func (h *Handler) readDir(r *http.Request, dir string, withHidden bool) ([]BrowseEntry, bool, error) {
+	principal := h.principal(r)
+	seen := make(map[string]bool)
+	found := false
+	res := make([]BrowseEntry, 0)
+	for _, root := range h.rootDirs() {
+		truncated, err := h.walkDir(
+			r.Context(), filepath.Join(root, filepath.FromSlash(dir)), false, func(p string, d fs.DirEntry) error {
+				name := path.Join(dir, d.Name())
+				if seen[name] || isCachePath(name) || (!withHidden && h.hidden(name)) {
+					return nil
+				}
+				if d.Type()&fs.ModeSymlink != 0 && (dir != "" || !h.listable(d)) {
+					return nil
+				}
+				seen[name] = true
+
+				info, err := os.Stat(p)
+				if err != nil {
+					return nil
+				}
+				if h.policy != nil {
+					allowed := h.policy.evaluate(principal, ActionList, name).Allowed
+					if info.IsDir() {
+						allowed = h.policy.evaluateUnder(principal, ActionList, name).Allowed
+					}
+					if !allowed {
+						return nil
+					}
+				}
+
+				e := BrowseEntry{Name: name, Dir: info.IsDir(), ModTime: info.ModTime().UTC()}
+				if e.Dir {
+					e.URL = h.browseURL(r, name)
+				} else {
+					e.Size, e.URL = info.Size(), h.fileURL(r, name)
+				}
+				res = append(res, e)
+				return nil
+			},
+		)
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrInvalid) {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		found = true
+		if truncated {
+			return res, true, nil
+		}
+	}
+	if !found {
+		return nil, false, fs.ErrNotExist
+	}
+	return res, false, nil
+}
+
+// sortBrowse orders entries by key, directories first.
+func sortBrowse(entries []BrowseEntry, key string, desc bool) {
+	slices.SortFunc(
+		entries, func(a, b BrowseEntry) int {
+			if a.Dir != b.Dir {
+				if a.Dir {
+					return -1
+				}
+				return 1
+			}
+
+			var c int
+			switch key {
+			case BrowseSortSize:
+				c = cmp.Compare(a.Size, b.Size)
+			case BrowseSortModified:
+				c = a.ModTime.Compare(b.ModTime)
+			}
+			c = cmp.Or(c, strings.Compare(a.Name, b.Name))
+			if desc {
+				return -c
+			}
+			return c
+		},
+	)
+}
+
+func (h *Handler) browseURL(r *http.Request, dir string) string {
+	p := "/browse/"
+	if dir != "" {
+		p += dir + "/"
+	}
+	return h.baseURL(r) + (&url.URL{Path: p}).EscapedPath()
+}
+
+func (h *Handler) browse(w http.ResponseWriter, r *http.Request) {
+	if h.config.Browse == nil {
+		writeError(w, ErrBrowseDisabled)
+		return
+	}
+
+	dir := strings.Trim(strings.TrimPrefix(path.Clean(r.URL.Path), "/browse"), "/")
+	if dir != "" && !h.checkInternal(w, r, dir) {
+		return
+	}
+	withHidden, err := h.showHidden(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	asJSON := false
+	switch q.Get("format") {
+	case "", "html":
+	case "json":
+		asJSON = true
+	default:
+		writeError(w, ErrInvalidFormat)
+		return
+	}
+	key := cmp.Or(q.Get("sort"), BrowseSortName)
+	if key != BrowseSortName && key != BrowseSortSize && key != BrowseSortModified {
+		writeError(w, ErrInvalidSort)
+		return
+	}
+	desc := q.Get("order") == "desc"
+
+	entries, truncated, err := h.readDir(r, dir, withHidden)
+	if errors.Is(err, fs.ErrNotExist) {
+		writeError(w, ErrNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error browsing %q: %s\n", dir, err)
+		writeError(w, canceledOr(err, ErrReadingDir))
+		return
+	}
+	sortBrowse(entries, key, desc)
+
+	page, size := utils.ParsePaginationParams(r, 1, h.browsePageSize())
+	size = min(size, h.browsePageSize())
+	count := len(entries)
+	start := min((page-1)*size, count)
+	end := min(start+size, count)
+	totalPages := (count + size - 1) / size
+
+	if asJSON {
+		utils.SuccessPaginatedResponse(
+			w, http.StatusOK, utils.PaginatedResponse{
+				Data:        entries[start:end],
+				Count:       count,
+				TotalPages:  totalPages,
+				CurrentPage: page,
+				HasNextPage: end < count,
+				Truncated:   truncated,
+				Hint:        truncatedHint(truncated, h.maxWalkEntries()),
+			},
+		)
+		return
+	}
+
+	here := h.browseURL(r, dir)
+	query := func(v url.Values) string {
+		return here + "?" + v.Encode()
+	}
+	res := browsePage{Dir: dir, Hint: truncatedHint(truncated, h.maxWalkEntries())}
+	res.Crumbs = append(res.Crumbs, browseLink{Name: "root", URL: h.browseURL(r, "")})
+	if dir != "" {
+		parts := strings.Split(dir, "/")
+		for i := range parts {
+			res.Crumbs = append(res.Crumbs, browseLink{Name: parts[i], URL: h.browseURL(r, strings.Join(parts[:i+1], "/"))})
+		}
+	}
+	for _, c := range []browseLink{{"Name", BrowseSortName}, {"Size", BrowseSortSize}, {"Modified", BrowseSortModified}} {
+		v := url.Values{"sort": {c.URL}}
+		if c.URL == key && !desc {
+			v.Set("order", "desc")
+		}
+		res.Columns = append(res.Columns, browseLink{Name: c.Name, URL: query(v)})
+	}
+	for _, e := range entries[start:end] {
+		row := browseRow{BrowseEntry: e, Base: path.Base(e.Name)}
+		if !e.Dir {
+			row.Stream = h.baseURL(r) + (&url.URL{Path: "/stream/" + e.Name}).EscapedPath()
+		}
+		res.Rows = append(res.Rows, row)
+	}
+	pageURL := func(n int) string {
+		v := url.Values{"page": {strconv.Itoa(n)}, "sort": {key}}
+		if desc {
+			v.Set("order", "desc")
+		}
+		return query(v)
+	}
+	if page > 1 && start > 0 {
+		res.Prev = pageURL(page - 1)
+	}
+	if end < count {
+		res.Next = pageURL(page + 1)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	if err = browseTemplate.Execute(w, res); err != nil {
+		log.Printf("Error rendering index of %q: %s\n", dir, err)
+	}
+}
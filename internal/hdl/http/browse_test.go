@@ -0,0 +1,169 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBrowse(t *testing.T) {
+	testDir := t.TempDir()
+
+	seed := func(name, content string, mtime time.Time) {
+		p := filepath.Join(testDir, filepath.FromSlash(name))
+		assert.Nil(t, os.MkdirAll(filepath.Dir(p), os.ModePerm))
+		assert.Nil(t, os.WriteFile(p, []byte(content), 0644))
+		assert.Nil(t, os.Chtimes(p, mtime, mtime))
+	}
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	seed("a.mp4", "aaaaa", base.Add(2*time.Hour))
+	seed("b.mp4", "b", base)
+	seed("c.mp4", "ccc", base.Add(time.Hour))
+	seed("<img src=x onerror=alert(1)>.mp4", "x", base)
+	seed(".secret", "s", base)
+	seed("shows/s01/e01.mp4", "e01", base)
+	seed("private/notes.txt", "n", base)
+
+	newHandler := func(cfg *config.HTTPConfig) *Handler {
+		cfg.MaxUploadSize = 1 << 20
+		cfg.MaxStreamBuffer = 1024
+		cfg.AdminToken = "admin-secret"
+		return New(port, testDir, cfg)
+	}
+	hdl := newHandler(&config.HTTPConfig{Browse: &config.BrowseConfig{}})
+	get := func(hdl *Handler, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	names := func(hdl *Handler, target, token string) []string {
+		rec := get(hdl, target, token)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var entries []BrowseEntry
+		res := utils.PaginatedResponse{Data: &entries}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		out := make([]string, 0, len(entries))
+		for _, e := range entries {
+			out = append(out, e.Name)
+		}
+		return out
+	}
+
+	t.Run(
+		"Disabled by default", func(t *testing.T) {
+			rec := get(newHandler(&config.HTTPConfig{}), "/browse/", "")
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+			assert.Contains(t, rec.Body.String(), "browse_disabled")
+		},
+	)
+
+	t.Run(
+		"HTML listing", func(t *testing.T) {
+			rec := get(hdl, "/browse/", "")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+			body := rec.Body.String()
+			assert.Contains(t, body, `href="/browse/shows/"`)
+			assert.Contains(t, body, `href="/stream/a.mp4"`)
+			assert.Contains(t, body, `href="/`+filepath.ToSlash(testDir)+`/a.mp4"`)
+			assert.NotContains(t, body, ".secret")
+
+			rec = get(hdl, "/browse/shows/s01/", "")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			body = rec.Body.String()
+			assert.Contains(t, body, `<a href="/browse/">root</a> / <a href="/browse/shows/">shows</a> / <a href="/browse/shows/s01/">s01</a>`)
+			assert.Contains(t, body, `href="/stream/shows/s01/e01.mp4"`)
+
+			assert.Equal(t, http.StatusNotFound, get(hdl, "/browse/missing/", "").Code)
+		},
+	)
+
+	t.Run(
+		"Names are escaped", func(t *testing.T) {
+			body := get(hdl, "/browse/", "").Body.String()
+			assert.NotContains(t, body, "<img")
+			assert.Contains(t, body, "&lt;img src=x onerror=alert(1)&gt;.mp4")
+		},
+	)
+
+	t.Run(
+		"Sorting", func(t *testing.T) {
+			all := []string{"private", "shows", "<img src=x onerror=alert(1)>.mp4", "a.mp4", "b.mp4", "c.mp4"}
+			assert.Equal(t, all, names(hdl, "/browse/?format=json", ""))
+			assert.Equal(
+				t, []string{"shows", "private", "c.mp4", "b.mp4", "a.mp4", "<img src=x onerror=alert(1)>.mp4"},
+				names(hdl, "/browse/?format=json&order=desc", ""),
+			)
+			assert.Equal(
+				t, []string{"private", "shows", "<img src=x onerror=alert(1)>.mp4", "b.mp4", "c.mp4", "a.mp4"},
+				names(hdl, "/browse/?format=json&sort=size", ""),
+			)
+			assert.Equal(
+				t, []string{"a.mp4", "c.mp4", "b.mp4", "<img src=x onerror=alert(1)>.mp4"},
+				names(hdl, "/browse/?format=json&sort=mtime&order=desc", "")[2:],
+			)
+
+			assert.Equal(t, http.StatusBadRequest, get(hdl, "/browse/?sort=owner", "").Code)
+			assert.Equal(t, http.StatusBadRequest, get(hdl, "/browse/?format=xml", "").Code)
+		},
+	)
+
+	t.Run(
+		"JSON", func(t *testing.T) {
+			rec := get(hdl, "/browse/shows/s01/?format=json", "")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			var entries []BrowseEntry
+			res := utils.PaginatedResponse{Data: &entries}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.Equal(t, 1, res.Count)
+			if assert.Len(t, entries, 1) {
+				assert.Equal(t, "shows/s01/e01.mp4", entries[0].Name)
+				assert.Equal(t, int64(3), entries[0].Size)
+				assert.True(t, base.Equal(entries[0].ModTime))
+			}
+
+			assert.Equal(t, []string{"private", "shows"}, names(hdl, "/browse/?format=json&size=2", ""))
+			assert.Equal(t, []string{"<img src=x onerror=alert(1)>.mp4", "a.mp4"}, names(hdl, "/browse/?format=json&page=2&size=2", ""))
+		},
+	)
+
+	t.Run(
+		"Hidden", func(t *testing.T) {
+			assert.NotContains(t, names(hdl, "/browse/?format=json", ""), ".secret")
+			assert.Contains(t, names(hdl, "/browse/?format=json&include=hidden", "admin-secret"), ".secret")
+			assert.Equal(t, http.StatusForbidden, get(hdl, "/browse/?include=hidden", "").Code)
+		},
+	)
+
+	t.Run(
+		"Policy prefixes", func(t *testing.T) {
+			hdl := newHandler(
+				&config.HTTPConfig{
+					Browse: &config.BrowseConfig{},
+					Policy: &config.PolicyConfig{
+						Principals: []*config.PrincipalConfig{{Name: "viewer", Token: "viewer-key"}},
+						Rules: []*config.PolicyRule{
+							{Principals: []string{"viewer"}, Actions: []string{"list"}, Prefixes: []string{"shows/"}},
+						},
+					},
+				},
+			)
+
+			assert.Equal(t, []string{"shows"}, names(hdl, "/browse/?format=json", "viewer-key"))
+			assert.Equal(t, []string{"shows/s01"}, names(hdl, "/browse/shows/?format=json", "viewer-key"))
+			assert.Equal(t, http.StatusForbidden, get(hdl, "/browse/private/", "viewer-key").Code)
+			assert.Equal(t, http.StatusForbidden, get(hdl, "/browse/", "").Code)
+		},
+	)
+}
@@ -0,0 +1,100 @@
+package http
+
+import (
+	"io"
+	"math/bits"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const (
+	minPooledBuffer   = 512
+	largeStreamBuffer = 256 << 10
+	largeStreamFile   = 8 << 20
+	maxBufferOverride = 4 << 20
+	bufferPoolClasses = 24
+	unknownBodyLength = -1
+	streamBufferParam = "buffer"
+)
+
+// bufferPools holds one pool per power-of-two size class, so buffers of
+// similar sizes are shared between requests regardless of who asked.
+var bufferPools [bufferPoolClasses]sync.Pool
+
+func bufferClass(n int) int {
+	if n <= minPooledBuffer {
+		n = minPooledBuffer
+	}
+	return bits.Len(uint(n - 1))
+}
+
+func getBuffer(n int) *[]byte {
+	class := bufferClass(n)
+	if class >= bufferPoolClasses {
+		b := make([]byte, n)
+		return &b
+	}
+
+	if b, ok := bufferPools[class].Get().(*[]byte); ok {
+		*b = (*b)[:n]
+		return b
+	}
+	b := make([]byte, n, 1<<class)
+	return &b
+}
+
+func putBuffer(b *[]byte) {
+	class := bufferClass(cap(*b))
+	if class >= bufferPoolClasses || cap(*b) != 1<<class {
+		return
+	}
+	bufferPools[class].Put(b)
+}
+
+func (h *Handler) bufferOverride(r *http.Request) (int, error) {
+	v := r.URL.Query().Get(streamBufferParam)
+	if v == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, ErrInvalidBuffer
+	}
+	return min(n, maxBufferOverride), nil
+}
+
+// streamBuffer picks the read buffer for a body of the given length: bodies
+// that fit the configured buffer are read in one shot, large files get a
+// bigger buffer, and an explicit override wins over both.
+func (h *Handler) streamBuffer(length int64, override int) int {
+	if override > 0 {
+		return override
+	}
+
+	size := h.config.MaxStreamBuffer
+	if size <= 0 {
+		size = defaultStreamBuffer
+	}
+	switch {
+	case length == unknownBodyLength:
+		return size
+	case length < int64(size):
+		return max(int(length), 1)
+	case length >= largeStreamFile:
+		return max(size, largeStreamBuffer)
+	default:
+		return size
+	}
+}
+
+func bodyLength(file io.Reader) int64 {
+	if f, ok := file.(interface{ Stat() (os.FileInfo, error) }); ok {
+		if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+			return info.Size()
+		}
+	}
+	return unknownBodyLength
+}
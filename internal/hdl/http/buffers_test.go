@@ -0,0 +1,121 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStreamBuffer(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := New(port, testDir, &config.HTTPConfig{MaxStreamBuffer: 1024})
+
+	t.Run(
+		"Adaptive size", func(t *testing.T) {
+			assert.Equal(t, 300, hdl.streamBuffer(300, 0))
+			assert.Equal(t, 1, hdl.streamBuffer(0, 0))
+			assert.Equal(t, 1024, hdl.streamBuffer(1<<20, 0))
+			assert.Equal(t, largeStreamBuffer, hdl.streamBuffer(largeStreamFile, 0))
+			assert.Equal(t, 1024, hdl.streamBuffer(unknownBodyLength, 0))
+			assert.Equal(t, 64, hdl.streamBuffer(largeStreamFile, 64))
+
+			fallback := New(port, testDir, &config.HTTPConfig{})
+			assert.Equal(t, defaultStreamBuffer, fallback.streamBuffer(unknownBodyLength, 0))
+		},
+	)
+
+	t.Run(
+		"Pooled buffers", func(t *testing.T) {
+			b := getBuffer(3000)
+			assert.Len(t, *b, 3000)
+			assert.Equal(t, 4096, cap(*b))
+			putBuffer(b)
+
+			b = getBuffer(100)
+			assert.Len(t, *b, 100)
+			assert.Equal(t, minPooledBuffer, cap(*b))
+			putBuffer(b)
+		},
+	)
+
+	t.Run(
+		"Override", func(t *testing.T) {
+			content := bytes.Repeat([]byte("0123456789"), 500)
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, "clip.mp4"), content, 0644))
+
+			for _, q := range []string{"", "?buffer=7", "?buffer=999999999"} {
+				rec := httptest.NewRecorder()
+				hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream/clip.mp4"+q, nil))
+				assert.Equal(t, http.StatusOK, rec.Code, q)
+				assert.Equal(t, content, rec.Body.Bytes(), q)
+			}
+
+			for _, q := range []string{"?buffer=0", "?buffer=-1", "?buffer=big"} {
+				rec := httptest.NewRecorder()
+				hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream/clip.mp4"+q, nil))
+				assert.Equal(t, http.StatusBadRequest, rec.Code, q)
+			}
+
+			override, err := hdl.bufferOverride(httptest.NewRequest(http.MethodGet, "/?buffer=999999999", nil))
+			assert.Nil(t, err)
+			assert.Equal(t, maxBufferOverride, override)
+		},
+	)
+}
+
+func fixedChunks(w io.Writer, body io.Reader, size int) error {
+	buffer := make([]byte, size)
+	for {
+		n, err := body.Read(buffer)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		if _, err := w.Write(buffer[:n]); err != nil {
+			return err
+		}
+	}
+}
+
+func BenchmarkStreamChunks(b *testing.B) {
+	hdl := &Handler{config: &config.HTTPConfig{MaxStreamBuffer: defaultStreamBuffer}}
+
+	for _, size := range []int{2 << 10, 16 << 20} {
+		data := bytes.Repeat([]byte{'x'}, size)
+
+		b.Run(
+			fmt.Sprintf("Fixed/%d", size), func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(size))
+				for range b.N {
+					if err := fixedChunks(io.Discard, bytes.NewReader(data), defaultStreamBuffer); err != nil {
+						b.Fatal(err)
+					}
+				}
+			},
+		)
+
+		b.Run(
+			fmt.Sprintf("Adaptive/%d", size), func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(size))
+				for range b.N {
+					buf := hdl.streamBuffer(int64(size), 0)
+					if err := streamChunks(io.Discard, bytes.NewReader(data), buf); err != nil {
+						b.Fatal(err)
+					}
+				}
+			},
+		)
+	}
+}
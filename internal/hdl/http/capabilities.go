@@ -0,0 +1,112 @@
+package http
+
+import (
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// apiVersion is the version of the HTTP API, bumped on changes that break
+// clients.
+const apiVersion = 1
+
+// Capabilities describes the optional features a deployment has enabled and
+// the limits they come with, so clients can pick how to upload and download
+// without probing. It is built from the live config; features that are off
+// are false or left out.
+type Capabilities struct {
+	APIVersion int                    `json:"api_version"`
+	Upload     UploadCapabilities     `json:"upload"`
+	Download   DownloadCapabilities   `json:"download"`
+	Multipart  *MultipartCapabilities `json:"multipart,omitempty"`
+	Images     *ImageCapabilities     `json:"images,omitempty"`
+	Transcode  []string               `json:"transcode_presets,omitempty"`
+	Checksums  []string               `json:"checksums"`
+	Features   []string               `json:"features"`
+}
+
+type UploadCapabilities struct {
+	MaxUploadSize    int64    `json:"max_upload_size"`
+	MaxFileSize      int64    `json:"max_file_size"`
+	MaxFilenameBytes int      `json:"max_filename_bytes,omitempty"`
+	Fields           []string `json:"fields"`
+	Naming           string   `json:"naming"`
+	EmptyFiles       bool     `json:"empty_files"`
+	Resumable        bool     `json:"resumable"`
+	Idempotency      bool     `json:"idempotency"`
+	Receipts         bool     `json:"receipts"`
+}
+
+type DownloadCapabilities struct {
+	Ranges     bool     `json:"ranges"`
+	MaxRanges  int      `json:"max_ranges"`
+	SignedURLs bool     `json:"signed_urls"`
+	NeverServe []string `json:"never_serve_types,omitempty"`
+}
+
+type MultipartCapabilities struct {
+	MinPartSize int64 `json:"min_part_size"`
+	MaxParts    int   `json:"max_parts"`
+	TTLSeconds  int64 `json:"ttl_seconds"`
+}
+
+type ImageCapabilities struct {
+	Presets          []string `json:"presets"`
+	PresetsOnly      bool     `json:"presets_only"`
+	RequireSignature bool     `json:"require_signature"`
+	Negotiate        bool     `json:"negotiate"`
+	MaxDimension     int      `json:"max_dimension,omitempty"`
+}
+
+func (h *Handler) capabilities() *Capabilities {
+	naming, _ := h.naming("")
+	res := &Capabilities{
+		APIVersion: apiVersion,
+		Upload: UploadCapabilities{
+			MaxUploadSize:    h.config.MaxUploadSize,
+			MaxFileSize:      h.maxFileSize(),
+			MaxFilenameBytes: h.config.MaxFilenameBytes,
+			Fields:           h.uploadFields(),
+			Naming:           naming,
+			EmptyFiles:       h.allowEmptyFiles(),
+			// Files encrypted at rest cannot be written in place.
+			Resumable:   h.crypt == nil,
+			Idempotency: h.config.Idempotency != nil,
+			Receipts:    h.receipts != nil,
+		},
+		Download: DownloadCapabilities{
+			Ranges:     true,
+			MaxRanges:  h.maxRanges(),
+			SignedURLs: h.config.SigningSecret != "",
+			NeverServe: h.config.NeverServeTypes,
+		},
+		Checksums: slices.Sorted(maps.Keys(checksumAlgos)),
+		Features:  h.features(),
+	}
+	if h.config.Multipart != nil {
+		res.Multipart = &MultipartCapabilities{
+			MinPartSize: h.minPartSize(),
+			MaxParts:    h.maxParts(),
+			TTLSeconds:  int64(h.multipartTTL().Seconds()),
+		}
+	}
+	if cfg := h.config.Images; cfg != nil {
+		res.Images = &ImageCapabilities{
+			Presets:          slices.Sorted(maps.Keys(h.presets)),
+			PresetsOnly:      cfg.PresetsOnly,
+			RequireSignature: cfg.RequireSignature,
+			Negotiate:        cfg.Negotiate,
+			MaxDimension:     cfg.MaxDimension,
+		}
+	}
+	if cfg := h.config.Transcode; cfg != nil {
+		res.Transcode = slices.Sorted(maps.Keys(cfg.Presets))
+	}
+	return res
+}
+
+func (h *Handler) capabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.JSONResponse(w, http.StatusOK, h.capabilities())
+}
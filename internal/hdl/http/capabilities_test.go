@@ -0,0 +1,71 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func TestCapabilities(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize:   1024,
+			MaxRanges:       4,
+			Naming:          NamingSlug,
+			SigningSecret:   "secret",
+			NeverServeTypes: []string{"text/html"},
+			Multipart:       &config.MultipartConfig{MinPartSize: 16, MaxParts: 100},
+			Images: &config.ImagesConfig{
+				PresetsOnly: true,
+				Presets: map[string]string{
+					"thumb": "64x64 cover",
+					"card":  "320w",
+				},
+			},
+			Trash: &config.TrashConfig{},
+		},
+	)
+
+	t.Run(
+		"Golden", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/capabilities", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "public, max-age=300", rec.Header().Get("Cache-Control"))
+
+			var got bytes.Buffer
+			assert.Nil(t, json.Indent(&got, rec.Body.Bytes(), "", "  "))
+			got.WriteByte('\n')
+
+			golden := filepath.Join("testdata", "capabilities.json")
+			if *update {
+				assert.Nil(t, os.MkdirAll("testdata", os.ModePerm))
+				assert.Nil(t, os.WriteFile(golden, got.Bytes(), 0644))
+			}
+			want, err := os.ReadFile(golden)
+			assert.Nil(t, err)
+			assert.Equal(t, string(want), got.String())
+		},
+	)
+
+	t.Run(
+		"Disabled features are left out", func(t *testing.T) {
+			caps := New(port, testDir, &config.HTTPConfig{MaxUploadSize: 1024}).capabilities()
+			assert.Nil(t, caps.Multipart)
+			assert.Nil(t, caps.Images)
+			assert.False(t, caps.Download.SignedURLs)
+			assert.Equal(t, []string{}, caps.Features)
+		},
+	)
+}
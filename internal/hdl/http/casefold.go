@@ -0,0 +1,62 @@
+package http
+
+import (
+	"context"
+	"github.com/JMURv/media-server/internal/storage"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// detectCase probes b, normally the save path, for case sensitivity. On a
+// case-insensitive save path names that differ only in case are one file,
+// so the handler enforces that in conflict checks, the metadata catalog and
+// lookups instead of silently overwriting one with the other.
+func (h *Handler) detectCase(ctx context.Context, b storage.Backend) {
+	insensitive, err := storage.CaseInsensitive(ctx, b)
+	if err != nil {
+		log.Printf("Error probing case sensitivity, assuming case-sensitive names: %s\n", err)
+	}
+	if insensitive {
+		log.Println("Save path is case-insensitive; names differing only in case refer to one file")
+	}
+	h.caseInsensitive = insensitive
+	h.meta.FoldCase(insensitive)
+}
+
+// resolveName returns the name name is stored under: name itself when the
+// save path is case-sensitive or holds it exactly, otherwise the first entry
+// of its directory equal to it ignoring case. ok is false if neither exists.
+func (h *Handler) resolveName(name string) (string, bool) {
+	full := h.filePath(name)
+	if !h.caseInsensitive {
+		_, err := os.Lstat(full)
+		return name, err == nil
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(full))
+	if err != nil {
+		return name, false
+	}
+	base, match := path.Base(name), ""
+	for _, e := range entries {
+		if e.Name() == base {
+			return name, true
+		}
+		if match == "" && strings.EqualFold(e.Name(), base) {
+			match = e.Name()
+		}
+	}
+	if match == "" {
+		return name, false
+	}
+	return path.Join(path.Dir(name), match), true
+}
+
+// existsError reports that name cannot be created because existing, which
+// may differ from it in case, is already stored.
+func existsError(name, existing string) error {
+	return withParams(ErrAlreadyExists, map[string]any{"name": name, "existing": existing})
+}
@@ -0,0 +1,106 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/storage"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCaseInsensitiveNames(t *testing.T) {
+	t.Run(
+		"Probing the save path leaves nothing behind", func(t *testing.T) {
+			dir := t.TempDir()
+			_, err := storage.CaseInsensitive(context.Background(), storage.NewLocal(dir))
+			assert.Nil(t, err)
+
+			entries, err := os.ReadDir(dir)
+			assert.Nil(t, err)
+			assert.Empty(t, entries)
+		},
+	)
+
+	for _, tc := range []struct {
+		name        string
+		backend     storage.Backend
+		insensitive bool
+	}{
+		{"Case-sensitive", storage.NewMemory(false), false},
+		{"Case-insensitive", storage.NewMemory(true), true},
+	} {
+		t.Run(
+			tc.name, func(t *testing.T) {
+				testDir := t.TempDir()
+
+				hdl := setupTestHandler(testDir)
+				hdl.detectCase(context.Background(), tc.backend)
+				assert.Equal(t, tc.insensitive, hdl.caseInsensitive)
+
+				upload := func(name, content string) *httptest.ResponseRecorder {
+					rec := httptest.NewRecorder()
+					hdl.ServeHTTP(rec, newUploadRequest(name, content, nil))
+					return rec
+				}
+				do := func(method, target string) *httptest.ResponseRecorder {
+					rec := httptest.NewRecorder()
+					hdl.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+					return rec
+				}
+
+				assert.Equal(t, http.StatusCreated, upload("Logo.mp4", "original").Code)
+
+				rec := upload("logo.mp4", "replacement")
+				if tc.insensitive {
+					assert.Equal(t, http.StatusConflict, rec.Code)
+					res := utils.ErrorResponse{}
+					assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+					assert.Equal(t, "file_exists", res.Code)
+					assert.Equal(t, "logo.mp4", res.Params["name"])
+					assert.Equal(t, "Logo.mp4", res.Params["existing"])
+				} else {
+					assert.Equal(t, http.StatusCreated, rec.Code)
+				}
+
+				data, err := os.ReadFile(filepath.Join(testDir, "Logo.mp4"))
+				assert.Nil(t, err)
+				assert.Equal(t, "original", string(data))
+
+				m, err := hdl.meta.Get("LOGO.MP4")
+				if tc.insensitive {
+					assert.Nil(t, err)
+					assert.Equal(t, "Logo.mp4", m.Name)
+				} else {
+					assert.ErrorIs(t, err, meta.ErrNotFound)
+				}
+
+				var list struct {
+					Count int `json:"count"`
+				}
+				assert.Nil(t, json.NewDecoder(do(http.MethodGet, "/list").Body).Decode(&list))
+				var stats Stats
+				assert.Nil(t, json.NewDecoder(do(http.MethodGet, "/stats").Body).Decode(&stats))
+				assert.Equal(t, tc.insensitive, stats.CaseInsensitive)
+
+				rec = do(http.MethodDelete, "/files/LOGO.MP4")
+				if tc.insensitive {
+					assert.Equal(t, 1, list.Count)
+					assert.Equal(t, http.StatusNoContent, rec.Code)
+					assert.NoFileExists(t, filepath.Join(testDir, "Logo.mp4"))
+					_, err = hdl.meta.Get("Logo.mp4")
+					assert.ErrorIs(t, err, meta.ErrNotFound)
+				} else {
+					assert.Equal(t, 2, list.Count)
+					assert.Equal(t, http.StatusNotFound, rec.Code)
+					assert.FileExists(t, filepath.Join(testDir, "Logo.mp4"))
+				}
+			},
+		)
+	}
+}
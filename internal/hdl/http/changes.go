@@ -0,0 +1,149 @@
+package http
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/webhook"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultChangesRetention = 7 * 24 * time.Hour
+	defaultChangesPage      = 1000
+)
+
+var changeTypes = map[string]string{
+	webhook.FileCreated: meta.ChangeCreated,
+	webhook.FileUpdated: meta.ChangeModified,
+	webhook.FileDeleted: meta.ChangeDeleted,
+}
+
+// ChangesResponse is a page of changes. With ?tombstones=true it also lists
+// the tombstones of the files deleted after the cursor, which outlive the
+// changes that deleted them, so a client whose cursor expired can still
+// tell deleted files from ones it never saw.
+type ChangesResponse struct {
+	Changes    []meta.Change    `json:"changes"`
+	Cursor     string           `json:"cursor"`
+	HasMore    bool             `json:"has_more"`
+	Tombstones []meta.Tombstone `json:"tombstones,omitempty"`
+}
+
+func encodeCursor(seq uint64) string {
+	return base64.RawURLEncoding.EncodeToString(binary.BigEndian.AppendUint64(nil, seq))
+}
+
+func decodeCursor(v string) (uint64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil || len(b) != 8 {
+		return 0, ErrInvalidCursor
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (h *Handler) openJournal() (*meta.Journal, error) {
+	retention := h.config.Changes.Retention
+	if retention <= 0 {
+		retention = defaultChangesRetention
+	}
+	return meta.OpenJournal(h.savePath, retention)
+}
+
+func (h *Handler) recordChange(typ, name string) {
+	kind, ok := changeTypes[typ]
+	if h.journal == nil || !ok || name == "" || h.hidden(name) {
+		return
+	}
+
+	c := meta.Change{Type: kind, Name: name}
+	if kind != meta.ChangeDeleted {
+		if info, err := h.statStored(h.filePath(name)); err == nil {
+			mtime := info.ModTime().UTC()
+			c.ModTime, c.Size = &mtime, info.Size()
+		}
+		if m, err := h.meta.Get(name); err == nil {
+			c.Checksum = m.Checksum
+		}
+	}
+
+	c, err := h.journal.Append(c)
+	if err != nil {
+		log.Printf("Error recording change for %s: %s\n", name, err)
+		return
+	}
+	h.bury(c)
+}
+
+func (h *Handler) changesLimit(r *http.Request) (int, error) {
+	limit := h.config.Changes.MaxPage
+	if limit <= 0 {
+		limit = defaultChangesPage
+	}
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return 0, ErrInvalidLimit
+		}
+		limit = min(n, limit)
+	}
+	return limit, nil
+}
+
+func (h *Handler) changes(w http.ResponseWriter, r *http.Request) {
+	if h.journal == nil {
+		writeError(w, ErrChangesDisabled)
+		return
+	}
+
+	limit, err := h.changesLimit(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var seq uint64
+	if since := r.URL.Query().Get("since"); since != "" {
+		if t, terr := time.Parse(time.RFC3339Nano, since); terr == nil {
+			seq, err = h.journal.Cursor(t)
+		} else if seq, err = decodeCursor(since); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	var res []meta.Change
+	var more bool
+	if err == nil {
+		res, more, err = h.journal.Since(seq, limit)
+	}
+	if errors.Is(err, meta.ErrCursorExpired) {
+		errorResponse(w, http.StatusGone, err)
+		return
+	}
+	if err != nil {
+		writeError(w, ErrInternal)
+		return
+	}
+
+	page := ChangesResponse{Changes: res, HasMore: more}
+	tombstones := r.URL.Query().Get("tombstones") == "true"
+	if tombstones {
+		page.Tombstones = h.tombstones.Since(seq)
+	}
+	if len(res) > 0 {
+		seq = res[len(res)-1].Seq
+	}
+	if tombstones && more {
+		page.Tombstones = slices.DeleteFunc(page.Tombstones, func(ts meta.Tombstone) bool { return ts.Seq > seq })
+	}
+	page.Cursor = encodeCursor(seq)
+	utils.JSONResponse(w, http.StatusOK, page)
+}
@@ -0,0 +1,154 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChanges(t *testing.T) {
+	testDir := t.TempDir()
+
+	newHandler := func(cfg *config.ChangesConfig) *Handler {
+		return New(
+			port, testDir, &config.HTTPConfig{
+				MaxUploadSize:   1024 * 1024,
+				MaxStreamBuffer: 1024,
+				Changes:         cfg,
+			},
+		)
+	}
+	do := func(h *Handler, method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader([]byte(body))))
+		return rec
+	}
+	poll := func(h *Handler, since string) ChangesResponse {
+		rec := do(h, http.MethodGet, "/changes?since="+url.QueryEscape(since), "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		res := ChangesResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res
+	}
+	kinds := func(res ChangesResponse) []string {
+		var k []string
+		for _, c := range res.Changes {
+			k = append(k, c.Type+" "+c.Name)
+		}
+		return k
+	}
+
+	t.Run(
+		"Disabled by default", func(t *testing.T) {
+			assert.Equal(t, http.StatusNotFound, do(setupTestHandler(testDir), http.MethodGet, "/changes", "").Code)
+		},
+	)
+
+	t.Run(
+		"Interleaved mutations and polls", func(t *testing.T) {
+			hdl := newHandler(&config.ChangesConfig{})
+			defer hdl.journal.Close()
+
+			res := poll(hdl, "")
+			assert.Empty(t, res.Changes)
+			cursor := res.Cursor
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("doc.pdf", "v1", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			res = poll(hdl, cursor)
+			if assert.Len(t, res.Changes, 1) {
+				c := res.Changes[0]
+				assert.Equal(t, meta.ChangeCreated, c.Type)
+				assert.Equal(t, "doc.pdf", c.Name)
+				assert.Equal(t, int64(2), c.Size)
+				assert.NotEmpty(t, c.Checksum)
+				assert.NotNil(t, c.ModTime)
+			}
+			cursor = res.Cursor
+
+			assert.Equal(t, http.StatusOK, do(hdl, http.MethodPatch, "/files/doc.pdf", "+v2").Code)
+			mark := time.Now()
+			assert.Equal(t, http.StatusNoContent, do(hdl, http.MethodDelete, "/files/doc.pdf", "").Code)
+			assert.NoFileExists(t, filepath.Join(testDir, "doc.pdf"))
+
+			res = poll(hdl, cursor)
+			assert.Equal(t, []string{"modified doc.pdf", "deleted doc.pdf"}, kinds(res))
+			assert.Equal(t, int64(5), res.Changes[0].Size)
+			assert.Nil(t, res.Changes[1].ModTime)
+			assert.False(t, res.HasMore)
+
+			again := poll(hdl, res.Cursor)
+			assert.Empty(t, again.Changes)
+			assert.Equal(t, res.Cursor, again.Cursor)
+
+			assert.Equal(t, []string{"deleted doc.pdf"}, kinds(poll(hdl, mark.Format(time.RFC3339Nano))))
+
+			rec = do(hdl, http.MethodGet, "/changes?limit=2", "")
+			page := ChangesResponse{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&page))
+			assert.Equal(t, []string{"created doc.pdf", "modified doc.pdf"}, kinds(page))
+			assert.True(t, page.HasMore)
+			assert.Equal(t, []string{"deleted doc.pdf"}, kinds(poll(hdl, page.Cursor)))
+		},
+	)
+
+	t.Run(
+		"Survives restarts", func(t *testing.T) {
+			hdl := newHandler(&config.ChangesConfig{})
+			cursor := poll(hdl, "").Cursor
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, "notes.txt"), []byte("notes"), 0644))
+			assert.Equal(t, http.StatusNoContent, do(hdl, http.MethodDelete, "/files/notes.txt", "").Code)
+			assert.Nil(t, hdl.journal.Close())
+
+			hdl = newHandler(&config.ChangesConfig{})
+			defer hdl.journal.Close()
+			assert.Equal(t, []string{"deleted notes.txt"}, kinds(poll(hdl, cursor)))
+		},
+	)
+
+	t.Run(
+		"Expired cursors", func(t *testing.T) {
+			assert.Nil(t, os.RemoveAll(filepath.Join(testDir, meta.Dir)))
+			hdl := newHandler(&config.ChangesConfig{Retention: 50 * time.Millisecond})
+			defer hdl.journal.Close()
+
+			start := time.Now()
+			cursor := poll(hdl, "").Cursor
+			for _, name := range []string{"a.txt", "b.txt"} {
+				assert.Nil(t, os.WriteFile(filepath.Join(testDir, name), []byte(name), 0644))
+			}
+			assert.Equal(t, http.StatusNoContent, do(hdl, http.MethodDelete, "/files/a.txt", "").Code)
+			time.Sleep(100 * time.Millisecond)
+			assert.Equal(t, http.StatusNoContent, do(hdl, http.MethodDelete, "/files/b.txt", "").Code)
+
+			assert.Equal(t, http.StatusGone, do(hdl, http.MethodGet, "/changes?since="+cursor, "").Code)
+			assert.Equal(
+				t, http.StatusGone,
+				do(hdl, http.MethodGet, "/changes?since="+url.QueryEscape(start.Format(time.RFC3339Nano)), "").Code,
+			)
+			assert.Equal(t, http.StatusGone, do(hdl, http.MethodGet, "/changes", "").Code)
+		},
+	)
+
+	t.Run(
+		"Invalid parameters", func(t *testing.T) {
+			hdl := newHandler(&config.ChangesConfig{})
+			defer hdl.journal.Close()
+
+			assert.Equal(t, http.StatusBadRequest, do(hdl, http.MethodGet, "/changes?since=garbage!", "").Code)
+			assert.Equal(t, http.StatusBadRequest, do(hdl, http.MethodGet, "/changes?limit=0", "").Code)
+		},
+	)
+}
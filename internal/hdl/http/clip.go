@@ -0,0 +1,374 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/internal/jobs"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/webhook"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TaskClip = "clip"
+	clipDir  = ".clips"
+)
+
+// Clip is a POST /files/{name}/clip run, cutting Start to End out of Source
+// into Output. Its job is named after its ID. Without Reencode the streams
+// are copied, so the cut snaps to the keyframe at or before Start.
+type Clip struct {
+	ID         string     `json:"id"`
+	Source     string     `json:"source"`
+	Output     string     `json:"output"`
+	Start      string     `json:"start"`
+	End        string     `json:"end"`
+	Reencode   bool       `json:"reencode"`
+	Durable    bool       `json:"durable,omitempty"`
+	State      string     `json:"state"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type ClipReport struct {
+	Clip
+	Job      string     `json:"job,omitempty"`
+	Progress float64    `json:"progress"`
+	File     *meta.File `json:"file,omitempty"`
+}
+
+type clipRequest struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Output   string `json:"output"`
+	Reencode bool   `json:"reencode"`
+}
+
+// parseTimestamp reads HH:MM:SS(.fff) or a number of seconds.
+func parseTimestamp(s string) (time.Duration, bool) {
+	if strings.Contains(s, ":") {
+		d, ok := parseClock(s)
+		return d, ok && d >= 0
+	}
+	sec, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || sec < 0 {
+		return 0, false
+	}
+	return time.Duration(sec * float64(time.Second)), true
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// probeDuration reads the duration ffmpeg reports for src, or 0 when it
+// reports none, as for files that are not media.
+func (h *Handler) probeDuration(ctx context.Context, src string) time.Duration {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, h.ffmpegPath(), "-hide_banner", "-nostdin", "-i", src)
+	cmd.Stderr = &stderr
+	// Without an output ffmpeg always fails, after printing what it found.
+	_ = cmd.Run()
+
+	_, rest, ok := strings.Cut(stderr.String(), "Duration: ")
+	if !ok {
+		return 0
+	}
+	v, _, _ := strings.Cut(rest, ",")
+	d, _ := parseClock(v)
+	return d
+}
+
+func (h *Handler) clipArgs(src, dst string, c *Clip) []string {
+	start, _ := parseTimestamp(c.Start)
+	end, _ := parseTimestamp(c.End)
+
+	args := []string{
+		"-hide_banner", "-nostdin", "-y", "-ss", formatSeconds(start), "-i", src,
+		"-t", formatSeconds(end - start), "-map", "0",
+	}
+	if c.Reencode {
+		args = append(args, "-threads", strconv.Itoa(h.transcodeThreads()))
+	} else {
+		args = append(args, "-c", "copy", "-avoid_negative_ts", "make_zero")
+	}
+	if ext := strings.ToLower(filepath.Ext(dst)); ext == ".mp4" || ext == ".mov" {
+		args = append(args, "-movflags", "+faststart")
+	}
+	return append(args, dst)
+}
+
+func (h *Handler) clipPath(id string) string {
+	return filepath.Join(h.savePath, clipDir, id+".json")
+}
+
+func (h *Handler) saveClip(c *Clip) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(h.clipPath(c.ID), data)
+}
+
+func (h *Handler) loadClip(id string) (*Clip, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return nil, ErrClipNotFound
+	}
+	data, err := os.ReadFile(h.clipPath(id))
+	if err != nil {
+		return nil, ErrClipNotFound
+	}
+
+	c := &Clip{}
+	if err = json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (h *Handler) clip(w http.ResponseWriter, r *http.Request) {
+	if h.transcodeSem == nil {
+		writeError(w, ErrTranscodeDisabled)
+		return
+	}
+
+	name, err := h.pathName(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	req := &clipRequest{}
+	if !h.decodeJSON(w, r, req) {
+		return
+	}
+	start, ok1 := parseTimestamp(req.Start)
+	end, ok2 := parseTimestamp(req.End)
+	if !ok1 || !ok2 || end <= start {
+		writeError(w, withParams(ErrInvalidClip, map[string]any{"start": req.Start, "end": req.End}))
+		return
+	}
+
+	if req.Output == "" {
+		ext := filepath.Ext(name)
+		req.Output = strings.TrimSuffix(name, ext) + ".clip" + ext
+	}
+	output, err := h.cleanName(req.Output)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !h.authorizeName(w, r, ActionUpload, output) {
+		return
+	}
+
+	src, err := h.checkPath(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if info, err := os.Stat(src); err != nil || !info.Mode().IsRegular() {
+		writeError(w, ErrNotFound)
+		return
+	}
+	if _, err = h.checkPath(output); err != nil {
+		writeError(w, err)
+		return
+	}
+	if existing, ok := h.resolveName(output); ok {
+		writeError(w, existsError(output, existing))
+		return
+	}
+
+	plain, cleanup, err := h.plainPath(r.Context(), src)
+	if err != nil {
+		log.Printf("Error decrypting %s: %s\n", name, err)
+		writeError(w, canceledOr(err, ErrInternal))
+		return
+	}
+	duration := h.probeDuration(r.Context(), plain)
+	cleanup()
+	if duration == 0 {
+		writeError(w, ErrUnsupportedMediaType)
+		return
+	}
+	if end > duration {
+		writeError(
+			w, withParams(
+				ErrInvalidClip, map[string]any{"start": req.Start, "end": req.End, "duration": formatSeconds(duration)},
+			),
+		)
+		return
+	}
+
+	c := &Clip{
+		ID:        newUUID(),
+		Source:    name,
+		Output:    output,
+		Start:     req.Start,
+		End:       req.End,
+		Reencode:  req.Reencode,
+		Durable:   h.durableWrites(r),
+		State:     jobs.StatePending,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err = h.saveClip(c); err != nil {
+		log.Printf("Error saving clip %s: %s\n", c.ID, err)
+		writeError(w, ErrInternal)
+		return
+	}
+
+	job, err := h.jobs.Enqueue(c.ID, []string{TaskClip})
+	if err != nil {
+		os.Remove(h.clipPath(c.ID))
+		if errors.Is(err, jobs.ErrQueueFull) {
+			w.Header().Set("Retry-After", processingRetryAfter)
+			writeError(w, err)
+			return
+		}
+		log.Printf("Error enqueueing clip of %s: %s\n", name, err)
+		writeError(w, ErrInternal)
+		return
+	}
+	utils.JSONResponse(w, http.StatusAccepted, &ClipReport{Clip: *c, Job: job.ID})
+}
+
+func (h *Handler) clipReport(w http.ResponseWriter, r *http.Request) {
+	c, err := h.loadClip(r.PathValue("id"))
+	if err != nil {
+		writeError(w, ErrClipNotFound)
+		return
+	}
+
+	res := &ClipReport{Clip: *c}
+	if list := h.jobs.List(c.ID); len(list) > 0 {
+		job := list[len(list)-1]
+		res.Job, res.Progress = job.ID, job.Progress
+		if c.FinishedAt == nil {
+			res.State = job.State
+		}
+	}
+	if c.State == jobs.StateDone {
+		res.Progress = 1
+		if m, err := h.meta.Get(c.Output); err == nil {
+			res.File = m
+		}
+	}
+	utils.JSONResponse(w, http.StatusOK, res)
+}
+
+// clipTask cuts the clip into a temporary file next to its output and
+// commits it there, so the output appears complete or not at all.
+func (h *Handler) clipTask(ctx context.Context, id string) error {
+	c, err := h.loadClip(id)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case h.transcodeSem <- struct{}{}:
+		defer func() { <-h.transcodeSem }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	src, err := h.checkPath(c.Source)
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(src); err != nil {
+		return err
+	}
+	src, cleanup, err := h.plainPath(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	dst, err := h.checkPath(c.Output)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(dst), ".clip-"+filepath.Base(dst))
+	defer os.Remove(tmp)
+
+	cmd := exec.CommandContext(ctx, h.ffmpegPath(), h.clipArgs(src, tmp, c)...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	if nice := h.config.Transcode.Nice; nice != 0 {
+		if err = setNice(cmd.Process.Pid, nice); err != nil {
+			log.Printf("Error setting niceness for ffmpeg: %s\n", err)
+		}
+	}
+
+	start, _ := parseTimestamp(c.Start)
+	end, _ := parseTimestamp(c.End)
+	last := trackFFmpeg(ctx, stderr, end-start)
+	if err = cmd.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, last)
+	}
+
+	info, err := os.Stat(tmp)
+	if err != nil {
+		return err
+	}
+	checksum, err := fileChecksum(ctx, tmp)
+	if err != nil {
+		return err
+	}
+
+	unlock := h.fileMu.Lock(c.Output)
+	defer unlock()
+
+	now := time.Now().UTC()
+	stored := &meta.File{
+		Name:         c.Output,
+		OriginalName: c.Output,
+		Size:         info.Size(),
+		Checksum:     checksum,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	defer h.intend(meta.OpPut, c.Output, stored)()
+	if err = h.commitFile(tmp, dst, c.Durable); err != nil {
+		return err
+	}
+	if err = h.meta.Put(stored); err != nil {
+		return err
+	}
+	h.invalidateFile(c.Output)
+
+	h.emit(webhook.FileCreated, c.Output, nil, map[string]any{"source": c.Source, "start": c.Start, "end": c.End})
+	return nil
+}
+
+func (h *Handler) finishClip(job *jobs.Job) {
+	c, err := h.loadClip(job.Name)
+	if err != nil {
+		log.Printf("Error loading clip %s: %s\n", job.Name, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	c.State, c.Error, c.FinishedAt = job.State, job.Error, &now
+	if err = h.saveClip(c); err != nil {
+		log.Printf("Error saving clip %s: %s\n", c.ID, err)
+	}
+	log.Printf("Clip %s of %s %s\n", c.Output, c.Source, c.State)
+}
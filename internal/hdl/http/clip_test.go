@@ -0,0 +1,215 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/jobs"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClipFFmpeg reports a two minute input. Run without an output, as when
+// probing, it fails the way ffmpeg does.
+const fakeClipFFmpeg = `#!/bin/sh
+prev=
+for arg; do
+	[ "$prev" = "-i" ] && in=$arg
+	prev=$arg
+	last=$arg
+done
+case "$in" in
+*.txt) exit 1 ;;
+esac
+echo "  Duration: 00:02:00.00, start: 0.000000, bitrate: 1000 kb/s" >&2
+[ "$last" = "$in" ] && exit 1
+echo "$@" > "$ARGS_FILE"
+case "$in" in
+*broken*)
+	echo "$in: Invalid data found when processing input" >&2
+	exit 1
+	;;
+esac
+printf "frame=  1 fps=0.0 q=0.0 size=0kB time=00:00:10.00 bitrate=0.0kbits/s\r" >&2
+cp "$in" "$last"
+`
+
+func TestClip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+
+	testDir := t.TempDir()
+
+	bin := t.TempDir()
+	ffmpeg := filepath.Join(bin, "ffmpeg")
+	argsFile := filepath.Join(bin, "args")
+	assert.Nil(t, os.WriteFile(ffmpeg, []byte(fakeClipFFmpeg), 0755))
+	t.Setenv("ARGS_FILE", argsFile)
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			Jobs:      &config.JobsConfig{Workers: 1},
+			Transcode: &config.TranscodeConfig{FFmpeg: ffmpeg, CPUBudget: 4},
+		},
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hdl.jobs.Run(ctx)
+
+	for name, content := range map[string]string{"recording.mp4": "video", "broken.mp4": "junk", "notes.txt": "text"} {
+		assert.Nil(t, os.WriteFile(filepath.Join(testDir, name), []byte(content), 0644))
+	}
+
+	clip := func(name string, req clipRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(req)
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/files/"+name+"/clip", bytes.NewReader(body)))
+		return rec
+	}
+	report := func(id string) ClipReport {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/clips/"+id, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		res := ClipReport{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res
+	}
+	wait := func(id string) ClipReport {
+		var res ClipReport
+		assert.Eventually(
+			t, func() bool {
+				res = report(id)
+				return res.FinishedAt != nil
+			}, 5*time.Second, 20*time.Millisecond,
+		)
+		return res
+	}
+	assertCode := func(rec *httptest.ResponseRecorder, status int, code string) {
+		assert.Equal(t, status, rec.Code)
+		res := utils.ErrorResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		assert.Equal(t, code, res.Code)
+	}
+
+	t.Run(
+		"Stream copy", func(t *testing.T) {
+			rec := clip("recording.mp4", clipRequest{Start: "00:01:10", End: "00:01:30", Output: "snippet.mp4"})
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			res := ClipReport{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.NotEmpty(t, res.Job)
+			assert.Equal(t, jobs.StatePending, res.State)
+
+			res = wait(res.ID)
+			assert.Equal(t, jobs.StateDone, res.State)
+			assert.Equal(t, float64(1), res.Progress)
+			if assert.NotNil(t, res.File) {
+				assert.Equal(t, "snippet.mp4", res.File.Name)
+				assert.Equal(t, int64(len("video")), res.File.Size)
+				assert.NotEmpty(t, res.File.ID)
+			}
+			assert.FileExists(t, filepath.Join(testDir, "snippet.mp4"))
+
+			args, _ := os.ReadFile(argsFile)
+			assert.Contains(t, string(args), "-ss 70.000 -i ")
+			assert.Contains(t, string(args), "-t 20.000 -map 0 -c copy")
+			assert.Contains(t, string(args), "-movflags +faststart")
+		},
+	)
+
+	t.Run(
+		"Re-encode", func(t *testing.T) {
+			rec := clip("recording.mp4", clipRequest{Start: "5", End: "7.5", Reencode: true})
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			res := ClipReport{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.Equal(t, "recording.clip.mp4", res.Output)
+
+			res = wait(res.ID)
+			assert.Equal(t, jobs.StateDone, res.State)
+			args, _ := os.ReadFile(argsFile)
+			assert.Contains(t, string(args), "-t 2.500 -map 0 -threads 4")
+			assert.NotContains(t, string(args), "-c copy")
+		},
+	)
+
+	t.Run(
+		"Invalid ranges", func(t *testing.T) {
+			for _, req := range []clipRequest{
+				{Start: "00:01:30", End: "00:01:10"},
+				{Start: "10", End: "10"},
+				{Start: "-1", End: "10"},
+				{Start: "1:2", End: "10"},
+				{Start: "00:01:50", End: "00:02:10"},
+				{End: "10"},
+			} {
+				req.Output = "invalid.mp4"
+				assertCode(clip("recording.mp4", req), http.StatusBadRequest, "invalid_clip")
+			}
+			assert.NoFileExists(t, filepath.Join(testDir, "invalid.mp4"))
+		},
+	)
+
+	t.Run(
+		"Output checks", func(t *testing.T) {
+			rec := clip("recording.mp4", clipRequest{Start: "0", End: "1", Output: "snippet.mp4"})
+			assertCode(rec, http.StatusConflict, "file_exists")
+
+			rec = clip("recording.mp4", clipRequest{Start: "0", End: "1", Output: "../escape.mp4"})
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			res := ClipReport{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.False(t, strings.ContainsAny(res.Output, `/\`))
+			wait(res.ID)
+			assert.NoFileExists(t, filepath.Join(testDir, "..", "escape.mp4"))
+
+			rec = clip("missing.mp4", clipRequest{Start: "0", End: "1"})
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+
+			assertCode(clip("notes.txt", clipRequest{Start: "0", End: "1"}), http.StatusUnsupportedMediaType, "unsupported_media_type")
+		},
+	)
+
+	t.Run(
+		"Failed runs", func(t *testing.T) {
+			rec := clip("broken.mp4", clipRequest{Start: "0", End: "1", Output: "broken-clip.mp4"})
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			res := ClipReport{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+
+			res = wait(res.ID)
+			assert.Equal(t, jobs.StateFailed, res.State)
+			assert.Contains(t, res.Error, "Invalid data found")
+			assert.Nil(t, res.File)
+			assert.NoFileExists(t, filepath.Join(testDir, "broken-clip.mp4"))
+			assert.NoFileExists(t, filepath.Join(testDir, ".clip-broken-clip.mp4"))
+		},
+	)
+
+	t.Run(
+		"Unknown clip", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/clips/missing", nil))
+			assertCode(rec, http.StatusNotFound, "clip_not_found")
+		},
+	)
+
+	t.Run(
+		"Disabled", func(t *testing.T) {
+			body := strings.NewReader(`{"start":"0","end":"1"}`)
+			rec := httptest.NewRecorder()
+			setupTestHandler(testDir).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/files/recording.mp4/clip", body))
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		},
+	)
+}
@@ -0,0 +1,103 @@
+package http
+
+import (
+	"context"
+	"github.com/JMURv/media-server/internal/metrics"
+	"github.com/JMURv/media-server/internal/storage"
+	"sync"
+	"time"
+)
+
+const defaultCoalesceTimeout = time.Minute
+
+// flights coalesces identical computations of derived content, such as a
+// resize of one image version by one transform. The first request for a key
+// starts the computation and every request for that key, the first included,
+// waits for its result or error.
+type flights struct {
+	ctx       context.Context
+	mu        sync.Mutex
+	calls     map[string]*flight
+	timeout   time.Duration
+	coalesced *metrics.Counter
+}
+
+type flight struct {
+	done     chan struct{}
+	deadline time.Time
+	val      any
+	err      error
+}
+
+func newFlights(ctx context.Context, timeout time.Duration, reg *metrics.Registry) *flights {
+	if timeout <= 0 {
+		timeout = defaultCoalesceTimeout
+	}
+	return &flights{
+		ctx:       ctx,
+		calls:     make(map[string]*flight),
+		timeout:   timeout,
+		coalesced: reg.Counter("coalesced_total", "Requests served by a computation started for an identical request."),
+	}
+}
+
+// Do returns the result of fn for key, running fn only when no computation
+// for key is in flight. fn runs detached from the callers, so one leaving
+// does not fail the rest, and is cancelled at the per-key timeout. Callers
+// still waiting then get ErrCoalesceTimeout, and the key is released so the
+// next request starts over rather than joining a stuck computation.
+func (f *flights) Do(ctx context.Context, key string, fn func(ctx context.Context) (any, error)) (any, error) {
+	f.mu.Lock()
+	c, ok := f.calls[key]
+	if ok {
+		f.coalesced.Inc()
+	} else {
+		c = &flight{done: make(chan struct{}), deadline: time.Now().Add(f.timeout)}
+		f.calls[key] = c
+		go f.run(key, c, fn)
+	}
+	f.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(c.deadline))
+	defer timer.Stop()
+
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		return nil, storage.Canceled(ctx)
+	case <-timer.C:
+		f.forget(key, c)
+		return nil, ErrCoalesceTimeout
+	}
+}
+
+func (f *flights) run(key string, c *flight, fn func(ctx context.Context) (any, error)) {
+	ctx, cancel := context.WithDeadline(f.ctx, c.deadline)
+	defer cancel()
+
+	c.val, c.err = fn(ctx)
+	f.forget(key, c)
+	close(c.done)
+}
+
+// Start starts fn for key unless a computation for key is in flight, and
+// returns without waiting for it.
+func (f *flights) Start(key string, fn func(ctx context.Context) (any, error)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.calls[key]; ok {
+		return
+	}
+	c := &flight{done: make(chan struct{}), deadline: time.Now().Add(f.timeout)}
+	f.calls[key] = c
+	go f.run(key, c, fn)
+}
+
+func (f *flights) forget(key string, c *flight) {
+	f.mu.Lock()
+	if f.calls[key] == c {
+		delete(f.calls, key)
+	}
+	f.mu.Unlock()
+}
@@ -0,0 +1,172 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/JMURv/media-server/internal/imaging"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalescing(t *testing.T) {
+	testDir := t.TempDir()
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, png.Encode(buf, image.NewNRGBA(image.Rect(0, 0, 64, 64))))
+	assert.Nil(t, os.WriteFile(filepath.Join(testDir, "photo.png"), buf.Bytes(), 0644))
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			CoalesceTimeout: 200 * time.Millisecond,
+			Images: &config.ImagesConfig{
+				MaxDimension: 1000,
+				CacheSize:    64 << 10,
+			},
+		},
+	)
+
+	waitCoalesced := func(n uint64) {
+		assert.Eventually(
+			t, func() bool { return hdl.flights.coalesced.Value() >= n }, time.Second, time.Millisecond,
+		)
+	}
+
+	t.Run(
+		"Concurrent identical transforms run once", func(t *testing.T) {
+			var renders atomic.Int32
+			release := make(chan struct{})
+			applyTransform = func(dst io.Writer, src io.ReadSeeker, tr imaging.Transform, limits imaging.Limits) (string, error) {
+				renders.Add(1)
+				<-release
+				return imaging.Apply(dst, src, tr, limits)
+			}
+			defer func() { applyTransform = imaging.Apply }()
+
+			const clients = 20
+			before := hdl.flights.coalesced.Value()
+			recs := make([]*httptest.ResponseRecorder, clients)
+			var wg sync.WaitGroup
+			for i := range clients {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					recs[i] = httptest.NewRecorder()
+					hdl.ServeHTTP(recs[i], httptest.NewRequest(http.MethodGet, "/img/photo.png?w=16", nil))
+				}()
+			}
+
+			waitCoalesced(before + clients - 1)
+			close(release)
+			wg.Wait()
+
+			assert.Equal(t, int32(1), renders.Load())
+			for _, rec := range recs {
+				assert.Equal(t, http.StatusOK, rec.Code)
+				assert.Equal(t, recs[0].Body.Bytes(), rec.Body.Bytes())
+			}
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			assert.Contains(t, rec.Body.String(), "media_server_coalesced_total")
+		},
+	)
+
+	t.Run(
+		"Waiters share the error", func(t *testing.T) {
+			errRender := errors.New("render failed")
+			var runs atomic.Int32
+			release := make(chan struct{})
+			fn := func(context.Context) (any, error) {
+				runs.Add(1)
+				<-release
+				return nil, errRender
+			}
+
+			before := hdl.flights.coalesced.Value()
+			errs := make(chan error, 3)
+			for range 3 {
+				go func() {
+					_, err := hdl.flights.Do(context.Background(), "failing", fn)
+					errs <- err
+				}()
+			}
+			waitCoalesced(before + 2)
+			close(release)
+
+			for range 3 {
+				assert.ErrorIs(t, <-errs, errRender)
+			}
+			assert.Equal(t, int32(1), runs.Load())
+		},
+	)
+
+	t.Run(
+		"Stuck computations time out and are released", func(t *testing.T) {
+			stuck := make(chan struct{})
+			defer close(stuck)
+			var cancelled atomic.Bool
+			fn := func(ctx context.Context) (any, error) {
+				select {
+				case <-ctx.Done():
+					cancelled.Store(true)
+				case <-stuck:
+				}
+				return nil, ctx.Err()
+			}
+
+			start := time.Now()
+			_, err := hdl.flights.Do(context.Background(), "stuck", fn)
+			assert.ErrorIs(t, err, ErrCoalesceTimeout)
+			assert.Less(t, time.Since(start), time.Second)
+			assert.Eventually(t, cancelled.Load, time.Second, time.Millisecond)
+
+			v, err := hdl.flights.Do(
+				context.Background(), "stuck", func(context.Context) (any, error) { return "fresh", nil },
+			)
+			assert.Nil(t, err)
+			assert.Equal(t, "fresh", v)
+		},
+	)
+
+	t.Run(
+		"A waiter leaving does not cancel the computation", func(t *testing.T) {
+			release := make(chan struct{})
+			fn := func(ctx context.Context) (any, error) {
+				<-release
+				return "done", ctx.Err()
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			first := make(chan error, 1)
+			go func() {
+				_, err := hdl.flights.Do(ctx, "leaving", fn)
+				first <- err
+			}()
+
+			before := hdl.flights.coalesced.Value()
+			second := make(chan any, 1)
+			go func() {
+				v, _ := hdl.flights.Do(context.Background(), "leaving", fn)
+				second <- v
+			}()
+			waitCoalesced(before + 1)
+
+			cancel()
+			assert.ErrorIs(t, <-first, context.Canceled)
+			close(release)
+			assert.Equal(t, "done", <-second)
+		},
+	)
+}
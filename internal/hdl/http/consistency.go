@@ -0,0 +1,250 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/storage"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	DiscrepancyMissingEntry = "missing_entry"
+	DiscrepancyMissingFile  = "missing_file"
+	DiscrepancyStaleEntry   = "stale_entry"
+)
+
+// Discrepancy is a file and its catalog entry found out of step: a file
+// without an entry, an entry without a file, or an entry describing other
+// content than the file holds.
+type Discrepancy struct {
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	Repaired bool   `json:"repaired"`
+	Error    string `json:"error,omitempty"`
+}
+
+type ConsistencyReport struct {
+	CheckedAt     time.Time     `json:"checked_at"`
+	DryRun        bool          `json:"dry_run"`
+	Files         int           `json:"files"`
+	Entries       int           `json:"entries"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
+
+// intend records in the write-ahead log that op is about to change name,
+// with f the entry a put saves, and returns the func that marks it done.
+// Callers defer it past both the file and the catalog update.
+func (h *Handler) intend(op, name string, f *meta.File) func() {
+	seq, err := h.wal.Begin(op, name, f)
+	if err != nil {
+		log.Printf("Error journaling %s of %s: %s\n", op, name, err)
+		return func() {}
+	}
+	return func() {
+		if err := h.wal.Done(seq); err != nil {
+			log.Printf("Error journaling %s of %s: %s\n", op, name, err)
+		}
+	}
+}
+
+// recoverCatalog reconciles the names of the mutations a previous run left
+// unfinished, which are the only ones a crash can have left out of step.
+func (h *Handler) recoverCatalog() {
+	for _, in := range h.wal.Pending() {
+		if d := h.reconcile(h.ctx, in.Name, in.File, true, false); d != nil {
+			log.Printf("Recovered interrupted %s of %s: %s\n", in.Op, in.Name, d.Kind)
+		}
+		if err := h.wal.Done(in.Seq); err != nil {
+			log.Printf("Error journaling %s of %s: %s\n", in.Op, in.Name, err)
+		}
+	}
+}
+
+// reconcile compares name on disk with its catalog entry and, unless
+// dryRun, repairs the entry to match the file. Entries are compared by size,
+// and by checksum too when deep. intended is the entry an interrupted put
+// meant to save, kept when the file turns out to hold its content.
+func (h *Handler) reconcile(ctx context.Context, name string, intended *meta.File, deep, dryRun bool) *Discrepancy {
+	unlock := h.fileMu.Lock(name)
+	defer unlock()
+
+	path, err := h.checkPath(name)
+	if err != nil {
+		return nil
+	}
+	info, err := h.statStored(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Error checking %s: %s\n", name, err)
+		return nil
+	}
+	exists := err == nil && info.Mode().IsRegular()
+
+	m, err := h.meta.Get(name)
+	if err != nil && !errors.Is(err, meta.ErrNotFound) {
+		log.Printf("Error reading metadata for %s: %s\n", name, err)
+		return nil
+	}
+	if m != nil && m.Backend != "" {
+		return nil
+	}
+
+	d := &Discrepancy{Name: name}
+	switch {
+	case !exists && m != nil:
+		d.Kind = DiscrepancyMissingFile
+	case exists && m == nil:
+		d.Kind = DiscrepancyMissingEntry
+	case exists && m.Size != info.Size():
+		d.Kind = DiscrepancyStaleEntry
+	case exists && deep && m.Checksum != "":
+		checksum, err := h.storedChecksum(ctx, path)
+		if err != nil || checksum == m.Checksum {
+			return nil
+		}
+		d.Kind = DiscrepancyStaleEntry
+	default:
+		return nil
+	}
+	if dryRun {
+		return d
+	}
+
+	if d.Kind == DiscrepancyMissingFile {
+		err = h.meta.Delete(name)
+	} else {
+		err = h.repairEntry(ctx, name, path, info, m, intended)
+	}
+	h.invalidateFile(name)
+	if err != nil {
+		log.Printf("Error repairing %s of %s: %s\n", d.Kind, name, err)
+		d.Error = err.Error()
+		return d
+	}
+
+	log.Printf("Repaired %s of %s\n", d.Kind, name)
+	d.Repaired = true
+	return d
+}
+
+func (h *Handler) repairEntry(ctx context.Context, name, path string, info os.FileInfo, m, intended *meta.File) error {
+	checksum, err := h.storedChecksum(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case intended != nil && intended.Checksum == checksum:
+		f := *intended
+		if m != nil {
+			f.ID, f.CreatedAt = m.ID, m.CreatedAt
+		}
+		m = &f
+	case m == nil:
+		m = &meta.File{
+			Name:         name,
+			OriginalName: name,
+			CreatedAt:    info.ModTime().UTC(),
+		}
+	default:
+		m.DetectedType = ""
+	}
+	m.Size, m.Checksum, m.UpdatedAt = info.Size(), checksum, info.ModTime().UTC()
+	return h.meta.Put(m)
+}
+
+// checkConsistency reconciles every file under the save path with the
+// catalog, and every local entry of the catalog with the save path.
+func (h *Handler) checkConsistency(ctx context.Context, dryRun bool) (*ConsistencyReport, error) {
+	res := &ConsistencyReport{CheckedAt: time.Now().UTC(), DryRun: dryRun, Discrepancies: []Discrepancy{}}
+	key := func(name string) string {
+		if h.caseInsensitive {
+			return strings.ToLower(name)
+		}
+		return name
+	}
+
+	seen := make(map[string]bool)
+	err := h.walkRootsDir(
+		func(root, p string, d fs.DirEntry, err error) error {
+			if err == nil {
+				err = storage.Canceled(ctx)
+			}
+			if err != nil {
+				return err
+			}
+			if p != root && strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() || d.Type()&fs.ModeSymlink != 0 {
+				return nil
+			}
+
+			rel, _ := filepath.Rel(root, p)
+			name := filepath.ToSlash(rel)
+			if h.hidden(name) || h.deletePending(p) {
+				return nil
+			}
+
+			res.Files++
+			seen[key(name)] = true
+			if disc := h.reconcile(ctx, name, nil, false, dryRun); disc != nil {
+				res.Discrepancies = append(res.Discrepancies, *disc)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.meta.Walk(
+		func(m *meta.File) error {
+			if err := storage.Canceled(ctx); err != nil {
+				return err
+			}
+
+			res.Entries++
+			if seen[key(m.Name)] || m.Backend != "" {
+				return nil
+			}
+			if disc := h.reconcile(ctx, m.Name, nil, false, dryRun); disc != nil {
+				res.Discrepancies = append(res.Discrepancies, *disc)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	h.consistencyMu.Lock()
+	h.consistencyReport = res
+	h.consistencyMu.Unlock()
+	return res, nil
+}
+
+func (h *Handler) consistency(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+
+	res, err := h.checkConsistency(r.Context(), dryRun(r))
+	if err != nil {
+		log.Printf("Error checking catalog consistency: %s\n", err)
+		writeError(w, canceledOr(err, ErrInternal))
+		return
+	}
+	utils.JSONResponse(w, http.StatusOK, res)
+}
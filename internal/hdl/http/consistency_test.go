@@ -0,0 +1,163 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestConsistency(t *testing.T) {
+	testDir := t.TempDir()
+
+	cfg := &config.HTTPConfig{MaxUploadSize: 1024, AdminToken: "admin-secret"}
+	hdl := New(port, testDir, cfg)
+
+	sum := func(data string) string {
+		s := sha256.Sum256([]byte(data))
+		return hex.EncodeToString(s[:])
+	}
+	write := func(name, content string) {
+		assert.Nil(t, os.WriteFile(filepath.Join(testDir, name), []byte(content), 0644))
+	}
+	put := func(name, content string) {
+		now := time.Now().UTC()
+		assert.Nil(t, hdl.meta.Put(&meta.File{Name: name, Size: int64(len(content)), Checksum: sum(content), CreatedAt: now, UpdatedAt: now}))
+	}
+	check := func(query string) ConsistencyReport {
+		req := httptest.NewRequest(http.MethodGet, "/admin/consistency"+query, nil)
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		res := ConsistencyReport{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		sort.Slice(res.Discrepancies, func(i, j int) bool { return res.Discrepancies[i].Name < res.Discrepancies[j].Name })
+		return res
+	}
+
+	t.Run(
+		"Completed writes leave nothing to recover", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("done.mp4", "video", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			req := httptest.NewRequest(http.MethodDelete, "/files/done.mp4", nil)
+			req.Header.Set("Authorization", "Bearer admin-secret")
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+
+			assert.Empty(t, hdl.wal.Pending())
+			info, err := os.Stat(filepath.Join(testDir, meta.Dir, meta.WALFile))
+			assert.Nil(t, err)
+			assert.Zero(t, info.Size())
+		},
+	)
+
+	t.Run(
+		"Interrupted writes are recovered at startup", func(t *testing.T) {
+			// An upload that stopped after its file was written.
+			intended := &meta.File{Name: "written.mp4", OriginalName: "Written.mp4", Size: 5, Checksum: sum("video"), Tags: []string{"crash"}}
+			_, err := hdl.wal.Begin(meta.OpPut, "written.mp4", intended)
+			assert.Nil(t, err)
+			write("written.mp4", "video")
+
+			// An overwrite with content of the same size.
+			put("replaced.mp4", "old-1")
+			_, err = hdl.wal.Begin(meta.OpPut, "replaced.mp4", &meta.File{Name: "replaced.mp4", Checksum: sum("new-2")})
+			assert.Nil(t, err)
+			write("replaced.mp4", "new-2")
+
+			// A delete that stopped after removing the file.
+			put("deleted.mp4", "video")
+			_, err = hdl.wal.Begin(meta.OpDelete, "deleted.mp4", nil)
+			assert.Nil(t, err)
+
+			// An upload that stopped before writing anything.
+			_, err = hdl.wal.Begin(meta.OpPut, "never.mp4", &meta.File{Name: "never.mp4"})
+			assert.Nil(t, err)
+
+			hdl = New(port, testDir, cfg)
+			assert.Empty(t, hdl.wal.Pending())
+
+			m, err := hdl.meta.Get("written.mp4")
+			if assert.Nil(t, err) {
+				assert.Equal(t, "Written.mp4", m.OriginalName)
+				assert.Equal(t, []string{"crash"}, m.Tags)
+				assert.NotEmpty(t, m.ID)
+			}
+			m, err = hdl.meta.Get("replaced.mp4")
+			if assert.Nil(t, err) {
+				assert.Equal(t, sum("new-2"), m.Checksum)
+			}
+			_, err = hdl.meta.Get("deleted.mp4")
+			assert.ErrorIs(t, err, meta.ErrNotFound)
+			_, err = hdl.meta.Get("never.mp4")
+			assert.ErrorIs(t, err, meta.ErrNotFound)
+		},
+	)
+
+	t.Run(
+		"Discrepancies are reported and repaired on demand", func(t *testing.T) {
+			write("orphan.mp4", "video")
+			put("ghost.mp4", "video")
+			put("stale.mp4", "old")
+			write("stale.mp4", "longer")
+			write(".hidden", "skip")
+
+			res := check("?dry_run=true")
+			assert.True(t, res.DryRun)
+			assert.Equal(
+				t, []Discrepancy{
+					{Name: "ghost.mp4", Kind: DiscrepancyMissingFile},
+					{Name: "orphan.mp4", Kind: DiscrepancyMissingEntry},
+					{Name: "stale.mp4", Kind: DiscrepancyStaleEntry},
+				}, res.Discrepancies,
+			)
+			_, err := hdl.meta.Get("orphan.mp4")
+			assert.ErrorIs(t, err, meta.ErrNotFound)
+
+			res = check("")
+			assert.False(t, res.DryRun)
+			assert.Len(t, res.Discrepancies, 3)
+			for _, d := range res.Discrepancies {
+				assert.True(t, d.Repaired, d.Name)
+			}
+
+			m, err := hdl.meta.Get("orphan.mp4")
+			if assert.Nil(t, err) {
+				assert.Equal(t, sum("video"), m.Checksum)
+			}
+			m, err = hdl.meta.Get("stale.mp4")
+			if assert.Nil(t, err) {
+				assert.Equal(t, int64(len("longer")), m.Size)
+				assert.Equal(t, sum("longer"), m.Checksum)
+			}
+			_, err = hdl.meta.Get("ghost.mp4")
+			assert.ErrorIs(t, err, meta.ErrNotFound)
+
+			res = check("")
+			assert.Empty(t, res.Discrepancies)
+			assert.Equal(t, res.Files, res.Entries)
+		},
+	)
+
+	t.Run(
+		"Requires admin", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/consistency", nil))
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		},
+	)
+}
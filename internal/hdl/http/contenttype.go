@@ -0,0 +1,153 @@
+package http
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+const defaultContentType = "application/octet-stream"
+
+var mediaTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".avif": "image/avif",
+	".heic": "image/heic",
+	".heif": "image/heif",
+	".bmp":  "image/bmp",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+	".ico":  "image/x-icon",
+	".svg":  "image/svg+xml",
+
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+	".mkv":  "video/x-matroska",
+	".avi":  "video/x-msvideo",
+	".ogv":  "video/ogg",
+	".mpeg": "video/mpeg",
+	".mpg":  "video/mpeg",
+	".ts":   "video/mp2t",
+	".3gp":  "video/3gpp",
+
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".wav":  "audio/wav",
+	".flac": "audio/flac",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".opus": "audio/opus",
+	".weba": "audio/webm",
+	".mid":  "audio/midi",
+
+	".m3u8": "application/vnd.apple.mpegurl",
+	".vtt":  vttType,
+	".srt":  subripType,
+}
+
+func typeByExtension(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	if t, ok := mediaTypes[ext]; ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+func sniffType(file io.ReadSeeker) (string, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return baseMediaType(http.DetectContentType(buf[:n])), nil
+}
+
+func sameKind(declared, sniffed string) bool {
+	if sniffed == defaultContentType {
+		return true
+	}
+
+	declared, sniffed = baseMediaType(declared), baseMediaType(sniffed)
+	if declared == sniffed {
+		return true
+	}
+	// Subtitles are plain text to the sniffer, whatever they are declared as.
+	if subtitle(declared) && sniffed == "text/plain" {
+		return true
+	}
+	kind, _, _ := strings.Cut(declared, "/")
+	sniffedKind, _, _ := strings.Cut(sniffed, "/")
+	return kind == sniffedKind
+}
+
+func (h *Handler) detectedType(name string, file io.ReadSeeker) (string, error) {
+	m, err := h.meta.Get(name)
+	if err != nil {
+		m = nil
+	}
+	if m != nil && m.DetectedType != "" {
+		return m.DetectedType, nil
+	}
+
+	sniffed, err := sniffType(file)
+	if err != nil {
+		return "", err
+	}
+
+	if m != nil {
+		unlock := h.fileMu.Lock(name)
+		defer unlock()
+
+		if m, err = h.meta.Get(name); err == nil {
+			m.DetectedType = sniffed
+			if err = h.meta.Put(m); err != nil {
+				log.Printf("Error caching content type for %s: %s\n", name, err)
+			}
+		}
+	}
+	return sniffed, nil
+}
+
+func (h *Handler) contentType(name string, file io.ReadSeeker) (string, error) {
+	if m, err := h.meta.Get(name); err == nil && m.ContentType != "" {
+		return m.ContentType, nil
+	}
+
+	declared := typeByExtension(name)
+	if declared != "" && !h.config.StrictContentType {
+		return declared, nil
+	}
+
+	sniffed, err := h.detectedType(name, file)
+	if err != nil {
+		return "", err
+	}
+	if declared == "" {
+		return sniffed, nil
+	}
+	if !sameKind(declared, sniffed) {
+		return "", ErrContentTypeMismatch
+	}
+	return declared, nil
+}
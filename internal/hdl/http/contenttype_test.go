@@ -0,0 +1,147 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContentType(t *testing.T) {
+	testDir := t.TempDir()
+
+	cfg := &config.HTTPConfig{MaxUploadSize: 1024 * 1024, MaxStreamBuffer: 1024}
+	hdl := New(port, testDir, cfg)
+
+	png := []byte("\x89PNG\r\n\x1a\nimage")
+	text := []byte("definitely not a video")
+	write := func(name string, data []byte, m *meta.File) {
+		assert.Nil(t, os.WriteFile(filepath.Join(testDir, name), data, 0644))
+		if m != nil {
+			m.Name, m.Size = name, int64(len(data))
+			assert.Nil(t, hdl.meta.Put(m))
+		}
+	}
+
+	write("image", png, nil)
+	write("notes", text, nil)
+	write("cached", png, &meta.File{})
+	write("movie.mkv", []byte("\x1a\x45\xdf\xa3matroska"), nil)
+	write("song.m4a", []byte("audio"), nil)
+	write("fake.mp4", text, nil)
+	write("explicit.bin", text, &meta.File{ContentType: "video/mp4"})
+
+	stream := func(name string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream/"+name, nil))
+		return rec
+	}
+
+	t.Run(
+		"Extensionless files are sniffed", func(t *testing.T) {
+			rec := stream("image")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+
+			rec = stream("notes")
+			assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Sniffed type is cached in metadata", func(t *testing.T) {
+			rec := stream("cached")
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			m, err := hdl.meta.Get("cached")
+			assert.Nil(t, err)
+			assert.Equal(t, "image/png", m.DetectedType)
+
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, "cached"), text, 0644))
+			rec = stream("cached")
+			assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+		},
+	)
+
+	t.Run(
+		"Built-in extension table", func(t *testing.T) {
+			rec := stream("movie.mkv")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "video/x-matroska", rec.Header().Get("Content-Type"))
+
+			rec = stream("song.m4a")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "audio/mp4", rec.Header().Get("Content-Type"))
+
+			assert.Equal(t, "image/heic", typeByExtension("photo.HEIC"))
+		},
+	)
+
+	t.Run(
+		"Text file with video extension", func(t *testing.T) {
+			cfg.StrictContentType = false
+			rec := stream("fake.mp4")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "video/mp4", rec.Header().Get("Content-Type"))
+
+			cfg.StrictContentType = true
+			defer func() { cfg.StrictContentType = false }()
+			rec = stream("fake.mp4")
+			assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+			assert.NotContains(t, rec.Body.String(), string(text))
+
+			rec = stream("movie.mkv")
+			assert.Equal(t, http.StatusOK, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Stored type wins", func(t *testing.T) {
+			cfg.StrictContentType = true
+			defer func() { cfg.StrictContentType = false }()
+
+			rec := stream("explicit.bin")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "video/mp4", rec.Header().Get("Content-Type"))
+		},
+	)
+
+	t.Run(
+		"Type set on upload", func(t *testing.T) {
+			upload := func(contentType string) *httptest.ResponseRecorder {
+				body := &bytes.Buffer{}
+				writer := multipart.NewWriter(body)
+				file, _ := writer.CreateFormFile("file", "clip")
+				file.Write(text)
+				writer.WriteField("content_type", contentType)
+				writer.Close()
+
+				req := httptest.NewRequest(http.MethodPost, "/upload", body)
+				req.Header.Set("Content-Type", writer.FormDataContentType())
+				rec := httptest.NewRecorder()
+				hdl.ServeHTTP(rec, req)
+				return rec
+			}
+
+			rec := upload("not a type;;")
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+			rec = upload("video/webm")
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			res := utils.UploadResponse{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+
+			rec = stream(res.Name)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "video/webm", rec.Header().Get("Content-Type"))
+		},
+	)
+}
@@ -0,0 +1,125 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestContextPropagation(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := setupTestHandler(testDir)
+
+	t.Run(
+		"Walk stops within a batch of cancellation", func(t *testing.T) {
+			dir := filepath.Join(testDir, "many")
+			assert.Nil(t, os.MkdirAll(dir, os.ModePerm))
+			for i := range 3 * readDirBatch {
+				assert.Nil(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("%05d", i)), nil, 0644))
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			seen := 0
+			_, err := walkEntries(
+				ctx, dir, false, 0, func(_ string, _ fs.DirEntry) error {
+					seen++
+					cancel()
+					return nil
+				},
+			)
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.LessOrEqual(t, seen, readDirBatch)
+
+			ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+			defer cancel()
+			<-ctx.Done()
+
+			seen = 0
+			_, err = walkEntries(
+				ctx, dir, false, 0, func(_ string, _ fs.DirEntry) error {
+					seen++
+					return nil
+				},
+			)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			assert.Equal(t, 0, seen)
+		},
+	)
+
+	t.Run(
+		"Hashing stops at the deadline", func(t *testing.T) {
+			path := filepath.Join(testDir, "large.bin")
+			file, err := os.Create(path)
+			assert.Nil(t, err)
+			assert.Nil(t, file.Truncate(4<<30))
+			assert.Nil(t, file.Close())
+			defer os.Remove(path)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			_, err = hashFile(ctx, path, sha256.New())
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			assert.Less(t, time.Since(start), 2*time.Second)
+		},
+	)
+
+	t.Run(
+		"Cause is kept", func(t *testing.T) {
+			ctx, cancel := context.WithCancelCause(context.Background())
+			cancel(ErrRequestCancelled)
+
+			_, err := walkEntries(ctx, testDir, false, 0, func(string, fs.DirEntry) error { return nil })
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.ErrorIs(t, err, ErrRequestCancelled)
+			assert.Equal(t, statusClientClosedRequest, StatusCode(err))
+			assert.Equal(t, "request_cancelled", ErrorCode(err))
+		},
+	)
+
+	t.Run(
+		"Cancelled requests are client closed, not internal errors", func(t *testing.T) {
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, "small.txt"), []byte("hello"), 0644))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			for _, path := range []string{"/list", "/stats", "/files/small.txt/checksum"} {
+				rec := httptest.NewRecorder()
+				hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx))
+				assert.Equal(t, statusClientClosedRequest, rec.Code, path)
+
+				res := utils.ErrorResponse{}
+				assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+				assert.Equal(t, "client_closed_request", res.Code, path)
+			}
+		},
+	)
+
+	t.Run(
+		"Expired deadlines are gateway timeouts", func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+			defer cancel()
+			<-ctx.Done()
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil).WithContext(ctx))
+			assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+			assert.Contains(t, rec.Body.String(), "deadline_exceeded")
+		},
+	)
+}
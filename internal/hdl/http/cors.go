@@ -0,0 +1,53 @@
+package http
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+const (
+	corsMethods = "GET, HEAD, OPTIONS"
+	corsHeaders = "Range, If-Range, If-None-Match, If-Modified-Since, Accept-Language"
+	corsExposed = "Accept-Ranges, Content-Length, Content-Range, Content-Language, ETag"
+)
+
+// withCORS answers cross-origin requests from the configured origins, so
+// browsers can play streams and load text tracks from another origin.
+func (h *Handler) withCORS(next http.HandlerFunc) http.HandlerFunc {
+	cfg := h.config.CORS
+	if cfg == nil {
+		return next
+	}
+
+	anyOrigin := slices.Contains(cfg.AllowedOrigins, "*")
+	return func(w http.ResponseWriter, r *http.Request) {
+		hdr := w.Header()
+		if !anyOrigin {
+			hdr.Add("Vary", "Origin")
+		}
+
+		origin := r.Header.Get("Origin")
+		if origin != "" && (anyOrigin || slices.Contains(cfg.AllowedOrigins, origin)) {
+			if anyOrigin {
+				hdr.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				hdr.Set("Access-Control-Allow-Origin", origin)
+			}
+			hdr.Set("Access-Control-Expose-Headers", corsExposed)
+			if r.Method == http.MethodOptions {
+				hdr.Set("Access-Control-Allow-Methods", corsMethods)
+				hdr.Set("Access-Control-Allow-Headers", corsHeaders)
+				if cfg.MaxAge > 0 {
+					hdr.Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.MaxAge.Seconds())))
+				}
+			}
+		}
+		next(w, r)
+	}
+}
+
+func (h *Handler) preflight(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", corsMethods)
+	w.WriteHeader(http.StatusNoContent)
+}
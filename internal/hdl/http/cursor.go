@@ -0,0 +1,66 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"time"
+)
+
+const (
+	defaultListCursorTTL = 24 * time.Hour
+	listCursorMACSize    = 16
+)
+
+// listCursorKey returns the key /list continuation tokens are signed with:
+// the signing secret when one is configured, otherwise a random key, in
+// which case tokens do not survive a restart.
+func listCursorKey(secret string) []byte {
+	if secret != "" {
+		return []byte(secret)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func (h *Handler) listCursorMAC(payload []byte) []byte {
+	mac := hmac.New(sha256.New, h.cursorKey)
+	mac.Write([]byte("list\x00"))
+	mac.Write(payload)
+	return mac.Sum(nil)[:listCursorMACSize]
+}
+
+// encodeListCursor returns a token resuming a listing after the name after.
+// It carries its expiry and is signed, so clients can neither forge a
+// position nor keep one forever.
+func (h *Handler) encodeListCursor(after string, now time.Time) string {
+	ttl := h.config.ListCursorTTL
+	if ttl <= 0 {
+		ttl = defaultListCursorTTL
+	}
+
+	payload := binary.BigEndian.AppendUint64(nil, uint64(now.Add(ttl).Unix()))
+	payload = append(payload, after...)
+	return base64.RawURLEncoding.EncodeToString(append(payload, h.listCursorMAC(payload)...))
+}
+
+func (h *Handler) decodeListCursor(v string, now time.Time) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil || len(b) < 8+listCursorMACSize {
+		return "", ErrCursorRestart
+	}
+
+	payload, sig := b[:len(b)-listCursorMACSize], b[len(b)-listCursorMACSize:]
+	if !hmac.Equal(sig, h.listCursorMAC(payload)) {
+		return "", ErrCursorRestart
+	}
+	if now.Unix() > int64(binary.BigEndian.Uint64(payload)) {
+		return "", ErrCursorRestart
+	}
+	return string(payload[8:]), nil
+}
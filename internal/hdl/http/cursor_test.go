@@ -0,0 +1,133 @@
+package http
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListCursor(t *testing.T) {
+	testDir := t.TempDir()
+
+	cfg := &config.HTTPConfig{DefaultPage: 1, DefaultSize: 2, SigningSecret: "secret"}
+	hdl := New(port, testDir, cfg)
+
+	create := func(names ...string) {
+		for _, name := range names {
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, name), []byte(name), 0644))
+		}
+	}
+	type listing struct {
+		Data        []string `json:"data"`
+		HasNextPage bool     `json:"has_next_page"`
+		NextCursor  string   `json:"next_cursor"`
+	}
+	list := func(h *Handler, cursor string) (int, listing) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/list?cursor="+url.QueryEscape(cursor), nil))
+
+		res := listing{}
+		if rec.Code == http.StatusOK {
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+			for i, u := range res.Data {
+				res.Data[i] = path.Base(u)
+			}
+			return rec.Code, res
+		}
+
+		e := utils.ErrorResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&e))
+		assert.Equal(t, "restart_listing", e.Code)
+		return rec.Code, res
+	}
+
+	t.Run(
+		"Every file present for the whole scan is returned once", func(t *testing.T) {
+			create("b.txt", "d.txt", "f.txt", "h.txt", "j.txt")
+
+			_, first := list(hdl, "")
+			assert.Equal(t, []string{"b.txt", "d.txt"}, first.Data)
+			assert.True(t, first.HasNextPage)
+			assert.NotEmpty(t, first.NextCursor)
+
+			// Churn before and around the cursor would shift an offset.
+			create("a.txt", "c.txt", "e.txt")
+			assert.Nil(t, os.Remove(filepath.Join(testDir, "b.txt")))
+
+			_, second := list(hdl, first.NextCursor)
+			assert.Equal(t, []string{"e.txt", "f.txt"}, second.Data)
+
+			assert.Nil(t, os.Remove(filepath.Join(testDir, "e.txt")))
+			create("g.txt")
+
+			_, third := list(hdl, second.NextCursor)
+			assert.Equal(t, []string{"g.txt", "h.txt"}, third.Data)
+
+			_, last := list(hdl, third.NextCursor)
+			assert.Equal(t, []string{"j.txt"}, last.Data)
+			assert.False(t, last.HasNextPage)
+			assert.Empty(t, last.NextCursor)
+		},
+	)
+
+	t.Run(
+		"Cursors resume after a deleted name", func(t *testing.T) {
+			_, first := list(hdl, "")
+			assert.Equal(t, []string{"a.txt", "c.txt"}, first.Data)
+			assert.Nil(t, os.Remove(filepath.Join(testDir, "c.txt")))
+
+			_, next := list(hdl, first.NextCursor)
+			assert.Equal(t, []string{"d.txt", "f.txt"}, next.Data)
+		},
+	)
+
+	t.Run(
+		"Forged cursors ask the client to restart", func(t *testing.T) {
+			code, _ := list(hdl, "not a cursor")
+			assert.Equal(t, http.StatusBadRequest, code)
+
+			other := New(port, testDir, &config.HTTPConfig{DefaultPage: 1, DefaultSize: 2, SigningSecret: "other"})
+			code, _ = list(hdl, other.encodeListCursor("d.txt", time.Now()))
+			assert.Equal(t, http.StatusBadRequest, code)
+
+			forged, err := base64.RawURLEncoding.DecodeString(hdl.encodeListCursor("d.txt", time.Now()))
+			assert.Nil(t, err)
+			forged[8] = 'a'
+			code, _ = list(hdl, base64.RawURLEncoding.EncodeToString(forged))
+			assert.Equal(t, http.StatusBadRequest, code)
+		},
+	)
+
+	t.Run(
+		"Expired cursors ask the client to restart", func(t *testing.T) {
+			code, _ := list(hdl, hdl.encodeListCursor("d.txt", time.Now().Add(-2*defaultListCursorTTL)))
+			assert.Equal(t, http.StatusBadRequest, code)
+
+			code, res := list(hdl, hdl.encodeListCursor("d.txt", time.Now()))
+			assert.Equal(t, http.StatusOK, code)
+			assert.Equal(t, []string{"f.txt", "g.txt"}, res.Data)
+		},
+	)
+
+	t.Run(
+		"Offset pages carry a cursor too", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/list?page=2", nil))
+			res := listing{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+
+			_, next := list(hdl, res.NextCursor)
+			assert.Equal(t, []string{"h.txt", "j.txt"}, next.Data)
+		},
+	)
+}
@@ -0,0 +1,169 @@
+package http
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultUploadRateWindow = 10 * time.Second
+
+type rateSample struct {
+	at    time.Time
+	total int64
+}
+
+type uploadGuard struct {
+	io.ReadCloser
+	rc       *http.ResponseController
+	start    time.Time
+	timeout  time.Duration
+	minRate  int64
+	window   time.Duration
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mu      sync.Mutex
+	total   int64
+	samples []rateSample
+	err     error
+}
+
+func (h *Handler) guardUpload(w http.ResponseWriter, r *http.Request) *uploadGuard {
+	if h.config.UploadTimeout <= 0 && h.config.MinUploadRate <= 0 {
+		return nil
+	}
+
+	window := h.config.UploadRateWindow
+	if window <= 0 {
+		window = defaultUploadRateWindow
+	}
+
+	now := time.Now()
+	g := &uploadGuard{
+		ReadCloser: r.Body,
+		rc:         http.NewResponseController(w),
+		start:      now,
+		timeout:    h.config.UploadTimeout,
+		minRate:    h.config.MinUploadRate,
+		window:     window,
+		stop:       make(chan struct{}),
+		samples:    []rateSample{{at: now}},
+	}
+	r.Body = g
+
+	go g.watch()
+	return g
+}
+
+func (g *uploadGuard) check(now time.Time) error {
+	if g.err != nil {
+		return g.err
+	}
+
+	if g.timeout > 0 && now.Sub(g.start) > g.timeout {
+		g.err = ErrUploadTimeout
+		return g.err
+	}
+
+	if g.minRate > 0 && now.Sub(g.start) >= g.window {
+		cutoff := now.Add(-g.window)
+		i := 0
+		for i+1 < len(g.samples) && !g.samples[i+1].at.After(cutoff) {
+			i++
+		}
+		g.samples = g.samples[i:]
+
+		if float64(g.total-g.samples[0].total)/g.window.Seconds() < float64(g.minRate) {
+			g.err = ErrUploadTooSlow
+		}
+	}
+	return g.err
+}
+
+func (g *uploadGuard) Read(p []byte) (int, error) {
+	if err := g.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := g.ReadCloser.Read(p)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	g.total += int64(n)
+	g.samples = append(g.samples, rateSample{at: now, total: g.total})
+	if gerr := g.check(now); gerr != nil {
+		return n, gerr
+	}
+	return n, err
+}
+
+func (g *uploadGuard) watch() {
+	interval := g.window / 4
+	if g.timeout > 0 && (g.minRate <= 0 || g.timeout/4 < interval) {
+		interval = g.timeout / 4
+	}
+
+	ticker := time.NewTicker(max(interval, 10*time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.stop:
+			return
+		case now := <-ticker.C:
+			g.mu.Lock()
+			err := g.check(now)
+			g.mu.Unlock()
+
+			if err != nil {
+				g.rc.SetReadDeadline(now)
+				return
+			}
+		}
+	}
+}
+
+func (g *uploadGuard) Err() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.err
+}
+
+func (g *uploadGuard) Rate() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	elapsed := time.Since(g.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(g.total) / elapsed
+}
+
+func (g *uploadGuard) Stop() {
+	g.stopOnce.Do(func() { close(g.stop) })
+}
+
+func (g *uploadGuard) aborted(w http.ResponseWriter, r *http.Request) bool {
+	if g == nil {
+		return false
+	}
+
+	err := g.Err()
+	if err == nil {
+		return false
+	}
+
+	log.Printf(
+		"Upload from %s aborted: %s (%.1f KB/s over %s)\n",
+		r.RemoteAddr, err, g.Rate()/1024, time.Since(g.start).Round(time.Millisecond),
+	)
+	w.Header().Set("Connection", "close")
+	errorResponse(w, http.StatusRequestTimeout, err)
+	return true
+}
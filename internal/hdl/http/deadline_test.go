@@ -0,0 +1,112 @@
+package http
+
+import (
+	"bytes"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func multipartBody(name string, size int) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	file, _ := writer.CreateFormFile("file", name)
+	file.Write(bytes.Repeat([]byte("x"), size))
+	writer.Close()
+	return body, writer.FormDataContentType()
+}
+
+func TestUploadDeadline(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize:    10 * 1024 * 1024,
+			MinUploadRate:    10 * 1024,
+			UploadRateWindow: 100 * time.Millisecond,
+		},
+	)
+
+	upload := func(name string, chunk int, delay time.Duration, size int) *httptest.ResponseRecorder {
+		body, contentType := multipartBody(name, size)
+		req := httptest.NewRequest(http.MethodPost, "/upload", &slowReader{r: body, chunk: chunk, delay: delay})
+		req.Header.Set("Content-Type", contentType)
+
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run(
+		"Too slow", func(t *testing.T) {
+			rec := upload("slow.bin", 100, 20*time.Millisecond, 10*1024)
+			assert.Equal(t, http.StatusRequestTimeout, rec.Code)
+			assert.Contains(t, rec.Body.String(), ErrUploadTooSlow.Error())
+			assert.NoFileExists(t, filepath.Join(testDir, "slow.bin"))
+
+			entries, err := os.ReadDir(testDir)
+			assert.Nil(t, err)
+			for _, e := range entries {
+				assert.NotContains(t, e.Name(), ".upload-")
+			}
+		},
+	)
+
+	t.Run(
+		"Just above threshold", func(t *testing.T) {
+			rec := upload("steady.bin", 300, 20*time.Millisecond, 8*1024)
+			assert.Equal(t, http.StatusCreated, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Pauses shorter than window", func(t *testing.T) {
+			rec := upload("bursty.bin", 2000, 60*time.Millisecond, 12*1024)
+			assert.Equal(t, http.StatusCreated, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Maximum duration", func(t *testing.T) {
+			hdl.config.UploadTimeout = 50 * time.Millisecond
+			defer func() { hdl.config.UploadTimeout = 0 }()
+
+			rec := upload("long.bin", 4096, 20*time.Millisecond, 32*1024)
+			assert.Equal(t, http.StatusRequestTimeout, rec.Code)
+			assert.Contains(t, rec.Body.String(), ErrUploadTimeout.Error())
+		},
+	)
+
+	t.Run(
+		"Stalled client", func(t *testing.T) {
+			srv := httptest.NewServer(hdl)
+			defer srv.Close()
+
+			body, contentType := multipartBody("stalled.bin", 4096)
+			pr, pw := io.Pipe()
+			defer pw.Close()
+			go pw.Write(body.Bytes()[:1024])
+
+			req, err := http.NewRequest(http.MethodPost, srv.URL+"/upload", pr)
+			assert.Nil(t, err)
+			req.Header.Set("Content-Type", contentType)
+			req.ContentLength = int64(body.Len())
+
+			start := time.Now()
+			res, err := http.DefaultClient.Do(req)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, http.StatusRequestTimeout, res.StatusCode)
+			assert.Less(t, time.Since(start), 2*time.Second)
+			assert.NoFileExists(t, filepath.Join(testDir, "stalled.bin"))
+		},
+	)
+}
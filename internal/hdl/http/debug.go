@@ -0,0 +1,117 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultBenchSize    = 64 << 20
+	defaultMaxBenchSize = 1 << 30
+)
+
+type IOBenchResult struct {
+	Size         int64   `json:"size"`
+	WriteSeconds float64 `json:"write_seconds"`
+	WriteMBps    float64 `json:"write_mbps"`
+	ReadSeconds  float64 `json:"read_seconds"`
+	ReadMBps     float64 `json:"read_mbps"`
+}
+
+func (h *Handler) debugRoutes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("POST /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	mux.HandleFunc("POST /debug/io-bench", h.ioBench)
+	return mux
+}
+
+func mbps(size int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(size) / (1 << 20) / d.Seconds()
+}
+
+func (h *Handler) ioBench(w http.ResponseWriter, r *http.Request) {
+	maxSize := h.config.Debug.MaxBenchSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxBenchSize
+	}
+
+	size := int64(defaultBenchSize)
+	if v := r.URL.Query().Get("size"); v != "" {
+		var err error
+		if size, err = strconv.ParseInt(v, 10, 64); err != nil || size <= 0 || size > maxSize {
+			writeError(w, ErrInvalidBenchSize)
+			return
+		}
+	}
+
+	if !h.benchMu.TryLock() {
+		writeError(w, ErrBenchRunning)
+		return
+	}
+	defer h.benchMu.Unlock()
+
+	src := io.LimitReader(rand.NewChaCha8([32]byte{}), size)
+	start := time.Now()
+	path, written, checksum, err := h.writeTemp(src)
+	if path != "" {
+		defer os.Remove(path)
+	}
+	if err != nil {
+		log.Printf("Error writing benchmark file: %s\n", err)
+		writeError(w, ErrInternal)
+		return
+	}
+	writeTime := time.Since(start)
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Error opening benchmark file: %s\n", err)
+		writeError(w, ErrInternal)
+		return
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	start = time.Now()
+	if err = streamChunks(hash, file, h.streamBuffer(bodyLength(file), 0)); err != nil {
+		log.Printf("Error reading benchmark file: %s\n", err)
+		writeError(w, ErrInternal)
+		return
+	}
+	readTime := time.Since(start)
+
+	if hex.EncodeToString(hash.Sum(nil)) != checksum {
+		log.Printf("Benchmark file was corrupted on disk\n")
+		errorResponse(w, http.StatusInternalServerError, ErrChecksumMismatch)
+		return
+	}
+
+	utils.JSONResponse(
+		w, http.StatusOK, &IOBenchResult{
+			Size:         written,
+			WriteSeconds: writeTime.Seconds(),
+			WriteMBps:    mbps(written, writeTime),
+			ReadSeconds:  readTime.Seconds(),
+			ReadMBps:     mbps(written, readTime),
+		},
+	)
+}
@@ -0,0 +1,90 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestDebugListener(t *testing.T) {
+	testDir := t.TempDir()
+
+	t.Run(
+		"Disabled by default", func(t *testing.T) {
+			hdl := setupTestHandler(testDir)
+			assert.Nil(t, hdl.debugMux)
+		},
+	)
+
+	t.Run(
+		"Address required", func(t *testing.T) {
+			assert.Panics(t, func() { New(port, testDir, &config.HTTPConfig{Debug: &config.DebugConfig{}}) })
+		},
+	)
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxStreamBuffer: 4096,
+			Debug:           &config.DebugConfig{Addr: "127.0.0.1:0", MaxBenchSize: 4 << 20},
+		},
+	)
+	debug := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		hdl.debugMux.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	t.Run(
+		"Not exposed on the public handler", func(t *testing.T) {
+			for _, path := range []string{"/debug/pprof/", "/debug/vars", "/debug/io-bench"} {
+				rec := httptest.NewRecorder()
+				hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+				assert.Equal(t, http.StatusNotFound, rec.Code, path)
+			}
+		},
+	)
+
+	t.Run(
+		"Profiling and vars", func(t *testing.T) {
+			rec := debug(http.MethodGet, "/debug/pprof/")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Contains(t, rec.Body.String(), "goroutine")
+
+			rec = debug(http.MethodGet, "/debug/pprof/goroutine?debug=1")
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			rec = debug(http.MethodGet, "/debug/vars")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			vars := map[string]any{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&vars))
+			assert.Contains(t, vars, "memstats")
+		},
+	)
+
+	t.Run(
+		"IO benchmark", func(t *testing.T) {
+			assert.Equal(t, http.StatusBadRequest, debug(http.MethodPost, "/debug/io-bench?size=0").Code)
+			assert.Equal(t, http.StatusBadRequest, debug(http.MethodPost, "/debug/io-bench?size=8388608").Code)
+			assert.Equal(t, http.StatusMethodNotAllowed, debug(http.MethodGet, "/debug/io-bench").Code)
+
+			rec := debug(http.MethodPost, "/debug/io-bench?size=1048576")
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			res := &IOBenchResult{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+			assert.Equal(t, int64(1<<20), res.Size)
+			assert.Greater(t, res.WriteMBps, 0.0)
+			assert.Greater(t, res.ReadMBps, 0.0)
+
+			entries, err := os.ReadDir(testDir)
+			assert.Nil(t, err)
+			for _, e := range entries {
+				assert.NotContains(t, e.Name(), ".upload-")
+			}
+		},
+	)
+}
@@ -0,0 +1,132 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	removeAttempts         = 5
+	removeBackoff          = 20 * time.Millisecond
+	deferredDeleteInterval = time.Second
+)
+
+type deferredDeletes struct {
+	once sync.Once
+	mu   sync.Mutex
+	// files maps paths to the file that was asked to be deleted, so a file
+	// uploaded under the same name in the meantime is left alone.
+	files map[string]os.FileInfo
+}
+
+// removeFile deletes path, retrying with backoff while another process
+// briefly holds it locked, which only happens on Windows. A file that stays
+// locked is handed to the deferred remover and reported as pending.
+func (h *Handler) removeFile(path string) (bool, error) {
+	backoff := removeBackoff
+	for attempt := 1; ; attempt++ {
+		err := os.Remove(path)
+		if err == nil || !isLocked(err) {
+			return false, err
+		}
+		if attempt == removeAttempts {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	h.deferDelete(path, info)
+	return true, nil
+}
+
+func (h *Handler) deferDelete(path string, info os.FileInfo) {
+	d := &h.deletes
+	d.mu.Lock()
+	if d.files == nil {
+		d.files = make(map[string]os.FileInfo)
+	}
+	d.files[path] = info
+	d.mu.Unlock()
+
+	log.Printf("File %s is locked, deleting it later\n", path)
+	d.once.Do(func() { go h.runDeferredDeletes(h.ctx) })
+}
+
+// deletePending reports whether path is a file waiting to be deleted.
+func (h *Handler) deletePending(path string) bool {
+	d := &h.deletes
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.files[path]
+	return ok
+}
+
+func (h *Handler) runDeferredDeletes(ctx context.Context) {
+	ticker := time.NewTicker(deferredDeleteInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.retryDeletes()
+		}
+	}
+}
+
+func (h *Handler) retryDeletes() {
+	d := &h.deletes
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for path, info := range d.files {
+		cur, err := os.Stat(path)
+		if err != nil || !os.SameFile(info, cur) {
+			delete(d.files, path)
+			continue
+		}
+
+		err = os.Remove(path)
+		if isLocked(err) {
+			continue
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Error deleting %s: %s\n", path, err)
+		}
+		delete(d.files, path)
+	}
+}
+
+// sharedDir is http.Dir opening files with openShared, so a download under
+// /uploads does not keep the file from being deleted on Windows.
+type sharedDir string
+
+func (d sharedDir) Open(name string) (http.File, error) {
+	rel := path.Clean("/" + name)[1:]
+	if rel == "" {
+		rel = "."
+	}
+	rel, err := filepath.Localize(rel)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+
+	file, err := openShared(filepath.Join(string(d), rel), os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,74 @@
+package http
+
+import (
+	"bytes"
+	"crypto/rand"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeleteWhileStreaming(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := setupTestHandler(testDir)
+	srv := httptest.NewServer(hdl)
+	defer srv.Close()
+
+	content := make([]byte, 8<<20)
+	rand.Read(content)
+
+	for _, path := range []string{"/stream/clip.mp4", "/uploads/clip.mp4"} {
+		t.Run(
+			"Stream and delete both succeed "+path, func(t *testing.T) {
+				assert.Nil(t, os.WriteFile(filepath.Join(testDir, "clip.mp4"), content, 0644))
+
+				res, err := http.Get(srv.URL + path)
+				assert.Nil(t, err)
+				defer res.Body.Close()
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+
+				head := make([]byte, 64<<10)
+				_, err = io.ReadFull(res.Body, head)
+				assert.Nil(t, err)
+
+				rec := httptest.NewRecorder()
+				hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/files/clip.mp4", nil))
+				assert.Equal(t, http.StatusNoContent, rec.Code)
+				assert.NoFileExists(t, filepath.Join(testDir, "clip.mp4"))
+
+				rest, err := io.ReadAll(res.Body)
+				assert.Nil(t, err)
+				assert.True(t, bytes.Equal(content, append(head, rest...)))
+			},
+		)
+	}
+
+	t.Run(
+		"Deferred deletes", func(t *testing.T) {
+			path := filepath.Join(testDir, "locked.txt")
+			assert.Nil(t, os.WriteFile(path, []byte("old"), 0644))
+			info, err := os.Stat(path)
+			assert.Nil(t, err)
+
+			hdl.deferDelete(path, info)
+			hdl.retryDeletes()
+			assert.NoFileExists(t, path)
+
+			assert.Nil(t, os.WriteFile(path, []byte("old"), 0644))
+			info, err = os.Stat(path)
+			assert.Nil(t, err)
+			hdl.deferDelete(path, info)
+
+			assert.Nil(t, os.WriteFile(path+".new", []byte("new upload"), 0644))
+			assert.Nil(t, os.Rename(path+".new", path))
+			hdl.retryDeletes()
+			assert.FileExists(t, path)
+			assert.Empty(t, hdl.deletes.files)
+		},
+	)
+}
@@ -0,0 +1,26 @@
+//go:build !unix
+
+package http
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+const noFollow = 0
+
+func sameDevice(a, b string) (bool, error) {
+	return strings.EqualFold(filepath.VolumeName(a), filepath.VolumeName(b)), nil
+}
+
+func isCrossDevice(error) bool {
+	return false
+}
+
+func isDiskFull(error) bool {
+	return false
+}
+
+func syncDir(string) error {
+	return nil
+}
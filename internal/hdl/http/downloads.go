@@ -0,0 +1,229 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	downloadsDir              = ".downloads"
+	defaultDownloadSessionTTL = time.Hour
+)
+
+type DownloadSession struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	ETag       string    `json:"etag"`
+	Size       int64     `json:"size"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastAccess time.Time `json:"last_access"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type downloadRequest struct {
+	Name string `json:"name"`
+	ETag string `json:"etag"`
+}
+
+func (h *Handler) downloadSessionTTL() time.Duration {
+	if h.config.DownloadSessionTTL > 0 {
+		return h.config.DownloadSessionTTL
+	}
+	return defaultDownloadSessionTTL
+}
+
+func (h *Handler) sessionPath(id string) string {
+	return filepath.Join(h.savePath, downloadsDir, id+".json")
+}
+
+func (h *Handler) saveSession(s *DownloadSession) error {
+	s.ExpiresAt = s.LastAccess.Add(h.downloadSessionTTL())
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	path := h.sessionPath(s.ID)
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (h *Handler) loadSession(id string) (*DownloadSession, error) {
+	if strings.ContainsAny(id, `/\.`) {
+		return nil, ErrSessionNotFound
+	}
+
+	data, err := os.ReadFile(h.sessionPath(id))
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+
+	s := &DownloadSession{}
+	if err = json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(s.ExpiresAt) {
+		os.Remove(h.sessionPath(id))
+		return nil, ErrSessionNotFound
+	}
+	return s, nil
+}
+
+func (h *Handler) pruneSessions(now time.Time) {
+	entries, err := os.ReadDir(filepath.Join(h.savePath, downloadsDir))
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+
+		data, err := os.ReadFile(h.sessionPath(id))
+		if err != nil {
+			continue
+		}
+
+		s := &DownloadSession{}
+		if err = json.Unmarshal(data, s); err != nil || now.After(s.ExpiresAt) {
+			os.Remove(h.sessionPath(id))
+		}
+	}
+}
+
+func (h *Handler) createDownload(w http.ResponseWriter, r *http.Request) {
+	req := &downloadRequest{}
+	if !h.decodeJSON(w, r, req) {
+		return
+	}
+
+	name, err := h.cleanName(req.Name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !h.checkAvailable(w, r, name) || !h.checkReady(w, name) {
+		return
+	}
+
+	file, err := h.openFile(r.Context(), name)
+	if err != nil {
+		writeError(w, ErrNotFound)
+		return
+	}
+	defer file.Close()
+
+	etag, _ := h.validators(name, file)
+	if etag == "" {
+		writeError(w, ErrInternal)
+		return
+	}
+	if req.ETag != "" && req.ETag != etag {
+		writeError(w, ErrFileChanged)
+		return
+	}
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		writeError(w, ErrInternal)
+		return
+	}
+
+	now := time.Now().UTC()
+	h.pruneSessions(now)
+
+	s := &DownloadSession{
+		ID:         newUUID(),
+		Name:       name,
+		ETag:       etag,
+		Size:       size,
+		CreatedAt:  now,
+		LastAccess: now,
+	}
+	if err = h.saveSession(s); err != nil {
+		log.Printf("Error saving download session for %s: %s\n", name, err)
+		writeError(w, ErrInternal)
+		return
+	}
+	utils.JSONResponse(w, http.StatusCreated, s)
+}
+
+func (h *Handler) download(w http.ResponseWriter, r *http.Request) {
+	s, err := h.loadSession(r.PathValue("id"))
+	if err != nil {
+		writeError(w, ErrSessionNotFound)
+		return
+	}
+	if !h.checkAvailable(w, r, s.Name) || !h.checkReady(w, s.Name) {
+		return
+	}
+
+	var offset int64
+	if v := r.URL.Query().Get("offset"); v != "" {
+		if offset, err = strconv.ParseInt(v, 10, 64); err != nil || offset < 0 {
+			errorResponse(w, http.StatusBadRequest, ErrInvalidOffset)
+			return
+		}
+	}
+
+	file, err := h.openFile(r.Context(), s.Name)
+	if err != nil {
+		writeError(w, ErrFileChanged)
+		return
+	}
+	defer file.Close()
+
+	if etag, _ := h.validators(s.Name, file); etag != s.ETag {
+		writeError(w, ErrFileChanged)
+		return
+	}
+
+	if offset > s.Size {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", s.Size))
+		writeError(w, ErrInvalidOffset)
+		return
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		writeError(w, ErrInternal)
+		return
+	}
+
+	s.LastAccess = time.Now().UTC()
+	if err = h.saveSession(s); err != nil {
+		log.Printf("Error updating download session %s: %s\n", s.ID, err)
+	}
+
+	w.Header().Set("ETag", s.ETag)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Length", strconv.FormatInt(s.Size-offset, 10))
+	if offset > 0 && offset < s.Size {
+		w.Header().Set("Content-Range", byteRange{start: offset, length: s.Size - offset}.contentRange(s.Size))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+
+	w, done := h.paceStream(r, w, s.Size)
+	defer done()
+	if _, err = io.CopyN(w, file, s.Size-offset); err != nil {
+		log.Printf("Error serving download session %s: %s\n", s.ID, err)
+	}
+}
@@ -0,0 +1,115 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDownloadSessions(t *testing.T) {
+	testDir := t.TempDir()
+	hdl := setupTestHandler(testDir)
+
+	content := "resumable download content"
+	path := filepath.Join(testDir, "sync.bin")
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0644))
+
+	create := func(h *Handler, body string) (*httptest.ResponseRecorder, *DownloadSession) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/downloads", bytes.NewBufferString(body)))
+
+		res := &DownloadSession{}
+		if rec.Code == http.StatusCreated {
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+		}
+		return rec, res
+	}
+
+	get := func(h *Handler, id, offset string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/downloads/"+id+"?offset="+offset, nil))
+		return rec
+	}
+
+	var session *DownloadSession
+	t.Run(
+		"Create and resume", func(t *testing.T) {
+			var rec *httptest.ResponseRecorder
+			rec, session = create(hdl, `{"name": "sync.bin"}`)
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.NotEmpty(t, session.ETag)
+			assert.Equal(t, int64(len(content)), session.Size)
+
+			rec = get(hdl, session.ID, "0")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, content, rec.Body.String())
+			assert.Equal(t, session.ETag, rec.Header().Get("ETag"))
+
+			rec = get(hdl, session.ID, "10")
+			assert.Equal(t, http.StatusPartialContent, rec.Code)
+			assert.Equal(t, content[10:], rec.Body.String())
+			assert.Equal(t, "bytes 10-25/26", rec.Header().Get("Content-Range"))
+		},
+	)
+
+	t.Run(
+		"Shared across instances", func(t *testing.T) {
+			other := setupTestHandler(testDir)
+			rec := get(other, session.ID, "20")
+			assert.Equal(t, http.StatusPartialContent, rec.Code)
+			assert.Equal(t, content[20:], rec.Body.String())
+		},
+	)
+
+	t.Run(
+		"Invalid requests", func(t *testing.T) {
+			assert.Equal(t, http.StatusBadRequest, get(hdl, session.ID, "-1").Code)
+			assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, get(hdl, session.ID, "100").Code)
+			assert.Equal(t, http.StatusNotFound, get(hdl, "missing", "0").Code)
+
+			rec, _ := create(hdl, `{"name": "missing.bin"}`)
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+
+			rec, _ = create(hdl, `{"name": "sync.bin", "etag": "\"stale\""}`)
+			assert.Equal(t, http.StatusConflict, rec.Code)
+		},
+	)
+
+	t.Run(
+		"File changed", func(t *testing.T) {
+			assert.Nil(t, os.WriteFile(path, []byte("replaced content, new size"), 0644))
+			future := time.Now().Add(time.Minute)
+			assert.Nil(t, os.Chtimes(path, future, future))
+
+			rec := get(hdl, session.ID, "10")
+			assert.Equal(t, http.StatusConflict, rec.Code)
+			assert.Contains(t, rec.Body.String(), ErrFileChanged.Error())
+		},
+	)
+
+	t.Run(
+		"Idle expiry", func(t *testing.T) {
+			hdl.config.DownloadSessionTTL = 200 * time.Millisecond
+			defer func() { hdl.config.DownloadSessionTTL = 0 }()
+
+			_, s := create(hdl, `{"name": "sync.bin"}`)
+			assert.Equal(t, http.StatusOK, get(hdl, s.ID, "0").Code)
+
+			time.Sleep(120 * time.Millisecond)
+			assert.Equal(t, http.StatusOK, get(hdl, s.ID, "0").Code)
+
+			time.Sleep(120 * time.Millisecond)
+			assert.Equal(t, http.StatusOK, get(hdl, s.ID, "0").Code)
+
+			time.Sleep(300 * time.Millisecond)
+			assert.Equal(t, http.StatusNotFound, get(hdl, s.ID, "0").Code)
+			assert.NoFileExists(t, hdl.sessionPath(s.ID))
+		},
+	)
+}
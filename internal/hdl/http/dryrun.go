@@ -0,0 +1,38 @@
+package http
+
+import (
+	"maps"
+	"net/http"
+)
+
+// DeleteResult is what a dry run of a delete answers with in place of the
+// empty response the delete would have given.
+type DeleteResult struct {
+	DryRun bool   `json:"dry_run"`
+	Name   string `json:"name"`
+	Status int    `json:"status"`
+}
+
+// dryRun reports whether r only asks what it would do.
+func dryRun(r *http.Request) bool {
+	return r.URL.Query().Get("dry_run") == "true"
+}
+
+func actor(r *http.Request) string {
+	if r == nil {
+		return "system"
+	}
+	return r.RemoteAddr
+}
+
+// simulate records in the audit log the event a dry run stood in for,
+// flagged as such, and nothing else: no webhooks, events or journal
+// entries, and caches are left as they are.
+func (h *Handler) simulate(typ, name string, r *http.Request, data map[string]any) {
+	details := maps.Clone(data)
+	if details == nil {
+		details = make(map[string]any)
+	}
+	details["dry_run"] = true
+	h.audit.Log(typ, name, actor(r), auditDetails(r, details))
+}
@@ -0,0 +1,161 @@
+package http
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/audit"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/webhook"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDryRun(t *testing.T) {
+	testDir := t.TempDir()
+
+	var notified atomic.Int32
+	hook := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				notified.Add(1)
+			},
+		),
+	)
+	defer hook.Close()
+
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize: 1024,
+			AdminToken:    "admin-secret",
+			AuditLog:      auditPath,
+			Webhooks:      []*config.WebhookConfig{{URL: hook.URL}},
+			Lifecycle: &config.LifecycleConfig{
+				Rules: []*config.LifecycleRule{{Name: "tmp", Prefix: "tmp", Action: "delete"}},
+			},
+		},
+	)
+
+	do := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	upload := func(name string) string {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, newUploadRequest(name, "content of "+name, nil))
+		assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		return decodeUpload(t, rec).ID
+	}
+	entries := func() []audit.Entry {
+		file, err := os.Open(auditPath)
+		assert.Nil(t, err)
+		defer file.Close()
+
+		var res []audit.Entry
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			e := audit.Entry{}
+			assert.Nil(t, json.Unmarshal(scanner.Bytes(), &e))
+			res = append(res, e)
+		}
+		return res
+	}
+
+	upload("keep.txt")
+	id := upload("by-id.txt")
+	upload("tmp-report.txt")
+	hdl.webhooks.Wait()
+	notified.Store(0)
+	logged := len(entries())
+
+	t.Run(
+		"Delete", func(t *testing.T) {
+			rec := do(http.MethodDelete, "/files/keep.txt?dry_run=true")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			res := DeleteResult{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.Equal(t, DeleteResult{DryRun: true, Name: "keep.txt", Status: http.StatusNoContent}, res)
+			assert.FileExists(t, filepath.Join(testDir, "keep.txt"))
+			_, err := hdl.meta.Get("keep.txt")
+			assert.Nil(t, err)
+
+			rec = do(http.MethodDelete, "/id/"+id+"?dry_run=true")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.FileExists(t, filepath.Join(testDir, "by-id.txt"))
+		},
+	)
+
+	t.Run(
+		"Checks still apply", func(t *testing.T) {
+			assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/files/missing.txt?dry_run=true").Code)
+
+			m, err := hdl.meta.Get("keep.txt")
+			assert.Nil(t, err)
+			m.Lock = &meta.Lock{Token: "t", Holder: "editor", ExpiresAt: time.Now().Add(time.Hour)}
+			assert.Nil(t, hdl.meta.Put(m))
+			defer func() {
+				m.Lock = nil
+				hdl.meta.Put(m)
+			}()
+
+			want := do(http.MethodDelete, "/files/keep.txt").Code
+			assert.NotEqual(t, http.StatusNoContent, want)
+			assert.Equal(t, want, do(http.MethodDelete, "/files/keep.txt?dry_run=true").Code)
+		},
+	)
+
+	t.Run(
+		"Lifecycle", func(t *testing.T) {
+			rec := do(http.MethodPost, "/admin/lifecycle/run?dry_run=true")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			report := LifecycleReport{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&report))
+			assert.True(t, report.DryRun)
+			if assert.Len(t, report.Actions, 1) {
+				assert.Equal(t, "tmp-report.txt", report.Actions[0].Name)
+				assert.Empty(t, report.Actions[0].Error)
+			}
+			assert.FileExists(t, filepath.Join(testDir, "tmp-report.txt"))
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/lifecycle/run", nil)
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Nothing is announced but the audit log", func(t *testing.T) {
+			hdl.webhooks.Wait()
+			assert.Zero(t, notified.Load())
+
+			simulated := entries()[logged:]
+			assert.Len(t, simulated, 3)
+			for _, e := range simulated {
+				assert.Equal(t, webhook.FileDeleted, e.Action)
+				assert.Equal(t, true, e.Details["dry_run"])
+			}
+		},
+	)
+
+	t.Run(
+		"Lifecycle disabled", func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/admin/lifecycle/run", nil)
+			req.Header.Set("Authorization", "Bearer admin-secret")
+			rec := httptest.NewRecorder()
+			New(port, testDir, &config.HTTPConfig{AdminToken: "admin-secret"}).ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+			assert.Contains(t, rec.Body.String(), "lifecycle_disabled")
+		},
+	)
+}
@@ -0,0 +1,370 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/storage"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type DuplicateGroup struct {
+	Checksum string   `json:"checksum"`
+	Size     int64    `json:"size"`
+	Files    []string `json:"files"`
+	Wasted   int64    `json:"wasted"`
+
+	infos []os.FileInfo
+}
+
+type DuplicatesReport struct {
+	Groups []*DuplicateGroup `json:"groups"`
+	Wasted int64             `json:"wasted"`
+}
+
+type DedupeFailure struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+type DedupeResult struct {
+	Linked    int             `json:"linked"`
+	Reclaimed int64           `json:"reclaimed"`
+	DryRun    bool            `json:"dry_run"`
+	Failed    []DedupeFailure `json:"failed,omitempty"`
+}
+
+func (h *Handler) cachedChecksum(ctx context.Context, name string, info os.FileInfo) (string, error) {
+	unlock := h.fileMu.Lock(name)
+	defer unlock()
+
+	m, err := h.meta.Get(name)
+	if err != nil && !errors.Is(err, meta.ErrNotFound) {
+		return "", err
+	}
+	if m != nil && m.Checksum != "" && m.Size == info.Size() {
+		return m.Checksum, nil
+	}
+
+	checksum, err := h.storedChecksum(ctx, h.filePath(name))
+	if err != nil {
+		return "", err
+	}
+
+	if m == nil {
+		m = &meta.File{
+			Name:         name,
+			OriginalName: name,
+			CreatedAt:    info.ModTime().UTC(),
+			UpdatedAt:    info.ModTime().UTC(),
+		}
+	}
+	m.Size = info.Size()
+	m.Checksum = checksum
+	if err = h.meta.Put(m); err != nil {
+		log.Printf("Error caching checksum for %s: %s\n", name, err)
+	}
+	return checksum, nil
+}
+
+func uniqueFiles(infos []os.FileInfo) int {
+	res := 0
+	for i, info := range infos {
+		if !slices.ContainsFunc(infos[:i], func(other os.FileInfo) bool { return os.SameFile(info, other) }) {
+			res++
+		}
+	}
+	return res
+}
+
+func (h *Handler) duplicateGroups(ctx context.Context, minSize int64) ([]*DuplicateGroup, error) {
+	groups := make(map[string]*DuplicateGroup)
+	err := h.walkRootsDir(
+		func(root, p string, d fs.DirEntry, err error) error {
+			if err == nil {
+				err = storage.Canceled(ctx)
+			}
+			if err != nil {
+				return err
+			}
+			if p != root && strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.Type()&fs.ModeSymlink != 0 {
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil || info.Size() < minSize || info.Size() == 0 {
+				return nil
+			}
+
+			rel, _ := filepath.Rel(root, p)
+			name := filepath.ToSlash(rel)
+			plain := h.storedInfo(p, info)
+			checksum, err := h.cachedChecksum(ctx, name, plain)
+			if err != nil {
+				if ctx.Err() != nil {
+					return err
+				}
+				log.Printf("Error computing checksum for %s: %s\n", name, err)
+				return nil
+			}
+
+			g, ok := groups[checksum]
+			if !ok {
+				g = &DuplicateGroup{Checksum: checksum, Size: plain.Size()}
+				groups[checksum] = g
+			}
+			g.Files = append(g.Files, name)
+			g.infos = append(g.infos, info)
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*DuplicateGroup, 0)
+	for _, g := range groups {
+		if len(g.Files) < 2 {
+			continue
+		}
+		g.Wasted = int64(uniqueFiles(g.infos)-1) * g.Size
+		res = append(res, g)
+	}
+
+	sort.Slice(
+		res, func(i, j int) bool {
+			if res[i].Wasted != res[j].Wasted {
+				return res[i].Wasted > res[j].Wasted
+			}
+			return res[i].Checksum < res[j].Checksum
+		},
+	)
+	return res, nil
+}
+
+func parseMinSize(r *http.Request) (int64, error) {
+	v := r.URL.Query().Get("min_size")
+	if v == "" {
+		return 0, nil
+	}
+
+	res, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || res < 0 {
+		return 0, ErrInvalidMinSize
+	}
+	return res, nil
+}
+
+func (h *Handler) duplicates(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+
+	minSize, err := parseMinSize(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	groups, err := h.duplicateGroups(r.Context(), minSize)
+	if err != nil {
+		log.Printf("Error collecting duplicates: %s\n", err)
+		writeError(w, canceledOr(err, ErrReadingDir))
+		return
+	}
+
+	res := &DuplicatesReport{Groups: groups}
+	for _, g := range groups {
+		res.Wasted += g.Wasted
+	}
+	utils.JSONResponse(w, http.StatusOK, res)
+}
+
+func (h *Handler) sameContent(ctx context.Context, a, b string) (bool, error) {
+	fa, err := h.openStored(ctx, a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+
+	fb, err := h.openStored(ctx, b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	bufA, bufB := make([]byte, 32*1024), make([]byte, 32*1024)
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		nb, errB := io.ReadFull(fb, bufB)
+		if na != nb || !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+
+		doneA := errors.Is(errA, io.EOF) || errors.Is(errA, io.ErrUnexpectedEOF)
+		doneB := errors.Is(errB, io.EOF) || errors.Is(errB, io.ErrUnexpectedEOF)
+		if errA != nil && !doneA {
+			return false, errA
+		}
+		if errB != nil && !doneB {
+			return false, errB
+		}
+		if doneA || doneB {
+			return doneA == doneB, nil
+		}
+	}
+}
+
+func (h *Handler) linkDuplicate(keeper, name string, dryRun bool) (bool, error) {
+	unlockKeeper := h.fileMu.Lock(keeper)
+	defer unlockKeeper()
+	unlock := h.fileMu.Lock(name)
+	defer unlock()
+
+	for _, n := range []string{keeper, name} {
+		if m, err := h.meta.Get(n); err == nil && m.Lock.Active() {
+			return false, ErrLocked
+		}
+	}
+	if h.activeHold(name) != nil {
+		return false, ErrHeld
+	}
+
+	src := h.filePath(keeper)
+	dst := h.filePath(name)
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return false, err
+	}
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		return false, err
+	}
+	if os.SameFile(srcInfo, dstInfo) {
+		return false, nil
+	}
+	// Copies on roots on different filesystems cannot share their content.
+	if shared, err := sameDevice(src, dst); err == nil && !shared {
+		return false, nil
+	}
+
+	same, err := h.sameContent(h.ctx, src, dst)
+	if err != nil {
+		return false, err
+	}
+	if !same {
+		return false, ErrChecksumMismatch
+	}
+	if dryRun {
+		return true, nil
+	}
+
+	tmp := filepath.Join(filepath.Dir(dst), ".dedupe-"+newUUID())
+	if err = os.Link(src, tmp); err != nil {
+		return false, err
+	}
+	if err = os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	return true, nil
+}
+
+func unshareFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".unshare-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if info, err := src.Stat(); err == nil {
+		tmp.Chmod(info.Mode().Perm())
+	}
+
+	_, err = io.Copy(tmp, src)
+	if err = errors.Join(err, tmp.Close()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (h *Handler) dedupe(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+
+	minSize, err := parseMinSize(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	groups, err := h.duplicateGroups(r.Context(), minSize)
+	if err != nil {
+		log.Printf("Error collecting duplicates: %s\n", err)
+		writeError(w, canceledOr(err, ErrReadingDir))
+		return
+	}
+
+	checksums := r.URL.Query()["checksum"]
+	res := &DedupeResult{DryRun: dryRun(r)}
+	for _, g := range groups {
+		if len(checksums) > 0 && !slices.Contains(checksums, g.Checksum) {
+			continue
+		}
+
+		slices.Sort(g.Files)
+		for _, name := range g.Files[1:] {
+			if err = r.Context().Err(); err != nil {
+				break
+			}
+
+			linked, err := h.linkDuplicate(g.Files[0], name, res.DryRun)
+			if err != nil {
+				res.Failed = append(res.Failed, DedupeFailure{Name: name, Error: err.Error()})
+				continue
+			}
+			if linked {
+				res.Linked++
+				res.Reclaimed += g.Size
+			}
+		}
+	}
+
+	data := map[string]any{"linked": res.Linked, "reclaimed": res.Reclaimed}
+	if res.DryRun {
+		h.simulate("admin.duplicates.dedupe", "", r, data)
+	} else {
+		h.emit("admin.duplicates.dedupe", "", r, data)
+	}
+	log.Printf("Deduplicated %d files, reclaimed %d bytes\n", res.Linked, res.Reclaimed)
+	utils.JSONResponse(w, http.StatusOK, res)
+}
@@ -0,0 +1,149 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDuplicates(t *testing.T) {
+	testDir := t.TempDir()
+	hdl := setupTestHandler(testDir)
+	hdl.config.AdminToken = "admin-secret"
+	routes := hdl.routes()
+	do := func(method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, req)
+		return rec
+	}
+
+	big := strings.Repeat("b", 1000)
+	files := map[string]string{
+		"a1.bin":   big,
+		"a2.bin":   big,
+		"a3.bin":   big,
+		"s1.txt":   "small",
+		"s2.txt":   "small",
+		"uniq.txt": "unique",
+	}
+	for name, content := range files {
+		assert.Nil(t, os.WriteFile(filepath.Join(testDir, name), []byte(content), 0644))
+	}
+
+	report := func(query string) *DuplicatesReport {
+		rec := do(http.MethodGet, "/admin/duplicates"+query, "admin-secret")
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		res := &DuplicatesReport{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+		return res
+	}
+
+	dedupe := func(query string) *DedupeResult {
+		rec := do(http.MethodPost, "/admin/duplicates/dedupe"+query, "admin-secret")
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		res := &DedupeResult{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+		return res
+	}
+
+	t.Run(
+		"Admin only", func(t *testing.T) {
+			for _, token := range []string{"", "wrong"} {
+				assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/admin/duplicates", token).Code)
+				assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/admin/duplicates/dedupe", token).Code)
+			}
+			a1, _ := os.Stat(filepath.Join(testDir, "a1.bin"))
+			a2, _ := os.Stat(filepath.Join(testDir, "a2.bin"))
+			assert.False(t, os.SameFile(a1, a2))
+		},
+	)
+
+	t.Run(
+		"Groups sorted by wasted bytes", func(t *testing.T) {
+			res := report("")
+			assert.Len(t, res.Groups, 2)
+			assert.Equal(t, []string{"a1.bin", "a2.bin", "a3.bin"}, res.Groups[0].Files)
+			assert.Equal(t, int64(2000), res.Groups[0].Wasted)
+			assert.Equal(t, []string{"s1.txt", "s2.txt"}, res.Groups[1].Files)
+			assert.Equal(t, int64(2005), res.Wasted)
+
+			m, err := hdl.meta.Get("a1.bin")
+			assert.Nil(t, err)
+			assert.Equal(t, res.Groups[0].Checksum, m.Checksum)
+		},
+	)
+
+	t.Run(
+		"Min size", func(t *testing.T) {
+			res := report("?min_size=100")
+			assert.Len(t, res.Groups, 1)
+			assert.Equal(t, int64(1000), res.Groups[0].Size)
+
+			rec := do(http.MethodGet, "/admin/duplicates?min_size=-1", "admin-secret")
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Dry run", func(t *testing.T) {
+			res := dedupe("?dry_run=true")
+			assert.Equal(t, 3, res.Linked)
+			assert.Equal(t, int64(2005), res.Reclaimed)
+			assert.Equal(t, int64(2005), report("").Wasted)
+		},
+	)
+
+	t.Run(
+		"Stale checksum is verified by content", func(t *testing.T) {
+			m, _ := hdl.meta.Get("s2.txt")
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, "s2.txt"), []byte("SMALL"), 0644))
+
+			res := dedupe("?checksum=" + m.Checksum)
+			assert.Equal(t, 0, res.Linked)
+			assert.Len(t, res.Failed, 1)
+			assert.Equal(t, "s2.txt", res.Failed[0].Name)
+		},
+	)
+
+	t.Run(
+		"Hard links group members", func(t *testing.T) {
+			res := dedupe("?min_size=100")
+			assert.Equal(t, 2, res.Linked)
+			assert.Equal(t, int64(2000), res.Reclaimed)
+
+			a1, _ := os.Stat(filepath.Join(testDir, "a1.bin"))
+			a3, _ := os.Stat(filepath.Join(testDir, "a3.bin"))
+			assert.True(t, os.SameFile(a1, a3))
+
+			groups := report("?min_size=100").Groups
+			assert.Len(t, groups, 1)
+			assert.Equal(t, int64(0), groups[0].Wasted)
+			assert.Equal(t, 0, dedupe("?min_size=100").Linked)
+		},
+	)
+
+	t.Run(
+		"Patching a linked file does not affect others", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newPatchRequest("a2.bin", "X", "bytes 0-0/*"))
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			data, err := os.ReadFile(filepath.Join(testDir, "a1.bin"))
+			assert.Nil(t, err)
+			assert.Equal(t, big, string(data))
+
+			data, err = os.ReadFile(filepath.Join(testDir, "a2.bin"))
+			assert.Nil(t, err)
+			assert.Equal(t, "X"+big[1:], string(data))
+		},
+	)
+}
@@ -0,0 +1,156 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func (h *Handler) durableWrites(r *http.Request) bool {
+	return h.config.DurableWrites || r.URL.Query().Get("durable") == "true"
+}
+
+func syncFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return errors.Join(file.Sync(), file.Close())
+}
+
+// commitFile moves src into place at dst, which must not exist yet. dst is
+// hard linked to src, or to a copy of it beside dst when src is on another
+// filesystem, and src removed after: linking fails if dst exists, so of
+// several uploads racing for one name, even from separate processes,
+// exactly one wins and the others get ErrAlreadyExists, and dst never
+// exists with part of its content. Where hard links are not supported, src
+// is moved to dst under an O_EXCL claim beside it instead. Either way
+// dst is left free, and src in place, if the commit fails.
+func (h *Handler) commitFile(src, dst string, durable bool) error {
+	if _, err := h.sealFile(context.Background(), src, false); err != nil {
+		return err
+	}
+	start := time.Now()
+	if durable {
+		if err := syncFile(src); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	moved := false
+	if h.crossDevice {
+		err = h.linkCopy(src, dst)
+	} else if err = h.uploadFS.Link(src, dst); err == nil {
+		h.tempRenames.Inc()
+	} else if isCrossDevice(err) {
+		err = h.linkCopy(src, dst)
+	}
+	if linkUnsupported(err) {
+		err = h.claimFile(src, dst)
+		moved = err == nil
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return ErrAlreadyExists
+	}
+	if err != nil {
+		return err
+	}
+
+	// src is only let go of once dst is durable, so that a failing sync
+	// gives it back rather than losing the upload.
+	if durable {
+		if err = h.uploadFS.SyncDir(filepath.Dir(dst)); err != nil {
+			if moved {
+				return errors.Join(err, h.moveFile(dst, src))
+			}
+			h.uploadFS.Remove(dst)
+			return err
+		}
+		h.syncLatency.Observe(time.Since(start).Seconds())
+	}
+	if !moved {
+		h.uploadFS.Remove(src)
+	}
+	return nil
+}
+
+// linkCopy copies src beside dst and hard links the copy as dst.
+func (h *Handler) linkCopy(src, dst string) error {
+	staged, err := h.copyBeside(src, dst)
+	if err != nil {
+		return err
+	}
+	defer h.uploadFS.Remove(staged)
+
+	if err = h.uploadFS.Link(staged, dst); err != nil {
+		return err
+	}
+	h.tempCopies.Inc()
+	return nil
+}
+
+// claimFile moves src to dst under a hidden claim beside dst, created with
+// O_EXCL: only the holder of the claim moves anything to dst, and only if
+// dst does not exist yet. The claim, unlike dst itself, is never served, so
+// dst never exists empty or with part of its content.
+func (h *Handler) claimFile(src, dst string) error {
+	path := filepath.Join(filepath.Dir(dst), ".claim-"+filepath.Base(dst))
+	claim, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	claim.Close()
+	defer h.uploadFS.Remove(path)
+
+	if _, err = os.Lstat(dst); err == nil {
+		return fs.ErrExist
+	}
+	return h.moveFile(src, dst)
+}
+
+// placeFile moves src into place at dst, encrypting it first when files are
+// encrypted at rest.
+func (h *Handler) placeFile(src, dst string, durable bool) error {
+	if _, err := h.sealFile(context.Background(), src, false); err != nil {
+		return err
+	}
+	if !durable {
+		return h.moveFile(src, dst)
+	}
+
+	start := time.Now()
+	if err := syncFile(src); err != nil {
+		return err
+	}
+	elapsed := time.Since(start)
+
+	if err := h.moveFile(src, dst); err != nil {
+		return err
+	}
+
+	start = time.Now()
+	if err := syncDir(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	h.syncLatency.Observe((elapsed + time.Since(start)).Seconds())
+	return nil
+}
+
+func (h *Handler) syncInPlace(file *os.File, created bool) error {
+	start := time.Now()
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if created {
+		if err := syncDir(filepath.Dir(file.Name())); err != nil {
+			return err
+		}
+	}
+	h.syncLatency.Observe(time.Since(start).Seconds())
+	return nil
+}
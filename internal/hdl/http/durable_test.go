@@ -0,0 +1,72 @@
+package http
+
+import (
+	"bytes"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDurableWrites(t *testing.T) {
+	testDir := t.TempDir()
+
+	upload := func(h *Handler, name, query string) {
+		req := newUploadRequest(name, "durable", nil)
+		req.URL.RawQuery = query
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+
+		data, err := os.ReadFile(filepath.Join(testDir, name))
+		assert.Nil(t, err)
+		assert.Equal(t, "durable", string(data))
+	}
+
+	t.Run(
+		"Off by default", func(t *testing.T) {
+			hdl := setupTestHandler(testDir)
+			upload(hdl, "fast.txt", "")
+			assert.Equal(t, uint64(0), hdl.syncLatency.Count())
+		},
+	)
+
+	t.Run(
+		"Per-request override", func(t *testing.T) {
+			hdl := setupTestHandler(testDir)
+			upload(hdl, "override.txt", "durable=true")
+			assert.Equal(t, uint64(1), hdl.syncLatency.Count())
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			assert.Contains(t, rec.Body.String(), "media_server_upload_sync_seconds_count 1")
+			assert.Contains(t, rec.Body.String(), `media_server_upload_sync_seconds_bucket{le="+Inf"} 1`)
+		},
+	)
+
+	t.Run(
+		"Global flag", func(t *testing.T) {
+			hdl := New(port, testDir, &config.HTTPConfig{MaxUploadSize: 1024 * 1024, DurableWrites: true})
+			upload(hdl, "global.txt", "")
+			assert.Equal(t, uint64(1), hdl.syncLatency.Count())
+
+			hdl.crossDevice = true
+			upload(hdl, "copied.txt", "")
+			assert.Equal(t, uint64(2), hdl.syncLatency.Count())
+		},
+	)
+
+	t.Run(
+		"Resumable uploads", func(t *testing.T) {
+			hdl := setupTestHandler(testDir)
+			req := httptest.NewRequest(http.MethodPatch, "/files/patched.txt?durable=true", bytes.NewReader([]byte("chunk")))
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, uint64(1), hdl.syncLatency.Count())
+		},
+	)
+}
@@ -0,0 +1,170 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// emptySHA256 is the SHA-256 of no bytes.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func TestEmptyFiles(t *testing.T) {
+	testDir := t.TempDir()
+
+	newHandler := func(allow *bool) *Handler {
+		return New(
+			port, testDir, &config.HTTPConfig{
+				MaxUploadSize:   1024,
+				MaxStreamBuffer: 1024,
+				DefaultPage:     1,
+				DefaultSize:     10,
+				AllowEmptyFiles: allow,
+				Images:          &config.ImagesConfig{Presets: map[string]string{"thumb": "150x150 cover"}},
+				Previews:        &config.PreviewsConfig{Command: []string{"false", "{input}", "{output}"}},
+				Multipart:       &config.MultipartConfig{MinPartSize: 4},
+			},
+		)
+	}
+	hdl := newHandler(nil)
+
+	do := func(hdl *Handler, method, target string, body []byte, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	upload := func(hdl *Handler, name string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, newUploadRequest(name, "", nil))
+		return rec
+	}
+	assertCode := func(rec *httptest.ResponseRecorder, status int, code string) {
+		assert.Equal(t, status, rec.Code)
+		res := utils.ErrorResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		assert.Equal(t, code, res.Code)
+	}
+
+	for _, name := range []string{"empty.mp4", "empty.pdf", "empty.png", "empty.mp3"} {
+		assert.Equal(t, http.StatusCreated, upload(hdl, name).Code)
+	}
+
+	t.Run(
+		"Uploads are accepted by default", func(t *testing.T) {
+			m, err := hdl.meta.Get("empty.mp4")
+			assert.Nil(t, err)
+			assert.Equal(t, int64(0), m.Size)
+			assert.Equal(t, emptySHA256, m.Checksum)
+		},
+	)
+
+	t.Run(
+		"Stream", func(t *testing.T) {
+			rec := do(hdl, http.MethodGet, "/stream/empty.mp4", nil)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "0", rec.Header().Get("Content-Length"))
+			assert.Empty(t, rec.Header().Get("Transfer-Encoding"))
+			assert.Empty(t, rec.Body.String())
+
+			rec = do(hdl, http.MethodHead, "/stream/empty.mp4", nil)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "0", rec.Header().Get("Content-Length"))
+		},
+	)
+
+	t.Run(
+		"Any range is unsatisfiable", func(t *testing.T) {
+			for _, target := range []string{"/stream/empty.mp4", "/uploads/empty.mp4"} {
+				for _, spec := range []string{"bytes=0-", "bytes=0-0", "bytes=-1", "bytes=0-1,5-"} {
+					rec := do(hdl, http.MethodGet, target, nil, "Range", spec)
+					assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, rec.Code, target+" "+spec)
+					assert.Equal(t, "bytes */0", rec.Header().Get("Content-Range"), target+" "+spec)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"Checksum", func(t *testing.T) {
+			rec := do(hdl, http.MethodGet, "/files/empty.mp4/checksum", nil)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			res := map[string]string{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.Equal(t, emptySHA256, res["checksum"])
+
+			// Files written outside the API are hashed on demand.
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, "outside.mp4"), nil, 0644))
+			rec = do(hdl, http.MethodGet, "/files/outside.mp4/checksum", nil)
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.Equal(t, emptySHA256, res["checksum"])
+		},
+	)
+
+	t.Run(
+		"Thumbnails", func(t *testing.T) {
+			assertCode(do(hdl, http.MethodGet, "/thumb/empty.pdf", nil), http.StatusUnsupportedMediaType, "empty_file")
+			assertCode(do(hdl, http.MethodGet, "/img/thumb/empty.png", nil), http.StatusUnsupportedMediaType, "empty_file")
+			assertCode(do(hdl, http.MethodGet, "/files/empty.mp3/waveform", nil), http.StatusUnsupportedMediaType, "empty_file")
+		},
+	)
+
+	t.Run(
+		"List", func(t *testing.T) {
+			res := struct {
+				Data []ListEntry `json:"data"`
+			}{}
+			rec := do(hdl, http.MethodGet, "/list?include=meta", nil)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+
+			var found *meta.File
+			for _, e := range res.Data {
+				if e.Meta != nil && e.Meta.Name == "empty.mp4" {
+					found = e.Meta
+				}
+			}
+			if assert.NotNil(t, found) {
+				assert.Equal(t, int64(0), found.Size)
+				assert.Equal(t, emptySHA256, found.Checksum)
+			}
+		},
+	)
+
+	t.Run(
+		"Rejected when disallowed", func(t *testing.T) {
+			allow := false
+			strict := newHandler(&allow)
+
+			assertCode(upload(strict, "refused.mp4"), http.StatusUnprocessableEntity, "empty_file")
+			assert.NoFileExists(t, filepath.Join(testDir, "refused.mp4"))
+
+			assertCode(do(strict, http.MethodPatch, "/files/patched.mp4", nil), http.StatusUnprocessableEntity, "empty_file")
+			assert.NoFileExists(t, filepath.Join(testDir, "patched.mp4"))
+
+			rec := do(strict, http.MethodPost, "/mpu", []byte(`{"name":"parts.mp4"}`))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			u := MultipartUpload{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&u))
+			assert.Equal(t, http.StatusOK, do(strict, http.MethodPut, "/mpu/"+u.ID+"/parts/1", nil).Code)
+			body, _ := json.Marshal(completeRequest{Parts: []MultipartPart{{Number: 1, Checksum: emptySHA256}}})
+			assertCode(do(strict, http.MethodPost, "/mpu/"+u.ID+"/complete", body), http.StatusUnprocessableEntity, "empty_file")
+			assert.NoFileExists(t, filepath.Join(testDir, "parts.mp4"))
+
+			// Files that already exist may still be appended to with nothing.
+			assert.Equal(t, http.StatusOK, do(strict, http.MethodPatch, "/files/empty.mp4", nil).Code)
+			assert.Equal(t, http.StatusCreated, upload(newHandler(nil), "allowed.mp4").Code)
+		},
+	)
+}
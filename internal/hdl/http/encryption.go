@@ -0,0 +1,316 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/JMURv/media-server/internal/crypt"
+	"github.com/JMURv/media-server/internal/storage"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const sealPrefix = ".seal-"
+
+// storedFile is a stored file opened for reading, its content decrypted when
+// it is encrypted at rest.
+type storedFile interface {
+	io.ReadSeekCloser
+	Stat() (fs.FileInfo, error)
+}
+
+// unseal returns a reader of the content of file, decrypting it when the
+// file is encrypted. file is closed on error.
+func (h *Handler) unseal(ctx context.Context, file *os.File) (storedFile, error) {
+	if h.crypt == nil || !crypt.IsEncrypted(file) {
+		return file, nil
+	}
+	f, err := h.crypt.Open(ctx, file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (h *Handler) openStored(ctx context.Context, path string) (storedFile, error) {
+	file, err := openShared(path, h.openFlags(os.O_RDONLY))
+	if err != nil {
+		return nil, err
+	}
+	return h.unseal(ctx, file)
+}
+
+// sealedFile is a stored file served by http.FileServer.
+type sealedFile struct {
+	storedFile
+}
+
+func (sealedFile) Readdir(int) ([]fs.FileInfo, error) {
+	return nil, errors.New("not a directory")
+}
+
+// storedInfo returns info of the file at path with the size of its content,
+// which for an encrypted file is less than it takes on disk.
+func (h *Handler) storedInfo(path string, info fs.FileInfo) fs.FileInfo {
+	if h.crypt == nil || !info.Mode().IsRegular() {
+		return info
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return info
+	}
+	defer file.Close()
+
+	if !crypt.IsEncrypted(file) {
+		return info
+	}
+	if plain, err := crypt.PlainInfo(info); err == nil {
+		return plain
+	}
+	return info
+}
+
+func (h *Handler) statStored(path string) (fs.FileInfo, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return h.storedInfo(path, info), nil
+}
+
+// hashStored is hashFile over the content of a stored file.
+func (h *Handler) hashStored(ctx context.Context, path string, hash hash.Hash) (string, error) {
+	if h.crypt == nil {
+		return hashFile(ctx, path, hash)
+	}
+	if err := storage.Canceled(ctx); err != nil {
+		return "", err
+	}
+	file, err := h.openStored(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err = io.Copy(hash, storage.Reader(ctx, file)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (h *Handler) storedChecksum(ctx context.Context, path string) (string, error) {
+	return h.hashStored(ctx, path, sha256.New())
+}
+
+// plainPath returns a path external tools can read the content of the
+// stored file at path from: path itself, or a decrypted copy in the temp
+// dir, removed by the returned func.
+func (h *Handler) plainPath(ctx context.Context, path string) (string, func(), error) {
+	if h.crypt == nil {
+		return path, func() {}, nil
+	}
+	file, err := h.openStored(ctx, path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+	if _, ok := file.(*os.File); ok {
+		return path, func() {}, nil
+	}
+
+	tmp, err := os.CreateTemp(h.tempDir, ".plain-*"+filepath.Ext(path))
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = io.Copy(tmp, storage.Reader(ctx, file))
+	if err = errors.Join(err, tmp.Close()); err != nil {
+		os.Remove(tmp.Name())
+		return "", nil, err
+	}
+	return tmp.Name(), func() { os.Remove(tmp.Name()) }, nil
+}
+
+// sealFile encrypts the file at path in place, keeping its mode and
+// modification time, and reports whether it did: without encryption, or for
+// a file encrypted already, it does nothing. The encrypted copy replaces the
+// file at once, so the file is never seen part way.
+func (h *Handler) sealFile(ctx context.Context, path string, durable bool) (bool, error) {
+	if h.crypt == nil {
+		return false, nil
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+
+	if crypt.IsEncrypted(src) {
+		return false, nil
+	}
+	info, err := src.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), sealPrefix+"*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Chmod(info.Mode().Perm())
+
+	err = h.crypt.Encrypt(ctx, tmp, storage.Reader(ctx, src))
+	if err == nil && durable {
+		err = tmp.Sync()
+	}
+	if err = errors.Join(err, tmp.Close()); err != nil {
+		return false, err
+	}
+	src.Close()
+
+	os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime())
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return false, err
+	}
+	if durable {
+		return true, syncDir(filepath.Dir(path))
+	}
+	return true, nil
+}
+
+// sealable reports whether the file at rel, relative to the save path,
+// holds content to encrypt: stored files, and those in the trash or kept as
+// versions or originals, but not the records kept next to them.
+func sealable(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	first, rest, nested := strings.Cut(rel, "/")
+	if !strings.HasPrefix(first, ".") {
+		return !strings.HasPrefix(filepath.Base(rel), ".")
+	}
+	if !nested || strings.HasPrefix(filepath.Base(rest), ".") {
+		return false
+	}
+	switch first {
+	case trashDir, versionsDir:
+		return !strings.HasSuffix(rest, ".json")
+	case originalsDir:
+		return true
+	}
+	return false
+}
+
+// walkSealable calls fn for every file holding content to encrypt, removing
+// the encrypted copies an interrupted run left behind.
+func (h *Handler) walkSealable(ctx context.Context, fn func(path, rel string) error) error {
+	return h.walkRootsDir(
+		func(root, p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if err = ctx.Err(); err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			if strings.HasPrefix(d.Name(), sealPrefix) {
+				return os.Remove(p)
+			}
+			rel, _ := filepath.Rel(root, p)
+			if !sealable(rel) {
+				return nil
+			}
+			return fn(p, filepath.ToSlash(rel))
+		},
+	)
+}
+
+// EncryptReport is the outcome of EncryptStored or RotateKeys.
+type EncryptReport struct {
+	Done    int      `json:"done"`
+	Skipped int      `json:"skipped"`
+	Failed  []string `json:"failed,omitempty"`
+}
+
+// EncryptStored encrypts in place the files stored before encryption was
+// enabled, the trash and kept versions and originals included. Files are
+// replaced one at a time and those encrypted already skipped, so a run that
+// was interrupted resumes when run again. Writes by a server running on the
+// same save path from another process are not waited for.
+func (h *Handler) EncryptStored(ctx context.Context) (*EncryptReport, error) {
+	if h.crypt == nil {
+		return nil, ErrEncryptionDisabled
+	}
+
+	res := &EncryptReport{}
+	err := h.walkSealable(
+		ctx, func(path, rel string) error {
+			unlock := h.fileMu.Lock(rel)
+			sealed, err := h.sealFile(ctx, path, true)
+			unlock()
+			h.invalidateFile(rel)
+
+			switch {
+			case err != nil && ctx.Err() != nil:
+				return ctx.Err()
+			case err != nil:
+				log.Printf("Error encrypting %s: %s\n", rel, err)
+				res.Failed = append(res.Failed, rel)
+			case sealed:
+				res.Done++
+			default:
+				res.Skipped++
+			}
+			return nil
+		},
+	)
+	return res, err
+}
+
+// RotateKeys wraps the data key of every encrypted file with the active
+// master key, leaving the content as it is. Files wrapped with it already
+// are skipped, so it may be run again after an interruption.
+func (h *Handler) RotateKeys(ctx context.Context) (*EncryptReport, error) {
+	if h.crypt == nil {
+		return nil, ErrEncryptionDisabled
+	}
+
+	res := &EncryptReport{}
+	err := h.walkSealable(
+		ctx, func(path, rel string) error {
+			file, err := os.OpenFile(path, os.O_RDWR, 0)
+			if err != nil {
+				log.Printf("Error opening %s: %s\n", rel, err)
+				res.Failed = append(res.Failed, rel)
+				return nil
+			}
+			defer file.Close()
+
+			if !crypt.IsEncrypted(file) {
+				res.Skipped++
+				return nil
+			}
+			rewrapped, err := h.crypt.Rewrap(ctx, file)
+			switch {
+			case err != nil && ctx.Err() != nil:
+				return ctx.Err()
+			case err != nil:
+				log.Printf("Error rewrapping the key of %s: %s\n", rel, err)
+				res.Failed = append(res.Failed, rel)
+			case rewrapped:
+				res.Done++
+			default:
+				res.Skipped++
+			}
+			return nil
+		},
+	)
+	return res, err
+}
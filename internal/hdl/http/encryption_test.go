@@ -0,0 +1,185 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/crypt"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncryption(t *testing.T) {
+	testDir := t.TempDir()
+
+	k1 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	k2 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+	newHandler := func(keys map[string]string, active string) *Handler {
+		return New(
+			port, testDir, &config.HTTPConfig{
+				MaxUploadSize: 1 << 20,
+				DefaultPage:   1,
+				DefaultSize:   10,
+				Encryption:    &config.EncryptionConfig{Keys: keys, ActiveKey: active},
+			},
+		)
+	}
+	hdl := newHandler(map[string]string{"k1": k1}, "k1")
+
+	content := strings.Repeat("0123456789", 10000)
+	serve := func(h *Handler, req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	get := func(h *Handler, target string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		return serve(h, req)
+	}
+	encrypted := func(name string) bool {
+		f, err := os.Open(filepath.Join(testDir, name))
+		if !assert.Nil(t, err) {
+			return false
+		}
+		defer f.Close()
+		return crypt.IsEncrypted(f)
+	}
+
+	t.Run(
+		"Uploads are stored encrypted", func(t *testing.T) {
+			rec := serve(hdl, newUploadRequest("movie.mp4", content, nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			etag := rec.Header().Get("ETag")
+			res := utils.UploadResponse{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+
+			raw, err := os.ReadFile(filepath.Join(testDir, "movie.mp4"))
+			assert.Nil(t, err)
+			assert.True(t, encrypted("movie.mp4"))
+			assert.NotContains(t, string(raw), content[:100])
+
+			rec = get(hdl, "/stream/movie.mp4", nil)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, content, rec.Body.String())
+			assert.Equal(t, etag, rec.Header().Get("ETag"))
+
+			rec = get(hdl, "/uploads/movie.mp4", nil)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, content, rec.Body.String())
+		},
+	)
+
+	t.Run(
+		"Ranges cross chunks", func(t *testing.T) {
+			rec := get(hdl, "/stream/movie.mp4", map[string]string{"Range": "bytes=65530-65545"})
+			assert.Equal(t, http.StatusPartialContent, rec.Code)
+			assert.Equal(t, content[65530:65546], rec.Body.String())
+			assert.Equal(t, "bytes 65530-65545/100000", rec.Header().Get("Content-Range"))
+
+			rec = get(hdl, "/uploads/movie.mp4", map[string]string{"Range": "bytes=99990-"})
+			assert.Equal(t, http.StatusPartialContent, rec.Code)
+			assert.Equal(t, content[99990:], rec.Body.String())
+		},
+	)
+
+	t.Run(
+		"Checksums are of the content", func(t *testing.T) {
+			sum := md5.Sum([]byte(content))
+			rec := get(hdl, "/files/movie.mp4/checksum?algo=md5", nil)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Contains(t, rec.Body.String(), hex.EncodeToString(sum[:]))
+
+			report, err := hdl.checkConsistency(context.Background(), true)
+			assert.Nil(t, err)
+			assert.Empty(t, report.Discrepancies)
+		},
+	)
+
+	t.Run(
+		"In place writes are refused", func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, "/files/movie.mp4", strings.NewReader("x"))
+			req.Header.Set("Content-Range", "bytes 0-0/*")
+			rec := serve(hdl, req)
+			assert.Equal(t, http.StatusConflict, rec.Code)
+			assert.Contains(t, rec.Body.String(), "encryption_unsupported")
+		},
+	)
+
+	t.Run(
+		"Existing files are migrated", func(t *testing.T) {
+			old := filepath.Join(testDir, "old.mp4")
+			assert.Nil(t, os.WriteFile(old, []byte("stored before encryption"), 0644))
+			mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+			assert.Nil(t, os.Chtimes(old, mtime, mtime))
+			// A copy left by an interrupted run.
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, sealPrefix+"123"), []byte("partial"), 0644))
+
+			res, err := hdl.EncryptStored(context.Background())
+			assert.Nil(t, err)
+			assert.Equal(t, 1, res.Done)
+			assert.Equal(t, 1, res.Skipped)
+			assert.Empty(t, res.Failed)
+			assert.NoFileExists(t, filepath.Join(testDir, sealPrefix+"123"))
+
+			assert.True(t, encrypted("old.mp4"))
+			info, err := os.Stat(old)
+			assert.Nil(t, err)
+			assert.True(t, info.ModTime().Equal(mtime))
+			assert.Equal(t, "stored before encryption", get(hdl, "/stream/old.mp4", nil).Body.String())
+
+			res, err = hdl.EncryptStored(context.Background())
+			assert.Nil(t, err)
+			assert.Equal(t, 0, res.Done)
+			assert.Equal(t, 2, res.Skipped)
+		},
+	)
+
+	t.Run(
+		"Keys are rotated", func(t *testing.T) {
+			before, err := os.ReadFile(filepath.Join(testDir, "movie.mp4"))
+			assert.Nil(t, err)
+
+			rotating := newHandler(map[string]string{"k1": k1, "k2": k2}, "k2")
+			res, err := rotating.RotateKeys(context.Background())
+			assert.Nil(t, err)
+			assert.Equal(t, 2, res.Done)
+			assert.Empty(t, res.Failed)
+
+			after, err := os.ReadFile(filepath.Join(testDir, "movie.mp4"))
+			assert.Nil(t, err)
+			assert.Equal(t, before[crypt.HeaderSize:], after[crypt.HeaderSize:])
+
+			rotated := newHandler(map[string]string{"k2": k2}, "k2")
+			assert.Equal(t, content, get(rotated, "/stream/movie.mp4", nil).Body.String())
+			body, err := io.ReadAll(get(rotated, "/uploads/old.mp4", nil).Body)
+			assert.Nil(t, err)
+			assert.Equal(t, "stored before encryption", string(body))
+
+			res, err = rotated.RotateKeys(context.Background())
+			assert.Nil(t, err)
+			assert.Equal(t, 0, res.Done)
+		},
+	)
+
+	t.Run(
+		"Disabled", func(t *testing.T) {
+			_, err := setupTestHandler(testDir).EncryptStored(context.Background())
+			assert.Equal(t, ErrEncryptionDisabled, err)
+		},
+	)
+}
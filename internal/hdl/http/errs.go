@@ -1,14 +1,440 @@
 package http
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"github.com/JMURv/media-server/internal/imaging"
+	"github.com/JMURv/media-server/internal/jobs"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/storage"
+	"github.com/JMURv/media-server/internal/usage"
+	"github.com/JMURv/media-server/internal/webhook"
+	"github.com/JMURv/media-server/pkg/messages"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"log"
+	"net/http"
+)
 
-var ErrFileTooBig = errors.New("file too big")
-var ErrAlreadyExists = errors.New("file already exists")
+var ErrFileTooBig = classify(errors.New("file too big"), storage.ErrTooLarge)
+var ErrAlreadyExists = classify(errors.New("file already exists"), storage.ErrExists)
 var ErrInvalidReqMethod = errors.New("invalid request method")
 var ErrInternal = errors.New("internal error")
 
-var ErrFilenameNotProvided = errors.New("filename not provided")
+var ErrFilenameNotProvided = classify(errors.New("filename not provided"), storage.ErrInvalidName)
+var ErrDotSegment = classify(errors.New("name has a . or .. segment"), storage.ErrInvalidName)
+var ErrNameTooLong = classify(errors.New("name is too long"), storage.ErrInvalidName)
+var ErrEncodedSlash = errors.New("encoded slash in name")
+var ErrDiskFull = errors.New("no space left to store the upload")
+var ErrBodyTooLarge = errors.New("request body too large")
 var ErrRetrievingFile = errors.New("error retrieving file")
 var ErrParsingForm = errors.New("error parsing form")
 var ErrReadingDir = errors.New("error reading directory")
 var ErrUnsupportedMediaType = errors.New("unsupported media type")
+var ErrInvalidNaming = errors.New("invalid naming strategy")
+var ErrInvalidContentRange = errors.New("invalid content range")
+var ErrBodyLength = errors.New("body length does not match content range")
+var ErrLocked = errors.New("file is locked")
+var ErrLockNotHeld = errors.New("lock not held")
+var ErrLockTokenRequired = errors.New("lock token required")
+var ErrInvalidLockTTL = errors.New("invalid lock ttl")
+var ErrNotFound = classify(errors.New("file not found"), storage.ErrNotFound)
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+var ErrNoLifecycleReport = errors.New("lifecycle report not available")
+var ErrLifecycleDisabled = errors.New("lifecycle rules are disabled")
+var ErrInvalidArchive = errors.New("invalid archive")
+var ErrInvalidConflictPolicy = errors.New("invalid conflict policy")
+var ErrReplicationDisabled = errors.New("replication is disabled")
+var ErrPeerUnavailable = errors.New("replication peer unavailable")
+var ErrInvalidUploadID = errors.New("invalid upload id")
+var ErrUploadNotFound = classify(errors.New("upload not found"), storage.ErrNotFound)
+var ErrInvalidMinSize = errors.New("invalid min size")
+var ErrJobsDisabled = errors.New("post-processing jobs are disabled")
+var ErrInvalidRange = errors.New("invalid range")
+var ErrTooManyRanges = errors.New("too many ranges")
+var ErrUploadTimeout = errors.New("upload exceeded maximum duration")
+var ErrUploadTooSlow = errors.New("upload transfer rate too low")
+var ErrForbiddenType = errors.New("content type is not allowed")
+var ErrDecodeRequest = errors.New("error decoding request")
+var ErrFileChanged = errors.New("file changed since session was created")
+var ErrSessionNotFound = classify(errors.New("download session not found"), storage.ErrNotFound)
+var ErrInvalidOffset = errors.New("invalid offset")
+var ErrSavePathMissing = errors.New("save path does not exist")
+var ErrSavePathNotDir = errors.New("save path is not a directory")
+var ErrSavePathNotWritable = errors.New("save path is not writable")
+var ErrUnsafeSavePath = errors.New("unsafe save path")
+var ErrContentTypeMismatch = errors.New("file content does not match its type")
+var ErrInvalidContentType = errors.New("invalid content type")
+var ErrImagesDisabled = errors.New("image transformations are disabled")
+var ErrInvalidSignature = errors.New("invalid signature")
+var ErrPresetNotFound = classify(errors.New("preset not found"), storage.ErrNotFound)
+var ErrTranscodeDisabled = errors.New("transcoding is disabled")
+var ErrTranscodeNeedsJobs = errors.New("transcoding requires jobs")
+var ErrInvalidClip = errors.New("invalid clip range")
+var ErrClipNotFound = errors.New("clip not found")
+var ErrWarmupNeedsJobs = errors.New("preset warmup requires jobs")
+var ErrInvalidPreset = errors.New("invalid preset")
+var ErrInvalidPoints = errors.New("invalid number of points")
+var ErrPreviewsDisabled = errors.New("previews are disabled")
+var ErrPreviewFailed = errors.New("preview generation failed")
+var ErrInvalidPreviewCommand = errors.New("invalid preview command")
+var ErrInvalidConvertCommand = errors.New("invalid convert command")
+var ErrConvertFailed = errors.New("conversion failed")
+var ErrOriginalNotKept = errors.New("original is not kept")
+var ErrPresetRequired = errors.New("only preset transformations are allowed")
+var ErrSigningSecretRequired = errors.New("signing secret required")
+var ErrDebugAddrRequired = errors.New("debug listener address required")
+var ErrBandwidthBudgetRequired = errors.New("bandwidth budget required")
+var ErrAdoptNeedsJobs = errors.New("adopting files requires jobs")
+var ErrAdoptDisabled = errors.New("adopting files is disabled")
+var ErrSourceNotAllowed = errors.New("source path is not allowed")
+var ErrInvalidAdoptMode = errors.New("invalid adopt mode")
+var ErrAdoptionNotFound = errors.New("adoption not found")
+var ErrNotRegularFile = errors.New("not a regular file")
+var ErrInvalidBenchSize = errors.New("invalid benchmark size")
+var ErrBenchRunning = errors.New("benchmark already running")
+var ErrUnsafeTempDir = errors.New("unsafe temp directory")
+var ErrTempDirCrossDevice = errors.New("temp directory is on a different filesystem")
+var ErrSymlinkNotAllowed = errors.New("symbolic links are not allowed")
+var ErrSymlinkOutsideRoot = errors.New("symbolic link points outside the save path")
+var ErrInternalPath = errors.New("path is reserved for internal use")
+var ErrAdminRequired = errors.New("admin role required")
+var ErrAccessDenied = errors.New("access denied by policy")
+var ErrAuthRequired = errors.New("authentication required")
+var ErrInvalidBuffer = errors.New("invalid buffer size")
+var ErrRequestCancelled = errors.New("request cancelled by admin")
+var ErrOverloaded = errors.New("server is overloaded, retry later")
+var ErrRequestNotFound = classify(errors.New("request not found"), storage.ErrNotFound)
+var ErrHeld = errors.New("file is under a retention hold")
+var ErrNotHeld = errors.New("file is not under a retention hold")
+var ErrInvalidHoldUntil = errors.New("invalid hold until time")
+var ErrChangesDisabled = errors.New("change journal is disabled")
+var ErrInvalidCursor = errors.New("invalid cursor")
+var ErrInvalidLimit = errors.New("invalid limit")
+var ErrMultipartDisabled = errors.New("multipart uploads are disabled")
+var ErrInvalidPartNumber = errors.New("invalid part number")
+var ErrPartNotFound = classify(errors.New("part not found"), storage.ErrNotFound)
+var ErrPartTooSmall = errors.New("part is smaller than the minimum part size")
+var ErrNoParts = errors.New("no parts to complete")
+var ErrInvalidIgnore = errors.New("invalid ignore pattern")
+var ErrDirectoryFull = classify(errors.New("directory is full; store files under nested paths or shard them by date"), storage.ErrQuotaExceeded)
+var ErrLogsDisabled = errors.New("log buffer is disabled")
+var ErrInvalidLevel = errors.New("invalid log level")
+var ErrReadRequired = errors.New("read or admin role required")
+var ErrInvalidFormat = errors.New("invalid format")
+var ErrInvalidSince = errors.New("invalid since time")
+var ErrTooManyEntries = errors.New("too many entries")
+var ErrInvalidAlgo = errors.New("invalid checksum algorithm")
+var ErrUploadRejected = errors.New("upload rejected")
+var ErrEmptyFile = errors.New("file is empty")
+var ErrValidationFailed = errors.New("upload validation failed")
+var ErrInvalidIdempotencyKey = errors.New("invalid idempotency key")
+var ErrIdempotencyKeyReused = errors.New("idempotency key was used for different content")
+var ErrEncryptionUnsupported = errors.New("not supported with encryption at rest")
+var ErrEncryptionDisabled = errors.New("encryption is disabled")
+var ErrReceiptsDisabled = errors.New("receipts are disabled")
+var ErrInvalidReceipt = errors.New("invalid receipt")
+var ErrInvalidChecksum = errors.New("invalid checksum")
+var ErrChecksumConflict = errors.New("file already exists with a different checksum")
+var ErrUsageDisabled = errors.New("usage reporting is disabled")
+var ErrTrafficDisabled = errors.New("traffic accounting is disabled")
+var ErrBrowseDisabled = errors.New("directory browsing is disabled")
+var ErrReportDisabled = errors.New("reports are disabled")
+var ErrReportFailed = errors.New("report could not be delivered")
+var ErrInvalidSort = errors.New("invalid sort key")
+var ErrInvalidDay = errors.New("invalid day")
+var ErrInvalidTrafficRange = errors.New("invalid traffic range")
+var ErrInvalidGroupBy = errors.New("invalid group by")
+var ErrInvalidDepth = errors.New("invalid depth")
+var ErrInvalidAsOf = errors.New("invalid as of time")
+var ErrTrashDisabled = errors.New("trash is disabled")
+var ErrVersionsDisabled = errors.New("version history is disabled")
+var ErrShadowDisabled = errors.New("shadow backend is disabled")
+var ErrPreconditionFailed = errors.New("file does not match the precondition")
+var ErrCoalesceTimeout = errors.New("timed out waiting for an identical request")
+var ErrNoFilePart = errors.New("no file part")
+var ErrFileProcessing = errors.New("file is still processing")
+var ErrQuarantined = errors.New("file is quarantined")
+var ErrProcessingFailed = errors.New("file failed processing")
+var ErrInvalidState = errors.New("invalid state")
+var ErrCursorRestart = errors.New("cursor is invalid or expired")
+var ErrUnavailable = errors.New("file is outside its availability window")
+var ErrInvalidAvailability = errors.New("invalid availability window")
+var ErrInvalidKind = errors.New("invalid kind")
+var ErrInvalidGroup = errors.New("invalid group")
+var ErrRootsFull = classify(errors.New("no storage root has room for the file"), storage.ErrQuotaExceeded)
+var ErrInvalidPlacement = errors.New("invalid placement")
+var ErrRootRemoved = errors.New("storage roots cannot be removed while running")
+var ErrRootsDisabled = errors.New("storage roots are not configured")
+var ErrFileDeleted = errors.New("file was deleted")
+
+// classified keeps an error's own message while also matching the storage
+// sentinel it belongs to, so embedders can test errors.Is(err,
+// storage.ErrExists) without knowing the handler's more specific errors.
+type classified struct {
+	err  error
+	kind error
+}
+
+func classify(err, kind error) error {
+	return &classified{err: err, kind: kind}
+}
+
+func (e *classified) Error() string {
+	return e.err.Error()
+}
+
+func (e *classified) Unwrap() []error {
+	return []error{e.err, e.kind}
+}
+
+// statusCodes maps errors to HTTP statuses and to the stable codes clients
+// match on, checked in order with errors.Is. Codes never change once
+// released; messages may. Errors classified under a storage sentinel fall
+// through to its entry unless they have their own.
+var statusCodes = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrDecodeRequest, http.StatusBadRequest, "decode_request"},
+	{ErrParsingForm, http.StatusBadRequest, "parsing_form"},
+	{ErrInvalidNaming, http.StatusBadRequest, "invalid_naming"},
+	{ErrInvalidContentRange, http.StatusBadRequest, "invalid_content_range"},
+	{ErrBodyLength, http.StatusBadRequest, "body_length"},
+	{ErrLockTokenRequired, http.StatusBadRequest, "lock_token_required"},
+	{ErrInvalidLockTTL, http.StatusBadRequest, "invalid_lock_ttl"},
+	{ErrChecksumMismatch, http.StatusBadRequest, "checksum_mismatch"},
+	{ErrInvalidArchive, http.StatusBadRequest, "invalid_archive"},
+	{ErrInvalidConflictPolicy, http.StatusBadRequest, "invalid_conflict_policy"},
+	{ErrInvalidAdoptMode, http.StatusBadRequest, "invalid_adopt_mode"},
+	{ErrInvalidUploadID, http.StatusBadRequest, "invalid_upload_id"},
+	{ErrInvalidMinSize, http.StatusBadRequest, "invalid_min_size"},
+	{ErrInvalidContentType, http.StatusBadRequest, "invalid_content_type"},
+	{ErrInvalidPreset, http.StatusBadRequest, "invalid_preset"},
+	{ErrInvalidClip, http.StatusBadRequest, "invalid_clip"},
+	{ErrInvalidIdempotencyKey, http.StatusBadRequest, "invalid_idempotency_key"},
+	{ErrInvalidPoints, http.StatusBadRequest, "invalid_points"},
+	{ErrInvalidBenchSize, http.StatusBadRequest, "invalid_bench_size"},
+	{ErrInvalidBuffer, http.StatusBadRequest, "invalid_buffer"},
+	{ErrInvalidHoldUntil, http.StatusBadRequest, "invalid_hold_until"},
+	{ErrInvalidCursor, http.StatusBadRequest, "invalid_cursor"},
+	{ErrCursorRestart, http.StatusBadRequest, "restart_listing"},
+	{ErrInvalidLimit, http.StatusBadRequest, "invalid_limit"},
+	{ErrInvalidPartNumber, http.StatusBadRequest, "invalid_part_number"},
+	{ErrPartTooSmall, http.StatusBadRequest, "part_too_small"},
+	{ErrNoParts, http.StatusBadRequest, "no_parts"},
+	{ErrInvalidLevel, http.StatusBadRequest, "invalid_level"},
+	{ErrInvalidFormat, http.StatusBadRequest, "invalid_format"},
+	{ErrInvalidSince, http.StatusBadRequest, "invalid_since"},
+	{ErrInvalidAlgo, http.StatusBadRequest, "invalid_algo"},
+	{ErrInvalidChecksum, http.StatusBadRequest, "invalid_checksum"},
+	{ErrInvalidGroupBy, http.StatusBadRequest, "invalid_group_by"},
+	{ErrInvalidState, http.StatusBadRequest, "invalid_state"},
+	{ErrInvalidAvailability, http.StatusBadRequest, "invalid_availability"},
+	{ErrInvalidKind, http.StatusBadRequest, "invalid_kind"},
+	{ErrInvalidGroup, http.StatusBadRequest, "invalid_group"},
+	{ErrInvalidDepth, http.StatusBadRequest, "invalid_depth"},
+	{ErrInvalidAsOf, http.StatusBadRequest, "invalid_as_of"},
+	{ErrInvalidDay, http.StatusBadRequest, "invalid_day"},
+	{ErrInvalidTrafficRange, http.StatusBadRequest, "invalid_traffic_range"},
+	{imaging.ErrInvalidTransform, http.StatusBadRequest, "invalid_transform"},
+
+	{ErrAuthRequired, http.StatusUnauthorized, "auth_required"},
+	{ErrAdminRequired, http.StatusForbidden, "admin_required"},
+	{ErrAccessDenied, http.StatusForbidden, "access_denied"},
+	{ErrReadRequired, http.StatusForbidden, "read_required"},
+	{ErrInternalPath, http.StatusForbidden, "internal_path"},
+	{ErrSourceNotAllowed, http.StatusForbidden, "source_not_allowed"},
+	{ErrInvalidSignature, http.StatusForbidden, "invalid_signature"},
+	{ErrForbiddenType, http.StatusForbidden, "forbidden_type"},
+	{ErrPresetRequired, http.StatusForbidden, "preset_required"},
+	{ErrSymlinkNotAllowed, http.StatusForbidden, "symlink_not_allowed"},
+	{ErrSymlinkOutsideRoot, http.StatusForbidden, "symlink_outside_root"},
+
+	{ErrRetrievingFile, http.StatusNotFound, "retrieving_file"},
+	{ErrNoLifecycleReport, http.StatusNotFound, "no_lifecycle_report"},
+	{ErrLifecycleDisabled, http.StatusNotFound, "lifecycle_disabled"},
+	{ErrJobsDisabled, http.StatusNotFound, "jobs_disabled"},
+	{webhook.ErrDeadLetterNotFound, http.StatusNotFound, "dead_letter_not_found"},
+	{ErrTranscodeDisabled, http.StatusNotFound, "transcode_disabled"},
+	{ErrReplicationDisabled, http.StatusNotFound, "replication_disabled"},
+	{ErrPreviewsDisabled, http.StatusNotFound, "previews_disabled"},
+	{ErrImagesDisabled, http.StatusNotFound, "images_disabled"},
+	{ErrChangesDisabled, http.StatusNotFound, "changes_disabled"},
+	{ErrMultipartDisabled, http.StatusNotFound, "multipart_disabled"},
+	{ErrLogsDisabled, http.StatusNotFound, "logs_disabled"},
+	{ErrUsageDisabled, http.StatusNotFound, "usage_disabled"},
+	{ErrTrafficDisabled, http.StatusNotFound, "traffic_disabled"},
+	{ErrBrowseDisabled, http.StatusNotFound, "browse_disabled"},
+	{ErrReportDisabled, http.StatusNotFound, "report_disabled"},
+	{ErrEncodedSlash, http.StatusBadRequest, "encoded_slash"},
+	{ErrInvalidSort, http.StatusBadRequest, "invalid_sort"},
+	{usage.ErrNoSnapshot, http.StatusNotFound, "no_usage_snapshot"},
+	{ErrTrashDisabled, http.StatusNotFound, "trash_disabled"},
+	{ErrVersionsDisabled, http.StatusNotFound, "versions_disabled"},
+	{ErrShadowDisabled, http.StatusNotFound, "shadow_disabled"},
+	{ErrAdoptDisabled, http.StatusNotFound, "adopt_disabled"},
+	{ErrAdoptionNotFound, http.StatusNotFound, "adoption_not_found"},
+	{ErrClipNotFound, http.StatusNotFound, "clip_not_found"},
+
+	{ErrUploadTimeout, http.StatusRequestTimeout, "upload_timeout"},
+	{ErrUploadTooSlow, http.StatusRequestTimeout, "upload_too_slow"},
+	{ErrLockNotHeld, http.StatusConflict, "lock_not_held"},
+	{ErrNotHeld, http.StatusConflict, "not_held"},
+	{ErrFileChanged, http.StatusConflict, "file_changed"},
+	{ErrBenchRunning, http.StatusConflict, "bench_running"},
+	{ErrChecksumConflict, http.StatusConflict, "checksum_conflict"},
+	{ErrPreconditionFailed, http.StatusPreconditionFailed, "precondition_failed"},
+	{ErrFileDeleted, http.StatusGone, "file_deleted"},
+	{webhook.ErrTargetRemoved, http.StatusConflict, "webhook_target_removed"},
+	{meta.ErrCursorExpired, http.StatusGone, "cursor_expired"},
+	{ErrTooManyEntries, http.StatusRequestEntityTooLarge, "too_many_entries"},
+	{imaging.ErrImageTooLarge, http.StatusUnprocessableEntity, "image_too_large"},
+	{ErrUploadRejected, http.StatusUnprocessableEntity, "upload_rejected"},
+	{ErrEmptyFile, http.StatusUnprocessableEntity, "empty_file"},
+	{ErrIdempotencyKeyReused, http.StatusUnprocessableEntity, "idempotency_key_reused"},
+	{ErrEncryptionUnsupported, http.StatusConflict, "encryption_unsupported"},
+	{ErrReceiptsDisabled, http.StatusNotFound, "receipts_disabled"},
+	{ErrInvalidReceipt, http.StatusUnprocessableEntity, "invalid_receipt"},
+	{ErrUnsupportedMediaType, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+	{ErrContentTypeMismatch, http.StatusUnsupportedMediaType, "content_type_mismatch"},
+	{ErrInvalidRange, http.StatusRequestedRangeNotSatisfiable, "invalid_range"},
+	{ErrTooManyRanges, http.StatusRequestedRangeNotSatisfiable, "too_many_ranges"},
+	{ErrInvalidOffset, http.StatusRequestedRangeNotSatisfiable, "invalid_offset"},
+	{ErrLocked, http.StatusLocked, "locked"},
+	{ErrHeld, http.StatusLocked, "held"},
+	{ErrFileProcessing, http.StatusLocked, "file_processing"},
+	{ErrQuarantined, http.StatusConflict, "quarantined"},
+	{ErrUnavailable, http.StatusForbidden, "unavailable"},
+	{ErrProcessingFailed, http.StatusConflict, "processing_failed"},
+	{ErrPreviewFailed, http.StatusBadGateway, "preview_failed"},
+	{ErrOriginalNotKept, http.StatusNotFound, "original_not_kept"},
+	{ErrPeerUnavailable, http.StatusBadGateway, "peer_unavailable"},
+	{ErrReportFailed, http.StatusBadGateway, "report_failed"},
+	{ErrValidationFailed, http.StatusBadGateway, "validation_failed"},
+	{jobs.ErrNotFound, http.StatusNotFound, "job_not_found"},
+	{jobs.ErrQueueFull, http.StatusServiceUnavailable, "queue_full"},
+	{ErrOverloaded, http.StatusServiceUnavailable, "overloaded"},
+	{ErrRequestCancelled, statusClientClosedRequest, "request_cancelled"},
+	{context.Canceled, statusClientClosedRequest, "client_closed_request"},
+	{context.DeadlineExceeded, http.StatusGatewayTimeout, "deadline_exceeded"},
+	{ErrCoalesceTimeout, http.StatusGatewayTimeout, "coalesce_timeout"},
+	{ErrInternal, http.StatusInternalServerError, "internal_error"},
+	{ErrReadingDir, http.StatusInternalServerError, "reading_dir"},
+
+	{ErrNotFound, http.StatusNotFound, "file_not_found"},
+	{ErrUploadNotFound, http.StatusNotFound, "upload_not_found"},
+	{ErrSessionNotFound, http.StatusNotFound, "session_not_found"},
+	{ErrPresetNotFound, http.StatusNotFound, "preset_not_found"},
+	{ErrRequestNotFound, http.StatusNotFound, "request_not_found"},
+	{ErrPartNotFound, http.StatusNotFound, "part_not_found"},
+	{ErrAlreadyExists, http.StatusConflict, "file_exists"},
+	{ErrFileTooBig, http.StatusRequestEntityTooLarge, "file_too_big"},
+	{ErrBodyTooLarge, http.StatusRequestEntityTooLarge, "body_too_large"},
+	{ErrFilenameNotProvided, http.StatusBadRequest, "filename_not_provided"},
+	{ErrNoFilePart, http.StatusBadRequest, "no_file_part"},
+	{ErrDirectoryFull, http.StatusRequestEntityTooLarge, "directory_full"},
+	{ErrRootsFull, http.StatusInsufficientStorage, "roots_full"},
+	{ErrDiskFull, http.StatusInsufficientStorage, "disk_full"},
+
+	{storage.ErrNotFound, http.StatusNotFound, "not_found"},
+	{storage.ErrExists, http.StatusConflict, "already_exists"},
+	{storage.ErrTooLarge, http.StatusRequestEntityTooLarge, "too_large"},
+	{storage.ErrInvalidName, http.StatusBadRequest, "invalid_name"},
+	{storage.ErrQuotaExceeded, http.StatusRequestEntityTooLarge, "quota_exceeded"},
+}
+
+// StatusCode returns the HTTP status the handler answers err with, or 500
+// for errors it does not know.
+func StatusCode(err error) int {
+	for _, s := range statusCodes {
+		if errors.Is(err, s.err) {
+			return s.status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// ErrorCode returns the machine-readable code sent with err, or
+// "internal_error" for errors the handler does not know.
+func ErrorCode(err error) string {
+	for _, s := range statusCodes {
+		if errors.Is(err, s.err) {
+			return s.code
+		}
+	}
+	return "internal_error"
+}
+
+// paramError carries the values a localized message is filled in with, such
+// as the limit a file exceeded. They are also sent to clients as params.
+type paramError struct {
+	error
+	params map[string]any
+}
+
+func withParams(err error, params map[string]any) error {
+	return &paramError{error: err, params: params}
+}
+
+func (e *paramError) Unwrap() error {
+	return e.error
+}
+
+// retryable reports whether a request failing with status may succeed
+// when sent again unchanged: it timed out, was shed or cut short, or failed
+// on the server's side. A response telling clients when to retry always is.
+func retryable(w http.ResponseWriter, status int) bool {
+	if w.Header().Get("Retry-After") != "" {
+		return true
+	}
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests, statusClientClosedRequest:
+		return true
+	case http.StatusNotImplemented, http.StatusHTTPVersionNotSupported:
+		return false
+	}
+	return status >= http.StatusInternalServerError
+}
+
+// errorBody builds the error envelope for err, sent with status: its
+// message, stable code, params, whether to retry and, when the response
+// carries a language, the localized message.
+func errorBody(w http.ResponseWriter, status int, err error) utils.ErrorResponse {
+	res := utils.ErrorResponse{Error: err.Error(), Code: ErrorCode(err), Retryable: retryable(w, status)}
+
+	var p *paramError
+	if errors.As(err, &p) {
+		res.Params = p.params
+	}
+	if lang := responseLanguage(w); lang != "" {
+		if msg, ok := messages.Message(lang, res.Code, res.Params); ok {
+			res.Message = msg
+			w.Header().Set("Content-Language", lang)
+		}
+	}
+	return res
+}
+
+// errorResponse writes err with a status other than the one StatusCode
+// would pick for it.
+func errorResponse(w http.ResponseWriter, status int, err error) {
+	utils.JSONResponse(w, status, errorBody(w, status, err))
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := StatusCode(err)
+	if status == statusClientClosedRequest {
+		log.Printf("Client closed request: %s\n", err)
+	}
+	errorResponse(w, status, err)
+}
+
+// canceledOr returns err when it comes from a cancelled or expired context,
+// so it maps to 499 or 504, and fallback for any other failure.
+func canceledOr(err, fallback error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return fallback
+}
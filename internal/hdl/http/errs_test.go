@@ -0,0 +1,96 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/internal/jobs"
+	"github.com/JMURv/media-server/internal/storage"
+	"github.com/JMURv/media-server/pkg/filename"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	testDir := t.TempDir()
+
+	t.Run(
+		"Handler errors match storage sentinels", func(t *testing.T) {
+			assert.True(t, errors.Is(ErrAlreadyExists, storage.ErrExists))
+			assert.True(t, errors.Is(ErrFileTooBig, storage.ErrTooLarge))
+			assert.True(t, errors.Is(ErrNotFound, storage.ErrNotFound))
+			assert.True(t, errors.Is(ErrUploadNotFound, storage.ErrNotFound))
+			assert.True(t, errors.Is(ErrDirectoryFull, storage.ErrQuotaExceeded))
+			assert.False(t, errors.Is(ErrAlreadyExists, storage.ErrNotFound))
+			assert.Equal(t, "file already exists", ErrAlreadyExists.Error())
+		},
+	)
+
+	t.Run(
+		"Invalid names keep their cause", func(t *testing.T) {
+			hdl := setupTestHandler(testDir)
+			hdl.config.FilenamePolicy = filename.ModeReject
+			_, err := hdl.cleanName("bad\x01name.txt")
+			assert.True(t, errors.Is(err, storage.ErrInvalidName))
+			assert.True(t, errors.Is(err, filename.ErrControlChars))
+		},
+	)
+
+	t.Run(
+		"Status codes", func(t *testing.T) {
+			for err, code := range map[error]int{
+				ErrDecodeRequest:       http.StatusBadRequest,
+				ErrAdminRequired:       http.StatusForbidden,
+				ErrNotFound:            http.StatusNotFound,
+				ErrAlreadyExists:       http.StatusConflict,
+				ErrLocked:              http.StatusLocked,
+				ErrDirectoryFull:       http.StatusRequestEntityTooLarge,
+				jobs.ErrQueueFull:      http.StatusServiceUnavailable,
+				storage.ErrNotFound:    http.StatusNotFound,
+				storage.ErrTooLarge:    http.StatusRequestEntityTooLarge,
+				ErrInternal:            http.StatusInternalServerError,
+				errors.New("boom"):     http.StatusInternalServerError,
+				storage.ErrExists:      http.StatusConflict,
+				storage.ErrInvalidName: http.StatusBadRequest,
+				fmt.Errorf("stat x: %w", storage.ErrQuotaExceeded): http.StatusRequestEntityTooLarge,
+			} {
+				assert.Equal(t, code, StatusCode(err), err.Error())
+			}
+		},
+	)
+
+	t.Run(
+		"Local storage", func(t *testing.T) {
+			s := storage.NewLocal(testDir)
+			_, err := s.Open(context.Background(), "absent.txt")
+			assert.True(t, errors.Is(err, storage.ErrNotFound))
+			assert.Contains(t, err.Error(), "absent.txt")
+			assert.True(t, errors.Is(s.Remove(context.Background(), "absent.txt"), storage.ErrNotFound))
+
+			_, err = s.Put(context.Background(), "../escape.txt", strings.NewReader("x"))
+			assert.True(t, errors.Is(err, storage.ErrInvalidName))
+			assert.Equal(t, http.StatusBadRequest, StatusCode(err))
+		},
+	)
+
+	t.Run(
+		"Responses are unchanged", func(t *testing.T) {
+			hdl := setupTestHandler(testDir)
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("dup.txt", "one", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("dup.txt", "two", nil))
+			assert.Equal(t, http.StatusConflict, rec.Code)
+			assert.Contains(t, rec.Body.String(), ErrAlreadyExists.Error())
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/files/absent.txt", nil))
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		},
+	)
+}
@@ -0,0 +1,145 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/JMURv/media-server/internal/events"
+	"github.com/JMURv/media-server/internal/metrics"
+	"github.com/JMURv/media-server/internal/webhook"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errBrokerDown = errors.New("broker down")
+
+type fakePublisher struct {
+	down atomic.Bool
+
+	mu   sync.Mutex
+	msgs []*events.Event
+}
+
+func (p *fakePublisher) Publish(_ context.Context, key string, msg []byte) error {
+	if p.down.Load() {
+		return errBrokerDown
+	}
+
+	ev := &events.Event{}
+	if err := json.Unmarshal(msg, ev); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.msgs = append(p.msgs, ev)
+	return nil
+}
+
+func (p *fakePublisher) Health() error {
+	if p.down.Load() {
+		return errBrokerDown
+	}
+	return nil
+}
+
+func (p *fakePublisher) Close() error {
+	return nil
+}
+
+func (p *fakePublisher) received() []*events.Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*events.Event(nil), p.msgs...)
+}
+
+func TestEvents(t *testing.T) {
+	testDir := t.TempDir()
+	hdl := setupTestHandler(testDir)
+
+	pub := &fakePublisher{}
+	pub.down.Store(true)
+
+	outbox := filepath.Join(testDir, ".events")
+	bus, err := events.NewWithPublisher(pub, 10*time.Millisecond, outbox, metrics.NewRegistry())
+	assert.Nil(t, err)
+	hdl.events = bus
+
+	t.Run(
+		"Readyz warns while broker is down", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			res := &Readiness{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+			assert.Equal(t, []string{"event bus: " + errBrokerDown.Error()}, res.Warnings)
+		},
+	)
+
+	t.Run(
+		"Events survive outage and restart", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("bus.txt", "data", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			req := httptest.NewRequest(http.MethodDelete, "/delete?filename=bus.txt", nil)
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			go bus.Run(ctx)
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+			assert.Empty(t, pub.received())
+			assert.Equal(t, 2, bus.Pending())
+
+			restarted, err := events.NewWithPublisher(pub, 10*time.Millisecond, outbox, metrics.NewRegistry())
+			assert.Nil(t, err)
+			assert.Equal(t, 2, restarted.Pending())
+			hdl.events = restarted
+
+			pub.down.Store(false)
+			ctx, cancel = context.WithCancel(context.Background())
+			defer cancel()
+			go restarted.Run(ctx)
+
+			assert.Eventually(t, func() bool { return restarted.Pending() == 0 }, time.Second, 10*time.Millisecond)
+			msgs := pub.received()
+			assert.Len(t, msgs, 2)
+			assert.Equal(t, webhook.FileCreated, msgs[0].Type)
+			assert.Equal(t, webhook.FileDeleted, msgs[1].Type)
+			assert.Equal(t, "bus.txt", msgs[0].Name)
+			assert.NotEmpty(t, msgs[0].ID)
+			assert.NotEqual(t, msgs[0].ID, msgs[1].ID)
+		},
+	)
+
+	t.Run(
+		"Admin events are not published", func(t *testing.T) {
+			hdl.emit("admin.export", "", nil, nil)
+			assert.Equal(t, 0, hdl.events.Pending())
+		},
+	)
+
+	t.Run(
+		"Readyz fails without storage", func(t *testing.T) {
+			missing := filepath.Join(testDir, "missing")
+			assert.Nil(t, os.MkdirAll(missing, os.ModePerm))
+			h := New(port, missing, hdl.config)
+			assert.Nil(t, os.RemoveAll(missing))
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		},
+	)
+}
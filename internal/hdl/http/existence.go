@@ -0,0 +1,250 @@
+package http
+
+import (
+	"container/list"
+	"context"
+	"github.com/JMURv/media-server/internal/metrics"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/fsnotify/fsnotify"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultExistenceCacheSize = 10000
+	defaultExistenceTTL       = 30 * time.Second
+	defaultNegativeTTL        = time.Second
+)
+
+type existenceEntry struct {
+	name    string
+	info    os.FileInfo
+	expires time.Time
+}
+
+type existenceCache struct {
+	mu          sync.Mutex
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	gen         uint64
+	order       *list.List
+	items       map[string]*list.Element
+
+	hits   *metrics.Counter
+	misses *metrics.Counter
+}
+
+func newExistenceCache(cfg *config.ExistenceCacheConfig, reg *metrics.Registry) *existenceCache {
+	if cfg == nil {
+		return nil
+	}
+
+	c := &existenceCache{
+		size:        cfg.Size,
+		ttl:         cfg.TTL,
+		negativeTTL: cfg.NegativeTTL,
+		order:       list.New(),
+		items:       make(map[string]*list.Element),
+		hits:        reg.Counter("existence_cache_hits_total", "File lookups answered by the existence cache."),
+		misses:      reg.Counter("existence_cache_misses_total", "File lookups that had to touch the disk."),
+	}
+	if c.size <= 0 {
+		c.size = defaultExistenceCacheSize
+	}
+	if c.ttl <= 0 {
+		c.ttl = defaultExistenceTTL
+	}
+	if c.negativeTTL <= 0 {
+		c.negativeTTL = defaultNegativeTTL
+	}
+	return c
+}
+
+// lookup returns the cached entry for name, or the generation to pass to
+// store once the caller has checked the disk itself.
+func (c *existenceCache) lookup(name string) (*existenceEntry, uint64) {
+	if c == nil {
+		return nil, 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[name]; ok {
+		e := el.Value.(*existenceEntry)
+		if time.Now().Before(e.expires) {
+			c.order.MoveToFront(el)
+			c.hits.Inc()
+			return e, c.gen
+		}
+		c.order.Remove(el)
+		delete(c.items, name)
+	}
+	c.misses.Inc()
+	return nil, c.gen
+}
+
+// store records what the caller found on disk, unless something was
+// invalidated since the lookup that produced gen.
+func (c *existenceCache) store(name string, gen uint64, info os.FileInfo) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+
+	ttl := c.ttl
+	if info == nil {
+		ttl = c.negativeTTL
+	}
+	e := &existenceEntry{name: name, info: info, expires: time.Now().Add(ttl)}
+	if el, ok := c.items[name]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[name] = c.order.PushFront(e)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*existenceEntry).name)
+	}
+}
+
+// invalidate drops name, or every entry when name is empty.
+func (c *existenceCache) invalidate(name string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if name == "" {
+		c.order.Init()
+		clear(c.items)
+		return
+	}
+	if el, ok := c.items[name]; ok {
+		c.order.Remove(el)
+		delete(c.items, name)
+	}
+}
+
+func (c *existenceCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (h *Handler) statFile(name string) (os.FileInfo, error) {
+	path, err := h.checkPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	e, gen := h.exists.lookup(name)
+	if e != nil {
+		if e.info == nil {
+			return nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+		}
+		return e.info, nil
+	}
+
+	info, err := h.statStored(path)
+	if err == nil {
+		h.exists.store(name, gen, info)
+	} else if os.IsNotExist(err) {
+		h.exists.store(name, gen, nil)
+	}
+	return info, err
+}
+
+func (h *Handler) newExistenceWatcher() (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err = watchDirs(watcher, h.rootDirs()); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	return watcher, nil
+}
+
+// watchDirs adds every directory under roots to watcher, dot directories
+// aside.
+func watchDirs(watcher *fsnotify.Watcher, roots []string) error {
+	for _, root := range roots {
+		err := filepath.WalkDir(
+			root, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if !d.IsDir() {
+					return nil
+				}
+				if p != root && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return watcher.Add(p)
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Handler) watchExistence(ctx context.Context) {
+	defer h.watcher.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-h.watcher.Events:
+			if !ok {
+				return
+			}
+
+			_, rel, ok := h.rootOf(ev.Name)
+			if !ok || strings.HasPrefix(filepath.Base(rel), ".") {
+				continue
+			}
+			if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+				h.invalidateFile("")
+			} else {
+				h.invalidateFile(filepath.ToSlash(rel))
+			}
+
+			if ev.Has(fsnotify.Create) {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					if err = h.watcher.Add(ev.Name); err != nil {
+						log.Printf("Error watching %s: %s\n", ev.Name, err)
+					}
+				}
+			}
+		case err, ok := <-h.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Existence watcher error: %s\n", err)
+			h.invalidateFile("")
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package http
+
+import (
+	"context"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExistenceCache(t *testing.T) {
+	testDir := t.TempDir()
+
+	newHandler := func(cfg *config.ExistenceCacheConfig) *Handler {
+		return New(
+			port, testDir, &config.HTTPConfig{
+				MaxUploadSize:   1024 * 1024,
+				MaxStreamBuffer: 1024,
+				ExistenceCache:  cfg,
+			},
+		)
+	}
+	stream := func(h *Handler, name string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream/"+name, nil))
+		return rec.Code
+	}
+
+	t.Run(
+		"Disabled by default", func(t *testing.T) {
+			hdl := setupTestHandler(testDir)
+			assert.Nil(t, hdl.exists)
+			assert.Equal(t, http.StatusNotFound, stream(hdl, "missing.mp4"))
+		},
+	)
+
+	t.Run(
+		"Negative entries expire", func(t *testing.T) {
+			hdl := newHandler(&config.ExistenceCacheConfig{NegativeTTL: 100 * time.Millisecond})
+
+			assert.Equal(t, http.StatusNotFound, stream(hdl, "late.mp4"))
+			assert.Equal(t, http.StatusNotFound, stream(hdl, "late.mp4"))
+			assert.Equal(t, uint64(1), hdl.exists.misses.Value())
+			assert.Equal(t, uint64(1), hdl.exists.hits.Value())
+
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, "late.mp4"), []byte("video"), 0644))
+			assert.Equal(t, http.StatusNotFound, stream(hdl, "late.mp4"))
+			assert.Eventually(
+				t, func() bool { return stream(hdl, "late.mp4") == http.StatusOK }, time.Second, 10*time.Millisecond,
+			)
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			assert.Contains(t, rec.Body.String(), "media_server_existence_cache_hits_total")
+			assert.Contains(t, rec.Body.String(), "media_server_existence_cache_misses_total")
+		},
+	)
+
+	t.Run(
+		"Upload then stream never sees a stale entry", func(t *testing.T) {
+			hdl := newHandler(&config.ExistenceCacheConfig{NegativeTTL: time.Hour})
+
+			assert.Equal(t, http.StatusNotFound, stream(hdl, "fresh.mp4"))
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("fresh.mp4", "video", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, http.StatusOK, stream(hdl, "fresh.mp4"))
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/files/fresh.mp4", nil))
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+			assert.Equal(t, http.StatusNotFound, stream(hdl, "fresh.mp4"))
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/files/fresh.mp4/meta", nil))
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Lookups racing an invalidation are not stored", func(t *testing.T) {
+			hdl := newHandler(&config.ExistenceCacheConfig{})
+
+			_, gen := hdl.exists.lookup("racy.mp4")
+			hdl.exists.invalidate("racy.mp4")
+			hdl.exists.store("racy.mp4", gen, nil)
+
+			e, _ := hdl.exists.lookup("racy.mp4")
+			assert.Nil(t, e)
+		},
+	)
+
+	t.Run(
+		"Bounded size", func(t *testing.T) {
+			hdl := newHandler(&config.ExistenceCacheConfig{Size: 2})
+			for _, name := range []string{"a.mp4", "b.mp4", "c.mp4"} {
+				_, gen := hdl.exists.lookup(name)
+				hdl.exists.store(name, gen, nil)
+			}
+			assert.Equal(t, 2, hdl.exists.Len())
+
+			e, _ := hdl.exists.lookup("a.mp4")
+			assert.Nil(t, e)
+		},
+	)
+
+	t.Run(
+		"Watcher invalidates outside changes", func(t *testing.T) {
+			hdl := newHandler(&config.ExistenceCacheConfig{NegativeTTL: time.Hour, Watch: true})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go hdl.watchExistence(ctx)
+
+			assert.Equal(t, http.StatusNotFound, stream(hdl, "copied.mp4"))
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, "copied.mp4"), []byte("video"), 0644))
+			assert.Eventually(
+				t, func() bool { return stream(hdl, "copied.mp4") == http.StatusOK }, time.Second, 10*time.Millisecond,
+			)
+		},
+	)
+}
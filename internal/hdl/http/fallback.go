@@ -0,0 +1,114 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const fallbackHeader = "X-Fallback"
+
+// fallback is a rule of the fallbacks config with its names cleaned.
+type fallback struct {
+	prefix string
+	file   string
+	status int
+}
+
+// covers reports whether name lies under the prefix of f, which like a
+// policy prefix names a directory. An empty prefix covers every name.
+func (f *fallback) covers(name string) bool {
+	return f.prefix == "" || name == f.prefix || strings.HasPrefix(name, f.prefix+"/")
+}
+
+func (h *Handler) initFallbacks() error {
+	if h.config.Fallbacks == nil {
+		return nil
+	}
+
+	for i, rule := range h.config.Fallbacks.Rules {
+		f := fallback{status: http.StatusOK}
+		if rule.NotFound {
+			f.status = http.StatusNotFound
+		}
+		if prefix := strings.Trim(rule.Prefix, "/"); prefix != "" {
+			var err error
+			if f.prefix, err = h.cleanPath(prefix); err != nil {
+				return fmt.Errorf("rule %d: invalid prefix %q: %w", i, rule.Prefix, err)
+			}
+		}
+
+		file, err := h.cleanPath(rule.File)
+		if err != nil {
+			return fmt.Errorf("rule %d: invalid file %q: %w", i, rule.File, err)
+		}
+		info, err := h.statFile(file)
+		if err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("rule %d: %q is not a regular file", i, rule.File)
+		}
+		f.file = file
+		h.fallbacks = append(h.fallbacks, f)
+	}
+	return nil
+}
+
+// serveFallback answers a request for name with the fallback covering it
+// when name does not exist. It reports whether it did; when it did not, it
+// has written nothing.
+func (h *Handler) serveFallback(w http.ResponseWriter, r *http.Request, name string) bool {
+	i := slices.IndexFunc(h.fallbacks, func(f fallback) bool { return f.covers(name) })
+	if i < 0 {
+		return false
+	}
+	if _, err := h.statFile(name); !errors.Is(err, fs.ErrNotExist) {
+		return false
+	}
+	f := h.fallbacks[i]
+
+	path, err := h.checkPath(f.file)
+	if err != nil {
+		return false
+	}
+	file, err := h.openStored(r.Context(), path)
+	if err != nil {
+		log.Printf("Error opening fallback %s for %s: %s\n", f.file, name, err)
+		return false
+	}
+	defer file.Close()
+	contentType, err := h.contentType(f.file, file)
+	if err != nil {
+		log.Printf("Error detecting type of fallback %s: %s\n", f.file, err)
+		return false
+	}
+
+	// The fallback stands in for a file that may be uploaded at any time,
+	// so caches have to ask again rather than keep it under name.
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set(fallbackHeader, "true")
+	if f.status == http.StatusOK {
+		http.ServeContent(w, r, f.file, time.Time{}, file)
+		return true
+	}
+
+	if length := bodyLength(file); length != unknownBodyLength {
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+	w.WriteHeader(f.status)
+	if r.Method != http.MethodHead {
+		if _, err = io.Copy(w, file); err != nil {
+			log.Printf("Error writing fallback %s: %s\n", f.file, err)
+		}
+	}
+	return true
+}
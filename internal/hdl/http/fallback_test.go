@@ -0,0 +1,133 @@
+package http
+
+import (
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFallbacks(t *testing.T) {
+	testDir := t.TempDir()
+
+	avatar := "\x89PNG\r\n\x1a\ndefault avatar"
+	seed := func(name, content string) {
+		p := filepath.Join(testDir, filepath.FromSlash(name))
+		assert.Nil(t, os.MkdirAll(filepath.Dir(p), os.ModePerm))
+		assert.Nil(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	seed("defaults/avatar.png", avatar)
+	seed("defaults/cover.png", "\x89PNG\r\n\x1a\ndefault cover")
+	seed("avatars/alice.png", "\x89PNG\r\n\x1a\nalice")
+
+	newConfig := func(rules ...*config.FallbackRule) *config.HTTPConfig {
+		return &config.HTTPConfig{
+			MaxUploadSize:   1 << 20,
+			MaxStreamBuffer: 1024,
+			DefaultPage:     1,
+			DefaultSize:     10,
+			Fallbacks:       &config.FallbacksConfig{Rules: rules},
+		}
+	}
+	hdl := New(
+		port, testDir, newConfig(
+			&config.FallbackRule{Prefix: "avatars/", File: "defaults/avatar.png"},
+			&config.FallbackRule{Prefix: "covers", File: "defaults/cover.png", NotFound: true},
+		),
+	)
+	do := func(method, target string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run(
+		"Missing files get the fallback", func(t *testing.T) {
+			for _, target := range []string{"/stream/avatars/bob.png", "/uploads/avatars/bob.png", "/stream/avatars/team/carol.png"} {
+				rec := do(http.MethodGet, target, nil)
+				assert.Equal(t, http.StatusOK, rec.Code, target)
+				assert.Equal(t, "true", rec.Header().Get("X-Fallback"), target)
+				assert.Equal(t, "image/png", rec.Header().Get("Content-Type"), target)
+				assert.Equal(t, avatar, rec.Body.String(), target)
+			}
+
+			rec := do(http.MethodGet, "/stream/avatars/bob.png", http.Header{"Range": {"bytes=0-7"}})
+			assert.Equal(t, http.StatusPartialContent, rec.Code)
+			assert.Equal(t, avatar[:8], rec.Body.String())
+		},
+	)
+
+	t.Run(
+		"Stored files are served as they are", func(t *testing.T) {
+			for _, target := range []string{"/stream/avatars/alice.png", "/uploads/avatars/alice.png"} {
+				rec := do(http.MethodGet, target, nil)
+				assert.Equal(t, http.StatusOK, rec.Code, target)
+				assert.Empty(t, rec.Header().Get("X-Fallback"), target)
+				assert.Equal(t, "\x89PNG\r\n\x1a\nalice", rec.Body.String(), target)
+			}
+		},
+	)
+
+	t.Run(
+		"Fallback with 404", func(t *testing.T) {
+			rec := do(http.MethodGet, "/stream/covers/album.png", nil)
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+			assert.Equal(t, "true", rec.Header().Get("X-Fallback"))
+			assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+			assert.Equal(t, "\x89PNG\r\n\x1a\ndefault cover", rec.Body.String())
+
+			rec = do(http.MethodHead, "/stream/covers/album.png", nil)
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+			assert.Empty(t, rec.Body.String())
+		},
+	)
+
+	t.Run(
+		"Only downloads under a prefix fall back", func(t *testing.T) {
+			for _, rec := range []*httptest.ResponseRecorder{
+				do(http.MethodGet, "/stream/avatarsfoo/bob.png", nil),
+				do(http.MethodGet, "/stream/bob.png", nil),
+				do(http.MethodDelete, "/files/avatars/bob.png", nil),
+			} {
+				assert.Equal(t, http.StatusNotFound, rec.Code)
+				assert.Empty(t, rec.Header().Get("X-Fallback"))
+			}
+
+			// Without a prefix every missing file falls back, but only
+			// when downloaded.
+			hdl := New(port, testDir, newConfig(&config.FallbackRule{File: "defaults/avatar.png"}))
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream/bob.png", nil))
+			assert.Equal(t, "true", rec.Header().Get("X-Fallback"))
+			for _, req := range []*http.Request{
+				httptest.NewRequest(http.MethodGet, "/files/bob.png/meta", nil),
+				httptest.NewRequest(http.MethodDelete, "/files/bob.png", nil),
+			} {
+				rec = httptest.NewRecorder()
+				hdl.ServeHTTP(rec, req)
+				assert.Equal(t, http.StatusNotFound, rec.Code)
+				assert.Empty(t, rec.Header().Get("X-Fallback"))
+			}
+		},
+	)
+
+	t.Run(
+		"Fallback files must exist at startup", func(t *testing.T) {
+			for _, rule := range []*config.FallbackRule{
+				{Prefix: "avatars", File: "defaults/missing.png"},
+				{Prefix: "avatars", File: "defaults"},
+				{Prefix: "avatars", File: "../avatar.png"},
+				{Prefix: "../avatars", File: "defaults/avatar.png"},
+			} {
+				assert.Panics(t, func() { New(port, testDir, newConfig(rule)) }, rule.File)
+			}
+		},
+	)
+}
@@ -0,0 +1,170 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type formPart struct {
+	field    string
+	filename string
+	content  string
+}
+
+func newFormRequest(parts ...formPart) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, p := range parts {
+		if p.filename == "" {
+			writer.WriteField(p.field, p.content)
+			continue
+		}
+		file, _ := writer.CreateFormFile(p.field, p.filename)
+		file.Write([]byte(p.content))
+	}
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestUploadFields(t *testing.T) {
+	testDir := t.TempDir()
+	hdl := setupTestHandler(testDir)
+
+	upload := func(parts ...formPart) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, newFormRequest(parts...))
+		return rec
+	}
+	stored := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(testDir, name))
+		assert.Nil(t, err)
+		return string(data)
+	}
+
+	t.Run(
+		"CKEditor", func(t *testing.T) {
+			rec := upload(
+				formPart{field: "ckCsrfToken", content: "token"},
+				formPart{field: "upload", filename: "editor.png", content: "ckeditor image"},
+			)
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, "editor.png", decodeUpload(t, rec).Name)
+			assert.Equal(t, "ckeditor image", stored("editor.png"))
+		},
+	)
+
+	t.Run(
+		"Uppy", func(t *testing.T) {
+			rec := upload(
+				formPart{field: "relativePath", content: "null"},
+				formPart{field: "name", content: "uppy.jpg"},
+				formPart{field: "type", content: "image/jpeg"},
+				formPart{field: "files[]", filename: "uppy.jpg", content: "uppy image"},
+			)
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, "uppy.jpg", decodeUpload(t, rec).Name)
+			assert.Equal(t, "uppy image", stored("uppy.jpg"))
+		},
+	)
+
+	t.Run(
+		"Earlier listed fields win", func(t *testing.T) {
+			rec := upload(
+				formPart{field: "files[0]", filename: "first.txt", content: "from files[0]"},
+				formPart{field: "file", filename: "second.txt", content: "from file"},
+				formPart{field: "upload", filename: "third.txt", content: "from upload"},
+			)
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, "second.txt", decodeUpload(t, rec).Name)
+			assert.NoFileExists(t, filepath.Join(testDir, "first.txt"))
+			assert.NoFileExists(t, filepath.Join(testDir, "third.txt"))
+		},
+	)
+
+	t.Run(
+		"Filename field overrides the part's filename", func(t *testing.T) {
+			for _, parts := range [][]formPart{
+				{
+					{field: filenameField, content: "Quarterly Report.txt"},
+					{field: "file", filename: "blob", content: "report"},
+				},
+				{
+					{field: "file", filename: "blob", content: "report"},
+					{field: filenameField, content: "Quarterly Report.txt"},
+				},
+			} {
+				os.Remove(filepath.Join(testDir, "Quarterly Report.txt"))
+				rec := upload(parts...)
+				assert.Equal(t, http.StatusCreated, rec.Code)
+
+				res := decodeUpload(t, rec)
+				assert.Equal(t, "Quarterly Report.txt", res.Name)
+				assert.Equal(t, "report", stored(res.Name))
+			}
+
+			rec := upload(
+				formPart{field: filenameField, content: "../escape.txt"},
+				formPart{field: "file", filename: "blob", content: "report"},
+			)
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			cleaned, err := hdl.cleanName("../escape.txt")
+			assert.Nil(t, err)
+			assert.Equal(t, cleaned, decodeUpload(t, rec).Name)
+			assert.NoFileExists(t, filepath.Join(filepath.Dir(testDir), "escape.txt"))
+		},
+	)
+
+	t.Run(
+		"No file part names the fields found", func(t *testing.T) {
+			rec := upload(
+				formPart{field: "ckCsrfToken", content: "token"},
+				formPart{field: "attachment", filename: "a.txt", content: "a"},
+			)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+			res := utils.ErrorResponse{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.Equal(t, "no_file_part", res.Code)
+			assert.Contains(t, res.Error, "expected one of file, upload, files[], files[0], files")
+			assert.Contains(t, res.Error, "found ckCsrfToken, attachment")
+			assert.Equal(t, "ckCsrfToken, attachment", res.Params["found"])
+
+			rec = upload()
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Contains(t, rec.Body.String(), "found none")
+		},
+	)
+
+	t.Run(
+		"Configured fields", func(t *testing.T) {
+			custom := New(
+				port, testDir, &config.HTTPConfig{
+					MaxUploadSize: 1024,
+					UploadFields:  []string{"attachment"},
+				},
+			)
+
+			rec := httptest.NewRecorder()
+			custom.ServeHTTP(rec, newFormRequest(formPart{field: "attachment", filename: "custom.txt", content: "custom"}))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, "custom", stored("custom.txt"))
+
+			rec = httptest.NewRecorder()
+			custom.ServeHTTP(rec, newFormRequest(formPart{field: "file", filename: "ignored.txt", content: "x"}))
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Contains(t, rec.Body.String(), "expected one of attachment, found file")
+		},
+	)
+}
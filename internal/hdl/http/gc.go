@@ -0,0 +1,297 @@
+package http
+
+import (
+	"context"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/storage"
+	"github.com/JMURv/media-server/internal/webhook"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	ArtifactPreview  = "preview"
+	ArtifactWaveform = "waveform"
+
+	GCSourceDeleted = "source_deleted"
+	GCSourceChanged = "source_changed"
+
+	// gcTempGrace is how old a leftover temporary file in a cache directory
+	// must be before it is collected, so writes in flight are left alone.
+	gcTempGrace = time.Hour
+)
+
+// cacheDirs hold derived artifacts, by kind. They are only ever served
+// through the endpoints that derive them.
+var cacheDirs = map[string]string{
+	previewsDir:  ArtifactPreview,
+	waveformsDir: ArtifactWaveform,
+}
+
+type GCEntry struct {
+	Path   string `json:"path"`
+	Kind   string `json:"kind"`
+	Source string `json:"source"`
+	Reason string `json:"reason"`
+	Size   int64  `json:"size"`
+}
+
+type GCReport struct {
+	DryRun         bool      `json:"dry_run"`
+	Scanned        int       `json:"scanned"`
+	Removed        []GCEntry `json:"removed"`
+	ReclaimedBytes int64     `json:"reclaimed_bytes"`
+}
+
+// isCachePath reports whether name lies within a cache directory.
+func isCachePath(name string) bool {
+	first, _, _ := strings.Cut(strings.TrimLeft(filepath.ToSlash(name), "/"), "/")
+	_, ok := cacheDirs[first]
+	return ok
+}
+
+func (h *Handler) artifactMu(kind string) *fileMutex {
+	if kind == ArtifactPreview {
+		return h.previewMu
+	}
+	return h.waveformMu
+}
+
+// trackArtifact records the artifact of kind just written to p as derived
+// from the current content of source.
+func (h *Handler) trackArtifact(kind, source, p string) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return
+	}
+	rel, err := filepath.Rel(h.savePath, p)
+	if err != nil {
+		return
+	}
+
+	art := meta.Artifact{
+		Path:      filepath.ToSlash(rel),
+		Kind:      kind,
+		Source:    source,
+		Size:      info.Size(),
+		CreatedAt: time.Now().UTC(),
+	}
+	if m, err := h.meta.Get(source); err == nil {
+		art.SourceID, art.Checksum = m.ID, m.Checksum
+	}
+	if err = h.artifacts.Add(art); err != nil {
+		log.Printf("Error tracking %s of %s: %s\n", kind, source, err)
+	}
+}
+
+// settleArtifacts drops what was derived from name once it is deleted, or
+// once its content no longer matches what the artifacts were derived from.
+func (h *Handler) settleArtifacts(typ, name string) {
+	var current *meta.File
+	switch typ {
+	case webhook.FileDeleted:
+	case webhook.FileCreated, webhook.FileUpdated:
+		m, err := h.meta.Get(name)
+		if err != nil {
+			return
+		}
+		current = m
+	default:
+		return
+	}
+
+	// Renditions of an earlier version are kept for serving stale.
+	if h.imageCache != nil && (current == nil || h.config.Images.MaxStale <= 0) {
+		h.imageCache.DropPrefix(name + "\x00")
+	}
+	for _, art := range h.artifacts.Of(name) {
+		if current != nil && art.SourceID == current.ID && art.Checksum == current.Checksum {
+			continue
+		}
+		reason := GCSourceDeleted
+		if current != nil {
+			reason = GCSourceChanged
+		}
+		h.removeArtifact(art, reason)
+	}
+}
+
+func (h *Handler) removeArtifact(art meta.Artifact, reason string) {
+	unlock := h.artifactMu(art.Kind).Lock(art.Source)
+	defer unlock()
+
+	// The artifact may have been derived again since it was listed.
+	if cur, ok := h.artifacts.Get(art.Path); !ok || !cur.CreatedAt.Equal(art.CreatedAt) {
+		return
+	}
+	if err := os.Remove(filepath.Join(h.savePath, filepath.FromSlash(art.Path))); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing %s of %s: %s\n", art.Kind, art.Source, err)
+		return
+	}
+	if err := h.artifacts.Remove(art.Path); err != nil {
+		log.Printf("Error untracking %s of %s: %s\n", art.Kind, art.Source, err)
+	}
+	log.Printf("Removed %s of %s: %s\n", art.Kind, art.Source, reason)
+}
+
+// orphaned reports why art no longer belongs to its source, or "".
+func (h *Handler) orphaned(art meta.Artifact) string {
+	m, err := h.meta.Get(art.Source)
+	if err != nil {
+		if _, err = h.statFile(art.Source); err != nil {
+			return GCSourceDeleted
+		}
+		if art.SourceID != "" {
+			return GCSourceChanged
+		}
+		return ""
+	}
+	if (art.SourceID != "" && m.ID != art.SourceID) || art.Checksum != m.Checksum {
+		return GCSourceChanged
+	}
+	return ""
+}
+
+// collectGarbage removes the tracked artifacts whose source is gone or has
+// changed, and the untracked files in cache directories whose source is
+// gone, such as those derived before artifacts were tracked.
+func (h *Handler) collectGarbage(ctx context.Context, dryRun bool) (*GCReport, error) {
+	res := &GCReport{DryRun: dryRun, Removed: []GCEntry{}}
+	collect := func(art meta.Artifact, reason string) {
+		res.Removed = append(
+			res.Removed, GCEntry{Path: art.Path, Kind: art.Kind, Source: art.Source, Reason: reason, Size: art.Size},
+		)
+		res.ReclaimedBytes += art.Size
+		if !dryRun {
+			h.removeArtifact(art, reason)
+		}
+	}
+
+	for _, art := range h.artifacts.All() {
+		if err := storage.Canceled(ctx); err != nil {
+			return nil, err
+		}
+		res.Scanned++
+
+		info, err := os.Stat(filepath.Join(h.savePath, filepath.FromSlash(art.Path)))
+		if err != nil {
+			if !dryRun {
+				h.artifacts.Remove(art.Path)
+			}
+			continue
+		}
+		if reason := h.orphaned(art); reason != "" {
+			art.Size = info.Size()
+			collect(art, reason)
+		}
+	}
+
+	now := time.Now()
+	for dir, kind := range cacheDirs {
+		err := filepath.WalkDir(
+			filepath.Join(h.savePath, dir), func(p string, d fs.DirEntry, err error) error {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				if err == nil {
+					err = storage.Canceled(ctx)
+				}
+				if err != nil || d.IsDir() {
+					return err
+				}
+
+				rel, _ := filepath.Rel(h.savePath, p)
+				rel = filepath.ToSlash(rel)
+				if _, ok := h.artifacts.Get(rel); ok {
+					return nil
+				}
+				info, err := d.Info()
+				if err != nil {
+					return nil
+				}
+				res.Scanned++
+
+				art := meta.Artifact{Path: rel, Kind: kind, Size: info.Size(), CreatedAt: info.ModTime()}
+				name := strings.TrimPrefix(rel, dir+"/")
+				if tmp, ok := strings.CutSuffix(name, ".tmp"); ok {
+					if now.Sub(info.ModTime()) < gcTempGrace {
+						return nil
+					}
+					name = tmp
+				}
+				art.Source = strings.TrimSuffix(name, path.Ext(name))
+				if _, err = h.meta.Get(art.Source); err == nil {
+					return nil
+				}
+				if _, err = h.statFile(art.Source); err == nil {
+					return nil
+				}
+
+				res.Removed = append(
+					res.Removed, GCEntry{Path: rel, Kind: kind, Source: art.Source, Reason: GCSourceDeleted, Size: art.Size},
+				)
+				res.ReclaimedBytes += art.Size
+				if !dryRun {
+					if err = os.Remove(p); err != nil && !os.IsNotExist(err) {
+						log.Printf("Error removing %s: %s\n", rel, err)
+					}
+				}
+				return nil
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	slices.SortFunc(res.Removed, func(a, b GCEntry) int { return strings.Compare(a.Path, b.Path) })
+	if !dryRun {
+		h.gcRemoved.Add(uint64(len(res.Removed)))
+		h.gcReclaimed.Add(uint64(res.ReclaimedBytes))
+	}
+	return res, nil
+}
+
+func (h *Handler) runGC(ctx context.Context) {
+	ticker := time.NewTicker(h.config.GC.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			res, err := h.collectGarbage(ctx, false)
+			if err != nil {
+				log.Printf("Error collecting derived artifacts: %s\n", err)
+				continue
+			}
+			if len(res.Removed) > 0 {
+				log.Printf("Collected %d derived artifacts, %d bytes\n", len(res.Removed), res.ReclaimedBytes)
+			}
+		}
+	}
+}
+
+func (h *Handler) gc(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+
+	res, err := h.collectGarbage(r.Context(), dryRun(r))
+	if err != nil {
+		log.Printf("Error collecting derived artifacts: %s\n", err)
+		writeError(w, canceledOr(err, ErrInternal))
+		return
+	}
+	utils.JSONResponse(w, http.StatusOK, res)
+}
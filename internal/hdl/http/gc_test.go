@@ -0,0 +1,198 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGC(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake converter is a shell script")
+	}
+
+	testDir := t.TempDir()
+
+	bin := t.TempDir()
+	converter := filepath.Join(bin, "convert")
+	pngFile := filepath.Join(bin, "preview.png")
+	buf := &bytes.Buffer{}
+	assert.Nil(t, png.Encode(buf, image.NewGray(image.Rect(0, 0, 32, 32))))
+	assert.Nil(t, os.WriteFile(pngFile, buf.Bytes(), 0644))
+	assert.Nil(t, os.WriteFile(converter, []byte(fakeConverter), 0755))
+	t.Setenv("COUNT_FILE", filepath.Join(bin, "count"))
+	t.Setenv("PNG_FILE", pngFile)
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize: 1024,
+			DefaultPage:   1,
+			DefaultSize:   10,
+			AdminToken:    "admin-secret",
+			Previews:      &config.PreviewsConfig{Command: []string{converter, "{input}", "{output}"}},
+		},
+	)
+
+	do := func(method, target string, admin bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if admin {
+			req.Header.Set("Authorization", "Bearer admin-secret")
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	upload := func(name, content string, fields map[string]string) {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, newUploadRequest(name, content, fields))
+		assert.Less(t, rec.Code, 300, rec.Body.String())
+	}
+	preview := func(name string) []meta.Artifact {
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/thumb/"+name, false).Code)
+		return hdl.artifacts.Of(name)
+	}
+	gc := func(query string) GCReport {
+		rec := do(http.MethodPost, "/admin/gc"+query, true)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		res := GCReport{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res
+	}
+	cached := func(name string) string {
+		return filepath.Join(testDir, previewsDir, name+".png")
+	}
+
+	t.Run(
+		"Artifacts are tracked against their source", func(t *testing.T) {
+			upload("doc.pdf", "%PDF-1.4 doc", nil)
+			m, err := hdl.meta.Get("doc.pdf")
+			assert.Nil(t, err)
+
+			arts := preview("doc.pdf")
+			if assert.Len(t, arts, 2) {
+				for _, art := range arts {
+					assert.Equal(t, ArtifactPreview, art.Kind)
+					assert.Equal(t, m.ID, art.SourceID)
+					assert.Equal(t, m.Checksum, art.Checksum)
+					assert.True(t, strings.HasPrefix(art.Path, previewsDir+"/doc.pdf."))
+				}
+			}
+			assert.FileExists(t, cached("doc.pdf"))
+		},
+	)
+
+	t.Run(
+		"Changed content drops them", func(t *testing.T) {
+			upload("doc.pdf", "%PDF-1.4 changed", map[string]string{"conflict": ConflictOverwrite})
+			assert.Empty(t, hdl.artifacts.Of("doc.pdf"))
+			assert.NoFileExists(t, cached("doc.pdf"))
+
+			assert.Len(t, preview("doc.pdf"), 2)
+		},
+	)
+
+	t.Run(
+		"Deletes drop them", func(t *testing.T) {
+			assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/files/doc.pdf", true).Code)
+			assert.Empty(t, hdl.artifacts.Of("doc.pdf"))
+			assert.NoFileExists(t, cached("doc.pdf"))
+		},
+	)
+
+	t.Run(
+		"Collection", func(t *testing.T) {
+			upload("kept.pdf", "%PDF-1.4 kept", nil)
+			upload("stale.pdf", "%PDF-1.4 stale", nil)
+			preview("kept.pdf")
+			preview("stale.pdf")
+
+			// Changed behind the server's back.
+			m, _ := hdl.meta.Get("stale.pdf")
+			m.Checksum = "changed"
+			assert.Nil(t, hdl.meta.Put(m))
+
+			// Left behind before artifacts were tracked.
+			orphan := filepath.Join(testDir, waveformsDir, "gone.mp3.json")
+			assert.Nil(t, os.MkdirAll(filepath.Dir(orphan), os.ModePerm))
+			assert.Nil(t, os.WriteFile(orphan, []byte(`{"etag":"x"}`), 0644))
+			untracked := filepath.Join(testDir, waveformsDir, "kept.pdf.json")
+			assert.Nil(t, os.WriteFile(untracked, []byte(`{"etag":"x"}`), 0644))
+			tmp := filepath.Join(testDir, waveformsDir, "gone.mp3.json.tmp")
+			assert.Nil(t, os.WriteFile(tmp, []byte("partial"), 0644))
+			old := time.Now().Add(-2 * gcTempGrace)
+			assert.Nil(t, os.Chtimes(tmp, old, old))
+			fresh := filepath.Join(testDir, waveformsDir, "writing.mp3.json.tmp")
+			assert.Nil(t, os.WriteFile(fresh, []byte("partial"), 0644))
+
+			res := gc("?dry_run=true")
+			assert.True(t, res.DryRun)
+			paths := make([]string, 0, len(res.Removed))
+			var reclaimed int64
+			for _, e := range res.Removed {
+				paths = append(paths, e.Path)
+				reclaimed += e.Size
+			}
+			assert.Equal(
+				t, []string{
+					previewsDir + "/stale.pdf.json",
+					previewsDir + "/stale.pdf.png",
+					waveformsDir + "/gone.mp3.json",
+					waveformsDir + "/gone.mp3.json.tmp",
+				}, paths,
+			)
+			assert.Equal(t, reclaimed, res.ReclaimedBytes)
+			assert.Positive(t, res.ReclaimedBytes)
+			assert.FileExists(t, orphan)
+			assert.FileExists(t, cached("stale.pdf"))
+
+			res = gc("")
+			assert.Len(t, res.Removed, 4)
+			assert.NoFileExists(t, orphan)
+			assert.NoFileExists(t, tmp)
+			assert.NoFileExists(t, cached("stale.pdf"))
+			assert.Empty(t, hdl.artifacts.Of("stale.pdf"))
+			assert.FileExists(t, fresh)
+			assert.FileExists(t, untracked)
+			assert.FileExists(t, cached("kept.pdf"))
+			assert.Len(t, hdl.artifacts.Of("kept.pdf"), 2)
+
+			assert.Empty(t, gc("").Removed)
+
+			rec := do(http.MethodGet, "/metrics", false)
+			assert.Contains(t, rec.Body.String(), "gc_removed_total 4")
+			assert.Contains(t, rec.Body.String(), "gc_reclaimed_bytes_total ")
+		},
+	)
+
+	t.Run(
+		"Requires admin", func(t *testing.T) {
+			assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/admin/gc", false).Code)
+		},
+	)
+
+	t.Run(
+		"Cache directories are never served directly", func(t *testing.T) {
+			for _, admin := range []bool{false, true} {
+				assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/stream/"+previewsDir+"/kept.pdf.png", admin).Code)
+				assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/uploads/"+previewsDir+"/kept.pdf.png", admin).Code)
+				assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/uploads/"+previewsDir+"/", admin).Code)
+			}
+			rec := do(http.MethodGet, "/list?include=hidden", true)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.NotContains(t, rec.Body.String(), "kept.pdf.png")
+			assert.NotContains(t, rec.Body.String(), previewsDir)
+		},
+	)
+}
@@ -2,99 +2,790 @@ package http
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"github.com/JMURv/media-server/internal/audit"
+	"github.com/JMURv/media-server/internal/cron"
+	"github.com/JMURv/media-server/internal/crypt"
+	"github.com/JMURv/media-server/internal/events"
+	"github.com/JMURv/media-server/internal/imaging"
+	"github.com/JMURv/media-server/internal/jobs"
+	"github.com/JMURv/media-server/internal/logs"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/metrics"
+	"github.com/JMURv/media-server/internal/migrate"
+	"github.com/JMURv/media-server/internal/outbound"
+	"github.com/JMURv/media-server/internal/replication"
+	"github.com/JMURv/media-server/internal/storage"
+	"github.com/JMURv/media-server/internal/traffic"
+	"github.com/JMURv/media-server/internal/trash"
+	"github.com/JMURv/media-server/internal/usage"
+	"github.com/JMURv/media-server/internal/webhook"
 	"github.com/JMURv/media-server/pkg/config"
 	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/fsnotify/fsnotify"
 	"io"
+	"io/fs"
 	"log"
+	"mime"
 	"net/http"
+	"net/netip"
+	"net/smtp"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+const defaultStreamBuffer = 32 << 10
+
+var errReadChunk = errors.New("error reading chunk")
+
 type Handler struct {
-	port     string
-	server   *http.Server
-	savePath string
-	config   *config.HTTPConfig
+	port       string
+	server     *http.Server
+	savePath   string
+	urlPath    string
+	config     *config.HTTPConfig
+	meta       *meta.Store
+	fileMu     *fileMutex
+	waveformMu *fileMutex
+	previewMu  *fileMutex
+	progress   *progressTracker
+	audit      *audit.Logger
+	webhooks   *webhook.Notifier
+	outbound   *outbound.Factory
+	backends   map[string]storage.Backend
+	metrics    *metrics.Registry
+	mux        http.Handler
+	handler    http.Handler
+
+	middleware []func(http.Handler) http.Handler
+	hooks      hooks
+
+	trustedProxies []netip.Prefix
+	kinds          map[string]string
+	policy         *policy
+	basic          *basicAuth
+	fallbacks      []fallback
+
+	replicator *replication.Replicator
+	shadow     *shadowMirror
+	validator  *uploadValidator
+	events     *events.Bus
+	jobs       *jobs.Pool
+
+	presets      map[string]imaging.Transform
+	transcodeSem chan struct{}
+	imageCache   *imaging.Cache
+	imageStale   *metrics.Counter
+	flights      *flights
+	warmMu       sync.Mutex
+	deletes      deferredDeletes
+
+	roots       *rootSet
+	tempDir     string
+	crossDevice bool
+	uploadFS    uploadFS
+	tempRenames *metrics.Counter
+	tempCopies  *metrics.Counter
+	syncLatency *metrics.Histogram
+
+	walkTruncations  *metrics.Counter
+	dirCapRejections *metrics.Counter
+	gcRemoved        *metrics.Counter
+	gcReclaimed      *metrics.Counter
+
+	journal    *meta.Journal
+	tombstones *meta.Tombstones
+	wal        *meta.WAL
+	downloads  *meta.Downloads
+	artifacts  *meta.Artifacts
+	logs       *logs.Buffer
+	exists     *existenceCache
+	hot        *hotCache
+	watcher    *fsnotify.Watcher
+
+	usage          *usage.Index
+	usageOnce      sync.Once
+	usageSnapshots *usage.Snapshots
+	traffic        *traffic.Meter
+
+	trash       *trash.Store
+	trashPurged *metrics.Counter
+
+	idempotencyMu *fileMutex
+
+	crypt    *crypt.Keyring
+	receipts *receiptSigner
+
+	requests requestRegistry
+	shedder  *shedder
+	streams  *streamScheduler
+
+	cursorKey       []byte
+	caseInsensitive bool
+
+	configPath     string
+	configLoadedAt time.Time
+
+	debugMux    *http.ServeMux
+	debugServer *http.Server
+	benchMu     sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lifecycleRunMu  sync.Mutex
+	lifecycleMu     sync.RWMutex
+	lifecycleReport *LifecycleReport
+
+	consistencyMu     sync.Mutex
+	consistencyReport *ConsistencyReport
+
+	reportMu       sync.Mutex
+	reportSchedule *cron.Schedule
+	sendMail       func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
 }
 
 func New(port string, savePath string, config *config.HTTPConfig) *Handler {
-	return &Handler{
-		port:     port,
-		savePath: savePath,
-		config:   config,
+	root, err := prepareSavePath(savePath, config.CreateDirs)
+	if err != nil {
+		panic("invalid save path: " + err.Error())
+	}
+	if err = migrate.Run(root); err != nil {
+		panic("failed to migrate save path: " + err.Error())
+	}
+
+	reg := metrics.NewRegistry()
+	backends, err := storage.NewBackends(config.Backends, reg)
+	if err != nil {
+		panic("failed to init storage backends: " + err.Error())
 	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &Handler{
+		port:       port,
+		savePath:   root,
+		urlPath:    savePath,
+		config:     config,
+		meta:       meta.New(root),
+		fileMu:     newFileMutex(),
+		waveformMu: newFileMutex(),
+		previewMu:  newFileMutex(),
+		progress:   newProgressTracker(),
+		audit:      audit.New(config.AuditLog),
+		backends:   backends,
+		metrics:    reg,
+		ctx:        ctx,
+		cancel:     cancel,
+		uploadFS:   osUploadFS{},
+	}
+	h.cursorKey = listCursorKey(config.SigningSecret)
+	h.idempotencyMu = newFileMutex()
+	h.detectCase(ctx, storage.NewLocal(root))
+
+	h.tempDir, h.crossDevice, err = prepareTempDir(config.TempDir, root, config.StrictTempDir)
+	if err != nil {
+		panic("invalid temp dir: " + err.Error())
+	}
+	if config.Roots != nil {
+		if h.roots, err = newRootSet(root, config.Roots, config.CreateDirs); err != nil {
+			panic("invalid roots config: " + err.Error())
+		}
+	}
+	h.tempRenames = h.metrics.Counter("temp_renames_total", "Uploads moved into place with a rename.")
+	h.tempCopies = h.metrics.Counter("temp_copies_total", "Uploads copied into place across filesystems.")
+	h.walkTruncations = h.metrics.Counter("walk_truncations_total", "Directory walks stopped at the entry cap.")
+	h.dirCapRejections = h.metrics.Counter("dir_cap_rejections_total", "Uploads refused by the per-directory file cap.")
+	h.gcRemoved = h.metrics.Counter("gc_removed_total", "Derived artifacts removed by garbage collection.")
+	h.gcReclaimed = h.metrics.Counter("gc_reclaimed_bytes_total", "Bytes reclaimed by garbage collection.")
+	h.syncLatency = h.metrics.Histogram(
+		"upload_sync_seconds", "Time spent syncing durable uploads to disk.", metrics.DefaultBuckets,
+	)
+	h.flights = newFlights(ctx, config.CoalesceTimeout, h.metrics)
+
+	if config.Encryption != nil {
+		if h.crypt, err = crypt.New(config.Encryption); err != nil {
+			panic("invalid encryption config: " + err.Error())
+		}
+	}
+	if config.Receipts != nil {
+		if h.receipts, err = newReceiptSigner(config.Receipts); err != nil {
+			panic("invalid receipts config: " + err.Error())
+		}
+	}
+
+	h.outbound, err = outbound.New(config.Outbound)
+	if err != nil {
+		panic("invalid outbound config: " + err.Error())
+	}
+
+	h.webhooks, err = webhook.New(
+		config.Webhooks, filepath.Join(root, ".webhooks"), config.WebhookDLQSize,
+		h.outbound.Client(outbound.Webhooks, 0), h.metrics,
+	)
+	if err != nil {
+		panic("failed to load webhook dead letters: " + err.Error())
+	}
+
+	h.exists = newExistenceCache(config.ExistenceCache, h.metrics)
+	h.hot = newHotCache(config.HotCache, h.metrics)
+	if h.wal, err = meta.OpenWAL(root, config.DurableWrites); err != nil {
+		panic("failed to open catalog journal: " + err.Error())
+	}
+	h.recoverCatalog()
+	if h.downloads, err = meta.OpenDownloads(root); err != nil {
+		panic("failed to load download counts: " + err.Error())
+	}
+	if h.artifacts, err = meta.OpenArtifacts(root); err != nil {
+		panic("failed to load derived artifacts: " + err.Error())
+	}
+	if config.Logs != nil {
+		if h.logs, err = h.newLogBuffer(); err != nil {
+			panic("invalid logs config: " + err.Error())
+		}
+		logs.Install(h.logs)
+	}
+	if config.Changes != nil {
+		if h.journal, err = h.openJournal(); err != nil {
+			panic("failed to open change journal: " + err.Error())
+		}
+		if h.tombstones, err = meta.OpenTombstones(root); err != nil {
+			panic("failed to load tombstones: " + err.Error())
+		}
+	}
+	if config.Usage != nil {
+		h.usage = usage.NewIndex()
+		h.usageSnapshots = usage.OpenSnapshots(filepath.Join(h.savePath, usageDir))
+	}
+	if config.Traffic != nil {
+		h.traffic = traffic.Open(filepath.Join(h.savePath, trafficDir))
+	}
+	if config.Trash != nil {
+		if err = h.initTrash(); err != nil {
+			panic("failed to open trash: " + err.Error())
+		}
+	}
+	if config.ExistenceCache != nil && config.ExistenceCache.Watch {
+		if h.watcher, err = h.newExistenceWatcher(); err != nil {
+			panic("failed to watch save path: " + err.Error())
+		}
+	}
+
+	if err = validateIgnore(config.Ignore); err != nil {
+		panic("invalid ignore list: " + err.Error())
+	}
+
+	if h.trustedProxies, err = parseTrustedProxies(config.TrustedProxies); err != nil {
+		panic("invalid trusted proxies: " + err.Error())
+	}
+
+	if h.kinds, err = parseMediaKinds(config.MediaKinds); err != nil {
+		panic("invalid media kinds: " + err.Error())
+	}
+
+	var user func(string) bool
+	if config.BasicAuth != nil {
+		if h.basic, err = newBasicAuth(config.BasicAuth); err != nil {
+			panic("invalid basic auth config: " + err.Error())
+		}
+		user = h.basic.mayExist
+	}
+
+	if config.Policy != nil {
+		if h.policy, err = newPolicy(config.Policy, config.AdminToken, user, h.caseInsensitive); err != nil {
+			panic("invalid policy config: " + err.Error())
+		}
+	}
+
+	if err = h.validateLifecycle(); err != nil {
+		panic("invalid lifecycle config: " + err.Error())
+	}
+
+	if err = h.initFallbacks(); err != nil {
+		panic("invalid fallbacks config: " + err.Error())
+	}
+
+	if config.Report != nil {
+		if err = h.initReport(); err != nil {
+			panic("invalid report config: " + err.Error())
+		}
+	}
+
+	if config.Shadow != nil {
+		if h.shadow, err = h.newShadow(config.Shadow); err != nil {
+			panic("invalid shadow config: " + err.Error())
+		}
+	}
+
+	if config.Validation != nil {
+		if h.validator, err = h.newValidator(config.Validation); err != nil {
+			panic("invalid validation config: " + err.Error())
+		}
+	}
+
+	if config.Replication != nil {
+		h.replicator, err = replication.New(
+			config.Replication, filepath.Join(root, ".replication"), h.openReplica,
+			h.outbound.Client(outbound.Replication, config.Replication.Timeout), h.metrics,
+		)
+		if err != nil {
+			panic("failed to init replication: " + err.Error())
+		}
+	}
+
+	if config.Events != nil {
+		h.events, err = events.New(config.Events, filepath.Join(root, ".events"), h.metrics)
+		if err != nil {
+			panic("failed to init event bus: " + err.Error())
+		}
+	}
+
+	if config.Transcode != nil && config.Jobs == nil {
+		panic("failed to init jobs: " + ErrTranscodeNeedsJobs.Error())
+	}
+	if config.Adopt != nil && config.Jobs == nil {
+		panic("failed to init jobs: " + ErrAdoptNeedsJobs.Error())
+	}
+	if config.Jobs != nil {
+		if err = h.initJobs(); err != nil {
+			panic("failed to init jobs: " + err.Error())
+		}
+	}
+
+	if err = h.validatePreviews(); err != nil {
+		panic("invalid previews config: " + err.Error())
+	}
+	if err = h.validateHEIC(); err != nil {
+		panic("invalid heic config: " + err.Error())
+	}
+
+	if config.Images != nil {
+		if err = h.initImages(); err != nil {
+			panic("invalid images config: " + err.Error())
+		}
+	}
+
+	if config.Debug != nil {
+		if config.Debug.Addr == "" {
+			panic("invalid debug config: " + ErrDebugAddrRequired.Error())
+		}
+		h.debugMux = h.debugRoutes()
+	}
+
+	if config.Shedding != nil {
+		h.initShedding()
+	}
+	if bw := config.Bandwidth; bw != nil {
+		if bw.Budget <= 0 {
+			panic("invalid bandwidth config: " + ErrBandwidthBudgetRequired.Error())
+		}
+		h.streams = newStreamScheduler(bw.Budget, bw.SmallFileSize, bw.SmallFileWeight, h.metrics)
+	}
+
+	h.mux = h.routes()
+	h.handler = h.mux
+	return h
 }
 
-func (h *Handler) Start() {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/list", h.listFiles)
-	mux.HandleFunc("/upload", h.createFile)
-	mux.HandleFunc("/delete", h.deleteFile)
-	mux.HandleFunc("/stream/uploads/", h.stream)
-	mux.Handle("/uploads/", http.StripPrefix("/uploads", http.FileServer(http.Dir(h.savePath))))
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler.ServeHTTP(w, r)
+}
+
+func (h *Handler) emit(typ, name string, r *http.Request, data map[string]any) {
+	h.invalidateFile(name)
+	h.settleArtifacts(typ, name)
+	if typ == webhook.FileDeleted {
+		h.downloads.Forget(name)
+	}
+	h.recordChange(typ, name)
+	h.trackUsage(typ, name)
+	h.audit.Log(typ, name, actor(r), auditDetails(r, data))
+	h.webhooks.Notify(typ, name, data)
+	h.replicate(typ, name)
+	h.mirror(typ, name)
+	if h.events != nil && strings.HasPrefix(typ, "file.") {
+		h.events.Publish(typ, name, data)
+	}
+}
+
+func (h *Handler) routes() http.Handler {
+	mux := trackedMux{ServeMux: http.NewServeMux(), h: h}
+	mux.HandleFunc("GET /list", h.listFiles)
+	mux.HandleFunc("GET /browse/", h.browse)
+	mux.HandleFunc("POST /upload", h.createFile)
+	mux.HandleFunc("GET /upload/progress/{id}", h.uploadProgress)
+	mux.HandleFunc("GET /stream/{name...}", h.withCORS(h.stream))
+	mux.HandleFunc("OPTIONS /stream/{name...}", h.withCORS(h.preflight))
+	mux.HandleFunc("HEAD /files/{name}", h.uploadOffset)
+	mux.HandleFunc("PATCH /files/{name}", h.patchFile)
+	mux.HandleFunc("DELETE /files/{name...}", h.deleteFile)
+	mux.HandleFunc("POST /files/{name}/lock", h.lockFile)
+	mux.HandleFunc("DELETE /files/{name}/lock", h.unlockFile)
+	mux.HandleFunc("POST /files/{name}/hold", h.holdFile)
+	mux.HandleFunc("DELETE /files/{name}/hold", h.releaseHold)
+	mux.HandleFunc("POST /files/{name}/transcode", h.transcode)
+	mux.HandleFunc("POST /files/{name}/clip", h.clip)
+	mux.HandleFunc("GET /clips/{id}", h.clipReport)
+	mux.HandleFunc("GET /files/{name}/waveform", h.waveform)
+	mux.HandleFunc("GET /files/{name}/meta", h.fileMeta)
+	mux.HandleFunc("PATCH /files/{name}/meta", h.patchMeta)
+	mux.HandleFunc("GET /meta/{name...}", h.fileMeta)
+	mux.HandleFunc("PATCH /meta/{name...}", h.patchMeta)
+	mux.HandleFunc("GET /files/{name}/tracks", h.withCORS(h.tracks))
+	mux.HandleFunc("GET /files/{name}/checksum", h.checksum)
+	mux.HandleFunc("GET /files/{name}/versions", h.listVersions)
+	mux.HandleFunc("GET /id/{id}", h.fileByID)
+	mux.HandleFunc("DELETE /id/{id}", h.deleteByID)
+	mux.HandleFunc("POST /verify", h.verify)
+	mux.HandleFunc("POST /receipts/verify", h.verifyReceipt)
+	mux.HandleFunc("GET /admin/lifecycle/report", h.lifecycleReportHandler)
+	mux.HandleFunc("POST /admin/lifecycle/run", h.lifecycleRun)
+	mux.HandleFunc("POST /admin/report/run", h.reportRun)
+	mux.HandleFunc("GET /admin/export", h.exportArchive)
+	mux.HandleFunc("GET /export/manifest", h.exportManifest)
+	mux.HandleFunc("POST /admin/import", h.importArchive)
+	mux.HandleFunc("POST /admin/adopt", h.adopt)
+	mux.HandleFunc("GET /admin/adopt/{id}", h.adoptionReport)
+	mux.HandleFunc("POST /admin/replication/reconcile", h.reconcileReplica)
+	mux.HandleFunc("GET /admin/shadow", h.shadowReport)
+	mux.HandleFunc("GET /admin/consistency", h.consistency)
+	mux.HandleFunc("POST /admin/gc", h.gc)
+	mux.HandleFunc("GET /admin/duplicates", h.duplicates)
+	mux.HandleFunc("POST /admin/duplicates/dedupe", h.dedupe)
+	mux.HandleFunc("POST /admin/placeholders/backfill", h.backfillPlaceholders)
+	mux.HandleFunc("GET /admin/webhooks/dlq", h.listDeadLetters)
+	mux.HandleFunc("POST /admin/webhooks/dlq/retry", h.retryDeadLetters)
+	mux.HandleFunc("POST /admin/webhooks/dlq/{id}/retry", h.retryDeadLetter)
+	mux.HandleUntracked("GET /admin/requests", h.listRequests)
+	mux.HandleUntracked("DELETE /admin/requests/{id}", h.cancelRequest)
+	mux.HandleFunc("GET /admin/logs", h.recentLogs)
+	mux.HandleUntracked("GET /admin/logs/ws", h.tailLogs)
+	mux.HandleFunc("POST /downloads", h.createDownload)
+	mux.HandleFunc("GET /downloads/{id}", h.download)
+	mux.HandleFunc("POST /mpu", h.createMultipart)
+	mux.HandleFunc("PUT /mpu/{id}/parts/{n}", h.uploadPart)
+	mux.HandleFunc("POST /mpu/{id}/complete", h.completeMultipart)
+	mux.HandleFunc("DELETE /mpu/{id}", h.abortMultipart)
+	mux.HandleFunc("GET /jobs", h.listJobs)
+	mux.HandleFunc("GET /jobs/{id}", h.getJob)
+	mux.HandleFunc("GET /thumb/{name}", h.preview)
+	mux.HandleFunc("GET /img/{name}", h.image)
+	mux.HandleFunc("GET /img/{preset}/{name}", h.image)
+	mux.HandleFunc("GET /stats", h.stats)
+	mux.HandleFunc("GET /stats/usage", h.usageReport)
+	mux.HandleFunc("GET /stats/traffic", h.trafficReport)
+	mux.HandleFunc("GET /trash", h.listTrash)
+	mux.HandleFunc("GET /changes", h.changes)
+	mux.HandleFunc("GET /readyz", h.readyz)
+	mux.HandleFunc("GET /version", h.buildVersion)
+	mux.HandleFunc("GET /capabilities", h.capabilitiesHandler)
+	mux.HandleFunc("GET /.well-known/receipt-key", h.receiptKeys)
+	mux.HandleFunc("GET /admin/config", h.adminConfig)
+	mux.Handle("GET /metrics", h.metrics)
+	mux.Handle("GET /uploads/", http.StripPrefix("/uploads", h.withDisposition(http.FileServer(publicFS{rootsDir{h}, h}))))
 
+	// Deprecated aliases, kept for one release.
+	mux.HandleFunc("DELETE /delete", h.deleteFile)
+	mux.HandleFunc("GET /stream/uploads/{name...}", h.withCORS(h.stream))
+
+	return h.withBasePath(mux)
+}
+
+// RegisterRoutes mounts the handler on mux under the configured base path,
+// so the media endpoints can share a server, and the caller's middleware,
+// with other routes. Without a base path it claims "/". Embedders call
+// StartWorkers instead of Start. Use a mux of your own: http.DefaultServeMux
+// carries the debug endpoints that importing net/http/pprof registers.
+func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
+	mux.Handle(cleanBasePath(h.config.BasePath)+"/", h)
+}
+
+// Start runs the background workers and serves on the handler's port until
+// Shutdown.
+func (h *Handler) Start() {
 	h.server = &http.Server{
 		Addr:    h.port,
-		Handler: mux,
+		Handler: h,
 	}
+	h.StartWorkers()
 
+	if h.debugMux != nil {
+		h.debugServer = &http.Server{
+			Addr:    h.config.Debug.Addr,
+			Handler: h.debugMux,
+		}
+		go func() {
+			if err := h.debugServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Error starting debug server: %s\n", err)
+			}
+		}()
+		log.Printf("Debug server is running on %v\n", h.config.Debug.Addr)
+	}
+
+	h.logStartup()
 	log.Printf("Server is running on port %v\n", h.port)
 	if err := h.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Error starting server: %s\n", err)
 	}
 }
 
+// StartWorkers starts the enabled background jobs, such as lifecycle runs,
+// replication and the trash janitor, without binding a listener. They stop
+// on Shutdown.
+func (h *Handler) StartWorkers() {
+	if h.config.Lifecycle != nil {
+		go h.runLifecycle(h.ctx)
+	}
+	if h.replicator != nil {
+		go h.replicator.Run(h.ctx)
+	}
+	if h.shadow != nil {
+		go h.runShadow(h.ctx)
+	}
+	if h.events != nil {
+		go h.events.Run(h.ctx)
+	}
+	if h.jobs != nil {
+		go h.jobs.Run(h.ctx)
+	}
+	if h.watcher != nil {
+		go h.watchExistence(h.ctx)
+	}
+	if h.config.Multipart != nil {
+		go h.runMultipartGC(h.ctx)
+	}
+	if h.usage != nil {
+		go h.runUsageSnapshots(h.ctx)
+	}
+	if h.trash != nil {
+		go h.runTrashJanitor(h.ctx)
+	}
+	if cfg := h.config.Availability; cfg != nil && cfg.DeleteAfter > 0 {
+		go h.runAvailabilitySweeper(h.ctx)
+	}
+	if cfg := h.config.GC; cfg != nil && cfg.Interval > 0 {
+		go h.runGC(h.ctx)
+	}
+	if h.config.Idempotency != nil {
+		go h.runIdempotencyJanitor(h.ctx)
+	}
+	if h.tombstones != nil {
+		go h.runTombstoneJanitor(h.ctx)
+	}
+	if h.traffic != nil {
+		go h.runTrafficFlusher(h.ctx)
+	}
+	if h.reportSchedule != nil {
+		go h.runReports(h.ctx)
+	}
+}
+
 func (h *Handler) Shutdown(ctx context.Context) error {
-	if err := h.server.Shutdown(ctx); err != nil {
-		return err
+	h.cancel()
+	if h.debugServer != nil {
+		if err := h.debugServer.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down debug server: %s\n", err)
+		}
+	}
+	if h.server != nil {
+		if err := h.server.Shutdown(ctx); err != nil {
+			return err
+		}
 	}
-	return nil
+
+	h.webhooks.Wait()
+	if h.events != nil {
+		if err := h.events.Close(); err != nil {
+			log.Printf("Error closing event bus: %s\n", err)
+		}
+	}
+	if h.journal != nil {
+		if err := h.journal.Close(); err != nil {
+			log.Printf("Error closing change journal: %s\n", err)
+		}
+	}
+	if err := h.wal.Close(); err != nil {
+		log.Printf("Error closing catalog journal: %s\n", err)
+	}
+	if err := h.downloads.Save(); err != nil {
+		log.Printf("Error saving download counts: %s\n", err)
+	}
+	if h.traffic != nil {
+		if err := h.traffic.Flush(); err != nil {
+			log.Printf("Error flushing traffic counts: %s\n", err)
+		}
+	}
+	return h.audit.Close()
 }
 
 func (h *Handler) stream(w http.ResponseWriter, r *http.Request) {
-	name := r.URL.Path[len("/stream/uploads/"):]
-	path := filepath.Join(h.savePath, name)
+	name, err := h.pathName(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !h.checkInternal(w, r, name) || h.serveFallback(w, r, name) || !h.checkAvailable(w, r, name) || !h.checkReady(w, name) {
+		return
+	}
 
-	file, err := os.Open(path)
+	override, err := h.bufferOverride(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	source, originalType, err := h.streamSource(r, name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	file, err := h.openCached(r.Context(), source)
+	if symlinkError(err) {
+		errorResponse(w, http.StatusForbidden, err)
+		return
+	}
 	if err != nil {
-		utils.ErrResponse(w, http.StatusNotFound, ErrRetrievingFile)
+		writeError(w, ErrRetrievingFile)
 		return
 	}
 	defer file.Close()
 
-	switch filepath.Ext(name) {
-	case ".jpg", ".jpeg":
-		w.Header().Set("Content-Type", "image/jpeg")
-	case ".png":
-		w.Header().Set("Content-Type", "image/png")
-	case ".gif":
-		w.Header().Set("Content-Type", "image/gif")
-	case ".mp4":
-		w.Header().Set("Content-Type", "video/mp4")
-	case ".webm":
-		w.Header().Set("Content-Type", "video/webm")
-	default:
-		utils.ErrResponse(w, http.StatusUnsupportedMediaType, ErrUnsupportedMediaType)
-	}
-	w.Header().Set("Transfer-Encoding", "chunked")
+	contentType, err := originalType, nil
+	if contentType == "" {
+		contentType, err = h.contentType(name, file)
+	}
+	if errors.Is(err, ErrContentTypeMismatch) {
+		errorResponse(w, http.StatusUnsupportedMediaType, err)
+		return
+	}
+	if err != nil {
+		writeError(w, ErrInternal)
+		return
+	}
+	if !h.streamable(contentType) {
+		writeError(w, ErrUnsupportedMediaType)
+		return
+	}
+	switch format := r.URL.Query().Get("format"); {
+	case format == "vtt" && baseMediaType(contentType) == subripType:
+		h.streamVTT(w, r, name, file)
+		return
+	case format != "" && (format != "vtt" || baseMediaType(contentType) != vttType):
+		writeError(w, ErrInvalidFormat)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	h.setDisposition(w, name)
+	if !h.applyContentPolicy(w, source, file) {
+		return
+	}
+	h.countDownload(r, name)
+
+	etag, modTime := h.validators(source, file)
+	setValidators(w, etag, modTime)
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	var body io.Reader = file
+	length := bodyLength(file)
+	w, done := h.paceStream(r, w, length)
+	defer done()
+	if rng := r.Header.Get("Range"); rng != "" && ifRangeMatches(r, etag, modTime) {
+		size, err := file.Seek(0, io.SeekEnd)
+		if err != nil {
+			writeError(w, ErrInternal)
+			return
+		}
+
+		ranges, err := parseRange(rng, size)
+		if errors.Is(err, errNoOverlap) {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			writeError(w, ErrInvalidRange)
+			return
+		}
+		if err == nil && len(ranges) > h.maxRanges() {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			writeError(w, ErrTooManyRanges)
+			return
+		}
+		if err == nil {
+			ranges = coalesce(ranges)
+		}
+
+		if err == nil && len(ranges) > 1 {
+			if err = writeRanges(w, file, ranges, size); err != nil {
+				log.Println("Error writing ranges:", err)
+			}
+			return
+		}
+		if err == nil && len(ranges) == 1 {
+			if _, err = file.Seek(ranges[0].start, io.SeekStart); err != nil {
+				writeError(w, ErrInternal)
+				return
+			}
+
+			body, length = io.LimitReader(file, ranges[0].length), ranges[0].length
+			w.Header().Set("Content-Range", ranges[0].contentRange(size))
+			w.Header().Set("Content-Length", strconv.FormatInt(ranges[0].length, 10))
+			w.WriteHeader(http.StatusPartialContent)
+		} else if _, err = file.Seek(0, io.SeekStart); err != nil {
+			writeError(w, ErrInternal)
+			return
+		}
+	}
+	if r.Method == http.MethodHead {
+		if w.Header().Get("Content-Range") == "" && length != unknownBodyLength {
+			w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+		}
+		return
+	}
+	if w.Header().Get("Content-Range") == "" && length == 0 {
+		w.Header().Set("Content-Length", "0")
+	} else if w.Header().Get("Content-Range") == "" {
+		w.Header().Set("Transfer-Encoding", "chunked")
+	}
+
+	info := fileInfo(name, nil)
+	if m, err := h.meta.Get(name); err == nil {
+		info = fileInfo(name, m)
+	}
+	runFileHooks(r.Context(), h.hooks.streamStart, info)
+	defer runFileHooks(r.Context(), h.hooks.streamEnd, info)
 
 	log.Println("Streaming mediafile: ", name)
-	buffer := make([]byte, h.config.MaxStreamBuffer)
+	err = streamChunks(w, body, h.streamBuffer(length, override))
+	if errors.Is(err, errReadChunk) {
+		writeError(w, ErrInternal)
+		return
+	}
+	if err != nil {
+		log.Println("Error writing chunk:", err)
+	}
+}
+
+func streamChunks(w io.Writer, body io.Reader, size int) error {
+	buf := getBuffer(size)
+	defer putBuffer(buf)
+
+	buffer := *buf
 	for {
-		n, err := file.Read(buffer)
+		n, err := body.Read(buffer)
 		if err != nil && err != io.EOF {
-			utils.ErrResponse(w, http.StatusInternalServerError, ErrInternal)
-			return
+			return fmt.Errorf("%w: %w", errReadChunk, err)
 		}
 		if n == 0 {
-			break
+			return nil
 		}
 
 		if _, err := w.Write(buffer[:n]); err != nil {
-			log.Println("Error writing chunk:", err)
-			return
+			return err
 		}
 
 		if flusher, ok := w.(http.Flusher); ok {
@@ -103,16 +794,146 @@ func (h *Handler) stream(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (h *Handler) writeTemp(src io.Reader) (string, int64, string, error) {
+	tmp, err := h.uploadFS.CreateTemp(h.tempDir, ".upload-*")
+	if err != nil {
+		return "", 0, "", fmt.Errorf("%w: %w", errWritingTemp, err)
+	}
+
+	hash := sha256.New()
+	dst := &tempWriter{w: tmp}
+	size, err := io.Copy(io.MultiWriter(dst, hash), src)
+	if cerr := tmp.Close(); dst.err == nil {
+		dst.err = cerr
+	}
+	if dst.err != nil {
+		return tmp.Name(), 0, "", fmt.Errorf("%w: %w", errWritingTemp, dst.err)
+	}
+	if err != nil {
+		return tmp.Name(), 0, "", err
+	}
+	return tmp.Name(), size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 func (h *Handler) listFiles(w http.ResponseWriter, r *http.Request) {
 	page, size := utils.ParsePaginationParams(r, h.config.DefaultPage, h.config.DefaultSize)
-	files, err := os.ReadDir(h.savePath)
+	withHidden, err := h.showHidden(r)
 	if err != nil {
-		utils.ErrResponse(w, http.StatusInternalServerError, ErrReadingDir)
+		writeError(w, err)
 		return
 	}
+	state, err := stateFilter(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	available, err := availableFilter(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	kind, err := kindFilter(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	grouped, err := groupBy(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	now := time.Now()
+	cursor := r.URL.Query().Has("cursor")
+	var after string
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		if after, err = h.decodeListCursor(v, now); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	withKind := includes(r, groupKind) || kind != "" || grouped
+	var groups map[string]int
+	if grouped {
+		groups = make(map[string]int, len(mediaKinds))
+		for _, k := range mediaKinds {
+			groups[k] = 0
+		}
+	}
+
+	// Kinds come from the catalog and are counted before the kind filter
+	// applies, so every tab shows its count whichever one is selected.
+	var files []fs.DirEntry
+	kinds := make(map[string]string)
+	add := func(file fs.DirEntry) {
+		if file.IsDir() || !h.listable(file) || (!withHidden && h.hidden(file.Name())) {
+			return
+		}
+		if state != "" && h.fileState(file.Name()) != state {
+			return
+		}
+		if available != nil && h.available(file.Name(), now) != *available {
+			return
+		}
+		if !withKind {
+			files = append(files, file)
+			return
+		}
 
+		m, err := h.meta.Get(file.Name())
+		if err != nil {
+			m = nil
+		}
+		k := h.fileKind(file.Name(), m)
+		if groups != nil {
+			groups[k]++
+		}
+		if kind == "" || k == kind {
+			files = append(files, file)
+			kinds[file.Name()] = k
+		}
+	}
+	truncated, err := h.walkRoots(
+		r.Context(), false, func(_, _ string, file fs.DirEntry) error {
+			add(file)
+			return nil
+		},
+	)
+	if err == nil {
+		// Files transitioned to a backend are no longer on disk but are
+		// still served, so they are listed from their metadata.
+		err = h.walkTransitioned(
+			func(m *meta.File) error {
+				if strings.Contains(m.Name, "/") {
+					return nil
+				}
+				if _, err := os.Lstat(h.filePath(m.Name)); err == nil {
+					return nil
+				}
+				add(transitionedEntry{m})
+				return r.Context().Err()
+			},
+		)
+	}
+	if err != nil {
+		writeError(w, canceledOr(err, ErrReadingDir))
+		return
+	}
+	slices.SortFunc(files, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+
+	// A cursor resumes after the last name served rather than at an offset,
+	// so files created or deleted between pages do not shift the rest.
 	count := len(files)
 	start := (page - 1) * size
+	if cursor {
+		start, _ = slices.BinarySearchFunc(
+			files, after, func(f fs.DirEntry, name string) int { return strings.Compare(f.Name(), name) },
+		)
+		if start < count && files[start].Name() == after {
+			start++
+		}
+	}
 	end := start + size
 	if start > count {
 		start = count
@@ -121,89 +942,465 @@ func (h *Handler) listFiles(w http.ResponseWriter, r *http.Request) {
 		end = count
 	}
 
-	res := make([]string, 0, len(files))
+	withMeta := includes(r, "meta")
+	withAvailability := includes(r, "availability")
+	res := make([]any, 0, end-start)
 	for _, file := range files[start:end] {
-		if !file.IsDir() {
-			res = append(res, fmt.Sprintf("/%s", filepath.Join(h.savePath, file.Name())))
+		if !withMeta && !withAvailability && !withKind {
+			res = append(res, h.fileURL(r, file.Name()))
+			continue
+		}
+
+		entry := ListEntry{URL: h.fileURL(r, file.Name()), Kind: kinds[file.Name()]}
+		m, err := h.meta.Get(file.Name())
+		if withMeta && err == nil {
+			entry.Meta = withoutExpiredHold(m)
+		}
+		if withAvailability {
+			ok := err != nil || m.Available(now, h.availabilitySkew())
+			entry.Available = &ok
 		}
+		res = append(res, entry)
 	}
 
 	totalPages := (count + size - 1) / size
+	var next string
+	if end < count && end > start {
+		next = h.encodeListCursor(files[end-1].Name(), now)
+	}
+	if cursor {
+		page = 0
+	}
 	utils.SuccessPaginatedResponse(
 		w, http.StatusOK, utils.PaginatedResponse{
 			Data:        res,
 			Count:       count,
 			TotalPages:  totalPages,
 			CurrentPage: page,
-			HasNextPage: page < totalPages,
+			HasNextPage: end < count,
+			Truncated:   truncated,
+			Hint:        truncatedHint(truncated, h.maxWalkEntries()),
+			NextCursor:  next,
+			Groups:      groups,
 		},
 	)
 }
 
 func (h *Handler) createFile(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		utils.ErrResponse(w, http.StatusMethodNotAllowed, ErrParsingForm)
+	receipt, err := h.wantsReceipt(r)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
+	idem, w, ok := h.beginIdempotent(w, r)
+	if !ok {
+		return
+	}
+	defer idem.finish()
 
-	if err := r.ParseMultipartForm(h.config.MaxUploadSize); err != nil {
-		utils.ErrResponse(w, http.StatusBadRequest, ErrFileTooBig)
+	body, limit, ok := h.limitUpload(w, r)
+	if !ok {
 		return
 	}
 
-	file, handler, err := r.FormFile("file")
+	sw, progress, err := h.trackUpload(w, r)
 	if err != nil {
-		utils.ErrResponse(w, http.StatusBadRequest, ErrRetrievingFile)
+		errorResponse(w, http.StatusBadRequest, err)
 		return
 	}
-	defer file.Close()
+	if progress != nil {
+		w = sw
+		defer func() {
+			if sw.code >= http.StatusBadRequest {
+				h.progress.finish(progress, ProgressFailed)
+				return
+			}
+			h.progress.finish(progress, ProgressDone)
+		}()
+	}
+
+	guard := h.guardUpload(w, r)
+	if guard != nil {
+		defer guard.Stop()
+	}
+
+	expected, err := expectedChecksum(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	upload, err := h.parseUpload(r, h.precheckUpload(r, expected))
+	var existing *existingUpload
+	if errors.As(err, &existing) {
+		if idem.replay(w, expected) {
+			return
+		}
+		h.skipUpload(w, r, existing, expected, receipt)
+		return
+	}
+	if errors.Is(err, ErrNoFilePart) {
+		writeError(w, err)
+		return
+	}
+	if err != nil {
+		if guard.aborted(w, r) {
+			return
+		}
+		if tooLargeErr(err) {
+			h.tooLarge(w, body, limit)
+			return
+		}
+		if errors.Is(err, errWritingTemp) {
+			log.Printf("Error spooling upload: %s\n", err)
+			writeError(w, storageError(err))
+			return
+		}
+		errorResponse(w, http.StatusBadRequest, withParams(ErrFileTooBig, map[string]any{"limit": limit}))
+		return
+	}
+	defer os.Remove(upload.path)
+	if idem.replay(w, upload.checksum) {
+		return
+	}
+	if upload.size == 0 && !h.allowEmptyFiles() {
+		writeError(w, ErrEmptyFile)
+		return
+	}
+	if progress != nil {
+		progress.state.Store(ProgressProcessing)
+	}
 
-	dstPath := filepath.Join(h.savePath, handler.Filename)
-	if _, err := os.Stat(dstPath); err == nil {
-		utils.ErrResponse(w, http.StatusConflict, ErrAlreadyExists)
+	strategy, err := h.namingStrategy(r)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
-	dst, err := os.Create(dstPath)
+	contentType := r.FormValue("content_type")
+	if contentType != "" {
+		if _, _, err = mime.ParseMediaType(contentType); err != nil {
+			writeError(w, ErrInvalidContentType)
+			return
+		}
+	}
+	availableFrom, availableUntil, err := parseAvailability(r.FormValue("available_from"), r.FormValue("available_until"))
 	if err != nil {
-		utils.ErrResponse(w, http.StatusInternalServerError, ErrInternal)
+		writeError(w, err)
 		return
 	}
-	defer dst.Close()
 
-	if _, err := io.Copy(dst, file); err != nil {
-		utils.ErrResponse(w, http.StatusInternalServerError, ErrInternal)
+	overwrite, err := wantsOverwrite(r, r.PostForm)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
-	fileURL := fmt.Sprintf("/%s", dstPath)
+	original, err := h.cleanName(upload.filename)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var name string
+	if strategy != NamingHash {
+		name = storedName(strategy, original, "")
+		if existing, ok := h.resolveName(name); ok && !overwrite {
+			writeError(w, existsError(name, existing))
+			return
+		} else if ok {
+			name = existing
+		}
+	}
+
+	size, checksum := upload.size, upload.checksum
+	var tags []string
+	for _, tag := range strings.Split(r.FormValue("tags"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	if strategy == NamingHash {
+		name = storedName(strategy, original, checksum)
+	}
+	if !h.authorizeName(w, r, ActionUpload, name) {
+		return
+	}
+
+	now := time.Now().UTC()
+	stored := &meta.File{
+		Name:         name,
+		OriginalName: original,
+		Size:         size,
+		Checksum:     checksum,
+		ContentType:  contentType,
+		Tags:         tags,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+
+		AvailableFrom:  availableFrom,
+		AvailableUntil: availableUntil,
+	}
+	kept := h.convertHEIF(r.Context(), upload.path, stored)
+	if kept != "" {
+		defer os.Remove(kept)
+	}
+	if !h.probeImage(w, upload.path, stored) {
+		return
+	}
+
+	validated, ok := h.validateUpload(w, r, upload.path, stored)
+	if !ok {
+		return
+	}
+	if validated != name {
+		if existing, ok := h.resolveName(validated); ok && !overwrite {
+			writeError(w, existsError(validated, existing))
+			return
+		} else if ok {
+			validated = existing
+		}
+		if !h.authorizeName(w, r, ActionUpload, validated) {
+			return
+		}
+		name, stored.Name = validated, validated
+	}
+
+	// A client that went away retries, so nothing may be stored for it.
+	if err := storage.Canceled(r.Context()); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	fileURL := h.fileURL(r, name)
+	unlock := h.fileMu.Lock(name)
+	defer unlock()
+
+	dstPath, root, err := h.placePath(name, size)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	stored.Root = h.storedRoot(root)
+
+	if _, err := os.Stat(dstPath); err == nil && strategy == NamingHash {
+		log.Printf("File deduplicated: %s\n", fileURL)
+		res := utils.UploadResponse{
+			URL:          fileURL,
+			Name:         name,
+			OriginalName: original,
+		}
+		if m, err := h.meta.Get(name); err == nil {
+			res.ID = m.ID
+		}
+		utils.JSONResponse(w, http.StatusOK, res)
+		return
+	}
+
+	// An overwrite sets the current content aside before renaming the new
+	// one over it, so concurrent overwrites apply one after the other and
+	// each replaced content is kept.
+	var rep *replacement
+	if overwrite && strategy != NamingHash {
+		var ok bool
+		if rep, ok = h.prepareOverwrite(w, r, name); !ok {
+			return
+		}
+	}
+	defer h.intend(meta.OpPut, name, stored)()
+	if rep != nil {
+		if err = h.placeFile(upload.path, dstPath, h.durableWrites(r)); err != nil {
+			rep.discard()
+		}
+	} else if !h.checkDirCap(w, dstPath) {
+		return
+	} else {
+		err = h.commitFile(upload.path, dstPath, h.durableWrites(r))
+	}
+	if errors.Is(err, ErrAlreadyExists) {
+		existing, _ := h.resolveName(name)
+		writeError(w, existsError(name, existing))
+		return
+	}
+	if err != nil {
+		log.Printf("Error committing %s: %s\n", name, err)
+		writeError(w, storageError(err))
+		return
+	}
+
+	if rep != nil {
+		stored.ID = h.overwriteID(name)
+		if rep.meta != nil {
+			stored.CreatedAt = rep.meta.CreatedAt
+		}
+	}
+	h.locate(name, root)
+	h.placeOriginal(name, kept)
+	h.finishUpload(w, r, stored, rep)
+}
+
+// finishUpload records metadata for a file already committed under
+// stored.Name, runs upload hooks and post-processing, and writes the
+// response. The file is removed again if any of these steps fail, or its
+// previous content restored when it replaced rep. With post-processing
+// configured it is processing, and not served, until its job finishes.
+func (h *Handler) finishUpload(w http.ResponseWriter, r *http.Request, stored *meta.File, rep *replacement) {
+	name := stored.Name
+	dstPath := h.filePath(name)
+	fileURL := h.fileURL(r, name)
+	rollback := func() {
+		if rep != nil {
+			h.restore(rep)
+			return
+		}
+		h.rollbackUpload(name)
+	}
+	if h.jobs != nil {
+		stored.State = meta.StateProcessing
+	}
+	if err := h.meta.Put(stored); err != nil {
+		log.Printf("Error saving metadata for %s: %s\n", name, err)
+		rollback()
+		writeError(w, ErrInternal)
+		return
+	}
+
+	if err := h.runUploaded(r.Context(), fileInfo(name, stored)); err != nil {
+		log.Printf("Upload of %s rejected by hook: %s\n", name, err)
+		rollback()
+		writeError(w, classify(err, ErrUploadRejected))
+		return
+	}
+
+	res := utils.UploadResponse{
+		ID:           stored.ID,
+		URL:          fileURL,
+		Name:         name,
+		OriginalName: stored.OriginalName,
+	}
+	if h.jobs != nil {
+		job, err := h.jobs.Enqueue(name, h.processingTasks())
+		if err != nil {
+			log.Printf("Error enqueueing post-processing for %s: %s\n", name, err)
+			rollback()
+			if errors.Is(err, jobs.ErrQueueFull) {
+				w.Header().Set("Retry-After", "5")
+				writeError(w, err)
+				return
+			}
+			writeError(w, ErrInternal)
+			return
+		}
+		res.Processing, res.Job, res.Tasks = true, job.ID, job.Pending()
+	}
+
+	typ, status := webhook.FileCreated, http.StatusCreated
+	data := map[string]any{"size": stored.Size, "checksum": stored.Checksum}
+	if info, err := h.statStored(dstPath); err == nil {
+		w.Header().Set("ETag", fileETag(stored, info))
+	}
+	if rep != nil {
+		h.keep(rep, w.Header().Get("ETag"))
+		res.PreviousETag = rep.etag
+		typ, status, data["previous_etag"] = webhook.FileUpdated, http.StatusOK, rep.etag
+	}
+	// A request asking for a receipt without receipts enabled failed before
+	// the upload.
+	if receipt, _ := h.wantsReceipt(r); receipt {
+		var err error
+		if res.Receipt, err = h.signReceipt(w, r, name, stored.Size, stored.Checksum); err != nil {
+			log.Printf("Error signing the receipt for %s: %s\n", name, err)
+		}
+	}
+
+	h.emit(typ, name, r, data)
+	h.warmImages(name, stored.ContentType)
 	log.Printf("File saved: %s\n", fileURL)
-	utils.SuccessResponse(w, http.StatusCreated, fileURL)
+	utils.JSONResponse(w, status, res)
 }
 
 func (h *Handler) deleteFile(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
-		utils.ErrResponse(w, http.StatusMethodNotAllowed, ErrInvalidReqMethod)
+	filename := r.PathValue("name")
+	if filename == "" {
+		filename = r.URL.Query().Get("filename")
+	}
+	if filename == "" {
+		writeError(w, ErrFilenameNotProvided)
 		return
 	}
 
-	filename := r.URL.Query().Get("filename")
-	if filename == "" {
-		utils.ErrResponse(w, http.StatusBadRequest, ErrFilenameNotProvided)
+	if err := h.checkEncodedSlash(r); err != nil {
+		writeError(w, err)
+		return
+	}
+	filename, err := h.cleanPath(filename)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !h.checkInternal(w, r, filename) {
+		return
+	}
+	filename, _ = h.resolveName(filename)
+
+	path, err := h.checkPath(filename)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	unlock := h.fileMu.Lock(filename)
+	defer unlock()
+
+	if _, err := os.Stat(path); os.IsNotExist(err) && h.transitioned(filename) == nil {
+		if r.Header.Get("If-Match") != "" {
+			writeError(w, withParams(ErrPreconditionFailed, map[string]any{"name": filename}))
+			return
+		}
+		writeError(w, withParams(ErrNotFound, map[string]any{"name": filename}))
 		return
 	}
 
-	path := filepath.Join(h.savePath, filename)
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		utils.ErrResponse(w, http.StatusNotFound, err)
+	if !h.checkLock(w, r, filename) || !h.checkHold(w, filename) {
 		return
 	}
 
-	if err := os.Remove(path); err != nil {
-		utils.ErrResponse(w, http.StatusInternalServerError, err)
+	m, err := h.meta.Get(filename)
+	if err != nil {
+		m = nil
+	}
+	if !h.checkIfMatch(w, r, filename, path, m) {
+		return
+	}
+	if dryRun(r) {
+		h.simulate(webhook.FileDeleted, filename, r, nil)
+		utils.JSONResponse(w, http.StatusOK, &DeleteResult{DryRun: true, Name: filename, Status: http.StatusNoContent})
 		return
 	}
 
+	defer h.intend(meta.OpDelete, filename, nil)()
+	deferred, err := h.discard(path, filename, m)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.meta.Delete(filename); err != nil {
+		log.Printf("Error removing metadata for %s: %s\n", filename, err)
+	}
+	if err := os.Remove(h.waveformPath(filename)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing waveform for %s: %s\n", filename, err)
+	}
+	h.removePreview(filename)
+	h.removeOriginal(filename)
+
+	runFileHooks(r.Context(), h.hooks.deleted, fileInfo(filename, m))
+	h.emit(webhook.FileDeleted, filename, r, nil)
+	if deferred {
+		utils.SuccessResponse(w, http.StatusAccepted, "Deletion pending")
+		return
+	}
 	log.Printf("File %s deleted successfully\n", filename)
 	utils.SuccessResponse(w, http.StatusNoContent, "OK")
 }
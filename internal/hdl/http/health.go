@@ -0,0 +1,29 @@
+package http
+
+import (
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"net/http"
+	"os"
+)
+
+type Readiness struct {
+	Status   string   `json:"status"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+func (h *Handler) readyz(w http.ResponseWriter, r *http.Request) {
+	for _, root := range h.rootDirs() {
+		if _, err := os.Stat(root); err != nil {
+			utils.JSONResponse(w, http.StatusServiceUnavailable, &Readiness{Status: "unavailable", Warnings: []string{err.Error()}})
+			return
+		}
+	}
+
+	res := &Readiness{Status: "ok"}
+	if h.events != nil {
+		if err := h.events.Health(); err != nil {
+			res.Warnings = append(res.Warnings, "event bus: "+err.Error())
+		}
+	}
+	utils.JSONResponse(w, http.StatusOK, res)
+}
@@ -0,0 +1,233 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/internal/imaging"
+	"github.com/JMURv/media-server/internal/meta"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	originalsDir = ".originals"
+
+	defaultHEICTimeout = time.Minute
+)
+
+func (h *Handler) validateHEIC() error {
+	cfg := h.config.HEIC
+	if cfg == nil || len(cfg.Command) == 0 {
+		return nil
+	}
+
+	joined := strings.Join(cfg.Command, " ")
+	if !strings.Contains(joined, "{input}") {
+		return fmt.Errorf("%w: missing {input}", ErrInvalidConvertCommand)
+	}
+	if !strings.Contains(joined, "{output}") {
+		return fmt.Errorf("%w: missing {output}", ErrInvalidConvertCommand)
+	}
+	return nil
+}
+
+func heifFormatOf(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	header := make([]byte, imaging.HEIFHeaderSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	return imaging.HEIFFormat(header[:n]), nil
+}
+
+// convertHEIF replaces the upload spooled at path with a JPEG conversion
+// when it is an HEIC or HEIF image, and records the format it was received
+// in on stored. A failed conversion leaves the upload as it is, with the
+// error recorded. When the original is kept it returns where it was moved
+// to, for placeOriginal once the conversion is committed; the caller
+// removes it otherwise.
+func (h *Handler) convertHEIF(ctx context.Context, path string, stored *meta.File) string {
+	cfg := h.config.HEIC
+	if cfg == nil {
+		return ""
+	}
+	format, err := heifFormatOf(path)
+	if err != nil {
+		log.Printf("Error detecting the format of %s: %s\n", stored.Name, err)
+		return ""
+	}
+	if format == "" {
+		return ""
+	}
+
+	stored.OriginalFormat = format
+	if len(cfg.Command) == 0 {
+		return ""
+	}
+
+	original, err := h.runConverter(ctx, path, format)
+	if err != nil {
+		log.Printf("Error converting %s from %s: %s\n", stored.Name, format, err)
+		stored.ConversionError = err.Error()
+		return ""
+	}
+	if !cfg.KeepOriginal {
+		os.Remove(original)
+		original = ""
+	}
+
+	info, err := os.Stat(path)
+	if err == nil {
+		stored.Checksum, err = fileChecksum(ctx, path)
+	}
+	if err != nil {
+		log.Printf("Error reading conversion of %s: %s\n", stored.Name, err)
+	}
+	if info != nil {
+		stored.Size = info.Size()
+	}
+	stored.ContentType, stored.DetectedType = "image/jpeg", ""
+	return original
+}
+
+// runConverter converts the image at path to JPEG in place. The received
+// file is moved next to it and its new path returned.
+func (h *Handler) runConverter(ctx context.Context, path, format string) (string, error) {
+	cfg := h.config.HEIC
+	dir, err := os.MkdirTemp(filepath.Dir(path), "convert-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "input."+format)
+	output := filepath.Join(dir, "output.jpg")
+	if err = os.Link(path, input); err != nil {
+		if err = copyAside(path, input); err != nil {
+			return "", err
+		}
+	}
+
+	args := make([]string, len(cfg.Command))
+	for i, arg := range cfg.Command {
+		args[i] = strings.NewReplacer("{input}", input, "{output}", output).Replace(arg)
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultHEICTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Stderr = stderr
+	cmd.WaitDelay = time.Second
+	if err = cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("%w: timed out after %s", ErrConvertFailed, timeout)
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > 200 {
+			msg = msg[len(msg)-200:]
+		}
+		return "", fmt.Errorf("%w: %w: %s", ErrConvertFailed, err, msg)
+	}
+
+	file, err := os.Open(output)
+	if err != nil {
+		return "", fmt.Errorf("%w: no output", ErrConvertFailed)
+	}
+	got, err := sniffType(file)
+	file.Close()
+	if err != nil {
+		return "", err
+	}
+	if got != "image/jpeg" {
+		return "", fmt.Errorf("%w: output is %s, not image/jpeg", ErrConvertFailed, got)
+	}
+
+	original := path + ".original"
+	if err = os.Rename(path, original); err != nil {
+		return "", err
+	}
+	if err = os.Rename(output, path); err != nil {
+		return "", errors.Join(err, os.Rename(original, path))
+	}
+	return original, nil
+}
+
+func (h *Handler) originalPath(name string) string {
+	return filepath.Join(h.savePath, originalsDir, filepath.FromSlash(name))
+}
+
+// placeOriginal keeps the received file at original, if any, as the
+// original of name, once its conversion is committed. Any original kept
+// for content name held before is dropped.
+func (h *Handler) placeOriginal(name, original string) {
+	if original == "" {
+		h.removeOriginal(name)
+		return
+	}
+
+	defer h.invalidateFile(originalsDir + "/" + name)
+	dst := h.originalPath(name)
+	err := os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+	if err == nil {
+		_, err = h.sealFile(context.Background(), original, false)
+	}
+	if err == nil {
+		err = h.moveFile(original, dst)
+	}
+	if err != nil {
+		log.Printf("Error keeping the original of %s: %s\n", name, err)
+	}
+}
+
+func (h *Handler) removeOriginal(name string) {
+	if h.config.HEIC == nil {
+		return
+	}
+
+	defer h.invalidateFile(originalsDir + "/" + name)
+	if err := os.Remove(h.originalPath(name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing the original of %s: %s\n", name, err)
+	}
+}
+
+// streamSource returns the stored name /stream serves for name: its kept
+// original with ?original=true, name itself otherwise. The content type of
+// an original is returned too, as it is not recorded for it.
+func (h *Handler) streamSource(r *http.Request, name string) (string, string, error) {
+	if r.URL.Query().Get("original") != "true" {
+		return name, "", nil
+	}
+
+	m, err := h.meta.Get(name)
+	if err != nil || m.OriginalFormat == "" {
+		return "", "", ErrOriginalNotKept
+	}
+	if _, err = os.Stat(h.originalPath(name)); err != nil {
+		return "", "", ErrOriginalNotKept
+	}
+	return originalsDir + "/" + name, "image/" + m.OriginalFormat, nil
+}
@@ -0,0 +1,183 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/imaging"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+const fakeHEIFConverter = `#!/bin/sh
+case "$(cat "$1")" in
+*broken*) echo "not an image" >&2; exit 1 ;;
+*text*) echo text > "$2"; exit 0 ;;
+esac
+cp "$JPEG_FILE" "$2"
+`
+
+func heifHeader(brands ...string) string {
+	box := []byte{0, 0, 0, byte(16 + 4*len(brands))}
+	box = append(box, "ftyp"...)
+	box = append(box, brands[0]...)
+	box = append(box, 0, 0, 0, 0)
+	for _, b := range brands {
+		box = append(box, b...)
+	}
+	return string(box)
+}
+
+func TestHEIFFormat(t *testing.T) {
+	for header, want := range map[string]string{
+		heifHeader("heic", "mif1"):         imaging.FormatHEIC,
+		heifHeader("mif1", "heix"):         imaging.FormatHEIC,
+		heifHeader("mif1", "miaf"):         imaging.FormatHEIF,
+		heifHeader("msf1", "hevc"):         imaging.FormatHEIC,
+		heifHeader("avif", "mif1", "miaf"): "",
+		heifHeader("mif1", "avif"):         "",
+		heifHeader("isom", "mp42"):         "",
+		"\x00\x00\x00\x18ftyp":             "",
+		"plain text content":               "",
+	} {
+		assert.Equal(t, want, imaging.HEIFFormat([]byte(header)), []byte(header))
+	}
+}
+
+func TestHEIC(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake converter is a shell script")
+	}
+
+	testDir := t.TempDir()
+
+	bin := t.TempDir()
+	converter := filepath.Join(bin, "convert")
+	jpegFile := filepath.Join(bin, "converted.jpg")
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, jpeg.Encode(buf, image.NewGray(image.Rect(0, 0, 16, 16)), nil))
+	fixture := buf.Bytes()
+	assert.Nil(t, os.WriteFile(jpegFile, fixture, 0644))
+	assert.Nil(t, os.WriteFile(converter, []byte(fakeHEIFConverter), 0755))
+	t.Setenv("JPEG_FILE", jpegFile)
+
+	newHandler := func(heic *config.HEICConfig) *Handler {
+		return New(port, testDir, &config.HTTPConfig{MaxUploadSize: 1 << 20, MaxStreamBuffer: 1 << 20, HEIC: heic})
+	}
+	upload := func(hdl *Handler, name, content string) {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, newUploadRequest(name, content, nil))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	}
+	stream := func(hdl *Handler, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+	assertNotKept := func(rec *httptest.ResponseRecorder) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		res := utils.ErrorResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		assert.Equal(t, "original_not_kept", res.Code)
+	}
+
+	photo := heifHeader("heic", "mif1") + "photo"
+
+	t.Run(
+		"Without a converter the format is recorded", func(t *testing.T) {
+			hdl := newHandler(&config.HEICConfig{})
+			upload(hdl, "plain.heic", photo)
+			upload(hdl, "notes.txt", "plain text content")
+
+			m, err := hdl.meta.Get("plain.heic")
+			assert.Nil(t, err)
+			assert.Equal(t, imaging.FormatHEIC, m.OriginalFormat)
+			assert.Empty(t, m.ConversionError)
+			data, _ := os.ReadFile(filepath.Join(testDir, "plain.heic"))
+			assert.Equal(t, photo, string(data))
+
+			m, err = hdl.meta.Get("notes.txt")
+			assert.Nil(t, err)
+			assert.Empty(t, m.OriginalFormat)
+
+			assertNotKept(stream(hdl, "/stream/plain.heic?original=true"))
+		},
+	)
+
+	t.Run(
+		"Converted with the original kept", func(t *testing.T) {
+			hdl := newHandler(&config.HEICConfig{Command: []string{converter, "{input}", "{output}"}, KeepOriginal: true})
+			upload(hdl, "kept.heic", photo)
+
+			m, err := hdl.meta.Get("kept.heic")
+			assert.Nil(t, err)
+			assert.Equal(t, imaging.FormatHEIC, m.OriginalFormat)
+			assert.Equal(t, "image/jpeg", m.ContentType)
+			assert.Equal(t, int64(len(fixture)), m.Size)
+
+			rec := stream(hdl, "/stream/kept.heic")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "image/jpeg", rec.Header().Get("Content-Type"))
+			assert.Equal(t, fixture, rec.Body.Bytes())
+
+			rec = stream(hdl, "/stream/kept.heic?original=true")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "image/heic", rec.Header().Get("Content-Type"))
+			assert.Equal(t, photo, rec.Body.String())
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/files/kept.heic", nil))
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+			assert.NoFileExists(t, filepath.Join(testDir, originalsDir, "kept.heic"))
+			assert.Equal(t, http.StatusNotFound, stream(hdl, "/stream/kept.heic?original=true").Code)
+		},
+	)
+
+	hdl := newHandler(&config.HEICConfig{Command: []string{converter, "{input}", "{output}"}})
+
+	t.Run(
+		"Converted only", func(t *testing.T) {
+			upload(hdl, "only.heic", photo)
+
+			rec := stream(hdl, "/stream/only.heic")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, fixture, rec.Body.Bytes())
+			assertNotKept(stream(hdl, "/stream/only.heic?original=true"))
+			assert.NoFileExists(t, filepath.Join(testDir, originalsDir, "only.heic"))
+		},
+	)
+
+	t.Run(
+		"Failed conversions keep the upload", func(t *testing.T) {
+			for name, content := range map[string]string{
+				"broken.heic": heifHeader("mif1") + "broken",
+				"text.heic":   heifHeader("mif1") + "text",
+			} {
+				upload(hdl, name, content)
+
+				m, err := hdl.meta.Get(name)
+				assert.Nil(t, err)
+				assert.Equal(t, imaging.FormatHEIF, m.OriginalFormat)
+				assert.NotEmpty(t, m.ConversionError)
+				data, _ := os.ReadFile(filepath.Join(testDir, name))
+				assert.Equal(t, content, string(data))
+			}
+		},
+	)
+
+	t.Run(
+		"Invalid command fails at startup", func(t *testing.T) {
+			assert.Panics(t, func() { newHandler(&config.HEICConfig{Command: []string{converter, "{input}"}}) })
+			assert.Panics(t, func() { newHandler(&config.HEICConfig{Command: []string{converter, "{output}"}}) })
+		},
+	)
+}
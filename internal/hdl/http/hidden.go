@@ -0,0 +1,163 @@
+package http
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+const includeHidden = "hidden"
+
+func validateIgnore(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return ErrInvalidIgnore
+		}
+	}
+	return nil
+}
+
+// hidden reports whether name belongs to the internal namespace: any
+// dot-prefixed segment, or a base name matching the configured ignore list.
+func (h *Handler) hidden(name string) bool {
+	segments := strings.Split(strings.Trim(name, "/"), "/")
+	for _, seg := range segments {
+		if strings.HasPrefix(seg, ".") {
+			return true
+		}
+	}
+
+	base := segments[len(segments)-1]
+	return slices.ContainsFunc(
+		h.config.Ignore, func(p string) bool {
+			ok, _ := path.Match(p, base)
+			return ok
+		},
+	)
+}
+
+func bearerMatches(r *http.Request, want string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || want == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// isAdmin reports whether r carries the admin token, or the token of a
+// principal the policy allows admin on any file.
+func (h *Handler) isAdmin(r *http.Request) bool {
+	if bearerMatches(r, h.config.AdminToken) {
+		return true
+	}
+	return h.policy != nil && h.policy.evaluate(h.principal(r), ActionAdmin, "").Allowed
+}
+
+// canRead reports whether r may use read-only bulk endpoints: anyone when no
+// tokens or policy are configured, otherwise holders of the read or admin
+// token and principals allowed to list. Without a policy any Basic auth user
+// may.
+func (h *Handler) canRead(r *http.Request) bool {
+	if h.config.AdminToken == "" && h.config.ReadToken == "" && h.policy == nil {
+		return true
+	}
+	if _, ok := basicUser(r); ok && h.policy == nil {
+		return true
+	}
+	if h.isAdmin(r) || bearerMatches(r, h.config.ReadToken) {
+		return true
+	}
+	return h.policy != nil && h.policy.evaluate(h.principal(r), ActionList, "").Allowed
+}
+
+func includes(r *http.Request, flag string) bool {
+	for _, v := range r.URL.Query()["include"] {
+		if slices.Contains(strings.Split(v, ","), flag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) showHidden(r *http.Request) (bool, error) {
+	if !includes(r, includeHidden) {
+		return false, nil
+	}
+	if !h.isAdmin(r) {
+		return false, ErrAdminRequired
+	}
+	return true, nil
+}
+
+func (h *Handler) checkInternal(w http.ResponseWriter, r *http.Request, name string) bool {
+	if isCachePath(name) || (h.hidden(name) && !h.isAdmin(r)) {
+		writeError(w, ErrInternalPath)
+		return false
+	}
+	return true
+}
+
+type publicFS struct {
+	http.FileSystem
+	h *Handler
+}
+
+func (fsys publicFS) Open(name string) (http.File, error) {
+	if isCachePath(name) {
+		return nil, fs.ErrNotExist
+	}
+	f, err := fsys.FileSystem.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fsys.openTransitioned(strings.TrimPrefix(name, "/"), err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err == nil && !info.IsDir() {
+		if err = fsys.h.servable(strings.TrimPrefix(name, "/")); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if file, ok := f.(*os.File); ok && fsys.h.crypt != nil {
+			stored, err := fsys.h.unseal(context.Background(), file)
+			if err != nil {
+				return nil, err
+			}
+			f = sealedFile{stored}
+		}
+	}
+	return publicDir{File: f, h: fsys.h}, nil
+}
+
+// openTransitioned serves name from the backend it was moved to, returning
+// notFound if it was not moved.
+func (fsys publicFS) openTransitioned(name string, notFound error) (http.File, error) {
+	m := fsys.h.transitioned(name)
+	if m == nil {
+		return nil, notFound
+	}
+	if err := fsys.h.servable(name); err != nil {
+		return nil, err
+	}
+	file, err := fsys.h.openFile(context.Background(), name)
+	if err != nil {
+		return nil, err
+	}
+	return transitionedFile{ReadSeekCloser: file, m: m}, nil
+}
+
+type publicDir struct {
+	http.File
+	h *Handler
+}
+
+func (d publicDir) Readdir(count int) ([]fs.FileInfo, error) {
+	entries, err := d.File.Readdir(count)
+	return slices.DeleteFunc(entries, func(e fs.FileInfo) bool { return d.h.hidden(e.Name()) }), err
+}
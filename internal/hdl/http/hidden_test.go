@@ -0,0 +1,146 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHiddenEntries(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize:   1024 * 1024,
+			MaxStreamBuffer: 1024,
+			DefaultPage:     1,
+			DefaultSize:     2,
+			Ignore:          []string{"Thumbs.db", "*.draft.png"},
+			AdminToken:      "admin-secret",
+		},
+	)
+
+	rec := httptest.NewRecorder()
+	hdl.ServeHTTP(rec, newUploadRequest("cat.png", "cat", nil))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	for _, name := range []string{"Thumbs.db", "cover.draft.png", ".env"} {
+		assert.Nil(t, os.WriteFile(filepath.Join(testDir, name), []byte(name), 0644))
+	}
+
+	do := func(method, path string, admin bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if admin {
+			req.Header.Set("Authorization", "Bearer admin-secret")
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	list := func(path string, admin bool) (int, []string) {
+		res := struct {
+			Data  []string `json:"data"`
+			Count int      `json:"count"`
+		}{}
+		assert.Nil(t, json.NewDecoder(do(http.MethodGet, path, admin).Body).Decode(&res))
+
+		var names []string
+		for _, u := range res.Data {
+			names = append(names, filepath.Base(u))
+		}
+		return res.Count, names
+	}
+
+	t.Run(
+		"Excluded from listings", func(t *testing.T) {
+			count, names := list("/list", false)
+			assert.Equal(t, 1, count)
+			assert.Equal(t, []string{"cat.png"}, names)
+
+			count, names = list("/list?include=hidden,meta", false)
+			assert.Zero(t, count)
+			assert.Empty(t, names)
+			assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/list?include=hidden", false).Code)
+
+			count, _ = list("/list?include=hidden", true)
+			assert.Equal(t, 5, count)
+			_, names = list("/list?include=hidden&page=2", true)
+			assert.Len(t, names, 2)
+
+			rec := do(http.MethodGet, "/uploads/", false)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Contains(t, rec.Body.String(), "cat.png")
+			assert.NotContains(t, rec.Body.String(), ".meta")
+			assert.NotContains(t, rec.Body.String(), "Thumbs.db")
+		},
+	)
+
+	t.Run(
+		"Excluded from stats", func(t *testing.T) {
+			res := Stats{}
+			assert.Nil(t, json.NewDecoder(do(http.MethodGet, "/stats", false).Body).Decode(&res))
+			assert.Equal(t, 1, res.Files)
+			assert.Equal(t, int64(3), res.Bytes)
+
+			assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/stats?include=hidden", false).Code)
+			res = Stats{}
+			assert.Nil(t, json.NewDecoder(do(http.MethodGet, "/stats?include=hidden", true).Body).Decode(&res))
+			assert.Greater(t, res.Files, 4)
+		},
+	)
+
+	t.Run(
+		"Sidecars are not reachable publicly", func(t *testing.T) {
+			for _, path := range []string{
+				"/uploads/.meta/cat.png.json",
+				"/uploads/.env",
+				"/uploads/Thumbs.db",
+				"/stream/cover.draft.png",
+				"/files/Thumbs.db/meta",
+			} {
+				rec := do(http.MethodGet, path, false)
+				assert.Equal(t, http.StatusForbidden, rec.Code, path)
+				assert.NotContains(t, rec.Body.String(), "checksum", path)
+			}
+
+			rec := do(http.MethodGet, "/stream/.meta/cat.png.json", false)
+			assert.NotEqual(t, http.StatusOK, rec.Code)
+			assert.NotContains(t, rec.Body.String(), "checksum")
+
+			rec = do(http.MethodGet, "/uploads/.meta/cat.png.json", true)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Contains(t, rec.Body.String(), "checksum")
+			assert.Equal(t, http.StatusOK, do(http.MethodGet, "/stream/cover.draft.png", true).Code)
+		},
+	)
+
+	t.Run(
+		"Delete needs the admin role", func(t *testing.T) {
+			assert.Equal(t, http.StatusForbidden, do(http.MethodDelete, "/files/Thumbs.db", false).Code)
+			assert.FileExists(t, filepath.Join(testDir, "Thumbs.db"))
+
+			req := httptest.NewRequest(http.MethodDelete, "/files/Thumbs.db", nil)
+			req.Header.Set("Authorization", "Bearer wrong")
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+
+			assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/files/Thumbs.db", true).Code)
+			assert.NoFileExists(t, filepath.Join(testDir, "Thumbs.db"))
+		},
+	)
+
+	t.Run(
+		"Invalid ignore pattern", func(t *testing.T) {
+			assert.Panics(
+				t, func() {
+					New(port, testDir, &config.HTTPConfig{Ignore: []string{"[a-"}})
+				},
+			)
+		},
+	)
+}
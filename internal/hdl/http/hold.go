@@ -0,0 +1,139 @@
+package http
+
+import (
+	"github.com/JMURv/media-server/internal/meta"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+func heldResponse(w http.ResponseWriter, hold *meta.Hold) {
+	utils.JSONResponse(
+		w, http.StatusLocked, utils.HeldResponse{
+			ErrorResponse: errorBody(w, http.StatusLocked, ErrHeld),
+			Reason:        hold.Reason,
+			Until:         hold.Until,
+		},
+	)
+}
+
+func (h *Handler) activeHold(name string) *meta.Hold {
+	m, err := h.meta.Get(name)
+	if err != nil || !m.Hold.Active() {
+		return nil
+	}
+	return m.Hold
+}
+
+func (h *Handler) checkHold(w http.ResponseWriter, name string) bool {
+	if hold := h.activeHold(name); hold != nil {
+		heldResponse(w, hold)
+		return false
+	}
+	return true
+}
+
+func withoutExpiredHold(m *meta.File) *meta.File {
+	if m != nil && m.Hold != nil && !m.Hold.Active() {
+		m.Hold = nil
+	}
+	return m
+}
+
+func (h *Handler) holdFile(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+
+	name, err := h.pathName(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	hold := &meta.Hold{
+		Reason:   r.URL.Query().Get("reason"),
+		PlacedBy: r.RemoteAddr,
+		PlacedAt: time.Now().UTC(),
+	}
+	if v := r.URL.Query().Get("until"); v != "" {
+		until, err := time.Parse(time.RFC3339, v)
+		if err != nil || !until.After(hold.PlacedAt) {
+			writeError(w, ErrInvalidHoldUntil)
+			return
+		}
+		until = until.UTC()
+		hold.Until = &until
+	}
+
+	unlock := h.fileMu.Lock(name)
+	defer unlock()
+
+	path, err := h.checkPath(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if _, err = os.Stat(path); err != nil {
+		writeError(w, ErrNotFound)
+		return
+	}
+
+	m, err := h.meta.Get(name)
+	if err != nil {
+		m = &meta.File{Name: name, OriginalName: name}
+	}
+	m.Hold = hold
+	if err = h.meta.Put(m); err != nil {
+		log.Printf("Error saving hold for %s: %s\n", name, err)
+		writeError(w, ErrInternal)
+		return
+	}
+
+	h.emit("admin.hold", name, r, map[string]any{"until": hold.Until, "reason": hold.Reason})
+	log.Printf("File %s placed under hold by %s\n", name, hold.PlacedBy)
+	utils.JSONResponse(w, http.StatusOK, hold)
+}
+
+func (h *Handler) releaseHold(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+
+	name, err := h.pathName(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	unlock := h.fileMu.Lock(name)
+	defer unlock()
+
+	m, err := h.meta.Get(name)
+	if err != nil || !m.Hold.Active() {
+		writeError(w, ErrNotHeld)
+		return
+	}
+
+	released := m.Hold
+	m.Hold = nil
+	if err = h.meta.Put(m); err != nil {
+		log.Printf("Error releasing hold for %s: %s\n", name, err)
+		writeError(w, ErrInternal)
+		return
+	}
+
+	h.emit(
+		"admin.hold.release", name, r, map[string]any{
+			"placed_by": released.PlacedBy,
+			"placed_at": released.PlacedAt,
+			"until":     released.Until,
+		},
+	)
+	log.Printf("Hold on %s released early\n", name)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,168 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/audit"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetentionHold(t *testing.T) {
+	testDir := t.TempDir()
+
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize:   1024 * 1024,
+			MaxStreamBuffer: 1024,
+			DefaultPage:     1,
+			DefaultSize:     10,
+			AdminToken:      "admin-secret",
+			AuditLog:        auditPath,
+			Lifecycle: &config.LifecycleConfig{
+				Rules: []*config.LifecycleRule{{Name: "purge", Glob: "*.mp4", Action: "delete"}},
+			},
+		},
+	)
+
+	do := func(method, path string, admin bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte("more")))
+		if admin {
+			req.Header.Set("Authorization", "Bearer admin-secret")
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	write := func(name string) {
+		assert.Nil(t, os.WriteFile(filepath.Join(testDir, name), []byte("evidence"), 0644))
+	}
+	assertHeld := func(rec *httptest.ResponseRecorder) {
+		assert.Equal(t, http.StatusLocked, rec.Code)
+		res := utils.HeldResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		assert.Equal(t, ErrHeld.Error(), res.Error)
+	}
+
+	t.Run(
+		"Admin role required", func(t *testing.T) {
+			write("bodycam.mp4")
+			assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/files/bodycam.mp4/hold", false).Code)
+			assert.Equal(t, http.StatusForbidden, do(http.MethodDelete, "/files/bodycam.mp4/hold", false).Code)
+			assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/files/missing.mp4/hold", true).Code)
+			assert.Equal(
+				t, http.StatusBadRequest, do(http.MethodPost, "/files/bodycam.mp4/hold?until=yesterday", true).Code,
+			)
+			past := url.QueryEscape(time.Now().Add(-time.Hour).Format(time.RFC3339))
+			assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/files/bodycam.mp4/hold?until="+past, true).Code)
+		},
+	)
+
+	t.Run(
+		"Active hold refuses mutations", func(t *testing.T) {
+			write("evidence.mp4")
+			until := time.Now().Add(90 * 24 * time.Hour).UTC().Truncate(time.Second)
+			rec := do(
+				http.MethodPost,
+				"/files/evidence.mp4/hold?reason=case-42&until="+url.QueryEscape(until.Format(time.RFC3339)),
+				true,
+			)
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			assertHeld(do(http.MethodDelete, "/files/evidence.mp4", false))
+			assertHeld(do(http.MethodDelete, "/files/evidence.mp4", true))
+			assertHeld(do(http.MethodPatch, "/files/evidence.mp4", false))
+
+			report := hdl.applyLifecycle(context.Background(), time.Now(), hdl.config.Lifecycle.DryRun)
+			for _, action := range report.Actions {
+				if action.Name == "evidence.mp4" {
+					assert.Equal(t, ErrHeld.Error(), action.Error)
+				}
+			}
+			data, err := os.ReadFile(filepath.Join(testDir, "evidence.mp4"))
+			assert.Nil(t, err)
+			assert.Equal(t, "evidence", string(data))
+
+			write("copy.mp4")
+			_, err = hdl.linkDuplicate("copy.mp4", "evidence.mp4", false)
+			assert.ErrorIs(t, err, ErrHeld)
+
+			m := meta.File{}
+			assert.Nil(t, json.NewDecoder(do(http.MethodGet, "/files/evidence.mp4/meta", false).Body).Decode(&m))
+			if assert.NotNil(t, m.Hold) {
+				assert.Equal(t, "case-42", m.Hold.Reason)
+				assert.True(t, until.Equal(*m.Hold.Until))
+			}
+
+			res := struct {
+				Data []ListEntry `json:"data"`
+			}{}
+			assert.Nil(t, json.NewDecoder(do(http.MethodGet, "/list?include=meta", false).Body).Decode(&res))
+			var held bool
+			for _, e := range res.Data {
+				held = held || (e.Meta != nil && e.Meta.Name == "evidence.mp4" && e.Meta.Hold != nil)
+			}
+			assert.True(t, held)
+		},
+	)
+
+	t.Run(
+		"Early release is audited", func(t *testing.T) {
+			write("release.mp4")
+			assert.Equal(t, http.StatusOK, do(http.MethodPost, "/files/release.mp4/hold", true).Code)
+			assertHeld(do(http.MethodDelete, "/files/release.mp4", false))
+
+			assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/files/release.mp4/hold", true).Code)
+			assert.Equal(t, http.StatusConflict, do(http.MethodDelete, "/files/release.mp4/hold", true).Code)
+			assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/files/release.mp4", false).Code)
+
+			file, err := os.Open(auditPath)
+			assert.Nil(t, err)
+			defer file.Close()
+
+			var types []string
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				e := audit.Entry{}
+				assert.Nil(t, json.Unmarshal(scanner.Bytes(), &e))
+				if e.Name == "release.mp4" {
+					types = append(types, e.Action)
+				}
+			}
+			assert.Contains(t, types, "admin.hold")
+			assert.Contains(t, types, "admin.hold.release")
+		},
+	)
+
+	t.Run(
+		"Expired holds release automatically", func(t *testing.T) {
+			write("expired.mp4")
+			past := time.Now().Add(-time.Minute)
+			assert.Nil(
+				t, hdl.meta.Put(
+					&meta.File{
+						Name: "expired.mp4",
+						Hold: &meta.Hold{PlacedBy: "legal", PlacedAt: past.Add(-time.Hour), Until: &past},
+					},
+				),
+			)
+
+			m := meta.File{}
+			assert.Nil(t, json.NewDecoder(do(http.MethodGet, "/files/expired.mp4/meta", false).Body).Decode(&m))
+			assert.Nil(t, m.Hold)
+			assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/files/expired.mp4", false).Code)
+		},
+	)
+}
@@ -0,0 +1,95 @@
+package http
+
+import (
+	"cmp"
+	"context"
+	"github.com/JMURv/media-server/internal/meta"
+	"net/http"
+)
+
+type FileInfo struct {
+	Name         string
+	OriginalName string
+	Size         int64
+	Checksum     string
+	ContentType  string
+	Tags         []string
+}
+
+type UploadHook func(ctx context.Context, f FileInfo) error
+
+type FileHook func(ctx context.Context, f FileInfo)
+
+type hooks struct {
+	uploaded    []UploadHook
+	deleted     []FileHook
+	streamStart []FileHook
+	streamEnd   []FileHook
+}
+
+func fileInfo(name string, m *meta.File) FileInfo {
+	if m == nil {
+		return FileInfo{Name: name, OriginalName: name}
+	}
+	return FileInfo{
+		Name:         name,
+		OriginalName: m.OriginalName,
+		Size:         m.Size,
+		Checksum:     m.Checksum,
+		ContentType:  cmp.Or(m.ContentType, m.DetectedType),
+		Tags:         m.Tags,
+	}
+}
+
+// Use wraps all routes with mw. Middleware registered first runs first and
+// sees the request before any built-in handling, including base path
+// stripping. Use must be called before the handler starts serving.
+func (h *Handler) Use(mw ...func(http.Handler) http.Handler) {
+	h.middleware = append(h.middleware, mw...)
+
+	next := h.mux
+	for i := len(h.middleware) - 1; i >= 0; i-- {
+		next = h.middleware[i](next)
+	}
+	h.handler = next
+}
+
+// OnUploaded registers fn to run after an upload is stored and before it is
+// announced to webhooks, replication and post-processing. Hooks run
+// synchronously in registration order; the first error stops the chain,
+// removes the file and fails the upload with 422.
+func (h *Handler) OnUploaded(fn UploadHook) {
+	h.hooks.uploaded = append(h.hooks.uploaded, fn)
+}
+
+// OnDeleted registers fn to run synchronously, in registration order, after
+// a file has been removed by the API or by a lifecycle rule.
+func (h *Handler) OnDeleted(fn FileHook) {
+	h.hooks.deleted = append(h.hooks.deleted, fn)
+}
+
+// OnStreamStart registers fn to run before the first byte of a stream is
+// written. OnStreamEnd hooks run after the last byte, even if the client
+// went away.
+func (h *Handler) OnStreamStart(fn FileHook) {
+	h.hooks.streamStart = append(h.hooks.streamStart, fn)
+}
+
+func (h *Handler) OnStreamEnd(fn FileHook) {
+	h.hooks.streamEnd = append(h.hooks.streamEnd, fn)
+}
+
+func (h *Handler) runUploaded(ctx context.Context, f FileInfo) error {
+	for _, fn := range h.hooks.uploaded {
+		if err := fn(ctx, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runFileHooks(ctx context.Context, fns []FileHook, f FileInfo) {
+	for _, fn := range fns {
+		fn(ctx, f)
+	}
+}
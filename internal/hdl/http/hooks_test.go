@@ -0,0 +1,129 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMiddlewareAndHooks(t *testing.T) {
+	testDir := t.TempDir()
+	hdl := setupTestHandler(testDir)
+
+	var calls []string
+	record := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					calls = append(calls, name+" before")
+					next.ServeHTTP(w, r)
+					calls = append(calls, name+" after")
+				},
+			)
+		}
+	}
+
+	hdl.Use(record("first"))
+	hdl.Use(
+		record("second"), func(next http.Handler) http.Handler {
+			return http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					if r.Header.Get("Authorization") == "deny" {
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
+					next.ServeHTTP(w, r)
+				},
+			)
+		},
+	)
+
+	hdl.OnUploaded(
+		func(ctx context.Context, f FileInfo) error {
+			calls = append(calls, "uploaded "+f.Name)
+			return nil
+		},
+	)
+	hdl.OnUploaded(
+		func(ctx context.Context, f FileInfo) error {
+			if f.Name == "vetoed.mp4" {
+				return errors.New("rejected by policy")
+			}
+			return nil
+		},
+	)
+	hdl.OnDeleted(func(ctx context.Context, f FileInfo) { calls = append(calls, "deleted "+f.Name) })
+	hdl.OnStreamStart(func(ctx context.Context, f FileInfo) { calls = append(calls, "stream start "+f.OriginalName) })
+	hdl.OnStreamEnd(func(ctx context.Context, f FileInfo) { calls = append(calls, "stream end") })
+
+	t.Run(
+		"Middleware order", func(t *testing.T) {
+			calls = nil
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("hooked.mp4", "video", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(
+				t, []string{"first before", "second before", "uploaded hooked.mp4", "second after", "first after"},
+				calls,
+			)
+		},
+	)
+
+	t.Run(
+		"Middleware can reject", func(t *testing.T) {
+			calls = nil
+			req := newUploadRequest("denied.mp4", "video", nil)
+			req.Header.Set("Authorization", "deny")
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+			assert.NoFileExists(t, filepath.Join(testDir, "denied.mp4"))
+			assert.NotContains(t, calls, "uploaded denied.mp4")
+		},
+	)
+
+	t.Run(
+		"Upload veto", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("vetoed.mp4", "video", nil))
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+			assert.Contains(t, rec.Body.String(), "rejected by policy")
+			assert.NoFileExists(t, filepath.Join(testDir, "vetoed.mp4"))
+
+			_, err := hdl.meta.Get("vetoed.mp4")
+			assert.NotNil(t, err)
+		},
+	)
+
+	t.Run(
+		"Stream hooks", func(t *testing.T) {
+			calls = nil
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream/hooked.mp4", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(
+				t, []string{"first before", "second before", "stream start hooked.mp4", "stream end", "second after", "first after"},
+				calls,
+			)
+		},
+	)
+
+	t.Run(
+		"Delete hook", func(t *testing.T) {
+			calls = nil
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/files/hooked.mp4", nil))
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+			assert.Contains(t, calls, "deleted hooked.mp4")
+
+			_, err := os.Stat(filepath.Join(testDir, "hooked.mp4"))
+			assert.True(t, os.IsNotExist(err))
+		},
+	)
+}
@@ -0,0 +1,227 @@
+package http
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"github.com/JMURv/media-server/internal/metrics"
+	"github.com/JMURv/media-server/pkg/config"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	defaultHotCacheSize     = 64 << 20
+	defaultHotCacheFileSize = 1 << 20
+)
+
+type hotEntry struct {
+	name string
+	data []byte
+	info os.FileInfo
+}
+
+type hotCache struct {
+	mu          sync.Mutex
+	maxBytes    int64
+	maxFileSize int64
+	size        int64
+	gen         uint64
+	order       *list.List
+	items       map[string]*list.Element
+
+	hits   *metrics.Counter
+	misses *metrics.Counter
+	served *metrics.Counter
+}
+
+type cachedFile struct {
+	*bytes.Reader
+	info   os.FileInfo
+	served *metrics.Counter
+}
+
+func (f *cachedFile) Read(p []byte) (int, error) {
+	n, err := f.Reader.Read(p)
+	if f.served != nil {
+		f.served.Add(uint64(n))
+	}
+	return n, err
+}
+
+func (f *cachedFile) Close() error {
+	return nil
+}
+
+func (f *cachedFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+func newHotCache(cfg *config.HotCacheConfig, reg *metrics.Registry) *hotCache {
+	if cfg == nil {
+		return nil
+	}
+
+	c := &hotCache{
+		maxBytes:    cfg.MaxSize,
+		maxFileSize: cfg.MaxFileSize,
+		order:       list.New(),
+		items:       make(map[string]*list.Element),
+		hits:        reg.Counter("hot_cache_hits_total", "Streams served from the in-memory file cache."),
+		misses:      reg.Counter("hot_cache_misses_total", "Cacheable streams that had to be read from disk."),
+		served:      reg.Counter("hot_cache_served_bytes_total", "Bytes read from the in-memory file cache."),
+	}
+	if c.maxBytes <= 0 {
+		c.maxBytes = defaultHotCacheSize
+	}
+	if c.maxFileSize <= 0 {
+		c.maxFileSize = defaultHotCacheFileSize
+	}
+	c.maxFileSize = min(c.maxFileSize, c.maxBytes)
+
+	reg.GaugeFunc(
+		"hot_cache_hit_ratio", "Share of cacheable streams served from memory.", func() float64 {
+			hits, misses := c.hits.Value(), c.misses.Value()
+			if hits+misses == 0 {
+				return 0
+			}
+			return float64(hits) / float64(hits+misses)
+		},
+	)
+	reg.GaugeFunc(
+		"hot_cache_bytes", "Bytes held by the in-memory file cache.", func() float64 {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return float64(c.size)
+		},
+	)
+	return c
+}
+
+func (c *hotCache) get(name string, info os.FileInfo) (*hotEntry, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[name]; ok {
+		e := el.Value.(*hotEntry)
+		if e.info.Size() == info.Size() && e.info.ModTime().Equal(info.ModTime()) {
+			c.order.MoveToFront(el)
+			c.hits.Inc()
+			return e, c.gen
+		}
+		c.remove(el)
+	}
+	c.misses.Inc()
+	return nil, c.gen
+}
+
+func (c *hotCache) put(name string, gen uint64, data []byte, info os.FileInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+	if el, ok := c.items[name]; ok {
+		c.remove(el)
+	}
+
+	c.items[name] = c.order.PushFront(&hotEntry{name: name, data: data, info: info})
+	c.size += int64(len(data))
+	for c.size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *hotCache) remove(el *list.Element) {
+	e := el.Value.(*hotEntry)
+	c.order.Remove(el)
+	delete(c.items, e.name)
+	c.size -= int64(len(e.data))
+}
+
+func (c *hotCache) drop(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[name]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *hotCache) invalidate(name string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if name == "" {
+		c.order.Init()
+		clear(c.items)
+		c.size = 0
+		return
+	}
+	if el, ok := c.items[name]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *hotCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (h *Handler) invalidateFile(name string) {
+	h.exists.invalidate(name)
+	h.hot.invalidate(name)
+	h.forgetRoot(name)
+}
+
+func (h *Handler) openCached(ctx context.Context, name string) (io.ReadSeekCloser, error) {
+	if h.hot == nil {
+		return h.openFile(ctx, name)
+	}
+
+	info, err := h.statFile(name)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > h.hot.maxFileSize {
+		h.hot.drop(name)
+		return h.openFile(ctx, name)
+	}
+
+	e, gen := h.hot.get(name, info)
+	if e != nil {
+		return &cachedFile{Reader: bytes.NewReader(e.data), info: e.info, served: h.hot.served}, nil
+	}
+
+	file, err := h.openFile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := file.(*os.File)
+	if !ok {
+		return file, nil
+	}
+
+	if info, err = f.Stat(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size() > h.hot.maxFileSize {
+		return f, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(f, info.Size()+1))
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) == info.Size() {
+		h.hot.put(name, gen, data, info)
+	}
+	return &cachedFile{Reader: bytes.NewReader(data), info: info}, nil
+}
@@ -0,0 +1,150 @@
+package http
+
+import (
+	"fmt"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHotCache(t *testing.T) {
+	testDir := t.TempDir()
+
+	newHandler := func(cfg *config.HotCacheConfig) *Handler {
+		return New(port, testDir, &config.HTTPConfig{MaxStreamBuffer: 1024, HotCache: cfg})
+	}
+	write := func(name, content string) {
+		assert.Nil(t, os.WriteFile(filepath.Join(testDir, name), []byte(content), 0644))
+	}
+	stream := func(h *Handler, name, rng string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/stream/"+name, nil)
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run(
+		"Disabled by default", func(t *testing.T) {
+			assert.Nil(t, setupTestHandler(testDir).hot)
+		},
+	)
+
+	t.Run(
+		"Serves hot files from memory", func(t *testing.T) {
+			hdl := newHandler(&config.HotCacheConfig{MaxSize: 1024, MaxFileSize: 64})
+			write("icon.png", "0123456789")
+
+			for range 3 {
+				rec := stream(hdl, "icon.png", "")
+				assert.Equal(t, http.StatusOK, rec.Code)
+				assert.Equal(t, "0123456789", rec.Body.String())
+			}
+			assert.Equal(t, uint64(1), hdl.hot.misses.Value())
+			assert.Equal(t, uint64(2), hdl.hot.hits.Value())
+			assert.GreaterOrEqual(t, hdl.hot.served.Value(), uint64(20))
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			assert.Contains(t, rec.Body.String(), "media_server_hot_cache_hit_ratio 0.6666")
+			assert.Contains(t, rec.Body.String(), "media_server_hot_cache_bytes 10")
+		},
+	)
+
+	t.Run(
+		"Ranges from cached content", func(t *testing.T) {
+			hdl := newHandler(&config.HotCacheConfig{})
+			write("sprite.png", "0123456789")
+			stream(hdl, "sprite.png", "")
+
+			rec := stream(hdl, "sprite.png", "bytes=2-4")
+			assert.Equal(t, http.StatusPartialContent, rec.Code)
+			assert.Equal(t, "234", rec.Body.String())
+			assert.Equal(t, "bytes 2-4/10", rec.Header().Get("Content-Range"))
+
+			rec = stream(hdl, "sprite.png", "bytes=0-0,8-9")
+			assert.Equal(t, http.StatusPartialContent, rec.Code)
+			assert.Contains(t, rec.Body.String(), "89")
+			assert.Equal(t, uint64(2), hdl.hot.hits.Value())
+		},
+	)
+
+	t.Run(
+		"Validated against the file on each hit", func(t *testing.T) {
+			hdl := newHandler(&config.HotCacheConfig{})
+			write("logo.png", "old")
+			assert.Equal(t, "old", stream(hdl, "logo.png", "").Body.String())
+
+			write("logo.png", "newer")
+			assert.Equal(t, "newer", stream(hdl, "logo.png", "").Body.String())
+
+			assert.Nil(t, os.Remove(filepath.Join(testDir, "logo.png")))
+			assert.Equal(t, http.StatusNotFound, stream(hdl, "logo.png", "").Code)
+			assert.Equal(t, 0, hdl.hot.Len())
+		},
+	)
+
+	t.Run(
+		"Invalidated by mutations", func(t *testing.T) {
+			hdl := newHandler(&config.HotCacheConfig{})
+			write("badge.png", "badge")
+			stream(hdl, "badge.png", "")
+			assert.Equal(t, 1, hdl.hot.Len())
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/files/badge.png", nil))
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+			assert.Equal(t, 0, hdl.hot.Len())
+		},
+	)
+
+	t.Run(
+		"Size limits", func(t *testing.T) {
+			hdl := newHandler(&config.HotCacheConfig{MaxSize: 10, MaxFileSize: 8})
+			write("big.png", "0123456789")
+			write("a.png", "aaaaaa")
+			write("b.png", "bbbbbb")
+
+			assert.Equal(t, "0123456789", stream(hdl, "big.png", "").Body.String())
+			assert.Equal(t, 0, hdl.hot.Len())
+
+			stream(hdl, "a.png", "")
+			stream(hdl, "b.png", "")
+			assert.Equal(t, 1, hdl.hot.Len())
+			assert.Equal(t, "aaaaaa", stream(hdl, "a.png", "").Body.String())
+		},
+	)
+
+	t.Run(
+		"Concurrent eviction", func(t *testing.T) {
+			hdl := newHandler(&config.HotCacheConfig{MaxSize: 32, MaxFileSize: 16})
+			for i := range 8 {
+				write(fmt.Sprintf("c%d.png", i), fmt.Sprintf("content-%d", i))
+			}
+
+			var wg sync.WaitGroup
+			deadline := time.Now().Add(200 * time.Millisecond)
+			for i := range 8 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; time.Now().Before(deadline); j++ {
+						n := (i + j) % 8
+						rec := stream(hdl, fmt.Sprintf("c%d.png", n), "")
+						assert.Equal(t, fmt.Sprintf("content-%d", n), rec.Body.String())
+					}
+				}()
+			}
+			wg.Wait()
+			assert.LessOrEqual(t, hdl.hot.Len(), 3)
+		},
+	)
+}
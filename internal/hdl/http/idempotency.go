@@ -0,0 +1,251 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	idempotencyDir            = ".idempotency"
+
+	defaultIdempotencyRetention = 24 * time.Hour
+	minIdempotencyPruneInterval = time.Minute
+	maxIdempotencyKey           = 255
+	maxIdempotentResponse       = 64 << 10
+)
+
+// IdempotencyRecord is the outcome of an upload made with an Idempotency-Key,
+// replayed to retries. Checksum is the uploaded body's, before any
+// conversion.
+type IdempotencyRecord struct {
+	Key       string          `json:"key"`
+	Principal string          `json:"principal"`
+	Name      string          `json:"name"`
+	Checksum  string          `json:"checksum"`
+	Status    int             `json:"status"`
+	ETag      string          `json:"etag,omitempty"`
+	Body      json.RawMessage `json:"body"`
+	CreatedAt time.Time       `json:"created_at"`
+	ExpiresAt time.Time       `json:"expires_at"`
+}
+
+type idempotentWriter struct {
+	http.ResponseWriter
+	code     int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *idempotentWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *idempotentWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if w.body.Len()+len(p) > maxIdempotentResponse {
+		w.overflow = true
+	} else {
+		w.body.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *idempotentWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// idempotentUpload holds a key for the length of an upload, so a retry
+// arriving while the first attempt is still running waits for its outcome.
+type idempotentUpload struct {
+	h         *Handler
+	key       string
+	principal string
+	path      string
+	record    *IdempotencyRecord
+	checksum  string
+	w         *idempotentWriter
+	unlock    func()
+}
+
+func (h *Handler) idempotencyRetention() time.Duration {
+	if r := h.config.Idempotency.Retention; r > 0 {
+		return r
+	}
+	return defaultIdempotencyRetention
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKey {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x21 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// beginIdempotent claims the request's Idempotency-Key, scoped to its
+// principal, and wraps w to capture the response. It returns nil without a
+// key or with idempotency disabled.
+func (h *Handler) beginIdempotent(w http.ResponseWriter, r *http.Request) (*idempotentUpload, http.ResponseWriter, bool) {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key == "" || h.config.Idempotency == nil {
+		return nil, w, true
+	}
+	if !validIdempotencyKey(key) {
+		writeError(w, ErrInvalidIdempotencyKey)
+		return nil, w, false
+	}
+
+	principal := h.principal(r)
+	sum := sha256.Sum256([]byte(principal + "\x00" + key))
+	id := hex.EncodeToString(sum[:])
+	u := &idempotentUpload{
+		h:         h,
+		key:       key,
+		principal: principal,
+		path:      filepath.Join(h.savePath, idempotencyDir, id+".json"),
+		w:         &idempotentWriter{ResponseWriter: w},
+		unlock:    h.idempotencyMu.Lock(id),
+	}
+
+	if data, err := os.ReadFile(u.path); err == nil {
+		rec := &IdempotencyRecord{}
+		if err = json.Unmarshal(data, rec); err == nil && time.Now().Before(rec.ExpiresAt) {
+			u.record = rec
+		}
+	}
+	return u, u.w, true
+}
+
+// replay answers a retry once the checksum of its body is known, and reports
+// whether it did: with the recorded response when the body is the one
+// recorded, or with an error when the key was used for another.
+func (u *idempotentUpload) replay(w http.ResponseWriter, checksum string) bool {
+	if u == nil {
+		return false
+	}
+	u.checksum = checksum
+	if u.record == nil {
+		return false
+	}
+	if u.record.Checksum != checksum {
+		writeError(w, withParams(ErrIdempotencyKeyReused, map[string]any{"key": u.key}))
+		return true
+	}
+
+	log.Printf("Replaying upload of %s for idempotency key %s\n", u.record.Name, u.key)
+	if u.record.ETag != "" {
+		w.Header().Set("ETag", u.record.ETag)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(u.record.Body)))
+	w.Header().Set(idempotencyReplayedHeader, "true")
+	w.WriteHeader(u.record.Status)
+	w.Write(u.record.Body)
+	return true
+}
+
+// finish records a successful response and releases the key. Failures are
+// not recorded, so they can be retried with the same key.
+func (u *idempotentUpload) finish() {
+	if u == nil {
+		return
+	}
+	defer u.unlock()
+
+	code := u.w.code
+	if u.record != nil || u.checksum == "" || code < http.StatusOK || code >= http.StatusMultipleChoices || u.w.overflow {
+		return
+	}
+
+	body := bytes.TrimSpace(u.w.body.Bytes())
+	res := utils.UploadResponse{}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return
+	}
+	now := time.Now().UTC()
+	rec := &IdempotencyRecord{
+		Key:       u.key,
+		Principal: u.principal,
+		Name:      res.Name,
+		Checksum:  u.checksum,
+		Status:    code,
+		ETag:      u.w.Header().Get("ETag"),
+		Body:      body,
+		CreatedAt: now,
+		ExpiresAt: now.Add(u.h.idempotencyRetention()),
+	}
+	data, err := json.Marshal(rec)
+	if err == nil {
+		err = writeFileAtomic(u.path, data)
+	}
+	if err != nil {
+		log.Printf("Error recording idempotency key %s: %s\n", u.key, err)
+	}
+}
+
+// pruneIdempotency removes the records past their retention, and those that
+// cannot be read.
+func (h *Handler) pruneIdempotency(now time.Time) int {
+	dir := filepath.Join(h.savePath, idempotencyDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+
+	pruned := 0
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+
+		unlock := h.idempotencyMu.Lock(id)
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		rec := &IdempotencyRecord{}
+		if err == nil && (json.Unmarshal(data, rec) != nil || now.After(rec.ExpiresAt)) {
+			if err = os.Remove(filepath.Join(dir, e.Name())); err == nil {
+				pruned++
+			}
+		}
+		unlock()
+	}
+	return pruned
+}
+
+func (h *Handler) runIdempotencyJanitor(ctx context.Context) {
+	ticker := time.NewTicker(max(h.idempotencyRetention()/4, minIdempotencyPruneInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if n := h.pruneIdempotency(now); n > 0 {
+				log.Printf("Pruned %d expired idempotency keys\n", n)
+			}
+		}
+	}
+}
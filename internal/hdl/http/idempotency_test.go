@@ -0,0 +1,156 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotency(t *testing.T) {
+	testDir := t.TempDir()
+
+	cfg := &config.HTTPConfig{
+		MaxUploadSize: 1024,
+		AdminToken:    "admin-secret",
+		Idempotency:   &config.IdempotencyConfig{Retention: time.Hour},
+	}
+	hdl := New(port, testDir, cfg)
+
+	upload := func(h *Handler, key, name, content string, fields map[string]string, admin bool) *httptest.ResponseRecorder {
+		req := newUploadRequest(name, content, fields)
+		if key != "" {
+			req.Header.Set(idempotencyKeyHeader, key)
+		}
+		if admin {
+			req.Header.Set("Authorization", "Bearer admin-secret")
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	decode := func(rec *httptest.ResponseRecorder) utils.UploadResponse {
+		res := utils.UploadResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res
+	}
+	assertCode := func(rec *httptest.ResponseRecorder, status int, code string) {
+		assert.Equal(t, status, rec.Code)
+		res := utils.ErrorResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		assert.Equal(t, code, res.Code)
+	}
+	count := func() int {
+		entries, _ := os.ReadDir(testDir)
+		n := 0
+		for _, e := range entries {
+			if !e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+				n++
+			}
+		}
+		return n
+	}
+	uuid := map[string]string{"naming": NamingUUID}
+
+	t.Run(
+		"Retries are replayed", func(t *testing.T) {
+			first := upload(hdl, "retry-1", "photo.jpg", "photo", uuid, false)
+			assert.Equal(t, http.StatusCreated, first.Code)
+			assert.Empty(t, first.Header().Get(idempotencyReplayedHeader))
+			etag := first.Header().Get("ETag")
+			res := decode(first)
+
+			for range 2 {
+				rec := upload(hdl, "retry-1", "photo.jpg", "photo", uuid, false)
+				assert.Equal(t, http.StatusCreated, rec.Code)
+				assert.Equal(t, "true", rec.Header().Get(idempotencyReplayedHeader))
+				assert.Equal(t, etag, rec.Header().Get("ETag"))
+				assert.Equal(t, res, decode(rec))
+			}
+			assert.Equal(t, 1, count())
+
+			// Without a key, the same upload is stored again.
+			assert.Equal(t, http.StatusCreated, upload(hdl, "", "photo.jpg", "photo", uuid, false).Code)
+			assert.Equal(t, 2, count())
+		},
+	)
+
+	t.Run(
+		"No spurious conflicts", func(t *testing.T) {
+			assert.Equal(t, http.StatusCreated, upload(hdl, "retry-2", "doc.txt", "doc", nil, false).Code)
+			rec := upload(hdl, "retry-2", "doc.txt", "doc", nil, false)
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, "doc.txt", decode(rec).Name)
+
+			assertCode(upload(hdl, "", "doc.txt", "doc", nil, false), http.StatusConflict, "file_exists")
+		},
+	)
+
+	t.Run(
+		"Reused keys", func(t *testing.T) {
+			rec := upload(hdl, "retry-1", "photo.jpg", "different", uuid, false)
+			assertCode(rec, http.StatusUnprocessableEntity, "idempotency_key_reused")
+			assert.Equal(t, 3, count())
+		},
+	)
+
+	t.Run(
+		"Keys are scoped per principal", func(t *testing.T) {
+			rec := upload(hdl, "retry-2", "doc.txt", "doc", nil, true)
+			assertCode(rec, http.StatusConflict, "file_exists")
+			assert.Empty(t, rec.Header().Get(idempotencyReplayedHeader))
+		},
+	)
+
+	t.Run(
+		"Failures are not recorded", func(t *testing.T) {
+			assertCode(upload(hdl, "retry-3", "doc.txt", "other", nil, false), http.StatusConflict, "file_exists")
+			rec := upload(hdl, "retry-3", "doc.txt", "other", map[string]string{"conflict": ConflictOverwrite}, false)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Empty(t, rec.Header().Get(idempotencyReplayedHeader))
+		},
+	)
+
+	t.Run(
+		"Invalid keys", func(t *testing.T) {
+			for _, key := range []string{strings.Repeat("k", maxIdempotencyKey+1), "with space", "ключ"} {
+				assertCode(upload(hdl, key, "bad.txt", "bad", nil, false), http.StatusBadRequest, "invalid_idempotency_key")
+			}
+			assert.NoFileExists(t, filepath.Join(testDir, "bad.txt"))
+		},
+	)
+
+	t.Run(
+		"Survives restarts", func(t *testing.T) {
+			restarted := New(port, testDir, cfg)
+			rec := upload(restarted, "retry-2", "doc.txt", "doc", nil, false)
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, "true", rec.Header().Get(idempotencyReplayedHeader))
+		},
+	)
+
+	t.Run(
+		"Pruned after retention", func(t *testing.T) {
+			assert.Zero(t, hdl.pruneIdempotency(time.Now()))
+			assert.Equal(t, 3, hdl.pruneIdempotency(time.Now().Add(2*time.Hour)))
+
+			rec := upload(hdl, "retry-1", "photo.jpg", "different", uuid, false)
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Empty(t, rec.Header().Get(idempotencyReplayedHeader))
+		},
+	)
+
+	t.Run(
+		"Disabled", func(t *testing.T) {
+			rec := upload(setupTestHandler(testDir), "retry-2", "doc.txt", "doc", nil, false)
+			assertCode(rec, http.StatusConflict, "file_exists")
+		},
+	)
+}
@@ -0,0 +1,53 @@
+package http
+
+import (
+	"errors"
+	"github.com/JMURv/media-server/internal/meta"
+	"log"
+	"net/http"
+)
+
+// overwriteID returns the ID a file replacing name should carry: the current
+// one, or empty so a new one is assigned when RotateIDOnOverwrite is set.
+func (h *Handler) overwriteID(name string) string {
+	if h.config.RotateIDOnOverwrite {
+		return ""
+	}
+	if m, err := h.meta.Get(name); err == nil {
+		return m.ID
+	}
+	return ""
+}
+
+func (h *Handler) resolveID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name, err := h.meta.Resolve(r.PathValue("id"))
+	if errors.Is(err, meta.ErrNotFound) {
+		writeError(w, ErrNotFound)
+		return "", false
+	}
+	if err != nil {
+		log.Printf("Error resolving id %s: %s\n", r.PathValue("id"), err)
+		writeError(w, ErrInternal)
+		return "", false
+	}
+	return name, h.checkInternal(w, r, name)
+}
+
+// fileByID redirects to the file currently holding the ID, so references
+// survive renames and moves.
+func (h *Handler) fileByID(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.resolveID(w, r)
+	if !ok {
+		return
+	}
+	http.Redirect(w, r, h.fileURL(r, name), http.StatusFound)
+}
+
+func (h *Handler) deleteByID(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.resolveID(w, r)
+	if !ok {
+		return
+	}
+	r.SetPathValue("name", name)
+	h.deleteFile(w, r)
+}
@@ -0,0 +1,87 @@
+package http
+
+import (
+	"bytes"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFileIDs(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := setupTestHandler(testDir)
+	rec := httptest.NewRecorder()
+	hdl.ServeHTTP(rec, newUploadRequest("report.pdf", "v1", nil))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	id := decodeUpload(t, rec).ID
+	assert.True(t, meta.ValidID(id), id)
+
+	byID := func(method, id string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(method, "/id/"+id, nil))
+		return rec
+	}
+
+	t.Run(
+		"IDs are unique and sortable", func(t *testing.T) {
+			a, b := meta.NewID(), meta.NewID()
+			assert.NotEqual(t, a, b)
+			assert.Len(t, a, 26)
+			assert.LessOrEqual(t, a[:10], b[:10])
+			assert.False(t, meta.ValidID("report.pdf"))
+		},
+	)
+
+	t.Run(
+		"Resolve by ID", func(t *testing.T) {
+			m, err := hdl.meta.Get("report.pdf")
+			assert.Nil(t, err)
+			assert.Equal(t, id, m.ID)
+
+			rec := byID(http.MethodGet, id)
+			assert.Equal(t, http.StatusFound, rec.Code)
+			assert.Equal(t, hdl.fileURL(httptest.NewRequest(http.MethodGet, "/", nil), "report.pdf"), rec.Header().Get("Location"))
+
+			assert.Equal(t, http.StatusNotFound, byID(http.MethodGet, meta.NewID()).Code)
+			assert.Equal(t, http.StatusNotFound, byID(http.MethodGet, "not-an-id").Code)
+		},
+	)
+
+	t.Run(
+		"Overwrites keep or rotate the ID", func(t *testing.T) {
+			hdl.config.AdminToken = "admin-secret"
+			defer func() { hdl.config.AdminToken = "" }()
+			req := httptest.NewRequest(http.MethodGet, "/admin/export", nil)
+			req.Header.Set("Authorization", "Bearer admin-secret")
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			archive := rec.Body.Bytes()
+
+			rec, res := importInto(hdl, bytes.NewReader(archive), "?conflict=overwrite")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, ImportOverwritten, res["report.pdf"].Status)
+			m, _ := hdl.meta.Get("report.pdf")
+			assert.Equal(t, id, m.ID)
+
+			hdl.config.RotateIDOnOverwrite = true
+			defer func() { hdl.config.RotateIDOnOverwrite = false }()
+			importInto(hdl, bytes.NewReader(archive), "?conflict=overwrite")
+			m, _ = hdl.meta.Get("report.pdf")
+			assert.NotEqual(t, id, m.ID)
+			assert.Equal(t, http.StatusNotFound, byID(http.MethodGet, id).Code)
+			assert.Equal(t, http.StatusFound, byID(http.MethodGet, m.ID).Code)
+			id = m.ID
+		},
+	)
+
+	t.Run(
+		"Delete by ID", func(t *testing.T) {
+			assert.Equal(t, http.StatusNoContent, byID(http.MethodDelete, id).Code)
+			assert.Equal(t, http.StatusNotFound, byID(http.MethodGet, id).Code)
+			assert.Equal(t, http.StatusNotFound, byID(http.MethodDelete, id).Code)
+		},
+	)
+}
@@ -0,0 +1,313 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/internal/imaging"
+	"github.com/JMURv/media-server/internal/jobs"
+	"github.com/JMURv/media-server/internal/meta"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const TaskWarm = "warm"
+
+// staleWarning marks an image rendered from an earlier version of its
+// source, served while the current version is rendered.
+const staleWarning = `110 - "Response is Stale"`
+
+// applyTransform renders image transforms; tests swap it to count renders.
+var applyTransform = imaging.Apply
+
+func (h *Handler) initImages() error {
+	cfg := h.config.Images
+	if cfg.RequireSignature && h.config.SigningSecret == "" {
+		return ErrSigningSecretRequired
+	}
+
+	h.presets = make(map[string]imaging.Transform, len(cfg.Presets))
+	for name, spec := range cfg.Presets {
+		t, err := imaging.ParseSpec(spec)
+		if err != nil {
+			return fmt.Errorf("preset %q: %w", name, err)
+		}
+		h.presets[name] = t
+	}
+	for _, name := range cfg.WarmPresets {
+		if _, ok := h.presets[name]; !ok {
+			return fmt.Errorf("warm preset %q: %w", name, ErrPresetNotFound)
+		}
+	}
+	if len(cfg.WarmPresets) > 0 && h.jobs == nil {
+		return ErrWarmupNeedsJobs
+	}
+
+	h.imageCache = imaging.NewCache(cfg.CacheSize)
+	h.imageStale = h.metrics.Counter("image_stale_served_total", "Transformed images served stale while rendered again.")
+	return nil
+}
+
+func (h *Handler) image(w http.ResponseWriter, r *http.Request) {
+	if h.imageCache == nil {
+		writeError(w, ErrImagesDisabled)
+		return
+	}
+	cfg := h.config.Images
+
+	name, err := h.pathName(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !h.checkAvailable(w, r, name) || !h.checkReady(w, name) {
+		return
+	}
+
+	if cfg.RequireSignature && !h.validSignature(r) {
+		writeError(w, ErrInvalidSignature)
+		return
+	}
+
+	var t imaging.Transform
+	if preset := r.PathValue("preset"); preset != "" {
+		var ok bool
+		if t, ok = h.presets[preset]; !ok {
+			writeError(w, ErrPresetNotFound)
+			return
+		}
+	} else {
+		if cfg.PresetsOnly {
+			writeError(w, ErrPresetRequired)
+			return
+		}
+
+		if t, err = imaging.ParseQuery(r.URL.Query()); err != nil {
+			writeError(w, err)
+			return
+		}
+		if !t.Within(cfg.MaxDimension) {
+			writeError(w, imaging.ErrInvalidTransform)
+			return
+		}
+	}
+
+	// Requests that name a format have a stable URL per variant and are
+	// cacheable without Vary; the rest depend on what the client accepts.
+	if t.Format == "" && cfg.Negotiate {
+		w.Header().Add("Vary", "Accept")
+		t.Format = imaging.Negotiate(r.Header.Get("Accept"))
+	}
+
+	file, err := h.openFile(r.Context(), name)
+	if err != nil {
+		writeError(w, ErrRetrievingFile)
+		return
+	}
+	defer file.Close()
+	if bodyLength(file) == 0 {
+		errorResponse(w, http.StatusUnsupportedMediaType, ErrEmptyFile)
+		return
+	}
+
+	key := imageKey(name, t)
+	source, modTime := h.validators(name, file)
+	if etag := imageETag(key, source); etagListed(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	v, stale, err := h.transformImage(r.Context(), name, key, source, t, h.servesStale(modTime))
+	if errors.Is(err, imaging.ErrUnsupportedImage) {
+		writeError(w, ErrUnsupportedMediaType)
+		return
+	}
+	if errors.Is(err, imaging.ErrImageTooLarge) || errors.Is(err, ErrCoalesceTimeout) {
+		writeError(w, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Error transforming %s: %s\n", name, err)
+		writeError(w, canceledOr(err, ErrInternal))
+		return
+	}
+
+	if stale {
+		w.Header().Set("Warning", staleWarning)
+	}
+	w.Header().Set("Content-Type", v.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(v.Data)))
+	w.Header().Set("ETag", imageETag(key, v.Source))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	w.Write(v.Data)
+}
+
+func (h *Handler) imageLimits() imaging.Limits {
+	return imaging.Limits{MaxDimension: h.config.MaxImageDimension, MaxPixels: h.config.MaxImagePixels}
+}
+
+// probeImage reads the header of the upload at path and, if it is an image,
+// rejects it when the declared dimensions exceed the limits. Accepted images
+// have their dimensions recorded in stored.
+func (h *Handler) probeImage(w http.ResponseWriter, path string, stored *meta.File) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Error opening upload %s: %s\n", path, err)
+		writeError(w, ErrInternal)
+		return false
+	}
+	defer file.Close()
+
+	cfg, _, err := imaging.Probe(file, h.imageLimits())
+	if errors.Is(err, imaging.ErrImageTooLarge) {
+		writeError(w, err)
+		return false
+	}
+	if err == nil {
+		stored.Width, stored.Height = cfg.Width, cfg.Height
+	}
+	return true
+}
+
+// imageKey identifies the rendition of name by t: the parameters and the
+// output format. The version of the source it was rendered from is kept
+// with it in the cache.
+func imageKey(name string, t imaging.Transform) string {
+	return name + "\x00" + t.Key()
+}
+
+// imageETag is the ETag of the rendition under key of the source version
+// source.
+func imageETag(key, source string) string {
+	return fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(key+"\x00"+source)))
+}
+
+// servesStale reports whether a rendition of an earlier version of a source
+// modified at modTime may still be served while the current one is
+// rendered.
+func (h *Handler) servesStale(modTime time.Time) bool {
+	maxStale := h.config.Images.MaxStale
+	return maxStale > 0 && !modTime.IsZero() && time.Since(modTime) < maxStale
+}
+
+// transformImage returns name transformed by t, taking it from the cache
+// when the rendition under key there is of the source version source.
+// Identical concurrent requests share one transform. With stale, a
+// rendition of an earlier version is returned at once, reported as stale,
+// and the current one rendered in the background.
+func (h *Handler) transformImage(ctx context.Context, name, key, source string, t imaging.Transform, stale bool) (*imaging.Variant, bool, error) {
+	cached, ok := h.imageCache.Get(key)
+	if ok && cached.Source == source {
+		return cached, false, nil
+	}
+
+	render := func(ctx context.Context) (any, error) {
+		if v, ok := h.imageCache.Get(key); ok && v.Source == source {
+			return v, nil
+		}
+
+		file, err := h.openFile(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		// The source may have changed since source was taken; the version
+		// read is the one the rendition is of.
+		v := &imaging.Variant{}
+		v.Source, _ = h.validators(name, file)
+		buf := &bytes.Buffer{}
+		if v.ContentType, err = applyTransform(buf, file, t, h.imageLimits()); err != nil {
+			return nil, err
+		}
+		v.Data = buf.Bytes()
+		h.imageCache.Put(key, v)
+		return v, nil
+	}
+
+	flight := "image\x00" + key + "\x00" + source
+	if ok && stale {
+		h.flights.Start(flight, render)
+		h.imageStale.Inc()
+		return cached, true, nil
+	}
+	v, err := h.flights.Do(ctx, flight, render)
+	if err != nil {
+		return nil, false, err
+	}
+	return v.(*imaging.Variant), false, nil
+}
+
+// renderImage renders name by t into the cache.
+func (h *Handler) renderImage(ctx context.Context, name string, t imaging.Transform) error {
+	file, err := h.openFile(ctx, name)
+	if err != nil {
+		return ErrRetrievingFile
+	}
+	defer file.Close()
+
+	source, _ := h.validators(name, file)
+	_, _, err = h.transformImage(ctx, name, imageKey(name, t), source, t, false)
+	return err
+}
+
+// warmImages queues rendering of the warm presets for a freshly uploaded
+// image so the first visitor does not pay for the resize. A warmup still
+// waiting in the queue already covers the new upload, so none is added.
+func (h *Handler) warmImages(name, contentType string) {
+	if h.imageCache == nil || len(h.config.Images.WarmPresets) == 0 {
+		return
+	}
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(name))
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return
+	}
+
+	h.warmMu.Lock()
+	defer h.warmMu.Unlock()
+
+	for _, job := range h.jobs.List(name) {
+		if job.State == jobs.StatePending && slices.Equal(job.Tasks, []string{TaskWarm}) {
+			return
+		}
+	}
+	if _, err := h.jobs.Enqueue(name, []string{TaskWarm}); err != nil {
+		log.Printf("Error enqueueing warmup for %s: %s\n", name, err)
+	}
+}
+
+func (h *Handler) warmTask(ctx context.Context, name string) error {
+	var errs []error
+	for _, preset := range h.config.Images.WarmPresets {
+		// Negotiating presets are warmed in both the source format and WebP.
+		variants := []imaging.Transform{h.presets[preset]}
+		if t := variants[0]; t.Format == "" && h.config.Images.Negotiate {
+			t.Format = imaging.FormatWebP
+			variants = append(variants, t)
+		}
+
+		for _, t := range variants {
+			err := h.renderImage(ctx, name, t)
+			if errors.Is(err, imaging.ErrUnsupportedImage) || errors.Is(err, imaging.ErrImageTooLarge) {
+				return nil
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("preset %s: %w", preset, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,514 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/JMURv/media-server/internal/imaging"
+	"github.com/JMURv/media-server/internal/jobs"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	_ "golang.org/x/image/webp"
+)
+
+func TestImages(t *testing.T) {
+	testDir := t.TempDir()
+
+	src := image.NewNRGBA(image.Rect(0, 0, 400, 200))
+	for x := range 400 {
+		for y := range 200 {
+			src.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	buf := &bytes.Buffer{}
+	assert.Nil(t, png.Encode(buf, src))
+	assert.Nil(t, os.WriteFile(filepath.Join(testDir, "photo.png"), buf.Bytes(), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(testDir, "notes.txt"), []byte("text"), 0644))
+
+	cfg := &config.HTTPConfig{
+		SigningSecret: "secret",
+		Images: &config.ImagesConfig{
+			Presets: map[string]string{
+				"thumb": "150x150 cover webp",
+				"hero":  "100w",
+			},
+			MaxDimension: 1000,
+			CacheSize:    64 << 10,
+		},
+	}
+	hdl := New(port, testDir, cfg)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	decode := func(rec *httptest.ResponseRecorder) image.Config {
+		c, _, err := image.DecodeConfig(rec.Body)
+		assert.Nil(t, err)
+		return c
+	}
+
+	t.Run(
+		"Presets", func(t *testing.T) {
+			rec := get("/img/thumb/photo.png")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "image/webp", rec.Header().Get("Content-Type"))
+			c := decode(rec)
+			assert.Equal(t, 150, c.Width)
+			assert.Equal(t, 150, c.Height)
+
+			rec = get("/img/hero/photo.png")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+			c = decode(rec)
+			assert.Equal(t, 100, c.Width)
+			assert.Equal(t, 50, c.Height)
+
+			assert.Equal(t, http.StatusNotFound, get("/img/huge/photo.png").Code)
+			assert.Equal(t, http.StatusUnsupportedMediaType, get("/img/hero/notes.txt").Code)
+		},
+	)
+
+	t.Run(
+		"Arbitrary parameters", func(t *testing.T) {
+			rec := get("/img/photo.png?w=80&h=80&format=jpg")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "image/jpeg", rec.Header().Get("Content-Type"))
+			c := decode(rec)
+			assert.Equal(t, 80, c.Width)
+			assert.Equal(t, 40, c.Height)
+
+			assert.Equal(t, http.StatusBadRequest, get("/img/photo.png?w=5000").Code)
+			assert.Equal(t, http.StatusBadRequest, get("/img/photo.png?fit=cover&w=10").Code)
+			assert.Equal(t, http.StatusBadRequest, get("/img/photo.png").Code)
+		},
+	)
+
+	t.Run(
+		"Presets only", func(t *testing.T) {
+			cfg.Images.PresetsOnly = true
+			defer func() { cfg.Images.PresetsOnly = false }()
+
+			assert.Equal(t, http.StatusForbidden, get("/img/photo.png?w=81").Code)
+			assert.Equal(t, http.StatusOK, get("/img/hero/photo.png").Code)
+		},
+	)
+
+	t.Run(
+		"Signatures", func(t *testing.T) {
+			cfg.Images.RequireSignature = true
+			defer func() { cfg.Images.RequireSignature = false }()
+
+			assert.Equal(t, http.StatusForbidden, get("/img/thumb/photo.png").Code)
+			assert.Equal(t, http.StatusForbidden, get("/img/photo.png?w=82").Code)
+
+			assert.Equal(t, http.StatusOK, get(hdl.SignURL("/img/thumb/photo.png", nil)).Code)
+			signed := hdl.SignURL("/img/photo.png", url.Values{"w": {"82"}})
+			assert.Equal(t, http.StatusOK, get(signed).Code)
+
+			tampered, _ := url.Parse(signed)
+			q := tampered.Query()
+			q.Set("w", "83")
+			tampered.RawQuery = q.Encode()
+			assert.Equal(t, http.StatusForbidden, get(tampered.String()).Code)
+			assert.Equal(t, http.StatusForbidden, get("/img/thumb/notes.txt?"+tampered.RawQuery).Code)
+		},
+	)
+
+	t.Run(
+		"Signature requires secret", func(t *testing.T) {
+			assert.Panics(
+				t, func() {
+					New(port, testDir, &config.HTTPConfig{Images: &config.ImagesConfig{RequireSignature: true}})
+				},
+			)
+			assert.Panics(
+				t, func() {
+					New(port, testDir, &config.HTTPConfig{Images: &config.ImagesConfig{Presets: map[string]string{"x": "big"}}})
+				},
+			)
+		},
+	)
+
+	t.Run(
+		"Cache is keyed by transformation and bounded", func(t *testing.T) {
+			get("/img/thumb/photo.png")
+			before := hdl.imageCache.Len()
+			rec := get("/img/photo.png?w=150&h=150&fit=cover&format=webp")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, before, hdl.imageCache.Len())
+
+			for w := 300; w <= 1000; w += 50 {
+				rec := get("/img/photo.png?format=png&w=" + strconv.Itoa(w))
+				assert.Equal(t, http.StatusOK, rec.Code)
+				assert.LessOrEqual(t, hdl.imageCache.Size(), cfg.Images.CacheSize)
+			}
+			assert.Less(t, hdl.imageCache.Len(), before+15, "older entries must be evicted")
+		},
+	)
+
+	t.Run(
+		"Disabled", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			setupTestHandler(testDir).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/img/hero/photo.png", nil))
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		},
+	)
+}
+
+func TestWarmPresets(t *testing.T) {
+	testDir := t.TempDir()
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, png.Encode(buf, image.NewNRGBA(image.Rect(0, 0, 300, 200))))
+
+	images := func(warm ...string) *config.ImagesConfig {
+		return &config.ImagesConfig{
+			Presets:     map[string]string{"thumb": "150x150 cover webp", "hero": "100w"},
+			WarmPresets: warm,
+			CacheSize:   64 << 10,
+		}
+	}
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize: 1024 * 1024,
+			Images:        images("thumb", "hero"),
+			Jobs:          &config.JobsConfig{Workers: 1, Tasks: []string{TaskVerify}},
+		},
+	)
+	warmJobs := func(name string) []*jobs.Job {
+		var res []*jobs.Job
+		for _, job := range hdl.jobs.List(name) {
+			if slices.Equal(job.Tasks, []string{TaskWarm}) {
+				res = append(res, job)
+			}
+		}
+		return res
+	}
+	upload := func(name, content string) {
+		hdl.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/files/"+name, nil))
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, newUploadRequest(name, content, nil))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	}
+
+	t.Run(
+		"Invalid config", func(t *testing.T) {
+			assert.Panics(t, func() { New(port, testDir, &config.HTTPConfig{Images: images("thumb")}) })
+			assert.Panics(
+				t, func() {
+					New(port, testDir, &config.HTTPConfig{Images: images("huge"), Jobs: &config.JobsConfig{}})
+				},
+			)
+		},
+	)
+
+	t.Run(
+		"Only images are warmed, once per pending upload", func(t *testing.T) {
+			upload("photo.png", buf.String())
+			upload("photo.png", buf.String())
+			upload("notes.txt", "text")
+			upload("broken.png", "not a png")
+
+			assert.Len(t, warmJobs("photo.png"), 1)
+			assert.Len(t, warmJobs("broken.png"), 1)
+			assert.Empty(t, warmJobs("notes.txt"))
+		},
+	)
+
+	t.Run(
+		"Renditions are cached before the first request", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go hdl.jobs.Run(ctx)
+
+			assert.Eventually(
+				t, func() bool {
+					for _, job := range append(warmJobs("photo.png"), warmJobs("broken.png")...) {
+						if job.State != jobs.StateDone {
+							return false
+						}
+					}
+					return true
+				}, 3*time.Second, 10*time.Millisecond,
+			)
+			assert.Equal(t, 2, hdl.imageCache.Len())
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/img/thumb/photo.png", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, 2, hdl.imageCache.Len())
+		},
+	)
+}
+
+func TestStaleImages(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize: 1024 * 1024,
+			Images: &config.ImagesConfig{
+				Presets:  map[string]string{"hero": "100w"},
+				MaxStale: time.Hour,
+			},
+		},
+	)
+	upload := func(width int) {
+		buf := &bytes.Buffer{}
+		assert.Nil(t, png.Encode(buf, image.NewNRGBA(image.Rect(0, 0, width, 100))))
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, newUploadRequest("photo.png", buf.String(), map[string]string{"conflict": "overwrite"}))
+		assert.Less(t, rec.Code, 300, rec.Body.String())
+	}
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/img/hero/photo.png", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		return rec
+	}
+	height := func(rec *httptest.ResponseRecorder) int {
+		c, _, err := image.DecodeConfig(bytes.NewReader(rec.Body.Bytes()))
+		assert.Nil(t, err)
+		return c.Height
+	}
+
+	upload(200)
+	first := get()
+	assert.Equal(t, 50, height(first))
+	assert.Empty(t, first.Header().Get("Warning"))
+
+	t.Run(
+		"Previous rendition is served while the new one renders", func(t *testing.T) {
+			upload(400)
+			rec := get()
+			assert.Equal(t, staleWarning, rec.Header().Get("Warning"))
+			assert.Equal(t, first.Header().Get("ETag"), rec.Header().Get("ETag"))
+			assert.Equal(t, 50, height(rec))
+			assert.Equal(t, uint64(1), hdl.imageStale.Value())
+
+			assert.Eventually(
+				t, func() bool {
+					rec := get()
+					return rec.Header().Get("Warning") == "" && height(rec) == 25
+				}, 3*time.Second, 10*time.Millisecond,
+			)
+			assert.NotEqual(t, first.Header().Get("ETag"), get().Header().Get("ETag"))
+		},
+	)
+
+	t.Run(
+		"Renditions staler than the limit are not served", func(t *testing.T) {
+			upload(100)
+			old := time.Now().Add(-2 * time.Hour)
+			assert.Nil(t, os.Chtimes(filepath.Join(testDir, "photo.png"), old, old))
+
+			before := hdl.imageStale.Value()
+			rec := get()
+			assert.Empty(t, rec.Header().Get("Warning"))
+			assert.Equal(t, 100, height(rec))
+			assert.Equal(t, before, hdl.imageStale.Value())
+		},
+	)
+}
+
+// pngHeader returns just the signature and IHDR chunk of an RGBA PNG, which
+// is all a header check reads, declaring whatever dimensions are asked for.
+func pngHeader(width, height uint32) []byte {
+	ihdr := binary.BigEndian.AppendUint32([]byte("IHDR"), width)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, height)
+	ihdr = append(ihdr, 8, 6, 0, 0, 0)
+
+	res := []byte("\x89PNG\r\n\x1a\n")
+	res = binary.BigEndian.AppendUint32(res, uint32(len(ihdr)-4))
+	res = append(res, ihdr...)
+	return binary.BigEndian.AppendUint32(res, crc32.ChecksumIEEE(ihdr))
+}
+
+func TestImageLimits(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize:     1024 * 1024,
+			MaxImageDimension: 150,
+			MaxImagePixels:    10_000,
+			Images:            &config.ImagesConfig{Presets: map[string]string{"thumb": "50x50 cover"}},
+		},
+	)
+	upload := func(name string, content []byte) int {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, newUploadRequest(name, string(content), nil))
+		return rec.Code
+	}
+	encode := func(w, h int) []byte {
+		buf := &bytes.Buffer{}
+		assert.Nil(t, png.Encode(buf, image.NewNRGBA(image.Rect(0, 0, w, h))))
+		return buf.Bytes()
+	}
+
+	t.Run(
+		"Decompression bombs are rejected at upload", func(t *testing.T) {
+			assert.Equal(t, http.StatusUnprocessableEntity, upload("bomb.png", pngHeader(60000, 60000)))
+			assert.NoFileExists(t, filepath.Join(testDir, "bomb.png"))
+
+			assert.Equal(t, http.StatusUnprocessableEntity, upload("wide.png", encode(200, 10)))
+			assert.Equal(t, http.StatusUnprocessableEntity, upload("square.png", encode(120, 120)))
+		},
+	)
+
+	t.Run(
+		"Accepted images record their dimensions", func(t *testing.T) {
+			assert.Equal(t, http.StatusCreated, upload("small.png", encode(100, 50)))
+			m, err := hdl.meta.Get("small.png")
+			assert.Nil(t, err)
+			assert.Equal(t, 100, m.Width)
+			assert.Equal(t, 50, m.Height)
+
+			assert.Equal(t, http.StatusCreated, upload("notes.txt", []byte("not an image")))
+			m, err = hdl.meta.Get("notes.txt")
+			assert.Nil(t, err)
+			assert.Zero(t, m.Width)
+		},
+	)
+
+	t.Run(
+		"Transformations check limits before decoding", func(t *testing.T) {
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, "placed.png"), pngHeader(60000, 60000), 0644))
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/img/thumb/placed.png", nil))
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/img/thumb/small.png", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+		},
+	)
+}
+
+func TestImageNegotiation(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize: 1024 * 1024,
+			Images: &config.ImagesConfig{
+				Negotiate: true,
+				Presets:   map[string]string{"thumb": "50x50 cover", "thumbpng": "50x50 cover png"},
+			},
+		},
+	)
+	buf := &bytes.Buffer{}
+	assert.Nil(t, png.Encode(buf, image.NewNRGBA(image.Rect(0, 0, 100, 100))))
+	rec := httptest.NewRecorder()
+	hdl.ServeHTTP(rec, newUploadRequest("cat.png", buf.String(), nil))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	const (
+		avifClient  = "image/avif,image/webp,image/apng,*/*;q=0.8"
+		plainClient = "image/png,image/*;q=0.8,*/*;q=0.5"
+	)
+	get := func(path, accept, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept", accept)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run(
+		"Variants per Accept", func(t *testing.T) {
+			modern := get("/img/thumb/cat.png", avifClient, "")
+			assert.Equal(t, http.StatusOK, modern.Code)
+			assert.Equal(t, "image/webp", modern.Header().Get("Content-Type"))
+			assert.Equal(t, "Accept", modern.Header().Get("Vary"))
+
+			plain := get("/img/thumb/cat.png", plainClient, "")
+			assert.Equal(t, http.StatusOK, plain.Code)
+			assert.Equal(t, "image/png", plain.Header().Get("Content-Type"))
+			assert.Equal(t, "Accept", plain.Header().Get("Vary"))
+
+			assert.NotEmpty(t, modern.Header().Get("ETag"))
+			assert.NotEqual(t, modern.Header().Get("ETag"), plain.Header().Get("ETag"))
+		},
+	)
+
+	t.Run(
+		"Not modified per variant", func(t *testing.T) {
+			modern := get("/img/thumb/cat.png", avifClient, "").Header().Get("ETag")
+			plain := get("/img/thumb/cat.png", plainClient, "").Header().Get("ETag")
+
+			rec := get("/img/thumb/cat.png", avifClient, modern)
+			assert.Equal(t, http.StatusNotModified, rec.Code)
+			assert.Empty(t, rec.Body.Bytes())
+			assert.Equal(t, modern, rec.Header().Get("ETag"))
+			assert.Equal(t, "Accept", rec.Header().Get("Vary"))
+
+			assert.Equal(t, http.StatusNotModified, get("/img/thumb/cat.png", plainClient, "W/"+plain).Code)
+			assert.Equal(t, http.StatusNotModified, get("/img/thumb/cat.png", plainClient, `"x", `+plain).Code)
+
+			rec = get("/img/thumb/cat.png", plainClient, modern)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+		},
+	)
+
+	t.Run(
+		"Explicit formats do not vary", func(t *testing.T) {
+			modern := get("/img/thumbpng/cat.png", avifClient, "")
+			plain := get("/img/thumbpng/cat.png", plainClient, "")
+			assert.Equal(t, "image/png", modern.Header().Get("Content-Type"))
+			assert.Empty(t, modern.Header().Get("Vary"))
+			assert.Equal(t, modern.Header().Get("ETag"), plain.Header().Get("ETag"))
+
+			rec := get("/img/cat.png?w=20&format=jpeg", avifClient, "")
+			assert.Equal(t, "image/jpeg", rec.Header().Get("Content-Type"))
+			assert.Empty(t, rec.Header().Get("Vary"))
+		},
+	)
+
+	t.Run(
+		"New versions change the ETag", func(t *testing.T) {
+			before := get("/img/thumb/cat.png", avifClient, "").Header().Get("ETag")
+
+			buf := &bytes.Buffer{}
+			assert.Nil(t, png.Encode(buf, image.NewNRGBA(image.Rect(0, 0, 80, 80))))
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, "cat.png"), buf.Bytes(), 0644))
+			assert.Nil(t, os.Chtimes(filepath.Join(testDir, "cat.png"), time.Now().Add(time.Hour), time.Now().Add(time.Hour)))
+
+			rec := get("/img/thumb/cat.png", avifClient, before)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.NotEqual(t, before, rec.Header().Get("ETag"))
+		},
+	)
+
+	t.Run(
+		"Negotiate", func(t *testing.T) {
+			assert.Equal(t, imaging.FormatWebP, imaging.Negotiate("image/webp"))
+			assert.Equal(t, imaging.FormatWebP, imaging.Negotiate("text/html, image/webp;q=0.9"))
+			assert.Equal(t, "", imaging.Negotiate("image/webp;q=0"))
+			assert.Equal(t, "", imaging.Negotiate("*/*"))
+			assert.Equal(t, "", imaging.Negotiate("image/avif, image/*"))
+			assert.Equal(t, "", imaging.Negotiate(""))
+		},
+	)
+}
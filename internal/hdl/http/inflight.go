@@ -0,0 +1,224 @@
+package http
+
+import (
+	"cmp"
+	"context"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const statusClientClosedRequest = 499
+
+type InFlightRequest struct {
+	ID        string    `json:"id"`
+	Route     string    `json:"route"`
+	Name      string    `json:"name,omitempty"`
+	Bytes     int64     `json:"bytes"`
+	StartedAt time.Time `json:"started_at"`
+	Client    string    `json:"client"`
+}
+
+type inflightEntry struct {
+	id     string
+	route  string
+	name   string
+	client string
+	start  time.Time
+	bytes  atomic.Int64
+
+	cancelled atomic.Bool
+	cancel    context.CancelCauseFunc
+	rc        *http.ResponseController
+}
+
+type requestRegistry struct {
+	seq     atomic.Uint64
+	entries sync.Map
+}
+
+func (reg *requestRegistry) list() []InFlightRequest {
+	res := make([]InFlightRequest, 0)
+	reg.entries.Range(
+		func(_, v any) bool {
+			e := v.(*inflightEntry)
+			res = append(
+				res, InFlightRequest{
+					ID:        e.id,
+					Route:     e.route,
+					Name:      e.name,
+					Bytes:     e.bytes.Load(),
+					StartedAt: e.start,
+					Client:    e.client,
+				},
+			)
+			return true
+		},
+	)
+	slices.SortFunc(res, func(a, b InFlightRequest) int { return a.StartedAt.Compare(b.StartedAt) })
+	return res
+}
+
+// cancel aborts the request's context and expires its connection deadlines,
+// so handlers blocked on the body or on a slow client return promptly.
+func (reg *requestRegistry) cancel(id string) bool {
+	v, ok := reg.entries.Load(id)
+	if !ok {
+		return false
+	}
+
+	e := v.(*inflightEntry)
+	e.cancelled.Store(true)
+	e.cancel(ErrRequestCancelled)
+	now := time.Now()
+	e.rc.SetReadDeadline(now)
+	e.rc.SetWriteDeadline(now)
+	return true
+}
+
+type trackedBody struct {
+	io.ReadCloser
+	entry *inflightEntry
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	if b.entry.cancelled.Load() {
+		return 0, ErrRequestCancelled
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.entry.bytes.Add(int64(n))
+	return n, err
+}
+
+type trackedWriter struct {
+	http.ResponseWriter
+	entry *inflightEntry
+	wrote bool
+}
+
+func (w *trackedWriter) WriteHeader(code int) {
+	if w.entry.cancelled.Load() {
+		return
+	}
+	w.wrote = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *trackedWriter) Write(p []byte) (int, error) {
+	if w.entry.cancelled.Load() {
+		return 0, ErrRequestCancelled
+	}
+	w.wrote = true
+	n, err := w.ResponseWriter.Write(p)
+	w.entry.bytes.Add(int64(n))
+	return n, err
+}
+
+func (w *trackedWriter) ReadFrom(src io.Reader) (int64, error) {
+	if w.entry.cancelled.Load() {
+		return 0, ErrRequestCancelled
+	}
+	w.wrote = true
+	n, err := io.Copy(w.ResponseWriter, src)
+	w.entry.bytes.Add(n)
+	return n, err
+}
+
+func (w *trackedWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.entry.cancelled.Load() {
+		f.Flush()
+	}
+}
+
+func (w *trackedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (h *Handler) track(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithCancelCause(r.Context())
+			defer cancel(nil)
+
+			e := &inflightEntry{
+				id:     strconv.FormatUint(h.requests.seq.Add(1), 10),
+				route:  route,
+				name:   cmp.Or(r.PathValue("name"), r.URL.Query().Get("filename")),
+				client: r.RemoteAddr,
+				start:  time.Now(),
+				cancel: cancel,
+				rc:     http.NewResponseController(w),
+			}
+			h.requests.entries.Store(e.id, e)
+			defer h.requests.entries.Delete(e.id)
+
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = &trackedBody{ReadCloser: r.Body, entry: e}
+			}
+			tw := &trackedWriter{ResponseWriter: w, entry: e}
+			next.ServeHTTP(tw, r.WithContext(ctx))
+
+			if !e.cancelled.Load() {
+				return
+			}
+			log.Printf("Request %s (%s) cancelled after %d bytes\n", e.id, route, e.bytes.Load())
+			if tw.wrote {
+				panic(http.ErrAbortHandler)
+			}
+			e.rc.SetWriteDeadline(time.Time{})
+			w.Header().Set("Connection", "close")
+			errorResponse(w, statusClientClosedRequest, ErrRequestCancelled)
+		},
+	)
+}
+
+type trackedMux struct {
+	*http.ServeMux
+	h *Handler
+}
+
+func (m trackedMux) Handle(pattern string, handler http.Handler) {
+	m.ServeMux.Handle(pattern, m.h.localize(m.h.authenticate(pattern, m.h.meter(m.h.authorize(pattern, m.h.shed(pattern, m.h.track(pattern, m.h.limitBody(pattern, handler))))))))
+}
+
+// HandleUntracked registers routes that are neither tracked nor shed, such
+// as the ones managing tracked requests, and whose writer is left unwrapped
+// for websockets to hijack. They are still authorized.
+func (m trackedMux) HandleUntracked(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.ServeMux.Handle(pattern, m.h.authenticate(pattern, m.h.authorize(pattern, http.HandlerFunc(handler))))
+}
+
+func (m trackedMux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.Handle(pattern, http.HandlerFunc(handler))
+}
+
+func (h *Handler) listRequests(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, h.requests.list())
+}
+
+func (h *Handler) cancelRequest(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+
+	id := r.PathValue("id")
+	if !h.requests.cancel(id) {
+		writeError(w, ErrRequestNotFound)
+		return
+	}
+
+	h.emit("admin.requests.cancel", "", r, map[string]any{"id": id})
+	utils.SuccessResponse(w, http.StatusNoContent, "OK")
+}
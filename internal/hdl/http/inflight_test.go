@@ -0,0 +1,166 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInFlightRequests(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize:   10 * 1024 * 1024,
+			MaxStreamBuffer: 1024,
+			DefaultPage:     1,
+			DefaultSize:     10,
+			AdminToken:      "admin-secret",
+		},
+	)
+	srv := httptest.NewServer(hdl)
+	defer srv.Close()
+
+	admin := func(method, path, token string) *http.Response {
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		return res
+	}
+	requests := func() []InFlightRequest {
+		res := admin(http.MethodGet, "/admin/requests", "admin-secret")
+		defer res.Body.Close()
+
+		var list []InFlightRequest
+		assert.Nil(t, json.NewDecoder(res.Body).Decode(&list))
+		return list
+	}
+	find := func(route string, minBytes int64) *InFlightRequest {
+		var found *InFlightRequest
+		assert.Eventually(
+			t, func() bool {
+				for _, req := range requests() {
+					if req.Route == route && req.Bytes >= minBytes {
+						found = &req
+						return true
+					}
+				}
+				return false
+			}, 2*time.Second, 10*time.Millisecond,
+		)
+		return found
+	}
+	cancel := func(id string) int {
+		res := admin(http.MethodDelete, "/admin/requests/"+id, "admin-secret")
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	t.Run(
+		"Admin only", func(t *testing.T) {
+			for _, token := range []string{"", "wrong"} {
+				res := admin(http.MethodGet, "/admin/requests", token)
+				res.Body.Close()
+				assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+				res = admin(http.MethodDelete, "/admin/requests/any", token)
+				res.Body.Close()
+				assert.Equal(t, http.StatusForbidden, res.StatusCode)
+			}
+		},
+	)
+
+	t.Run(
+		"Removed on completion", func(t *testing.T) {
+			res, err := http.Get(srv.URL + "/list")
+			assert.Nil(t, err)
+			res.Body.Close()
+			assert.Empty(t, requests())
+			assert.Equal(t, http.StatusNotFound, cancel("missing"))
+		},
+	)
+
+	t.Run(
+		"Cancel upload", func(t *testing.T) {
+			body, contentType := multipartBody("runaway.bin", 64*1024)
+			pr, pw := io.Pipe()
+			defer pw.Close()
+			go pw.Write(body.Bytes()[:8*1024])
+
+			req, _ := http.NewRequest(http.MethodPost, srv.URL+"/upload", pr)
+			req.Header.Set("Content-Type", contentType)
+			req.ContentLength = int64(body.Len())
+
+			done := make(chan *http.Response, 1)
+			go func() {
+				res, err := http.DefaultClient.Do(req)
+				if err != nil {
+					res = nil
+				}
+				done <- res
+			}()
+
+			inflight := find("POST /upload", 1)
+			if !assert.NotNil(t, inflight) {
+				return
+			}
+			assert.Equal(t, "POST /upload", inflight.Route)
+			assert.NotEmpty(t, inflight.Client)
+			assert.Equal(t, http.StatusNoContent, cancel(inflight.ID))
+
+			select {
+			case res := <-done:
+				if res != nil {
+					assert.Equal(t, statusClientClosedRequest, res.StatusCode)
+					res.Body.Close()
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("upload was not aborted")
+			}
+
+			assert.Eventually(t, func() bool { return len(requests()) == 0 }, time.Second, 10*time.Millisecond)
+			assert.NoFileExists(t, filepath.Join(testDir, "runaway.bin"))
+			leftovers, err := os.ReadDir(hdl.tempDir)
+			assert.Nil(t, err)
+			assert.Empty(t, leftovers)
+		},
+	)
+
+	t.Run(
+		"Cancel stream", func(t *testing.T) {
+			content := bytes.Repeat([]byte("x"), 16<<20)
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, "huge.mp4"), content, 0644))
+
+			res, err := http.Get(srv.URL + "/stream/huge.mp4?buffer=1024")
+			assert.Nil(t, err)
+			defer res.Body.Close()
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+
+			_, err = io.ReadFull(res.Body, make([]byte, 1024))
+			assert.Nil(t, err)
+
+			inflight := find("GET /stream/{name...}", 1024)
+			if !assert.NotNil(t, inflight) {
+				return
+			}
+			assert.Equal(t, "huge.mp4", inflight.Name)
+			assert.Equal(t, http.StatusNoContent, cancel(inflight.ID))
+
+			n, err := io.Copy(io.Discard, res.Body)
+			assert.NotNil(t, err)
+			assert.Less(t, n, int64(len(content)))
+			assert.Eventually(t, func() bool { return len(requests()) == 0 }, time.Second, 10*time.Millisecond)
+		},
+	)
+}
@@ -0,0 +1,206 @@
+package http
+
+import (
+	"github.com/JMURv/media-server/internal/logs"
+	"github.com/JMURv/media-server/internal/outbound"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/JMURv/media-server/pkg/version"
+	"gopkg.in/yaml.v3"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const redactedValue = "[REDACTED]"
+
+// SetConfigSource records where the configuration was loaded from and when,
+// for GET /admin/config. Call it before Start.
+func (h *Handler) SetConfigSource(path string, loadedAt time.Time) {
+	h.configPath, h.configLoadedAt = path, loadedAt
+}
+
+type Limits struct {
+	MaxUploadSize   int64 `json:"max_upload_size"`
+	MaxFileSize     int64 `json:"max_file_size"`
+	MaxRanges       int   `json:"max_ranges"`
+	MaxStreamBuffer int   `json:"max_stream_buffer"`
+	MaxWalkEntries  int   `json:"max_walk_entries"`
+	MaxFilesPerDir  int   `json:"max_files_per_dir,omitempty"`
+}
+
+// Summary is the effective runtime setup, logged at startup and reported by
+// GET /admin/config.
+type Summary struct {
+	version.Info
+	Listen      string            `json:"listen"`
+	DebugListen string            `json:"debug_listen,omitempty"`
+	SavePath    string            `json:"save_path"`
+	Roots       []string          `json:"roots,omitempty"`
+	TempDir     string            `json:"temp_dir"`
+	Limits      Limits            `json:"limits"`
+	Features    []string          `json:"features"`
+	Backends    map[string]string `json:"backends,omitempty"`
+}
+
+type ConfigResponse struct {
+	Summary
+	ConfigPath string            `json:"config_path,omitempty"`
+	LoadedAt   *time.Time        `json:"loaded_at,omitempty"`
+	Outbound   outbound.Settings `json:"outbound"`
+	Config     map[string]any    `json:"config"`
+}
+
+func (h *Handler) summary() Summary {
+	res := Summary{
+		Info:     version.Get(),
+		Listen:   h.port,
+		SavePath: h.savePath,
+		TempDir:  h.tempDir,
+		Limits: Limits{
+			MaxUploadSize:   h.config.MaxUploadSize,
+			MaxFileSize:     h.maxFileSize(),
+			MaxRanges:       h.maxRanges(),
+			MaxStreamBuffer: h.streamBuffer(unknownBodyLength, 0),
+			MaxWalkEntries:  h.maxWalkEntries(),
+			MaxFilesPerDir:  h.config.MaxFilesPerDir,
+		},
+		Features: h.features(),
+	}
+	if h.roots != nil {
+		res.Roots = h.rootDirs()
+	}
+	if h.config.Debug != nil {
+		res.DebugListen = h.config.Debug.Addr
+	}
+	if len(h.config.Backends) > 0 {
+		res.Backends = make(map[string]string, len(h.config.Backends))
+		for name, b := range h.config.Backends {
+			res.Backends[name] = b.Type
+		}
+	}
+	return res
+}
+
+// features lists the configured optional sections by their config key: set
+// section pointers and non-empty lists and maps of sections.
+func (h *Handler) features() []string {
+	res := make([]string, 0)
+	if h.config.AuditLog != "" {
+		res = append(res, "auditLog")
+	}
+
+	v := reflect.ValueOf(h.config).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		on := false
+		switch f.Kind() {
+		case reflect.Pointer:
+			on = !f.IsNil()
+		case reflect.Slice, reflect.Map:
+			on = f.Type().Elem().Kind() == reflect.Pointer && f.Len() > 0
+		}
+		if on {
+			res = append(res, strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0])
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (h *Handler) logStartup() {
+	s := h.summary()
+	slog.Info(
+		"Starting media-server",
+		"version", s.Version,
+		"commit", s.Commit,
+		"listen", s.Listen,
+		"debug_listen", s.DebugListen,
+		"save_path", s.SavePath,
+		"roots", s.Roots,
+		"temp_dir", s.TempDir,
+		slog.Group(
+			"limits",
+			"max_upload_size", s.Limits.MaxUploadSize,
+			"max_file_size", s.Limits.MaxFileSize,
+			"max_ranges", s.Limits.MaxRanges,
+			"max_stream_buffer", s.Limits.MaxStreamBuffer,
+			"max_walk_entries", s.Limits.MaxWalkEntries,
+			"max_files_per_dir", s.Limits.MaxFilesPerDir,
+		),
+		"features", strings.Join(s.Features, ","),
+		"backends", s.Backends,
+	)
+}
+
+func (h *Handler) buildVersion(w http.ResponseWriter, r *http.Request) {
+	utils.JSONResponse(w, http.StatusOK, version.Get())
+}
+
+func (h *Handler) adminConfig(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+
+	cfg, err := redactedConfig(h.config)
+	if err != nil {
+		log.Printf("Error encoding config: %s\n", err)
+		writeError(w, ErrInternal)
+		return
+	}
+
+	res := &ConfigResponse{
+		Summary: h.summary(), ConfigPath: h.configPath, Outbound: h.outbound.Settings(), Config: cfg,
+	}
+	if !h.configLoadedAt.IsZero() {
+		res.LoadedAt = &h.configLoadedAt
+	}
+	utils.JSONResponse(w, http.StatusOK, res)
+}
+
+// redactedConfig returns v as it is keyed in the config file, with secrets
+// masked: non-empty strings under sensitive keys, such as tokens and
+// secrets, credentials in URLs and secrets in free text.
+func redactedConfig(v any) (map[string]any, error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]any)
+	if err = yaml.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	redactValue("", res)
+	return res, nil
+}
+
+func redactValue(key string, v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = redactValue(k, e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = redactValue(key, e)
+		}
+	case string:
+		if v == "" {
+			return v
+		}
+		if logs.Sensitive(key) {
+			return redactedValue
+		}
+		if u, err := url.Parse(v); err == nil && u.User != nil {
+			v = u.Redacted()
+		}
+		return logs.Redact(v)
+	}
+	return v
+}
@@ -0,0 +1,117 @@
+package http
+
+import (
+	"fmt"
+	"github.com/JMURv/media-server/internal/meta"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Media kinds group files the way gallery UIs tab them.
+const (
+	KindImage = "image"
+	KindVideo = "video"
+	KindAudio = "audio"
+	KindOther = "other"
+)
+
+const groupKind = "kind"
+
+var mediaKinds = []string{KindImage, KindVideo, KindAudio, KindOther}
+
+// defaultKinds are media types whose kind their top-level type does not
+// tell. Config entries take precedence.
+var defaultKinds = map[string]string{
+	"application/vnd.apple.mpegurl": KindVideo,
+	"application/x-mpegurl":         KindVideo,
+	"application/ogg":               KindAudio,
+}
+
+// parseMediaKinds validates the configured overrides, keyed by media type or
+// by top-level type as "type/*", and normalizes their keys.
+func parseMediaKinds(kinds map[string]string) (map[string]string, error) {
+	res := make(map[string]string, len(kinds))
+	for typ, kind := range kinds {
+		if !slices.Contains(mediaKinds, kind) {
+			return nil, fmt.Errorf("%w: %q for %s", ErrInvalidKind, kind, typ)
+		}
+		key := baseMediaType(typ)
+		if top, ok := strings.CutSuffix(key, "/*"); ok && top != "" && !strings.Contains(top, "/") {
+			res[key] = kind
+			continue
+		}
+		if _, _, err := mime.ParseMediaType(typ); err != nil || !strings.Contains(key, "/") {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidContentType, typ)
+		}
+		res[key] = kind
+	}
+	return res, nil
+}
+
+// mediaKind returns the kind of contentType: a configured override for the
+// media type or its top-level type, a built-in one, or the top-level type
+// itself when it is image, video or audio.
+func (h *Handler) mediaKind(contentType string) string {
+	typ := baseMediaType(contentType)
+	top, _, _ := strings.Cut(typ, "/")
+	if kind, ok := h.kinds[typ]; ok {
+		return kind
+	}
+	if kind, ok := h.kinds[top+"/*"]; ok {
+		return kind
+	}
+	if kind, ok := defaultKinds[typ]; ok {
+		return kind
+	}
+	switch top {
+	case KindImage, KindVideo, KindAudio:
+		return top
+	}
+	return KindOther
+}
+
+// streamable reports whether files of contentType may be streamed: media
+// kinds and subtitles.
+func (h *Handler) streamable(contentType string) bool {
+	return h.mediaKind(contentType) != KindOther || subtitle(contentType)
+}
+
+// fileKind returns the kind of name from the catalog alone, so listings never
+// open files: the stored content type, else the type its extension declares,
+// else the type sniffed when it was last streamed. This is the order
+// contentType settles on the type a file is streamed as.
+func (h *Handler) fileKind(name string, m *meta.File) string {
+	var contentType string
+	if m != nil {
+		contentType = m.ContentType
+	}
+	if contentType == "" {
+		contentType = typeByExtension(name)
+	}
+	if contentType == "" && m != nil {
+		contentType = m.DetectedType
+	}
+	return h.mediaKind(contentType)
+}
+
+func kindFilter(r *http.Request) (string, error) {
+	kind := r.URL.Query().Get("kind")
+	if kind != "" && !slices.Contains(mediaKinds, kind) {
+		return "", withParams(ErrInvalidKind, map[string]any{"expected": strings.Join(mediaKinds, ", ")})
+	}
+	return kind, nil
+}
+
+// groupBy reports whether a listing should count its files per kind, the
+// only grouping there is.
+func groupBy(r *http.Request) (bool, error) {
+	switch r.URL.Query().Get("group") {
+	case "":
+		return false, nil
+	case groupKind:
+		return true, nil
+	}
+	return false, ErrInvalidGroup
+}
@@ -0,0 +1,137 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMediaKinds(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize: 1024,
+			DefaultPage:   1,
+			DefaultSize:   2,
+			MediaKinds: map[string]string{
+				"application/mxf": KindVideo,
+				"Image/SVG+XML":   KindOther,
+				"text/*":          KindAudio,
+			},
+		},
+	)
+
+	do := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+	list := func(query string) (utils.PaginatedResponse, []ListEntry) {
+		rec := do("/list?" + query)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var res struct {
+			utils.PaginatedResponse
+			Data []ListEntry `json:"data"`
+		}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res.PaginatedResponse, res.Data
+	}
+	errorCode := func(rec *httptest.ResponseRecorder) string {
+		res := utils.ErrorResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res.Code
+	}
+
+	for name, fields := range map[string]map[string]string{
+		"a.jpg":      nil,
+		"b.png":      nil,
+		"c.mp4":      nil,
+		"d.mp3":      nil,
+		"e.pdf":      nil,
+		"f.svg":      nil,
+		"g.mxf":      {"content_type": "application/mxf"},
+		"h.txt":      {"content_type": "text/plain"},
+		"i.playlist": {"content_type": "application/vnd.apple.mpegurl"},
+	} {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, newUploadRequest(name, "content", fields))
+		assert.Equal(t, http.StatusCreated, rec.Code, name)
+	}
+
+	t.Run(
+		"Grouping counts every kind", func(t *testing.T) {
+			res, data := list("group=kind")
+			assert.Equal(t, map[string]int{KindImage: 2, KindVideo: 3, KindAudio: 2, KindOther: 2}, res.Groups)
+			assert.Equal(t, 9, res.Count)
+			assert.Len(t, data, 2)
+			assert.Equal(t, KindImage, data[0].Kind)
+
+			res, data = list("group=kind&kind=video&page=2")
+			assert.Equal(t, 3, res.Count)
+			assert.Equal(t, 2, res.TotalPages)
+			assert.Equal(t, 9, res.Groups[KindImage]+res.Groups[KindVideo]+res.Groups[KindAudio]+res.Groups[KindOther])
+			assert.Len(t, data, 1)
+			assert.Contains(t, data[0].URL, "i.playlist")
+		},
+	)
+
+	t.Run(
+		"Filtering by kind", func(t *testing.T) {
+			res, data := list("kind=other&size=10")
+			assert.Nil(t, res.Groups)
+			assert.Len(t, data, 2)
+			for _, e := range data {
+				assert.Equal(t, KindOther, e.Kind)
+			}
+			assert.Contains(t, data[0].URL, "e.pdf")
+			assert.Contains(t, data[1].URL, "f.svg")
+
+			_, data = list("include=kind&size=10")
+			assert.Len(t, data, 9)
+			assert.Equal(t, KindAudio, data[7].Kind)
+		},
+	)
+
+	t.Run(
+		"Streaming follows the same kinds", func(t *testing.T) {
+			assert.Equal(t, http.StatusOK, do("/stream/g.mxf").Code)
+			assert.Equal(t, http.StatusOK, do("/stream/i.playlist").Code)
+			assert.Equal(t, http.StatusUnsupportedMediaType, do("/stream/f.svg").Code)
+			assert.Equal(t, http.StatusUnsupportedMediaType, do("/stream/e.pdf").Code)
+		},
+	)
+
+	t.Run(
+		"Invalid parameters", func(t *testing.T) {
+			rec := do("/list?kind=document")
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Equal(t, "invalid_kind", errorCode(rec))
+
+			rec = do("/list?group=state")
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Equal(t, "invalid_group", errorCode(rec))
+		},
+	)
+
+	t.Run(
+		"Invalid overrides fail at startup", func(t *testing.T) {
+			for _, kinds := range []map[string]string{
+				{"image/png": "picture"},
+				{"png": KindImage},
+				{"/*": KindImage},
+			} {
+				assert.Panics(
+					t, func() {
+						New(port, testDir, &config.HTTPConfig{MediaKinds: kinds})
+					},
+				)
+			}
+		},
+	)
+}
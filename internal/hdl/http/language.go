@@ -0,0 +1,56 @@
+package http
+
+import (
+	"github.com/JMURv/media-server/pkg/messages"
+	"io"
+	"net/http"
+)
+
+// languageWriter carries the language negotiated for a request down to
+// writeError, which only sees the response writer.
+type languageWriter struct {
+	http.ResponseWriter
+	lang string
+}
+
+func (w *languageWriter) ReadFrom(src io.Reader) (int64, error) {
+	return io.Copy(w.ResponseWriter, src)
+}
+
+func (w *languageWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *languageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// localize negotiates the language error messages are written in from
+// Accept-Language, falling back to the configured default.
+func (h *Handler) localize(next http.Handler) http.Handler {
+	if h.config.Messages == nil {
+		return next
+	}
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			lang := messages.Negotiate(r.Header.Get("Accept-Language"), h.config.Messages.DefaultLanguage)
+			next.ServeHTTP(&languageWriter{ResponseWriter: w, lang: lang}, r)
+		},
+	)
+}
+
+// responseLanguage returns the language negotiated for w, or "" when
+// messages are not localized.
+func responseLanguage(w http.ResponseWriter) string {
+	for w != nil {
+		if lw, ok := w.(*languageWriter); ok {
+			return lw.lang
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return ""
+		}
+		w = u.Unwrap()
+	}
+	return ""
+}
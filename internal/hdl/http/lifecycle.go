@@ -0,0 +1,446 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/webhook"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	actionDelete     = "delete"
+	actionTransition = "transition:"
+
+	defaultLifecycleInterval = time.Hour
+)
+
+type LifecycleAction struct {
+	Rule   string `json:"rule"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	Age    string `json:"age"`
+	Error  string `json:"error,omitempty"`
+}
+
+type LifecycleReport struct {
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	DryRun     bool              `json:"dry_run"`
+	Actions    []LifecycleAction `json:"actions"`
+}
+
+func (h *Handler) validateLifecycle() error {
+	if h.config.Lifecycle == nil {
+		return nil
+	}
+
+	for i, rule := range h.config.Lifecycle.Rules {
+		if rule.Glob != "" {
+			if _, err := path.Match(rule.Glob, ""); err != nil {
+				return fmt.Errorf("rule %d: invalid glob %q", i, rule.Glob)
+			}
+		}
+
+		if rule.Action == actionDelete {
+			continue
+		}
+
+		backend, ok := strings.CutPrefix(rule.Action, actionTransition)
+		if !ok {
+			return fmt.Errorf("rule %d: unknown action %q", i, rule.Action)
+		}
+		if _, ok = h.backends[backend]; !ok {
+			return fmt.Errorf("rule %d: unknown backend %q", i, backend)
+		}
+	}
+	return nil
+}
+
+func matchRule(rule *config.LifecycleRule, name string, tags []string) bool {
+	if rule.Prefix != "" && !strings.HasPrefix(name, rule.Prefix) {
+		return false
+	}
+
+	if rule.Glob != "" {
+		if ok, _ := path.Match(rule.Glob, name); !ok {
+			return false
+		}
+	}
+
+	return rule.Tag == "" || slices.Contains(tags, rule.Tag)
+}
+
+func (h *Handler) runLifecycle(ctx context.Context) {
+	interval := h.config.Lifecycle.Interval
+	if interval <= 0 {
+		interval = defaultLifecycleInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			report := h.applyLifecycle(ctx, now, h.config.Lifecycle.DryRun)
+			log.Printf("Lifecycle run finished: %d actions (dry run: %v)\n", len(report.Actions), report.DryRun)
+		}
+	}
+}
+
+// applyLifecycle applies the rules to the files they match. A dry run checks
+// whether each action could be applied and reports it without applying it.
+// Runs do not overlap.
+func (h *Handler) applyLifecycle(ctx context.Context, now time.Time, dryRun bool) *LifecycleReport {
+	h.lifecycleRunMu.Lock()
+	defer h.lifecycleRunMu.Unlock()
+
+	cfg := h.config.Lifecycle
+	report := &LifecycleReport{
+		StartedAt: now.UTC(),
+		DryRun:    dryRun,
+		Actions:   make([]LifecycleAction, 0),
+	}
+
+	apply := func(name string, tags []string, age time.Duration, transitioned bool) {
+		for _, rule := range cfg.Rules {
+			// Transitioned files are already off local disk; only deletes
+			// apply to them.
+			if age < rule.Age || (transitioned && rule.Action != actionDelete) || !matchRule(rule, name, tags) {
+				continue
+			}
+
+			action := LifecycleAction{
+				Rule:   rule.Name,
+				Name:   name,
+				Action: rule.Action,
+				Age:    age.Round(time.Second).String(),
+			}
+			if err := h.applyRule(ctx, rule, name, dryRun); err != nil {
+				action.Error = err.Error()
+			}
+
+			report.Actions = append(report.Actions, action)
+			return
+		}
+	}
+
+	err := h.walkRootsDir(
+		func(root, p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if err = ctx.Err(); err != nil {
+				return err
+			}
+
+			if p != root && strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.Type()&fs.ModeSymlink != 0 {
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+
+			rel, _ := filepath.Rel(root, p)
+			name := filepath.ToSlash(rel)
+
+			var tags []string
+			if m, err := h.meta.Get(name); err == nil {
+				tags = m.Tags
+			}
+			apply(name, tags, now.Sub(info.ModTime()), false)
+			return nil
+		},
+	)
+	if err == nil {
+		err = h.walkTransitioned(
+			func(m *meta.File) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				apply(m.Name, m.Tags, now.Sub(m.UpdatedAt), true)
+				return nil
+			},
+		)
+	}
+	if err != nil {
+		log.Printf("Lifecycle run interrupted: %s\n", err)
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	h.lifecycleMu.Lock()
+	h.lifecycleReport = report
+	h.lifecycleMu.Unlock()
+	return report
+}
+
+func (h *Handler) applyRule(ctx context.Context, rule *config.LifecycleRule, name string, dryRun bool) error {
+	unlock := h.fileMu.Lock(name)
+	defer unlock()
+
+	m, err := h.meta.Get(name)
+	if err != nil && !errors.Is(err, meta.ErrNotFound) {
+		return err
+	}
+	if m != nil && m.Lock.Active() {
+		return ErrLocked
+	}
+	if m != nil && m.Hold.Active() {
+		return ErrHeld
+	}
+
+	details := map[string]any{"rule": rule.Name}
+	if dryRun {
+		typ := webhook.FileDeleted
+		if backend, ok := strings.CutPrefix(rule.Action, actionTransition); ok {
+			typ, details["backend"] = webhook.FileTransitioned, backend
+		}
+		h.simulate(typ, name, nil, details)
+		return nil
+	}
+	if rule.Action == actionDelete {
+		if _, err = h.discard(h.filePath(name), name, m); err != nil {
+			return err
+		}
+		if err = h.meta.Delete(name); err != nil {
+			log.Printf("Error removing metadata for %s: %s\n", name, err)
+		}
+
+		runFileHooks(ctx, h.hooks.deleted, fileInfo(name, m))
+
+		h.emit(webhook.FileDeleted, name, nil, details)
+		return nil
+	}
+
+	backend := strings.TrimPrefix(rule.Action, actionTransition)
+	if err = h.transition(ctx, backend, name, m); err != nil {
+		return err
+	}
+
+	details["backend"] = backend
+	h.emit(webhook.FileTransitioned, name, nil, details)
+	return nil
+}
+
+func (h *Handler) transition(ctx context.Context, backend, name string, m *meta.File) error {
+	dst := h.backends[backend]
+	src := h.filePath(name)
+
+	file, err := h.openStored(ctx, src)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	hash := sha256.New()
+	size, err := dst.Put(ctx, name, io.TeeReader(file, hash))
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if m != nil && m.Checksum != "" && m.Checksum != checksum {
+		dst.Remove(ctx, name)
+		return ErrChecksumMismatch
+	}
+
+	copied, err := dst.Open(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	hash.Reset()
+	_, err = io.Copy(hash, copied)
+	copied.Close()
+	if err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != checksum {
+		dst.Remove(ctx, name)
+		return ErrChecksumMismatch
+	}
+
+	if m == nil {
+		m = &meta.File{Name: name, OriginalName: name, CreatedAt: time.Now().UTC()}
+	}
+	// UpdatedAt keeps the local modification time: it is what listings
+	// show and what later rules measure the file's age from.
+	m.Checksum = checksum
+	m.Size = size
+	m.Backend = backend
+	m.UpdatedAt = info.ModTime().UTC()
+	if err = h.meta.Put(m); err != nil {
+		return err
+	}
+	defer h.invalidateFile(name)
+	return os.Remove(src)
+}
+
+// transitioned returns the metadata of name if its content was moved to a
+// backend, and nil if it is stored locally or not at all.
+func (h *Handler) transitioned(name string) *meta.File {
+	if len(h.backends) == 0 {
+		return nil
+	}
+	m, err := h.meta.Get(name)
+	if err != nil || h.backends[m.Backend] == nil {
+		return nil
+	}
+	return m
+}
+
+// walkTransitioned calls fn with the metadata of every file whose content
+// was moved to a backend.
+func (h *Handler) walkTransitioned(fn func(*meta.File) error) error {
+	if len(h.backends) == 0 {
+		return nil
+	}
+	return h.meta.Walk(
+		func(m *meta.File) error {
+			if h.backends[m.Backend] == nil {
+				return nil
+			}
+			return fn(m)
+		},
+	)
+}
+
+// transitionedInfo describes a transitioned file from its metadata.
+type transitionedInfo struct{ m *meta.File }
+
+func (i transitionedInfo) Name() string       { return path.Base(i.m.Name) }
+func (i transitionedInfo) Size() int64        { return i.m.Size }
+func (i transitionedInfo) Mode() fs.FileMode  { return 0444 }
+func (i transitionedInfo) ModTime() time.Time { return i.m.UpdatedAt }
+func (i transitionedInfo) IsDir() bool        { return false }
+func (i transitionedInfo) Sys() any           { return nil }
+
+// transitionedEntry lists a transitioned file by its name.
+type transitionedEntry struct{ m *meta.File }
+
+func (e transitionedEntry) Name() string               { return e.m.Name }
+func (e transitionedEntry) IsDir() bool                { return false }
+func (e transitionedEntry) Type() fs.FileMode          { return 0 }
+func (e transitionedEntry) Info() (fs.FileInfo, error) { return transitionedInfo(e), nil }
+
+// transitionedFile serves a transitioned file from its backend.
+type transitionedFile struct {
+	io.ReadSeekCloser
+	m *meta.File
+}
+
+func (f transitionedFile) Readdir(int) ([]fs.FileInfo, error) { return nil, fs.ErrInvalid }
+func (f transitionedFile) Stat() (fs.FileInfo, error)         { return transitionedInfo{f.m}, nil }
+
+func (h *Handler) openFile(ctx context.Context, name string) (io.ReadSeekCloser, error) {
+	path, err := h.checkPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	e, gen := h.exists.lookup(name)
+	if e != nil && e.info == nil {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+
+	file, err := openShared(path, h.openFlags(os.O_RDONLY))
+	if err == nil {
+		stored, err := h.unseal(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			if info, err := stored.Stat(); err == nil {
+				h.exists.store(name, gen, info)
+			}
+		}
+		return stored, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	m, merr := h.meta.Get(name)
+	if merr != nil || m.Backend == "" {
+		h.exists.store(name, gen, nil)
+		return nil, err
+	}
+
+	backend, ok := h.backends[m.Backend]
+	if !ok {
+		return nil, err
+	}
+	return backend.Open(ctx, name)
+}
+
+// lifecycleRun applies the lifecycle rules now rather than at the next
+// interval, and answers with the report of the run. A dry run, or any run
+// while the rules are configured as a dry run, only reports.
+func (h *Handler) lifecycleRun(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+	if h.config.Lifecycle == nil {
+		writeError(w, ErrLifecycleDisabled)
+		return
+	}
+
+	report := h.applyLifecycle(r.Context(), time.Now(), h.config.Lifecycle.DryRun || dryRun(r))
+	if err := r.Context().Err(); err != nil {
+		writeError(w, canceledOr(err, ErrInternal))
+		return
+	}
+	utils.JSONResponse(w, http.StatusOK, report)
+}
+
+func (h *Handler) lifecycleReportHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+
+	h.lifecycleMu.RLock()
+	report := h.lifecycleReport
+	h.lifecycleMu.RUnlock()
+
+	if report == nil {
+		writeError(w, ErrNoLifecycleReport)
+		return
+	}
+	utils.JSONResponse(w, http.StatusOK, report)
+}
@@ -0,0 +1,259 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/audit"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/webhook"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLifecycle(t *testing.T) {
+	testDir := t.TempDir()
+
+	var mu sync.Mutex
+	events := make(map[string][]string)
+	hook := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				assert.Equal(t, webhook.Sign("secret", body), r.Header.Get(webhook.SignatureHeader))
+
+				e := webhook.Event{}
+				assert.Nil(t, json.Unmarshal(body, &e))
+				mu.Lock()
+				events[e.Type] = append(events[e.Type], e.Name)
+				mu.Unlock()
+			},
+		),
+	)
+	defer hook.Close()
+
+	archive := t.TempDir()
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize:   10 * 1024 * 1024,
+			MaxStreamBuffer: 1024,
+			AdminToken:      "admin-secret",
+			AuditLog:        auditPath,
+			Webhooks:        []*config.WebhookConfig{{URL: hook.URL, Secret: "secret"}},
+			Backends: map[string]*config.BackendConfig{
+				"archive": {Type: "local", Path: archive},
+			},
+			Lifecycle: &config.LifecycleConfig{
+				DryRun: true,
+				Rules: []*config.LifecycleRule{
+					{Name: "tmp", Prefix: "tmp/", Action: "delete", Age: time.Hour},
+					{Name: "ephemeral", Glob: "*.log", Tag: "ephemeral", Action: "delete"},
+					{Name: "archive", Glob: "*.mp4", Action: "transition:archive", Age: time.Hour},
+				},
+			},
+		},
+	)
+
+	old := time.Now().Add(-2 * time.Hour)
+	seed := func(name, content string, mtime time.Time, tags ...string) {
+		p := filepath.Join(testDir, filepath.FromSlash(name))
+		assert.Nil(t, os.MkdirAll(filepath.Dir(p), os.ModePerm))
+		assert.Nil(t, os.WriteFile(p, []byte(content), 0644))
+		assert.Nil(t, os.Chtimes(p, mtime, mtime))
+
+		checksum, err := fileChecksum(context.Background(), p)
+		assert.Nil(t, err)
+		assert.Nil(t, hdl.meta.Put(&meta.File{Name: name, Checksum: checksum, Tags: tags}))
+	}
+
+	seed("tmp/old.txt", "old", old)
+	seed("tmp/new.txt", "new", time.Now())
+	seed("debug.log", "log", time.Now(), "ephemeral")
+	seed("keep.log", "log", time.Now())
+	seed("movie.mp4", "movie", old)
+	seed("locked.mp4", "locked", old)
+
+	m, _ := hdl.meta.Get("locked.mp4")
+	m.Lock = &meta.Lock{Token: "t", Holder: "encoder", ExpiresAt: time.Now().Add(time.Hour)}
+	assert.Nil(t, hdl.meta.Put(m))
+
+	getReport := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/admin/lifecycle/report", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	actions := func(r *LifecycleReport) map[string]LifecycleAction {
+		res := make(map[string]LifecycleAction)
+		for _, a := range r.Actions {
+			res[a.Name] = a
+		}
+		return res
+	}
+
+	t.Run(
+		"No report before first run", func(t *testing.T) {
+			assert.Equal(t, http.StatusNotFound, getReport("admin-secret").Code)
+		},
+	)
+
+	t.Run(
+		"Dry run", func(t *testing.T) {
+			report := hdl.applyLifecycle(context.Background(), time.Now(), hdl.config.Lifecycle.DryRun)
+			assert.True(t, report.DryRun)
+
+			res := actions(report)
+			assert.Len(t, res, 4)
+			assert.Equal(t, "tmp", res["tmp/old.txt"].Rule)
+			assert.Equal(t, "ephemeral", res["debug.log"].Rule)
+			assert.Equal(t, "archive", res["movie.mp4"].Rule)
+			assert.Equal(t, ErrLocked.Error(), res["locked.mp4"].Error)
+
+			assert.FileExists(t, filepath.Join(testDir, "tmp", "old.txt"))
+			assert.FileExists(t, filepath.Join(testDir, "movie.mp4"))
+		},
+	)
+
+	t.Run(
+		"Apply", func(t *testing.T) {
+			hdl.config.Lifecycle.DryRun = false
+			res := actions(hdl.applyLifecycle(context.Background(), time.Now(), hdl.config.Lifecycle.DryRun))
+			assert.Len(t, res, 4)
+			assert.Equal(t, ErrLocked.Error(), res["locked.mp4"].Error)
+
+			assert.NoFileExists(t, filepath.Join(testDir, "tmp", "old.txt"))
+			assert.FileExists(t, filepath.Join(testDir, "tmp", "new.txt"))
+			assert.NoFileExists(t, filepath.Join(testDir, "debug.log"))
+			assert.FileExists(t, filepath.Join(testDir, "keep.log"))
+			assert.FileExists(t, filepath.Join(testDir, "locked.mp4"))
+
+			assert.NoFileExists(t, filepath.Join(testDir, "movie.mp4"))
+			data, err := os.ReadFile(filepath.Join(archive, "movie.mp4"))
+			assert.Nil(t, err)
+			assert.Equal(t, "movie", string(data))
+
+			m, err := hdl.meta.Get("movie.mp4")
+			assert.Nil(t, err)
+			assert.Equal(t, "archive", m.Backend)
+		},
+	)
+
+	t.Run(
+		"Transitioned file is still streamed", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream/uploads/movie.mp4", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "movie", rec.Body.String())
+		},
+	)
+
+	t.Run(
+		"Report endpoint", func(t *testing.T) {
+			assert.Equal(t, http.StatusForbidden, getReport("").Code)
+			assert.Equal(t, http.StatusForbidden, getReport("wrong").Code)
+
+			rec := getReport("admin-secret")
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			report := &LifecycleReport{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(report))
+			assert.False(t, report.DryRun)
+			assert.Len(t, report.Actions, 4)
+		},
+	)
+
+	t.Run(
+		"Audit log and webhooks", func(t *testing.T) {
+			hdl.webhooks.Wait()
+			mu.Lock()
+			assert.ElementsMatch(t, []string{"tmp/old.txt", "debug.log"}, events[webhook.FileDeleted])
+			assert.ElementsMatch(t, []string{"movie.mp4"}, events[webhook.FileTransitioned])
+			mu.Unlock()
+
+			file, err := os.Open(auditPath)
+			assert.Nil(t, err)
+			defer file.Close()
+
+			var entries []audit.Entry
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				e := audit.Entry{}
+				assert.Nil(t, json.Unmarshal(scanner.Bytes(), &e))
+				entries = append(entries, e)
+			}
+			// The dry run is logged as a simulation of the run that followed.
+			assert.Len(t, entries, 6)
+			for i, e := range entries {
+				assert.Equal(t, "system", e.Actor)
+				assert.NotEmpty(t, e.Details["rule"])
+				assert.Equal(t, i < 3, e.Details["dry_run"] == true)
+			}
+		},
+	)
+
+	t.Run(
+		"Transitioned file is listed and deleted", func(t *testing.T) {
+			get := func(target string) *httptest.ResponseRecorder {
+				rec := httptest.NewRecorder()
+				hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+				return rec
+			}
+
+			rec := get("/list?page=1&size=10")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Contains(t, rec.Body.String(), "movie.mp4")
+
+			sum := sha256.Sum256([]byte("movie"))
+			rec = get("/files/movie.mp4/checksum")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Contains(t, rec.Body.String(), hex.EncodeToString(sum[:]))
+
+			md5sum := md5.Sum([]byte("movie"))
+			rec = get("/files/movie.mp4/checksum?algo=md5")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Contains(t, rec.Body.String(), hex.EncodeToString(md5sum[:]))
+
+			rec = get("/uploads/movie.mp4")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "movie", rec.Body.String())
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/files/movie.mp4", nil))
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+			assert.NoFileExists(t, filepath.Join(archive, "movie.mp4"))
+			_, err := hdl.meta.Get("movie.mp4")
+			assert.ErrorIs(t, err, meta.ErrNotFound)
+
+			assert.NotContains(t, get("/list?page=1&size=10").Body.String(), "movie.mp4")
+		},
+	)
+
+	t.Run(
+		"Invalid config", func(t *testing.T) {
+			assert.Panics(
+				t, func() {
+					New(
+						port, testDir, &config.HTTPConfig{
+							Lifecycle: &config.LifecycleConfig{
+								Rules: []*config.LifecycleRule{{Action: "transition:s3"}},
+							},
+						},
+					)
+				},
+			)
+		},
+	)
+}
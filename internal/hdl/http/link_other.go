@@ -0,0 +1,9 @@
+//go:build !unix
+
+package http
+
+import "os"
+
+func linkCount(os.FileInfo) uint64 {
+	return 1
+}
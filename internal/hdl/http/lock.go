@@ -0,0 +1,179 @@
+package http
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/JMURv/media-server/internal/meta"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	defaultLockTTL    = 5 * time.Minute
+	defaultMaxLockTTL = time.Hour
+	lockTokenHeader   = "X-Lock-Token"
+)
+
+func newLockToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (h *Handler) lockTTL(r *http.Request) (time.Duration, error) {
+	ttl, maxTTL := h.config.LockTTL, h.config.MaxLockTTL
+	if ttl <= 0 {
+		ttl = defaultLockTTL
+	}
+	if maxTTL <= 0 {
+		maxTTL = defaultMaxLockTTL
+	}
+
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxTTL {
+			return 0, ErrInvalidLockTTL
+		}
+		ttl = d
+	}
+	return ttl, nil
+}
+
+func lockToken(r *http.Request) string {
+	if token := r.Header.Get(lockTokenHeader); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("token")
+}
+
+// holdsLock reports whether token is the token of l, in constant time so
+// that response timing does not leak a lock's token.
+func holdsLock(l *meta.Lock, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(l.Token), []byte(token)) == 1
+}
+
+func lockedResponse(w http.ResponseWriter, l *meta.Lock) {
+	utils.JSONResponse(
+		w, http.StatusLocked, utils.LockedResponse{
+			ErrorResponse: errorBody(w, http.StatusLocked, ErrLocked),
+			Holder:        l.Holder,
+			ExpiresAt:     l.ExpiresAt,
+		},
+	)
+}
+
+func (h *Handler) checkLock(w http.ResponseWriter, r *http.Request, name string) bool {
+	m, err := h.meta.Get(name)
+	if err != nil || !m.Lock.Active() || holdsLock(m.Lock, lockToken(r)) {
+		return true
+	}
+
+	lockedResponse(w, m.Lock)
+	return false
+}
+
+func (h *Handler) lockFile(w http.ResponseWriter, r *http.Request) {
+	name, err := h.pathName(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	ttl, err := h.lockTTL(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	unlock := h.fileMu.Lock(name)
+	defer unlock()
+
+	if _, err = os.Stat(h.filePath(name)); os.IsNotExist(err) {
+		writeError(w, ErrNotFound)
+		return
+	}
+
+	m, err := h.meta.Get(name)
+	if err != nil {
+		m = &meta.File{Name: name, OriginalName: name}
+	}
+
+	refresh := r.URL.Query().Get("refresh") == "true"
+	token := lockToken(r)
+	switch {
+	case refresh && token == "":
+		writeError(w, ErrLockTokenRequired)
+		return
+	case m.Lock.Active() && !holdsLock(m.Lock, token):
+		lockedResponse(w, m.Lock)
+		return
+	case refresh && !m.Lock.Active():
+		writeError(w, ErrLockNotHeld)
+		return
+	}
+
+	if !refresh {
+		holder := r.URL.Query().Get("holder")
+		if holder == "" {
+			holder = r.RemoteAddr
+		}
+		m.Lock = &meta.Lock{
+			Token:  newLockToken(),
+			Holder: holder,
+		}
+	}
+	m.Lock.ExpiresAt = time.Now().UTC().Add(ttl)
+
+	if err = h.meta.Put(m); err != nil {
+		log.Printf("Error saving lock for %s: %s\n", name, err)
+		writeError(w, ErrInternal)
+		return
+	}
+
+	log.Printf("File %s locked by %s until %s\n", name, m.Lock.Holder, m.Lock.ExpiresAt)
+	utils.JSONResponse(w, http.StatusOK, m.Lock)
+}
+
+func (h *Handler) unlockFile(w http.ResponseWriter, r *http.Request) {
+	name, err := h.pathName(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	token := lockToken(r)
+	if token == "" {
+		writeError(w, ErrLockTokenRequired)
+		return
+	}
+
+	unlock := h.fileMu.Lock(name)
+	defer unlock()
+
+	m, err := h.meta.Get(name)
+	if err != nil || !m.Lock.Active() {
+		writeError(w, ErrLockNotHeld)
+		return
+	}
+
+	if !holdsLock(m.Lock, token) {
+		lockedResponse(w, m.Lock)
+		return
+	}
+
+	m.Lock = nil
+	if err = h.meta.Put(m); err != nil {
+		log.Printf("Error releasing lock for %s: %s\n", name, err)
+		writeError(w, ErrInternal)
+		return
+	}
+
+	log.Printf("File %s unlocked\n", name)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,144 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/meta"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newLockRequest(method, name, query, token string) *http.Request {
+	req := httptest.NewRequest(method, "/files/"+name+"/lock"+query, nil)
+	if token != "" {
+		req.Header.Set(lockTokenHeader, token)
+	}
+	return req
+}
+
+func acquireLock(t *testing.T, hdl *Handler, name, query string) *meta.Lock {
+	rec := httptest.NewRecorder()
+	hdl.ServeHTTP(rec, newLockRequest(http.MethodPost, name, query, ""))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	l := &meta.Lock{}
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(l))
+	return l
+}
+
+func TestLockFile(t *testing.T) {
+	testDir := t.TempDir()
+	hdl := setupTestHandler(testDir)
+
+	path := filepath.Join(testDir, "locked.mp4")
+	assert.Nil(t, os.WriteFile(path, []byte("video"), 0644))
+
+	t.Run(
+		"Locked file rejects other callers", func(t *testing.T) {
+			l := acquireLock(t, hdl, "locked.mp4", "?holder=encoder-1&ttl=1m")
+			assert.NotEmpty(t, l.Token)
+			assert.Equal(t, "encoder-1", l.Holder)
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newLockRequest(http.MethodPost, "locked.mp4", "", ""))
+			assert.Equal(t, http.StatusLocked, rec.Code)
+
+			req := httptest.NewRequest(http.MethodDelete, "/delete?filename=locked.mp4", nil)
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusLocked, rec.Code)
+
+			res := utils.LockedResponse{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.Equal(t, "encoder-1", res.Holder)
+			assert.WithinDuration(t, l.ExpiresAt, res.ExpiresAt, time.Second)
+			assert.NotContains(t, rec.Body.String(), l.Token)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newPatchRequest("locked.mp4", "x", ""))
+			assert.Equal(t, http.StatusLocked, rec.Code)
+
+			patch := newPatchRequest("locked.mp4", "!", "")
+			patch.Header.Set(lockTokenHeader, l.Token)
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, patch)
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream/uploads/locked.mp4", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newLockRequest(http.MethodDelete, "locked.mp4", "", "wrong"))
+			assert.Equal(t, http.StatusLocked, rec.Code)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newLockRequest(http.MethodDelete, "locked.mp4", "", l.Token))
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newPatchRequest("locked.mp4", "x", ""))
+			assert.Equal(t, http.StatusOK, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Refresh extends ttl", func(t *testing.T) {
+			l := acquireLock(t, hdl, "locked.mp4", "?ttl=1s")
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newLockRequest(http.MethodPost, "locked.mp4", "?refresh=true&ttl=1m", l.Token))
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			refreshed := &meta.Lock{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(refreshed))
+			assert.Equal(t, l.Token, refreshed.Token)
+			assert.True(t, refreshed.ExpiresAt.After(l.ExpiresAt))
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newLockRequest(http.MethodPost, "locked.mp4", "?refresh=true", ""))
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newLockRequest(http.MethodDelete, "locked.mp4", "", l.Token))
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Expired lock is ignored", func(t *testing.T) {
+			m, err := hdl.meta.Get("locked.mp4")
+			assert.Nil(t, err)
+			m.Lock = &meta.Lock{Token: "stale", Holder: "crashed", ExpiresAt: time.Now().Add(-time.Second)}
+			assert.Nil(t, hdl.meta.Put(m))
+
+			l := acquireLock(t, hdl, "locked.mp4", "")
+			assert.NotEqual(t, "stale", l.Token)
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newLockRequest(http.MethodDelete, "locked.mp4", "", l.Token))
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Invalid requests", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newLockRequest(http.MethodPost, "missing.mp4", "", ""))
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newLockRequest(http.MethodPost, "locked.mp4", "?ttl=100h", ""))
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newLockRequest(http.MethodDelete, "locked.mp4", "", ""))
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		},
+	)
+}
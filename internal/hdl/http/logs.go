@@ -0,0 +1,142 @@
+package http
+
+import (
+	"github.com/JMURv/media-server/internal/logs"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"golang.org/x/net/websocket"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLogBuffer = 1000
+	defaultLogLimit  = 500
+	logWriteTimeout  = 10 * time.Second
+)
+
+func parseLevel(v string) (slog.Level, error) {
+	var level slog.Level
+	if v == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(v)); err != nil {
+		return 0, ErrInvalidLevel
+	}
+	return level, nil
+}
+
+func (h *Handler) newLogBuffer() (*logs.Buffer, error) {
+	level, err := parseLevel(h.config.Logs.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	size := h.config.Logs.BufferSize
+	if size <= 0 {
+		size = defaultLogBuffer
+	}
+	return logs.New(size, level), nil
+}
+
+// logsRequest checks the admin role and the level filter shared by both log
+// endpoints, writing the error response when they fail.
+func (h *Handler) logsRequest(w http.ResponseWriter, r *http.Request) (slog.Level, bool) {
+	if h.logs == nil {
+		writeError(w, ErrLogsDisabled)
+		return 0, false
+	}
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return 0, false
+	}
+
+	level, err := parseLevel(r.URL.Query().Get("level"))
+	if err != nil {
+		writeError(w, err)
+		return 0, false
+	}
+	return level, true
+}
+
+func (h *Handler) recentLogs(w http.ResponseWriter, r *http.Request) {
+	level, ok := h.logsRequest(w, r)
+	if !ok {
+		return
+	}
+
+	limit := defaultLogLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, ErrInvalidLimit)
+			return
+		}
+		limit = n
+	}
+
+	res := h.logs.Recent(limit, level)
+	if res == nil {
+		res = []logs.Record{}
+	}
+	utils.JSONResponse(w, http.StatusOK, res)
+}
+
+func (h *Handler) tailLogs(w http.ResponseWriter, r *http.Request) {
+	level, ok := h.logsRequest(w, r)
+	if !ok {
+		return
+	}
+
+	srv := websocket.Server{
+		Handshake: sameOrigin,
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			sub := h.logs.Subscribe(level)
+			defer h.logs.Unsubscribe(sub)
+
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				io.Copy(io.Discard, ws)
+			}()
+
+			for {
+				select {
+				case <-h.ctx.Done():
+					return
+				case <-closed:
+					return
+				case rec, ok := <-sub.C:
+					if !ok {
+						return
+					}
+					ws.SetWriteDeadline(time.Now().Add(logWriteTimeout))
+					if err := websocket.JSON.Send(ws, rec); err != nil {
+						return
+					}
+				}
+			}
+		},
+	}
+	srv.ServeHTTP(w, r)
+}
+
+// sameOrigin refuses WebSocket handshakes a browser makes from another
+// site's page. Browsers always send an Origin; clients that send none are
+// let through to the usual token checks.
+func sameOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin != nil && !strings.EqualFold(origin.Host, r.Host) {
+		return websocket.ErrBadWebSocketOrigin
+	}
+	config.Origin = origin
+	return nil
+}
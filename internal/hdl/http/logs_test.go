@@ -0,0 +1,151 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/logs"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminLogs(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize:   1024 * 1024,
+			MaxStreamBuffer: 1024,
+			AdminToken:      "admin-secret",
+			Logs:            &config.LogsConfig{BufferSize: 5, Level: "info"},
+		},
+	)
+	srv := httptest.NewServer(hdl)
+	defer srv.Close()
+
+	recent := func(query string) []logs.Record {
+		req := httptest.NewRequest(http.MethodGet, "/admin/logs"+query, nil)
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var res []logs.Record
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res
+	}
+
+	t.Run(
+		"Admin role required", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/logs", nil))
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+
+			rec = httptest.NewRecorder()
+			setupTestHandler(testDir).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/logs", nil))
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Recent records are redacted and filtered", func(t *testing.T) {
+			slog.Debug("not retained")
+			log.Printf("Calling backend with Authorization: Bearer abc123 and ?token=s3cr3t&x=1\n")
+			slog.Info("webhook sent", "api_key", "k-123", "url", "https://hook?sig=deadbeef")
+			log.Printf("Error writing audit entry: disk full\n")
+
+			res := recent("?limit=3")
+			if assert.Len(t, res, 3) {
+				assert.Equal(t, "Calling backend with Authorization: Bearer [REDACTED] and ?token=[REDACTED]&x=1", res[0].Message)
+				assert.Equal(t, "[REDACTED]", res[1].Attrs["api_key"])
+				assert.Equal(t, "https://hook?sig=[REDACTED]", res[1].Attrs["url"])
+				assert.Equal(t, "ERROR", res[2].Level)
+			}
+			for _, r := range recent("") {
+				assert.NotEqual(t, "not retained", r.Message)
+			}
+
+			errs := recent("?level=error")
+			if assert.NotEmpty(t, errs) {
+				assert.Equal(t, "Error writing audit entry: disk full", errs[len(errs)-1].Message)
+			}
+
+			for i := 0; i < 10; i++ {
+				log.Printf("filler %d\n", i)
+			}
+			assert.Len(t, recent(""), 5)
+		},
+	)
+
+	t.Run(
+		"Invalid parameters", func(t *testing.T) {
+			for _, q := range []string{"?limit=0", "?level=loud"} {
+				req := httptest.NewRequest(http.MethodGet, "/admin/logs"+q, nil)
+				req.Header.Set("Authorization", "Bearer admin-secret")
+				rec := httptest.NewRecorder()
+				hdl.ServeHTTP(rec, req)
+				assert.Equal(t, http.StatusBadRequest, rec.Code)
+			}
+		},
+	)
+
+	t.Run(
+		"Live tail over WebSocket", func(t *testing.T) {
+			wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/admin/logs/ws?level=warn"
+			cfg, err := websocket.NewConfig(wsURL, srv.URL)
+			assert.Nil(t, err)
+
+			_, err = websocket.DialConfig(cfg)
+			assert.NotNil(t, err)
+
+			cfg.Header.Set("Authorization", "Bearer admin-secret")
+			cross, err := websocket.NewConfig(wsURL, "http://evil.example")
+			assert.Nil(t, err)
+			cross.Header = cfg.Header
+			_, err = websocket.DialConfig(cross)
+			assert.NotNil(t, err)
+
+			ws, err := websocket.DialConfig(cfg)
+			if !assert.Nil(t, err) {
+				return
+			}
+			defer ws.Close()
+
+			assert.Eventually(
+				t, func() bool {
+					log.Printf("Error tailing probe password=hunter2\n")
+					ws.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+					rec := logs.Record{}
+					if websocket.JSON.Receive(ws, &rec) != nil {
+						return false
+					}
+					assert.Equal(t, "ERROR", rec.Level)
+					assert.Equal(t, "Error tailing probe password=[REDACTED]", rec.Message)
+					return true
+				}, time.Second, 10*time.Millisecond,
+			)
+		},
+	)
+
+	t.Run(
+		"Slow consumers are dropped", func(t *testing.T) {
+			sub := hdl.logs.Subscribe(slog.LevelInfo)
+			for i := 0; i < 200; i++ {
+				log.Printf("burst %d\n", i)
+			}
+
+			n := 0
+			for range sub.C {
+				n++
+			}
+			assert.Less(t, n, 200)
+			hdl.logs.Unsubscribe(sub)
+		},
+	)
+}
@@ -0,0 +1,167 @@
+package http
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/storage"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	manifestCSV        = "csv"
+	manifestJSONL      = "jsonl"
+	manifestFlushEvery = 1000
+)
+
+var manifestColumns = []string{
+	"name", "size", "mtime", "checksum", "content_type", "tags", "downloads", "backend",
+}
+
+type ManifestRecord struct {
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mtime"`
+	Checksum    string    `json:"checksum,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Downloads   int64     `json:"downloads"`
+	Backend     string    `json:"backend"`
+}
+
+func (rec *ManifestRecord) row() []string {
+	return []string{
+		rec.Name,
+		strconv.FormatInt(rec.Size, 10),
+		rec.ModTime.Format(time.RFC3339Nano),
+		rec.Checksum,
+		rec.ContentType,
+		strings.Join(rec.Tags, ";"),
+		strconv.FormatInt(rec.Downloads, 10),
+		rec.Backend,
+	}
+}
+
+// countDownload records a download of name. Revalidations and ranges that
+// do not start at the beginning of the file, such as seeking through a video,
+// are not counted. Counted downloads are the reads sampled for comparison
+// with a shadow backend.
+func (h *Handler) countDownload(r *http.Request, name string) {
+	if r.Method != http.MethodGet || r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+		return
+	}
+	if rng := r.Header.Get("Range"); rng != "" && !strings.HasPrefix(rng, "bytes=0-") {
+		return
+	}
+	h.downloads.Inc(name)
+	h.compareShadow(name)
+}
+
+func (h *Handler) manifestRecord(name string, info fs.FileInfo) *ManifestRecord {
+	rec := &ManifestRecord{
+		Name:      name,
+		Size:      info.Size(),
+		ModTime:   info.ModTime().UTC(),
+		Downloads: h.downloads.Get(name),
+		Backend:   storage.TypeLocal,
+	}
+	if m, err := h.meta.Get(name); err == nil {
+		rec.Checksum, rec.ContentType, rec.Tags = m.Checksum, m.ContentType, m.Tags
+		if m.Backend != "" {
+			rec.Backend = m.Backend
+		}
+	}
+	return rec
+}
+
+// exportManifest streams one record per listed file. Entries are written as
+// the directory is read, in batches, so memory does not grow with the store.
+func (h *Handler) exportManifest(w http.ResponseWriter, r *http.Request) {
+	if !h.canRead(r) {
+		writeError(w, ErrReadRequired)
+		return
+	}
+	withHidden, err := h.showHidden(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = manifestJSONL
+	}
+	if format != manifestCSV && format != manifestJSONL {
+		writeError(w, ErrInvalidFormat)
+		return
+	}
+
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		if since, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			writeError(w, ErrInvalidSince)
+			return
+		}
+	}
+
+	var write func(*ManifestRecord) error
+	var flush func() error
+	rc := http.NewResponseController(w)
+	if format == manifestCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="manifest.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write(manifestColumns)
+		write = func(rec *ManifestRecord) error { return cw.Write(rec.row()) }
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="manifest.jsonl"`)
+		enc := json.NewEncoder(w)
+		write = func(rec *ManifestRecord) error { return enc.Encode(rec) }
+		flush = func() error { return nil }
+	}
+	w.WriteHeader(http.StatusOK)
+
+	count := 0
+	_, err = h.walkRootEntries(
+		r.Context(), false, 0, func(root, _ string, d fs.DirEntry) error {
+			if d.IsDir() || !h.listable(d) || (!withHidden && h.hidden(d.Name())) {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil || !info.ModTime().After(since) {
+				return nil
+			}
+			info = h.storedInfo(filepath.Join(root, d.Name()), info)
+			if err = write(h.manifestRecord(d.Name(), info)); err != nil {
+				return err
+			}
+
+			if count++; count%manifestFlushEvery == 0 {
+				if err = flush(); err != nil {
+					return err
+				}
+				rc.Flush()
+			}
+			return nil
+		},
+	)
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		log.Printf("Error exporting manifest after %d records: %s\n", count, err)
+		return
+	}
+	log.Printf("Exported manifest with %d records\n", count)
+}
@@ -0,0 +1,146 @@
+package http
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManifestExport(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize:   1024 * 1024,
+			MaxStreamBuffer: 1024,
+			AdminToken:      "admin-secret",
+			ReadToken:       "read-secret",
+		},
+	)
+	do := func(path, token string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	jsonl := func(path string) []ManifestRecord {
+		rec := do(path, "read-secret", nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+
+		var res []ManifestRecord
+		scanner := bufio.NewScanner(rec.Body)
+		for scanner.Scan() {
+			m := ManifestRecord{}
+			assert.Nil(t, json.Unmarshal(scanner.Bytes(), &m))
+			res = append(res, m)
+		}
+		return res
+	}
+
+	rec := httptest.NewRecorder()
+	hdl.ServeHTTP(rec, newUploadRequest("clip.mp4", "video", map[string]string{"tags": "raw,2024"}))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Nil(t, os.WriteFile(filepath.Join(testDir, ".draft.mp4"), []byte("draft"), 0644))
+
+	old := time.Now().Add(-48 * time.Hour)
+	assert.Nil(t, os.WriteFile(filepath.Join(testDir, "old.txt"), []byte("old"), 0644))
+	assert.Nil(t, os.Chtimes(filepath.Join(testDir, "old.txt"), old, old))
+
+	t.Run(
+		"Read or admin role required", func(t *testing.T) {
+			assert.Equal(t, http.StatusForbidden, do("/export/manifest", "", nil).Code)
+			assert.Equal(t, http.StatusForbidden, do("/export/manifest", "wrong", nil).Code)
+			assert.Equal(t, http.StatusOK, do("/export/manifest", "admin-secret", nil).Code)
+
+			rec := httptest.NewRecorder()
+			setupTestHandler(testDir).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/export/manifest", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+		},
+	)
+
+	t.Run(
+		"JSONL with metadata and download counts", func(t *testing.T) {
+			assert.Equal(t, http.StatusOK, do("/uploads/clip.mp4", "", nil).Code)
+			do("/uploads/clip.mp4", "", map[string]string{"Range": "bytes=2-"})
+			do("/uploads/clip.mp4", "", map[string]string{"If-None-Match": `"x"`})
+
+			records := map[string]ManifestRecord{}
+			for _, m := range jsonl("/export/manifest") {
+				records[m.Name] = m
+			}
+			assert.NotContains(t, records, ".draft.mp4")
+
+			clip := records["clip.mp4"]
+			assert.Equal(t, int64(5), clip.Size)
+			assert.NotEmpty(t, clip.Checksum)
+			assert.Equal(t, []string{"raw", "2024"}, clip.Tags)
+			assert.Equal(t, int64(1), clip.Downloads)
+			assert.Equal(t, "local", clip.Backend)
+			assert.Equal(t, int64(3), records["old.txt"].Size)
+		},
+	)
+
+	t.Run(
+		"Incremental export with since", func(t *testing.T) {
+			since := url.QueryEscape(time.Now().Add(-time.Hour).Format(time.RFC3339))
+			var names []string
+			for _, m := range jsonl("/export/manifest?since=" + since) {
+				names = append(names, m.Name)
+			}
+			assert.Equal(t, []string{"clip.mp4"}, names)
+
+			assert.Equal(t, http.StatusBadRequest, do("/export/manifest?since=yesterday", "read-secret", nil).Code)
+			assert.Equal(t, http.StatusBadRequest, do("/export/manifest?format=xml", "read-secret", nil).Code)
+		},
+	)
+
+	t.Run(
+		"CSV", func(t *testing.T) {
+			rec := do("/export/manifest?format=csv", "read-secret", nil)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Contains(t, rec.Header().Get("Content-Type"), "text/csv")
+
+			rows, err := csv.NewReader(rec.Body).ReadAll()
+			assert.Nil(t, err)
+			assert.Equal(t, manifestColumns, rows[0])
+			assert.Len(t, rows, 3)
+			for _, row := range rows[1:] {
+				if row[0] == "clip.mp4" {
+					assert.Equal(t, "raw;2024", row[5])
+					assert.Equal(t, "1", row[6])
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"Download counts persist and reset on delete", func(t *testing.T) {
+			assert.Nil(t, hdl.downloads.Save())
+			d, err := meta.OpenDownloads(testDir)
+			assert.Nil(t, err)
+			assert.Equal(t, int64(1), d.Get("clip.mp4"))
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/files/clip.mp4", nil))
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+			assert.Equal(t, int64(0), hdl.downloads.Get("clip.mp4"))
+		},
+	)
+}
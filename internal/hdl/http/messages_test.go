@@ -0,0 +1,108 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/JMURv/media-server/pkg/messages"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorMessages(t *testing.T) {
+	testDir := t.TempDir()
+
+	do := func(hdl *Handler, req *http.Request) (*httptest.ResponseRecorder, utils.ErrorResponse) {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		res := utils.ErrorResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return rec, res
+	}
+	missing := func(lang string) *http.Request {
+		req := httptest.NewRequest(http.MethodDelete, "/files/missing.txt", nil)
+		if lang != "" {
+			req.Header.Set("Accept-Language", lang)
+		}
+		return req
+	}
+
+	t.Run(
+		"Every code has an English message", func(t *testing.T) {
+			for _, s := range statusCodes {
+				_, ok := messages.Message(messages.Fallback, s.code, nil)
+				assert.True(t, ok, s.code)
+			}
+			assert.Equal(t, "internal_error", ErrorCode(ErrInternal))
+			assert.Equal(t, "file_exists", ErrorCode(ErrAlreadyExists))
+			assert.Equal(t, "internal_error", ErrorCode(assert.AnError))
+		},
+	)
+
+	t.Run(
+		"Codes without localization", func(t *testing.T) {
+			hdl := New(port, testDir, &config.HTTPConfig{MaxUploadSize: 1024})
+			rec, res := do(hdl, missing("ru"))
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+			assert.Equal(t, ErrNotFound.Error(), res.Error)
+			assert.Equal(t, "file_not_found", res.Code)
+			assert.Empty(t, res.Message)
+			assert.Empty(t, rec.Header().Get("Content-Language"))
+		},
+	)
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize: 1024,
+			Messages:      &config.MessagesConfig{DefaultLanguage: "ru"},
+		},
+	)
+
+	t.Run(
+		"Language follows Accept-Language", func(t *testing.T) {
+			rec, res := do(hdl, missing("en-US,en;q=0.9"))
+			assert.Equal(t, "file_not_found", res.Code)
+			assert.Equal(t, "The file was not found.", res.Message)
+			assert.Equal(t, "en", rec.Header().Get("Content-Language"))
+
+			rec, res = do(hdl, missing("fr"))
+			assert.Equal(t, ErrNotFound.Error(), res.Error)
+			assert.Equal(t, "file_not_found", res.Code)
+			assert.Equal(t, "Файл не найден.", res.Message)
+			assert.Equal(t, "ru", rec.Header().Get("Content-Language"))
+		},
+	)
+
+	t.Run(
+		"Params are returned and filled in", func(t *testing.T) {
+			req := newUploadRequest("big.txt", string(make([]byte, 2048)), nil)
+			req.Header.Set("Accept-Language", "en")
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+			res := utils.TooLargeResponse{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.Equal(t, "file_too_big", res.Code)
+			assert.Equal(t, int64(1024), res.Limit)
+			assert.Equal(t, float64(1024), res.Params["limit"])
+			assert.Equal(t, "The file exceeds the size limit of 1024 bytes.", res.Message)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("a.txt", "a", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			req = newUploadRequest("a.txt", "a", nil)
+			req.Header.Set("Accept-Language", "en")
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusConflict, rec.Code)
+			res2 := utils.ErrorResponse{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res2))
+			assert.Equal(t, "file_exists", res2.Code)
+			assert.Equal(t, "a.txt", res2.Params["name"])
+		},
+	)
+}
@@ -0,0 +1,450 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/storage"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	multipartDir           = ".mpu"
+	multipartSessionFile   = "upload.json"
+	checksumHeader         = "X-Checksum-Sha256"
+	defaultMultipartTTL    = 24 * time.Hour
+	defaultMinPartSize     = 5 << 20
+	defaultMaxParts        = 10000
+	minMultipartGCInterval = time.Minute
+)
+
+type MultipartUpload struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type MultipartPart struct {
+	Number   int    `json:"number"`
+	Size     int64  `json:"size,omitempty"`
+	Checksum string `json:"checksum"`
+}
+
+type multipartRequest struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+}
+
+type completeRequest struct {
+	Parts    []MultipartPart `json:"parts"`
+	Checksum string          `json:"checksum"`
+}
+
+func (h *Handler) multipartTTL() time.Duration {
+	if h.config.Multipart.TTL > 0 {
+		return h.config.Multipart.TTL
+	}
+	return defaultMultipartTTL
+}
+
+func (h *Handler) minPartSize() int64 {
+	if h.config.Multipart.MinPartSize > 0 {
+		return h.config.Multipart.MinPartSize
+	}
+	return defaultMinPartSize
+}
+
+func (h *Handler) maxParts() int {
+	if h.config.Multipart.MaxParts > 0 {
+		return h.config.Multipart.MaxParts
+	}
+	return defaultMaxParts
+}
+
+func (h *Handler) multipartPath(id string, elem ...string) string {
+	return filepath.Join(append([]string{h.savePath, multipartDir, id}, elem...)...)
+}
+
+func partFile(n int) string {
+	return fmt.Sprintf("%05d.part", n)
+}
+
+func (h *Handler) loadMultipart(id string) (*MultipartUpload, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return nil, ErrUploadNotFound
+	}
+
+	data, err := os.ReadFile(h.multipartPath(id, multipartSessionFile))
+	if err != nil {
+		return nil, ErrUploadNotFound
+	}
+
+	u := &MultipartUpload{}
+	if err = json.Unmarshal(data, u); err != nil {
+		return nil, err
+	}
+	if time.Now().After(u.ExpiresAt) {
+		os.RemoveAll(h.multipartPath(id))
+		return nil, ErrUploadNotFound
+	}
+	return u, nil
+}
+
+func (h *Handler) pruneMultipart(now time.Time) {
+	entries, err := os.ReadDir(filepath.Join(h.savePath, multipartDir))
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		data, err := os.ReadFile(h.multipartPath(e.Name(), multipartSessionFile))
+		if err != nil && !os.IsNotExist(err) {
+			continue
+		}
+
+		u := &MultipartUpload{}
+		if err != nil || json.Unmarshal(data, u) != nil || now.After(u.ExpiresAt) {
+			log.Printf("Removing expired multipart upload %s\n", e.Name())
+			os.RemoveAll(h.multipartPath(e.Name()))
+		}
+	}
+}
+
+func (h *Handler) runMultipartGC(ctx context.Context) {
+	ticker := time.NewTicker(max(h.multipartTTL()/4, minMultipartGCInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.pruneMultipart(now)
+		}
+	}
+}
+
+func (h *Handler) multipartEnabled(w http.ResponseWriter) bool {
+	if h.config.Multipart == nil {
+		writeError(w, ErrMultipartDisabled)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) createMultipart(w http.ResponseWriter, r *http.Request) {
+	if !h.multipartEnabled(w) {
+		return
+	}
+
+	req := &multipartRequest{}
+	if !h.decodeJSON(w, r, req) {
+		return
+	}
+
+	name, err := h.cleanName(req.Name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !h.checkInternal(w, r, name) || !h.authorizeName(w, r, ActionUpload, name) {
+		return
+	}
+	if req.ContentType != "" {
+		if _, _, err = mime.ParseMediaType(req.ContentType); err != nil {
+			writeError(w, ErrInvalidContentType)
+			return
+		}
+	}
+	if existing, ok := h.resolveName(name); ok {
+		writeError(w, existsError(name, existing))
+		return
+	}
+	if !h.checkDirCap(w, h.filePath(name)) {
+		return
+	}
+
+	now := time.Now().UTC()
+	h.pruneMultipart(now)
+
+	u := &MultipartUpload{
+		ID:          newUUID(),
+		Name:        name,
+		ContentType: req.ContentType,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(h.multipartTTL()),
+	}
+	data, err := json.Marshal(u)
+	if err == nil {
+		err = os.MkdirAll(h.multipartPath(u.ID), os.ModePerm)
+	}
+	if err == nil {
+		err = os.WriteFile(h.multipartPath(u.ID, multipartSessionFile), data, 0644)
+	}
+	if err != nil {
+		log.Printf("Error creating multipart upload for %s: %s\n", name, err)
+		os.RemoveAll(h.multipartPath(u.ID))
+		writeError(w, ErrInternal)
+		return
+	}
+
+	log.Printf("Multipart upload %s started for %s\n", u.ID, name)
+	utils.JSONResponse(w, http.StatusCreated, u)
+}
+
+func (h *Handler) uploadPart(w http.ResponseWriter, r *http.Request) {
+	if !h.multipartEnabled(w) {
+		return
+	}
+
+	u, err := h.loadMultipart(r.PathValue("id"))
+	if err != nil {
+		writeError(w, ErrUploadNotFound)
+		return
+	}
+
+	n, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil || n < 1 || n > h.maxParts() {
+		writeError(w, ErrInvalidPartNumber)
+		return
+	}
+
+	if r.ContentLength > h.maxFileSize() {
+		h.tooLarge(w, nil, h.maxFileSize())
+		return
+	}
+
+	tmp, err := os.CreateTemp(h.multipartPath(u.ID), ".part-*")
+	if err != nil {
+		writeError(w, ErrUploadNotFound)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	body := r.Body
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), http.MaxBytesReader(w, body, h.maxFileSize()))
+	if err = errors.Join(err, tmp.Close()); tooLargeErr(err) {
+		h.tooLarge(w, body, h.maxFileSize())
+		return
+	}
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, withParams(ErrFileTooBig, map[string]any{"limit": h.maxFileSize()}))
+		return
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if want := r.Header.Get(checksumHeader); want != "" && !strings.EqualFold(want, checksum) {
+		writeError(w, ErrChecksumMismatch)
+		return
+	}
+
+	if err = os.Rename(tmp.Name(), h.multipartPath(u.ID, partFile(n))); err != nil {
+		writeError(w, ErrUploadNotFound)
+		return
+	}
+	utils.JSONResponse(w, http.StatusOK, MultipartPart{Number: n, Size: size, Checksum: checksum})
+}
+
+// assembleParts concatenates parts, in order, into a temp file, checking each
+// part against its listed checksum and the minimum part size.
+func (h *Handler) assembleParts(ctx context.Context, u *MultipartUpload, parts []MultipartPart) (string, int64, string, error) {
+	tmp, err := os.CreateTemp(h.tempDir, ".upload-*")
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	whole := sha256.New()
+	var total int64
+	for i, p := range parts {
+		file, err := os.Open(h.multipartPath(u.ID, partFile(p.Number)))
+		if err != nil {
+			tmp.Close()
+			return tmp.Name(), 0, "", ErrPartNotFound
+		}
+
+		part := sha256.New()
+		n, err := io.Copy(io.MultiWriter(tmp, whole, part), storage.Reader(ctx, file))
+		file.Close()
+		if err != nil {
+			tmp.Close()
+			return tmp.Name(), 0, "", err
+		}
+		if !strings.EqualFold(p.Checksum, hex.EncodeToString(part.Sum(nil))) {
+			tmp.Close()
+			return tmp.Name(), 0, "", fmt.Errorf("%w: part %d", ErrChecksumMismatch, p.Number)
+		}
+		if i < len(parts)-1 && n < h.minPartSize() {
+			tmp.Close()
+			return tmp.Name(), 0, "", fmt.Errorf("%w: part %d", ErrPartTooSmall, p.Number)
+		}
+		total += n
+	}
+	return tmp.Name(), total, hex.EncodeToString(whole.Sum(nil)), tmp.Close()
+}
+
+func (h *Handler) completeMultipart(w http.ResponseWriter, r *http.Request) {
+	if !h.multipartEnabled(w) {
+		return
+	}
+	if _, err := h.wantsReceipt(r); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	id := r.PathValue("id")
+	unlockUpload := h.fileMu.Lock(multipartDir + "/" + id)
+	defer unlockUpload()
+
+	u, err := h.loadMultipart(id)
+	if err != nil {
+		writeError(w, ErrUploadNotFound)
+		return
+	}
+	if !h.authorizeName(w, r, ActionUpload, u.Name) {
+		return
+	}
+
+	req := &completeRequest{}
+	if !h.decodeJSON(w, r, req) {
+		return
+	}
+	if len(req.Parts) == 0 {
+		writeError(w, ErrNoParts)
+		return
+	}
+
+	slices.SortFunc(req.Parts, func(a, b MultipartPart) int { return a.Number - b.Number })
+	for i, p := range req.Parts {
+		if p.Number < 1 || p.Number > h.maxParts() || (i > 0 && p.Number == req.Parts[i-1].Number) {
+			writeError(w, ErrInvalidPartNumber)
+			return
+		}
+	}
+
+	src, size, checksum, err := h.assembleParts(r.Context(), u, req.Parts)
+	if src != "" {
+		defer os.Remove(src)
+	}
+	if errors.Is(err, ErrPartNotFound) || errors.Is(err, ErrChecksumMismatch) || errors.Is(err, ErrPartTooSmall) {
+		errorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Error assembling multipart upload %s: %s\n", u.ID, err)
+		writeError(w, canceledOr(err, ErrInternal))
+		return
+	}
+	if req.Checksum != "" && !strings.EqualFold(req.Checksum, checksum) {
+		writeError(w, ErrChecksumMismatch)
+		return
+	}
+	if size > h.maxFileSize() {
+		writeError(w, withParams(ErrFileTooBig, map[string]any{"limit": h.maxFileSize()}))
+		return
+	}
+	if size == 0 && !h.allowEmptyFiles() {
+		writeError(w, ErrEmptyFile)
+		return
+	}
+
+	now := time.Now().UTC()
+	stored := &meta.File{
+		Name:         u.Name,
+		OriginalName: u.Name,
+		Size:         size,
+		Checksum:     checksum,
+		ContentType:  u.ContentType,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	kept := h.convertHEIF(r.Context(), src, stored)
+	if kept != "" {
+		defer os.Remove(kept)
+	}
+	if !h.probeImage(w, src, stored) {
+		return
+	}
+	validated, ok := h.validateUpload(w, r, src, stored)
+	if !ok {
+		return
+	}
+	if validated != u.Name {
+		if !h.authorizeName(w, r, ActionUpload, validated) {
+			return
+		}
+		u.Name, stored.Name = validated, validated
+	}
+
+	unlock := h.fileMu.Lock(u.Name)
+	defer unlock()
+
+	dstPath, root, err := h.placePath(u.Name, stored.Size)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	stored.Root = h.storedRoot(root)
+	if !h.checkDirCap(w, dstPath) {
+		return
+	}
+	defer h.intend(meta.OpPut, u.Name, stored)()
+	err = h.commitFile(src, dstPath, h.durableWrites(r))
+	if errors.Is(err, ErrAlreadyExists) {
+		existing, _ := h.resolveName(u.Name)
+		writeError(w, existsError(u.Name, existing))
+		return
+	}
+	if err != nil {
+		log.Printf("Error committing %s: %s\n", u.Name, err)
+		writeError(w, ErrInternal)
+		return
+	}
+	if err = os.RemoveAll(h.multipartPath(u.ID)); err != nil {
+		log.Printf("Error removing parts of %s: %s\n", u.ID, err)
+	}
+
+	h.locate(u.Name, root)
+	h.placeOriginal(u.Name, kept)
+	h.finishUpload(w, r, stored, nil)
+}
+
+func (h *Handler) abortMultipart(w http.ResponseWriter, r *http.Request) {
+	if !h.multipartEnabled(w) {
+		return
+	}
+
+	id := r.PathValue("id")
+	unlock := h.fileMu.Lock(multipartDir + "/" + id)
+	defer unlock()
+
+	u, err := h.loadMultipart(id)
+	if err != nil {
+		writeError(w, ErrUploadNotFound)
+		return
+	}
+	if err = os.RemoveAll(h.multipartPath(u.ID)); err != nil {
+		log.Printf("Error aborting multipart upload %s: %s\n", u.ID, err)
+		writeError(w, ErrInternal)
+		return
+	}
+
+	log.Printf("Multipart upload %s aborted\n", u.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
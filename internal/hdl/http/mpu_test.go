@@ -0,0 +1,177 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMultipartUpload(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize:   1024 * 1024,
+			MaxStreamBuffer: 1024,
+			Multipart:       &config.MultipartConfig{MinPartSize: 4, MaxParts: 5},
+		},
+	)
+
+	do := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(body)))
+		return rec
+	}
+	sum := func(data string) string {
+		s := sha256.Sum256([]byte(data))
+		return hex.EncodeToString(s[:])
+	}
+	initiate := func(name string) MultipartUpload {
+		rec := do(http.MethodPost, "/mpu", []byte(fmt.Sprintf(`{"name":%q}`, name)))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+
+		u := MultipartUpload{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&u))
+		return u
+	}
+	complete := func(id string, parts []MultipartPart, checksum string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(completeRequest{Parts: parts, Checksum: checksum})
+		return do(http.MethodPost, "/mpu/"+id+"/complete", body)
+	}
+
+	t.Run(
+		"Disabled by default", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			setupTestHandler(testDir).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/mpu", strings.NewReader(`{}`)))
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Parts uploaded out of order", func(t *testing.T) {
+			u := initiate("movie.mp4")
+			chunks := []string{"aaaa", "bbbb", "cc"}
+
+			var wg sync.WaitGroup
+			for i := len(chunks) - 1; i >= 0; i-- {
+				wg.Add(1)
+				go func(n int, data string) {
+					defer wg.Done()
+					req := httptest.NewRequest(
+						http.MethodPut, fmt.Sprintf("/mpu/%s/parts/%d", u.ID, n), strings.NewReader(data),
+					)
+					req.Header.Set(checksumHeader, sum(data))
+					rec := httptest.NewRecorder()
+					hdl.ServeHTTP(rec, req)
+					assert.Equal(t, http.StatusOK, rec.Code)
+				}(i+1, chunks[i])
+			}
+			wg.Wait()
+
+			parts := []MultipartPart{
+				{Number: 3, Checksum: sum("cc")},
+				{Number: 1, Checksum: sum("aaaa")},
+				{Number: 2, Checksum: sum("bbbb")},
+			}
+			rec := complete(u.ID, parts, sum("aaaabbbbcc"))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			data, err := os.ReadFile(filepath.Join(testDir, "movie.mp4"))
+			assert.Nil(t, err)
+			assert.Equal(t, "aaaabbbbcc", string(data))
+			assert.NoDirExists(t, filepath.Join(testDir, multipartDir, u.ID))
+
+			m, err := hdl.meta.Get("movie.mp4")
+			assert.Nil(t, err)
+			assert.Equal(t, sum("aaaabbbbcc"), m.Checksum)
+
+			assert.Equal(t, http.StatusNotFound, complete(u.ID, parts, "").Code)
+			assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/mpu", []byte(`{"name":"movie.mp4"}`)).Code)
+		},
+	)
+
+	t.Run(
+		"Checksums are verified", func(t *testing.T) {
+			u := initiate("checked.bin")
+			req := httptest.NewRequest(http.MethodPut, "/mpu/"+u.ID+"/parts/1", strings.NewReader("data"))
+			req.Header.Set(checksumHeader, sum("other"))
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+			assert.Equal(t, http.StatusOK, do(http.MethodPut, "/mpu/"+u.ID+"/parts/1", []byte("data")).Code)
+			assert.Equal(
+				t, http.StatusBadRequest, complete(u.ID, []MultipartPart{{Number: 1, Checksum: sum("other")}}, "").Code,
+			)
+			assert.Equal(
+				t, http.StatusBadRequest,
+				complete(u.ID, []MultipartPart{{Number: 1, Checksum: sum("data")}}, sum("other")).Code,
+			)
+			assert.NoFileExists(t, filepath.Join(testDir, "checked.bin"))
+		},
+	)
+
+	t.Run(
+		"Part limits", func(t *testing.T) {
+			u := initiate("limits.bin")
+			assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/mpu/"+u.ID+"/parts/0", []byte("x")).Code)
+			assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/mpu/"+u.ID+"/parts/6", []byte("x")).Code)
+
+			assert.Equal(t, http.StatusOK, do(http.MethodPut, "/mpu/"+u.ID+"/parts/1", []byte("ab")).Code)
+			assert.Equal(t, http.StatusOK, do(http.MethodPut, "/mpu/"+u.ID+"/parts/2", []byte("cd")).Code)
+
+			assert.Equal(t, http.StatusBadRequest, complete(u.ID, nil, "").Code)
+			assert.Equal(
+				t, http.StatusBadRequest,
+				complete(u.ID, []MultipartPart{{Number: 1, Checksum: sum("ab")}, {Number: 1, Checksum: sum("ab")}}, "").Code,
+			)
+			assert.Equal(
+				t, http.StatusBadRequest,
+				complete(u.ID, []MultipartPart{{Number: 1, Checksum: sum("ab")}, {Number: 3, Checksum: sum("ab")}}, "").Code,
+			)
+
+			rec := complete(u.ID, []MultipartPart{{Number: 1, Checksum: sum("ab")}, {Number: 2, Checksum: sum("cd")}}, "")
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Contains(t, rec.Body.String(), ErrPartTooSmall.Error())
+		},
+	)
+
+	t.Run(
+		"Abort removes parts", func(t *testing.T) {
+			u := initiate("aborted.bin")
+			assert.Equal(t, http.StatusOK, do(http.MethodPut, "/mpu/"+u.ID+"/parts/1", []byte("data")).Code)
+			assert.DirExists(t, filepath.Join(testDir, multipartDir, u.ID))
+
+			assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/mpu/"+u.ID, nil).Code)
+			assert.NoDirExists(t, filepath.Join(testDir, multipartDir, u.ID))
+			assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/mpu/"+u.ID, nil).Code)
+			assert.Equal(t, http.StatusNotFound, do(http.MethodPut, "/mpu/"+u.ID+"/parts/1", []byte("x")).Code)
+		},
+	)
+
+	t.Run(
+		"Expired uploads are collected", func(t *testing.T) {
+			u := initiate("stale.bin")
+			assert.Equal(t, http.StatusOK, do(http.MethodPut, "/mpu/"+u.ID+"/parts/1", []byte("data")).Code)
+
+			hdl.pruneMultipart(time.Now().Add(time.Hour))
+			assert.DirExists(t, filepath.Join(testDir, multipartDir, u.ID))
+
+			hdl.pruneMultipart(time.Now().Add(25 * time.Hour))
+			assert.NoDirExists(t, filepath.Join(testDir, multipartDir, u.ID))
+			assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/mpu/"+u.ID+"/complete", []byte(`{}`)).Code)
+		},
+	)
+}
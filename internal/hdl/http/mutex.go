@@ -0,0 +1,41 @@
+package http
+
+import "sync"
+
+type fileMutex struct {
+	mu    sync.Mutex
+	locks map[string]*refMutex
+}
+
+type refMutex struct {
+	sync.Mutex
+	refs int
+}
+
+func newFileMutex() *fileMutex {
+	return &fileMutex{
+		locks: make(map[string]*refMutex),
+	}
+}
+
+func (m *fileMutex) Lock(name string) func() {
+	m.mu.Lock()
+	l, ok := m.locks[name]
+	if !ok {
+		l = &refMutex{}
+		m.locks[name] = l
+	}
+	l.refs++
+	m.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		m.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(m.locks, name)
+		}
+		m.mu.Unlock()
+	}
+}
@@ -0,0 +1,185 @@
+package http
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/JMURv/media-server/internal/storage"
+	"github.com/JMURv/media-server/pkg/filename"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	NamingOriginal = "original"
+	NamingUUID     = "uuid"
+	NamingHash     = "hash"
+	NamingSlug     = "slug"
+)
+
+func (h *Handler) namingStrategy(r *http.Request) (string, error) {
+	return h.naming(r.FormValue("naming"))
+}
+
+func (h *Handler) naming(strategy string) (string, error) {
+	if strategy == "" {
+		strategy = h.config.Naming
+	}
+
+	switch strategy {
+	case "":
+		return NamingOriginal, nil
+	case NamingOriginal, NamingUUID, NamingHash, NamingSlug:
+		return strategy, nil
+	default:
+		return "", ErrInvalidNaming
+	}
+}
+
+func (h *Handler) cleanName(name string) (string, error) {
+	name, err := filename.Policy{
+		Mode:     h.config.FilenamePolicy,
+		MaxBytes: h.config.MaxFilenameBytes,
+	}.Apply(name)
+	if err != nil {
+		return "", classify(err, storage.ErrInvalidName)
+	}
+	return name, nil
+}
+
+// maxPathBytes caps names with directories as a whole, their segments
+// being capped by MaxFilenameBytes.
+const maxPathBytes = 4096
+
+// cleanPath cleans name segment by segment, so unlike cleanName it keeps
+// the directories in it. Empty segments, from doubled or trailing slashes,
+// are dropped; . and .. are refused rather than resolved.
+func (h *Handler) cleanPath(name string) (string, error) {
+	segments := make([]string, 0)
+	for _, seg := range strings.Split(name, "/") {
+		switch seg {
+		case "":
+			continue
+		case ".", "..":
+			return "", ErrDotSegment
+		}
+		seg, err := h.cleanName(seg)
+		if err != nil {
+			return "", err
+		}
+		segments = append(segments, seg)
+	}
+	if len(segments) == 0 {
+		return h.cleanName("")
+	}
+
+	name = strings.Join(segments, "/")
+	if len(name) > maxPathBytes {
+		return "", ErrNameTooLong
+	}
+	return name, nil
+}
+
+// checkEncodedSlash refuses r if its path has a %2F, which would put a
+// slash inside a segment, unless encodedSlashes allows it.
+func (h *Handler) checkEncodedSlash(r *http.Request) error {
+	if !h.config.EncodedSlashes && strings.Contains(strings.ToLower(r.URL.EscapedPath()), "%2f") {
+		return ErrEncodedSlash
+	}
+	return nil
+}
+
+// pathName returns the file the name path value of r names, which may be
+// in a directory.
+func (h *Handler) pathName(r *http.Request) (string, error) {
+	if err := h.checkEncodedSlash(r); err != nil {
+		return "", err
+	}
+	return h.cleanPath(r.PathValue("name"))
+}
+
+func storedName(strategy, original, checksum string) string {
+	ext := filepath.Ext(filename.Slug(original))
+	switch strategy {
+	case NamingUUID:
+		return newUUID() + ext
+	case NamingHash:
+		return checksum + ext
+	case NamingSlug:
+		return filename.Slug(original)
+	default:
+		return original
+	}
+}
+
+func newUUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func (h *Handler) setDisposition(w http.ResponseWriter, name string) {
+	if !h.config.RestoreOriginalName {
+		return
+	}
+
+	m, err := h.meta.Get(name)
+	if err != nil || m.OriginalName == "" || m.OriginalName == name {
+		return
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": m.OriginalName}))
+}
+
+func (h *Handler) withDisposition(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if err := h.checkEncodedSlash(r); err != nil {
+				writeError(w, err)
+				return
+			}
+			name := strings.TrimPrefix(r.URL.Path, "/")
+			if !h.checkInternal(w, r, name) {
+				return
+			}
+
+			h.setDisposition(w, filepath.Base(r.URL.Path))
+
+			path, err := h.checkPath(name)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			if h.serveFallback(w, r, name) {
+				return
+			}
+			if file, err := h.openStored(r.Context(), path); err == nil {
+				defer file.Close()
+
+				if info, err := file.Stat(); err == nil && !info.IsDir() {
+					m, err := h.meta.Get(name)
+					if err != nil {
+						m = nil
+					}
+					etag := fileETag(m, info)
+					w.Header().Set("ETag", etag)
+
+					if !h.applyContentPolicy(w, name, file) {
+						return
+					}
+					if !normalizeRange(w, r, etag, info) {
+						return
+					}
+					h.countDownload(r, name)
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		},
+	)
+}
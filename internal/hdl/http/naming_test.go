@@ -0,0 +1,301 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/JMURv/media-server/pkg/filename"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func newUploadRequest(name, content string, fields map[string]string) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for k, v := range fields {
+		writer.WriteField(k, v)
+	}
+	file, _ := writer.CreateFormFile("file", name)
+	file.Write([]byte(content))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func decodeUpload(t *testing.T, rec *httptest.ResponseRecorder) utils.UploadResponse {
+	res := utils.UploadResponse{}
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+	return res
+}
+
+func TestNamingStrategies(t *testing.T) {
+	testDir := t.TempDir()
+	hdl := setupTestHandler(testDir)
+
+	content := "naming strategy content"
+	sum := sha256.Sum256([]byte(content))
+	checksum := hex.EncodeToString(sum[:])
+
+	t.Run(
+		"UUID", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("Photo.JPG", content, map[string]string{"naming": NamingUUID}))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			res := decodeUpload(t, rec)
+			assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}\.jpg$`), res.Name)
+			assert.Equal(t, "Photo.JPG", res.OriginalName)
+			assert.FileExists(t, filepath.Join(testDir, res.Name))
+
+			m, err := hdl.meta.Get(res.Name)
+			assert.Nil(t, err)
+			assert.Equal(t, "Photo.JPG", m.OriginalName)
+			assert.Equal(t, checksum, m.Checksum)
+		},
+	)
+
+	t.Run(
+		"Hash with dedup", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("a.txt", content, map[string]string{"naming": NamingHash}))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, checksum+".txt", decodeUpload(t, rec).Name)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("b.txt", content, map[string]string{"naming": NamingHash}))
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			res := decodeUpload(t, rec)
+			assert.Equal(t, checksum+".txt", res.Name)
+			assert.Equal(t, "b.txt", res.OriginalName)
+		},
+	)
+
+	t.Run(
+		"Slug", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("Привет Мир (1).PNG", content, map[string]string{"naming": NamingSlug}))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, "privet-mir-1.png", decodeUpload(t, rec).Name)
+		},
+	)
+
+	t.Run(
+		"Global config", func(t *testing.T) {
+			hdl.config.Naming = NamingSlug
+			defer func() { hdl.config.Naming = "" }()
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("Crème Brûlée.txt", content, nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, "creme-brulee.txt", decodeUpload(t, rec).Name)
+		},
+	)
+
+	t.Run(
+		"Invalid strategy", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("x.txt", content, map[string]string{"naming": "random"}))
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Original name restored on download", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("clip.mp4", content, map[string]string{"naming": NamingUUID}))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			name := decodeUpload(t, rec).Name
+
+			req := httptest.NewRequest(http.MethodGet, "/stream/uploads/"+name, nil)
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Empty(t, rec.Header().Get("Content-Disposition"))
+
+			hdl.config.RestoreOriginalName = true
+			defer func() { hdl.config.RestoreOriginalName = false }()
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, `inline; filename=clip.mp4`, rec.Header().Get("Content-Disposition"))
+
+			assert.Nil(t, os.Remove(filepath.Join(testDir, name)))
+		},
+	)
+}
+
+func TestFilenamePolicy(t *testing.T) {
+	testDir := t.TempDir()
+	hdl := setupTestHandler(testDir)
+
+	t.Run(
+		"Sanitize", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("con.txt", "data", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			res := decodeUpload(t, rec)
+			assert.Equal(t, "_con.txt", res.Name)
+			assert.FileExists(t, filepath.Join(testDir, "_con.txt"))
+		},
+	)
+
+	t.Run(
+		"Reject", func(t *testing.T) {
+			hdl.config.FilenamePolicy = filename.ModeReject
+			defer func() { hdl.config.FilenamePolicy = "" }()
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("con.txt", "data", nil))
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Contains(t, rec.Body.String(), filename.ErrReservedName.Error())
+		},
+	)
+
+	t.Run(
+		"Unicode normalization", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("é.txt", "data", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("\u00e9.txt", "data", nil))
+			assert.Equal(t, http.StatusConflict, rec.Code)
+
+			req := httptest.NewRequest(http.MethodDelete, "/delete?filename=e%CC%81.txt", nil)
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+		},
+	)
+}
+
+func TestPathNames(t *testing.T) {
+	testDir := t.TempDir()
+	hdl := setupTestHandler(testDir)
+
+	do := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+	escape := func(name string) string {
+		return (&url.URL{Path: name}).EscapedPath()
+	}
+	seed := func(name string) {
+		p := filepath.Join(testDir, filepath.FromSlash(name))
+		assert.Nil(t, os.MkdirAll(filepath.Dir(p), os.ModePerm))
+		assert.Nil(t, os.WriteFile(p, []byte(name), 0644))
+	}
+
+	deep := strings.Repeat("nested/", 20) + "clip.mp4"
+	for _, name := range []string{"with space.mp4", "a+b.mp4", "видео.mp4", "shows/s01/e01.mp4", deep} {
+		t.Run(
+			name, func(t *testing.T) {
+				seed(name)
+
+				rec := do(http.MethodGet, "/stream/"+escape(name)+"?v=1")
+				assert.Equal(t, http.StatusOK, rec.Code)
+				assert.Equal(t, name, rec.Body.String())
+
+				rec = do(http.MethodGet, "/uploads/"+escape(name))
+				assert.Equal(t, http.StatusOK, rec.Code)
+				assert.Equal(t, name, rec.Body.String())
+
+				rec = do(http.MethodGet, "/meta/"+escape(name))
+				assert.Equal(t, http.StatusOK, rec.Code)
+				assert.Contains(t, rec.Body.String(), `"name":"`+name+`"`)
+				rec = httptest.NewRecorder()
+				hdl.ServeHTTP(
+					rec, httptest.NewRequest(http.MethodPatch, "/meta/"+escape(name), strings.NewReader(`{"available_from":null}`)),
+				)
+				assert.Equal(t, http.StatusOK, rec.Code)
+
+				assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/files/"+escape(name)).Code)
+				assert.NoFileExists(t, filepath.Join(testDir, filepath.FromSlash(name)))
+				assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/stream/"+escape(name)).Code)
+			},
+		)
+	}
+
+	t.Run(
+		"Doubled and trailing slashes", func(t *testing.T) {
+			for in, out := range map[string]string{
+				"shows//s01/e01.mp4": "shows/s01/e01.mp4",
+				"shows/s01/e01.mp4/": "shows/s01/e01.mp4",
+				"/e01.mp4":           "e01.mp4",
+			} {
+				name, err := hdl.cleanPath(in)
+				assert.Nil(t, err)
+				assert.Equal(t, out, name)
+			}
+
+			seed("e01.mp4")
+			assert.Equal(t, http.StatusOK, do(http.MethodGet, "/stream/e01.mp4/").Code)
+		},
+	)
+
+	t.Run(
+		"Dot segments", func(t *testing.T) {
+			for _, name := range []string{"..", "a/../b.mp4", "./a.mp4"} {
+				_, err := hdl.cleanPath(name)
+				assert.ErrorIs(t, err, ErrDotSegment, name)
+			}
+
+			seed("inside/a.mp4")
+			for _, target := range []string{
+				"/stream/inside/%2e%2e/%2e%2e/secret.mp4",
+				"/stream/%2E%2E/a.mp4",
+				"/files/%2e%2e/meta",
+				"/uploads/inside/%2e%2e/%2e%2e/secret.mp4",
+			} {
+				assert.NotEqual(t, http.StatusOK, do(http.MethodGet, target).Code, target)
+			}
+			assert.Equal(t, http.StatusBadRequest, do(http.MethodDelete, "/files/inside/%2e%2e").Code)
+			assert.DirExists(t, filepath.Join(testDir, "inside"))
+		},
+	)
+
+	t.Run(
+		"Encoded slashes", func(t *testing.T) {
+			seed("shows/s02/e01.mp4")
+			for _, target := range []string{
+				"/stream/shows%2Fs02%2Fe01.mp4",
+				"/uploads/shows%2fs02/e01.mp4",
+				"/files/shows%2Fs02%2Fe01.mp4/meta",
+			} {
+				rec := do(http.MethodGet, target)
+				assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+				assert.Contains(t, rec.Body.String(), "encoded_slash")
+			}
+			assert.Equal(t, http.StatusBadRequest, do(http.MethodDelete, "/files/shows%2Fs02%2Fe01.mp4").Code)
+
+			hdl.config.EncodedSlashes = true
+			defer func() { hdl.config.EncodedSlashes = false }()
+			assert.Equal(t, http.StatusOK, do(http.MethodGet, "/stream/shows%2Fs02%2Fe01.mp4").Code)
+			rec := do(http.MethodGet, "/files/shows%2Fs02%2Fe01.mp4/meta")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Contains(t, rec.Body.String(), `"name":"shows/s02/e01.mp4"`)
+		},
+	)
+
+	t.Run(
+		"Long paths", func(t *testing.T) {
+			_, err := hdl.cleanPath(strings.Repeat("a/", maxPathBytes/2+1))
+			assert.ErrorIs(t, err, ErrNameTooLong)
+			assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/stream/"+strings.Repeat("a/", maxPathBytes/2+1)).Code)
+		},
+	)
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package http
+
+func setNice(int, int) error {
+	return nil
+}
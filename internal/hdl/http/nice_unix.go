@@ -0,0 +1,9 @@
+//go:build unix
+
+package http
+
+import "syscall"
+
+func setNice(pid, nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, pid, nice)
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package http
+
+import "os"
+
+// openShared opens path for reading. An open descriptor keeps serving the
+// old contents after the file is deleted or renamed over.
+func openShared(path string, flag int) (*os.File, error) {
+	return os.OpenFile(path, flag, 0)
+}
+
+func isLocked(error) bool {
+	return false
+}
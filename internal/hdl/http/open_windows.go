@@ -0,0 +1,49 @@
+//go:build windows
+
+package http
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// openShared opens path for reading with FILE_SHARE_DELETE, so the file can
+// be deleted or renamed over while it is being served, as on POSIX.
+// Directories and other flags go through os.OpenFile.
+func openShared(path string, flag int) (*os.File, error) {
+	if flag != os.O_RDONLY {
+		return os.OpenFile(path, flag, 0)
+	}
+
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: err}
+	}
+	h, err := syscall.CreateFile(
+		p,
+		syscall.GENERIC_READ,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil,
+		syscall.OPEN_EXISTING,
+		syscall.FILE_ATTRIBUTE_NORMAL,
+		0,
+	)
+	if errors.Is(err, syscall.ERROR_ACCESS_DENIED) {
+		return os.OpenFile(path, flag, 0)
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(h), path), nil
+}
+
+func isLocked(err error) bool {
+	return errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation)
+}
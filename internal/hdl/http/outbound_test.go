@@ -0,0 +1,149 @@
+package http
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"github.com/JMURv/media-server/internal/outbound"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOutbound(t *testing.T) {
+	testDir := t.TempDir()
+
+	t.Run(
+		"Misconfiguration fails at startup", func(t *testing.T) {
+			empty := filepath.Join(t.TempDir(), "empty.pem")
+			assert.Nil(t, os.WriteFile(empty, []byte("not a certificate"), 0644))
+
+			for name, cfg := range map[string]*config.OutboundConfig{
+				"Proxy scheme":       {Proxy: "ftp://proxy:21"},
+				"Proxy host":         {Proxy: "http://"},
+				"Missing CA file":    {CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+				"Empty CA file":      {CAFile: empty},
+				"TLS version":        {MinTLSVersion: "1.1"},
+				"Timeout feature":    {Timeouts: map[string]time.Duration{"thumbnails": time.Second}},
+				"Source address":     {SourceAddr: "not-an-ip"},
+				"Address and device": {SourceAddr: "127.0.0.1", Interface: "lo"},
+				"Interface":          {Interface: "no-such-interface0"},
+			} {
+				assert.PanicsWithValue(
+					t, func() string {
+						_, err := outbound.New(cfg)
+						return "invalid outbound config: " + err.Error()
+					}(), func() {
+						New(port, testDir, &config.HTTPConfig{Outbound: cfg})
+					}, name,
+				)
+			}
+		},
+	)
+
+	t.Run(
+		"Webhooks go through the proxy", func(t *testing.T) {
+			var mu sync.Mutex
+			var seen []string
+			proxy := httptest.NewServer(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, r *http.Request) {
+						mu.Lock()
+						seen = append(seen, r.RequestURI)
+						mu.Unlock()
+					},
+				),
+			)
+			defer proxy.Close()
+
+			hdl := New(
+				port, testDir, &config.HTTPConfig{
+					MaxUploadSize: 1024,
+					Webhooks:      []*config.WebhookConfig{{URL: "http://hooks.example.invalid/events"}},
+					Outbound:      &config.OutboundConfig{Proxy: proxy.URL},
+				},
+			)
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("proxied.mp4", "video", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			assert.Eventually(
+				t, func() bool {
+					mu.Lock()
+					defer mu.Unlock()
+					return len(seen) > 0
+				}, 5*time.Second, 10*time.Millisecond,
+			)
+			mu.Lock()
+			assert.Equal(t, "http://hooks.example.invalid/events", seen[0])
+			mu.Unlock()
+		},
+	)
+
+	t.Run(
+		"Webhooks trust the CA bundle", func(t *testing.T) {
+			delivered := make(chan struct{}, 1)
+			hook := httptest.NewTLSServer(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, r *http.Request) {
+						select {
+						case delivered <- struct{}{}:
+						default:
+						}
+					},
+				),
+			)
+			defer hook.Close()
+
+			ca := filepath.Join(t.TempDir(), "ca.pem")
+			data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: hook.Certificate().Raw})
+			assert.Nil(t, os.WriteFile(ca, data, 0644))
+
+			hdl := New(
+				port, testDir, &config.HTTPConfig{
+					MaxUploadSize: 1024,
+					AdminToken:    "admin-secret",
+					Webhooks:      []*config.WebhookConfig{{URL: hook.URL}},
+					Outbound: &config.OutboundConfig{
+						Proxy:         outbound.ProxyDirect,
+						CAFile:        ca,
+						MinTLSVersion: "1.3",
+						SourceAddr:    "127.0.0.1",
+						Timeouts:      map[string]time.Duration{outbound.Webhooks: 5 * time.Second},
+					},
+				},
+			)
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("trusted.mp4", "video", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			select {
+			case <-delivered:
+			case <-time.After(5 * time.Second):
+				t.Fatal("webhook was not delivered")
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/admin/config", nil)
+			req.Header.Set("Authorization", "Bearer admin-secret")
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			res := ConfigResponse{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.Equal(t, outbound.ProxyDirect, res.Outbound.Proxy)
+			assert.Equal(t, ca, res.Outbound.CAFile)
+			assert.Equal(t, "1.3", res.Outbound.MinTLSVersion)
+			assert.Equal(t, "127.0.0.1", res.Outbound.SourceAddr)
+			assert.Equal(t, "5s", res.Outbound.Timeouts[outbound.Webhooks])
+			assert.Equal(t, "30s", res.Outbound.Timeouts[outbound.Replication])
+		},
+	)
+}
@@ -0,0 +1,164 @@
+package http
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	oversizeDrain        = 256 << 10
+	oversizeDrainTimeout = time.Second
+	uploadOverrideHeader = "X-Max-Upload-Override"
+
+	defaultMaxRequestBody = 1 << 20
+)
+
+// uploadRoutes take files in their bodies, capped by the upload limits
+// instead of the request body limit.
+var uploadRoutes = []string{
+	"POST /upload",
+	"PATCH /files/{name}",
+	"PUT /mpu/{id}/parts/{n}",
+	"POST /admin/import",
+}
+
+// defaultBodyLimits are routes whose bodies are capped below
+// MaxRequestBody unless BodyLimits says otherwise.
+var defaultBodyLimits = map[string]int64{
+	"POST /receipts/verify": maxReceiptBody,
+}
+
+// uploadLimit returns the body limit for r. Admins may replace MaxUploadSize
+// for one request with X-Max-Upload-Override, capped at AbsoluteMaxUploadSize;
+// the header is ignored for everyone else, or when no cap is configured.
+func (h *Handler) uploadLimit(r *http.Request) int64 {
+	limit := h.config.MaxUploadSize
+	v := r.Header.Get(uploadOverrideHeader)
+	if v == "" || h.config.AbsoluteMaxUploadSize <= 0 || !h.isAdmin(r) {
+		return limit
+	}
+
+	override, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || override <= 0 {
+		log.Printf("Ignoring invalid %s %q from %s\n", uploadOverrideHeader, v, r.RemoteAddr)
+		return limit
+	}
+	override = min(override, h.config.AbsoluteMaxUploadSize)
+
+	h.audit.Log(
+		"upload.override", r.URL.Path, r.RemoteAddr, map[string]any{
+			"requested": v,
+			"limit":     override,
+			"default":   limit,
+		},
+	)
+	return override
+}
+
+// limitUpload rejects a body whose declared length is over the upload limit
+// before reading any of it, and caps undeclared ones at the limit. It returns
+// the uncapped body and the limit applied, so the rest can be drained and the
+// limit reported when the cap is hit.
+func (h *Handler) limitUpload(w http.ResponseWriter, r *http.Request) (io.Reader, int64, bool) {
+	limit := h.uploadLimit(r)
+	if limit <= 0 {
+		return nil, limit, true
+	}
+	if r.ContentLength > limit {
+		h.tooLarge(w, nil, limit)
+		return nil, limit, false
+	}
+
+	body := r.Body
+	r.Body = http.MaxBytesReader(w, body, limit)
+	return body, limit, true
+}
+
+func tooLargeErr(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// tooLarge drains a bounded amount of what the client is still sending, so
+// closing the connection does not reset it before the response is read, and
+// writes the 413 with Connection: close.
+func (h *Handler) tooLarge(w http.ResponseWriter, body io.Reader, limit int64) {
+	if body != nil {
+		rc := http.NewResponseController(w)
+		rc.SetReadDeadline(time.Now().Add(oversizeDrainTimeout))
+		io.CopyN(io.Discard, body, oversizeDrain)
+		rc.SetReadDeadline(time.Time{})
+	}
+
+	writeTooLarge(w, ErrFileTooBig, limit)
+}
+
+func writeTooLarge(w http.ResponseWriter, err error, limit int64) {
+	w.Header().Set("Connection", "close")
+	utils.JSONResponse(
+		w, http.StatusRequestEntityTooLarge, utils.TooLargeResponse{
+			ErrorResponse: errorBody(w, http.StatusRequestEntityTooLarge, withParams(err, map[string]any{"limit": limit})),
+			Limit:         limit,
+		},
+	)
+}
+
+// bodyLimit returns the cap on the body of a request to pattern: its entry
+// in BodyLimits, or MaxRequestBody lowered to the route's own default.
+func (h *Handler) bodyLimit(pattern string) int64 {
+	if limit := h.config.BodyLimits[pattern]; limit > 0 {
+		return limit
+	}
+	limit := cmp.Or(h.config.MaxRequestBody, defaultMaxRequestBody)
+	if d, ok := defaultBodyLimits[pattern]; ok {
+		limit = min(limit, d)
+	}
+	return limit
+}
+
+// limitBody refuses a body whose declared length is over the limit of
+// pattern before reading any of it, and caps undeclared ones at the limit.
+// Upload routes are left to their own limits.
+func (h *Handler) limitBody(pattern string, next http.Handler) http.Handler {
+	if slices.Contains(uploadRoutes, pattern) {
+		return next
+	}
+	limit := h.bodyLimit(pattern)
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				writeTooLarge(w, ErrBodyTooLarge, limit)
+				return
+			}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			next.ServeHTTP(w, r)
+		},
+	)
+}
+
+// decodeJSON decodes the body of r into v, capped at the limit of its
+// route, and writes the error response when it cannot: 413 for a body over
+// the limit, 400 for any other failure.
+func (h *Handler) decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	limit := h.bodyLimit(r.Pattern)
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit)).Decode(v)
+	if tooLargeErr(err) {
+		writeTooLarge(w, ErrBodyTooLarge, limit)
+		return false
+	}
+	if err != nil {
+		writeError(w, ErrDecodeRequest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,244 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type unreadBody struct {
+	read bool
+}
+
+func (b *unreadBody) Read([]byte) (int, error) {
+	b.read = true
+	return 0, errors.New("body should not be read")
+}
+
+func TestOversizeUpload(t *testing.T) {
+	testDir := t.TempDir()
+
+	const limit = 64 * 1024
+	hdl := New(port, testDir, &config.HTTPConfig{MaxUploadSize: limit, MaxStreamBuffer: 1024})
+	srv := httptest.NewServer(hdl)
+	defer srv.Close()
+
+	assertTooLarge := func(res *http.Response) {
+		assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+		assert.True(t, res.Close || res.Header.Get("Connection") == "close")
+
+		body := utils.TooLargeResponse{}
+		assert.Nil(t, json.NewDecoder(res.Body).Decode(&body))
+		assert.Equal(t, ErrFileTooBig.Error(), body.Error)
+		assert.Equal(t, int64(limit), body.Limit)
+	}
+
+	t.Run(
+		"Declared length rejected before reading", func(t *testing.T) {
+			body := &unreadBody{}
+			req := httptest.NewRequest(http.MethodPost, "/upload", body)
+			req.ContentLength = limit + 1
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+
+			assertTooLarge(rec.Result())
+			assert.False(t, body.read)
+		},
+	)
+
+	t.Run(
+		"Declared length over the wire", func(t *testing.T) {
+			body, contentType := multipartBody("declared.bin", 4*1024*1024)
+			res, err := http.Post(srv.URL+"/upload", contentType, body)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+
+			assertTooLarge(res)
+			assert.NoFileExists(t, filepath.Join(testDir, "declared.bin"))
+		},
+	)
+
+	t.Run(
+		"Undeclared length stops at the limit", func(t *testing.T) {
+			body, contentType := multipartBody("chunked.bin", 4*1024*1024)
+			req, err := http.NewRequest(http.MethodPost, srv.URL+"/upload", io.MultiReader(body))
+			assert.Nil(t, err)
+			req.Header.Set("Content-Type", contentType)
+			assert.Equal(t, int64(0), req.ContentLength)
+
+			res, err := http.DefaultClient.Do(req)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+
+			assertTooLarge(res)
+			assert.NoFileExists(t, filepath.Join(testDir, "chunked.bin"))
+
+			entries, err := os.ReadDir(filepath.Join(testDir, tempDirName))
+			assert.Nil(t, err)
+			assert.Empty(t, entries)
+		},
+	)
+
+	t.Run(
+		"Within the limit", func(t *testing.T) {
+			body, contentType := multipartBody("small.bin", 1024)
+			res, err := http.Post(srv.URL+"/upload", contentType, body)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+			assert.Equal(t, http.StatusCreated, res.StatusCode)
+		},
+	)
+}
+
+func TestUploadOverride(t *testing.T) {
+	testDir := t.TempDir()
+
+	auditLog := filepath.Join(t.TempDir(), "audit.log")
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize:         1024,
+			AbsoluteMaxUploadSize: 8 * 1024,
+			AdminToken:            "admin-secret",
+			AuditLog:              auditLog,
+		},
+	)
+	upload := func(name string, size int, override string, admin bool) *httptest.ResponseRecorder {
+		req := newUploadRequest(name, strings.Repeat("x", size), nil)
+		req.Header.Set(uploadOverrideHeader, override)
+		if admin {
+			req.Header.Set("Authorization", "Bearer admin-secret")
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	limitOf := func(rec *httptest.ResponseRecorder) int64 {
+		body := utils.TooLargeResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&body))
+		return body.Limit
+	}
+
+	t.Run(
+		"Admins may raise the limit", func(t *testing.T) {
+			assert.Equal(t, http.StatusCreated, upload("big.bin", 4096, "6144", true).Code)
+
+			data, err := os.ReadFile(auditLog)
+			assert.Nil(t, err)
+			assert.Contains(t, string(data), `"action":"upload.override"`)
+			assert.Contains(t, string(data), `"limit":6144`)
+		},
+	)
+
+	t.Run(
+		"Overrides are capped", func(t *testing.T) {
+			rec := upload("huge.bin", 9*1024, "1000000", true)
+			assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+			assert.Equal(t, int64(8*1024), limitOf(rec))
+		},
+	)
+
+	t.Run(
+		"Others are held to the default", func(t *testing.T) {
+			rec := upload("sneaky.bin", 4096, "6144", false)
+			assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+			assert.Equal(t, int64(1024), limitOf(rec))
+
+			assert.Equal(t, http.StatusCreated, upload("small.bin", 100, "6144", false).Code)
+			assert.Equal(t, http.StatusCreated, upload("junk.bin", 100, "lots", true).Code)
+		},
+	)
+
+	t.Run(
+		"Ignored without an absolute cap", func(t *testing.T) {
+			hdl.config.AbsoluteMaxUploadSize = 0
+			defer func() { hdl.config.AbsoluteMaxUploadSize = 8 * 1024 }()
+
+			rec := upload("capless.bin", 4096, "6144", true)
+			assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+			assert.Equal(t, int64(1024), limitOf(rec))
+		},
+	)
+}
+
+func TestOversizeBody(t *testing.T) {
+	testDir := t.TempDir()
+
+	const limit = 1024
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize:   1 << 20,
+			MaxStreamBuffer: 1024,
+			MaxRequestBody:  limit,
+			BodyLimits:      map[string]int64{"PATCH /files/{name}/meta": 4 * limit},
+		},
+	)
+	assert.Nil(t, os.WriteFile(filepath.Join(testDir, "clip.mp4"), []byte("content"), 0644))
+
+	// entries is a JSON array of verify entries of about size bytes.
+	entries := func(size int) string {
+		e := `{"name":"clip.mp4","size":7},`
+		return "[" + strings.Repeat(e, size/len(e)) + e[:len(e)-1] + "]"
+	}
+	do := func(method, target string, body io.Reader, length int64) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, body)
+		req.ContentLength = length
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	assertTooLarge := func(rec *httptest.ResponseRecorder, limit int64) {
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+		assert.Equal(t, "close", rec.Header().Get("Connection"))
+
+		res := utils.TooLargeResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		assert.Equal(t, "body_too_large", res.Code)
+		assert.Equal(t, limit, res.Limit)
+	}
+
+	t.Run(
+		"Declared length rejected before reading", func(t *testing.T) {
+			body := &unreadBody{}
+			assertTooLarge(do(http.MethodPost, "/verify", body, limit+1), limit)
+			assert.False(t, body.read)
+
+			body = &unreadBody{}
+			assertTooLarge(do(http.MethodPost, "/verify", body, 1<<40), limit)
+			assert.False(t, body.read)
+		},
+	)
+
+	t.Run(
+		"Undeclared length cut off at the limit", func(t *testing.T) {
+			assertTooLarge(do(http.MethodPost, "/verify", strings.NewReader(entries(2*limit)), -1), limit)
+			assertTooLarge(do(http.MethodPatch, "/files/clip.mp4/meta", strings.NewReader(`{"title":"`+strings.Repeat("a", 8*limit)+`"}`), -1), 4*limit)
+		},
+	)
+
+	t.Run(
+		"Bodies within the limit", func(t *testing.T) {
+			body := entries(limit / 2)
+			assert.Equal(t, http.StatusOK, do(http.MethodPost, "/verify", strings.NewReader(body), int64(len(body))).Code)
+
+			body = `{"title":"` + strings.Repeat("a", 2*limit) + `"}`
+			assert.Equal(t, http.StatusOK, do(http.MethodPatch, "/files/clip.mp4/meta", strings.NewReader(body), -1).Code)
+		},
+	)
+
+	t.Run(
+		"Uploads keep their own limit", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("big.mp4", strings.Repeat("a", 8*limit), nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+		},
+	)
+}
@@ -0,0 +1,243 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/webhook"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func parseContentRange(v string) (int64, int64, error) {
+	unit, spec, ok := strings.Cut(strings.TrimSpace(v), " ")
+	if !ok || unit != "bytes" {
+		return 0, 0, ErrInvalidContentRange
+	}
+
+	rng, total, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, ErrInvalidContentRange
+	}
+
+	s, e, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, ErrInvalidContentRange
+	}
+
+	start, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, ErrInvalidContentRange
+	}
+
+	end, err := strconv.ParseInt(e, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, ErrInvalidContentRange
+	}
+
+	if total != "*" {
+		t, err := strconv.ParseInt(total, 10, 64)
+		if err != nil || end >= t {
+			return 0, 0, ErrInvalidContentRange
+		}
+	}
+	return start, end, nil
+}
+
+func fileChecksum(ctx context.Context, path string) (string, error) {
+	return hashFile(ctx, path, sha256.New())
+}
+
+func (h *Handler) maxFileSize() int64 {
+	if h.config.MaxFileSize > 0 {
+		return h.config.MaxFileSize
+	}
+	return h.config.MaxUploadSize
+}
+
+func (h *Handler) allowEmptyFiles() bool {
+	return h.config.AllowEmptyFiles == nil || *h.config.AllowEmptyFiles
+}
+
+func (h *Handler) patchFile(w http.ResponseWriter, r *http.Request) {
+	name, err := h.pathName(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// Writing in place would reuse the nonces of the chunks it replaces.
+	if h.crypt != nil {
+		writeError(w, ErrEncryptionUnsupported)
+		return
+	}
+
+	offset, length, ranged := int64(0), int64(0), false
+	if cr := r.Header.Get("Content-Range"); cr != "" {
+		start, end, err := parseContentRange(cr)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		offset, length, ranged = start, end-start+1, true
+
+		if r.ContentLength >= 0 && r.ContentLength != length {
+			writeError(w, ErrBodyLength)
+			return
+		}
+	}
+
+	limit := h.maxFileSize()
+	if ranged && offset+length > limit {
+		writeError(w, withParams(ErrFileTooBig, map[string]any{"limit": limit}))
+		return
+	}
+
+	path, err := h.checkPath(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	unlock := h.fileMu.Lock(name)
+	defer unlock()
+
+	if !h.checkLock(w, r, name) || !h.checkHold(w, name) {
+		return
+	}
+
+	info, err := os.Stat(path)
+	created := os.IsNotExist(err)
+	if created && !h.checkDirCap(w, path) {
+		return
+	}
+	if err == nil && linkCount(info) > 1 {
+		if err = unshareFile(path); err != nil {
+			log.Printf("Error unsharing hard link %s: %s\n", name, err)
+			writeError(w, ErrInternal)
+			return
+		}
+	}
+
+	defer h.intend(meta.OpPut, name, nil)()
+	file, err := os.OpenFile(path, h.openFlags(os.O_WRONLY|os.O_CREATE), 0644)
+	if err != nil {
+		writeError(w, ErrInternal)
+		return
+	}
+	defer file.Close()
+
+	info, err = file.Stat()
+	if err != nil {
+		writeError(w, ErrInternal)
+		return
+	}
+
+	size := info.Size()
+	rollback := func() {
+		if created {
+			file.Close()
+			os.Remove(path)
+			return
+		}
+		file.Truncate(size)
+	}
+
+	toRead := length
+	if !ranged {
+		offset = size
+		if r.ContentLength >= 0 && offset+r.ContentLength > limit {
+			rollback()
+			writeError(w, withParams(ErrFileTooBig, map[string]any{"limit": limit}))
+			return
+		}
+		toRead = limit - offset + 1
+	}
+
+	guard := h.guardUpload(w, r)
+	if guard != nil {
+		defer guard.Stop()
+	}
+
+	n, err := io.Copy(io.NewOffsetWriter(file, offset), io.LimitReader(r.Body, toRead))
+	if err != nil {
+		rollback()
+		if guard.aborted(w, r) {
+			return
+		}
+		writeError(w, ErrInternal)
+		return
+	}
+
+	if ranged {
+		extra, _ := io.ReadFull(r.Body, make([]byte, 1))
+		if n != length || extra > 0 {
+			rollback()
+			writeError(w, ErrBodyLength)
+			return
+		}
+	} else if offset+n > limit {
+		rollback()
+		writeError(w, withParams(ErrFileTooBig, map[string]any{"limit": limit}))
+		return
+	}
+	if created && n == 0 && !h.allowEmptyFiles() {
+		rollback()
+		writeError(w, ErrEmptyFile)
+		return
+	}
+
+	if h.durableWrites(r) {
+		if err = h.syncInPlace(file, created); err != nil {
+			log.Printf("Error syncing %s: %s\n", name, err)
+			writeError(w, ErrInternal)
+			return
+		}
+	}
+
+	if info, err = file.Stat(); err != nil {
+		writeError(w, ErrInternal)
+		return
+	}
+
+	checksum, err := fileChecksum(r.Context(), path)
+	if err != nil {
+		writeError(w, canceledOr(err, ErrInternal))
+		return
+	}
+
+	now := time.Now().UTC()
+	m, err := h.meta.Get(name)
+	if err != nil {
+		m = &meta.File{
+			Name:         name,
+			OriginalName: name,
+			CreatedAt:    now,
+		}
+	}
+	m.Size = info.Size()
+	m.Checksum = checksum
+	m.DetectedType = ""
+	m.UpdatedAt = now
+
+	if err = h.meta.Put(m); err != nil {
+		log.Printf("Error saving metadata for %s: %s\n", name, err)
+		writeError(w, ErrInternal)
+		return
+	}
+
+	log.Printf("File %s written at offset %d (%d bytes)\n", name, offset, n)
+	if created {
+		h.emit(webhook.FileCreated, name, r, map[string]any{"size": m.Size, "checksum": m.Checksum})
+		utils.JSONResponse(w, http.StatusCreated, m)
+		return
+	}
+
+	h.emit(webhook.FileUpdated, name, r, map[string]any{"offset": offset, "size": m.Size, "checksum": m.Checksum})
+	utils.JSONResponse(w, http.StatusOK, m)
+}
@@ -0,0 +1,147 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newPatchRequest(name, body, contentRange string) *http.Request {
+	req := httptest.NewRequest(http.MethodPatch, "/files/"+name, strings.NewReader(body))
+	if contentRange != "" {
+		req.Header.Set("Content-Range", contentRange)
+	}
+	return req
+}
+
+func TestPatchFile(t *testing.T) {
+	testDir := t.TempDir()
+	hdl := setupTestHandler(testDir)
+
+	t.Run(
+		"Append", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newPatchRequest("app.log", "first\n", ""))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newPatchRequest("app.log", "second\n", ""))
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			data, err := os.ReadFile(filepath.Join(testDir, "app.log"))
+			assert.Nil(t, err)
+			assert.Equal(t, "first\nsecond\n", string(data))
+
+			sum := sha256.Sum256(data)
+			m, err := hdl.meta.Get("app.log")
+			assert.Nil(t, err)
+			assert.Equal(t, hex.EncodeToString(sum[:]), m.Checksum)
+			assert.Equal(t, int64(len(data)), m.Size)
+		},
+	)
+
+	t.Run(
+		"Ranged write with hole", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newPatchRequest("sparse.mp4", "abc", "bytes 10-12/*"))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newPatchRequest("sparse.mp4", "XY", "bytes 0-1/13"))
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			req := httptest.NewRequest(http.MethodGet, "/stream/uploads/sparse.mp4", nil)
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			exp := append([]byte("XY"), make([]byte, 8)...)
+			exp = append(exp, "abc"...)
+			assert.Equal(t, exp, rec.Body.Bytes())
+		},
+	)
+
+	t.Run(
+		"Invalid content range", func(t *testing.T) {
+			for _, cr := range []string{"bytes 5-1/*", "items 0-1/*", "bytes 0-1", "bytes a-b/*", "bytes 0-9/5"} {
+				rec := httptest.NewRecorder()
+				hdl.ServeHTTP(rec, newPatchRequest("bad.log", "ab", cr))
+				assert.Equal(t, http.StatusBadRequest, rec.Code, cr)
+			}
+			assert.NoFileExists(t, filepath.Join(testDir, "bad.log"))
+		},
+	)
+
+	t.Run(
+		"Body length mismatch", func(t *testing.T) {
+			req := newPatchRequest("short.log", "ab", "bytes 0-9/*")
+			req.ContentLength = -1
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.NoFileExists(t, filepath.Join(testDir, "short.log"))
+		},
+	)
+
+	t.Run(
+		"Exceeds max file size", func(t *testing.T) {
+			hdl.config.MaxFileSize = 16
+			defer func() { hdl.config.MaxFileSize = 0 }()
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newPatchRequest("big.log", "a", "bytes 16-16/*"))
+			assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newPatchRequest("big.log", strings.Repeat("a", 10), ""))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			req := newPatchRequest("big.log", strings.Repeat("b", 10), "")
+			req.ContentLength = -1
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+			data, err := os.ReadFile(filepath.Join(testDir, "big.log"))
+			assert.Nil(t, err)
+			assert.Equal(t, strings.Repeat("a", 10), string(data))
+		},
+	)
+
+	t.Run(
+		"Concurrent appends do not interleave", func(t *testing.T) {
+			const writers, chunk = 16, 4096
+
+			var wg sync.WaitGroup
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(b byte) {
+					defer wg.Done()
+					req := httptest.NewRequest(
+						http.MethodPatch, "/files/concurrent.log", io.NopCloser(bytes.NewReader(bytes.Repeat([]byte{b}, chunk))),
+					)
+					req.SetPathValue("name", "concurrent.log")
+					hdl.patchFile(httptest.NewRecorder(), req)
+				}(byte('a' + i))
+			}
+			wg.Wait()
+
+			data, err := os.ReadFile(filepath.Join(testDir, "concurrent.log"))
+			assert.Nil(t, err)
+			assert.Len(t, data, writers*chunk)
+			for i := 0; i < len(data); i += chunk {
+				assert.Equal(t, bytes.Repeat(data[i:i+1], chunk), data[i:i+chunk])
+			}
+		},
+	)
+}
@@ -0,0 +1,172 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"github.com/JMURv/media-server/internal/imaging"
+	"github.com/JMURv/media-server/internal/jobs"
+	"github.com/JMURv/media-server/internal/meta"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const TaskPlaceholder = "placeholder"
+
+type ListEntry struct {
+	URL       string     `json:"url"`
+	Kind      string     `json:"kind,omitempty"`
+	Meta      *meta.File `json:"meta,omitempty"`
+	Available *bool      `json:"available,omitempty"`
+}
+
+type BackfillReport struct {
+	Enqueued   int  `json:"enqueued"`
+	Skipped    int  `json:"skipped"`
+	Incomplete bool `json:"incomplete"`
+}
+
+func (h *Handler) placeholderTask(ctx context.Context, name string) error {
+	file, err := h.openFile(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	contentType, err := h.contentType(name, file)
+	if err != nil || !strings.HasPrefix(contentType, "image/") {
+		return nil
+	}
+
+	ph, err := imaging.NewPlaceholder(file, h.imageLimits())
+	if err != nil && !errors.Is(err, imaging.ErrUnsupportedImage) && !errors.Is(err, imaging.ErrImageTooLarge) {
+		return err
+	}
+
+	unlock := h.fileMu.Lock(name)
+	defer unlock()
+
+	m, err2 := h.meta.Get(name)
+	if errors.Is(err2, meta.ErrNotFound) {
+		now := time.Now().UTC()
+		m, err2 = &meta.File{Name: name, OriginalName: name, CreatedAt: now, UpdatedAt: now}, nil
+		if info, err := os.Stat(h.filePath(name)); err == nil {
+			m.Size = info.Size()
+		}
+	}
+	if err2 != nil {
+		return err2
+	}
+
+	if err != nil {
+		log.Printf("Skipping placeholder for %s: %s\n", name, err)
+		m.BlurHash, m.DominantColor, m.PlaceholderError = "", "", err.Error()
+	} else {
+		m.BlurHash, m.DominantColor, m.PlaceholderError = ph.BlurHash, ph.DominantColor, ""
+	}
+	return h.meta.Put(m)
+}
+
+func (h *Handler) fileMeta(w http.ResponseWriter, r *http.Request) {
+	name, err := h.pathName(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !h.checkInternal(w, r, name) {
+		return
+	}
+
+	m, err := h.meta.Get(name)
+	if err == nil {
+		utils.JSONResponse(w, http.StatusOK, withoutExpiredHold(m))
+		return
+	}
+
+	info, err := h.statFile(name)
+	if err != nil || info.IsDir() {
+		writeError(w, h.deletedError(name))
+		return
+	}
+	utils.JSONResponse(w, http.StatusOK, &meta.File{Name: name, Size: info.Size(), UpdatedAt: info.ModTime().UTC()})
+}
+
+func (h *Handler) backfillPlaceholders(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+	if h.jobs == nil {
+		writeError(w, ErrJobsDisabled)
+		return
+	}
+	force := r.URL.Query().Get("force") == "true"
+
+	report := &BackfillReport{}
+	err := h.walkRootsDir(
+		func(root, p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if p != root && strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.Type()&fs.ModeSymlink != 0 {
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+
+			rel, _ := filepath.Rel(root, p)
+			name := filepath.ToSlash(rel)
+			if m, err := h.meta.Get(name); err == nil && !force && (m.BlurHash != "" || m.PlaceholderError != "") {
+				report.Skipped++
+				return nil
+			}
+			if !strings.HasPrefix(typeByExtension(name), "image/") && !strings.HasPrefix(h.sniffFile(p), "image/") {
+				report.Skipped++
+				return nil
+			}
+
+			if _, err := h.jobs.Enqueue(name, []string{TaskPlaceholder}); err != nil {
+				if errors.Is(err, jobs.ErrQueueFull) {
+					report.Incomplete = true
+					return filepath.SkipAll
+				}
+				return err
+			}
+			report.Enqueued++
+			return nil
+		},
+	)
+	if err != nil {
+		log.Printf("Error backfilling placeholders: %s\n", err)
+		writeError(w, ErrInternal)
+		return
+	}
+
+	if report.Incomplete {
+		w.Header().Set("Retry-After", "5")
+	}
+	utils.JSONResponse(w, http.StatusAccepted, report)
+}
+
+func (h *Handler) sniffFile(path string) string {
+	file, err := h.openStored(h.ctx, path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	res, _ := sniffType(file)
+	return res
+}
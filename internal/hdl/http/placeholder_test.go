@@ -0,0 +1,160 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/jobs"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPlaceholders(t *testing.T) {
+	testDir := t.TempDir()
+
+	cfg := &config.HTTPConfig{
+		DefaultPage:   1,
+		DefaultSize:   10,
+		MaxUploadSize: 10 * 1024 * 1024,
+		AdminToken:    "admin-secret",
+		Jobs:          &config.JobsConfig{Workers: 2},
+	}
+	hdl := New(port, testDir, cfg)
+
+	src := image.NewNRGBA(image.Rect(0, 0, 640, 480))
+	for x := range 640 {
+		for y := range 480 {
+			src.Set(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	buf := &bytes.Buffer{}
+	assert.Nil(t, png.Encode(buf, src))
+
+	wait := func(id string) *jobs.Job {
+		var job *jobs.Job
+		assert.Eventually(
+			t, func() bool {
+				job, _ = hdl.jobs.Get(id)
+				return job.State == jobs.StateDone || job.State == jobs.StateFailed
+			}, 3*time.Second, 10*time.Millisecond,
+		)
+		return job
+	}
+	getMeta := func(name string) (int, *meta.File) {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/files/"+name+"/meta", nil))
+
+		res := &meta.File{}
+		if rec.Code == http.StatusOK {
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+		}
+		return rec.Code, res
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hdl.jobs.Run(ctx)
+
+	t.Run(
+		"Computed after upload", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("red.png", buf.String(), nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, jobs.StateDone, wait(decodeUpload(t, rec).Job).State)
+
+			code, m := getMeta("red.png")
+			assert.Equal(t, http.StatusOK, code)
+			assert.Equal(t, "#ff0000", m.DominantColor)
+			assert.Len(t, m.BlurHash, 28)
+			assert.Equal(t, "L", m.BlurHash[:1])
+			assert.Empty(t, m.PlaceholderError)
+		},
+	)
+
+	t.Run(
+		"Corrupt images record the error", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("broken.png", "\x89PNG\r\n\x1a\ngarbage", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, jobs.StateDone, wait(decodeUpload(t, rec).Job).State)
+
+			code, m := getMeta("broken.png")
+			assert.Equal(t, http.StatusOK, code)
+			assert.Empty(t, m.BlurHash)
+			assert.NotEmpty(t, m.PlaceholderError)
+		},
+	)
+
+	t.Run(
+		"Metadata endpoint", func(t *testing.T) {
+			code, _ := getMeta("missing.png")
+			assert.Equal(t, http.StatusNotFound, code)
+		},
+	)
+
+	t.Run(
+		"List includes metadata", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/list?include=meta", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			res := struct {
+				Data []ListEntry `json:"data"`
+			}{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.Len(t, res.Data, 2)
+			for _, entry := range res.Data {
+				assert.NotEmpty(t, entry.URL)
+				assert.NotNil(t, entry.Meta)
+			}
+		},
+	)
+
+	t.Run(
+		"Backfill existing files", func(t *testing.T) {
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, "legacy.png"), buf.Bytes(), 0644))
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, "notes.txt"), []byte("text"), 0644))
+
+			post := func(query, token string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/admin/placeholders/backfill"+query, nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				rec := httptest.NewRecorder()
+				hdl.ServeHTTP(rec, req)
+				return rec
+			}
+			backfill := func(query string) *BackfillReport {
+				rec := post(query, "admin-secret")
+				assert.Equal(t, http.StatusAccepted, rec.Code)
+
+				res := &BackfillReport{}
+				assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+				return res
+			}
+
+			for _, token := range []string{"", "wrong"} {
+				assert.Equal(t, http.StatusForbidden, post("?force=true", token).Code)
+			}
+
+			assert.Equal(t, &BackfillReport{Enqueued: 1, Skipped: 3}, backfill(""))
+			assert.Eventually(
+				t, func() bool {
+					_, m := getMeta("legacy.png")
+					return m.DominantColor == "#ff0000"
+				}, 3*time.Second, 10*time.Millisecond,
+			)
+
+			assert.Equal(t, &BackfillReport{Skipped: 4}, backfill(""))
+			assert.Equal(t, &BackfillReport{Enqueued: 3, Skipped: 1}, backfill("?force=true"))
+		},
+	)
+}
@@ -0,0 +1,471 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/pkg/config"
+	"maps"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Policy actions. No route moves files yet; ActionMove lets rules name it
+// ahead of one.
+const (
+	ActionUpload   = "upload"
+	ActionDownload = "download"
+	ActionList     = "list"
+	ActionDelete   = "delete"
+	ActionMove     = "move"
+	ActionAdmin    = "admin"
+)
+
+const (
+	principalAdmin     = "admin"
+	principalAnonymous = "anonymous"
+
+	effectAllow = "allow"
+	effectDeny  = "deny"
+)
+
+var policyActions = []string{ActionUpload, ActionDownload, ActionList, ActionDelete, ActionMove, ActionAdmin}
+
+// routeActions is the action each route needs; "" for none. Routes missing
+// here, the /admin/ ones among them, need admin.
+var routeActions = map[string]string{
+	"GET /list":                     ActionList,
+	"GET /changes":                  ActionList,
+	"GET /trash":                    ActionList,
+	"GET /stats":                    ActionList,
+	"GET /stats/usage":              ActionList,
+	"GET /jobs":                     ActionList,
+	"GET /jobs/{id}":                ActionList,
+	"GET /export/manifest":          ActionList,
+	"GET /browse/":                  ActionList,
+	"POST /verify":                  ActionList,
+	"POST /receipts/verify":         ActionList,
+	"POST /upload":                  ActionUpload,
+	"GET /upload/progress/{id}":     ActionUpload,
+	"HEAD /files/{name}":            ActionUpload,
+	"PATCH /files/{name}":           ActionUpload,
+	"PATCH /files/{name}/meta":      ActionUpload,
+	"PATCH /meta/{name...}":         ActionUpload,
+	"POST /files/{name}/lock":       ActionUpload,
+	"DELETE /files/{name}/lock":     ActionUpload,
+	"POST /files/{name}/transcode":  ActionUpload,
+	"POST /files/{name}/clip":       ActionUpload,
+	"GET /clips/{id}":               ActionUpload,
+	"POST /mpu":                     ActionUpload,
+	"PUT /mpu/{id}/parts/{n}":       ActionUpload,
+	"POST /mpu/{id}/complete":       ActionUpload,
+	"DELETE /mpu/{id}":              ActionUpload,
+	"GET /stream/{name...}":         ActionDownload,
+	"GET /stream/uploads/{name...}": ActionDownload,
+	"GET /uploads/":                 ActionDownload,
+	"GET /files/{name}/waveform":    ActionDownload,
+	"GET /files/{name}/meta":        ActionDownload,
+	"GET /meta/{name...}":           ActionDownload,
+	"GET /files/{name}/tracks":      ActionDownload,
+	"GET /files/{name}/checksum":    ActionDownload,
+	"GET /files/{name}/versions":    ActionDownload,
+	"GET /id/{id}":                  ActionDownload,
+	"POST /downloads":               ActionDownload,
+	"GET /downloads/{id}":           ActionDownload,
+	"GET /thumb/{name}":             ActionDownload,
+	"GET /img/{name}":               ActionDownload,
+	"GET /img/{preset}/{name}":      ActionDownload,
+	"DELETE /files/{name...}":       ActionDelete,
+	"DELETE /id/{id}":               ActionDelete,
+	"DELETE /delete":                ActionDelete,
+	"OPTIONS /stream/{name...}":     "",
+	"GET /readyz":                   "",
+	"GET /version":                  "",
+	"GET /capabilities":             "",
+	"GET /.well-known/receipt-key":  "",
+	"GET /metrics":                  "",
+}
+
+// deferredRoutes only learn the name of the file they write from the
+// request body. The middleware lets through principals allowed the action
+// anywhere, and the handler checks the name once it knows it.
+var deferredRoutes = []string{
+	"POST /upload",
+	"POST /mpu",
+	"PUT /mpu/{id}/parts/{n}",
+	"POST /mpu/{id}/complete",
+	"DELETE /mpu/{id}",
+}
+
+// dirRoutes name a directory rather than a file. Principals allowed the
+// action on the directory or on anything under it get through, and the
+// handler leaves out the entries they may not see.
+var dirRoutes = []string{
+	"GET /browse/",
+}
+
+func routeAction(pattern string) string {
+	if action, ok := routeActions[pattern]; ok {
+		return action
+	}
+	return ActionAdmin
+}
+
+// Decision is the outcome of evaluating a request against the policy. Rule
+// is the name of the rule that decided it, or its index as "rules[i]"; it
+// is empty when no rule matched and the request was denied by default.
+type Decision struct {
+	Principal string `json:"principal"`
+	Action    string `json:"action"`
+	Name      string `json:"name,omitempty"`
+	Allowed   bool   `json:"allowed"`
+	Rule      string `json:"rule,omitempty"`
+}
+
+type decisionKey struct{}
+
+type principal struct {
+	name  string
+	token string
+}
+
+type policyRule struct {
+	name       string
+	deny       bool
+	principals []string
+	actions    []string
+	prefixes   []string
+}
+
+type policy struct {
+	principals []principal
+	rules      []*policyRule
+	foldCase   bool
+}
+
+// newPolicy builds the policy of cfg. Rules may name the principals it
+// defines and the Basic auth users user reports.
+func newPolicy(cfg *config.PolicyConfig, adminToken string, user func(string) bool, foldCase bool) (*policy, error) {
+	p := &policy{foldCase: foldCase}
+	tokens := make(map[string]bool)
+	for _, pc := range cfg.Principals {
+		name, token := pc.Name, pc.Token
+		switch {
+		case name == "":
+			return nil, errors.New("principal without a name")
+		case name == principalAdmin || name == principalAnonymous || name == "*":
+			return nil, fmt.Errorf("principal name %q is reserved", name)
+		case token == "":
+			return nil, fmt.Errorf("principal %q has no token", name)
+		case slices.ContainsFunc(p.principals, func(p principal) bool { return p.name == name }):
+			return nil, fmt.Errorf("principal %q is defined twice", name)
+		case tokens[token] || token == adminToken:
+			return nil, fmt.Errorf("principal %q shares its token", name)
+		}
+		tokens[token] = true
+		p.principals = append(p.principals, principal{name: name, token: token})
+	}
+
+	for i, rc := range cfg.Rules {
+		rule := &policyRule{name: rc.Name, principals: rc.Principals, actions: rc.Actions}
+		if rule.name == "" {
+			rule.name = "rules[" + strconv.Itoa(i) + "]"
+		}
+
+		switch rc.Effect {
+		case "", effectAllow:
+		case effectDeny:
+			rule.deny = true
+		default:
+			return nil, fmt.Errorf("rule %s: unknown effect %q", rule.name, rc.Effect)
+		}
+
+		if len(rc.Principals) == 0 {
+			return nil, fmt.Errorf("rule %s: no principals", rule.name)
+		}
+		for _, name := range rc.Principals {
+			known := slices.ContainsFunc(p.principals, func(p principal) bool { return p.name == name })
+			if !known && name != "*" && name != principalAnonymous && (user == nil || !user(name)) {
+				return nil, fmt.Errorf("rule %s: unknown principal %q", rule.name, name)
+			}
+		}
+
+		if len(rc.Actions) == 0 {
+			return nil, fmt.Errorf("rule %s: no actions", rule.name)
+		}
+		for _, action := range rc.Actions {
+			if action != "*" && !slices.Contains(policyActions, action) {
+				return nil, fmt.Errorf("rule %s: unknown action %q", rule.name, action)
+			}
+		}
+
+		for _, prefix := range rc.Prefixes {
+			if prefix = strings.Trim(prefix, "/"); prefix == "" {
+				return nil, fmt.Errorf("rule %s: empty prefix", rule.name)
+			}
+			if foldCase {
+				prefix = strings.ToLower(prefix)
+			}
+			rule.prefixes = append(rule.prefixes, prefix)
+		}
+		p.rules = append(p.rules, rule)
+	}
+	return p, nil
+}
+
+func (rule *policyRule) applies(principal, action string) bool {
+	return (slices.Contains(rule.principals, principal) || slices.Contains(rule.principals, "*")) &&
+		(slices.Contains(rule.actions, action) || slices.Contains(rule.actions, "*"))
+}
+
+// covers reports whether name lies under one of the rule's prefixes. A
+// prefix names a directory: "tmp" covers "tmp/a" but not "tmpfoo".
+func (rule *policyRule) covers(name string) bool {
+	if len(rule.prefixes) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(
+		rule.prefixes, func(prefix string) bool {
+			return name == prefix || strings.HasPrefix(name, prefix+"/")
+		},
+	)
+}
+
+// reaches reports whether the rule covers dir or anything under it.
+func (rule *policyRule) reaches(dir string) bool {
+	if dir == "" || rule.covers(dir) {
+		return true
+	}
+	return slices.ContainsFunc(rule.prefixes, func(prefix string) bool { return strings.HasPrefix(prefix, dir+"/") })
+}
+
+// evaluate decides whether principal may take action on name, or on no
+// file in particular when name is empty. A matching deny wins over any
+// allow; otherwise the first matching allow decides.
+func (p *policy) evaluate(principal, action, name string) *Decision {
+	d := &Decision{Principal: principal, Action: action, Name: name}
+	if principal == principalAdmin {
+		d.Allowed, d.Rule = true, "adminToken"
+		return d
+	}
+
+	target := name
+	if p.foldCase {
+		target = strings.ToLower(name)
+	}
+	var allow *policyRule
+	for _, rule := range p.rules {
+		if !rule.applies(principal, action) || !rule.covers(target) {
+			continue
+		}
+		if rule.deny {
+			d.Rule = rule.name
+			return d
+		}
+		if allow == nil {
+			allow = rule
+		}
+	}
+	if allow != nil {
+		d.Allowed, d.Rule = true, allow.name
+	}
+	return d
+}
+
+// evaluateAnywhere decides whether principal may take action on some file,
+// for requests that do not name theirs yet: any allow will do, and only a
+// deny without prefixes refuses.
+func (p *policy) evaluateAnywhere(principal, action string) *Decision {
+	d := &Decision{Principal: principal, Action: action}
+	if principal == principalAdmin {
+		d.Allowed, d.Rule = true, "adminToken"
+		return d
+	}
+
+	var allow *policyRule
+	for _, rule := range p.rules {
+		if !rule.applies(principal, action) {
+			continue
+		}
+		if rule.deny && len(rule.prefixes) == 0 {
+			d.Rule = rule.name
+			return d
+		}
+		if !rule.deny && allow == nil {
+			allow = rule
+		}
+	}
+	if allow != nil {
+		d.Allowed, d.Rule = true, allow.name
+	}
+	return d
+}
+
+// evaluateUnder decides whether principal may take action on dir or on
+// something under it, "" being the top: an allow reaching under dir will
+// do, and only a deny covering all of dir refuses.
+func (p *policy) evaluateUnder(principal, action, dir string) *Decision {
+	d := &Decision{Principal: principal, Action: action, Name: dir}
+	if principal == principalAdmin {
+		d.Allowed, d.Rule = true, "adminToken"
+		return d
+	}
+
+	target := dir
+	if p.foldCase {
+		target = strings.ToLower(dir)
+	}
+	var allow *policyRule
+	for _, rule := range p.rules {
+		if !rule.applies(principal, action) {
+			continue
+		}
+		if rule.deny {
+			if len(rule.prefixes) == 0 || (target != "" && rule.covers(target)) {
+				d.Rule = rule.name
+				return d
+			}
+			continue
+		}
+		if allow == nil && rule.reaches(target) {
+			allow = rule
+		}
+	}
+	if allow != nil {
+		d.Allowed, d.Rule = true, allow.name
+	}
+	return d
+}
+
+// principal returns who r authenticates as: admin for the admin token, the
+// Basic auth user it signed in as, the principal whose token it carries, or
+// anonymous.
+func (h *Handler) principal(r *http.Request) string {
+	if bearerMatches(r, h.config.AdminToken) {
+		return principalAdmin
+	}
+	if name, ok := basicUser(r); ok {
+		return name
+	}
+	if h.policy == nil {
+		return principalAnonymous
+	}
+	for _, p := range h.policy.principals {
+		if bearerMatches(r, p.token) {
+			return p.name
+		}
+	}
+	return principalAnonymous
+}
+
+// policyName returns the file a request to pattern targets, as the handler
+// will name it, or "" when it names none. Names the API takes are cleaned
+// segment by segment; the file server's are only trimmed.
+func (h *Handler) policyName(pattern string, r *http.Request) string {
+	_, route, _ := strings.Cut(pattern, " ")
+	var name string
+	switch route {
+	case "/browse/":
+		return strings.Trim(strings.TrimPrefix(path.Clean(r.URL.Path), "/browse"), "/")
+	case "/uploads/":
+		name = strings.Trim(strings.TrimPrefix(path.Clean(r.URL.Path), "/uploads"), "/")
+	case "/id/{id}":
+		name, _ = h.meta.Resolve(r.PathValue("id"))
+	case "/delete":
+		name = r.URL.Query().Get("filename")
+	default:
+		name = r.PathValue("name")
+	}
+	if name == "" {
+		return ""
+	}
+
+	if route != "/uploads/" {
+		var err error
+		if name, err = h.cleanPath(name); err != nil {
+			return ""
+		}
+	}
+	name, _ = h.resolveName(name)
+	return name
+}
+
+// authorize runs after the route is matched and refuses requests the
+// policy does not allow with 403. The decision is kept on the request for
+// the audit log.
+func (h *Handler) authorize(pattern string, next http.Handler) http.Handler {
+	action := routeAction(pattern)
+	if h.policy == nil || action == "" {
+		return next
+	}
+
+	deferred := slices.Contains(deferredRoutes, pattern)
+	under := slices.Contains(dirRoutes, pattern)
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var d *Decision
+			switch {
+			case deferred:
+				d = h.policy.evaluateAnywhere(h.principal(r), action)
+			case under:
+				d = h.policy.evaluateUnder(h.principal(r), action, h.policyName(pattern, r))
+			default:
+				d = h.policy.evaluate(h.principal(r), action, h.policyName(pattern, r))
+			}
+			if !d.Allowed {
+				h.deny(w, r, d)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), decisionKey{}, d)))
+		},
+	)
+}
+
+// authorizeName checks the policy for the file a deferred route turned out
+// to write, once the handler knows its name.
+func (h *Handler) authorizeName(w http.ResponseWriter, r *http.Request, action, name string) bool {
+	if h.policy == nil {
+		return true
+	}
+
+	d := h.policy.evaluate(h.principal(r), action, name)
+	if !d.Allowed {
+		h.deny(w, r, d)
+		return false
+	}
+	if prev, ok := r.Context().Value(decisionKey{}).(*Decision); ok {
+		*prev = *d
+	}
+	return true
+}
+
+func (h *Handler) deny(w http.ResponseWriter, r *http.Request, d *Decision) {
+	h.audit.Log("access.denied", d.Name, r.RemoteAddr, map[string]any{"policy": d})
+	params := map[string]any{"action": d.Action}
+	if d.Name != "" {
+		params["name"] = d.Name
+	}
+	writeError(w, withParams(ErrAccessDenied, params))
+}
+
+// auditDetails adds the policy decision that let r through to the details
+// of its audit entry.
+func auditDetails(r *http.Request, data map[string]any) map[string]any {
+	if r == nil {
+		return data
+	}
+	d, ok := r.Context().Value(decisionKey{}).(*Decision)
+	if !ok {
+		return data
+	}
+
+	details := maps.Clone(data)
+	if details == nil {
+		details = make(map[string]any)
+	}
+	details["policy"] = d
+	return details
+}
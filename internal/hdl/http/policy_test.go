@@ -0,0 +1,235 @@
+package http
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/audit"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	testDir := t.TempDir()
+
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	newConfig := func(policy *config.PolicyConfig) *config.HTTPConfig {
+		return &config.HTTPConfig{
+			MaxUploadSize:   1024,
+			MaxStreamBuffer: 1024,
+			DefaultPage:     1,
+			DefaultSize:     10,
+			AdminToken:      "admin-secret",
+			AuditLog:        auditPath,
+			Policy:          policy,
+		}
+	}
+	hdl := New(
+		port, testDir, newConfig(
+			&config.PolicyConfig{
+				Principals: []*config.PrincipalConfig{
+					{Name: "uploader", Token: "uploader-key"},
+					{Name: "cleaner", Token: "cleaner-key"},
+					{Name: "reader", Token: "reader-key"},
+					{Name: "editor", Token: "editor-key"},
+					{Name: "dropbox", Token: "dropbox-key"},
+					{Name: "ops", Token: "ops-key"},
+				},
+				Rules: []*config.PolicyRule{
+					{Name: "uploader", Principals: []string{"uploader"}, Actions: []string{"upload", "download", "list"}},
+					{Name: "cleaner-tmp", Principals: []string{"cleaner"}, Actions: []string{"delete"}, Prefixes: []string{"tmp/"}},
+					{Name: "reader-tmp", Principals: []string{"reader"}, Actions: []string{"download"}, Prefixes: []string{"tmp/"}},
+					{Name: "editor-media", Principals: []string{"editor"}, Actions: []string{"*"}, Prefixes: []string{"media"}},
+					{
+						Name: "editor-keep", Effect: "deny", Principals: []string{"editor"}, Actions: []string{"download"},
+						Prefixes: []string{"media/keep/"},
+					},
+					{Principals: []string{"*"}, Actions: []string{"download"}, Prefixes: []string{"/public/"}},
+					{Principals: []string{"dropbox"}, Actions: []string{"upload"}, Prefixes: []string{"inbox"}},
+					{Name: "ops", Principals: []string{"ops"}, Actions: []string{"admin"}},
+				},
+			},
+		),
+	)
+
+	do := func(method, target, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	upload := func(name, key string) *httptest.ResponseRecorder {
+		req := newUploadRequest(name, "content", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	write := func(name string) {
+		path := filepath.Join(testDir, filepath.FromSlash(name))
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.Nil(t, os.WriteFile(path, []byte("content"), 0644))
+	}
+	assertDenied := func(rec *httptest.ResponseRecorder) {
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		res := utils.ErrorResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		assert.Equal(t, "access_denied", res.Code)
+	}
+
+	t.Run(
+		"Actions are granted per principal", func(t *testing.T) {
+			assert.Equal(t, http.StatusCreated, upload("clip.mp4", "uploader-key").Code)
+			assert.Equal(t, http.StatusOK, do(http.MethodGet, "/stream/clip.mp4", "uploader-key").Code)
+			assert.Equal(t, http.StatusOK, do(http.MethodGet, "/list", "uploader-key").Code)
+			assertDenied(do(http.MethodDelete, "/files/clip.mp4", "uploader-key"))
+			assertDenied(do(http.MethodGet, "/admin/config", "uploader-key"))
+
+			assertDenied(do(http.MethodGet, "/stream/clip.mp4", ""))
+			assertDenied(do(http.MethodGet, "/stream/clip.mp4", "unknown-key"))
+			assertDenied(upload("other.mp4", "cleaner-key"))
+			assert.Equal(t, http.StatusOK, do(http.MethodGet, "/readyz", "").Code)
+
+			rec := do(http.MethodGet, "/admin/config", "ops-key")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.NotContains(t, rec.Body.String(), "uploader-key")
+			assert.Equal(t, http.StatusOK, do(http.MethodGet, "/admin/config", "admin-secret").Code)
+			assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/files/clip.mp4", "admin-secret").Code)
+		},
+	)
+
+	t.Run(
+		"Prefixes cover whole path segments", func(t *testing.T) {
+			for _, name := range []string{"tmp/a.txt", "tmpfoo/b.txt", "tmp.txt", "tmp_a.txt"} {
+				write(name)
+			}
+
+			assert.Equal(t, http.StatusOK, do(http.MethodGet, "/uploads/tmp/a.txt", "reader-key").Code)
+			assertDenied(do(http.MethodGet, "/uploads/tmpfoo/b.txt", "reader-key"))
+			assertDenied(do(http.MethodGet, "/uploads/tmp.txt", "reader-key"))
+
+			for name, allowed := range map[string]bool{
+				"tmp/a.txt":     true,
+				"tmp/sub/a.txt": true,
+				"tmp":           true,
+				"tmpfoo":        false,
+				"tmpfoo/b.txt":  false,
+				"tmp.txt":       false,
+				"tmp_a.txt":     false,
+				"":              false,
+			} {
+				assert.Equal(t, allowed, hdl.policy.evaluate("cleaner", ActionDelete, name).Allowed, name)
+			}
+
+			// Names keep their directories, so this names tmp/a.txt and not
+			// tmp_a.txt.
+			assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/delete?filename=tmp/a.txt", "cleaner-key").Code)
+			assert.NoFileExists(t, filepath.Join(testDir, "tmp", "a.txt"))
+			assert.FileExists(t, filepath.Join(testDir, "tmp_a.txt"))
+			assertDenied(do(http.MethodDelete, "/files/tmpfoo/b.txt", "cleaner-key"))
+
+			// Listings name no file, so scoped rules never allow them.
+			assertDenied(do(http.MethodGet, "/list", "reader-key"))
+		},
+	)
+
+	t.Run(
+		"Overlapping rules", func(t *testing.T) {
+			for _, name := range []string{"media/a.txt", "media/keep/b.txt", "media/keepsake.txt", "public/c.txt"} {
+				write(name)
+			}
+
+			assert.Equal(t, http.StatusOK, do(http.MethodGet, "/uploads/media/a.txt", "editor-key").Code)
+			assert.Equal(t, http.StatusOK, do(http.MethodGet, "/uploads/media/keepsake.txt", "editor-key").Code)
+			assertDenied(do(http.MethodGet, "/uploads/media/keep/b.txt", "editor-key"))
+
+			d := hdl.policy.evaluate("editor", ActionDelete, "media/keep/b.txt")
+			assert.Equal(t, &Decision{Principal: "editor", Action: ActionDelete, Name: "media/keep/b.txt", Allowed: true, Rule: "editor-media"}, d)
+			assert.True(t, hdl.policy.evaluate("editor", ActionDelete, "media/keep").Allowed)
+			assert.False(t, hdl.policy.evaluate("editor", ActionDownload, "media/keep").Allowed)
+			assert.False(t, hdl.policy.evaluate("editor", ActionDownload, "media/keep/").Allowed)
+
+			// The editor may do anything under media, but not admin, which
+			// names no file.
+			assertDenied(do(http.MethodGet, "/admin/config", "editor-key"))
+
+			// Rules for everyone add to a principal's own.
+			assert.Equal(t, http.StatusOK, do(http.MethodGet, "/uploads/public/c.txt", "").Code)
+			assert.Equal(t, http.StatusOK, do(http.MethodGet, "/uploads/public/c.txt", "editor-key").Code)
+			assertDenied(do(http.MethodGet, "/uploads/media/a.txt", ""))
+		},
+	)
+
+	t.Run(
+		"Uploads are checked against their stored name", func(t *testing.T) {
+			assertDenied(upload("outside.txt", "dropbox-key"))
+			assert.NoFileExists(t, filepath.Join(testDir, "outside.txt"))
+		},
+	)
+
+	t.Run(
+		"Decisions are audited", func(t *testing.T) {
+			file, err := os.Open(auditPath)
+			assert.Nil(t, err)
+			defer file.Close()
+
+			entries := make(map[string][]*Decision)
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				e := struct {
+					audit.Entry
+					Details struct {
+						Policy *Decision `json:"policy"`
+					} `json:"details"`
+				}{}
+				assert.Nil(t, json.Unmarshal(scanner.Bytes(), &e))
+				if e.Details.Policy != nil {
+					entries[e.Action+" "+e.Name] = append(entries[e.Action+" "+e.Name], e.Details.Policy)
+				}
+			}
+
+			created := entries["file.created clip.mp4"]
+			if assert.Len(t, created, 1) {
+				assert.Equal(t, &Decision{Principal: "uploader", Action: ActionUpload, Name: "clip.mp4", Allowed: true, Rule: "uploader"}, created[0])
+			}
+			deleted := entries["file.deleted clip.mp4"]
+			if assert.Len(t, deleted, 1) {
+				assert.Equal(t, "adminToken", deleted[0].Rule)
+			}
+			denied := entries["access.denied media/keep/b.txt"]
+			if assert.Len(t, denied, 1) {
+				assert.Equal(t, &Decision{Principal: "editor", Action: ActionDownload, Name: "media/keep/b.txt", Rule: "editor-keep"}, denied[0])
+			}
+			denied = entries["access.denied media/a.txt"]
+			if assert.Len(t, denied, 1) {
+				assert.Equal(t, &Decision{Principal: principalAnonymous, Action: ActionDownload, Name: "media/a.txt"}, denied[0])
+			}
+		},
+	)
+
+	t.Run(
+		"Invalid policies fail at startup", func(t *testing.T) {
+			for _, policy := range []*config.PolicyConfig{
+				{Principals: []*config.PrincipalConfig{{Name: "anonymous", Token: "key"}}},
+				{Principals: []*config.PrincipalConfig{{Name: "a"}}},
+				{Principals: []*config.PrincipalConfig{{Name: "a", Token: "key"}, {Name: "a", Token: "other"}}},
+				{Principals: []*config.PrincipalConfig{{Name: "a", Token: "key"}, {Name: "b", Token: "key"}}},
+				{Principals: []*config.PrincipalConfig{{Name: "a", Token: "admin-secret"}}},
+				{Rules: []*config.PolicyRule{{Principals: []string{"ghost"}, Actions: []string{"list"}}}},
+				{Rules: []*config.PolicyRule{{Principals: []string{"*"}, Actions: []string{"rename"}}}},
+				{Rules: []*config.PolicyRule{{Principals: []string{"*"}, Actions: []string{"list"}, Effect: "maybe"}}},
+				{Rules: []*config.PolicyRule{{Principals: []string{"*"}, Actions: []string{"list"}, Prefixes: []string{"/"}}}},
+			} {
+				assert.Panics(t, func() { New(port, testDir, newConfig(policy)) })
+			}
+		},
+	)
+}
@@ -0,0 +1,150 @@
+package http
+
+import (
+	"encoding/hex"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	expectedChecksumHeader = "X-Expected-Sha256"
+	skipDrain              = 64 << 10
+)
+
+// existingUpload stops parsing an upload whose target is already stored,
+// before any of its content is read.
+type existingUpload struct {
+	name     string
+	original string
+	checksum string
+}
+
+func (e *existingUpload) Error() string {
+	return ErrAlreadyExists.Error()
+}
+
+// expectedChecksum returns the SHA-256 a client says its upload has, from
+// X-Expected-Sha256 or a quoted If-None-Match. If-None-Match values that are
+// not a digest, such as *, are left alone.
+func expectedChecksum(r *http.Request) (string, error) {
+	if v := r.Header.Get(expectedChecksumHeader); v != "" {
+		if !sha256Hex(v) {
+			return "", ErrInvalidChecksum
+		}
+		return strings.ToLower(v), nil
+	}
+
+	v := strings.TrimSpace(r.Header.Get("If-None-Match"))
+	if v = strings.Trim(v, `"`); sha256Hex(v) {
+		return strings.ToLower(v), nil
+	}
+	return "", nil
+}
+
+func sha256Hex(v string) bool {
+	b, err := hex.DecodeString(v)
+	return err == nil && len(b) == 32
+}
+
+// precheckUpload returns the parseUpload check that stops an upload whose
+// target already exists, so its content is never transferred. Uploads that
+// would be stored under a fresh name, or that overwrite different content,
+// are never stopped.
+func (h *Handler) precheckUpload(r *http.Request, expected string) func(string, url.Values) error {
+	if expected == "" {
+		return nil
+	}
+	return func(filename string, values url.Values) error {
+		naming := values.Get("naming")
+		if naming == "" {
+			naming = r.URL.Query().Get("naming")
+		}
+		strategy, err := h.naming(naming)
+		if err != nil || strategy == NamingUUID {
+			return nil
+		}
+
+		original, err := h.cleanName(filename)
+		if err != nil {
+			return nil
+		}
+		name := storedName(strategy, original, expected)
+		if h.hidden(name) {
+			return nil
+		}
+		path, err := h.checkPath(name)
+		if err != nil {
+			return nil
+		}
+		if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+
+		res := &existingUpload{name: name, original: original}
+		if m, err := h.meta.Get(name); err == nil && m.Checksum != "" {
+			res.checksum = m.Checksum
+		} else if res.checksum, err = h.storedChecksum(r.Context(), path); err != nil {
+			log.Printf("Error computing checksum of %s: %s\n", name, err)
+			return nil
+		}
+		if overwrite, _ := wantsOverwrite(r, values); overwrite && !strings.EqualFold(res.checksum, expected) {
+			return nil
+		}
+		return res
+	}
+}
+
+// skipUpload answers an upload stopped by precheckUpload: 200 when the stored
+// file is identical, 409 with both checksums otherwise.
+func (h *Handler) skipUpload(w http.ResponseWriter, r *http.Request, e *existingUpload, expected string, receipt bool) {
+	drainSkipped(w, r.Body)
+
+	if !strings.EqualFold(e.checksum, expected) {
+		writeError(
+			w, withParams(
+				ErrChecksumConflict, map[string]any{
+					"name":     e.name,
+					"checksum": e.checksum,
+					"expected": expected,
+				},
+			),
+		)
+		return
+	}
+
+	res := utils.UploadResponse{
+		URL:          h.fileURL(r, e.name),
+		Name:         e.name,
+		OriginalName: e.original,
+		Identical:    true,
+	}
+	if m, err := h.meta.Get(e.name); err == nil {
+		res.ID = m.ID
+		if receipt {
+			if res.Receipt, err = h.signReceipt(w, r, e.name, m.Size, e.checksum); err != nil {
+				log.Printf("Error signing the receipt for %s: %s\n", e.name, err)
+			}
+		}
+	}
+	log.Printf("Upload skipped, identical file exists: %s\n", res.URL)
+	utils.JSONResponse(w, http.StatusOK, res)
+}
+
+// drainSkipped reads what is left of a skipped upload when that is little
+// enough to keep the connection. Anything larger closes it instead, so the
+// client stops sending the transfer it meant to skip.
+func drainSkipped(w http.ResponseWriter, body io.Reader) {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Now().Add(oversizeDrainTimeout))
+	_, err := io.CopyN(io.Discard, body, skipDrain+1)
+	rc.SetReadDeadline(time.Time{})
+	if err != io.EOF {
+		w.Header().Set("Connection", "close")
+	}
+}
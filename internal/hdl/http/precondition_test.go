@@ -0,0 +1,176 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type countedBody struct {
+	r io.Reader
+	n int
+}
+
+func (b *countedBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.n += n
+	return n, err
+}
+
+func TestUploadPrecondition(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := New(port, testDir, &config.HTTPConfig{MaxUploadSize: 8 * 1024 * 1024})
+	sha := func(b []byte) string {
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:])
+	}
+
+	small := []byte("backed up photo")
+	large := bytes.Repeat([]byte("x"), 1024*1024)
+	for name, content := range map[string][]byte{"small.jpg": small, "large.jpg": large} {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, newUploadRequest(name, string(content), nil))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	}
+
+	upload := func(name string, content []byte, header, value string) (*httptest.ResponseRecorder, *countedBody) {
+		req := newUploadRequest(name, string(content), nil)
+		body := &countedBody{r: req.Body}
+		req.Body = io.NopCloser(body)
+		req.Header.Set(header, value)
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec, body
+	}
+
+	t.Run(
+		"Identical files are not transferred", func(t *testing.T) {
+			before, err := os.Stat(filepath.Join(testDir, "large.jpg"))
+			assert.Nil(t, err)
+
+			rec, body := upload("large.jpg", large, expectedChecksumHeader, sha(large))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Less(t, body.n, 128*1024)
+			assert.Equal(t, "close", rec.Header().Get("Connection"))
+
+			res := decodeUpload(t, rec)
+			assert.True(t, res.Identical)
+			assert.Equal(t, "large.jpg", res.Name)
+			assert.NotEmpty(t, res.ID)
+
+			after, err := os.Stat(filepath.Join(testDir, "large.jpg"))
+			assert.Nil(t, err)
+			assert.Equal(t, before.ModTime(), after.ModTime())
+
+			rec, _ = upload("small.jpg", small, "If-None-Match", `"`+sha(small)+`"`)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Empty(t, rec.Header().Get("Connection"))
+			assert.True(t, decodeUpload(t, rec).Identical)
+		},
+	)
+
+	t.Run(
+		"Different files conflict with both checksums", func(t *testing.T) {
+			changed := []byte("edited photo")
+			rec, body := upload("large.jpg", changed, expectedChecksumHeader, sha(changed))
+			assert.Equal(t, http.StatusConflict, rec.Code)
+			assert.Less(t, body.n, 128*1024)
+
+			res := utils.ErrorResponse{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.Equal(t, "checksum_conflict", res.Code)
+			assert.Equal(t, sha(large), res.Params["checksum"])
+			assert.Equal(t, sha(changed), res.Params["expected"])
+			assert.Equal(t, "large.jpg", res.Params["name"])
+
+			data, err := os.ReadFile(filepath.Join(testDir, "large.jpg"))
+			assert.Nil(t, err)
+			assert.Equal(t, large, data)
+		},
+	)
+
+	t.Run(
+		"New files upload normally", func(t *testing.T) {
+			rec, _ := upload("new.jpg", small, expectedChecksumHeader, sha(small))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.False(t, decodeUpload(t, rec).Identical)
+		},
+	)
+
+	t.Run(
+		"Hash naming", func(t *testing.T) {
+			req := newUploadRequest("other-name.jpg", string(small), map[string]string{"naming": NamingHash})
+			req.Header.Set(expectedChecksumHeader, sha(small))
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			req = newUploadRequest("again.jpg", string(small), map[string]string{"naming": NamingHash})
+			req.Header.Set(expectedChecksumHeader, sha(small))
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			res := decodeUpload(t, rec)
+			assert.True(t, res.Identical)
+			assert.Equal(t, sha(small)+".jpg", res.Name)
+		},
+	)
+
+	t.Run(
+		"Invalid checksums", func(t *testing.T) {
+			rec, _ := upload("small.jpg", small, expectedChecksumHeader, "not-a-digest")
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+			rec, _ = upload("star.jpg", small, "If-None-Match", "*")
+			assert.Equal(t, http.StatusCreated, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Connections are reused after small skipped uploads", func(t *testing.T) {
+			srv := httptest.NewServer(hdl)
+			defer srv.Close()
+
+			var reused []bool
+			post := func(content []byte) *http.Response {
+				req := newUploadRequest("small.jpg", string(content), nil)
+				body, err := io.ReadAll(req.Body)
+				assert.Nil(t, err)
+
+				out, err := http.NewRequest(http.MethodPost, srv.URL+"/upload", bytes.NewReader(body))
+				assert.Nil(t, err)
+				out.Header.Set("Content-Type", req.Header.Get("Content-Type"))
+				out.Header.Set(expectedChecksumHeader, sha(content))
+				out = out.WithContext(
+					httptrace.WithClientTrace(
+						out.Context(), &httptrace.ClientTrace{
+							GotConn: func(info httptrace.GotConnInfo) { reused = append(reused, info.Reused) },
+						},
+					),
+				)
+
+				res, err := srv.Client().Do(out)
+				assert.Nil(t, err)
+				io.Copy(io.Discard, res.Body)
+				res.Body.Close()
+				return res
+			}
+
+			assert.Equal(t, http.StatusOK, post(small).StatusCode)
+			assert.Equal(t, http.StatusOK, post(small).StatusCode)
+			assert.Equal(t, []bool{false, true}, reused)
+		},
+	)
+}
@@ -0,0 +1,313 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/pkg/config"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	previewsDir = ".previews"
+
+	defaultPreviewTimeout    = 30 * time.Second
+	defaultPreviewMaxSize    = 5 << 20
+	defaultPreviewFailureTTL = time.Minute
+)
+
+var defaultPreviewTypes = []string{"application/pdf"}
+
+var previewFormats = map[string]string{
+	"png":  "image/png",
+	"jpeg": "image/jpeg",
+	"jpg":  "image/jpeg",
+}
+
+type previewRecord struct {
+	ETag        string    `json:"etag"`
+	Error       string    `json:"error,omitempty"`
+	FailedUntil time.Time `json:"failed_until"`
+}
+
+func previewsEnabled(cfg *config.PreviewsConfig) bool {
+	return cfg != nil && len(cfg.Command) > 0
+}
+
+func (h *Handler) validatePreviews() error {
+	cfg := h.config.Previews
+	if !previewsEnabled(cfg) {
+		return nil
+	}
+
+	if cfg.Format != "" && previewFormats[strings.ToLower(cfg.Format)] == "" {
+		return fmt.Errorf("%w: format %q", ErrInvalidPreviewCommand, cfg.Format)
+	}
+
+	joined := strings.Join(cfg.Command, " ")
+	if !strings.Contains(joined, "{input}") {
+		return fmt.Errorf("%w: missing {input}", ErrInvalidPreviewCommand)
+	}
+	if !strings.Contains(joined, "{output}") && !strings.Contains(joined, "{outputBase}") {
+		return fmt.Errorf("%w: missing {output} or {outputBase}", ErrInvalidPreviewCommand)
+	}
+	return nil
+}
+
+func (h *Handler) previewFormat() (string, string) {
+	format := strings.ToLower(h.config.Previews.Format)
+	switch format {
+	case "":
+		format = "png"
+	case "jpg":
+		format = "jpeg"
+	}
+	return format, previewFormats[format]
+}
+
+func (h *Handler) previewPaths(name string) (string, string) {
+	format, _ := h.previewFormat()
+	base := filepath.Join(h.savePath, previewsDir, filepath.FromSlash(name))
+	return base + "." + format, base + ".json"
+}
+
+func (h *Handler) renderPreview(ctx context.Context, name string, src io.Reader) ([]byte, error) {
+	cfg := h.config.Previews
+	format, contentType := h.previewFormat()
+
+	dir, err := os.MkdirTemp(h.tempDir, "preview-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "input"+filepath.Ext(name))
+	outputBase := filepath.Join(dir, "preview")
+	output := outputBase + "." + format
+
+	file, err := os.Create(input)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(file, src)
+	if err = errors.Join(err, file.Close()); err != nil {
+		return nil, err
+	}
+
+	args := make([]string, len(cfg.Command))
+	for i, arg := range cfg.Command {
+		args[i] = strings.NewReplacer("{input}", input, "{output}", output, "{outputBase}", outputBase).Replace(arg)
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultPreviewTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Stderr = stderr
+	cmd.WaitDelay = time.Second
+	if err = cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: timed out after %s", ErrPreviewFailed, timeout)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > 200 {
+			msg = msg[len(msg)-200:]
+		}
+		return nil, fmt.Errorf("%w: %w: %s", ErrPreviewFailed, err, msg)
+	}
+
+	maxSize := cfg.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultPreviewMaxSize
+	}
+	info, err := os.Stat(output)
+	if err != nil {
+		return nil, fmt.Errorf("%w: no output", ErrPreviewFailed)
+	}
+	if info.Size() > maxSize {
+		return nil, fmt.Errorf("%w: output exceeds %d bytes", ErrPreviewFailed, maxSize)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		return nil, err
+	}
+	if got := http.DetectContentType(data); got != contentType {
+		return nil, fmt.Errorf("%w: output is %s, not %s", ErrPreviewFailed, got, contentType)
+	}
+	return data, nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (h *Handler) preview(w http.ResponseWriter, r *http.Request) {
+	cfg := h.config.Previews
+	if !previewsEnabled(cfg) {
+		writeError(w, ErrPreviewsDisabled)
+		return
+	}
+
+	name, err := h.pathName(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !h.checkAvailable(w, r, name) || !h.checkReady(w, name) {
+		return
+	}
+
+	file, err := h.openFile(r.Context(), name)
+	if err != nil {
+		writeError(w, ErrNotFound)
+		return
+	}
+	defer file.Close()
+	if bodyLength(file) == 0 {
+		errorResponse(w, http.StatusUnsupportedMediaType, ErrEmptyFile)
+		return
+	}
+
+	types := cfg.Types
+	if len(types) == 0 {
+		types = defaultPreviewTypes
+	}
+	contentType, err := h.contentType(name, file)
+	if err != nil || !matchesType([]string{baseMediaType(contentType)}, types) {
+		writeError(w, ErrUnsupportedMediaType)
+		return
+	}
+
+	etag, modTime := h.validators(name, file)
+	imagePath, recordPath := h.previewPaths(name)
+
+	record := &previewRecord{}
+	if data, err := os.ReadFile(recordPath); err == nil && json.Unmarshal(data, record) == nil && record.ETag == etag {
+		if record.Error != "" && time.Now().Before(record.FailedUntil) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(record.FailedUntil).Seconds())+1))
+			writeError(w, ErrPreviewFailed)
+			return
+		}
+		if record.Error == "" {
+			if data, err := os.ReadFile(imagePath); err == nil {
+				h.servePreview(w, r, etag, modTime, data)
+				return
+			}
+		}
+	}
+
+	v, err := h.flights.Do(
+		r.Context(), "preview\x00"+name+"\x00"+etag, func(ctx context.Context) (any, error) {
+			return h.generatePreview(ctx, name, etag)
+		},
+	)
+	if errors.Is(err, ErrPreviewFailed) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(h.previewFailureTTL().Seconds())))
+		writeError(w, ErrPreviewFailed)
+		return
+	}
+	if errors.Is(err, ErrCoalesceTimeout) {
+		writeError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, canceledOr(err, ErrInternal))
+		return
+	}
+	h.servePreview(w, r, etag, modTime, v.([]byte))
+}
+
+func (h *Handler) previewFailureTTL() time.Duration {
+	if ttl := h.config.Previews.FailureTTL; ttl > 0 {
+		return ttl
+	}
+	return defaultPreviewFailureTTL
+}
+
+// generatePreview renders the preview of the version of name tagged etag
+// and caches the result, or the failure for the failure TTL.
+func (h *Handler) generatePreview(ctx context.Context, name, etag string) ([]byte, error) {
+	unlock := h.previewMu.Lock(name)
+	defer unlock()
+
+	file, err := h.openFile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	imagePath, recordPath := h.previewPaths(name)
+	data, err := h.renderPreview(ctx, name, file)
+	if err != nil {
+		log.Printf("Error generating preview for %s: %s\n", name, err)
+		if !errors.Is(err, ErrPreviewFailed) {
+			return nil, err
+		}
+
+		record := &previewRecord{ETag: etag, Error: err.Error(), FailedUntil: time.Now().Add(h.previewFailureTTL())}
+		if data, err := json.Marshal(record); err == nil {
+			if err = writeFileAtomic(recordPath, data); err != nil {
+				log.Printf("Error caching preview failure for %s: %s\n", name, err)
+			}
+			h.trackArtifact(ArtifactPreview, name, recordPath)
+		}
+		return nil, err
+	}
+
+	recordData, _ := json.Marshal(&previewRecord{ETag: etag})
+	if err = errors.Join(writeFileAtomic(imagePath, data), writeFileAtomic(recordPath, recordData)); err != nil {
+		log.Printf("Error caching preview for %s: %s\n", name, err)
+	}
+	h.trackArtifact(ArtifactPreview, name, imagePath)
+	h.trackArtifact(ArtifactPreview, name, recordPath)
+	return data, nil
+}
+
+func (h *Handler) servePreview(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time, data []byte) {
+	_, contentType := h.previewFormat()
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if etag != "" {
+		w.Header().Set("ETag", strconv.Quote("preview-"+strings.Trim(etag, `"`)))
+	}
+	http.ServeContent(w, r, "", modTime, bytes.NewReader(data))
+}
+
+func (h *Handler) removePreview(name string) {
+	if !previewsEnabled(h.config.Previews) {
+		return
+	}
+
+	imagePath, recordPath := h.previewPaths(name)
+	for _, p := range []string{imagePath, recordPath} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error removing preview for %s: %s\n", name, err)
+		}
+	}
+}
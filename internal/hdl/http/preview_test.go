@@ -0,0 +1,166 @@
+package http
+
+import (
+	"bytes"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const fakeConverter = `#!/bin/sh
+echo x >> "$COUNT_FILE"
+case "$(cat "$1")" in
+*broken*) echo "syntax error in pdf" >&2; exit 1 ;;
+*slow*) exec sleep 5 ;;
+*huge*) cat "$PNG_FILE" "$PNG_FILE" "$PNG_FILE" > "$2"; exit 0 ;;
+esac
+cp "$PNG_FILE" "$2"
+`
+
+func TestPreviews(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake converter is a shell script")
+	}
+
+	testDir := t.TempDir()
+
+	bin := t.TempDir()
+	converter := filepath.Join(bin, "convert")
+	countFile := filepath.Join(bin, "count")
+	pngFile := filepath.Join(bin, "preview.png")
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, png.Encode(buf, image.NewGray(image.Rect(0, 0, 32, 32))))
+	fixture := buf.Bytes()
+	assert.Nil(t, os.WriteFile(pngFile, fixture, 0644))
+	assert.Nil(t, os.WriteFile(converter, []byte(fakeConverter), 0755))
+	t.Setenv("COUNT_FILE", countFile)
+	t.Setenv("PNG_FILE", pngFile)
+
+	cfg := &config.HTTPConfig{
+		Previews: &config.PreviewsConfig{
+			Command:    []string{converter, "{input}", "{output}"},
+			Timeout:    300 * time.Millisecond,
+			MaxSize:    int64(len(fixture) * 2),
+			FailureTTL: 500 * time.Millisecond,
+		},
+	}
+	hdl := New(port, testDir, cfg)
+
+	for name, content := range map[string]string{
+		"doc.pdf":    "%PDF-1.4 doc",
+		"broken.pdf": "%PDF-1.4 broken",
+		"slow.pdf":   "%PDF-1.4 slow",
+		"huge.pdf":   "%PDF-1.4 huge",
+		"notes.txt":  "text",
+	} {
+		assert.Nil(t, os.WriteFile(filepath.Join(testDir, name), []byte(content), 0644))
+	}
+
+	calls := func() int {
+		data, _ := os.ReadFile(countFile)
+		return strings.Count(string(data), "x")
+	}
+	get := func(name string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/thumb/"+name, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run(
+		"Disabled without a command", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			setupTestHandler(testDir).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/thumb/doc.pdf", nil))
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Unsupported type", func(t *testing.T) {
+			assert.Equal(t, http.StatusUnsupportedMediaType, get("notes.txt").Code)
+			assert.Equal(t, http.StatusNotFound, get("missing.pdf").Code)
+			assert.Equal(t, 0, calls())
+		},
+	)
+
+	t.Run(
+		"Generated and cached", func(t *testing.T) {
+			rec := get("doc.pdf")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+			assert.Equal(t, fixture, rec.Body.Bytes())
+
+			etag := rec.Header().Get("ETag")
+			assert.NotEmpty(t, etag)
+
+			rec = get("doc.pdf")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, fixture, rec.Body.Bytes())
+			assert.Equal(t, http.StatusNotModified, get("doc.pdf", "If-None-Match", etag).Code)
+			assert.Equal(t, 1, calls())
+
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, "doc.pdf"), []byte("%PDF-1.4 doc v2"), 0644))
+			assert.Equal(t, http.StatusOK, get("doc.pdf").Code)
+			assert.Equal(t, 2, calls())
+		},
+	)
+
+	t.Run(
+		"Converter failures are cached", func(t *testing.T) {
+			before := calls()
+			rec := get("broken.pdf")
+			assert.Equal(t, http.StatusBadGateway, rec.Code)
+			assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+			rec = get("broken.pdf")
+			assert.Equal(t, http.StatusBadGateway, rec.Code)
+			assert.Equal(t, before+1, calls())
+
+			time.Sleep(600 * time.Millisecond)
+			assert.Equal(t, http.StatusBadGateway, get("broken.pdf").Code)
+			assert.Equal(t, before+2, calls())
+		},
+	)
+
+	t.Run(
+		"Timeout and size cap", func(t *testing.T) {
+			start := time.Now()
+			assert.Equal(t, http.StatusBadGateway, get("slow.pdf").Code)
+			assert.Less(t, time.Since(start), 3*time.Second)
+
+			assert.Equal(t, http.StatusBadGateway, get("huge.pdf").Code)
+		},
+	)
+
+	t.Run(
+		"Invalid config", func(t *testing.T) {
+			assert.Panics(
+				t, func() {
+					New(port, testDir, &config.HTTPConfig{Previews: &config.PreviewsConfig{Command: []string{"pdftoppm"}}})
+				},
+			)
+			assert.Panics(
+				t, func() {
+					New(
+						port, testDir, &config.HTTPConfig{
+							Previews: &config.PreviewsConfig{Command: []string{"x", "{input}", "{output}"}, Format: "gif"},
+						},
+					)
+				},
+			)
+		},
+	)
+}
@@ -0,0 +1,184 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"github.com/JMURv/media-server/internal/jobs"
+	"github.com/JMURv/media-server/internal/webhook"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+const (
+	TaskVerify = "verify"
+	TaskProbe  = "probe"
+)
+
+func (h *Handler) initJobs() error {
+	cfg := h.config.Jobs
+	pool, err := jobs.New(
+		filepath.Join(h.savePath, ".jobs"),
+		jobs.Options{Workers: cfg.Workers, QueueSize: cfg.QueueSize, Retention: cfg.Retention},
+		h.metrics,
+	)
+	if err != nil {
+		return err
+	}
+
+	pool.Register(TaskVerify, h.verifyTask)
+	pool.Register(TaskProbe, h.probeTask)
+	pool.Register(TaskWaveform, h.waveformTask)
+	pool.Register(TaskPlaceholder, h.placeholderTask)
+	pool.Register(TaskWarm, h.warmTask)
+	if len(cfg.ScanCommand) > 0 {
+		pool.Register(TaskScan, h.scanTask)
+	}
+	if h.config.Adopt != nil {
+		pool.Register(TaskAdopt, h.adoptTask)
+	}
+	if h.config.Transcode != nil {
+		if err = h.initTranscode(pool); err != nil {
+			return err
+		}
+		pool.Register(TaskClip, h.clipTask)
+	}
+	pool.OnDone(
+		func(job *jobs.Job) {
+			if slices.Contains(job.Tasks, TaskAdopt) {
+				h.finishAdoption(job)
+				return
+			}
+			if slices.Contains(job.Tasks, TaskClip) {
+				h.finishClip(job)
+				return
+			}
+			data := map[string]any{"job": job.ID, "state": job.State, "tasks": job.Tasks}
+			if job.Error != "" {
+				data["error"] = job.Error
+			}
+			h.emit(webhook.FileProcessed, job.Name, nil, data)
+			h.settleState(job)
+		},
+	)
+
+	if err = pool.Validate(h.processingTasks()); err != nil {
+		return err
+	}
+	h.jobs = pool
+	return nil
+}
+
+func (h *Handler) processingTasks() []string {
+	if len(h.config.Jobs.Tasks) > 0 {
+		return h.config.Jobs.Tasks
+	}
+	return []string{TaskVerify, TaskProbe, TaskPlaceholder}
+}
+
+func (h *Handler) verifyTask(ctx context.Context, name string) error {
+	unlock := h.fileMu.Lock(name)
+	defer unlock()
+
+	checksum, err := h.storedChecksum(ctx, h.filePath(name))
+	if err != nil {
+		return err
+	}
+
+	m, err := h.meta.Get(name)
+	if err != nil {
+		return err
+	}
+	if m.Checksum == "" {
+		m.Checksum = checksum
+		return h.meta.Put(m)
+	}
+	if m.Checksum != checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+func (h *Handler) probeTask(ctx context.Context, name string) error {
+	if err := h.probeType(name); err != nil {
+		return err
+	}
+
+	res, err := h.analyzeAudio(ctx, name)
+	if errors.Is(err, ErrUnsupportedMediaType) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Error analyzing audio %s: %s\n", name, err)
+		return nil
+	}
+
+	unlock := h.fileMu.Lock(name)
+	defer unlock()
+
+	m, err := h.meta.Get(name)
+	if err != nil {
+		return err
+	}
+	m.Loudness = &res.Loudness
+	return h.meta.Put(m)
+}
+
+func (h *Handler) probeType(name string) error {
+	unlock := h.fileMu.Lock(name)
+	defer unlock()
+
+	file, err := h.openStored(h.ctx, h.filePath(name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	detected, err := sniffType(file)
+	if err != nil {
+		return err
+	}
+
+	m, err := h.meta.Get(name)
+	if err != nil {
+		return err
+	}
+	m.DetectedType = detected
+	return h.meta.Put(m)
+}
+
+func (h *Handler) listJobs(w http.ResponseWriter, r *http.Request) {
+	if h.jobs == nil {
+		writeError(w, ErrJobsDisabled)
+		return
+	}
+	utils.JSONResponse(w, http.StatusOK, h.jobs.List(r.URL.Query().Get("filename")))
+}
+
+func (h *Handler) getJob(w http.ResponseWriter, r *http.Request) {
+	if h.jobs == nil {
+		writeError(w, ErrJobsDisabled)
+		return
+	}
+
+	job, err := h.jobs.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	utils.JSONResponse(w, http.StatusOK, job)
+}
+
+func (h *Handler) rollbackUpload(name string) {
+	defer h.invalidateFile(name)
+	if err := os.Remove(h.filePath(name)); err != nil {
+		log.Printf("Error removing rejected upload %s: %s\n", name, err)
+	}
+	if err := h.meta.Delete(name); err != nil {
+		log.Printf("Error removing metadata for %s: %s\n", name, err)
+	}
+	h.removeOriginal(name)
+}
@@ -0,0 +1,151 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/jobs"
+	"github.com/JMURv/media-server/internal/webhook"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProcessingJobs(t *testing.T) {
+	testDir := t.TempDir()
+
+	var mu sync.Mutex
+	processed := make(map[string]map[string]any)
+	hook := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				e := webhook.Event{}
+				assert.Nil(t, json.Unmarshal(body, &e))
+				if e.Type == webhook.FileProcessed {
+					mu.Lock()
+					processed[e.Name] = e.Data.(map[string]any)
+					mu.Unlock()
+				}
+			},
+		),
+	)
+	defer hook.Close()
+
+	cfg := &config.HTTPConfig{
+		MaxUploadSize: 10 * 1024 * 1024,
+		Webhooks:      []*config.WebhookConfig{{URL: hook.URL}},
+		Jobs:          &config.JobsConfig{Workers: 1, QueueSize: 2},
+	}
+	hdl := New(port, testDir, cfg)
+
+	getJob := func(h *Handler, id string) (int, *jobs.Job) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+id, nil))
+
+		res := &jobs.Job{}
+		if rec.Code == http.StatusOK {
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+		}
+		return rec.Code, res
+	}
+
+	var first, second string
+	t.Run(
+		"Upload enqueues processing", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("image.png", "\x89PNG\r\n\x1a\nrest", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			res := decodeUpload(t, rec)
+			assert.True(t, res.Processing)
+			assert.Equal(t, []string{TaskVerify, TaskProbe, TaskPlaceholder}, res.Tasks)
+			first = res.Job
+
+			code, job := getJob(hdl, first)
+			assert.Equal(t, http.StatusOK, code)
+			assert.Equal(t, jobs.StatePending, job.State)
+			assert.Equal(t, "image.png", job.Name)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("corrupt.txt", "original", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			second = decodeUpload(t, rec).Job
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, "corrupt.txt"), []byte("tampered"), 0644))
+		},
+	)
+
+	t.Run(
+		"Backpressure", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("rejected.txt", "data", nil))
+			assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+			assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+			assert.NoFileExists(t, filepath.Join(testDir, "rejected.txt"))
+		},
+	)
+
+	t.Run(
+		"Restart re-runs incomplete jobs", func(t *testing.T) {
+			restarted := New(port, testDir, cfg)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go restarted.jobs.Run(ctx)
+
+			assert.Eventually(
+				t, func() bool {
+					_, a := getJob(restarted, first)
+					_, b := getJob(restarted, second)
+					return a.State == jobs.StateDone && b.State == jobs.StateFailed
+				}, time.Second, 10*time.Millisecond,
+			)
+
+			_, job := getJob(restarted, second)
+			assert.Contains(t, job.Error, ErrChecksumMismatch.Error())
+			assert.Equal(t, []string{}, job.Done)
+
+			m, err := restarted.meta.Get("image.png")
+			assert.Nil(t, err)
+			assert.Equal(t, "image/png", m.DetectedType)
+
+			rec := httptest.NewRecorder()
+			restarted.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs?filename=image.png", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			var list []*jobs.Job
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&list))
+			assert.Len(t, list, 1)
+			assert.Equal(t, first, list[0].ID)
+
+			assert.Eventually(
+				t, func() bool {
+					mu.Lock()
+					defer mu.Unlock()
+					return processed["image.png"]["state"] == jobs.StateDone &&
+						processed["corrupt.txt"]["state"] == jobs.StateFailed
+				}, time.Second, 10*time.Millisecond,
+			)
+		},
+	)
+
+	t.Run(
+		"Unknown job", func(t *testing.T) {
+			code, _ := getJob(hdl, "missing")
+			assert.Equal(t, http.StatusNotFound, code)
+		},
+	)
+
+	t.Run(
+		"Disabled", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			setupTestHandler(testDir).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs", nil))
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		},
+	)
+}
@@ -0,0 +1,167 @@
+package http
+
+import (
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	uploadIDHeader     = "X-Upload-Id"
+	uploadOffsetHeader = "Upload-Offset"
+	maxUploadIDLen     = 128
+	progressTTL        = time.Minute
+)
+
+const (
+	ProgressReceiving  = "receiving"
+	ProgressProcessing = "processing"
+	ProgressDone       = "done"
+	ProgressFailed     = "failed"
+)
+
+type UploadProgress struct {
+	ID       string `json:"id"`
+	Received int64  `json:"received"`
+	Total    int64  `json:"total"`
+	State    string `json:"state"`
+}
+
+type progressEntry struct {
+	total     int64
+	received  atomic.Int64
+	state     atomic.Value
+	expiresAt time.Time
+}
+
+type progressTracker struct {
+	mu      sync.Mutex
+	entries map[string]*progressEntry
+}
+
+func newProgressTracker() *progressTracker {
+	return &progressTracker{
+		entries: make(map[string]*progressEntry),
+	}
+}
+
+func (t *progressTracker) start(id string, total int64) *progressEntry {
+	e := &progressEntry{total: total}
+	e.state.Store(ProgressReceiving)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.expire(time.Now())
+	t.entries[id] = e
+	return e
+}
+
+func (t *progressTracker) finish(e *progressEntry, state string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e.state.Store(state)
+	e.expiresAt = time.Now().Add(progressTTL)
+}
+
+func (t *progressTracker) get(id string) (*UploadProgress, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.expire(time.Now())
+	e, ok := t.entries[id]
+	if !ok {
+		return nil, false
+	}
+	return &UploadProgress{
+		ID:       id,
+		Received: e.received.Load(),
+		Total:    e.total,
+		State:    e.state.Load().(string),
+	}, true
+}
+
+func (t *progressTracker) expire(now time.Time) {
+	for id, e := range t.entries {
+		if !e.expiresAt.IsZero() && now.After(e.expiresAt) {
+			delete(t.entries, id)
+		}
+	}
+}
+
+type progressReader struct {
+	io.ReadCloser
+	entry *progressEntry
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.entry.received.Add(int64(n))
+	return n, err
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (h *Handler) trackUpload(w http.ResponseWriter, r *http.Request) (*statusWriter, *progressEntry, error) {
+	id := r.Header.Get(uploadIDHeader)
+	if id == "" {
+		return nil, nil, nil
+	}
+	if len(id) > maxUploadIDLen {
+		return nil, nil, ErrInvalidUploadID
+	}
+
+	entry := h.progress.start(id, r.ContentLength)
+	r.Body = &progressReader{ReadCloser: r.Body, entry: entry}
+	return &statusWriter{ResponseWriter: w, code: http.StatusOK}, entry, nil
+}
+
+func (h *Handler) uploadProgress(w http.ResponseWriter, r *http.Request) {
+	res, ok := h.progress.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, ErrUploadNotFound)
+		return
+	}
+	utils.JSONResponse(w, http.StatusOK, res)
+}
+
+func (h *Handler) uploadOffset(w http.ResponseWriter, r *http.Request) {
+	name, err := h.pathName(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	path, err := h.checkPath(name)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	info, err := h.statStored(path)
+	if err != nil || info.IsDir() {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(info.Size(), 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,139 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type slowReader struct {
+	r     io.Reader
+	chunk int
+	delay time.Duration
+}
+
+func (s *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	return s.r.Read(p[:min(len(p), s.chunk)])
+}
+
+func getProgress(t *testing.T, routes http.Handler, id string) (int, *UploadProgress) {
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/upload/progress/"+id, nil))
+
+	res := &UploadProgress{}
+	if rec.Code == http.StatusOK {
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+	}
+	return rec.Code, res
+}
+
+func TestUploadProgress(t *testing.T) {
+	testDir := t.TempDir()
+	hdl := setupTestHandler(testDir)
+	routes := hdl.routes()
+
+	t.Run(
+		"Multipart upload", func(t *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			file, _ := writer.CreateFormFile("file", "slow.bin")
+			file.Write(bytes.Repeat([]byte("x"), 64*1024))
+			writer.Close()
+			total := int64(body.Len())
+
+			req := httptest.NewRequest(http.MethodPost, "/upload", &slowReader{r: body, chunk: 4096, delay: 5 * time.Millisecond})
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			req.Header.Set(uploadIDHeader, "slow-1")
+			req.ContentLength = total
+
+			rec := httptest.NewRecorder()
+			done := make(chan struct{})
+			go func() {
+				routes.ServeHTTP(rec, req)
+				close(done)
+			}()
+
+			var seen []int64
+			for {
+				select {
+				case <-done:
+				case <-time.After(10 * time.Millisecond):
+					if code, p := getProgress(t, routes, "slow-1"); code == http.StatusOK && p.State == ProgressReceiving {
+						assert.Equal(t, total, p.Total)
+						seen = append(seen, p.Received)
+					}
+					continue
+				}
+				break
+			}
+
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.GreaterOrEqual(t, len(seen), 2)
+			assert.IsNonDecreasing(t, seen)
+			assert.Less(t, seen[0], total)
+
+			code, p := getProgress(t, routes, "slow-1")
+			assert.Equal(t, http.StatusOK, code)
+			assert.Equal(t, ProgressDone, p.State)
+			assert.Equal(t, total, p.Received)
+		},
+	)
+
+	t.Run(
+		"Failed upload", func(t *testing.T) {
+			req := newUploadRequest("slow.bin", "dup", nil)
+			req.Header.Set(uploadIDHeader, "slow-2")
+
+			rec := httptest.NewRecorder()
+			routes.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusConflict, rec.Code)
+
+			_, p := getProgress(t, routes, "slow-2")
+			assert.Equal(t, ProgressFailed, p.State)
+		},
+	)
+
+	t.Run(
+		"Expired entries", func(t *testing.T) {
+			hdl.progress.expire(time.Now().Add(2 * progressTTL))
+			code, _ := getProgress(t, routes, "slow-1")
+			assert.Equal(t, http.StatusNotFound, code)
+		},
+	)
+
+	t.Run(
+		"Invalid id", func(t *testing.T) {
+			req := newUploadRequest("other.bin", "data", nil)
+			req.Header.Set(uploadIDHeader, strings.Repeat("a", maxUploadIDLen+1))
+
+			rec := httptest.NewRecorder()
+			routes.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Offset of ranged upload", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newPatchRequest("chunked.bin", "12345", "bytes 0-4/*"))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			rec = httptest.NewRecorder()
+			routes.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/files/chunked.bin", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "5", rec.Header().Get(uploadOffsetHeader))
+
+			rec = httptest.NewRecorder()
+			routes.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/files/missing.bin", nil))
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		},
+	)
+}
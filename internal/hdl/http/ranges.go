@@ -0,0 +1,231 @@
+package http
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/internal/meta"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultMaxRanges = 10
+
+var errNoOverlap = errors.New("no satisfiable range")
+
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (br byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.start, br.start+br.length-1, size)
+}
+
+// parseRange parses a Range header against a representation of size bytes.
+// ErrInvalidRange means the header should be ignored, errNoOverlap that it
+// deserves a 416. No range of an empty representation is satisfiable, so
+// every valid one deserves a 416, unlike net/http, which serves it whole.
+func parseRange(v string, size int64) ([]byteRange, error) {
+	spec, ok := strings.CutPrefix(v, "bytes=")
+	if !ok {
+		return nil, ErrInvalidRange
+	}
+	var res []byteRange
+	overlap := false
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		s, e, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, ErrInvalidRange
+		}
+		s, e = strings.TrimSpace(s), strings.TrimSpace(e)
+
+		var br byteRange
+		if s == "" {
+			n, err := strconv.ParseInt(e, 10, 64)
+			if err != nil || n < 0 {
+				return nil, ErrInvalidRange
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			br = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || start < 0 {
+				return nil, ErrInvalidRange
+			}
+			if start >= size {
+				continue
+			}
+
+			end := size - 1
+			if e != "" {
+				if end, err = strconv.ParseInt(e, 10, 64); err != nil || end < start {
+					return nil, ErrInvalidRange
+				}
+				end = min(end, size-1)
+			}
+			br = byteRange{start: start, length: end - start + 1}
+		}
+
+		overlap = true
+		res = append(res, br)
+	}
+
+	if !overlap {
+		return nil, errNoOverlap
+	}
+	return res, nil
+}
+
+func (h *Handler) maxRanges() int {
+	if h.config.MaxRanges > 0 {
+		return h.config.MaxRanges
+	}
+	return defaultMaxRanges
+}
+
+func coalesce(ranges []byteRange) []byteRange {
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b byteRange) int { return cmp.Compare(a.start, b.start) })
+
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].start < sorted[i-1].start+sorted[i-1].length {
+			var end int64
+			for _, br := range sorted {
+				end = max(end, br.start+br.length)
+			}
+			return []byteRange{{start: sorted[0].start, length: end - sorted[0].start}}
+		}
+	}
+	return ranges
+}
+
+func writeRanges(w http.ResponseWriter, file io.ReadSeeker, ranges []byteRange, size int64) error {
+	contentType := w.Header().Get("Content-Type")
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusPartialContent)
+
+	for _, br := range ranges {
+		header := textproto.MIMEHeader{"Content-Range": {br.contentRange(size)}}
+		if contentType != "" {
+			header.Set("Content-Type", contentType)
+		}
+
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if _, err = file.Seek(br.start, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err = io.CopyN(part, file, br.length); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+func ifRangeMatches(r *http.Request, etag string, modTime time.Time) bool {
+	v := strings.TrimSpace(r.Header.Get("If-Range"))
+	if v == "" {
+		return true
+	}
+
+	if strings.HasPrefix(v, `"`) || strings.HasPrefix(v, "W/") {
+		return etag != "" && !strings.HasPrefix(etag, "W/") && v == etag
+	}
+
+	t, err := http.ParseTime(v)
+	return err == nil && !modTime.IsZero() && modTime.UTC().Truncate(time.Second).Equal(t)
+}
+
+// etagListed reports whether an If-None-Match header lists etag. The
+// comparison is weak, as RFC 9110 requires for If-None-Match.
+func etagListed(header, etag string) bool {
+	if header == "" || etag == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, v := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(v), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func fileETag(m *meta.File, info os.FileInfo) string {
+	if m != nil && m.Checksum != "" && m.Size == info.Size() && !info.ModTime().After(m.UpdatedAt) {
+		return strconv.Quote(m.Checksum)
+	}
+	return fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
+
+func (h *Handler) validators(name string, file io.ReadSeekCloser) (string, time.Time) {
+	m, err := h.meta.Get(name)
+	if err != nil {
+		m = nil
+	}
+
+	if f, ok := file.(interface{ Stat() (os.FileInfo, error) }); ok {
+		info, err := f.Stat()
+		if err != nil {
+			return "", time.Time{}
+		}
+		return fileETag(m, info), info.ModTime()
+	}
+
+	if m == nil || m.Checksum == "" {
+		return "", time.Time{}
+	}
+	return strconv.Quote(m.Checksum), m.UpdatedAt
+}
+
+func setValidators(w http.ResponseWriter, etag string, modTime time.Time) {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !modTime.IsZero() {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+}
+
+// normalizeRange brings the file server under /uploads in line with stream:
+// invalid specs and other units are ignored rather than refused, and
+// unsatisfiable ranges, which are all ranges of an empty file, get a 416
+// naming the size.
+func normalizeRange(w http.ResponseWriter, r *http.Request, etag string, info os.FileInfo) bool {
+	v := r.Header.Get("Range")
+	if v == "" || !ifRangeMatches(r, etag, info.ModTime()) {
+		return true
+	}
+
+	ranges, err := parseRange(v, info.Size())
+	if errors.Is(err, errNoOverlap) {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size()))
+		writeError(w, ErrInvalidRange)
+		return false
+	}
+	if err != nil || len(ranges) == 0 {
+		r.Header.Del("Range")
+	}
+	return true
+}
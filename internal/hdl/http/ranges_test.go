@@ -0,0 +1,325 @@
+package http
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIfRange(t *testing.T) {
+	testDir := t.TempDir()
+	hdl := setupTestHandler(testDir)
+
+	upload := func(content string) {
+		req := httptest.NewRequest(http.MethodDelete, "/files/resume.mp4", nil)
+		hdl.ServeHTTP(httptest.NewRecorder(), req)
+
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, newUploadRequest("resume.mp4", content, nil))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	}
+
+	get := func(path, rng, ifRange string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		if ifRange != "" {
+			req.Header.Set("If-Range", ifRange)
+		}
+
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, path := range []string{"/stream/resume.mp4", "/uploads/resume.mp4"} {
+		t.Run(
+			"ETag validator "+path, func(t *testing.T) {
+				upload("version-one-bytes")
+
+				rec := get(path, "bytes=0-6", "")
+				assert.Equal(t, http.StatusPartialContent, rec.Code)
+				assert.Equal(t, "version", rec.Body.String())
+				assert.Equal(t, "bytes 0-6/17", rec.Header().Get("Content-Range"))
+				etag := rec.Header().Get("ETag")
+				assert.NotEmpty(t, etag)
+
+				rec = get(path, "bytes=7-", etag)
+				assert.Equal(t, http.StatusPartialContent, rec.Code)
+				assert.Equal(t, "-one-bytes", rec.Body.String())
+
+				upload("version-two-bytes")
+
+				rec = get(path, "bytes=7-", etag)
+				assert.Equal(t, http.StatusOK, rec.Code)
+				assert.Equal(t, "version-two-bytes", rec.Body.String())
+				assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+
+				rec = get(path, "bytes=7-", "W/"+etag)
+				assert.Equal(t, http.StatusOK, rec.Code)
+			},
+		)
+	}
+
+	t.Run(
+		"Date validator", func(t *testing.T) {
+			upload("dated-content")
+			p := filepath.Join(testDir, "resume.mp4")
+			mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+			assert.Nil(t, os.Chtimes(p, mtime, mtime))
+
+			rec := get("/stream/resume.mp4", "bytes=0-4", "")
+			lastModified := rec.Header().Get("Last-Modified")
+			assert.Equal(t, mtime.UTC().Format(http.TimeFormat), lastModified)
+
+			rec = get("/stream/resume.mp4", "bytes=6-", lastModified)
+			assert.Equal(t, http.StatusPartialContent, rec.Code)
+			assert.Equal(t, "content", rec.Body.String())
+
+			assert.Nil(t, os.WriteFile(p, []byte("dated-REPLACE"), 0644))
+			rec = get("/stream/resume.mp4", "bytes=6-", lastModified)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "dated-REPLACE", rec.Body.String())
+		},
+	)
+
+	t.Run(
+		"Unsatisfiable", func(t *testing.T) {
+			rec := get("/stream/resume.mp4", "bytes=100-", "")
+			assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, rec.Code)
+			assert.Equal(t, "bytes */13", rec.Header().Get("Content-Range"))
+		},
+	)
+}
+
+func TestMultiRange(t *testing.T) {
+	testDir := t.TempDir()
+	hdl := setupTestHandler(testDir)
+
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	assert.Nil(t, os.WriteFile(filepath.Join(testDir, "multi.mp4"), []byte(content), 0644))
+
+	get := func(rng string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/stream/multi.mp4", nil)
+		req.Header.Set("Range", rng)
+
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run(
+		"Two ranges", func(t *testing.T) {
+			rec := get("bytes=0-3,10-13")
+			assert.Equal(t, http.StatusPartialContent, rec.Code)
+
+			mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+			assert.Nil(t, err)
+			assert.Equal(t, "multipart/byteranges", mediaType)
+
+			b := params["boundary"]
+			expected := "--" + b + "\r\n" +
+				"Content-Range: bytes 0-3/36\r\n" +
+				"Content-Type: video/mp4\r\n" +
+				"\r\n" +
+				"0123\r\n" +
+				"--" + b + "\r\n" +
+				"Content-Range: bytes 10-13/36\r\n" +
+				"Content-Type: video/mp4\r\n" +
+				"\r\n" +
+				"abcd\r\n" +
+				"--" + b + "--\r\n"
+			assert.Equal(t, expected, rec.Body.String())
+		},
+	)
+
+	t.Run(
+		"Overlapping ranges are coalesced", func(t *testing.T) {
+			rec := get("bytes=0-9,5-14,-3")
+			assert.Equal(t, http.StatusPartialContent, rec.Code)
+			assert.Equal(t, "bytes 0-35/36", rec.Header().Get("Content-Range"))
+			assert.Equal(t, content, rec.Body.String())
+
+			rec = get("bytes=2-5,4-7")
+			assert.Equal(t, "bytes 2-7/36", rec.Header().Get("Content-Range"))
+			assert.Equal(t, "234567", rec.Body.String())
+		},
+	)
+
+	t.Run(
+		"Too many ranges", func(t *testing.T) {
+			hdl.config.MaxRanges = 2
+			defer func() { hdl.config.MaxRanges = 0 }()
+
+			rec := get("bytes=0-0,2-2,4-4")
+			assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, rec.Code)
+			assert.Equal(t, "bytes */36", rec.Header().Get("Content-Range"))
+		},
+	)
+}
+
+// TestRangeConformance checks the stream endpoint against the range cases
+// from RFC 9110 that caches and CDNs probe, over a real connection so HEAD
+// and framing behave as they do in production.
+func TestRangeConformance(t *testing.T) {
+	testDir := t.TempDir()
+
+	content := "0123456789"
+	assert.Nil(t, os.WriteFile(filepath.Join(testDir, "ten.mp4"), []byte(content), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(testDir, "empty.mp4"), nil, 0644))
+
+	srv := httptest.NewServer(setupTestHandler(testDir))
+	defer srv.Close()
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	type result struct {
+		code          int
+		body          string
+		contentRange  string
+		contentLength string
+		header        http.Header
+	}
+	do := func(method, path string, header map[string]string) result {
+		req, err := http.NewRequest(method, srv.URL+path, nil)
+		assert.Nil(t, err)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+
+		res, err := client.Do(req)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return result{
+			code:          res.StatusCode,
+			body:          string(body),
+			contentRange:  res.Header.Get("Content-Range"),
+			contentLength: res.Header.Get("Content-Length"),
+			header:        res.Header,
+		}
+	}
+	rng := func(v string) map[string]string { return map[string]string{"Range": v} }
+
+	for _, path := range []string{"/stream/ten.mp4", "/uploads/ten.mp4"} {
+		t.Run(
+			"Satisfiable ranges "+path, func(t *testing.T) {
+				for spec, want := range map[string][2]string{
+					"bytes=0-0":    {"0", "bytes 0-0/10"},
+					"bytes=9-9":    {"9", "bytes 9-9/10"},
+					"bytes=9-":     {"9", "bytes 9-9/10"},
+					"bytes=-1":     {"9", "bytes 9-9/10"},
+					"bytes=-20":    {content, "bytes 0-9/10"},
+					"bytes=5-100":  {"56789", "bytes 5-9/10"},
+					"bytes= 2 - 3": {"23", "bytes 2-3/10"},
+				} {
+					res := do(http.MethodGet, path, rng(spec))
+					assert.Equal(t, http.StatusPartialContent, res.code, spec)
+					assert.Equal(t, want[0], res.body, spec)
+					assert.Equal(t, want[1], res.contentRange, spec)
+					assert.Equal(t, strconv.Itoa(len(want[0])), res.contentLength, spec)
+					assert.Equal(t, "bytes", res.header.Get("Accept-Ranges"), spec)
+				}
+			},
+		)
+
+		t.Run(
+			"Unsatisfiable ranges "+path, func(t *testing.T) {
+				for _, spec := range []string{"bytes=10-", "bytes=10-10", "bytes=-0", "bytes=20-30,40-"} {
+					res := do(http.MethodGet, path, rng(spec))
+					assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, res.code, spec)
+					assert.Equal(t, "bytes */10", res.contentRange, spec)
+				}
+			},
+		)
+
+		t.Run(
+			"Invalid ranges and other units are ignored "+path, func(t *testing.T) {
+				for _, spec := range []string{"bytes=5-2", "items=0-1", "bytes=abc", "bytes=0-1,x", "bytes"} {
+					res := do(http.MethodGet, path, rng(spec))
+					assert.Equal(t, http.StatusOK, res.code, spec)
+					assert.Equal(t, content, res.body, spec)
+					assert.Empty(t, res.contentRange, spec)
+				}
+			},
+		)
+
+		t.Run(
+			"Zero-length file "+path, func(t *testing.T) {
+				empty := strings.Replace(path, "ten", "empty", 1)
+				res := do(http.MethodGet, empty, nil)
+				assert.Equal(t, http.StatusOK, res.code)
+				assert.Empty(t, res.body)
+				assert.Equal(t, "0", res.contentLength)
+				assert.Empty(t, res.header.Get("Transfer-Encoding"))
+
+				for _, spec := range []string{"bytes=0-", "bytes=0-0", "bytes=-5", "bytes=-0"} {
+					res = do(http.MethodGet, empty, rng(spec))
+					assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, res.code, spec)
+					assert.Equal(t, "bytes */0", res.contentRange, spec)
+				}
+				res = do(http.MethodGet, empty, rng("items=0-1"))
+				assert.Equal(t, http.StatusOK, res.code)
+				assert.Empty(t, res.contentRange)
+			},
+		)
+
+		t.Run(
+			"HEAD "+path, func(t *testing.T) {
+				res := do(http.MethodHead, path, nil)
+				assert.Equal(t, http.StatusOK, res.code)
+				assert.Equal(t, "10", res.contentLength)
+				assert.Equal(t, "bytes", res.header.Get("Accept-Ranges"))
+				assert.Empty(t, res.body)
+
+				res = do(http.MethodHead, path, rng("bytes=0-0"))
+				assert.Equal(t, http.StatusPartialContent, res.code)
+				assert.Equal(t, "bytes 0-0/10", res.contentRange)
+				assert.Equal(t, "1", res.contentLength)
+				assert.Empty(t, res.body)
+
+				res = do(http.MethodHead, path, rng("bytes=10-"))
+				assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, res.code)
+				assert.Equal(t, "bytes */10", res.contentRange)
+			},
+		)
+
+		t.Run(
+			"If-Range "+path, func(t *testing.T) {
+				etag := do(http.MethodHead, path, nil).header.Get("ETag")
+				assert.NotEmpty(t, etag)
+
+				res := do(http.MethodGet, path, map[string]string{"Range": "bytes=0-0", "If-Range": etag})
+				assert.Equal(t, http.StatusPartialContent, res.code)
+				assert.Equal(t, "0", res.body)
+
+				for _, v := range []string{`"stale"`, "W/" + etag} {
+					res = do(http.MethodGet, path, map[string]string{"Range": "bytes=0-0", "If-Range": v})
+					assert.Equal(t, http.StatusOK, res.code, v)
+					assert.Equal(t, content, res.body, v)
+				}
+
+				res = do(http.MethodGet, path, map[string]string{"Range": "bytes=10-", "If-Range": `"stale"`})
+				assert.Equal(t, http.StatusOK, res.code)
+				assert.Equal(t, content, res.body)
+			},
+		)
+
+		t.Run(
+			"Ranges apply to the identity encoding "+path, func(t *testing.T) {
+				res := do(http.MethodGet, path, map[string]string{"Range": "bytes=2-5", "Accept-Encoding": "gzip"})
+				assert.Equal(t, http.StatusPartialContent, res.code)
+				assert.Equal(t, "2345", res.body)
+				assert.Empty(t, res.header.Get("Content-Encoding"))
+			},
+		)
+	}
+}
@@ -0,0 +1,264 @@
+package http
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	requestIDHeader  = "X-Request-Id"
+	receiptAlgorithm = "Ed25519"
+	maxReceiptBody   = 64 << 10
+)
+
+// ReceiptPayload is the statement a receipt signs: that the server stored
+// Size bytes hashing to SHA256 as Name, at Timestamp, for the request
+// RequestID.
+type ReceiptPayload struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	Timestamp time.Time `json:"timestamp"`
+	RequestID string    `json:"request_id"`
+	KeyID     string    `json:"key_id"`
+}
+
+type ReceiptKey struct {
+	ID        string `json:"id"`
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"`
+	Active    bool   `json:"active,omitempty"`
+}
+
+type ReceiptKeys struct {
+	Keys []ReceiptKey `json:"keys"`
+}
+
+// ReceiptVerification is the outcome of checking a receipt against the file
+// stored under its name now, whose Size and SHA256 are given when it exists.
+type ReceiptVerification struct {
+	Status  string          `json:"status"`
+	Receipt *ReceiptPayload `json:"receipt"`
+	Size    int64           `json:"size,omitempty"`
+	SHA256  string          `json:"sha256,omitempty"`
+}
+
+type receiptSigner struct {
+	active string
+	key    ed25519.PrivateKey
+	public map[string]ed25519.PublicKey
+}
+
+func decodeKey(v string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+}
+
+func newReceiptSigner(cfg *config.ReceiptsConfig) (*receiptSigner, error) {
+	s := &receiptSigner{active: cfg.ActiveKey, public: make(map[string]ed25519.PublicKey)}
+	for id, v := range cfg.Keys {
+		key, err := decodeKey(v)
+		if err != nil {
+			return nil, fmt.Errorf("receipt key %s: %w", id, err)
+		}
+		switch len(key) {
+		case ed25519.SeedSize:
+			key = ed25519.NewKeyFromSeed(key)
+		case ed25519.PrivateKeySize:
+		default:
+			return nil, fmt.Errorf("receipt key %s: %d bytes is not an Ed25519 key", id, len(key))
+		}
+		priv := ed25519.PrivateKey(key)
+		if id == s.active {
+			s.key = priv
+		}
+		s.public[id] = priv.Public().(ed25519.PublicKey)
+	}
+	if s.key == nil {
+		return nil, fmt.Errorf("active receipt key %q is not among the keys", s.active)
+	}
+
+	for id, v := range cfg.RetiredKeys {
+		if _, ok := s.public[id]; ok {
+			return nil, fmt.Errorf("receipt key %s is both active and retired", id)
+		}
+		key, err := decodeKey(v)
+		if err != nil {
+			return nil, fmt.Errorf("retired receipt key %s: %w", id, err)
+		}
+		if len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("retired receipt key %s: %d bytes is not an Ed25519 public key", id, len(key))
+		}
+		s.public[id] = key
+	}
+	return s, nil
+}
+
+// wantsReceipt reports whether the upload in r asked for a receipt, and
+// fails it when receipts are not configured.
+func (h *Handler) wantsReceipt(r *http.Request) (bool, error) {
+	if r.URL.Query().Get("receipt") != "true" {
+		return false, nil
+	}
+	if h.receipts == nil {
+		return false, ErrReceiptsDisabled
+	}
+	return true, nil
+}
+
+// receiptRequestID is the request id the client sent, when it is a usable
+// one, or a new one.
+func receiptRequestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" && validIdempotencyKey(id) {
+		return id
+	}
+	return newUUID()
+}
+
+// signReceipt signs a receipt for name, stored by r, and echoes the request
+// id it names.
+func (h *Handler) signReceipt(w http.ResponseWriter, r *http.Request, name string, size int64, checksum string) (*utils.Receipt, error) {
+	p := &ReceiptPayload{
+		Name:      name,
+		Size:      size,
+		SHA256:    strings.ToLower(checksum),
+		Timestamp: time.Now().UTC(),
+		RequestID: receiptRequestID(r),
+		KeyID:     h.receipts.active,
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Set(requestIDHeader, p.RequestID)
+	return &utils.Receipt{
+		Payload:   base64.StdEncoding.EncodeToString(data),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(h.receipts.key, data)),
+	}, nil
+}
+
+// openReceipt checks the signature of rec and returns the statement it
+// signs.
+func (h *Handler) openReceipt(rec *utils.Receipt) (*ReceiptPayload, error) {
+	data, err := base64.StdEncoding.DecodeString(rec.Payload)
+	if err != nil {
+		return nil, withParams(ErrInvalidReceipt, map[string]any{"reason": "payload is not base64"})
+	}
+	sig, err := base64.StdEncoding.DecodeString(rec.Signature)
+	if err != nil {
+		return nil, withParams(ErrInvalidReceipt, map[string]any{"reason": "signature is not base64"})
+	}
+
+	p := &ReceiptPayload{}
+	if err = json.Unmarshal(data, p); err != nil {
+		return nil, withParams(ErrInvalidReceipt, map[string]any{"reason": "payload is not a receipt"})
+	}
+	key, ok := h.receipts.public[p.KeyID]
+	if !ok {
+		return nil, withParams(ErrInvalidReceipt, map[string]any{"reason": "unknown key", "key_id": p.KeyID})
+	}
+	if !ed25519.Verify(key, data, sig) {
+		return nil, withParams(ErrInvalidReceipt, map[string]any{"reason": "bad signature", "key_id": p.KeyID})
+	}
+	return p, nil
+}
+
+// receiptKeys publishes the public keys receipts are verified with, retired
+// ones included, so receipts outlive the rotation of the key that signed
+// them.
+func (h *Handler) receiptKeys(w http.ResponseWriter, r *http.Request) {
+	if h.receipts == nil {
+		writeError(w, ErrReceiptsDisabled)
+		return
+	}
+
+	res := &ReceiptKeys{Keys: make([]ReceiptKey, 0, len(h.receipts.public))}
+	for id, key := range h.receipts.public {
+		res.Keys = append(
+			res.Keys, ReceiptKey{
+				ID:        id,
+				Algorithm: receiptAlgorithm,
+				PublicKey: base64.StdEncoding.EncodeToString(key),
+				Active:    id == h.receipts.active,
+			},
+		)
+	}
+	slices.SortFunc(
+		res.Keys, func(a, b ReceiptKey) int {
+			if a.Active != b.Active {
+				if a.Active {
+					return -1
+				}
+				return 1
+			}
+			return strings.Compare(a.ID, b.ID)
+		},
+	)
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.JSONResponse(w, http.StatusOK, res)
+}
+
+// verifyReceipt checks a receipt's signature, then whether the file it names
+// still holds what it states.
+func (h *Handler) verifyReceipt(w http.ResponseWriter, r *http.Request) {
+	if h.receipts == nil {
+		writeError(w, ErrReceiptsDisabled)
+		return
+	}
+
+	rec := &utils.Receipt{}
+	if !h.decodeJSON(w, r, rec) {
+		return
+	}
+	p, err := h.openReceipt(rec)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res := &ReceiptVerification{Status: VerifyMissing, Receipt: p}
+	name, err := h.cleanName(p.Name)
+	if err != nil {
+		utils.JSONResponse(w, http.StatusOK, res)
+		return
+	}
+	if !h.checkInternal(w, r, name) {
+		return
+	}
+	path, err := h.checkPath(name)
+	if err != nil {
+		utils.JSONResponse(w, http.StatusOK, res)
+		return
+	}
+	info, err := h.statStored(path)
+	if err != nil || !info.Mode().IsRegular() {
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Error checking %s for a receipt: %s\n", name, err)
+		}
+		utils.JSONResponse(w, http.StatusOK, res)
+		return
+	}
+
+	checksum, err := h.cachedChecksum(r.Context(), name, info)
+	if err != nil {
+		log.Printf("Error computing checksum for %s: %s\n", name, err)
+		writeError(w, canceledOr(err, ErrInternal))
+		return
+	}
+	res.Status, res.Size, res.SHA256 = VerifyDiffers, info.Size(), checksum
+	if info.Size() == p.Size && strings.EqualFold(checksum, p.SHA256) {
+		res.Status = VerifyMatch
+	}
+	utils.JSONResponse(w, http.StatusOK, res)
+}
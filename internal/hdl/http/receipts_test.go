@@ -0,0 +1,175 @@
+package http
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReceipts(t *testing.T) {
+	testDir := t.TempDir()
+
+	seed1 := bytes.Repeat([]byte{1}, ed25519.SeedSize)
+	seed2 := bytes.Repeat([]byte{2}, ed25519.SeedSize)
+	newHandler := func(cfg *config.ReceiptsConfig) *Handler {
+		return New(port, testDir, &config.HTTPConfig{MaxUploadSize: 1 << 20, Receipts: cfg})
+	}
+	hdl := newHandler(
+		&config.ReceiptsConfig{
+			Keys:      map[string]string{"r1": base64.StdEncoding.EncodeToString(seed1)},
+			ActiveKey: "r1",
+		},
+	)
+
+	serve := func(h *Handler, req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	upload := func(target, name, content string) (*httptest.ResponseRecorder, utils.UploadResponse) {
+		req := newUploadRequest(name, content, nil)
+		req.URL.RawQuery = target
+		req.Header.Set(requestIDHeader, "req-42")
+		rec := serve(hdl, req)
+		res := utils.UploadResponse{}
+		json.NewDecoder(rec.Body).Decode(&res)
+		return rec, res
+	}
+	verify := func(h *Handler, receipt *utils.Receipt) (*httptest.ResponseRecorder, ReceiptVerification) {
+		body, _ := json.Marshal(receipt)
+		rec := serve(h, httptest.NewRequest(http.MethodPost, "/receipts/verify", bytes.NewReader(body)))
+		res := ReceiptVerification{}
+		json.NewDecoder(bytes.NewReader(rec.Body.Bytes())).Decode(&res)
+		return rec, res
+	}
+	keys := func(h *Handler) ReceiptKeys {
+		rec := serve(h, httptest.NewRequest(http.MethodGet, "/.well-known/receipt-key", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		res := ReceiptKeys{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res
+	}
+
+	content := "signed for"
+	var receipt *utils.Receipt
+	t.Run(
+		"Uploads are signed", func(t *testing.T) {
+			rec, res := upload("receipt=true", "doc.txt", content)
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, "req-42", rec.Header().Get(requestIDHeader))
+			if !assert.NotNil(t, res.Receipt) {
+				return
+			}
+			receipt = res.Receipt
+
+			published := keys(hdl)
+			assert.Len(t, published.Keys, 1)
+			assert.True(t, published.Keys[0].Active)
+			public, err := base64.StdEncoding.DecodeString(published.Keys[0].PublicKey)
+			assert.Nil(t, err)
+
+			data, _ := base64.StdEncoding.DecodeString(receipt.Payload)
+			sig, _ := base64.StdEncoding.DecodeString(receipt.Signature)
+			assert.True(t, ed25519.Verify(public, data, sig))
+
+			p := ReceiptPayload{}
+			assert.Nil(t, json.Unmarshal(data, &p))
+			sum := sha256.Sum256([]byte(content))
+			assert.Equal(t, "doc.txt", p.Name)
+			assert.Equal(t, int64(len(content)), p.Size)
+			assert.Equal(t, hex.EncodeToString(sum[:]), p.SHA256)
+			assert.Equal(t, "req-42", p.RequestID)
+			assert.Equal(t, "r1", p.KeyID)
+			assert.False(t, p.Timestamp.IsZero())
+
+			_, res = upload("", "plain.txt", content)
+			assert.Nil(t, res.Receipt)
+		},
+	)
+
+	t.Run(
+		"Receipts are checked against the stored file", func(t *testing.T) {
+			rec, res := verify(hdl, receipt)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, VerifyMatch, res.Status)
+
+			rec, _ = upload("conflict=overwrite", "doc.txt", "changed since")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			_, res = verify(hdl, receipt)
+			assert.Equal(t, VerifyDiffers, res.Status)
+			assert.Equal(t, int64(len("changed since")), res.Size)
+
+			rec = serve(hdl, httptest.NewRequest(http.MethodDelete, "/files/doc.txt", nil))
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+			_, res = verify(hdl, receipt)
+			assert.Equal(t, VerifyMissing, res.Status)
+		},
+	)
+
+	t.Run(
+		"Tampered receipts are rejected", func(t *testing.T) {
+			data, _ := base64.StdEncoding.DecodeString(receipt.Payload)
+			forged := strings.Replace(string(data), `"size":10`, `"size":11`, 1)
+			rec, _ := verify(
+				hdl, &utils.Receipt{
+					Payload:   base64.StdEncoding.EncodeToString([]byte(forged)),
+					Signature: receipt.Signature,
+				},
+			)
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+			assert.Contains(t, rec.Body.String(), "invalid_receipt")
+
+			rec, _ = verify(hdl, &utils.Receipt{Payload: receipt.Payload, Signature: "bm90IGEgc2lnbmF0dXJl"})
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Old receipts outlive rotation", func(t *testing.T) {
+			old := ed25519.NewKeyFromSeed(seed1).Public().(ed25519.PublicKey)
+			rotated := newHandler(
+				&config.ReceiptsConfig{
+					Keys:        map[string]string{"r2": base64.StdEncoding.EncodeToString(seed2)},
+					ActiveKey:   "r2",
+					RetiredKeys: map[string]string{"r1": base64.StdEncoding.EncodeToString(old)},
+				},
+			)
+
+			published := keys(rotated)
+			if assert.Len(t, published.Keys, 2) {
+				assert.Equal(t, "r2", published.Keys[0].ID)
+				assert.True(t, published.Keys[0].Active)
+				assert.Equal(t, "r1", published.Keys[1].ID)
+				assert.False(t, published.Keys[1].Active)
+			}
+
+			rec, res := verify(rotated, receipt)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "r1", res.Receipt.KeyID)
+		},
+	)
+
+	t.Run(
+		"Disabled", func(t *testing.T) {
+			h := setupTestHandler(testDir)
+			req := newUploadRequest("other.txt", content, nil)
+			req.URL.RawQuery = "receipt=true"
+			rec := serve(h, req)
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+			assert.Contains(t, rec.Body.String(), "receipts_disabled")
+
+			rec = serve(h, httptest.NewRequest(http.MethodGet, "/.well-known/receipt-key", nil))
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		},
+	)
+}
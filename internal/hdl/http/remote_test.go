@@ -0,0 +1,130 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRemoteBackend(t *testing.T) {
+	testDir := t.TempDir()
+
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+	gets := 0
+	store := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				name := strings.TrimPrefix(r.URL.Path, "/")
+				mu.Lock()
+				defer mu.Unlock()
+				switch r.Method {
+				case http.MethodPut:
+					objects[name], _ = io.ReadAll(r.Body)
+					return
+				case http.MethodGet:
+					gets++
+				}
+				data, ok := objects[name]
+				if !ok {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Header().Set("ETag", `"v1"`)
+				http.ServeContent(w, r, name, time.Unix(1700000000, 0), bytes.NewReader(data))
+			},
+		),
+	)
+	defer store.Close()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize:   1024,
+			MaxStreamBuffer: 1024,
+			Backends: map[string]*config.BackendConfig{
+				"cold":  {Type: "http", URL: store.URL, ReadAhead: 8},
+				"small": {Type: "http", URL: store.URL, Spill: &config.SpillConfig{Dir: t.TempDir(), MaxFileSize: 1024}},
+			},
+		},
+	)
+
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	archive := func(name, backend string) {
+		p := filepath.Join(testDir, name)
+		assert.Nil(t, os.WriteFile(p, []byte(content), 0644))
+		checksum, err := fileChecksum(context.Background(), p)
+		assert.Nil(t, err)
+		m := &meta.File{Name: name, Checksum: checksum, Size: int64(len(content))}
+		assert.Nil(t, hdl.meta.Put(m))
+		assert.Nil(t, hdl.transition(context.Background(), backend, name, m))
+		assert.NoFileExists(t, p)
+	}
+	stream := func(name string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/stream/"+name, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	getCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return gets
+	}
+
+	t.Run(
+		"Ranges are fetched from the remote", func(t *testing.T) {
+			archive("movie.mp4", "cold")
+
+			rec := stream("movie.mp4", nil)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, content, rec.Body.String())
+			etag := rec.Header().Get("ETag")
+			assert.NotEmpty(t, etag)
+
+			before := getCount()
+			rec = stream("movie.mp4", map[string]string{"Range": "bytes=20-29", "If-Range": etag})
+			assert.Equal(t, http.StatusPartialContent, rec.Code)
+			assert.Equal(t, content[20:30], rec.Body.String())
+			assert.Equal(t, "bytes 20-29/36", rec.Header().Get("Content-Range"))
+			// One to sniff the type from the start, one from the range on.
+			assert.Equal(t, 2, getCount()-before)
+
+			rec = stream("movie.mp4", map[string]string{"Range": "bytes=20-29", "If-Range": `"stale"`})
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, content, rec.Body.String())
+		},
+	)
+
+	t.Run(
+		"Small objects are spilled", func(t *testing.T) {
+			// Checking the copy after the transition already spilled it.
+			archive("clip.mp4", "small")
+
+			before := getCount()
+			assert.Equal(t, content, stream("clip.mp4", nil).Body.String())
+			for range 3 {
+				assert.Equal(t, content[5:10], stream("clip.mp4", map[string]string{"Range": "bytes=5-9"}).Body.String())
+			}
+			assert.Equal(t, 0, getCount()-before)
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			assert.Contains(t, rec.Body.String(), "media_server_remote_gets_saved_total 4")
+			assert.Contains(t, rec.Body.String(), "media_server_remote_spill_bytes 36")
+		},
+	)
+}
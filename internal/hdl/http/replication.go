@@ -0,0 +1,69 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"github.com/JMURv/media-server/internal/replication"
+	"github.com/JMURv/media-server/internal/storage"
+	"github.com/JMURv/media-server/internal/webhook"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io"
+	"log"
+	"net/http"
+	"os"
+)
+
+func (h *Handler) replicate(typ, name string) {
+	if h.replicator == nil {
+		return
+	}
+
+	switch typ {
+	case webhook.FileCreated, webhook.FileUpdated:
+		h.replicator.Enqueue(replication.OpPut, name)
+	case webhook.FileDeleted:
+		h.replicator.Enqueue(replication.OpDelete, name)
+	}
+}
+
+func (h *Handler) openReplica(ctx context.Context, name string) (io.ReadCloser, error) {
+	file, err := h.openFile(ctx, name)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, os.ErrNotExist
+	}
+	return file, err
+}
+
+func (h *Handler) reconcileReplica(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+	if h.replicator == nil {
+		writeError(w, ErrReplicationDisabled)
+		return
+	}
+
+	manifest, err := h.snapshot(r.Context())
+	if err != nil {
+		log.Printf("Error creating reconcile snapshot: %s\n", err)
+		writeError(w, canceledOr(err, ErrInternal))
+		return
+	}
+
+	local := make(map[string]string, len(manifest.Files))
+	for _, f := range manifest.Files {
+		local[f.Name] = f.Checksum
+	}
+
+	repair := r.URL.Query().Get("repair") == "true"
+	diff, err := h.replicator.Reconcile(r.Context(), local, repair)
+	if err != nil {
+		log.Printf("Error reconciling with peer: %s\n", err)
+		writeError(w, ErrPeerUnavailable)
+		return
+	}
+
+	h.emit("admin.replication.reconcile", "", r, map[string]any{"repair": repair})
+	utils.JSONResponse(w, http.StatusOK, diff)
+}
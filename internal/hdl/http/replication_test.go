@@ -0,0 +1,182 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/metrics"
+	"github.com/JMURv/media-server/internal/replication"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReplication(t *testing.T) {
+	testDir := t.TempDir()
+
+	secondary := New(port, t.TempDir(), &config.HTTPConfig{MaxUploadSize: 10 * 1024 * 1024, AdminToken: "peer-secret"})
+	routes := secondary.routes()
+
+	var down atomic.Bool
+	peer := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if down.Load() {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				routes.ServeHTTP(w, r)
+			},
+		),
+	)
+	defer peer.Close()
+
+	replCfg := &config.ReplicationConfig{PeerURL: peer.URL, Token: "peer-secret", MaxBackoff: 10 * time.Millisecond}
+	primary := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize: 10 * 1024 * 1024,
+			AdminToken:    "admin-secret",
+			Replication:   replCfg,
+		},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go primary.replicator.Run(ctx)
+
+	onPeer := func(name string) func() bool {
+		return func() bool {
+			_, err := os.Stat(filepath.Join(secondary.savePath, name))
+			return err == nil
+		}
+	}
+
+	t.Run(
+		"Upload and delete are replayed", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			primary.ServeHTTP(rec, newUploadRequest("replicated.txt", "data", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Eventually(t, onPeer("replicated.txt"), time.Second, 5*time.Millisecond)
+
+			rec = httptest.NewRecorder()
+			primary.ServeHTTP(rec, newPatchRequest("replicated.txt", "+more", ""))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Eventually(
+				t, func() bool {
+					data, _ := os.ReadFile(filepath.Join(secondary.savePath, "replicated.txt"))
+					return string(data) == "data+more"
+				}, time.Second, 5*time.Millisecond,
+			)
+
+			rec = httptest.NewRecorder()
+			primary.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/delete?filename=replicated.txt", nil))
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+			assert.Eventually(
+				t, func() bool { return !onPeer("replicated.txt")() }, time.Second, 5*time.Millisecond,
+			)
+		},
+	)
+
+	t.Run(
+		"Peer outage is retried without failing requests", func(t *testing.T) {
+			down.Store(true)
+
+			rec := httptest.NewRecorder()
+			primary.ServeHTTP(rec, newUploadRequest("outage.txt", "data", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			assert.Eventually(
+				t, func() bool { return primary.replicator.Stats().Errors > 0 }, time.Second, 5*time.Millisecond,
+			)
+
+			rec = httptest.NewRecorder()
+			primary.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+			stats := &Stats{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(stats))
+			assert.Equal(t, 1, stats.Replication.Pending)
+			assert.NotEmpty(t, stats.Replication.LastError)
+
+			rec = httptest.NewRecorder()
+			primary.metrics.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			assert.Contains(t, rec.Body.String(), "media_server_replication_pending 1")
+
+			down.Store(false)
+			assert.Eventually(t, onPeer("outage.txt"), time.Second, 5*time.Millisecond)
+			assert.Eventually(
+				t, func() bool { return primary.replicator.Stats().Pending == 0 }, time.Second, 5*time.Millisecond,
+			)
+		},
+	)
+
+	t.Run(
+		"Queue survives restart", func(t *testing.T) {
+			dir := t.TempDir()
+			r, err := replication.New(replCfg, dir, primary.openReplica, nil, metrics.NewRegistry())
+			assert.Nil(t, err)
+			r.Enqueue(replication.OpPut, "a.txt")
+			r.Enqueue(replication.OpDelete, "b.txt")
+
+			r, err = replication.New(replCfg, dir, primary.openReplica, nil, metrics.NewRegistry())
+			assert.Nil(t, err)
+			assert.Equal(t, 2, r.Stats().Pending)
+
+			r.Enqueue(replication.OpPut, "c.txt")
+			entries, err := os.ReadDir(dir)
+			assert.Nil(t, err)
+			assert.Len(t, entries, 3)
+			assert.Equal(t, "00000000000000000003.json", entries[2].Name())
+		},
+	)
+
+	t.Run(
+		"Reconcile", func(t *testing.T) {
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, "local-only.txt"), []byte("local"), 0644))
+			assert.Nil(t, os.WriteFile(filepath.Join(secondary.savePath, "remote-only.txt"), []byte("remote"), 0644))
+			assert.Nil(t, os.WriteFile(filepath.Join(secondary.savePath, "outage.txt"), []byte("drift"), 0644))
+			assert.Nil(t, secondary.meta.Delete("outage.txt"))
+
+			reconcile := func(target, token string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, target, nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				rec := httptest.NewRecorder()
+				primary.ServeHTTP(rec, req)
+				return rec
+			}
+
+			// Repairing pushes data to the peer, so only an admin may reconcile.
+			for _, token := range []string{"", "wrong"} {
+				assert.Equal(t, http.StatusForbidden, reconcile("/admin/replication/reconcile?repair=true", token).Code)
+			}
+			assert.Never(t, onPeer("local-only.txt"), 50*time.Millisecond, 5*time.Millisecond)
+
+			rec := reconcile("/admin/replication/reconcile", "admin-secret")
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			diff := &replication.Diff{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(diff))
+			assert.Equal(t, []string{"local-only.txt"}, diff.Missing)
+			assert.Equal(t, []string{"outage.txt"}, diff.Different)
+			assert.Equal(t, []string{"remote-only.txt"}, diff.Extra)
+			assert.False(t, diff.Repaired)
+
+			rec = reconcile("/admin/replication/reconcile?repair=true", "admin-secret")
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			assert.Eventually(t, onPeer("local-only.txt"), time.Second, 5*time.Millisecond)
+			assert.Eventually(
+				t, func() bool { return !onPeer("remote-only.txt")() }, time.Second, 5*time.Millisecond,
+			)
+			assert.Eventually(
+				t, func() bool {
+					data, _ := os.ReadFile(filepath.Join(secondary.savePath, "outage.txt"))
+					return string(data) == "data"
+				}, time.Second, 5*time.Millisecond,
+			)
+		},
+	)
+}
@@ -0,0 +1,417 @@
+package http
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/internal/cron"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/usage"
+	"github.com/JMURv/media-server/internal/webhook"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	reportDir      = ".reports"
+	lastReportFile = "last.json"
+
+	defaultReportSchedule   = "@daily"
+	defaultReportRetryDelay = time.Minute
+	defaultReportPeriod     = 24 * time.Hour
+)
+
+// Report is a digest of storage health from From to To. It is assembled
+// from the change journal, the usage index and snapshots, and what the
+// server keeps in memory, without walking the save path; sections whose
+// source is disabled are left out.
+type Report struct {
+	From        time.Time          `json:"from"`
+	To          time.Time          `json:"to"`
+	Changes     *ReportChanges     `json:"changes,omitempty"`
+	Usage       *ReportUsage       `json:"usage,omitempty"`
+	Space       []ReportSpace      `json:"space,omitempty"`
+	Trash       *TrashStats        `json:"trash,omitempty"`
+	Webhooks    ReportWebhooks     `json:"webhooks"`
+	Consistency *ReportConsistency `json:"consistency,omitempty"`
+}
+
+// ReportChanges counts the changes in the journal over the period. Partial
+// is set when the journal no longer reaches back to its start.
+type ReportChanges struct {
+	Created  int  `json:"created"`
+	Modified int  `json:"modified"`
+	Deleted  int  `json:"deleted"`
+	Partial  bool `json:"partial,omitempty"`
+}
+
+// ReportUsage is the current usage, and its change since the latest
+// snapshot taken at or before the start of the period, Baseline, when
+// there is one.
+type ReportUsage struct {
+	usage.Usage
+	Delta    *usage.Usage `json:"delta,omitempty"`
+	Baseline *time.Time   `json:"baseline,omitempty"`
+}
+
+type ReportSpace struct {
+	Root string `json:"root"`
+	Free int64  `json:"free"`
+}
+
+// ReportWebhooks counts the deliveries that ran out of retries during the
+// period, and the dead letters queued overall.
+type ReportWebhooks struct {
+	Failed      int `json:"failed"`
+	DeadLetters int `json:"dead_letters"`
+}
+
+// ReportConsistency sums up the latest consistency check, which may
+// predate the period.
+type ReportConsistency struct {
+	CheckedAt     time.Time `json:"checked_at"`
+	DryRun        bool      `json:"dry_run"`
+	Discrepancies int       `json:"discrepancies"`
+	Repaired      int       `json:"repaired"`
+}
+
+func (h *Handler) initReport() error {
+	cfg := h.config.Report
+	var err error
+	if h.reportSchedule, err = cron.Parse(cmp.Or(cfg.Schedule, defaultReportSchedule)); err != nil {
+		return err
+	}
+	if !cfg.Webhook && cfg.SMTP == nil {
+		return errors.New("reports need webhook or smtp to be delivered")
+	}
+	if s := cfg.SMTP; s != nil {
+		if _, _, err = net.SplitHostPort(s.Addr); err != nil {
+			return fmt.Errorf("invalid smtp addr %q: %w", s.Addr, err)
+		}
+		if s.From == "" || len(s.To) == 0 {
+			return errors.New("smtp needs from and to")
+		}
+	}
+	h.sendMail = smtp.SendMail
+	return nil
+}
+
+func (h *Handler) reportRetryDelay() time.Duration {
+	if h.config.Report.RetryDelay > 0 {
+		return h.config.Report.RetryDelay
+	}
+	return defaultReportRetryDelay
+}
+
+// runReports sends a report each time the schedule comes due.
+func (h *Handler) runReports(ctx context.Context) {
+	for {
+		now := time.Now().UTC()
+		next := h.reportSchedule.Next(now)
+		if next.IsZero() {
+			log.Println("Report schedule never comes due, no reports will be sent")
+			return
+		}
+
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if report, err := h.sendReport(ctx, next, false); err == nil {
+			log.Printf("Report for %s to %s sent\n", report.From.Format(time.RFC3339), report.To.Format(time.RFC3339))
+		}
+	}
+}
+
+// retryOnce runs fn, and once more after the retry delay when it fails.
+// Failures are logged.
+func (h *Handler) retryOnce(ctx context.Context, what string, fn func() error) error {
+	err := fn()
+	if err == nil {
+		return nil
+	}
+	log.Printf("Error %s, retrying in %s: %s\n", what, h.reportRetryDelay(), err)
+
+	timer := time.NewTimer(h.reportRetryDelay())
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return ctx.Err()
+	case <-timer.C:
+	}
+	if err = fn(); err != nil {
+		log.Printf("Error %s: %s\n", what, err)
+	}
+	return err
+}
+
+// sendReport builds the report of the time since the last one sent up to
+// to and delivers it. A dry run only builds it. When the mail cannot be
+// sent the report is not recorded as sent, so the next one covers its
+// period again.
+func (h *Handler) sendReport(ctx context.Context, to time.Time, dryRun bool) (*Report, error) {
+	h.reportMu.Lock()
+	defer h.reportMu.Unlock()
+
+	from := to.Add(-defaultReportPeriod)
+	if last, err := h.lastReport(); err == nil && last.To.Before(to) {
+		from = last.To
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Error reading last report: %s\n", err)
+	}
+
+	var report *Report
+	err := h.retryOnce(
+		ctx, "building report", func() (err error) {
+			report, err = h.buildReport(from, to)
+			return err
+		},
+	)
+	if err != nil || dryRun {
+		return report, err
+	}
+
+	// The webhook goes out last: a report that fails to mail is sent again
+	// as part of the next one, and must not be announced twice.
+	cfg := h.config.Report
+	if cfg.SMTP != nil {
+		if err = h.retryOnce(ctx, "mailing report", func() error { return h.mailReport(report) }); err != nil {
+			return report, err
+		}
+	}
+	if cfg.Webhook {
+		h.webhooks.Notify(webhook.ReportGenerated, "", report)
+	}
+
+	if err = h.saveLastReport(report); err != nil {
+		log.Printf("Error saving last report: %s\n", err)
+	}
+	return report, nil
+}
+
+func (h *Handler) lastReport() (*Report, error) {
+	data, err := os.ReadFile(filepath.Join(h.savePath, reportDir, lastReportFile))
+	if err != nil {
+		return nil, err
+	}
+	res := &Report{}
+	if err = json.Unmarshal(data, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (h *Handler) saveLastReport(r *Report) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(h.savePath, reportDir)
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, lastReportFile)
+	if err = os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (h *Handler) buildReport(from, to time.Time) (*Report, error) {
+	res := &Report{From: from.UTC(), To: to.UTC()}
+
+	if h.journal != nil {
+		c := &ReportChanges{}
+		seq, err := h.journal.Cursor(from)
+		if errors.Is(err, meta.ErrCursorExpired) {
+			seq, c.Partial = h.journal.Floor(), true
+		} else if err != nil {
+			return nil, err
+		}
+		changes, _, err := h.journal.Since(seq, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, ch := range changes {
+			if ch.Time.After(to) {
+				break
+			}
+			switch ch.Type {
+			case meta.ChangeCreated:
+				c.Created++
+			case meta.ChangeModified:
+				c.Modified++
+			case meta.ChangeDeleted:
+				c.Deleted++
+			}
+		}
+		res.Changes = c
+	}
+
+	if h.usage != nil {
+		h.usageOnce.Do(h.rebuildUsage)
+		u := &ReportUsage{Usage: totalUsage(h.usage.Dirs())}
+		snap, err := h.usageSnapshots.At(from)
+		if err != nil && !errors.Is(err, usage.ErrNoSnapshot) {
+			return nil, err
+		}
+		if err == nil {
+			base := totalUsage(snap.Dirs)
+			u.Delta = &usage.Usage{Files: u.Files - base.Files, Bytes: u.Bytes - base.Bytes}
+			u.Baseline = &snap.Time
+		}
+		res.Usage = u
+	}
+
+	for _, root := range h.rootDirs() {
+		if free, err := freeSpace(root); err == nil {
+			res.Space = append(res.Space, ReportSpace{Root: root, Free: free})
+		}
+	}
+	if h.trash != nil {
+		res.Trash = h.trashStats()
+	}
+
+	dead := h.webhooks.DeadLetters()
+	res.Webhooks.DeadLetters = len(dead)
+	for _, d := range dead {
+		if d.FailedAt.After(from) && !d.FailedAt.After(to) {
+			res.Webhooks.Failed++
+		}
+	}
+
+	h.consistencyMu.Lock()
+	if c := h.consistencyReport; c != nil {
+		res.Consistency = &ReportConsistency{CheckedAt: c.CheckedAt, DryRun: c.DryRun, Discrepancies: len(c.Discrepancies)}
+		for _, d := range c.Discrepancies {
+			if d.Repaired {
+				res.Consistency.Repaired++
+			}
+		}
+	}
+	h.consistencyMu.Unlock()
+	return res, nil
+}
+
+func totalUsage(dirs map[string]usage.Usage) usage.Usage {
+	var res usage.Usage
+	for _, u := range dirs {
+		res.Files += u.Files
+		res.Bytes += u.Bytes
+	}
+	return res
+}
+
+func (h *Handler) mailReport(r *Report) error {
+	cfg := h.config.Report.SMTP
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		host, _, _ := net.SplitHostPort(cfg.Addr)
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+	return h.sendMail(cfg.Addr, auth, cfg.From, cfg.To, reportMail(cfg.From, cfg.To, r))
+}
+
+// reportMail renders r as a plain text message.
+func reportMail(from string, to []string, r *Report) []byte {
+	const layout = "2006-01-02 15:04 MST"
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "From: %s\r\n", from)
+	fmt.Fprintf(b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(b, "Subject: Storage report %s to %s\r\n", r.From.Format(layout), r.To.Format(layout))
+	fmt.Fprintf(b, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	line := func(format string, args ...any) {
+		fmt.Fprintf(b, format+"\r\n", args...)
+	}
+	line("Storage report for %s to %s.", r.From.Format(layout), r.To.Format(layout))
+	line("")
+	if c := r.Changes; c != nil {
+		line("Files: %d created, %d modified, %d deleted", c.Created, c.Modified, c.Deleted)
+		if c.Partial {
+			line("  (the change journal does not reach back to the start of the period)")
+		}
+	}
+	if u := r.Usage; u != nil {
+		line("Stored: %d files, %s", u.Files, formatBytes(u.Bytes))
+		if u.Delta != nil {
+			delta := formatBytes(u.Delta.Bytes)
+			if u.Delta.Bytes >= 0 {
+				delta = "+" + delta
+			}
+			line("  %+d files, %s since %s", u.Delta.Files, delta, u.Baseline.Format(layout))
+		}
+	}
+	for _, s := range r.Space {
+		line("Free space on %s: %s", s.Root, formatBytes(s.Free))
+	}
+	if t := r.Trash; t != nil {
+		if t.MaxSize > 0 {
+			line("Trash: %d entries, %s of %s (%.0f%%)", t.Entries, formatBytes(t.Bytes), formatBytes(t.MaxSize), 100*float64(t.Bytes)/float64(t.MaxSize))
+		} else {
+			line("Trash: %d entries, %s", t.Entries, formatBytes(t.Bytes))
+		}
+	}
+	line("Failed webhook deliveries: %d (%d dead letters queued)", r.Webhooks.Failed, r.Webhooks.DeadLetters)
+	if c := r.Consistency; c != nil {
+		line("Consistency check at %s: %d discrepancies, %d repaired", c.CheckedAt.Format(layout), c.Discrepancies, c.Repaired)
+	}
+	return []byte(b.String())
+}
+
+// formatBytes renders n in binary units.
+func formatBytes(n int64) string {
+	const unit = 1024
+	abs := n
+	if abs < 0 {
+		abs = -abs
+	}
+	if abs < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for v := abs / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// reportRun sends a report of the time since the last one now, and answers
+// with it. With ?dry_run=true it is only built.
+func (h *Handler) reportRun(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+	if h.config.Report == nil {
+		writeError(w, ErrReportDisabled)
+		return
+	}
+
+	report, err := h.sendReport(r.Context(), time.Now().UTC(), dryRun(r))
+	if report == nil || r.Context().Err() != nil {
+		writeError(w, canceledOr(err, ErrInternal))
+		return
+	}
+	if err != nil {
+		writeError(w, ErrReportFailed)
+		return
+	}
+	utils.JSONResponse(w, http.StatusOK, report)
+}
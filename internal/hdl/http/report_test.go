@@ -0,0 +1,226 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/JMURv/media-server/internal/webhook"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	testDir := t.TempDir()
+
+	var mu sync.Mutex
+	var events []webhook.Event
+	hook := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				e := webhook.Event{}
+				assert.Nil(t, json.Unmarshal(body, &e))
+				mu.Lock()
+				events = append(events, e)
+				mu.Unlock()
+			},
+		),
+	)
+	defer hook.Close()
+
+	newConfig := func(report *config.ReportConfig) *config.HTTPConfig {
+		return &config.HTTPConfig{
+			MaxUploadSize:   1 << 20,
+			MaxStreamBuffer: 1024,
+			AdminToken:      "admin-secret",
+			Webhooks:        []*config.WebhookConfig{{URL: hook.URL, Events: []string{webhook.ReportGenerated}}},
+			Changes:         &config.ChangesConfig{},
+			Usage:           &config.UsageConfig{},
+			Report:          report,
+		}
+	}
+	hdl := New(
+		port, testDir, newConfig(
+			&config.ReportConfig{
+				Schedule:   "0 8 * * *",
+				Webhook:    true,
+				RetryDelay: time.Millisecond,
+				SMTP: &config.SMTPConfig{
+					Addr: "smtp.example.com:587", Username: "user", Password: "pass",
+					From: "reports@example.com", To: []string{"ops@example.com"},
+				},
+			},
+		),
+	)
+
+	var mails []string
+	var mailErrs []error
+	hdl.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		assert.Equal(t, "smtp.example.com:587", addr)
+		assert.NotNil(t, a)
+		assert.Equal(t, "reports@example.com", from)
+		assert.Equal(t, []string{"ops@example.com"}, to)
+		if len(mailErrs) > 0 {
+			err := mailErrs[0]
+			mailErrs = mailErrs[1:]
+			return err
+		}
+		mails = append(mails, string(msg))
+		return nil
+	}
+
+	run := func(target, token string) (*httptest.ResponseRecorder, *Report) {
+		req := httptest.NewRequest(http.MethodPost, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		hdl.webhooks.Wait()
+
+		res := &Report{}
+		if rec.Code == http.StatusOK {
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+		}
+		return rec, res
+	}
+	for _, name := range []string{"a.mp4", "b.mp4"} {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, newUploadRequest(name, "content of "+name, nil))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, "/files/b.mp4", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	hdl.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	t.Run(
+		"Admin only", func(t *testing.T) {
+			rec, _ := run("/admin/report/run", "")
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Dry run is not delivered", func(t *testing.T) {
+			rec, res := run("/admin/report/run?dry_run=true", "admin-secret")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			if assert.NotNil(t, res.Changes) {
+				assert.Equal(t, 2, res.Changes.Created)
+				assert.Equal(t, 1, res.Changes.Deleted)
+			}
+			assert.Empty(t, mails)
+			assert.Empty(t, events)
+		},
+	)
+
+	var first *Report
+	t.Run(
+		"Delivered by webhook and mail", func(t *testing.T) {
+			rec, res := run("/admin/report/run", "admin-secret")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			first = res
+
+			if assert.NotNil(t, res.Changes) {
+				assert.Equal(t, ReportChanges{Created: 2, Deleted: 1, Partial: true}, *res.Changes)
+			}
+			if assert.NotNil(t, res.Usage) {
+				assert.Equal(t, 1, res.Usage.Files)
+				assert.Equal(t, int64(len("content of a.mp4")), res.Usage.Bytes)
+				assert.Nil(t, res.Usage.Delta)
+			}
+			assert.Equal(t, 24*time.Hour, res.To.Sub(res.From))
+
+			if assert.Len(t, events, 1) {
+				assert.Equal(t, webhook.ReportGenerated, events[0].Type)
+			}
+			if assert.Len(t, mails, 1) {
+				assert.Contains(t, mails[0], "Subject: Storage report ")
+				assert.Contains(t, mails[0], "Files: 2 created, 0 modified, 1 deleted")
+				assert.Contains(t, mails[0], "Stored: 1 files, 16 B")
+				assert.Contains(t, mails[0], "Failed webhook deliveries: 0")
+			}
+		},
+	)
+
+	t.Run(
+		"Reports follow on from the last one", func(t *testing.T) {
+			rec, res := run("/admin/report/run", "admin-secret")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.True(t, first.To.Equal(res.From))
+			if assert.NotNil(t, res.Changes) {
+				assert.Equal(t, ReportChanges{}, *res.Changes)
+			}
+		},
+	)
+
+	t.Run(
+		"Failed mail is retried once", func(t *testing.T) {
+			mails = nil
+			mailErrs = []error{errors.New("connection refused")}
+			rec, _ := run("/admin/report/run", "admin-secret")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Len(t, mails, 1)
+
+			last, err := hdl.lastReport()
+			assert.Nil(t, err)
+			mu.Lock()
+			sent := len(events)
+			mu.Unlock()
+			mailErrs = []error{errors.New("connection refused"), errors.New("connection refused")}
+			rec, _ = run("/admin/report/run", "admin-secret")
+			assert.Equal(t, http.StatusBadGateway, rec.Code)
+			assert.Contains(t, rec.Body.String(), "report_failed")
+			assert.Len(t, mails, 1)
+			mu.Lock()
+			assert.Len(t, events, sent)
+			mu.Unlock()
+
+			// The failed report is sent again as part of the next one, and
+			// only then announced.
+			rec, res := run("/admin/report/run", "admin-secret")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.True(t, last.To.Equal(res.From))
+			mu.Lock()
+			assert.Len(t, events, sent+1)
+			mu.Unlock()
+		},
+	)
+
+	t.Run(
+		"Disabled and invalid configs", func(t *testing.T) {
+			hdl := New(port, testDir, newConfig(nil))
+			req := httptest.NewRequest(http.MethodPost, "/admin/report/run", nil)
+			req.Header.Set("Authorization", "Bearer admin-secret")
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+
+			for _, cfg := range []*config.ReportConfig{
+				{Webhook: true, Schedule: "every day"},
+				{},
+				{SMTP: &config.SMTPConfig{Addr: "smtp.example.com", From: "a@example.com", To: []string{"b@example.com"}}},
+				{SMTP: &config.SMTPConfig{Addr: "smtp.example.com:25", From: "a@example.com"}},
+			} {
+				assert.Panics(t, func() { New(port, testDir, newConfig(cfg)) })
+			}
+		},
+	)
+
+	t.Run(
+		"Byte sizes", func(t *testing.T) {
+			for n, s := range map[int64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KiB", -3 << 20: "-3.0 MiB", 5 << 30: "5.0 GiB"} {
+				assert.Equal(t, s, formatBytes(n))
+			}
+			assert.True(t, strings.HasSuffix(formatBytes(1<<62), "EiB"))
+		},
+	)
+}
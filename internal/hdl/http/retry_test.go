@@ -0,0 +1,188 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// faultyFS fails the steps of putting an upload in place that it has an
+// error for.
+type faultyFS struct {
+	osUploadFS
+	create, write, link, rename, syncDir error
+}
+
+type faultyFile struct {
+	uploadFile
+	err error
+}
+
+func (f *faultyFile) Write(p []byte) (int, error) {
+	n, _ := f.uploadFile.Write(p[:len(p)/2])
+	return n, f.err
+}
+
+func (fsys *faultyFS) CreateTemp(dir, pattern string) (uploadFile, error) {
+	if fsys.create != nil {
+		return nil, fsys.create
+	}
+	f, err := fsys.osUploadFS.CreateTemp(dir, pattern)
+	if err != nil || fsys.write == nil {
+		return f, err
+	}
+	return &faultyFile{uploadFile: f, err: fsys.write}, nil
+}
+
+func (fsys *faultyFS) Link(oldname, newname string) error {
+	if fsys.link != nil {
+		return fsys.link
+	}
+	return fsys.osUploadFS.Link(oldname, newname)
+}
+
+func (fsys *faultyFS) Rename(oldpath, newpath string) error {
+	if fsys.rename != nil {
+		return fsys.rename
+	}
+	return fsys.osUploadFS.Rename(oldpath, newpath)
+}
+
+func (fsys *faultyFS) SyncDir(path string) error {
+	if fsys.syncDir != nil {
+		return fsys.syncDir
+	}
+	return fsys.osUploadFS.SyncDir(path)
+}
+
+func TestUploadFailures(t *testing.T) {
+	testDir := t.TempDir()
+	hdl := setupTestHandler(testDir)
+
+	upload := func(name, content string, fields map[string]string, ctx context.Context) (*httptest.ResponseRecorder, utils.ErrorResponse) {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, newUploadRequest(name, content, fields).WithContext(ctx))
+		res := utils.ErrorResponse{}
+		if rec.Code >= http.StatusBadRequest {
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		}
+		return rec, res
+	}
+	// files lists the files in the temp dir and at the top of the save path.
+	files := func() []string {
+		var res []string
+		for _, dir := range []string{hdl.tempDir, testDir} {
+			entries, err := os.ReadDir(dir)
+			assert.Nil(t, err)
+			for _, e := range entries {
+				if !e.IsDir() {
+					res = append(res, e.Name())
+				}
+			}
+		}
+		return res
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, tc := range []struct {
+		name      string
+		fsys      *faultyFS
+		fields    map[string]string
+		ctx       context.Context
+		durable   bool
+		retryable bool
+	}{
+		{name: "Temp file not created", fsys: &faultyFS{create: syscall.ENOSPC}, retryable: true},
+		{name: "Disk full while copying", fsys: &faultyFS{write: syscall.ENOSPC}, retryable: true},
+		{name: "Link fails", fsys: &faultyFS{link: errors.New("input/output error")}, retryable: true},
+		{name: "No hard links and move fails", fsys: &faultyFS{link: errors.ErrUnsupported, rename: syscall.EIO}, retryable: true},
+		{name: "Directory sync fails", fsys: &faultyFS{syncDir: syscall.EIO}, durable: true, retryable: true},
+		{name: "No hard links and directory sync fails", fsys: &faultyFS{link: errors.ErrUnsupported, syncDir: syscall.EIO}, durable: true, retryable: true},
+		{name: "Validation fails", fsys: &faultyFS{}, fields: map[string]string{"content_type": "not a type"}},
+		{name: "Client went away", fsys: &faultyFS{}, ctx: canceled, retryable: true},
+	} {
+		t.Run(
+			tc.name, func(t *testing.T) {
+				name := strings.ReplaceAll(strings.ToLower(tc.name), " ", "-") + ".mp4"
+				ctx := tc.ctx
+				if ctx == nil {
+					ctx = context.Background()
+				}
+
+				before := files()
+				hdl.uploadFS = tc.fsys
+				hdl.config.DurableWrites = tc.durable
+				rec, res := upload(name, "some content", tc.fields, ctx)
+				hdl.uploadFS = osUploadFS{}
+				hdl.config.DurableWrites = false
+
+				assert.GreaterOrEqual(t, rec.Code, http.StatusBadRequest)
+				assert.Equal(t, tc.retryable, res.Retryable, res.Code)
+				assert.NoFileExists(t, filepath.Join(testDir, name))
+				assert.Equal(t, before, files())
+
+				rec, _ = upload(name, "retried", nil, context.Background())
+				assert.Equal(t, http.StatusCreated, rec.Code)
+				data, err := os.ReadFile(filepath.Join(testDir, name))
+				assert.Nil(t, err)
+				assert.Equal(t, "retried", string(data))
+				assert.Nil(t, os.Remove(filepath.Join(testDir, name)))
+				assert.Nil(t, hdl.meta.Delete(name))
+			},
+		)
+	}
+
+	t.Run(
+		"Failed directory sync keeps the source", func(t *testing.T) {
+			for _, fsys := range []*faultyFS{{syncDir: syscall.EIO}, {link: errors.ErrUnsupported, syncDir: syscall.EIO}} {
+				hdl.uploadFS = fsys
+				src := filepath.Join(testDir, "unsynced-incoming.txt")
+				dst := filepath.Join(testDir, "unsynced.txt")
+				assert.Nil(t, os.WriteFile(src, []byte("content"), 0644))
+
+				assert.ErrorIs(t, hdl.commitFile(src, dst, true), syscall.EIO)
+				assert.NoFileExists(t, dst)
+				data, err := os.ReadFile(src)
+				assert.Nil(t, err)
+				assert.Equal(t, "content", string(data))
+				assert.Nil(t, os.Remove(src))
+			}
+			hdl.uploadFS = osUploadFS{}
+		},
+	)
+
+	t.Run(
+		"Disk full is reported as such", func(t *testing.T) {
+			if !isDiskFull(syscall.ENOSPC) {
+				t.Skip("no ENOSPC on this platform")
+			}
+			hdl.uploadFS = &faultyFS{write: syscall.ENOSPC}
+			defer func() { hdl.uploadFS = osUploadFS{} }()
+
+			rec, res := upload("full.mp4", "content", nil, context.Background())
+			assert.Equal(t, http.StatusInsufficientStorage, rec.Code)
+			assert.Equal(t, "disk_full", res.Code)
+		},
+	)
+
+	t.Run(
+		"Conflicts are not retryable", func(t *testing.T) {
+			rec, _ := upload("taken.mp4", "first", nil, context.Background())
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			rec, res := upload("taken.mp4", "second", nil, context.Background())
+			assert.Equal(t, http.StatusConflict, rec.Code)
+			assert.False(t, res.Retryable)
+		},
+	)
+}
@@ -0,0 +1,340 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/pkg/config"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Placement policies of new files across storage roots.
+const (
+	PlacementFill     = "fill"
+	PlacementBalanced = "balanced"
+)
+
+// rootSet spreads stored files over the save path and the extra roots of
+// the roots config. Every root holds files at their names relative to it;
+// the one holding a file is recorded as its meta.File.Root, empty for the
+// save path, which also keeps the bookkeeping directories.
+type rootSet struct {
+	mu        sync.RWMutex
+	extra     []string
+	placement string
+	reserve   int64
+	free      func(path string) (int64, error)
+
+	// located caches the root each stored name was found under, until the
+	// name is removed.
+	located sync.Map
+}
+
+func newRootSet(savePath string, cfg *config.RootsConfig, create bool) (*rootSet, error) {
+	s := &rootSet{free: freeSpace}
+	return s, s.apply(savePath, cfg, create)
+}
+
+// apply sets the roots to those of cfg, which must keep every current one:
+// files stored under a root are not moved off it.
+func (s *rootSet) apply(savePath string, cfg *config.RootsConfig, create bool) error {
+	switch cfg.Placement {
+	case "", PlacementFill, PlacementBalanced:
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidPlacement, cfg.Placement)
+	}
+
+	extra := make([]string, 0, len(cfg.Paths))
+	for _, p := range cfg.Paths {
+		root, err := prepareSavePath(p, create)
+		if err != nil {
+			return fmt.Errorf("root %s: %w", p, err)
+		}
+		for _, other := range append(extra, savePath) {
+			if within(root, other) || within(other, root) {
+				return fmt.Errorf("%w: %s overlaps %s", ErrUnsafeSavePath, root, other)
+			}
+		}
+		extra = append(extra, root)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, root := range s.extra {
+		if !slices.Contains(extra, root) {
+			return fmt.Errorf("%w: %s", ErrRootRemoved, root)
+		}
+	}
+	s.extra, s.placement, s.reserve = extra, cfg.Placement, cfg.Reserve
+	return nil
+}
+
+func (s *rootSet) has(root string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Contains(s.extra, root)
+}
+
+// ReloadRoots applies a changed roots config while running. Added roots
+// take new files from then on; files already stored stay where they are, so
+// a config that drops a root is refused.
+func (h *Handler) ReloadRoots(cfg *config.RootsConfig) error {
+	if h.roots == nil || cfg == nil {
+		return ErrRootsDisabled
+	}
+	if err := h.roots.apply(h.savePath, cfg, h.config.CreateDirs); err != nil {
+		return err
+	}
+	h.invalidateFile("")
+	if h.watcher != nil {
+		if err := watchDirs(h.watcher, h.rootDirs()); err != nil {
+			log.Printf("Error watching storage roots: %s\n", err)
+		}
+	}
+	log.Printf("Storage roots: %v\n", h.rootDirs())
+	return nil
+}
+
+// rootDirs returns the roots files are stored under, the save path first.
+func (h *Handler) rootDirs() []string {
+	if h.roots == nil {
+		return []string{h.savePath}
+	}
+	h.roots.mu.RLock()
+	defer h.roots.mu.RUnlock()
+	return append([]string{h.savePath}, h.roots.extra...)
+}
+
+// rootFor returns the root name is stored under: the one its metadata
+// records, else the first holding it, else the save path.
+func (h *Handler) rootFor(name string) string {
+	if h.roots == nil {
+		return h.savePath
+	}
+	if root, ok := h.roots.located.Load(name); ok {
+		return root.(string)
+	}
+
+	if m, err := h.meta.Get(name); err == nil && m.Root != "" && h.roots.has(m.Root) {
+		h.roots.located.Store(name, m.Root)
+		return m.Root
+	}
+	for _, root := range h.rootDirs() {
+		if h.storedUnder(root, name) {
+			h.roots.located.Store(name, root)
+			return root
+		}
+	}
+	return h.savePath
+}
+
+// filePath returns the path of the stored file name.
+func (h *Handler) filePath(name string) string {
+	return filepath.Join(h.rootFor(name), filepath.FromSlash(name))
+}
+
+// locate records that name is stored under root.
+func (h *Handler) locate(name, root string) {
+	if h.roots != nil {
+		h.roots.located.Store(name, root)
+	}
+}
+
+// forgetRoot drops the cached root of name, or of every name when it is
+// empty.
+func (h *Handler) forgetRoot(name string) {
+	switch {
+	case h.roots == nil:
+	case name == "":
+		h.roots.located.Clear()
+	default:
+		h.roots.located.Delete(name)
+	}
+}
+
+// rootOf returns the root path is under, and path relative to it.
+func (h *Handler) rootOf(path string) (string, string, bool) {
+	for _, root := range h.rootDirs() {
+		if within(path, root) {
+			rel, err := filepath.Rel(root, path)
+			return root, rel, err == nil
+		}
+	}
+	return "", "", false
+}
+
+// storedRoot is the root to record in the metadata of a file stored under
+// root.
+func (h *Handler) storedRoot(root string) string {
+	if root == h.savePath {
+		return ""
+	}
+	return root
+}
+
+// placePath returns where to store name, size bytes long: where it is
+// stored already, else under the root the placement policy picks.
+func (h *Handler) placePath(name string, size int64) (string, string, error) {
+	if h.roots == nil {
+		return filepath.Join(h.savePath, filepath.FromSlash(name)), h.savePath, nil
+	}
+	if root := h.rootFor(name); root != h.savePath || h.storedUnder(root, name) {
+		return filepath.Join(root, filepath.FromSlash(name)), root, nil
+	}
+
+	// Only roots holding the directory name goes in take it, as uploads do
+	// not create directories.
+	var dirs []string
+	for _, root := range h.rootDirs() {
+		if info, err := os.Stat(filepath.Dir(filepath.Join(root, filepath.FromSlash(name)))); err == nil && info.IsDir() {
+			dirs = append(dirs, root)
+		}
+	}
+	if len(dirs) == 0 {
+		return filepath.Join(h.savePath, filepath.FromSlash(name)), h.savePath, nil
+	}
+	root, err := h.roots.pick(dirs, size)
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Join(root, filepath.FromSlash(name)), root, nil
+}
+
+func (h *Handler) storedUnder(root, name string) bool {
+	_, err := os.Lstat(filepath.Join(root, filepath.FromSlash(name)))
+	return err == nil
+}
+
+// pick returns the root of dirs to store size more bytes under. Roots whose
+// free space is unknown are taken to have room.
+func (s *rootSet) pick(dirs []string, size int64) (string, error) {
+	s.mu.RLock()
+	placement, reserve := s.placement, s.reserve
+	s.mu.RUnlock()
+
+	best, bestFree := "", int64(-1)
+	for _, root := range dirs {
+		free, err := s.free(root)
+		if err != nil {
+			if placement != PlacementBalanced && best == "" {
+				return root, nil
+			}
+			continue
+		}
+		if free-size < reserve {
+			continue
+		}
+		if placement != PlacementBalanced {
+			return root, nil
+		}
+		if free > bestFree {
+			best, bestFree = root, free
+		}
+	}
+	if best == "" {
+		return "", ErrRootsFull
+	}
+	return best, nil
+}
+
+// walkRoots is walkDir over every root in turn, sharing one entry cap. fn
+// is given the root path is under.
+func (h *Handler) walkRoots(ctx context.Context, recursive bool, fn func(root, path string, d fs.DirEntry) error) (bool, error) {
+	truncated, err := h.walkRootEntries(ctx, recursive, h.maxWalkEntries(), fn)
+	if truncated {
+		h.walkTruncations.Inc()
+		log.Printf("Walk of %v stopped after %d entries\n", h.rootDirs(), h.maxWalkEntries())
+	}
+	return truncated, err
+}
+
+// walkRootEntries is walkRoots with an explicit cap; a limit of 0 walks
+// everything.
+func (h *Handler) walkRootEntries(ctx context.Context, recursive bool, limit int, fn func(root, path string, d fs.DirEntry) error) (bool, error) {
+	seen := 0
+	for _, root := range h.rootDirs() {
+		truncated, err := walkEntries(
+			ctx, root, recursive, 0, func(p string, d fs.DirEntry) error {
+				if limit > 0 && seen >= limit {
+					return errWalkLimit
+				}
+				seen++
+				return fn(root, p, d)
+			},
+		)
+		if err != nil || truncated {
+			return truncated, err
+		}
+	}
+	return false, nil
+}
+
+// walkRootsDir is filepath.WalkDir over every root in turn. fn is given the
+// root path is under.
+func (h *Handler) walkRootsDir(fn func(root, path string, d fs.DirEntry, err error) error) error {
+	for _, root := range h.rootDirs() {
+		err := filepath.WalkDir(
+			root, func(p string, d fs.DirEntry, err error) error {
+				return fn(root, p, d, err)
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rootsDir is sharedDir over the root each file is stored under.
+type rootsDir struct{ h *Handler }
+
+func (d rootsDir) Open(name string) (http.File, error) {
+	rel := strings.TrimPrefix(path.Clean("/"+name), "/")
+	return sharedDir(d.h.rootFor(rel)).Open(name)
+}
+
+// renameAcross renames src to dst, copying it when they are on different
+// filesystems, as files on different roots may be.
+func renameAcross(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil || !isCrossDevice(err) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".move-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Chmod(info.Mode().Perm())
+
+	_, err = io.Copy(tmp, in)
+	if err = errors.Join(err, tmp.Sync(), tmp.Close()); err != nil {
+		return err
+	}
+	os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime())
+	if err = os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	in.Close()
+	return os.Remove(src)
+}
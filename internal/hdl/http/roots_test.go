@@ -0,0 +1,158 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRoots(t *testing.T) {
+	testDir := t.TempDir()
+
+	disk2, _ := resolvePath(t.TempDir())
+	disk3, _ := resolvePath(t.TempDir())
+	save, _ := resolvePath(testDir)
+
+	cfg := &config.HTTPConfig{
+		MaxUploadSize: 1024,
+		Trash:         &config.TrashConfig{},
+		Roots:         &config.RootsConfig{Paths: []string{disk2}, Reserve: 100},
+	}
+	hdl := New(port, testDir, cfg)
+	free := map[string]int64{save: 1 << 20, disk2: 1 << 20, disk3: 1 << 20}
+	hdl.roots.free = func(path string) (int64, error) {
+		if n, ok := free[path]; ok {
+			return n, nil
+		}
+		return 0, errors.New("unknown root")
+	}
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	upload := func(name, content string) *httptest.ResponseRecorder {
+		return serve(newUploadRequest(name, content, nil))
+	}
+
+	t.Run(
+		"Fill spills over", func(t *testing.T) {
+			assert.Equal(t, http.StatusCreated, upload("first.txt", "on the save path").Code)
+			assert.FileExists(t, filepath.Join(save, "first.txt"))
+
+			free[save] = 110
+			assert.Equal(t, http.StatusCreated, upload("second.txt", "past the reserve").Code)
+			assert.FileExists(t, filepath.Join(disk2, "second.txt"))
+			assert.NoFileExists(t, filepath.Join(save, "second.txt"))
+
+			m, err := hdl.meta.Get("second.txt")
+			assert.Nil(t, err)
+			assert.Equal(t, disk2, m.Root)
+		},
+	)
+
+	t.Run(
+		"Reads find the file on its root", func(t *testing.T) {
+			hdl.roots.located.Clear()
+			rec := serve(httptest.NewRequest(http.MethodGet, "/uploads/second.txt", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "past the reserve", rec.Body.String())
+
+			rec = serve(httptest.NewRequest(http.MethodGet, "/list?page=1&size=10", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Contains(t, rec.Body.String(), "first.txt")
+			assert.Contains(t, rec.Body.String(), "second.txt")
+		},
+	)
+
+	t.Run(
+		"Overwrites stay on their root", func(t *testing.T) {
+			free[save], free[disk2] = 1<<20, 1<<20
+			req := newUploadRequest("second.txt", "replaced", nil)
+			req.URL.RawQuery = "conflict=overwrite"
+			assert.Equal(t, http.StatusOK, serve(req).Code)
+			data, err := os.ReadFile(filepath.Join(disk2, "second.txt"))
+			assert.Nil(t, err)
+			assert.Equal(t, "replaced", string(data))
+			assert.NoFileExists(t, filepath.Join(save, "second.txt"))
+		},
+	)
+
+	t.Run(
+		"Stats add up the roots", func(t *testing.T) {
+			rec := serve(httptest.NewRequest(http.MethodGet, "/stats", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			res := Stats{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.Equal(t, 2, res.Files)
+			if assert.Len(t, res.Roots, 2) {
+				assert.Equal(t, save, res.Roots[0].Path)
+				assert.Equal(t, 1, res.Roots[0].Files)
+				assert.Equal(t, disk2, res.Roots[1].Path)
+				assert.Equal(t, 1, res.Roots[1].Files)
+				assert.Equal(t, int64(len("replaced")), res.Roots[1].Bytes)
+				assert.Equal(t, int64(1<<20), *res.Roots[1].Free)
+			}
+		},
+	)
+
+	t.Run(
+		"Full", func(t *testing.T) {
+			free[save], free[disk2] = 50, 50
+			rec := upload("third.txt", "nowhere to go")
+			assert.Equal(t, http.StatusInsufficientStorage, rec.Code)
+			assert.Contains(t, rec.Body.String(), "roots_full")
+		},
+	)
+
+	t.Run(
+		"Reload adds roots", func(t *testing.T) {
+			err := hdl.ReloadRoots(&config.RootsConfig{Paths: []string{disk2, disk3}, Placement: PlacementBalanced})
+			assert.Nil(t, err)
+			assert.Equal(t, []string{save, disk2, disk3}, hdl.rootDirs())
+
+			free[save], free[disk2], free[disk3] = 1<<20, 2<<20, 3<<20
+			assert.Equal(t, http.StatusCreated, upload("third.txt", "on the new disk").Code)
+			assert.FileExists(t, filepath.Join(disk3, "third.txt"))
+			assert.FileExists(t, filepath.Join(disk2, "second.txt"))
+
+			err = hdl.ReloadRoots(&config.RootsConfig{Paths: []string{disk3}})
+			assert.ErrorIs(t, err, ErrRootRemoved)
+			assert.Equal(t, []string{save, disk2, disk3}, hdl.rootDirs())
+
+			err = hdl.ReloadRoots(&config.RootsConfig{Paths: []string{disk2, disk3}, Placement: "random"})
+			assert.ErrorIs(t, err, ErrInvalidPlacement)
+		},
+	)
+
+	t.Run(
+		"Deletes move across roots", func(t *testing.T) {
+			rec := serve(httptest.NewRequest(http.MethodDelete, "/files/third.txt", nil))
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+			assert.NoFileExists(t, filepath.Join(disk3, "third.txt"))
+			if entries := hdl.trash.List(); assert.Len(t, entries, 1) {
+				assert.Equal(t, "third.txt", entries[0].Name)
+				assert.FileExists(t, filepath.Join(save, trashDir, entries[0].ID))
+			}
+
+			rec = serve(httptest.NewRequest(http.MethodGet, "/uploads/third.txt", nil))
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Overlapping roots", func(t *testing.T) {
+			_, err := newRootSet(save, &config.RootsConfig{Paths: []string{filepath.Join(save, "nested")}}, true)
+			assert.ErrorIs(t, err, ErrUnsafeSavePath)
+			_, err = newRootSet(save, &config.RootsConfig{Paths: []string{disk2, disk2}}, false)
+			assert.ErrorIs(t, err, ErrUnsafeSavePath)
+		},
+	)
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func within(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved, nil
+	}
+	return abs, nil
+}
+
+func prepareSavePath(path string, create bool) (string, error) {
+	if strings.TrimSpace(path) == "" {
+		return "", fmt.Errorf("%w: save path is empty", ErrUnsafeSavePath)
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		if !create {
+			return "", fmt.Errorf("%w: %s (enable createDirs to create it)", ErrSavePathMissing, path)
+		}
+		if err = os.MkdirAll(path, os.ModePerm); err != nil {
+			return "", fmt.Errorf("creating save path %s: %w", path, err)
+		}
+		info, err = os.Stat(path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("checking save path %s: %w", path, err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%w: %s", ErrSavePathNotDir, path)
+	}
+
+	abs, err := resolvePath(path)
+	if err != nil {
+		return "", fmt.Errorf("resolving save path %s: %w", path, err)
+	}
+
+	if abs == filepath.VolumeName(abs)+string(filepath.Separator) {
+		return "", fmt.Errorf("%w: %s is the filesystem root", ErrUnsafeSavePath, abs)
+	}
+	if tmp, err := resolvePath(os.TempDir()); err == nil && within(tmp, abs) {
+		return "", fmt.Errorf("%w: %s contains the temp directory %s", ErrUnsafeSavePath, abs, tmp)
+	}
+
+	probe, err := os.CreateTemp(abs, ".probe-*")
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrSavePathNotWritable, abs, err)
+	}
+	probe.Close()
+	if err = os.Remove(probe.Name()); err != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrSavePathNotWritable, abs, err)
+	}
+	return abs, nil
+}
@@ -0,0 +1,101 @@
+package http
+
+import (
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSavePath(t *testing.T) {
+	t.Run(
+		"Missing directory", func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "media")
+			_, err := prepareSavePath(dir, false)
+			assert.ErrorIs(t, err, ErrSavePathMissing)
+			assert.NoDirExists(t, dir)
+
+			assert.Panics(t, func() { New(port, dir, &config.HTTPConfig{}) })
+		},
+	)
+
+	t.Run(
+		"Created on demand", func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "nested", "media")
+			res, err := prepareSavePath(dir, true)
+			assert.Nil(t, err)
+			assert.DirExists(t, dir)
+			assert.True(t, filepath.IsAbs(res))
+
+			entries, err := os.ReadDir(dir)
+			assert.Nil(t, err)
+			assert.Empty(t, entries)
+		},
+	)
+
+	t.Run(
+		"Not a directory", func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "file")
+			assert.Nil(t, os.WriteFile(file, nil, 0644))
+
+			_, err := prepareSavePath(file, true)
+			assert.ErrorIs(t, err, ErrSavePathNotDir)
+		},
+	)
+
+	t.Run(
+		"Read-only directory", func(t *testing.T) {
+			if os.Geteuid() == 0 {
+				t.Skip("permission checks are bypassed for root")
+			}
+
+			dir := t.TempDir()
+			assert.Nil(t, os.Chmod(dir, 0555))
+			defer os.Chmod(dir, 0755)
+
+			_, err := prepareSavePath(dir, false)
+			assert.ErrorIs(t, err, ErrSavePathNotWritable)
+		},
+	)
+
+	t.Run(
+		"Unsafe locations", func(t *testing.T) {
+			_, err := prepareSavePath("/", false)
+			assert.ErrorIs(t, err, ErrUnsafeSavePath)
+
+			_, err = prepareSavePath(os.TempDir(), false)
+			assert.ErrorIs(t, err, ErrUnsafeSavePath)
+
+			_, err = prepareSavePath("", true)
+			assert.ErrorIs(t, err, ErrUnsafeSavePath)
+		},
+	)
+
+	t.Run(
+		"Relative path normalization", func(t *testing.T) {
+			testDir := t.TempDir()
+
+			wd, err := os.Getwd()
+			assert.Nil(t, err)
+			rel, err := filepath.Rel(wd, testDir)
+			assert.Nil(t, err)
+			base := filepath.Base(testDir)
+
+			hdl := New(port, rel+"/../"+base, &config.HTTPConfig{MaxUploadSize: 1024 * 1024})
+			abs, err := filepath.EvalSymlinks(testDir)
+			assert.Nil(t, err)
+			assert.Equal(t, abs, hdl.savePath)
+
+			assert.Nil(t, os.Chdir(os.TempDir()))
+			defer os.Chdir(wd)
+
+			assert.Nil(t, os.WriteFile(filepath.Join(abs, "moved.txt"), []byte("x"), 0644))
+			_, err = hdl.meta.Get("moved.txt")
+			assert.ErrorIs(t, err, meta.ErrNotFound)
+			_, err = hdl.openFile(hdl.ctx, "moved.txt")
+			assert.Nil(t, err)
+		},
+	)
+}
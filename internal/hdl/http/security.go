@@ -0,0 +1,65 @@
+package http
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var defaultForceDownloadTypes = []string{"text/html", "application/xhtml+xml", "image/svg+xml"}
+
+func baseMediaType(v string) string {
+	if t, _, err := mime.ParseMediaType(v); err == nil {
+		return t
+	}
+	return strings.ToLower(strings.TrimSpace(v))
+}
+
+func (h *Handler) detectTypes(name string, file io.ReadSeeker) []string {
+	var res []string
+	if t := typeByExtension(name); t != "" {
+		res = append(res, baseMediaType(t))
+	}
+
+	if m, err := h.meta.Get(name); err == nil && m.ContentType != "" {
+		res = append(res, baseMediaType(m.ContentType))
+	}
+
+	if t, err := sniffType(file); err == nil {
+		res = append(res, t)
+	}
+	return res
+}
+
+func matchesType(types, policy []string) bool {
+	return slices.ContainsFunc(
+		types, func(t string) bool {
+			return slices.ContainsFunc(policy, func(p string) bool { return strings.EqualFold(t, baseMediaType(p)) })
+		},
+	)
+}
+
+func (h *Handler) applyContentPolicy(w http.ResponseWriter, name string, file io.ReadSeeker) bool {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	types := h.detectTypes(name, file)
+	if matchesType(types, h.config.NeverServeTypes) {
+		writeError(w, ErrForbiddenType)
+		return false
+	}
+
+	force := h.config.ForceDownloadTypes
+	if force == nil {
+		force = defaultForceDownloadTypes
+	}
+	if matchesType(types, force) {
+		w.Header().Set(
+			"Content-Disposition",
+			mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(name)}),
+		)
+	}
+	return true
+}
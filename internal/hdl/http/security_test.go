@@ -0,0 +1,73 @@
+package http
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContentPolicy(t *testing.T) {
+	testDir := t.TempDir()
+	hdl := setupTestHandler(testDir)
+
+	html := "<html><script>alert(1)</script></html>"
+	assert.Nil(t, os.WriteFile(filepath.Join(testDir, "evil.html"), []byte(html), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(testDir, "polyglot.png"), []byte(html), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(testDir, "photo.png"), []byte("\x89PNG\r\n\x1a\nimage"), 0644))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	t.Run(
+		"Force download by extension", func(t *testing.T) {
+			rec := get("/uploads/evil.html")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "attachment; filename=evil.html", rec.Header().Get("Content-Disposition"))
+			assert.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+
+			rec = get("/stream/evil.html")
+			assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+			assert.NotContains(t, rec.Body.String(), "<script>")
+		},
+	)
+
+	t.Run(
+		"Force download by sniffing", func(t *testing.T) {
+			for _, path := range []string{"/uploads/polyglot.png", "/stream/polyglot.png"} {
+				rec := get(path)
+				assert.Equal(t, http.StatusOK, rec.Code)
+				assert.Equal(t, "attachment; filename=polyglot.png", rec.Header().Get("Content-Disposition"))
+				assert.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+			}
+		},
+	)
+
+	t.Run(
+		"Regular files", func(t *testing.T) {
+			rec := get("/stream/photo.png")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Empty(t, rec.Header().Get("Content-Disposition"))
+			assert.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+		},
+	)
+
+	t.Run(
+		"Never serve", func(t *testing.T) {
+			hdl.config.NeverServeTypes = []string{"text/html"}
+			defer func() { hdl.config.NeverServeTypes = nil }()
+
+			for _, path := range []string{"/uploads/evil.html", "/uploads/polyglot.png", "/stream/polyglot.png"} {
+				rec := get(path)
+				assert.Equal(t, http.StatusForbidden, rec.Code)
+				assert.NotContains(t, rec.Body.String(), "<script>")
+			}
+			assert.Equal(t, http.StatusOK, get("/stream/photo.png").Code)
+		},
+	)
+}
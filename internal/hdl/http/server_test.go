@@ -0,0 +1,183 @@
+package http_test
+
+import (
+	"bytes"
+	"github.com/JMURv/media-server/pkg/mediatest"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// do sends a request to srv and returns the response with its body read.
+func do(t *testing.T, srv *mediatest.Server, method, target string, body io.Reader, header map[string]string) (*http.Response, string) {
+	req, err := http.NewRequest(method, srv.URL()+target, body)
+	assert.Nil(t, err)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	res, err := srv.Client().Do(req)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	data, _ := io.ReadAll(res.Body)
+	return res, string(data)
+}
+
+func upload(t *testing.T, srv *mediatest.Server, name, content string) (*http.Response, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	file, _ := writer.CreateFormFile("file", name)
+	file.Write([]byte(content))
+	writer.Close()
+	return do(t, srv, http.MethodPost, "/upload", body, map[string]string{"Content-Type": writer.FormDataContentType()})
+}
+
+func TestServer(t *testing.T) {
+	srv := mediatest.New(t, nil)
+	srv.Seed("clip.mp4", "0123456789", time.Time{})
+	srv.Seed("old.mp4", "old", mediatest.Epoch.Add(-time.Hour))
+
+	t.Run(
+		"Ranges over the wire", func(t *testing.T) {
+			res, body := do(t, srv, http.MethodGet, "/stream/clip.mp4", nil, map[string]string{"Range": "bytes=2-5"})
+			assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+			assert.Equal(t, "2345", body)
+			assert.Equal(t, mediatest.Epoch.Format(http.TimeFormat), res.Header.Get("Last-Modified"))
+		},
+	)
+
+	t.Run(
+		"List and delete", func(t *testing.T) {
+			res, body := do(t, srv, http.MethodGet, "/list?page=1&size=10", nil, nil)
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Contains(t, body, "clip.mp4")
+			assert.Contains(t, body, "old.mp4")
+
+			res, _ = do(t, srv, http.MethodDelete, "/files/old.mp4", nil, nil)
+			assert.Equal(t, http.StatusNoContent, res.StatusCode)
+			assert.NoFileExists(t, srv.Path("old.mp4"))
+		},
+	)
+}
+
+func TestCreateFile(t *testing.T) {
+	srv := mediatest.New(t, nil)
+
+	t.Run(
+		"Success", func(t *testing.T) {
+			res, body := upload(t, srv, "testfile.txt", "This is a test file.")
+			assert.Equal(t, http.StatusCreated, res.StatusCode)
+			assert.Contains(t, body, filepath.ToSlash(srv.Root()))
+			assert.Contains(t, body, "testfile.txt")
+			assert.FileExists(t, srv.Path("testfile.txt"))
+		},
+	)
+
+	t.Run(
+		"Method not allowed", func(t *testing.T) {
+			res, _ := do(t, srv, http.MethodGet, "/upload", nil, nil)
+			assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+			assert.Equal(t, "POST", res.Header.Get("Allow"))
+		},
+	)
+
+	t.Run(
+		"Retrieving file error", func(t *testing.T) {
+			res, _ := do(t, srv, http.MethodPost, "/upload", nil, nil)
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		},
+	)
+
+	t.Run(
+		"File already exists", func(t *testing.T) {
+			srv.Seed("taken.txt", "", time.Time{})
+			res, _ := upload(t, srv, "taken.txt", "This is a test file.")
+			assert.Equal(t, http.StatusConflict, res.StatusCode)
+		},
+	)
+}
+
+func TestListFiles(t *testing.T) {
+	srv := mediatest.New(t, nil)
+	srv.Seed("list.txt", "", time.Time{})
+	srv.Seed("list1.txt", "", time.Time{})
+
+	res, body := do(t, srv, http.MethodGet, "/list", nil, nil)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, body, "list.txt")
+	assert.Contains(t, body, "list1.txt")
+}
+
+func TestDeleteFile(t *testing.T) {
+	srv := mediatest.New(t, nil)
+
+	t.Run(
+		"Success", func(t *testing.T) {
+			srv.Seed("delete.txt", "", time.Time{})
+			res, _ := do(t, srv, http.MethodDelete, "/delete?filename=delete.txt", nil, nil)
+			assert.Equal(t, http.StatusNoContent, res.StatusCode)
+			assert.NoFileExists(t, srv.Path("delete.txt"))
+		},
+	)
+
+	t.Run(
+		"Method not allowed", func(t *testing.T) {
+			res, _ := do(t, srv, http.MethodGet, "/delete?filename=delete.txt", nil, nil)
+			assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+			assert.Equal(t, "DELETE", res.Header.Get("Allow"))
+		},
+	)
+
+	t.Run(
+		"Path parameter", func(t *testing.T) {
+			srv.Seed("delete.txt", "", time.Time{})
+			res, _ := do(t, srv, http.MethodDelete, "/files/delete.txt", nil, nil)
+			assert.Equal(t, http.StatusNoContent, res.StatusCode)
+			assert.NoFileExists(t, srv.Path("delete.txt"))
+		},
+	)
+
+	t.Run(
+		"Filename not provided", func(t *testing.T) {
+			res, _ := do(t, srv, http.MethodDelete, "/delete", nil, nil)
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		},
+	)
+
+	t.Run(
+		"File not found", func(t *testing.T) {
+			res, _ := do(t, srv, http.MethodDelete, "/delete?filename=nonexistent.txt", nil, nil)
+			assert.Equal(t, http.StatusNotFound, res.StatusCode)
+		},
+	)
+}
+
+func TestStream(t *testing.T) {
+	srv := mediatest.New(t, nil)
+
+	t.Run(
+		"Success", func(t *testing.T) {
+			srv.Seed("testfile.mp4", "This is a test video file.", time.Time{})
+			res, body := do(t, srv, http.MethodGet, "/stream/uploads/testfile.mp4", nil, nil)
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Equal(t, "video/mp4", res.Header.Get("Content-Type"))
+			assert.Equal(t, "This is a test video file.", body)
+		},
+	)
+
+	t.Run(
+		"Path parameter", func(t *testing.T) {
+			srv.Seed("clip.webm", "webm", time.Time{})
+			res, _ := do(t, srv, http.MethodGet, "/stream/clip.webm", nil, nil)
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Equal(t, "video/webm", res.Header.Get("Content-Type"))
+
+			res, _ = do(t, srv, http.MethodPost, "/stream/clip.webm", nil, nil)
+			assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+			assert.Contains(t, res.Header.Get("Allow"), "GET")
+		},
+	)
+}
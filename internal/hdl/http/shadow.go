@@ -0,0 +1,295 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/internal/metrics"
+	"github.com/JMURv/media-server/internal/storage"
+	"github.com/JMURv/media-server/internal/webhook"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultShadowQueueSize      = 1024
+	defaultShadowMaxDivergences = 100
+)
+
+const (
+	shadowPut     = "put"
+	shadowRemove  = "remove"
+	shadowCompare = "compare"
+)
+
+// Divergence reasons.
+const (
+	DivergenceMissing  = "missing"
+	DivergenceChecksum = "checksum"
+	DivergenceError    = "error"
+)
+
+// Divergence is a read whose content the shadow backend did not have.
+type Divergence struct {
+	Name    string    `json:"name"`
+	Reason  string    `json:"reason"`
+	Primary string    `json:"primary,omitempty"`
+	Shadow  string    `json:"shadow,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// ShadowReport is the state of the shadow mirror served by /admin/shadow.
+type ShadowReport struct {
+	Backend     string        `json:"backend"`
+	Queued      int           `json:"queued"`
+	Capacity    int           `json:"capacity"`
+	Mirrored    uint64        `json:"mirrored"`
+	Dropped     uint64        `json:"dropped"`
+	Failed      uint64        `json:"failed"`
+	Compared    uint64        `json:"compared"`
+	Divergent   uint64        `json:"divergent"`
+	Divergences []*Divergence `json:"divergences"`
+}
+
+type shadowOp struct {
+	kind string
+	name string
+}
+
+// shadowMirror replays writes to the primary store on a shadow backend in
+// the background, and compares sampled reads between the two. Nothing it
+// does reaches clients: a full queue drops operations and failures are only
+// counted and logged.
+type shadowMirror struct {
+	name    string
+	backend storage.Backend
+	cfg     *config.ShadowConfig
+	ops     chan shadowOp
+
+	mirrored  *metrics.Counter
+	dropped   *metrics.Counter
+	failed    *metrics.Counter
+	compared  *metrics.Counter
+	divergent *metrics.Counter
+
+	mu          sync.Mutex
+	divergences []*Divergence
+}
+
+func (h *Handler) newShadow(cfg *config.ShadowConfig) (*shadowMirror, error) {
+	backend, ok := h.backends[cfg.Backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q", cfg.Backend)
+	}
+	if cfg.CompareReads < 0 || cfg.CompareReads > 1 {
+		return nil, fmt.Errorf("compareReads %v is not between 0 and 1", cfg.CompareReads)
+	}
+
+	size := cfg.QueueSize
+	if size <= 0 {
+		size = defaultShadowQueueSize
+	}
+	s := &shadowMirror{
+		name:      cfg.Backend,
+		backend:   backend,
+		cfg:       cfg,
+		ops:       make(chan shadowOp, size),
+		mirrored:  h.metrics.Counter("shadow_mirrored_total", "Writes mirrored to the shadow backend."),
+		dropped:   h.metrics.Counter("shadow_dropped_total", "Shadow operations dropped because the queue was full."),
+		failed:    h.metrics.Counter("shadow_failed_total", "Shadow operations that failed."),
+		compared:  h.metrics.Counter("shadow_compared_total", "Reads compared with the shadow backend."),
+		divergent: h.metrics.Counter("shadow_divergent_total", "Reads whose content the shadow backend did not match."),
+	}
+	h.metrics.GaugeFunc(
+		"shadow_queue_length", "Operations waiting for the shadow backend.",
+		func() float64 { return float64(len(s.ops)) },
+	)
+	return s, nil
+}
+
+func (s *shadowMirror) push(kind, name string) {
+	select {
+	case s.ops <- shadowOp{kind: kind, name: name}:
+	default:
+		s.dropped.Inc()
+	}
+}
+
+// mirror queues the write an event announces for the shadow backend.
+func (h *Handler) mirror(typ, name string) {
+	if h.shadow == nil {
+		return
+	}
+
+	switch typ {
+	case webhook.FileCreated, webhook.FileUpdated:
+		h.shadow.push(shadowPut, name)
+	case webhook.FileDeleted:
+		h.shadow.push(shadowRemove, name)
+	}
+}
+
+// compareShadow queues a comparison of name between the primary store and
+// the shadow backend for the configured share of reads.
+func (h *Handler) compareShadow(name string) {
+	if h.shadow == nil || h.shadow.cfg.CompareReads <= 0 || rand.Float64() >= h.shadow.cfg.CompareReads {
+		return
+	}
+	h.shadow.push(shadowCompare, name)
+}
+
+// runShadow applies queued operations one at a time, so that a comparison
+// never overtakes the writes queued before it.
+func (h *Handler) runShadow(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case op := <-h.shadow.ops:
+			h.applyShadow(ctx, op)
+		}
+	}
+}
+
+func (h *Handler) applyShadow(ctx context.Context, op shadowOp) {
+	s := h.shadow
+	var err error
+	switch op.kind {
+	case shadowPut:
+		err = h.shadowPut(ctx, op.name)
+	case shadowRemove:
+		if err = s.backend.Remove(ctx, op.name); errors.Is(err, storage.ErrNotFound) {
+			err = nil
+		}
+	case shadowCompare:
+		h.shadowCompare(ctx, op.name)
+		return
+	}
+
+	if err != nil {
+		s.failed.Inc()
+		log.Printf("Error mirroring %s of %s to shadow backend %s: %s\n", op.kind, op.name, s.name, err)
+		return
+	}
+	s.mirrored.Inc()
+}
+
+func (h *Handler) shadowPut(ctx context.Context, name string) error {
+	file, err := h.openFile(ctx, name)
+	if os.IsNotExist(err) || errors.Is(err, storage.ErrNotFound) {
+		// Deleted since; its removal is queued behind.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = h.shadow.backend.Put(ctx, name, file)
+	return err
+}
+
+func checksumOf(ctx context.Context, r io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, storage.Reader(ctx, r)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (h *Handler) shadowCompare(ctx context.Context, name string) {
+	s := h.shadow
+	file, err := h.openFile(ctx, name)
+	if err != nil {
+		return
+	}
+	primary, err := checksumOf(ctx, file)
+	file.Close()
+	if err != nil {
+		return
+	}
+	s.compared.Inc()
+
+	d := &Divergence{Name: name, Primary: primary, At: time.Now().UTC()}
+	shadow, err := s.backend.Open(ctx, name)
+	if errors.Is(err, storage.ErrNotFound) {
+		d.Reason = DivergenceMissing
+		s.diverge(d)
+		return
+	}
+	if err == nil {
+		d.Shadow, err = checksumOf(ctx, shadow)
+		shadow.Close()
+	}
+	if err != nil {
+		d.Reason, d.Error = DivergenceError, err.Error()
+		s.diverge(d)
+		return
+	}
+	if d.Shadow != primary {
+		d.Reason = DivergenceChecksum
+		s.diverge(d)
+	}
+}
+
+func (s *shadowMirror) diverge(d *Divergence) {
+	s.divergent.Inc()
+	log.Printf("Shadow backend %s diverges on %s: %s\n", s.name, d.Name, d.Reason)
+
+	limit := s.cfg.MaxDivergences
+	if limit <= 0 {
+		limit = defaultShadowMaxDivergences
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.divergences = append(s.divergences, d)
+	if len(s.divergences) > limit {
+		s.divergences = s.divergences[len(s.divergences)-limit:]
+	}
+}
+
+func (s *shadowMirror) report() *ShadowReport {
+	res := &ShadowReport{
+		Backend:   s.name,
+		Queued:    len(s.ops),
+		Capacity:  cap(s.ops),
+		Mirrored:  s.mirrored.Value(),
+		Dropped:   s.dropped.Value(),
+		Failed:    s.failed.Value(),
+		Compared:  s.compared.Value(),
+		Divergent: s.divergent.Value(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res.Divergences = make([]*Divergence, 0, len(s.divergences))
+	for i := len(s.divergences) - 1; i >= 0; i-- {
+		res.Divergences = append(res.Divergences, s.divergences[i])
+	}
+	return res
+}
+
+// shadowReport serves GET /admin/shadow: the mirror's counters and the most
+// recent divergences, newest first.
+func (h *Handler) shadowReport(w http.ResponseWriter, r *http.Request) {
+	if h.shadow == nil {
+		writeError(w, ErrShadowDisabled)
+		return
+	}
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+	utils.JSONResponse(w, http.StatusOK, h.shadow.report())
+}
@@ -0,0 +1,150 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestShadow(t *testing.T) {
+	testDir := t.TempDir()
+
+	shadowDir := t.TempDir()
+	newHandler := func(shadow *config.ShadowConfig) *Handler {
+		return New(
+			port, testDir, &config.HTTPConfig{
+				MaxUploadSize: 1024,
+				AdminToken:    "admin-secret",
+				Backends:      map[string]*config.BackendConfig{"next": {Type: "local", Path: shadowDir}},
+				Shadow:        shadow,
+			},
+		)
+	}
+	report := func(hdl *Handler) ShadowReport {
+		req := httptest.NewRequest(http.MethodGet, "/admin/shadow", nil)
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		res := ShadowReport{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res
+	}
+	upload := func(hdl *Handler, name, content string) {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, newUploadRequest(name, content, nil))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	}
+	stream := func(hdl *Handler, name string) {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream/"+name, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "video", rec.Body.String())
+	}
+	shadowed := func(name string) string {
+		data, _ := os.ReadFile(filepath.Join(shadowDir, name))
+		return string(data)
+	}
+
+	t.Run(
+		"Invalid config fails at startup", func(t *testing.T) {
+			assert.Panics(t, func() { newHandler(&config.ShadowConfig{Backend: "s3"}) })
+			assert.Panics(t, func() { newHandler(&config.ShadowConfig{Backend: "next", CompareReads: 2}) })
+		},
+	)
+
+	t.Run(
+		"A full queue drops operations", func(t *testing.T) {
+			hdl := newHandler(&config.ShadowConfig{Backend: "next", QueueSize: 2})
+			for _, name := range []string{"q1.mp4", "q2.mp4", "q3.mp4"} {
+				upload(hdl, name, "video")
+			}
+
+			res := report(hdl)
+			assert.Equal(t, "next", res.Backend)
+			assert.Equal(t, 2, res.Queued)
+			assert.Equal(t, 2, res.Capacity)
+			assert.Equal(t, uint64(1), res.Dropped)
+			assert.Empty(t, shadowed("q1.mp4"))
+		},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hdl := newHandler(&config.ShadowConfig{Backend: "next", CompareReads: 1})
+	go hdl.runShadow(ctx)
+
+	t.Run(
+		"Writes are mirrored", func(t *testing.T) {
+			upload(hdl, "clip.mp4", "video")
+			assert.Eventually(
+				t, func() bool { return shadowed("clip.mp4") == "video" }, 5*time.Second, 10*time.Millisecond,
+			)
+
+			upload(hdl, "gone.mp4", "video")
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/files/gone.mp4", nil))
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+			assert.Eventually(
+				t, func() bool { return report(hdl).Mirrored == 3 }, 5*time.Second, 10*time.Millisecond,
+			)
+			assert.NoFileExists(t, filepath.Join(shadowDir, "gone.mp4"))
+		},
+	)
+
+	t.Run(
+		"Reads are compared and divergences reported", func(t *testing.T) {
+			var res ShadowReport
+			compared := func(n uint64) {
+				assert.Eventually(
+					t, func() bool {
+						res = report(hdl)
+						return res.Compared == n
+					}, 5*time.Second, 10*time.Millisecond,
+				)
+			}
+
+			stream(hdl, "clip.mp4")
+			compared(1)
+			assert.Equal(t, uint64(0), res.Divergent)
+
+			assert.Nil(t, os.WriteFile(filepath.Join(shadowDir, "clip.mp4"), []byte("stale"), 0644))
+			stream(hdl, "clip.mp4")
+			compared(2)
+			assert.Nil(t, os.Remove(filepath.Join(shadowDir, "clip.mp4")))
+			stream(hdl, "clip.mp4")
+			compared(3)
+
+			assert.Equal(t, uint64(2), res.Divergent)
+			assert.Len(t, res.Divergences, 2)
+			assert.Equal(t, DivergenceMissing, res.Divergences[0].Reason)
+			assert.Equal(t, DivergenceChecksum, res.Divergences[1].Reason)
+			assert.Equal(t, "clip.mp4", res.Divergences[1].Name)
+			assert.NotEqual(t, res.Divergences[1].Primary, res.Divergences[1].Shadow)
+		},
+	)
+
+	t.Run(
+		"Report access", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/shadow", nil))
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+
+			rec = httptest.NewRecorder()
+			newHandler(nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/shadow", nil))
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+			res := utils.ErrorResponse{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.Equal(t, "shadow_disabled", res.Code)
+		},
+	)
+}
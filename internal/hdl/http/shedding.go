@@ -0,0 +1,211 @@
+package http
+
+import (
+	"context"
+	"github.com/JMURv/media-server/internal/metrics"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ClassGlobal = "global"
+	ClassRead   = "read"
+	ClassWrite  = "write"
+	ClassStream = "stream"
+)
+
+const (
+	defaultQueueTimeout = time.Second
+	defaultRetryAfter   = time.Second
+)
+
+// unshedRoutes are never limited, so probes and scrapes keep working while
+// the server sheds everything else.
+var unshedRoutes = []string{"GET /readyz", "GET /metrics"}
+
+type LimiterStats struct {
+	Limit    int    `json:"limit"`
+	MaxQueue int    `json:"max_queue"`
+	InFlight int64  `json:"in_flight"`
+	Queued   int64  `json:"queued"`
+	Shed     uint64 `json:"shed"`
+}
+
+// limiter admits up to cap(slots) requests at once. Up to maxQueue more may
+// wait for a slot; anything beyond that is shed immediately.
+type limiter struct {
+	slots    chan struct{}
+	maxQueue int64
+	queued   atomic.Int64
+	shed     *metrics.Counter
+}
+
+func newLimiter(class string, limit, maxQueue int, reg *metrics.Registry) *limiter {
+	if limit <= 0 {
+		return nil
+	}
+
+	l := &limiter{
+		slots:    make(chan struct{}, limit),
+		maxQueue: int64(max(maxQueue, 0)),
+		shed:     reg.Counter("shed_"+class+"_total", "Requests refused with 503 by the "+class+" limit."),
+	}
+	reg.GaugeFunc("shed_"+class+"_limit", "Concurrent requests allowed by the "+class+" limit.", func() float64 { return float64(limit) })
+	reg.GaugeFunc("shed_"+class+"_in_flight", "Requests holding a "+class+" slot.", func() float64 { return float64(len(l.slots)) })
+	reg.GaugeFunc("shed_"+class+"_queued", "Requests waiting for a "+class+" slot.", func() float64 { return float64(l.queued.Load()) })
+	return l
+}
+
+// acquire takes a slot, waiting until deadline when the queue has room. It
+// returns false when the request is shed or gave up waiting.
+func (l *limiter) acquire(ctx context.Context, deadline *time.Timer) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if l.queued.Add(1) > l.maxQueue {
+		l.queued.Add(-1)
+		l.shed.Inc()
+		return false
+	}
+	defer l.queued.Add(-1)
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-deadline.C:
+		l.shed.Inc()
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *limiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+func (l *limiter) stats() LimiterStats {
+	return LimiterStats{
+		Limit:    cap(l.slots),
+		MaxQueue: int(l.maxQueue),
+		InFlight: int64(len(l.slots)),
+		Queued:   l.queued.Load(),
+		Shed:     l.shed.Value(),
+	}
+}
+
+type shedder struct {
+	global       *limiter
+	classes      map[string]*limiter
+	queueTimeout time.Duration
+	retryAfter   int
+}
+
+func (h *Handler) initShedding() {
+	cfg := h.config.Shedding
+	s := &shedder{
+		global: newLimiter(ClassGlobal, cfg.MaxInFlight, cfg.MaxQueue, h.metrics),
+		classes: map[string]*limiter{
+			ClassRead:   newLimiter(ClassRead, cfg.Read, cfg.MaxQueue, h.metrics),
+			ClassWrite:  newLimiter(ClassWrite, cfg.Write, cfg.MaxQueue, h.metrics),
+			ClassStream: newLimiter(ClassStream, cfg.Stream, cfg.MaxQueue, h.metrics),
+		},
+		queueTimeout: cfg.QueueTimeout,
+	}
+	if s.queueTimeout <= 0 {
+		s.queueTimeout = defaultQueueTimeout
+	}
+
+	retryAfter := cfg.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = defaultRetryAfter
+	}
+	s.retryAfter = int(math.Ceil(retryAfter.Seconds()))
+	h.shedder = s
+}
+
+// routeClass buckets a mux pattern by the kind of load it puts on the
+// server. It returns "" for routes that are never shed.
+func routeClass(pattern string) string {
+	method, path, _ := strings.Cut(pattern, " ")
+	switch {
+	case slices.Contains(unshedRoutes, pattern):
+		return ""
+	case strings.HasPrefix(path, "/stream/"), strings.HasPrefix(path, "/uploads/"), strings.HasPrefix(path, "/downloads/"):
+		return ClassStream
+	case method == http.MethodGet || method == http.MethodHead:
+		return ClassRead
+	default:
+		return ClassWrite
+	}
+}
+
+// shed admits requests to next within the class and global limits. Requests
+// that find both the limit and its queue full, or wait longer than the queue
+// timeout, get a 503 with Retry-After.
+func (h *Handler) shed(pattern string, next http.Handler) http.Handler {
+	class := routeClass(pattern)
+	if h.shedder == nil || class == "" {
+		return next
+	}
+
+	s := h.shedder
+	l := s.classes[class]
+	if l == nil && s.global == nil {
+		return next
+	}
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			deadline := time.NewTimer(s.queueTimeout)
+			defer deadline.Stop()
+
+			if !l.acquire(r.Context(), deadline) {
+				s.refuse(w, r)
+				return
+			}
+			defer l.release()
+
+			if !s.global.acquire(r.Context(), deadline) {
+				s.refuse(w, r)
+				return
+			}
+			defer s.global.release()
+
+			next.ServeHTTP(w, r)
+		},
+	)
+}
+
+func (s *shedder) refuse(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Err() != nil {
+		return
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(s.retryAfter))
+	writeError(w, withParams(ErrOverloaded, map[string]any{"retry_after": s.retryAfter}))
+}
+
+func (s *shedder) stats() map[string]LimiterStats {
+	res := make(map[string]LimiterStats)
+	if s.global != nil {
+		res[ClassGlobal] = s.global.stats()
+	}
+	for class, l := range s.classes {
+		if l != nil {
+			res[class] = l.stats()
+		}
+	}
+	return res
+}
@@ -0,0 +1,167 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestShedding(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize: 1024,
+			Shedding: &config.SheddingConfig{
+				MaxInFlight:  2,
+				Read:         1,
+				MaxQueue:     1,
+				QueueTimeout: 50 * time.Millisecond,
+				RetryAfter:   1500 * time.Millisecond,
+			},
+		},
+	)
+
+	release := make(chan struct{})
+	blocking := http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			w.WriteHeader(http.StatusOK)
+		},
+	)
+	read := hdl.shed("GET /list", blocking)
+	write := hdl.shed("POST /upload", blocking)
+
+	start := func(h http.Handler) chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			done <- rec
+		}()
+		return done
+	}
+	waitFor := func(class string, inFlight, queued int64) {
+		assert.Eventually(
+			t, func() bool {
+				s := hdl.shedder.stats()[class]
+				return s.InFlight == inFlight && s.Queued == queued
+			}, time.Second, time.Millisecond,
+		)
+	}
+	assertShed := func(rec *httptest.ResponseRecorder) {
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+		assert.Contains(t, rec.Body.String(), ErrOverloaded.Error())
+	}
+
+	t.Run(
+		"Route classes", func(t *testing.T) {
+			for pattern, class := range map[string]string{
+				"GET /list":               ClassRead,
+				"HEAD /files/{name}":      ClassRead,
+				"GET /img/{name}":         ClassRead,
+				"POST /upload":            ClassWrite,
+				"DELETE /files/{name}":    ClassWrite,
+				"GET /stream/{name...}":   ClassStream,
+				"GET /uploads/":           ClassStream,
+				"GET /downloads/{id}":     ClassStream,
+				"POST /downloads":         ClassWrite,
+				"GET /readyz":             "",
+				"GET /metrics":            "",
+				"PUT /mpu/{id}/parts/{n}": ClassWrite,
+			} {
+				assert.Equal(t, class, routeClass(pattern), pattern)
+			}
+		},
+	)
+
+	t.Run(
+		"Excess requests queue, then are shed", func(t *testing.T) {
+			first := start(read)
+			waitFor(ClassRead, 1, 0)
+			second := start(read)
+			waitFor(ClassRead, 1, 1)
+
+			rec := httptest.NewRecorder()
+			read.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			assertShed(rec)
+
+			release <- struct{}{}
+			assert.Equal(t, http.StatusOK, (<-first).Code)
+			release <- struct{}{}
+			assert.Equal(t, http.StatusOK, (<-second).Code)
+			waitFor(ClassRead, 0, 0)
+		},
+	)
+
+	t.Run(
+		"Queued requests give up after the timeout", func(t *testing.T) {
+			first := start(read)
+			waitFor(ClassRead, 1, 0)
+			assertShed(<-start(read))
+
+			release <- struct{}{}
+			assert.Equal(t, http.StatusOK, (<-first).Code)
+		},
+	)
+
+	t.Run(
+		"Global limit spans classes", func(t *testing.T) {
+			first, second := start(read), start(write)
+			waitFor(ClassGlobal, 2, 0)
+			assertShed(<-start(write))
+
+			release <- struct{}{}
+			release <- struct{}{}
+			<-first
+			<-second
+		},
+	)
+
+	t.Run(
+		"Health and metrics are never shed", func(t *testing.T) {
+			first := start(read)
+			waitFor(ClassRead, 1, 0)
+			queued := start(read)
+			waitFor(ClassRead, 1, 1)
+
+			for _, path := range []string{"/readyz", "/metrics"} {
+				rec := httptest.NewRecorder()
+				hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				assert.Equal(t, http.StatusOK, rec.Code, path)
+			}
+
+			release <- struct{}{}
+			release <- struct{}{}
+			<-first
+			<-queued
+		},
+	)
+
+	t.Run(
+		"Exposed in stats and metrics", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			res := Stats{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.Equal(t, 1, res.Shedding[ClassRead].Limit)
+			assert.Equal(t, 1, res.Shedding[ClassRead].MaxQueue)
+			assert.Equal(t, uint64(2), res.Shedding[ClassRead].Shed)
+			assert.Equal(t, uint64(1), res.Shedding[ClassGlobal].Shed)
+			assert.NotContains(t, res.Shedding, ClassStream)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			assert.Contains(t, rec.Body.String(), "media_server_shed_read_total 2")
+			assert.Contains(t, rec.Body.String(), "media_server_shed_global_limit 2")
+			assert.Contains(t, rec.Body.String(), "media_server_shed_read_in_flight 0")
+		},
+	)
+}
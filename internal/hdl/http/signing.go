@@ -0,0 +1,44 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+)
+
+const signatureParam = "sig"
+
+func signature(secret, path string, q url.Values) string {
+	params := url.Values{}
+	for k, v := range q {
+		if k != signatureParam {
+			params[k] = v
+		}
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(path + "?" + params.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h *Handler) validSignature(r *http.Request) bool {
+	sig := r.URL.Query().Get(signatureParam)
+	if sig == "" || h.config.SigningSecret == "" {
+		return false
+	}
+	expected := signature(h.config.SigningSecret, r.URL.Path, r.URL.Query())
+	return hmac.Equal([]byte(sig), []byte(expected))
+}
+
+// SignURL returns path with q and a signature over both appended. path is
+// relative to the handler, without the base path, e.g. "/img/thumb/cat.jpg".
+func (h *Handler) SignURL(path string, q url.Values) string {
+	res := url.Values{}
+	for k, v := range q {
+		res[k] = v
+	}
+	res.Set(signatureParam, signature(h.config.SigningSecret, path, q))
+	return path + "?" + res.Encode()
+}
@@ -0,0 +1,124 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/internal/jobs"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/webhook"
+	"log"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+const TaskScan = "scan"
+
+// processingRetryAfter is the Retry-After hint, in seconds, sent for files
+// that are still processing.
+const processingRetryAfter = "5"
+
+var fileStates = []string{meta.StateProcessing, meta.StateReady, meta.StateQuarantined, meta.StateFailed}
+
+func (h *Handler) fileState(name string) string {
+	m, err := h.meta.Get(name)
+	if err != nil {
+		return meta.StateReady
+	}
+	return m.CurrentState()
+}
+
+// checkReady answers requests for the content of a file that is not ready:
+// 423 with a Retry-After hint while it is processing, 409 once processing
+// quarantined or failed it.
+func (h *Handler) checkReady(w http.ResponseWriter, name string) bool {
+	state := h.fileState(name)
+	params := map[string]any{"state": state}
+	switch state {
+	case meta.StateProcessing:
+		w.Header().Set("Retry-After", processingRetryAfter)
+		writeError(w, withParams(ErrFileProcessing, params))
+	case meta.StateQuarantined:
+		writeError(w, withParams(ErrQuarantined, params))
+	case meta.StateFailed:
+		writeError(w, withParams(ErrProcessingFailed, params))
+	default:
+		return true
+	}
+	return false
+}
+
+func stateFilter(r *http.Request) (string, error) {
+	state := r.URL.Query().Get("state")
+	if state != "" && !slices.Contains(fileStates, state) {
+		return "", withParams(ErrInvalidState, map[string]any{"expected": strings.Join(fileStates, ", ")})
+	}
+	return state, nil
+}
+
+// setState moves name from one state to another and announces it with a
+// file.state_changed event. It does nothing if the file has since left the
+// from state, so jobs run for files that are already ready, like
+// transcodes, leave them alone.
+func (h *Handler) setState(name, from, to string, data map[string]any) {
+	unlock := h.fileMu.Lock(name)
+	m, err := h.meta.Get(name)
+	if err != nil || m.CurrentState() != from {
+		unlock()
+		return
+	}
+	m.State = to
+	err = h.meta.Put(m)
+	unlock()
+	if err != nil {
+		log.Printf("Error saving state of %s: %s\n", name, err)
+		return
+	}
+
+	if data == nil {
+		data = make(map[string]any)
+	}
+	data["state"], data["previous"] = to, from
+	h.emit(webhook.FileStateChanged, name, nil, data)
+}
+
+func (h *Handler) settleState(job *jobs.Job) {
+	if job.State == jobs.StateDone {
+		h.setState(job.Name, meta.StateProcessing, meta.StateReady, nil)
+		return
+	}
+	h.setState(job.Name, meta.StateProcessing, meta.StateFailed, map[string]any{"error": job.Error})
+}
+
+// scanTask runs the configured scan command on the file. An exit status of
+// 1 quarantines it and fails the job so no later task touches it.
+func (h *Handler) scanTask(ctx context.Context, name string) error {
+	command := h.config.Jobs.ScanCommand
+	path, cleanup, err := h.plainPath(ctx, h.filePath(name))
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	args := append(slices.Clip(command[1:]), path)
+
+	out := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, command[0], args...)
+	cmd.Stdout, cmd.Stderr = out, out
+	err = cmd.Run()
+
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == 1 {
+		h.setState(
+			name, meta.StateProcessing, meta.StateQuarantined,
+			map[string]any{"reason": strings.TrimSpace(out.String())},
+		)
+		return ErrQuarantined
+	}
+	if err != nil {
+		return fmt.Errorf("scan: %w: %s", err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/jobs"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/webhook"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileStates(t *testing.T) {
+	testDir := t.TempDir()
+
+	var mu sync.Mutex
+	changes := make(map[string][]map[string]any)
+	hook := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				e := webhook.Event{}
+				assert.Nil(t, json.Unmarshal(body, &e))
+				if e.Type == webhook.FileStateChanged {
+					mu.Lock()
+					changes[e.Name] = append(changes[e.Name], e.Data.(map[string]any))
+					mu.Unlock()
+				}
+			},
+		),
+	)
+	defer hook.Close()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize: 1024,
+			DefaultPage:   1,
+			DefaultSize:   10,
+			Webhooks:      []*config.WebhookConfig{{URL: hook.URL}},
+			Jobs: &config.JobsConfig{
+				Workers: 1,
+				Tasks:   []string{TaskVerify, TaskScan},
+				ScanCommand: []string{
+					"sh", "-c", `case "$0" in *eicar*) echo "Eicar-Signature FOUND"; exit 1;; esac`,
+				},
+			},
+		},
+	)
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+	decodeError := func(rec *httptest.ResponseRecorder) utils.ErrorResponse {
+		res := utils.ErrorResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res
+	}
+	listed := func(state string) []string {
+		rec := get("/list?state=" + state)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var res struct {
+			Data []string `json:"data"`
+		}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res.Data
+	}
+	stateChanges := func(name string, n int) []map[string]any {
+		var res []map[string]any
+		assert.Eventually(
+			t, func() bool {
+				mu.Lock()
+				defer mu.Unlock()
+				res = changes[name]
+				return len(res) >= n
+			}, time.Second, 10*time.Millisecond,
+		)
+		return res
+	}
+
+	t.Run(
+		"Uploads are processing until their job finishes", func(t *testing.T) {
+			for _, name := range []string{"clean.mp4", "eicar.mp4"} {
+				rec := httptest.NewRecorder()
+				hdl.ServeHTTP(rec, newUploadRequest(name, "content of "+name, nil))
+				assert.Equal(t, http.StatusCreated, rec.Code)
+				assert.Equal(t, meta.StateProcessing, hdl.fileState(name))
+			}
+
+			rec := get("/stream/clean.mp4")
+			assert.Equal(t, http.StatusLocked, rec.Code)
+			assert.Equal(t, processingRetryAfter, rec.Header().Get("Retry-After"))
+			res := decodeError(rec)
+			assert.Equal(t, "file_processing", res.Code)
+			assert.Equal(t, meta.StateProcessing, res.Params["state"])
+
+			body, _ := json.Marshal(map[string]string{"name": "clean.mp4"})
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/downloads", bytes.NewReader(body)))
+			assert.Equal(t, http.StatusLocked, rec.Code)
+
+			assert.Equal(t, http.StatusForbidden, get("/uploads/clean.mp4").Code)
+			assert.Len(t, listed(meta.StateProcessing), 2)
+			assert.Empty(t, listed(meta.StateReady))
+		},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hdl.jobs.Run(ctx)
+
+	t.Run(
+		"Processing to ready", func(t *testing.T) {
+			c := stateChanges("clean.mp4", 1)
+			assert.Equal(t, meta.StateProcessing, c[0]["previous"])
+			assert.Equal(t, meta.StateReady, c[0]["state"])
+			assert.Equal(t, meta.StateReady, hdl.fileState("clean.mp4"))
+
+			rec := get("/stream/clean.mp4")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "content of clean.mp4", rec.Body.String())
+			assert.Equal(t, http.StatusOK, get("/uploads/clean.mp4").Code)
+		},
+	)
+
+	t.Run(
+		"Processing to quarantined", func(t *testing.T) {
+			c := stateChanges("eicar.mp4", 1)
+			assert.Equal(t, meta.StateProcessing, c[0]["previous"])
+			assert.Equal(t, meta.StateQuarantined, c[0]["state"])
+			assert.Equal(t, "Eicar-Signature FOUND", c[0]["reason"])
+			assert.Equal(t, meta.StateQuarantined, hdl.fileState("eicar.mp4"))
+
+			rec := get("/stream/eicar.mp4")
+			assert.Equal(t, http.StatusConflict, rec.Code)
+			assert.Empty(t, rec.Header().Get("Retry-After"))
+			res := decodeError(rec)
+			assert.Equal(t, "quarantined", res.Code)
+			assert.Equal(t, meta.StateQuarantined, res.Params["state"])
+
+			assert.Equal(t, http.StatusForbidden, get("/uploads/eicar.mp4").Code)
+
+			// The failed job settling afterwards does not overwrite the verdict.
+			assert.Eventually(
+				t, func() bool { return hdl.jobs.List("eicar.mp4")[0].State == jobs.StateFailed },
+				time.Second, 10*time.Millisecond,
+			)
+			assert.Contains(t, hdl.jobs.List("eicar.mp4")[0].Error, ErrQuarantined.Error())
+			assert.Len(t, stateChanges("eicar.mp4", 1), 1)
+			assert.Equal(t, meta.StateQuarantined, hdl.fileState("eicar.mp4"))
+		},
+	)
+
+	t.Run(
+		"List filters by state", func(t *testing.T) {
+			assert.Len(t, listed(meta.StateQuarantined), 1)
+			assert.Contains(t, listed(meta.StateQuarantined)[0], "eicar.mp4")
+			assert.Len(t, listed(meta.StateReady), 1)
+			assert.Contains(t, listed(meta.StateReady)[0], "clean.mp4")
+			assert.Empty(t, listed(meta.StateProcessing))
+
+			rec := get("/list?state=bogus")
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Equal(t, "invalid_state", decodeError(rec).Code)
+		},
+	)
+
+	t.Run(
+		"Ready instantly without processors", func(t *testing.T) {
+			plain := setupTestHandler(testDir)
+			rec := httptest.NewRecorder()
+			plain.ServeHTTP(rec, newUploadRequest("plain.mp4", "plain", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, meta.StateReady, plain.fileState("plain.mp4"))
+
+			rec = httptest.NewRecorder()
+			plain.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream/plain.mp4", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+		},
+	)
+}
@@ -0,0 +1,111 @@
+package http
+
+import (
+	"github.com/JMURv/media-server/internal/replication"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+)
+
+type Stats struct {
+	Files           int                     `json:"files"`
+	Bytes           int64                   `json:"bytes"`
+	Truncated       bool                    `json:"truncated,omitempty"`
+	CaseInsensitive bool                    `json:"case_insensitive"`
+	Limits          StatsLimits             `json:"limits"`
+	Replication     *replication.Stats      `json:"replication,omitempty"`
+	Shedding        map[string]LimiterStats `json:"shedding,omitempty"`
+	Trash           *TrashStats             `json:"trash,omitempty"`
+	Roots           []*RootStats            `json:"roots,omitempty"`
+}
+
+// RootStats is the share of the totals stored under one storage root, and
+// the space left on its filesystem when it is known.
+type RootStats struct {
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+	Free  *int64 `json:"free,omitempty"`
+}
+
+type StatsLimits struct {
+	MaxWalkEntries   int    `json:"max_walk_entries"`
+	MaxFilesPerDir   int    `json:"max_files_per_dir,omitempty"`
+	WalkTruncations  uint64 `json:"walk_truncations"`
+	DirCapRejections uint64 `json:"dir_cap_rejections"`
+}
+
+func (h *Handler) stats(w http.ResponseWriter, r *http.Request) {
+	withHidden, err := h.showHidden(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res := &Stats{}
+	roots := make(map[string]*RootStats)
+	if h.roots != nil {
+		for _, root := range h.rootDirs() {
+			rs := &RootStats{Path: root}
+			if free, err := h.roots.free(root); err == nil {
+				rs.Free = &free
+			}
+			roots[root] = rs
+			res.Roots = append(res.Roots, rs)
+		}
+	}
+	res.Truncated, err = h.walkRoots(
+		r.Context(), true, func(root, _ string, d fs.DirEntry) error {
+			if !withHidden && h.hidden(d.Name()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.Type()&fs.ModeSymlink != 0 {
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			res.Files++
+			res.Bytes += info.Size()
+			if rs := roots[root]; rs != nil {
+				rs.Files++
+				rs.Bytes += info.Size()
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		log.Printf("Error collecting stats: %s\n", err)
+		writeError(w, canceledOr(err, ErrReadingDir))
+		return
+	}
+
+	res.CaseInsensitive = h.caseInsensitive
+	res.Limits = StatsLimits{
+		MaxWalkEntries:   h.maxWalkEntries(),
+		MaxFilesPerDir:   h.config.MaxFilesPerDir,
+		WalkTruncations:  h.walkTruncations.Value(),
+		DirCapRejections: h.dirCapRejections.Value(),
+	}
+	if h.replicator != nil {
+		stats := h.replicator.Stats()
+		res.Replication = &stats
+	}
+	if h.shedder != nil {
+		res.Shedding = h.shedder.stats()
+	}
+	if h.trash != nil {
+		res.Trash = h.trashStats()
+	}
+	utils.JSONResponse(w, http.StatusOK, res)
+}
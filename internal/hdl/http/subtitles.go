@@ -0,0 +1,168 @@
+package http
+
+import (
+	"bufio"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	vttType    = "text/vtt"
+	subripType = "application/x-subrip"
+)
+
+var srtTimestamp = regexp.MustCompile(`(\d+:\d{2}:\d{2}),(\d{3})`)
+var languageTag = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+type Track struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Language string `json:"language,omitempty"`
+	Label    string `json:"label,omitempty"`
+	Format   string `json:"format"`
+}
+
+func subtitle(contentType string) bool {
+	t := baseMediaType(contentType)
+	return t == vttType || t == subripType
+}
+
+// srtToVTT rewrites SubRip cues as WebVTT. Cue numbers are kept as
+// identifiers, which VTT allows; only the header and the decimal comma in
+// timestamps differ.
+func srtToVTT(dst io.Writer, src io.Reader) error {
+	w := bufio.NewWriter(dst)
+	if _, err := w.WriteString("WEBVTT\n\n"); err != nil {
+		return err
+	}
+
+	s := bufio.NewScanner(src)
+	first := true
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), "\r")
+		if first {
+			line, first = strings.TrimPrefix(line, "\ufeff"), false
+		}
+		if strings.Contains(line, "-->") {
+			line = srtTimestamp.ReplaceAllString(line, "$1.$2")
+		}
+		if _, err := w.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// streamVTT serves an SRT file converted to WebVTT, for browsers that only
+// accept VTT text tracks.
+func (h *Handler) streamVTT(w http.ResponseWriter, r *http.Request, name string, file io.ReadSeeker) {
+	w.Header().Set("Content-Type", vttType+"; charset=utf-8")
+	if !h.applyContentPolicy(w, name, file) {
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		writeError(w, ErrInternal)
+		return
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	h.countDownload(r, name)
+	if err := srtToVTT(w, file); err != nil {
+		log.Printf("Error converting %s to VTT: %s\n", name, err)
+	}
+}
+
+func (h *Handler) streamURL(r *http.Request, name string) string {
+	p := (&url.URL{Path: "/stream/" + filepath.ToSlash(name)}).EscapedPath()
+	return h.baseURL(r) + p
+}
+
+// trackFor reports whether sidecar is a subtitle file for a video with the
+// given basename, such as movie.en.vtt for movie.mp4, and describes it.
+func trackFor(base, sidecar string) (Track, bool) {
+	ext := strings.ToLower(filepath.Ext(sidecar))
+	if ext != ".vtt" && ext != ".srt" {
+		return Track{}, false
+	}
+
+	stem := strings.TrimSuffix(sidecar, filepath.Ext(sidecar))
+	if stem == base {
+		return Track{Name: sidecar, Format: ext[1:]}, true
+	}
+	rest, ok := strings.CutPrefix(stem, base+".")
+	if !ok || rest == "" {
+		return Track{}, false
+	}
+
+	t := Track{Name: sidecar, Label: rest, Format: ext[1:]}
+	for _, part := range strings.Split(rest, ".") {
+		if languageTag.MatchString(part) {
+			t.Language = strings.ToLower(part)
+			break
+		}
+	}
+	return t, true
+}
+
+func (h *Handler) tracks(w http.ResponseWriter, r *http.Request) {
+	name, err := h.pathName(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !h.checkInternal(w, r, name) {
+		return
+	}
+
+	path, err := h.checkPath(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		writeError(w, withParams(ErrNotFound, map[string]any{"name": name}))
+		return
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		writeError(w, ErrReadingDir)
+		return
+	}
+
+	dir := filepath.Dir(name)
+	base := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	res := make([]Track, 0)
+	for _, e := range entries {
+		if e.IsDir() || e.Name() == filepath.Base(name) {
+			continue
+		}
+		t, ok := trackFor(base, e.Name())
+		if !ok {
+			continue
+		}
+
+		t.Name = filepath.ToSlash(filepath.Join(dir, t.Name))
+		if h.hidden(t.Name) && !h.isAdmin(r) {
+			continue
+		}
+		t.URL = h.streamURL(r, t.Name)
+		if t.Format == "srt" {
+			t.URL += "?format=vtt"
+		}
+		res = append(res, t)
+	}
+	utils.JSONResponse(w, http.StatusOK, res)
+}
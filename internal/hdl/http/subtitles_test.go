@@ -0,0 +1,128 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSRT = "\ufeff1\r\n00:00:01,000 --> 00:00:04,250\r\nHello, world\r\n\r\n2\r\n01:02:03,004 --> 01:02:05,000 align:start\r\nSecond, line\r\n"
+
+func TestSubtitles(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize: 1024 * 1024,
+			CORS:          &config.CORSConfig{AllowedOrigins: []string{"https://app.example.com"}, MaxAge: 10 * time.Minute},
+		},
+	)
+	for name, content := range map[string]string{
+		"movie.mp4":              "video",
+		"movie.en.vtt":           "WEBVTT\n\n00:00.000 --> 00:01.000\nHi\n",
+		"movie.ru.srt":           testSRT,
+		"movie.vtt":              "WEBVTT\n",
+		"movie.pt-BR.forced.srt": testSRT,
+		"movie2.en.vtt":          "WEBVTT\n",
+		"other.en.vtt":           "WEBVTT\n",
+	} {
+		assert.Nil(t, os.WriteFile(filepath.Join(testDir, name), []byte(content), 0644))
+	}
+	do := func(method, path string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run(
+		"SRT to VTT", func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			assert.Nil(t, srtToVTT(buf, strings.NewReader(testSRT)))
+			assert.Equal(
+				t,
+				"WEBVTT\n\n1\n00:00:01.000 --> 00:00:04.250\nHello, world\n\n2\n01:02:03.004 --> 01:02:05.000 align:start\nSecond, line\n",
+				buf.String(),
+			)
+		},
+	)
+
+	t.Run(
+		"VTT is streamed as text/vtt", func(t *testing.T) {
+			rec := do(http.MethodGet, "/stream/movie.en.vtt", nil)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "text/vtt", rec.Header().Get("Content-Type"))
+			assert.Contains(t, rec.Body.String(), "WEBVTT")
+
+			assert.Equal(t, http.StatusOK, do(http.MethodGet, "/stream/movie.en.vtt?format=vtt", nil).Code)
+			assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/stream/movie.mp4?format=vtt", nil).Code)
+		},
+	)
+
+	t.Run(
+		"SRT is converted on request", func(t *testing.T) {
+			rec := do(http.MethodGet, "/stream/movie.ru.srt", nil)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, subripType, rec.Header().Get("Content-Type"))
+
+			rec = do(http.MethodGet, "/stream/movie.ru.srt?format=vtt", nil)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "text/vtt; charset=utf-8", rec.Header().Get("Content-Type"))
+			assert.True(t, strings.HasPrefix(rec.Body.String(), "WEBVTT\n\n1\n00:00:01.000 --> 00:00:04.250\n"))
+		},
+	)
+
+	t.Run(
+		"Tracks", func(t *testing.T) {
+			rec := do(http.MethodGet, "/files/movie.mp4/tracks", nil)
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			var res []Track
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.Equal(
+				t, []Track{
+					{Name: "movie.en.vtt", URL: "/stream/movie.en.vtt", Language: "en", Label: "en", Format: "vtt"},
+					{Name: "movie.pt-BR.forced.srt", URL: "/stream/movie.pt-BR.forced.srt?format=vtt", Language: "pt-br", Label: "pt-BR.forced", Format: "srt"},
+					{Name: "movie.ru.srt", URL: "/stream/movie.ru.srt?format=vtt", Language: "ru", Label: "ru", Format: "srt"},
+					{Name: "movie.vtt", URL: "/stream/movie.vtt", Format: "vtt"},
+				}, res,
+			)
+
+			rec = do(http.MethodGet, "/files/missing.mp4/tracks", nil)
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		},
+	)
+
+	t.Run(
+		"CORS", func(t *testing.T) {
+			origin := map[string]string{"Origin": "https://app.example.com"}
+			rec := do(http.MethodGet, "/stream/movie.en.vtt", origin)
+			assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+			assert.Contains(t, rec.Header().Get("Access-Control-Expose-Headers"), "Content-Range")
+			assert.Equal(t, "Origin", rec.Header().Get("Vary"))
+
+			rec = do(http.MethodGet, "/files/movie.mp4/tracks", origin)
+			assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+
+			rec = do(http.MethodOptions, "/stream/movie.ru.srt", origin)
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+			assert.Contains(t, rec.Header().Get("Access-Control-Allow-Headers"), "Range")
+			assert.Equal(t, "600", rec.Header().Get("Access-Control-Max-Age"))
+
+			rec = do(http.MethodGet, "/stream/movie.en.vtt", map[string]string{"Origin": "https://evil.example.com"})
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+		},
+	)
+}
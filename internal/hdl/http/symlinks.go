@@ -0,0 +1,72 @@
+package http
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func symlinkError(err error) bool {
+	return errors.Is(err, ErrSymlinkNotAllowed) || errors.Is(err, ErrSymlinkOutsideRoot)
+}
+
+// checkPath maps name onto the root it is stored under and lstats every
+// component, so that a symlink anywhere along the way is refused unless
+// FollowSymlinks is set and its target stays under that root. Missing
+// components are left for the caller to report.
+func (h *Handler) checkPath(name string) (string, error) {
+	root := h.rootFor(name)
+	path := filepath.Join(root, filepath.FromSlash(name))
+	rel, err := filepath.Rel(root, path)
+	if err != nil || !within(path, root) {
+		return "", ErrSymlinkOutsideRoot
+	}
+	if rel == "." {
+		return path, nil
+	}
+
+	cur := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		cur = filepath.Join(cur, part)
+		info, err := os.Lstat(cur)
+		if err != nil {
+			return path, nil
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			continue
+		}
+
+		if !h.config.FollowSymlinks {
+			return "", ErrSymlinkNotAllowed
+		}
+		resolved, err := filepath.EvalSymlinks(cur)
+		if err != nil {
+			return path, nil
+		}
+		if !within(resolved, root) {
+			return "", ErrSymlinkOutsideRoot
+		}
+	}
+	return path, nil
+}
+
+func (h *Handler) openFlags(flag int) int {
+	if h.config.FollowSymlinks {
+		return flag
+	}
+	return flag | noFollow
+}
+
+func (h *Handler) listable(entry fs.DirEntry) bool {
+	if entry.Type()&fs.ModeSymlink == 0 {
+		return true
+	}
+	path, err := h.checkPath(entry.Name())
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,133 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need elevated privileges on windows")
+	}
+
+	testDir := t.TempDir()
+
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret.png")
+	assert.Nil(t, os.WriteFile(secret, []byte("secret"), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(testDir, "inside.png"), []byte("inside"), 0644))
+	assert.Nil(t, os.Symlink(filepath.Join(outside, "secret.png"), filepath.Join(testDir, "out.png")))
+	assert.Nil(t, os.Symlink("inside.png", filepath.Join(testDir, "in.png")))
+	assert.Nil(t, os.Symlink(outside, filepath.Join(testDir, "outdir")))
+
+	newHandler := func(follow bool) *Handler {
+		return New(
+			port, testDir, &config.HTTPConfig{
+				MaxStreamBuffer: 1024,
+				DefaultPage:     1,
+				DefaultSize:     10,
+				FollowSymlinks:  follow,
+			},
+		)
+	}
+	do := func(h *Handler, method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader([]byte("overwrite"))))
+		return rec
+	}
+	list := func(h *Handler) []string {
+		res := struct {
+			Data []string `json:"data"`
+		}{}
+		assert.Nil(t, json.NewDecoder(do(h, http.MethodGet, "/list").Body).Decode(&res))
+
+		var names []string
+		for _, u := range res.Data {
+			names = append(names, filepath.Base(u))
+		}
+		return names
+	}
+	errorOf := func(rec *httptest.ResponseRecorder) string {
+		res := utils.ErrorResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res.Error
+	}
+
+	t.Run(
+		"Refused by default", func(t *testing.T) {
+			hdl := newHandler(false)
+
+			rec := do(hdl, http.MethodGet, "/stream/out.png")
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+			assert.Equal(t, ErrSymlinkNotAllowed.Error(), errorOf(rec))
+
+			assert.Equal(t, http.StatusForbidden, do(hdl, http.MethodGet, "/stream/in.png").Code)
+			assert.Equal(t, http.StatusForbidden, do(hdl, http.MethodGet, "/uploads/out.png").Code)
+			assert.Equal(t, http.StatusForbidden, do(hdl, http.MethodHead, "/files/out.png").Code)
+			assert.Equal(t, http.StatusOK, do(hdl, http.MethodGet, "/stream/inside.png").Code)
+
+			assert.Equal(t, []string{"inside.png"}, list(hdl))
+		},
+	)
+
+	t.Run(
+		"Mutations do not touch the link or its target", func(t *testing.T) {
+			hdl := newHandler(false)
+
+			assert.Equal(t, http.StatusForbidden, do(hdl, http.MethodDelete, "/files/out.png").Code)
+			assert.Equal(t, http.StatusForbidden, do(hdl, http.MethodPatch, "/files/out.png").Code)
+
+			_, err := os.Lstat(filepath.Join(testDir, "out.png"))
+			assert.Nil(t, err)
+			data, err := os.ReadFile(secret)
+			assert.Nil(t, err)
+			assert.Equal(t, "secret", string(data))
+		},
+	)
+
+	t.Run(
+		"Followed only inside the root", func(t *testing.T) {
+			hdl := newHandler(true)
+
+			rec := do(hdl, http.MethodGet, "/stream/in.png")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "inside", rec.Body.String())
+
+			rec = do(hdl, http.MethodGet, "/stream/out.png")
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+			assert.Equal(t, ErrSymlinkOutsideRoot.Error(), errorOf(rec))
+			assert.Equal(t, http.StatusForbidden, do(hdl, http.MethodDelete, "/files/out.png").Code)
+
+			assert.ElementsMatch(t, []string{"in.png", "inside.png"}, list(hdl))
+		},
+	)
+
+	t.Run(
+		"Linked directories along the path", func(t *testing.T) {
+			_, err := newHandler(false).checkPath("outdir/secret.png")
+			assert.ErrorIs(t, err, ErrSymlinkNotAllowed)
+			_, err = newHandler(true).checkPath("outdir/secret.png")
+			assert.ErrorIs(t, err, ErrSymlinkOutsideRoot)
+		},
+	)
+
+	t.Run(
+		"Excluded from walks", func(t *testing.T) {
+			hdl := newHandler(true)
+			manifest, err := hdl.snapshot(context.Background())
+			assert.Nil(t, err)
+			assert.Len(t, manifest.Files, 1)
+			assert.Equal(t, "inside.png", manifest.Files[0].Name)
+		},
+	)
+}
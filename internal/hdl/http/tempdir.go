@@ -0,0 +1,247 @@
+package http
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	tempDirName = ".tmp"
+
+	defaultMaxFormValues = 10 << 20
+)
+
+var tempPrefixes = []string{".upload-", ".move-", "preview-"}
+
+// defaultUploadFields are the multipart field names a file is read from,
+// covering the usual browser forms, CKEditor and Uppy.
+var defaultUploadFields = []string{"file", "upload", "files[]", "files[0]", "files"}
+
+// filenameField, when posted, names the upload instead of the file part.
+const filenameField = "filename"
+
+var errFormTooLarge = errors.New("form values too large")
+
+type uploadPart struct {
+	filename string
+	path     string
+	size     int64
+	checksum string
+}
+
+func prepareTempDir(dir, root string, strict bool) (string, bool, error) {
+	if dir == "" {
+		dir = filepath.Join(root, tempDirName)
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", false, fmt.Errorf("creating temp dir %s: %w", dir, err)
+	}
+
+	abs, err := resolvePath(dir)
+	if err != nil {
+		return "", false, fmt.Errorf("resolving temp dir %s: %w", dir, err)
+	}
+	if within(root, abs) {
+		return "", false, fmt.Errorf("%w: %s contains the save path %s", ErrUnsafeTempDir, abs, root)
+	}
+
+	entries, err := os.ReadDir(abs)
+	if err != nil {
+		return "", false, fmt.Errorf("reading temp dir %s: %w", abs, err)
+	}
+	for _, e := range entries {
+		for _, prefix := range tempPrefixes {
+			if strings.HasPrefix(e.Name(), prefix) {
+				if err = os.RemoveAll(filepath.Join(abs, e.Name())); err != nil {
+					log.Printf("Error removing stale temp file %s: %s\n", e.Name(), err)
+				}
+				break
+			}
+		}
+	}
+
+	same, err := sameDevice(abs, root)
+	if err != nil {
+		return "", false, fmt.Errorf("checking temp dir %s: %w", abs, err)
+	}
+	if !same {
+		if strict {
+			return "", false, fmt.Errorf("%w: %s", ErrTempDirCrossDevice, abs)
+		}
+		log.Printf("Temp dir %s is on a different filesystem than %s, uploads will be copied\n", abs, root)
+	}
+	return abs, !same, nil
+}
+
+func (h *Handler) moveFile(src, dst string) error {
+	if !h.crossDevice {
+		err := h.uploadFS.Rename(src, dst)
+		if err == nil {
+			h.tempRenames.Inc()
+			return nil
+		}
+		if !isCrossDevice(err) {
+			return err
+		}
+	}
+
+	tmp, err := h.copyBeside(src, dst)
+	if err != nil {
+		return err
+	}
+	defer h.uploadFS.Remove(tmp)
+
+	if err = h.uploadFS.Rename(tmp, dst); err != nil {
+		return err
+	}
+
+	h.tempCopies.Inc()
+	return h.uploadFS.Remove(src)
+}
+
+// copyBeside copies src to a synced temp file in dst's directory and
+// returns its path.
+func (h *Handler) copyBeside(src, dst string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".move-*")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(tmp, in)
+	if err = errors.Join(err, tmp.Sync(), tmp.Close()); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+func (h *Handler) uploadFields() []string {
+	if len(h.config.UploadFields) > 0 {
+		return h.config.UploadFields
+	}
+	return defaultUploadFields
+}
+
+// parseUpload streams the multipart form, spooling the file part to a temp
+// file. The file is taken from the first of the upload fields present, in
+// the order they are listed; other file parts are discarded and other values
+// kept. A filename value overrides the part's filename. A non-nil check runs
+// once the file part's name is known and before its content is read; its
+// error stops parsing. A form without a file part fails with ErrNoFilePart.
+func (h *Handler) parseUpload(r *http.Request, check func(filename string, values url.Values) error) (*uploadPart, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	budget := h.config.MaxUploadSize
+	if budget <= 0 {
+		budget = defaultMaxFormValues
+	}
+
+	fields := h.uploadFields()
+	rank := len(fields)
+	var found []string
+
+	values := url.Values{}
+	var upload *uploadPart
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, errors.Join(err, upload.remove())
+		}
+
+		name := part.FormName()
+		if !slices.Contains(found, name) {
+			found = append(found, name)
+		}
+		if part.FileName() == "" {
+			data, err := io.ReadAll(io.LimitReader(part, budget+1))
+			if err == nil && int64(len(data)) > budget {
+				err = errFormTooLarge
+			}
+			if err != nil {
+				return nil, errors.Join(err, upload.remove())
+			}
+			budget -= int64(len(data))
+			values.Add(name, string(data))
+			continue
+		}
+
+		i := slices.Index(fields, name)
+		if i < 0 || i >= rank {
+			if _, err = io.Copy(io.Discard, part); err != nil {
+				return nil, errors.Join(err, upload.remove())
+			}
+			continue
+		}
+		if err = upload.remove(); err != nil {
+			return nil, err
+		}
+		upload, rank = nil, i
+
+		filename := cmp.Or(values.Get(filenameField), part.FileName())
+		if check != nil {
+			if err = check(filename, values); err != nil {
+				return nil, err
+			}
+		}
+
+		path, size, checksum, err := h.writeTemp(part)
+		upload = &uploadPart{filename: part.FileName(), path: path, size: size, checksum: checksum}
+		if err != nil {
+			return nil, errors.Join(err, upload.remove())
+		}
+	}
+
+	if upload == nil {
+		expected, got := strings.Join(fields, ", "), strings.Join(found, ", ")
+		if got == "" {
+			got = "none"
+		}
+		return nil, withParams(
+			fmt.Errorf("%w: expected one of %s, found %s", ErrNoFilePart, expected, got),
+			map[string]any{"expected": expected, "found": got},
+		)
+	}
+	if v := values.Get(filenameField); v != "" {
+		upload.filename = v
+	}
+
+	r.MultipartForm = &multipart.Form{Value: values, File: map[string][]*multipart.FileHeader{}}
+	r.PostForm = values
+	r.Form = r.URL.Query()
+	for k, v := range values {
+		r.Form[k] = append(v, r.Form[k]...)
+	}
+	return upload, nil
+}
+
+func (u *uploadPart) remove() error {
+	if u == nil || u.path == "" {
+		return nil
+	}
+	if err := os.Remove(u.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package http
+
+import (
+	"bytes"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTempDir(t *testing.T) {
+	testDir := t.TempDir()
+
+	tmp := filepath.Join(testDir, tempDirName)
+	assert.Nil(t, os.MkdirAll(tmp, os.ModePerm))
+	assert.Nil(t, os.WriteFile(filepath.Join(tmp, ".upload-stale"), []byte("stale"), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(tmp, "keep.txt"), []byte("keep"), 0644))
+
+	hdl := setupTestHandler(testDir)
+	tempEntries := func() []string {
+		entries, err := os.ReadDir(hdl.tempDir)
+		assert.Nil(t, err)
+
+		var res []string
+		for _, e := range entries {
+			res = append(res, e.Name())
+		}
+		return res
+	}
+
+	t.Run(
+		"Created and cleaned at startup", func(t *testing.T) {
+			abs, err := filepath.Abs(tmp)
+			assert.Nil(t, err)
+			assert.Equal(t, abs, hdl.tempDir)
+			assert.False(t, hdl.crossDevice)
+			assert.Equal(t, []string{"keep.txt"}, tempEntries())
+		},
+	)
+
+	t.Run(
+		"Unsafe location", func(t *testing.T) {
+			_, _, err := prepareTempDir(filepath.Dir(hdl.savePath), hdl.savePath, false)
+			assert.ErrorIs(t, err, ErrUnsafeTempDir)
+
+			assert.Panics(t, func() { New(port, testDir, &config.HTTPConfig{TempDir: testDir}) })
+		},
+	)
+
+	t.Run(
+		"Uploads are renamed into place", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("renamed.txt", "renamed", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			data, err := os.ReadFile(filepath.Join(testDir, "renamed.txt"))
+			assert.Nil(t, err)
+			assert.Equal(t, "renamed", string(data))
+			assert.Equal(t, uint64(1), hdl.tempRenames.Value())
+			assert.Equal(t, uint64(0), hdl.tempCopies.Value())
+			assert.Equal(t, []string{"keep.txt"}, tempEntries())
+		},
+	)
+
+	t.Run(
+		"Copied across filesystems", func(t *testing.T) {
+			hdl.crossDevice = true
+			defer func() { hdl.crossDevice = false }()
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("copied.txt", "copied", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			data, err := os.ReadFile(filepath.Join(testDir, "copied.txt"))
+			assert.Nil(t, err)
+			assert.Equal(t, "copied", string(data))
+			assert.Equal(t, uint64(1), hdl.tempRenames.Value())
+			assert.Equal(t, uint64(1), hdl.tempCopies.Value())
+			assert.Equal(t, []string{"keep.txt"}, tempEntries())
+		},
+	)
+
+	t.Run(
+		"Fields after the file part", func(t *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			file, _ := writer.CreateFormFile("file", "late.txt")
+			file.Write([]byte("late"))
+			writer.WriteField("tags", "a, b")
+			writer.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/upload", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			m, err := hdl.meta.Get("late.txt")
+			assert.Nil(t, err)
+			assert.Equal(t, []string{"a", "b"}, m.Tags)
+		},
+	)
+
+	t.Run(
+		"Custom directory", func(t *testing.T) {
+			custom := t.TempDir()
+			same, err := sameDevice(custom, hdl.savePath)
+			assert.Nil(t, err)
+
+			cfg := &config.HTTPConfig{TempDir: custom, StrictTempDir: true}
+			if !same {
+				assert.Panics(t, func() { New(port, testDir, cfg) })
+				cfg.StrictTempDir = false
+			}
+
+			h := New(port, testDir, cfg)
+			resolved, err := resolvePath(custom)
+			assert.Nil(t, err)
+			assert.Equal(t, resolved, h.tempDir)
+			assert.Equal(t, !same, h.crossDevice)
+		},
+	)
+}
@@ -0,0 +1,68 @@
+package http
+
+import (
+	"context"
+	"github.com/JMURv/media-server/internal/meta"
+	"log"
+	"time"
+)
+
+const (
+	defaultTombstoneRetention = 30 * 24 * time.Hour
+	minTombstonePruneInterval = time.Minute
+)
+
+func (h *Handler) tombstoneRetention() time.Duration {
+	if h.config.Changes.TombstoneRetention > 0 {
+		return h.config.Changes.TombstoneRetention
+	}
+	return defaultTombstoneRetention
+}
+
+// bury leaves a tombstone for the delete journaled as c, or drops the
+// tombstone of a name stored again.
+func (h *Handler) bury(c meta.Change) {
+	var err error
+	if c.Type == meta.ChangeDeleted {
+		err = h.tombstones.Add(meta.Tombstone{Name: c.Name, Seq: c.Seq, DeletedAt: c.Time})
+	} else {
+		err = h.tombstones.Remove(c.Name)
+	}
+	if err != nil {
+		log.Printf("Error saving tombstone of %s: %s\n", c.Name, err)
+	}
+}
+
+// deletedError is the error GET /files/{name}/meta answers a missing name
+// with: file_deleted with when it was deleted, when it has a tombstone and
+// the changes config asks for it, otherwise not found.
+func (h *Handler) deletedError(name string) error {
+	if h.tombstones == nil || !h.config.Changes.Gone {
+		return ErrNotFound
+	}
+	ts, ok := h.tombstones.Get(name)
+	if !ok {
+		return ErrNotFound
+	}
+	return withParams(ErrFileDeleted, map[string]any{"name": name, "deleted_at": ts.DeletedAt})
+}
+
+func (h *Handler) runTombstoneJanitor(ctx context.Context) {
+	ticker := time.NewTicker(max(h.tombstoneRetention()/24, minTombstonePruneInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := h.tombstones.Prune(now.Add(-h.tombstoneRetention()))
+			if err != nil {
+				log.Printf("Error pruning tombstones: %s\n", err)
+			}
+			if n > 0 {
+				log.Printf("Pruned %d expired tombstones\n", n)
+			}
+		}
+	}
+}
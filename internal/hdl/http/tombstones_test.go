@@ -0,0 +1,119 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTombstones(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize: 1024,
+			Changes:       &config.ChangesConfig{Gone: true},
+		},
+	)
+	do := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	upload := func(name, content string) {
+		req := newUploadRequest(name, content, nil)
+		req.URL.RawQuery = "conflict=overwrite"
+		rec := do(req)
+		assert.Contains(t, []int{http.StatusCreated, http.StatusOK}, rec.Code, rec.Body.String())
+	}
+	changes := func(query string) ChangesResponse {
+		rec := do(httptest.NewRequest(http.MethodGet, "/changes?"+query, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		res := ChangesResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res
+	}
+
+	upload("synced.txt", "as the client saw it")
+	upload("other.txt", "untouched")
+	cursor := changes("").Cursor
+
+	t.Run(
+		"Conditional delete", func(t *testing.T) {
+			m, err := hdl.meta.Get("synced.txt")
+			assert.Nil(t, err)
+			stale := `"` + m.Checksum + `"`
+
+			upload("synced.txt", "changed on the server")
+			req := httptest.NewRequest(http.MethodDelete, "/files/synced.txt", nil)
+			req.Header.Set("If-Match", stale)
+			rec := do(req)
+			assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+			assert.NotEmpty(t, rec.Header().Get("ETag"))
+			assert.FileExists(t, filepath.Join(testDir, "synced.txt"))
+
+			req = httptest.NewRequest(http.MethodDelete, "/files/synced.txt", nil)
+			req.Header.Set("If-Match", rec.Header().Get("ETag"))
+			assert.Equal(t, http.StatusNoContent, do(req).Code)
+
+			req = httptest.NewRequest(http.MethodDelete, "/files/synced.txt", nil)
+			req.Header.Set("If-Match", stale)
+			assert.Equal(t, http.StatusPreconditionFailed, do(req).Code)
+		},
+	)
+
+	t.Run(
+		"Deleted files are gone", func(t *testing.T) {
+			rec := do(httptest.NewRequest(http.MethodGet, "/files/synced.txt/meta", nil))
+			assert.Equal(t, http.StatusGone, rec.Code)
+			assert.Contains(t, rec.Body.String(), "file_deleted")
+			assert.Contains(t, rec.Body.String(), "deleted_at")
+
+			rec = do(httptest.NewRequest(http.MethodGet, "/files/never.txt/meta", nil))
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+
+			rec = do(httptest.NewRequest(http.MethodGet, "/list?page=1&size=10", nil))
+			assert.NotContains(t, rec.Body.String(), "synced.txt")
+		},
+	)
+
+	t.Run(
+		"Changes list tombstones", func(t *testing.T) {
+			assert.Empty(t, changes("since="+cursor).Tombstones)
+
+			res := changes("tombstones=true&since=" + cursor)
+			if assert.Len(t, res.Tombstones, 1) {
+				assert.Equal(t, "synced.txt", res.Tombstones[0].Name)
+				assert.False(t, res.Tombstones[0].DeletedAt.IsZero())
+			}
+			assert.Empty(t, changes("tombstones=true&since="+res.Cursor).Tombstones)
+			assert.Len(t, changes("tombstones=true").Tombstones, 1)
+		},
+	)
+
+	t.Run(
+		"Uploads lift tombstones", func(t *testing.T) {
+			upload("synced.txt", "back again")
+			_, ok := hdl.tombstones.Get("synced.txt")
+			assert.False(t, ok)
+			assert.Equal(t, http.StatusOK, do(httptest.NewRequest(http.MethodGet, "/files/synced.txt/meta", nil)).Code)
+		},
+	)
+
+	t.Run(
+		"Pruned after the retention", func(t *testing.T) {
+			assert.Equal(t, http.StatusNoContent, do(httptest.NewRequest(http.MethodDelete, "/files/other.txt", nil)).Code)
+			n, err := hdl.tombstones.Prune(time.Now().Add(time.Minute))
+			assert.Nil(t, err)
+			assert.Equal(t, 1, n)
+			assert.Equal(t, []meta.Tombstone{}, hdl.tombstones.Since(0))
+			assert.Equal(t, http.StatusNotFound, do(httptest.NewRequest(http.MethodGet, "/files/other.txt/meta", nil)).Code)
+		},
+	)
+}
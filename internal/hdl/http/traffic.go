@@ -0,0 +1,165 @@
+package http
+
+import (
+	"context"
+	"github.com/JMURv/media-server/internal/traffic"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	trafficDir                  = ".traffic"
+	defaultTrafficFlushInterval = time.Minute
+	defaultTrafficRetention     = 400 * 24 * time.Hour
+	defaultTrafficDays          = 30
+	maxTrafficDays              = 366
+)
+
+type TrafficResponse struct {
+	Principal string           `json:"principal,omitempty"`
+	From      string           `json:"from"`
+	To        string           `json:"to"`
+	Total     traffic.Bytes    `json:"total"`
+	Days      []traffic.Bucket `json:"days"`
+}
+
+type meteredBody struct {
+	io.ReadCloser
+	c *traffic.Counter
+}
+
+func (b *meteredBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.c.AddIngress(int64(n))
+	return n, err
+}
+
+type meteredWriter struct {
+	http.ResponseWriter
+	c *traffic.Counter
+}
+
+func (w *meteredWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.c.AddEgress(int64(n))
+	return n, err
+}
+
+func (w *meteredWriter) ReadFrom(src io.Reader) (int64, error) {
+	n, err := io.Copy(w.ResponseWriter, src)
+	w.c.AddEgress(n)
+	return n, err
+}
+
+func (w *meteredWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *meteredWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// meter counts the body bytes a request reads and writes towards its
+// principal. Bytes are counted as they move, so aborted transfers count
+// what they got through.
+func (h *Handler) meter(next http.Handler) http.Handler {
+	if h.traffic == nil {
+		return next
+	}
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			c := h.traffic.Counter(h.principal(r))
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = &meteredBody{ReadCloser: r.Body, c: c}
+			}
+			next.ServeHTTP(&meteredWriter{ResponseWriter: w, c: c}, r)
+		},
+	)
+}
+
+func (h *Handler) runTrafficFlusher(ctx context.Context) {
+	interval := h.config.Traffic.FlushInterval
+	if interval <= 0 {
+		interval = defaultTrafficFlushInterval
+	}
+	retention := h.config.Traffic.Retention
+	if retention <= 0 {
+		retention = defaultTrafficRetention
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := h.traffic.Flush(); err != nil {
+				log.Printf("Error flushing traffic counts: %s\n", err)
+			}
+			if n, err := h.traffic.Prune(now.Add(-retention)); err != nil {
+				log.Printf("Error pruning traffic counts: %s\n", err)
+			} else if n > 0 {
+				log.Printf("Pruned %d days of traffic counts\n", n)
+			}
+		}
+	}
+}
+
+func (h *Handler) trafficReport(w http.ResponseWriter, r *http.Request) {
+	if h.traffic == nil {
+		writeError(w, ErrTrafficDisabled)
+		return
+	}
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+
+	q := r.URL.Query()
+	to := time.Now().UTC()
+	if v := q.Get("to"); v != "" {
+		var err error
+		if to, err = time.Parse(traffic.DayLayout, v); err != nil {
+			writeError(w, ErrInvalidDay)
+			return
+		}
+	}
+	from := to.AddDate(0, 0, 1-defaultTrafficDays)
+	if v := q.Get("from"); v != "" {
+		var err error
+		if from, err = time.Parse(traffic.DayLayout, v); err != nil {
+			writeError(w, ErrInvalidDay)
+			return
+		}
+	}
+	if from.After(to) || to.Sub(from) >= maxTrafficDays*24*time.Hour {
+		writeError(w, withParams(ErrInvalidTrafficRange, map[string]any{"max": maxTrafficDays}))
+		return
+	}
+
+	principal := q.Get("principal")
+	days, err := h.traffic.Report(principal, from, to)
+	if err != nil {
+		log.Printf("Error reading traffic counts: %s\n", err)
+		writeError(w, ErrInternal)
+		return
+	}
+
+	res := TrafficResponse{
+		Principal: principal,
+		From:      from.Format(traffic.DayLayout),
+		To:        to.Format(traffic.DayLayout),
+		Days:      days,
+	}
+	for _, d := range days {
+		res.Total.Ingress += d.Ingress
+		res.Total.Egress += d.Egress
+	}
+	utils.JSONResponse(w, http.StatusOK, res)
+}
@@ -0,0 +1,160 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/JMURv/media-server/internal/traffic"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// brokenWriter accepts limit bytes of the body, then fails like a client
+// that went away.
+type brokenWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (w *brokenWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		n, _ := w.ResponseRecorder.Write(p[:w.limit])
+		w.limit = 0
+		return n, errors.New("connection reset")
+	}
+	w.limit -= len(p)
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestTraffic(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize:   1 << 20,
+			MaxStreamBuffer: 1024,
+			AdminToken:      "admin-secret",
+			Traffic:         &config.TrafficConfig{},
+			Policy: &config.PolicyConfig{
+				Principals: []*config.PrincipalConfig{{Name: "ingest", Token: "ingest-key"}},
+				Rules: []*config.PolicyRule{
+					{Principals: []string{"ingest", "anonymous"}, Actions: []string{"upload", "download", "list"}},
+				},
+			},
+		},
+	)
+	report := func(query string) (*httptest.ResponseRecorder, TrafficResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/stats/traffic?"+query, nil)
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		res := TrafficResponse{}
+		if rec.Code == http.StatusOK {
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		}
+		return rec, res
+	}
+	today := time.Now().UTC().Format(traffic.DayLayout)
+	content := strings.Repeat("m", 4000)
+
+	t.Run(
+		"Counted per principal", func(t *testing.T) {
+			req := newUploadRequest("clip.mp4", content, nil)
+			req.Header.Set("Authorization", "Bearer ingest-key")
+			size := req.ContentLength
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			uploadReply := int64(rec.Body.Len())
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/uploads/clip.mp4", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			_, res := report("principal=ingest")
+			if assert.Len(t, res.Days, 1) {
+				assert.Equal(t, today, res.Days[0].Day)
+				assert.Equal(t, "ingest", res.Days[0].Principal)
+				assert.Equal(t, size, res.Days[0].Ingress)
+				assert.Equal(t, uploadReply, res.Days[0].Egress)
+			}
+			assert.Equal(t, size, res.Total.Ingress)
+
+			_, res = report("principal=anonymous")
+			if assert.Len(t, res.Days, 1) {
+				assert.Equal(t, int64(len(content)), res.Days[0].Egress)
+				assert.Zero(t, res.Days[0].Ingress)
+			}
+		},
+	)
+
+	t.Run(
+		"Aborted transfers count what moved", func(t *testing.T) {
+			_, before := report("principal=anonymous")
+
+			w := &brokenWriter{ResponseRecorder: httptest.NewRecorder(), limit: 1000}
+			hdl.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/uploads/clip.mp4", nil))
+
+			_, after := report("principal=anonymous")
+			assert.Equal(t, int64(1000), after.Total.Egress-before.Total.Egress)
+		},
+	)
+
+	t.Run(
+		"Survives a restart", func(t *testing.T) {
+			_, before := report("principal=ingest")
+			assert.Nil(t, hdl.traffic.Flush())
+			assert.FileExists(t, filepath.Join(testDir, trafficDir, today+".json"))
+
+			days, err := traffic.Open(filepath.Join(testDir, trafficDir)).Report("ingest", time.Now(), time.Now())
+			assert.Nil(t, err)
+			assert.Equal(t, before.Days, days)
+
+			_, after := report("principal=ingest")
+			assert.Equal(t, before.Days, after.Days)
+		},
+	)
+
+	t.Run(
+		"Ranges", func(t *testing.T) {
+			old := `{"ingest":{"ingress":5,"egress":7}}`
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, trafficDir, "2024-03-01.json"), []byte(old), 0644))
+
+			rec, res := report("principal=ingest&from=2024-02-01&to=2024-03-31")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, []traffic.Bucket{{Day: "2024-03-01", Principal: "ingest", Bytes: traffic.Bytes{Ingress: 5, Egress: 7}}}, res.Days)
+
+			_, res = report("")
+			assert.Equal(t, today, res.To)
+			assert.NotEmpty(t, res.Days)
+
+			rec, _ = report("from=yesterday")
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			rec, _ = report("from=2024-03-02&to=2024-03-01")
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			rec, _ = report("from=2020-01-01&to=2024-03-01")
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Contains(t, rec.Body.String(), "invalid_traffic_range")
+
+			n, err := hdl.traffic.Prune(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+			assert.Nil(t, err)
+			assert.Equal(t, 1, n)
+		},
+	)
+
+	t.Run(
+		"Admin only", func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/stats/traffic", nil)
+			req.Header.Set("Authorization", "Bearer ingest-key")
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		},
+	)
+}
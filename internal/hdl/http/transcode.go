@@ -0,0 +1,339 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/internal/jobs"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/webhook"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TaskTranscode = "transcode:"
+	// TaskTranscodeOverwrite transcodes like TaskTranscode, replacing an
+	// existing output instead of failing.
+	TaskTranscodeOverwrite = "transcode-overwrite:"
+)
+
+var presetName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+type transcodeRequest struct {
+	Preset   string `json:"preset"`
+	Conflict string `json:"conflict,omitempty"`
+}
+
+func (h *Handler) initTranscode(pool *jobs.Pool) error {
+	cfg := h.config.Transcode
+	for name, p := range cfg.Presets {
+		if !presetName.MatchString(name) {
+			return fmt.Errorf("%w: %q", ErrInvalidPreset, name)
+		}
+		if p == nil || !presetName.MatchString(p.Container) {
+			return fmt.Errorf("%w: %q needs a container", ErrInvalidPreset, name)
+		}
+		pool.Register(TaskTranscode+name, h.transcodeTask(name, p, false))
+		pool.Register(TaskTranscodeOverwrite+name, h.transcodeTask(name, p, true))
+	}
+
+	h.transcodeSem = make(chan struct{}, max(cfg.Concurrency, 1))
+	return nil
+}
+
+func (h *Handler) transcodeThreads() int {
+	budget := h.config.Transcode.CPUBudget
+	if budget <= 0 {
+		budget = runtime.NumCPU()
+	}
+	return max(budget/cap(h.transcodeSem), 1)
+}
+
+func transcodeOutput(name, preset string, p *config.TranscodePreset) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + "." + preset + "." + p.Container
+}
+
+func (h *Handler) ffmpegArgs(src, dst string, p *config.TranscodePreset) []string {
+	args := []string{"-hide_banner", "-nostdin", "-y", "-i", src, "-threads", strconv.Itoa(h.transcodeThreads())}
+	if p.VideoCodec != "" {
+		args = append(args, "-c:v", p.VideoCodec)
+	}
+	if p.VideoBitrate != "" {
+		args = append(args, "-b:v", p.VideoBitrate)
+	}
+	if p.Height > 0 {
+		args = append(args, "-vf", fmt.Sprintf("scale=-2:%d", p.Height))
+	}
+	if p.AudioCodec != "" {
+		args = append(args, "-c:a", p.AudioCodec)
+	}
+	if p.AudioBitrate != "" {
+		args = append(args, "-b:a", p.AudioBitrate)
+	}
+	if p.Container == "mp4" || p.Container == "mov" {
+		args = append(args, "-movflags", "+faststart")
+	}
+	return append(args, dst)
+}
+
+func parseClock(s string) (time.Duration, bool) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 3 {
+		return 0, false
+	}
+
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	sec, err3 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, false
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec*float64(time.Second)), true
+}
+
+func scanProgressLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// trackFFmpeg reports ffmpeg's progress through duration, or through the
+// input's duration when 0, and returns the last line it printed.
+func trackFFmpeg(ctx context.Context, stderr io.Reader, duration time.Duration) string {
+	var last string
+
+	scanner := bufio.NewScanner(stderr)
+	scanner.Split(scanProgressLines)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) != "" {
+			last = line
+		}
+
+		if _, rest, ok := strings.Cut(line, "Duration: "); ok && duration == 0 {
+			v, _, _ := strings.Cut(rest, ",")
+			duration, _ = parseClock(v)
+		}
+		if _, rest, ok := strings.Cut(line, "time="); ok && duration > 0 {
+			v, _, _ := strings.Cut(rest, " ")
+			if t, ok := parseClock(v); ok {
+				jobs.ReportProgress(ctx, float64(t)/float64(duration))
+			}
+		}
+	}
+	return last
+}
+
+// transcodeTask returns the task transcoding a file with preset p. Its
+// output is committed like an upload: it fails with ErrAlreadyExists if the
+// output exists, unless overwrite is set, and never replaces one that is
+// locked or held.
+func (h *Handler) transcodeTask(preset string, p *config.TranscodePreset, overwrite bool) jobs.TaskFunc {
+	return func(ctx context.Context, name string) error {
+		select {
+		case h.transcodeSem <- struct{}{}:
+			defer func() { <-h.transcodeSem }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		src := h.filePath(name)
+		if _, err := os.Stat(src); err != nil {
+			return err
+		}
+		src, cleanup, err := h.plainPath(ctx, src)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+
+		out := transcodeOutput(name, preset, p)
+		dst := filepath.Join(h.rootFor(name), filepath.FromSlash(out))
+		tmp := filepath.Join(filepath.Dir(dst), ".transcode-"+filepath.Base(dst))
+		defer os.Remove(tmp)
+
+		cmd := exec.CommandContext(ctx, h.ffmpegPath(), h.ffmpegArgs(src, tmp, p)...)
+		stderr, err := cmd.StderrPipe()
+		if err != nil {
+			return err
+		}
+		if err = cmd.Start(); err != nil {
+			return err
+		}
+		if nice := h.config.Transcode.Nice; nice != 0 {
+			if err = setNice(cmd.Process.Pid, nice); err != nil {
+				log.Printf("Error setting niceness for ffmpeg: %s\n", err)
+			}
+		}
+
+		last := trackFFmpeg(ctx, stderr, 0)
+		if err = cmd.Wait(); err != nil {
+			return fmt.Errorf("ffmpeg: %w: %s", err, last)
+		}
+
+		info, err := os.Stat(tmp)
+		if err != nil {
+			return err
+		}
+		checksum, err := fileChecksum(ctx, tmp)
+		if err != nil {
+			return err
+		}
+
+		unlock := h.fileMu.Lock(out)
+		defer unlock()
+
+		now := time.Now().UTC()
+		stored := &meta.File{
+			Name:         out,
+			OriginalName: out,
+			Size:         info.Size(),
+			Checksum:     checksum,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}
+		rep, err := h.replaceOutput(out, dst, overwrite)
+		if err != nil {
+			return err
+		}
+		defer h.intend(meta.OpPut, out, stored)()
+		if rep == nil {
+			err = h.commitFile(tmp, dst, false)
+		} else if err = h.placeFile(tmp, dst, false); err != nil {
+			rep.discard()
+		}
+		if err != nil {
+			return err
+		}
+
+		typ := webhook.FileCreated
+		data := map[string]any{"source": name, "preset": preset}
+		if rep != nil {
+			h.keep(rep, strconv.Quote(checksum))
+			stored.ID = h.overwriteID(out)
+			if rep.meta != nil {
+				stored.CreatedAt = rep.meta.CreatedAt
+			}
+			typ, data["previous_etag"] = webhook.FileUpdated, rep.etag
+		}
+		if err = h.meta.Put(stored); err != nil {
+			return err
+		}
+		h.invalidateFile(out)
+
+		h.emit(typ, out, nil, data)
+		return nil
+	}
+}
+
+// replaceOutput checks that a transcode may be committed as out, stored at
+// dst, and sets aside what it replaces. The caller holds out's file lock. It
+// returns nil if out does not exist.
+func (h *Handler) replaceOutput(out, dst string, overwrite bool) (*replacement, error) {
+	info, err := h.statStored(dst)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := h.meta.Get(out)
+	if err != nil {
+		m = nil
+	}
+	switch {
+	case m != nil && m.Lock.Active():
+		return nil, ErrLocked
+	case m != nil && m.Hold.Active():
+		return nil, ErrHeld
+	case !overwrite:
+		return nil, ErrAlreadyExists
+	}
+	return h.setAside(out, dst, m, info)
+}
+
+func (h *Handler) transcode(w http.ResponseWriter, r *http.Request) {
+	if h.transcodeSem == nil {
+		writeError(w, ErrTranscodeDisabled)
+		return
+	}
+
+	name, err := h.pathName(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	req := &transcodeRequest{}
+	if !h.decodeJSON(w, r, req) {
+		return
+	}
+	p, ok := h.config.Transcode.Presets[req.Preset]
+	if !ok {
+		errorResponse(w, http.StatusBadRequest, ErrPresetNotFound)
+		return
+	}
+	task := TaskTranscode
+	switch req.Conflict {
+	case "", ConflictFail:
+	case ConflictOverwrite:
+		task = TaskTranscodeOverwrite
+	default:
+		writeError(w, ErrInvalidConflictPolicy)
+		return
+	}
+
+	if info, err := os.Stat(h.filePath(name)); err != nil || info.IsDir() {
+		writeError(w, ErrNotFound)
+		return
+	}
+
+	// Checked again when the output is committed; this only spares a
+	// transcode that could not be.
+	out := transcodeOutput(name, req.Preset, p)
+	if existing, ok := h.resolveName(out); ok {
+		if task == TaskTranscode {
+			writeError(w, existsError(out, existing))
+			return
+		}
+		if m, err := h.meta.Get(existing); err == nil && m.Lock.Active() {
+			lockedResponse(w, m.Lock)
+			return
+		}
+		if !h.checkHold(w, existing) {
+			return
+		}
+	}
+
+	job, err := h.jobs.Enqueue(name, []string{task + req.Preset})
+	if errors.Is(err, jobs.ErrQueueFull) {
+		w.Header().Set("Retry-After", "5")
+		writeError(w, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Error enqueueing transcode for %s: %s\n", name, err)
+		writeError(w, ErrInternal)
+		return
+	}
+	utils.JSONResponse(w, http.StatusAccepted, job)
+}
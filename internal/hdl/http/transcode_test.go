@@ -0,0 +1,289 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/jobs"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/webhook"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+const fakeFFmpeg = `#!/bin/sh
+for last; do :; done
+echo "$@" > "$ARGS_FILE"
+case "$5" in
+*broken*)
+	echo "$5: Invalid data found when processing input" >&2
+	exit 1
+	;;
+esac
+echo "  Duration: 00:00:10.00, start: 0.000000, bitrate: 1000 kb/s" >&2
+printf "frame=  1 fps=0.0 q=0.0 size=0kB time=00:00:05.00 bitrate=0.0kbits/s\r" >&2
+sleep 1
+printf "frame=  2 fps=0.0 q=0.0 size=0kB time=00:00:10.00 bitrate=0.0kbits/s\r" >&2
+cp "$5" "$last"
+`
+
+func TestTranscode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+
+	testDir := t.TempDir()
+
+	bin := t.TempDir()
+	ffmpeg := filepath.Join(bin, "ffmpeg")
+	argsFile := filepath.Join(bin, "args")
+	assert.Nil(t, os.WriteFile(ffmpeg, []byte(fakeFFmpeg), 0755))
+	t.Setenv("ARGS_FILE", argsFile)
+
+	var mu sync.Mutex
+	processed := make(map[string]string)
+	created := make(map[string]any)
+	hook := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				e := webhook.Event{}
+				assert.Nil(t, json.Unmarshal(body, &e))
+
+				mu.Lock()
+				defer mu.Unlock()
+				switch e.Type {
+				case webhook.FileProcessed:
+					processed[e.Name] = e.Data.(map[string]any)["state"].(string)
+				case webhook.FileCreated:
+					created[e.Name] = e.Data
+				}
+			},
+		),
+	)
+	defer hook.Close()
+
+	cfg := &config.HTTPConfig{
+		Webhooks: []*config.WebhookConfig{{URL: hook.URL}},
+		Jobs:     &config.JobsConfig{Workers: 2},
+		Transcode: &config.TranscodeConfig{
+			FFmpeg:      ffmpeg,
+			Concurrency: 2,
+			Nice:        5,
+			CPUBudget:   8,
+			Presets: map[string]*config.TranscodePreset{
+				"720p": {VideoCodec: "libx264", AudioCodec: "aac", Height: 720, Container: "mp4"},
+			},
+		},
+	}
+	hdl := New(port, testDir, cfg)
+	assert.Nil(t, os.WriteFile(filepath.Join(testDir, "clip.webm"), []byte("video"), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(testDir, "broken.avi"), []byte("junk"), 0644))
+
+	transcode := func(h *Handler, name, preset string, conflict ...string) (*httptest.ResponseRecorder, *jobs.Job) {
+		req := transcodeRequest{Preset: preset}
+		if len(conflict) > 0 {
+			req.Conflict = conflict[0]
+		}
+		body, _ := json.Marshal(req)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/files/"+name+"/transcode", bytes.NewReader(body)))
+
+		job := &jobs.Job{}
+		if rec.Code == http.StatusAccepted {
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(job))
+		}
+		return rec, job
+	}
+	getJob := func(h *Handler, id string) *jobs.Job {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+id, nil))
+		job := &jobs.Job{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(job))
+		return job
+	}
+
+	t.Run(
+		"Invalid requests", func(t *testing.T) {
+			rec, _ := transcode(hdl, "clip.webm", "4k")
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+			rec, _ = transcode(hdl, "missing.webm", "720p")
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+
+			rec = httptest.NewRecorder()
+			setupTestHandler(testDir).ServeHTTP(
+				rec, httptest.NewRequest(http.MethodPost, "/files/clip.webm/transcode", bytes.NewReader([]byte(`{"preset":"720p"}`))),
+			)
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Transcode with progress", func(t *testing.T) {
+			rec, job := transcode(hdl, "clip.webm", "720p")
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			assert.Equal(t, []string{TaskTranscode + "720p"}, job.Tasks)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go hdl.jobs.Run(ctx)
+
+			assert.Eventually(
+				t, func() bool {
+					j := getJob(hdl, job.ID)
+					return j.State == jobs.StateRunning && j.Progress == 0.5
+				}, 2*time.Second, 10*time.Millisecond,
+			)
+			assert.Eventually(
+				t, func() bool { return getJob(hdl, job.ID).State == jobs.StateDone }, 3*time.Second, 10*time.Millisecond,
+			)
+			assert.Equal(t, 1.0, getJob(hdl, job.ID).Progress)
+
+			data, err := os.ReadFile(filepath.Join(testDir, "clip.720p.mp4"))
+			assert.Nil(t, err)
+			assert.Equal(t, "video", string(data))
+			assert.NoFileExists(t, filepath.Join(testDir, ".transcode-clip.720p.mp4"))
+
+			m, err := hdl.meta.Get("clip.720p.mp4")
+			assert.Nil(t, err)
+			assert.Equal(t, int64(5), m.Size)
+			assert.NotEmpty(t, m.Checksum)
+
+			args, err := os.ReadFile(argsFile)
+			assert.Nil(t, err)
+			assert.Contains(t, string(args), "-threads 4")
+			assert.Contains(t, string(args), "-c:v libx264")
+			assert.Contains(t, string(args), "scale=-2:720")
+
+			rec, job = transcode(hdl, "broken.avi", "720p")
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			assert.Eventually(
+				t, func() bool { return getJob(hdl, job.ID).State == jobs.StateFailed }, 2*time.Second, 10*time.Millisecond,
+			)
+			assert.Contains(t, getJob(hdl, job.ID).Error, "Invalid data found")
+			assert.NoFileExists(t, filepath.Join(testDir, "broken.720p.avi"))
+		},
+	)
+
+	t.Run(
+		"Webhooks", func(t *testing.T) {
+			assert.Eventually(
+				t, func() bool {
+					mu.Lock()
+					defer mu.Unlock()
+					return processed["clip.webm"] == jobs.StateDone && processed["broken.avi"] == jobs.StateFailed
+				}, 2*time.Second, 10*time.Millisecond,
+			)
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, map[string]any{"source": "clip.webm", "preset": "720p"}, created["clip.720p.mp4"])
+		},
+	)
+
+	t.Run(
+		"Existing output", func(t *testing.T) {
+			out := filepath.Join(testDir, "clip.720p.mp4")
+			rec, _ := transcode(hdl, "clip.webm", "720p")
+			assert.Equal(t, http.StatusConflict, rec.Code)
+			assert.Contains(t, rec.Body.String(), `"file_exists"`)
+
+			rec, _ = transcode(hdl, "clip.webm", "720p", "rename")
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+			m, err := hdl.meta.Get("clip.720p.mp4")
+			assert.Nil(t, err)
+			createdAt := m.CreatedAt
+			m.Lock = &meta.Lock{Token: "token", Holder: "editor", ExpiresAt: time.Now().Add(time.Hour)}
+			assert.Nil(t, hdl.meta.Put(m))
+			rec, _ = transcode(hdl, "clip.webm", "720p", ConflictOverwrite)
+			assert.Equal(t, http.StatusLocked, rec.Code)
+			assert.Contains(t, rec.Body.String(), `"locked"`)
+
+			m.Lock, m.Hold = nil, &meta.Hold{Reason: "audit", PlacedAt: time.Now()}
+			assert.Nil(t, hdl.meta.Put(m))
+			rec, _ = transcode(hdl, "clip.webm", "720p", ConflictOverwrite)
+			assert.Equal(t, http.StatusLocked, rec.Code)
+			assert.Contains(t, rec.Body.String(), `"held"`)
+			m.Hold = nil
+
+			// Locked after the transcode was accepted, the output is still
+			// not replaced.
+			assert.Nil(t, hdl.meta.Put(m))
+			rec, locked := transcode(hdl, "clip.webm", "720p", ConflictOverwrite)
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			m.Lock = &meta.Lock{Token: "token", Holder: "editor", ExpiresAt: time.Now().Add(time.Hour)}
+			assert.Nil(t, hdl.meta.Put(m))
+			assert.Nil(t, os.WriteFile(out, []byte("edited"), 0644))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go hdl.jobs.Run(ctx)
+			assert.Eventually(
+				t, func() bool { return getJob(hdl, locked.ID).State == jobs.StateFailed }, 3*time.Second, 10*time.Millisecond,
+			)
+			assert.Contains(t, getJob(hdl, locked.ID).Error, ErrLocked.Error())
+			data, _ := os.ReadFile(out)
+			assert.Equal(t, "edited", string(data))
+
+			m.Lock = nil
+			assert.Nil(t, hdl.meta.Put(m))
+			rec, job := transcode(hdl, "clip.webm", "720p", ConflictOverwrite)
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			assert.Eventually(
+				t, func() bool { return getJob(hdl, job.ID).State == jobs.StateDone }, 3*time.Second, 10*time.Millisecond,
+			)
+			data, _ = os.ReadFile(out)
+			assert.Equal(t, "video", string(data))
+			m, err = hdl.meta.Get("clip.720p.mp4")
+			assert.Nil(t, err)
+			assert.True(t, createdAt.Equal(m.CreatedAt))
+		},
+	)
+
+	t.Run(
+		"Resumed after restart", func(t *testing.T) {
+			assert.Nil(t, os.Remove(filepath.Join(testDir, "clip.720p.mp4")))
+			rec, job := transcode(hdl, "clip.webm", "720p")
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+
+			restarted := New(port, testDir, cfg)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go restarted.jobs.Run(ctx)
+
+			assert.Eventually(
+				t, func() bool { return getJob(restarted, job.ID).State == jobs.StateDone }, 3*time.Second, 10*time.Millisecond,
+			)
+			assert.FileExists(t, filepath.Join(testDir, "clip.720p.mp4"))
+		},
+	)
+
+	t.Run(
+		"Invalid config", func(t *testing.T) {
+			assert.Panics(t, func() { New(port, testDir, &config.HTTPConfig{Transcode: cfg.Transcode}) })
+			assert.Panics(
+				t, func() {
+					New(
+						port, testDir, &config.HTTPConfig{
+							Jobs: cfg.Jobs,
+							Transcode: &config.TranscodeConfig{
+								Presets: map[string]*config.TranscodePreset{"../x": {Container: "mp4"}},
+							},
+						},
+					)
+				},
+			)
+		},
+	)
+}
@@ -0,0 +1,183 @@
+package http
+
+import (
+	"context"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/trash"
+	"github.com/JMURv/media-server/internal/webhook"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	trashDir                  = ".trash"
+	defaultTrashWatermark     = 0.9
+	defaultTrashPurgeInterval = time.Minute
+)
+
+type TrashStats struct {
+	Entries int    `json:"entries"`
+	Bytes   int64  `json:"bytes"`
+	MaxSize int64  `json:"max_size,omitempty"`
+	Purged  uint64 `json:"purged"`
+}
+
+func (h *Handler) initTrash() error {
+	var err error
+	if h.trash, err = trash.Open(filepath.Join(h.savePath, trashDir)); err != nil {
+		return err
+	}
+	h.trash.Move = renameAcross
+
+	h.trashPurged = h.metrics.Counter("trash_purged_total", "Trash entries purged by age or quota.")
+	h.metrics.GaugeFunc(
+		"trash_bytes", "Bytes held by deleted files in the trash.", func() float64 {
+			_, bytes := h.trash.Usage()
+			return float64(bytes)
+		},
+	)
+	return nil
+}
+
+// trashLow is the size the trash is purged down to once it exceeds MaxSize.
+func (h *Handler) trashLow() int64 {
+	watermark := h.config.Trash.Watermark
+	if watermark <= 0 || watermark > 1 {
+		watermark = defaultTrashWatermark
+	}
+	return int64(float64(h.config.Trash.MaxSize) * watermark)
+}
+
+// discard removes the file at path for a delete of name. With the trash
+// enabled the file is moved there instead, purging the oldest entries first
+// when it would not fit. Deletes never fail on a full trash: a file larger
+// than the quota, or one the trash cannot take, is removed outright. A file
+// transitioned to a backend is removed there; the trash holds local files only.
+func (h *Handler) discard(path, name string, m *meta.File) (bool, error) {
+	if m != nil && m.Backend != "" {
+		if _, err := os.Stat(path); os.IsNotExist(err) && h.backends[m.Backend] != nil {
+			return false, h.backends[m.Backend].Remove(h.ctx, name)
+		}
+	}
+	if h.trash == nil {
+		return h.removeFile(path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if limit := h.config.Trash.MaxSize; limit > 0 {
+		if info.Size() > limit {
+			log.Printf("File %s exceeds the trash quota, deleting it permanently\n", name)
+			return h.removeFile(path)
+		}
+		h.purgeTrash(time.Time{}, limit-info.Size(), max(h.trashLow()-info.Size(), 0))
+	}
+
+	if _, err = h.trash.Put(name, path, m); err != nil {
+		log.Printf("Error moving %s to the trash, deleting it permanently: %s\n", name, err)
+		return h.removeFile(path)
+	}
+	return false, nil
+}
+
+func (h *Handler) purgeTrash(cutoff time.Time, high, low int64) {
+	purged, err := h.trash.Purge(cutoff, high, low)
+	if err != nil {
+		log.Printf("Error purging trash: %s\n", err)
+	}
+
+	for _, p := range purged {
+		h.trashPurged.Inc()
+		h.emit(
+			webhook.TrashPurged, p.Name, nil, map[string]any{
+				"id":         p.ID,
+				"size":       p.Size,
+				"deleted_at": p.DeletedAt,
+				"reason":     p.Reason,
+			},
+		)
+	}
+	if len(purged) > 0 {
+		log.Printf("Purged %d trash entries\n", len(purged))
+	}
+}
+
+// sweepTrash purges the entries older than MaxAge and, when the trash is
+// over its quota, the oldest entries until it is back under the watermark.
+func (h *Handler) sweepTrash(now time.Time) {
+	cfg := h.config.Trash
+
+	var cutoff time.Time
+	if cfg.MaxAge > 0 {
+		cutoff = now.Add(-cfg.MaxAge)
+	}
+	high := int64(-1)
+	if cfg.MaxSize > 0 {
+		high = cfg.MaxSize
+	}
+	h.purgeTrash(cutoff, high, h.trashLow())
+}
+
+func (h *Handler) runTrashJanitor(ctx context.Context) {
+	interval := h.config.Trash.PurgeInterval
+	if interval <= 0 {
+		interval = defaultTrashPurgeInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.sweepTrash(now)
+		}
+	}
+}
+
+func (h *Handler) trashStats() *TrashStats {
+	entries, bytes := h.trash.Usage()
+	return &TrashStats{
+		Entries: entries,
+		Bytes:   bytes,
+		MaxSize: h.config.Trash.MaxSize,
+		Purged:  h.trashPurged.Value(),
+	}
+}
+
+// listTrash pages through the trash, most recently deleted first.
+func (h *Handler) listTrash(w http.ResponseWriter, r *http.Request) {
+	if h.trash == nil {
+		writeError(w, ErrTrashDisabled)
+		return
+	}
+	if !h.canRead(r) {
+		writeError(w, ErrReadRequired)
+		return
+	}
+
+	page, size := utils.ParsePaginationParams(r, h.config.DefaultPage, h.config.DefaultSize)
+	entries := h.trash.List()
+	count := len(entries)
+	start := min((page-1)*size, count)
+	end := min(start+size, count)
+
+	totalPages := (count + size - 1) / size
+	utils.SuccessPaginatedResponse(
+		w, http.StatusOK, utils.PaginatedResponse{
+			Data:        entries[start:end],
+			Count:       count,
+			TotalPages:  totalPages,
+			CurrentPage: page,
+			HasNextPage: page < totalPages,
+		},
+	)
+}
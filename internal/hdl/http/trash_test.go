@@ -0,0 +1,187 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/trash"
+	"github.com/JMURv/media-server/internal/webhook"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	testDir := t.TempDir()
+
+	var mu sync.Mutex
+	purged := make(map[string]string)
+	hook := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				e := webhook.Event{}
+				assert.Nil(t, json.Unmarshal(body, &e))
+				if e.Type == webhook.TrashPurged {
+					mu.Lock()
+					purged[e.Name] = e.Data.(map[string]any)["reason"].(string)
+					mu.Unlock()
+				}
+			},
+		),
+	)
+	defer hook.Close()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize: 1024,
+			DefaultPage:   1,
+			DefaultSize:   10,
+			Webhooks:      []*config.WebhookConfig{{URL: hook.URL}},
+			Trash: &config.TrashConfig{
+				MaxSize:   100,
+				MaxAge:    time.Hour,
+				Watermark: 0.9,
+			},
+		},
+	)
+
+	del := func(name string, size int) {
+		assert.Nil(t, os.WriteFile(filepath.Join(testDir, name), []byte(strings.Repeat("x", size)), 0644))
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/files/"+name, nil))
+		assert.Equal(t, http.StatusNoContent, rec.Code, name)
+		_, err := os.Stat(filepath.Join(testDir, name))
+		assert.True(t, os.IsNotExist(err), name)
+	}
+	list := func() []trash.Entry {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/trash", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var res struct {
+			Data  []trash.Entry `json:"data"`
+			Count int           `json:"count"`
+		}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		assert.Len(t, res.Data, res.Count)
+		return res.Data
+	}
+	names := func() []string {
+		var res []string
+		for _, e := range list() {
+			res = append(res, e.Name)
+		}
+		return res
+	}
+	purgeReason := func(name string) string {
+		var reason string
+		assert.Eventually(
+			t, func() bool {
+				mu.Lock()
+				defer mu.Unlock()
+				reason = purged[name]
+				return reason != ""
+			}, time.Second, 10*time.Millisecond,
+		)
+		return reason
+	}
+
+	t.Run(
+		"Deletes move files to the trash", func(t *testing.T) {
+			del("first.txt", 40)
+			del("second.txt", 40)
+
+			entries := list()
+			assert.Equal(t, []string{"second.txt", "first.txt"}, names())
+			assert.Equal(t, int64(40), entries[0].Size)
+			assert.FileExists(t, filepath.Join(testDir, trashDir, entries[0].ID))
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/list", nil))
+			assert.NotContains(t, rec.Body.String(), "first.txt")
+		},
+	)
+
+	t.Run(
+		"A full trash purges the oldest entries instead of failing", func(t *testing.T) {
+			del("third.txt", 40)
+			assert.Equal(t, []string{"third.txt", "second.txt"}, names())
+			assert.Equal(t, trash.ReasonQuota, purgeReason("first.txt"))
+		},
+	)
+
+	t.Run(
+		"Files larger than the quota are deleted permanently", func(t *testing.T) {
+			del("huge.txt", 200)
+			assert.Equal(t, []string{"third.txt", "second.txt"}, names())
+		},
+	)
+
+	t.Run(
+		"Exposed in stats", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+
+			res := Stats{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.Equal(t, &TrashStats{Entries: 2, Bytes: 80, MaxSize: 100, Purged: 1}, res.Trash)
+		},
+	)
+
+	t.Run(
+		"Janitor purges expired entries", func(t *testing.T) {
+			hdl.sweepTrash(time.Now())
+			assert.Len(t, list(), 2)
+
+			hdl.sweepTrash(time.Now().Add(2 * time.Hour))
+			assert.Empty(t, list())
+			assert.Equal(t, trash.ReasonExpired, purgeReason("second.txt"))
+			assert.Equal(t, trash.ReasonExpired, purgeReason("third.txt"))
+
+			files, err := os.ReadDir(filepath.Join(testDir, trashDir))
+			assert.Nil(t, err)
+			assert.Empty(t, files)
+		},
+	)
+
+	t.Run(
+		"Janitor purges down to the watermark", func(t *testing.T) {
+			del("a.txt", 30)
+			del("b.txt", 30)
+			del("c.txt", 30)
+
+			hdl.config.Trash.MaxSize = 80
+			defer func() { hdl.config.Trash.MaxSize = 100 }()
+			hdl.sweepTrash(time.Now())
+			assert.Equal(t, []string{"c.txt", "b.txt"}, names())
+			assert.Equal(t, trash.ReasonQuota, purgeReason("a.txt"))
+		},
+	)
+
+	t.Run(
+		"Reloaded on restart", func(t *testing.T) {
+			reopened, err := trash.Open(filepath.Join(testDir, trashDir))
+			assert.Nil(t, err)
+			entries, bytes := reopened.Usage()
+			assert.Equal(t, 2, entries)
+			assert.Equal(t, int64(60), bytes)
+			assert.Equal(t, "c.txt", reopened.List()[0].Name)
+		},
+	)
+
+	t.Run(
+		"Disabled", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			setupTestHandler(testDir).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/trash", nil))
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+			assert.Contains(t, rec.Body.String(), "trash_disabled")
+		},
+	)
+}
@@ -0,0 +1,81 @@
+package http
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// errWritingTemp marks failures writing an upload's temp file, as opposed
+// to reading it from the client.
+var errWritingTemp = errors.New("error writing temp file")
+
+// uploadFile is a temp file an upload is spooled to.
+type uploadFile interface {
+	io.Writer
+	Name() string
+	Close() error
+}
+
+// uploadFS is the filesystem uploads are spooled to and put in place
+// through. Tests swap it for one failing at a given step.
+type uploadFS interface {
+	CreateTemp(dir, pattern string) (uploadFile, error)
+	Link(oldname, newname string) error
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	SyncDir(path string) error
+}
+
+type osUploadFS struct{}
+
+func (osUploadFS) CreateTemp(dir, pattern string) (uploadFile, error) {
+	return os.CreateTemp(dir, pattern)
+}
+
+func (osUploadFS) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (osUploadFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osUploadFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osUploadFS) SyncDir(path string) error {
+	return syncDir(path)
+}
+
+// tempWriter keeps the error writing to w, so it can be told apart from
+// one reading what is copied to it.
+type tempWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (t *tempWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	if err != nil && t.err == nil {
+		t.err = err
+	}
+	return n, err
+}
+
+// linkUnsupported reports whether err means the filesystem cannot hard link.
+func linkUnsupported(err error) bool {
+	return errors.Is(err, errors.ErrUnsupported) || errors.Is(err, fs.ErrPermission)
+}
+
+// storageError is what a failure to store an upload is reported as: a full
+// disk as such, so clients know to retry later, and anything else as
+// internal.
+func storageError(err error) error {
+	if isDiskFull(err) {
+		return ErrDiskFull
+	}
+	return canceledOr(err, ErrInternal)
+}
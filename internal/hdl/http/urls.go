@@ -0,0 +1,109 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	forwardedHostHeader   = "X-Forwarded-Host"
+	forwardedPrefixHeader = "X-Forwarded-Prefix"
+	forwardedProtoHeader  = "X-Forwarded-Proto"
+)
+
+func parseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	res := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		if strings.Contains(v, "/") {
+			prefix, err := netip.ParsePrefix(v)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(v)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return res, nil
+}
+
+func cleanBasePath(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+func firstValue(v string) string {
+	v, _, _ = strings.Cut(v, ",")
+	return strings.TrimSpace(v)
+}
+
+func (h *Handler) trustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(h.trustedProxies, func(p netip.Prefix) bool { return p.Contains(addr.Unmap()) })
+}
+
+func (h *Handler) baseURL(r *http.Request) string {
+	if h.config.PublicBaseURL != "" {
+		return strings.TrimSuffix(h.config.PublicBaseURL, "/")
+	}
+
+	if r != nil && h.trustedProxy(r) {
+		prefix := cleanBasePath(firstValue(r.Header.Get(forwardedPrefixHeader)))
+		host := firstValue(r.Header.Get(forwardedHostHeader))
+		if host == "" {
+			if prefix != "" {
+				return prefix
+			}
+			return cleanBasePath(h.config.BasePath)
+		}
+
+		proto := firstValue(r.Header.Get(forwardedProtoHeader))
+		if proto != "https" && proto != "http" {
+			proto = "http"
+			if r.TLS != nil {
+				proto = "https"
+			}
+		}
+		return fmt.Sprintf("%s://%s%s", proto, host, prefix)
+	}
+	return cleanBasePath(h.config.BasePath)
+}
+
+func (h *Handler) fileURL(r *http.Request, name string) string {
+	p := (&url.URL{Path: "/" + filepath.ToSlash(filepath.Join(h.urlPath, name))}).EscapedPath()
+	return h.baseURL(r) + p
+}
+
+func (h *Handler) withBasePath(mux http.Handler) http.Handler {
+	base := cleanBasePath(h.config.BasePath)
+	if base == "" {
+		return mux
+	}
+
+	root := http.NewServeMux()
+	root.Handle(base+"/", http.StripPrefix(base, mux))
+	root.Handle("/", mux)
+	return root
+}
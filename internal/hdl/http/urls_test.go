@@ -0,0 +1,120 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBasePath(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize:   10 * 1024 * 1024,
+			MaxStreamBuffer: 1024,
+			DefaultPage:     1,
+			DefaultSize:     10,
+			BasePath:        "/media/",
+			TrustedProxies:  []string{"10.0.0.0/8"},
+		},
+	)
+	routes := hdl.routes()
+	// File URLs carry the save path as it was given, absolute here.
+	dir := "/" + filepath.ToSlash(testDir)
+
+	for _, prefix := range []string{"", "/media"} {
+		t.Run(
+			"Routes with prefix "+prefix, func(t *testing.T) {
+				name := "base" + strings.ReplaceAll(prefix, "/", "-") + ".mp4"
+
+				rec := httptest.NewRecorder()
+				req := newUploadRequest(name, "video", nil)
+				req.URL.Path = prefix + "/upload"
+				routes.ServeHTTP(rec, req)
+				assert.Equal(t, http.StatusCreated, rec.Code)
+				assert.Equal(t, "/media"+dir+"/"+name, decodeUpload(t, rec).URL)
+
+				rec = httptest.NewRecorder()
+				routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, prefix+"/stream/uploads/"+name, nil))
+				assert.Equal(t, http.StatusOK, rec.Code)
+				assert.Equal(t, "video", rec.Body.String())
+
+				rec = httptest.NewRecorder()
+				routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, prefix+"/uploads/"+name, nil))
+				assert.Equal(t, http.StatusOK, rec.Code)
+
+				rec = httptest.NewRecorder()
+				routes.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, prefix+"/files/"+name+"/lock", nil))
+				assert.Equal(t, http.StatusOK, rec.Code)
+
+				rec = httptest.NewRecorder()
+				routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, prefix+"/list", nil))
+				assert.Equal(t, http.StatusOK, rec.Code)
+				assert.Contains(t, rec.Body.String(), "/media"+dir+"/"+name)
+
+				rec = httptest.NewRecorder()
+				routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, prefix+"/stats", nil))
+				assert.Equal(t, http.StatusOK, rec.Code)
+			},
+		)
+	}
+
+	t.Run(
+		"Unknown prefix", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/other/list", nil))
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Forwarded headers from trusted proxy", func(t *testing.T) {
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, "fwd.txt"), []byte("data"), 0644))
+			defer os.Remove(filepath.Join(testDir, "fwd.txt"))
+
+			req := httptest.NewRequest(http.MethodGet, "/list?size=100", nil)
+			req.Header.Set(forwardedHostHeader, "gw.example.com")
+			req.Header.Set(forwardedPrefixHeader, "/gw/")
+			req.Header.Set(forwardedProtoHeader, "https")
+
+			rec := httptest.NewRecorder()
+			routes.ServeHTTP(rec, req)
+			assert.NotContains(t, rec.Body.String(), "gw.example.com")
+
+			req.RemoteAddr = "10.1.2.3:4567"
+			rec = httptest.NewRecorder()
+			routes.ServeHTTP(rec, req)
+			assert.Contains(t, rec.Body.String(), "https://gw.example.com/gw"+dir+"/fwd.txt")
+		},
+	)
+
+	t.Run(
+		"Public base URL", func(t *testing.T) {
+			hdl.config.PublicBaseURL = "https://cdn.example.com/media/"
+			defer func() { hdl.config.PublicBaseURL = "" }()
+
+			rec := httptest.NewRecorder()
+			routes.ServeHTTP(rec, newUploadRequest("public file.txt", "data", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			res := decodeUpload(t, rec)
+			assert.Equal(t, "https://cdn.example.com/media"+dir+"/public%20file.txt", res.URL)
+
+			rec = httptest.NewRecorder()
+			routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/list?size=100", nil))
+
+			list := struct {
+				Data []string `json:"data"`
+			}{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&list))
+			assert.Contains(t, list.Data, res.URL)
+		},
+	)
+}
@@ -0,0 +1,200 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"github.com/JMURv/media-server/internal/usage"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	usageDir                     = ".usage"
+	groupByPrefix                = "prefix"
+	maxUsageDepth                = 32
+	defaultUsageSnapshotInterval = 24 * time.Hour
+	defaultUsageRetention        = 400 * 24 * time.Hour
+	defaultUsageMaxGroups        = 1000
+)
+
+type UsageResponse struct {
+	GroupBy     string        `json:"group_by"`
+	Depth       int           `json:"depth"`
+	AsOf        *time.Time    `json:"as_of,omitempty"`
+	Total       usage.Usage   `json:"total"`
+	Groups      []usage.Group `json:"groups"`
+	Count       int           `json:"count"`
+	TotalPages  int           `json:"total_pages"`
+	CurrentPage int           `json:"current_page"`
+	HasNextPage bool          `json:"has_next_page"`
+}
+
+func (h *Handler) usageMaxGroups() int {
+	if h.config.Usage.MaxGroups > 0 {
+		return h.config.Usage.MaxGroups
+	}
+	return defaultUsageMaxGroups
+}
+
+// rebuildUsage replaces the usage index with a full walk of the storage
+// roots. Between walks the index is kept current by trackUsage; the walk
+// picks up changes made to the roots behind the server's back.
+func (h *Handler) rebuildUsage() {
+	files := make(map[string]int64)
+	_, err := h.walkRootEntries(
+		h.ctx, true, 0, func(root, path string, d fs.DirEntry) error {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return nil
+			}
+			rel = filepath.ToSlash(rel)
+			if h.hidden(rel) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() || d.Type()&fs.ModeSymlink != 0 {
+				return nil
+			}
+
+			if info, err := d.Info(); err == nil {
+				files[rel] = info.Size()
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		log.Printf("Error indexing usage: %s\n", err)
+		return
+	}
+	h.usage.Reset(files)
+}
+
+func (h *Handler) trackUsage(typ, name string) {
+	if h.usage == nil || name == "" || !strings.HasPrefix(typ, "file.") || h.hidden(name) {
+		return
+	}
+
+	info, err := os.Stat(h.filePath(name))
+	if err != nil || !info.Mode().IsRegular() {
+		h.usage.Remove(name)
+		return
+	}
+	h.usage.Set(name, info.Size())
+}
+
+// runUsageSnapshots snapshots usage on multiples of the interval since the
+// zero time, so a daily interval snapshots at UTC midnight and the snapshot
+// as of the 1st of a month holds the previous month's closing usage.
+func (h *Handler) runUsageSnapshots(ctx context.Context) {
+	interval := h.config.Usage.SnapshotInterval
+	if interval <= 0 {
+		interval = defaultUsageSnapshotInterval
+	}
+
+	for {
+		now := time.Now()
+		next := now.Truncate(interval).Add(interval)
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			h.snapshotUsage(next)
+		}
+	}
+}
+
+func (h *Handler) snapshotUsage(at time.Time) {
+	h.rebuildUsage()
+	snap := &usage.Snapshot{Time: at.UTC().Truncate(time.Second), Dirs: h.usage.Dirs()}
+	if err := h.usageSnapshots.Save(snap); err != nil {
+		log.Printf("Error saving usage snapshot: %s\n", err)
+		return
+	}
+
+	retention := h.config.Usage.Retention
+	if retention <= 0 {
+		retention = defaultUsageRetention
+	}
+	if n, err := h.usageSnapshots.Prune(at.Add(-retention)); err != nil {
+		log.Printf("Error pruning usage snapshots: %s\n", err)
+	} else if n > 0 {
+		log.Printf("Pruned %d usage snapshots\n", n)
+	}
+}
+
+func (h *Handler) usageReport(w http.ResponseWriter, r *http.Request) {
+	if h.usage == nil {
+		writeError(w, ErrUsageDisabled)
+		return
+	}
+
+	q := r.URL.Query()
+	if groupBy := q.Get("group_by"); groupBy != "" && groupBy != groupByPrefix {
+		writeError(w, ErrInvalidGroupBy)
+		return
+	}
+
+	depth := 1
+	if v := q.Get("depth"); v != "" {
+		var err error
+		if depth, err = strconv.Atoi(v); err != nil || depth < 0 || depth > maxUsageDepth {
+			writeError(w, ErrInvalidDepth)
+			return
+		}
+	}
+
+	res := UsageResponse{GroupBy: groupByPrefix, Depth: depth}
+	var dirs map[string]usage.Usage
+	if v := q.Get("as_of"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, ErrInvalidAsOf)
+			return
+		}
+
+		snap, err := h.usageSnapshots.At(t)
+		if errors.Is(err, usage.ErrNoSnapshot) {
+			writeError(w, err)
+			return
+		}
+		if err != nil {
+			log.Printf("Error reading usage snapshot: %s\n", err)
+			writeError(w, ErrInternal)
+			return
+		}
+		dirs, res.AsOf = snap.Dirs, &snap.Time
+	} else {
+		h.usageOnce.Do(h.rebuildUsage)
+		dirs = h.usage.Dirs()
+	}
+
+	groups := usage.Groups(dirs, depth)
+	for _, g := range groups {
+		res.Total.Files += g.Files
+		res.Total.Bytes += g.Bytes
+	}
+
+	maxGroups := h.usageMaxGroups()
+	page, size := utils.ParsePaginationParams(r, 1, maxGroups)
+	size = min(size, maxGroups)
+	start := min((page-1)*size, len(groups))
+	end := min(start+size, len(groups))
+
+	res.Groups = groups[start:end]
+	res.Count = len(groups)
+	res.TotalPages = (len(groups) + size - 1) / size
+	res.CurrentPage = page
+	res.HasNextPage = page < res.TotalPages
+	utils.JSONResponse(w, http.StatusOK, res)
+}
@@ -0,0 +1,149 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsage(t *testing.T) {
+	testDir := t.TempDir()
+
+	for name, size := range map[string]int{
+		"root.txt":           1,
+		"acme/a.txt":         10,
+		"acme/photos/b.jpg":  100,
+		"acme/photos/c.jpg":  1000,
+		"globex/docs/d.pdf":  5,
+		"globex/.hidden.txt": 7,
+	} {
+		path := filepath.Join(testDir, filepath.FromSlash(name))
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.Nil(t, os.WriteFile(path, []byte(strings.Repeat("x", size)), 0644))
+	}
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize: 1024 * 1024,
+			Usage:         &config.UsageConfig{Retention: 48 * time.Hour, MaxGroups: 2},
+		},
+	)
+	report := func(query string) (*httptest.ResponseRecorder, UsageResponse) {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats/usage"+query, nil))
+		res := UsageResponse{}
+		if rec.Code == http.StatusOK {
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		}
+		return rec, res
+	}
+	prefixes := func(res UsageResponse) map[string][2]int64 {
+		m := map[string][2]int64{}
+		for _, g := range res.Groups {
+			m[g.Prefix] = [2]int64{int64(g.Files), g.Bytes}
+		}
+		return m
+	}
+
+	t.Run(
+		"Grouped by prefix", func(t *testing.T) {
+			rec, res := report("?group_by=prefix&depth=1&size=10")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, 5, res.Total.Files)
+			assert.Equal(t, int64(1116), res.Total.Bytes)
+			assert.Nil(t, res.AsOf)
+
+			// Pages are capped at MaxGroups.
+			assert.Equal(t, 3, res.Count)
+			assert.Equal(t, 2, res.TotalPages)
+			assert.True(t, res.HasNextPage)
+			assert.Equal(t, map[string][2]int64{"": {1, 1}, "acme": {3, 1110}}, prefixes(res))
+
+			_, res = report("?depth=1&page=2")
+			assert.Equal(t, map[string][2]int64{"globex": {1, 5}}, prefixes(res))
+			assert.False(t, res.HasNextPage)
+
+			_, res = report("?depth=2&page=2")
+			assert.Equal(t, map[string][2]int64{"acme/photos": {2, 1100}, "globex/docs": {1, 5}}, prefixes(res))
+
+			_, res = report("?depth=0")
+			assert.Equal(t, map[string][2]int64{"": {5, 1116}}, prefixes(res))
+		},
+	)
+
+	t.Run(
+		"Kept current by uploads and deletes", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("new.txt", "12345", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/files/root.txt", nil))
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+
+			_, res := report("?depth=0")
+			assert.Equal(t, map[string][2]int64{"": {5, 1120}}, prefixes(res))
+		},
+	)
+
+	t.Run(
+		"Snapshots", func(t *testing.T) {
+			rec, _ := report("?as_of=2026-10-01T00:00:00Z")
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+
+			monthEnd := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+			hdl.snapshotUsage(monthEnd)
+			assert.Nil(t, os.WriteFile(filepath.Join(testDir, "acme", "late.txt"), []byte("late"), 0644))
+			hdl.snapshotUsage(monthEnd.Add(24 * time.Hour))
+
+			rec, res := report("?as_of=2026-10-01T12:00:00Z&depth=1&size=10")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, monthEnd, *res.AsOf)
+			assert.Equal(t, [2]int64{3, 1110}, prefixes(res)["acme"])
+
+			_, res = report("?as_of=2026-10-02T00:00:00Z&depth=1")
+			assert.Equal(t, [2]int64{4, 1114}, prefixes(res)["acme"])
+
+			// The live index picked up the file written behind its back.
+			_, res = report("?depth=1")
+			assert.Equal(t, [2]int64{4, 1114}, prefixes(res)["acme"])
+		},
+	)
+
+	t.Run(
+		"Snapshots are pruned", func(t *testing.T) {
+			hdl.snapshotUsage(time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC))
+
+			entries, err := os.ReadDir(filepath.Join(testDir, usageDir))
+			assert.Nil(t, err)
+			assert.Len(t, entries, 1)
+
+			rec, _ := report("?as_of=2026-10-02T00:00:00Z")
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Invalid parameters", func(t *testing.T) {
+			for _, q := range []string{"?group_by=tenant", "?depth=-1", "?depth=x", "?as_of=yesterday"} {
+				rec, _ := report(q)
+				assert.Equal(t, http.StatusBadRequest, rec.Code, q)
+			}
+		},
+	)
+
+	t.Run(
+		"Disabled", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			setupTestHandler(testDir).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats/usage", nil))
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		},
+	)
+}
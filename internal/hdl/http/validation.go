@@ -0,0 +1,195 @@
+package http
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/internal/metrics"
+	"github.com/JMURv/media-server/internal/outbound"
+	"github.com/JMURv/media-server/internal/webhook"
+	"github.com/JMURv/media-server/pkg/config"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	ValidationReject = "reject"
+	ValidationAccept = "accept"
+
+	maxValidationResponse = 64 << 10
+	maxValidationError    = 200
+)
+
+// ValidationRequest summarizes an upload for the validation service.
+type ValidationRequest struct {
+	Name         string `json:"name"`
+	OriginalName string `json:"original_name"`
+	Size         int64  `json:"size"`
+	ContentType  string `json:"content_type,omitempty"`
+	Checksum     string `json:"checksum"`
+	Principal    string `json:"principal"`
+	Tenant       string `json:"tenant,omitempty"`
+}
+
+// ValidationResponse is what the validation service may answer with: the
+// name to store an accepted upload under instead, or why it was rejected.
+type ValidationResponse struct {
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type uploadValidator struct {
+	cfg    *config.ValidationConfig
+	client *http.Client
+
+	accepted *metrics.Counter
+	rejected *metrics.Counter
+	failed   *metrics.Counter
+	timeouts *metrics.Counter
+	latency  *metrics.Histogram
+}
+
+func (h *Handler) newValidator(cfg *config.ValidationConfig) (*uploadValidator, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid url %q", cfg.URL)
+	}
+	switch cfg.OnFailure {
+	case "", ValidationReject, ValidationAccept:
+	default:
+		return nil, fmt.Errorf("onFailure %q is neither %s nor %s", cfg.OnFailure, ValidationReject, ValidationAccept)
+	}
+
+	return &uploadValidator{
+		cfg:      cfg,
+		client:   h.outbound.Client(outbound.Validation, cfg.Timeout),
+		accepted: h.metrics.Counter("validation_accepted_total", "Uploads the validation service accepted."),
+		rejected: h.metrics.Counter("validation_rejected_total", "Uploads the validation service rejected."),
+		failed:   h.metrics.Counter("validation_failed_total", "Validation calls that gave no verdict, timeouts included."),
+		timeouts: h.metrics.Counter("validation_timeouts_total", "Validation calls that timed out."),
+		latency: h.metrics.Histogram(
+			"validation_seconds", "Time spent waiting for the validation service.", metrics.DefaultBuckets,
+		),
+	}, nil
+}
+
+func (v *uploadValidator) call(ctx context.Context, req *ValidationRequest) (int, *ValidationResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	hr, err := http.NewRequestWithContext(ctx, http.MethodPost, v.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	hr.Header.Set("Content-Type", "application/json")
+	if v.cfg.Secret != "" {
+		hr.Header.Set(webhook.SignatureHeader, webhook.Sign(v.cfg.Secret, body))
+	}
+
+	start := time.Now()
+	resp, err := v.client.Do(hr)
+	if err != nil {
+		v.latency.Observe(time.Since(start).Seconds())
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxValidationResponse))
+	v.latency.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// Rejections may come as plain text.
+	res := &ValidationResponse{}
+	if json.Unmarshal(data, res) != nil && resp.StatusCode/100 == 4 {
+		res.Error = strings.TrimSpace(string(data))
+	}
+	if len(res.Error) > maxValidationError {
+		res.Error = res.Error[:maxValidationError]
+	}
+	return resp.StatusCode, res, nil
+}
+
+// validateUpload asks the validation service whether the upload spooled at
+// path may be stored as stored describes it, and returns the name to store
+// it under. When it may not, the response is written and ok is false.
+func (h *Handler) validateUpload(w http.ResponseWriter, r *http.Request, path string, stored *meta.File) (string, bool) {
+	v := h.validator
+	if v == nil {
+		return stored.Name, true
+	}
+
+	contentType := cmp.Or(stored.ContentType, stored.DetectedType, typeByExtension(stored.Name))
+	if contentType == "" {
+		if file, err := os.Open(path); err == nil {
+			contentType, _ = sniffType(file)
+			file.Close()
+		}
+	}
+
+	status, res, err := v.call(
+		r.Context(), &ValidationRequest{
+			Name:         stored.Name,
+			OriginalName: stored.OriginalName,
+			Size:         stored.Size,
+			ContentType:  contentType,
+			Checksum:     stored.Checksum,
+			Principal:    h.principal(r),
+			Tenant:       r.Header.Get(v.cfg.TenantHeader),
+		},
+	)
+	if err != nil && r.Context().Err() != nil {
+		writeError(w, r.Context().Err())
+		return "", false
+	}
+
+	switch {
+	case err == nil && status/100 == 2 && res.Name == "":
+		v.accepted.Inc()
+		return stored.Name, true
+	case err == nil && status/100 == 2:
+		name, err := h.cleanName(res.Name)
+		if err != nil {
+			v.failed.Inc()
+			log.Printf("Validation of %s renamed it to invalid %q\n", stored.Name, res.Name)
+			writeError(w, ErrValidationFailed)
+			return "", false
+		}
+		v.accepted.Inc()
+		return name, true
+	case err == nil && status/100 == 4:
+		v.rejected.Inc()
+		reason := cmp.Or(res.Error, http.StatusText(status))
+		log.Printf("Upload of %s rejected by validation: %s\n", stored.Name, reason)
+		errorResponse(w, status, classify(errors.New(reason), ErrUploadRejected))
+		return "", false
+	}
+
+	v.failed.Inc()
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		v.timeouts.Inc()
+	}
+	if err == nil {
+		err = fmt.Errorf("unexpected status %d", status)
+	}
+	log.Printf("Error validating upload of %s: %s\n", stored.Name, err)
+	if v.cfg.OnFailure == ValidationAccept {
+		return stored.Name, true
+	}
+	writeError(w, ErrValidationFailed)
+	return "", false
+}
@@ -0,0 +1,185 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/webhook"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestValidation(t *testing.T) {
+	testDir := t.TempDir()
+
+	var mu sync.Mutex
+	var got ValidationRequest
+	var signature string
+	srv := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				req := ValidationRequest{}
+				json.Unmarshal(body, &req)
+
+				sig := r.Header.Get(webhook.SignatureHeader)
+				mu.Lock()
+				got, signature = req, sig
+				mu.Unlock()
+				if sig != webhook.Sign("validate-secret", body) {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+
+				switch {
+				case strings.HasPrefix(req.Name, "virus"):
+					w.WriteHeader(http.StatusForbidden)
+					json.NewEncoder(w).Encode(ValidationResponse{Error: "malware detected"})
+				case strings.HasPrefix(req.Name, "plain"):
+					w.WriteHeader(http.StatusBadRequest)
+					io.WriteString(w, "  not allowed here\n")
+				case strings.HasPrefix(req.Name, "move"):
+					json.NewEncoder(w).Encode(ValidationResponse{Name: req.Tenant + "-" + req.Name})
+				case strings.HasPrefix(req.Name, "slow"):
+					time.Sleep(300 * time.Millisecond)
+				case strings.HasPrefix(req.Name, "broken"):
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			},
+		),
+	)
+	defer srv.Close()
+
+	newHandler := func(onFailure string) *Handler {
+		return New(
+			port, testDir, &config.HTTPConfig{
+				MaxUploadSize: 1024,
+				Validation: &config.ValidationConfig{
+					URL:          srv.URL,
+					Secret:       "validate-secret",
+					Timeout:      100 * time.Millisecond,
+					OnFailure:    onFailure,
+					TenantHeader: "X-Tenant",
+				},
+			},
+		)
+	}
+	hdl := newHandler("")
+
+	upload := func(hdl *Handler, name string) *httptest.ResponseRecorder {
+		req := newUploadRequest(name, "content", nil)
+		req.Header.Set("X-Tenant", "acme")
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	assertError := func(rec *httptest.ResponseRecorder, status int, code, message string) {
+		assert.Equal(t, status, rec.Code)
+		res := utils.ErrorResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		assert.Equal(t, code, res.Code)
+		if message != "" {
+			assert.Equal(t, message, res.Error)
+		}
+	}
+
+	t.Run(
+		"Accepted uploads are summarized", func(t *testing.T) {
+			rec := upload(hdl, "Clip.mp4")
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.FileExists(t, filepath.Join(testDir, "Clip.mp4"))
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, "Clip.mp4", got.Name)
+			assert.Equal(t, int64(len("content")), got.Size)
+			assert.Equal(t, "video/mp4", got.ContentType)
+			assert.Len(t, got.Checksum, 64)
+			assert.Equal(t, principalAnonymous, got.Principal)
+			assert.Equal(t, "acme", got.Tenant)
+			assert.NotEmpty(t, signature)
+		},
+	)
+
+	t.Run(
+		"Rejections surface the service's error", func(t *testing.T) {
+			assertError(upload(hdl, "virus.mp4"), http.StatusForbidden, "upload_rejected", "malware detected")
+			assert.NoFileExists(t, filepath.Join(testDir, "virus.mp4"))
+
+			assertError(upload(hdl, "plain.mp4"), http.StatusBadRequest, "upload_rejected", "not allowed here")
+			assert.NoFileExists(t, filepath.Join(testDir, "plain.mp4"))
+		},
+	)
+
+	t.Run(
+		"Rewritten names are honored", func(t *testing.T) {
+			rec := upload(hdl, "move.mp4")
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			res := decodeUpload(t, rec)
+			assert.Equal(t, "acme-move.mp4", res.Name)
+			assert.FileExists(t, filepath.Join(testDir, "acme-move.mp4"))
+			assert.NoFileExists(t, filepath.Join(testDir, "move.mp4"))
+
+			m, err := hdl.meta.Get("acme-move.mp4")
+			if assert.Nil(t, err) {
+				assert.Equal(t, "move.mp4", m.OriginalName)
+			}
+
+			assertError(upload(hdl, "move.mp4"), http.StatusConflict, "file_exists", "")
+		},
+	)
+
+	t.Run(
+		"Failures reject by default", func(t *testing.T) {
+			assertError(upload(hdl, "slow.mp4"), http.StatusBadGateway, "validation_failed", "")
+			assertError(upload(hdl, "broken.mp4"), http.StatusBadGateway, "validation_failed", "")
+			assert.NoFileExists(t, filepath.Join(testDir, "slow.mp4"))
+			assert.NoFileExists(t, filepath.Join(testDir, "broken.mp4"))
+		},
+	)
+
+	t.Run(
+		"Failures may accept", func(t *testing.T) {
+			lenient := newHandler(ValidationAccept)
+			assert.Equal(t, http.StatusCreated, upload(lenient, "slow.mp4").Code)
+			assert.FileExists(t, filepath.Join(testDir, "slow.mp4"))
+
+			// Verdicts still apply.
+			assertError(upload(lenient, "virus.mp4"), http.StatusForbidden, "upload_rejected", "malware detected")
+		},
+	)
+
+	t.Run(
+		"Metrics", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			body := rec.Body.String()
+			assert.Contains(t, body, "validation_accepted_total 3")
+			assert.Contains(t, body, "validation_rejected_total 2")
+			assert.Contains(t, body, "validation_failed_total 2")
+			assert.Contains(t, body, "validation_timeouts_total 1")
+			assert.Contains(t, body, "validation_seconds_count 7")
+		},
+	)
+
+	t.Run(
+		"Invalid config", func(t *testing.T) {
+			for _, cfg := range []*config.ValidationConfig{
+				{URL: "ftp://example.com"},
+				{URL: "/validate"},
+				{URL: srv.URL, OnFailure: "ignore"},
+			} {
+				assert.Panics(
+					t, func() { New(port, testDir, &config.HTTPConfig{Validation: cfg}) },
+				)
+			}
+		},
+	)
+}
@@ -0,0 +1,212 @@
+package http
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"github.com/JMURv/media-server/internal/storage"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	maxVerifyEntries  = 1000
+	verifyConcurrency = 4
+)
+
+const (
+	VerifyMatch   = "match"
+	VerifyDiffers = "differs"
+	VerifyMissing = "missing"
+	VerifyInvalid = "invalid"
+)
+
+type VerifyEntry struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type VerifyResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ChecksumResponse struct {
+	Name     string `json:"name"`
+	Algo     string `json:"algo"`
+	Checksum string `json:"checksum"`
+}
+
+var checksumAlgos = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"crc32c": func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+}
+
+func (h *Handler) verifyEntry(r *http.Request, e VerifyEntry) VerifyResult {
+	res := VerifyResult{Name: e.Name}
+	name, err := h.cleanName(e.Name)
+	if err != nil {
+		res.Status, res.Error = VerifyInvalid, err.Error()
+		return res
+	}
+	if h.hidden(name) && !h.isAdmin(r) {
+		res.Status = VerifyMissing
+		return res
+	}
+
+	path, err := h.checkPath(name)
+	if err != nil {
+		res.Status, res.Error = VerifyInvalid, err.Error()
+		return res
+	}
+	info, err := h.statStored(path)
+	if err != nil || info.IsDir() {
+		res.Status = VerifyMissing
+		return res
+	}
+
+	res.Size = info.Size()
+	if info.Size() != e.Size {
+		res.Status = VerifyDiffers
+		return res
+	}
+	if res.SHA256, err = h.cachedChecksum(r.Context(), name, info); err != nil {
+		log.Printf("Error computing checksum for %s: %s\n", name, err)
+		res.Status, res.Error = VerifyInvalid, ErrInternal.Error()
+		return res
+	}
+
+	res.Status = VerifyDiffers
+	if strings.EqualFold(res.SHA256, e.SHA256) {
+		res.Status = VerifyMatch
+	}
+	return res
+}
+
+// verify reports for each entry whether the server holds an identical file,
+// so sync clients can skip redundant uploads. Sizes are compared first and
+// checksums are only computed, and cached, when they match.
+func (h *Handler) verify(w http.ResponseWriter, r *http.Request) {
+	var entries []VerifyEntry
+	if !h.decodeJSON(w, r, &entries) {
+		return
+	}
+	if len(entries) > maxVerifyEntries {
+		writeError(w, fmt.Errorf("%w: at most %d entries", ErrTooManyEntries, maxVerifyEntries))
+		return
+	}
+
+	res := make([]VerifyResult, len(entries))
+	sem := make(chan struct{}, verifyConcurrency)
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			res[i] = h.verifyEntry(r, e)
+		}()
+	}
+	wg.Wait()
+
+	utils.JSONResponse(w, http.StatusOK, res)
+}
+
+func (h *Handler) checksum(w http.ResponseWriter, r *http.Request) {
+	name, err := h.pathName(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !h.checkInternal(w, r, name) {
+		return
+	}
+
+	algo := r.URL.Query().Get("algo")
+	if algo == "" {
+		algo = "sha256"
+	}
+	newHash, ok := checksumAlgos[algo]
+	if !ok && algo != "sha256" {
+		writeError(w, ErrInvalidAlgo)
+		return
+	}
+
+	path, err := h.checkPath(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// A file transitioned to a backend is no longer on disk.
+	info, err := h.statStored(path)
+	m := h.transitioned(name)
+	if (err != nil && m == nil) || (err == nil && info.IsDir()) {
+		writeError(w, ErrRetrievingFile)
+		return
+	}
+
+	var checksum string
+	switch {
+	case err != nil && !ok:
+		checksum, err = m.Checksum, nil
+	case err != nil:
+		checksum, err = h.hashTransitioned(r.Context(), name, newHash())
+	case ok:
+		checksum, err = h.hashStored(r.Context(), path, newHash())
+	default:
+		checksum, err = h.cachedChecksum(r.Context(), name, info)
+	}
+	if err != nil {
+		log.Printf("Error computing %s checksum for %s: %s\n", algo, name, err)
+		writeError(w, canceledOr(err, ErrInternal))
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, ChecksumResponse{Name: name, Algo: algo, Checksum: checksum})
+}
+
+// hashTransitioned digests name as stored on the backend it was moved to.
+func (h *Handler) hashTransitioned(ctx context.Context, name string, hash hash.Hash) (string, error) {
+	file, err := h.openFile(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err = io.Copy(hash, storage.Reader(ctx, file)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hashFile digests the file at path, checking ctx between chunks.
+func hashFile(ctx context.Context, path string, hash hash.Hash) (string, error) {
+	if err := storage.Canceled(ctx); err != nil {
+		return "", err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err = io.Copy(hash, storage.Reader(ctx, file)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
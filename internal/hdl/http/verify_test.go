@@ -0,0 +1,103 @@
+package http
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := setupTestHandler(testDir)
+	content := []byte("same bytes")
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+	assert.Nil(t, os.WriteFile(filepath.Join(testDir, "synced.txt"), content, 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(testDir, "changed.txt"), []byte("diff bytes"), 0644))
+
+	verify := func(entries []VerifyEntry) (int, []VerifyResult) {
+		body, _ := json.Marshal(entries)
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/verify", bytes.NewReader(body)))
+
+		var res []VerifyResult
+		if rec.Code == http.StatusOK {
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		}
+		return rec.Code, res
+	}
+
+	t.Run(
+		"Statuses per entry", func(t *testing.T) {
+			size := int64(len(content))
+			code, res := verify(
+				[]VerifyEntry{
+					{Name: "synced.txt", Size: size, SHA256: digest},
+					{Name: "changed.txt", Size: size, SHA256: digest},
+					{Name: "synced.txt", Size: size + 1, SHA256: digest},
+					{Name: "absent.txt", Size: size, SHA256: digest},
+					{Name: "", Size: size, SHA256: digest},
+				},
+			)
+			assert.Equal(t, http.StatusOK, code)
+
+			var statuses []string
+			for _, r := range res {
+				statuses = append(statuses, r.Status)
+			}
+			assert.Equal(t, []string{VerifyMatch, VerifyDiffers, VerifyDiffers, VerifyMissing, VerifyInvalid}, statuses)
+
+			m, err := hdl.meta.Get("changed.txt")
+			assert.Nil(t, err)
+			assert.Equal(t, res[1].SHA256, m.Checksum)
+		},
+	)
+
+	t.Run(
+		"Entry count is capped", func(t *testing.T) {
+			code, _ := verify(make([]VerifyEntry, maxVerifyEntries+1))
+			assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+		},
+	)
+
+	t.Run(
+		"Single file checksums", func(t *testing.T) {
+			md := md5.Sum(content)
+			crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+			crc.Write(content)
+
+			for algo, want := range map[string]string{
+				"":       digest,
+				"sha256": digest,
+				"md5":    hex.EncodeToString(md[:]),
+				"crc32c": hex.EncodeToString(crc.Sum(nil)),
+			} {
+				rec := httptest.NewRecorder()
+				hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/files/synced.txt/checksum?algo="+algo, nil))
+				assert.Equal(t, http.StatusOK, rec.Code)
+
+				res := ChecksumResponse{}
+				assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+				assert.Equal(t, want, res.Checksum, algo)
+			}
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/files/synced.txt/checksum?algo=sha1", nil))
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/files/absent.txt/checksum", nil))
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+		},
+	)
+}
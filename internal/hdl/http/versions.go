@@ -0,0 +1,347 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/JMURv/media-server/internal/meta"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const versionsDir = ".versions"
+
+// Version is the content a file had before an overwrite. ReplacedBy is the
+// ETag of the content that took its place, which is the ETag of the next
+// version or of the current file, so the chain can be followed.
+type Version struct {
+	Version     int       `json:"version"`
+	ETag        string    `json:"etag"`
+	Size        int64     `json:"size"`
+	Checksum    string    `json:"checksum,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	ModifiedAt  time.Time `json:"modified_at"`
+	ReplacedAt  time.Time `json:"replaced_at"`
+	ReplacedBy  string    `json:"replaced_by"`
+}
+
+// replacement is the previous content of a file being overwritten, linked
+// aside before the new content is renamed over it, so that at no point is it
+// neither the current file nor set aside.
+type replacement struct {
+	name    string
+	path    string
+	etag    string
+	meta    *meta.File
+	info    os.FileInfo
+	version int
+}
+
+// wantsOverwrite reports whether an upload may replace the file stored under
+// its name: when asked to with conflict=overwrite, or when it carries
+// If-Match, which only lets it replace the content it names.
+func wantsOverwrite(r *http.Request, values url.Values) (bool, error) {
+	conflict := values.Get("conflict")
+	if conflict == "" {
+		conflict = r.URL.Query().Get("conflict")
+	}
+
+	switch conflict {
+	case "", ConflictFail:
+		return r.Header.Get("If-Match") != "", nil
+	case ConflictOverwrite:
+		return true, nil
+	}
+	return false, ErrInvalidConflictPolicy
+}
+
+// ifMatch reports whether an If-Match header lists etag. The comparison is
+// strong, as RFC 9110 requires, and * matches any current file.
+func ifMatch(header, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, v := range strings.Split(header, ",") {
+		if v = strings.TrimSpace(v); !strings.HasPrefix(v, "W/") && v == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch fails r with 412 when it carries an If-Match that the
+// current content of name, stored at path, does not match, so a client does
+// not delete content that changed since it last saw it.
+func (h *Handler) checkIfMatch(w http.ResponseWriter, r *http.Request, name, path string, m *meta.File) bool {
+	v := r.Header.Get("If-Match")
+	if v == "" {
+		return true
+	}
+	info, err := h.statStored(path)
+	if err != nil && m != nil && h.transitioned(name) != nil {
+		info, err = transitionedInfo{m}, nil
+	}
+	if err != nil {
+		writeError(w, withParams(ErrPreconditionFailed, map[string]any{"name": name}))
+		return false
+	}
+	if etag := fileETag(m, info); !ifMatch(v, etag) {
+		w.Header().Set("ETag", etag)
+		writeError(w, withParams(ErrPreconditionFailed, map[string]any{"name": name, "etag": etag}))
+		return false
+	}
+	return true
+}
+
+func (h *Handler) versionDir(name string) string {
+	return filepath.Join(h.savePath, versionsDir, filepath.FromSlash(name))
+}
+
+// prepareOverwrite checks that the upload in r may replace name and sets
+// its current content aside. The caller holds name's file lock. It returns
+// nil if name does not exist and there is no If-Match to fail.
+func (h *Handler) prepareOverwrite(w http.ResponseWriter, r *http.Request, name string) (*replacement, bool) {
+	path := h.filePath(name)
+	info, err := h.statStored(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if r.Header.Get("If-Match") != "" {
+			writeError(w, withParams(ErrPreconditionFailed, map[string]any{"name": name}))
+			return nil, false
+		}
+		return nil, true
+	}
+	if err != nil || !info.Mode().IsRegular() {
+		writeError(w, ErrInternal)
+		return nil, false
+	}
+	if !h.checkLock(w, r, name) || !h.checkHold(w, name) {
+		return nil, false
+	}
+
+	m, err := h.meta.Get(name)
+	if err != nil {
+		m = nil
+	}
+	etag := fileETag(m, info)
+	if v := r.Header.Get("If-Match"); v != "" && !ifMatch(v, etag) {
+		w.Header().Set("ETag", etag)
+		writeError(w, withParams(ErrPreconditionFailed, map[string]any{"name": name, "etag": etag}))
+		return nil, false
+	}
+
+	rep, err := h.setAside(name, path, m, info)
+	if err != nil {
+		log.Printf("Error setting %s aside: %s\n", name, err)
+		writeError(w, ErrInternal)
+		return nil, false
+	}
+	return rep, true
+}
+
+// setAside links the current content of name to where it is kept once it is
+// replaced: the next version when version history is on, a hidden file next
+// to it otherwise. Content that cannot be linked is copied. m is the
+// metadata of name, if any, and info its current stat.
+func (h *Handler) setAside(name, path string, m *meta.File, info os.FileInfo) (*replacement, error) {
+	rep := &replacement{name: name, etag: fileETag(m, info), meta: m, info: info}
+	if h.config.Versions == nil {
+		rep.path = filepath.Join(filepath.Dir(path), ".replaced-"+newUUID())
+	} else {
+		dir := h.versionDir(name)
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return nil, err
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		// Numbers are never reused, not even those of content left behind by
+		// an overwrite interrupted before it was recorded.
+		rep.version = 1
+		for _, e := range entries {
+			if n, err := strconv.Atoi(strings.TrimSuffix(e.Name(), ".json")); err == nil && n >= rep.version {
+				rep.version = n + 1
+			}
+		}
+		rep.path = filepath.Join(dir, strconv.Itoa(rep.version))
+	}
+
+	if err := os.Link(path, rep.path); err == nil {
+		return rep, nil
+	}
+	if err := copyAside(path, rep.path); err != nil {
+		os.Remove(rep.path)
+		return nil, err
+	}
+	return rep, nil
+}
+
+func copyAside(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	return errors.Join(err, out.Close())
+}
+
+// discard drops content set aside for an overwrite that never happened.
+func (rep *replacement) discard() {
+	if err := os.Remove(rep.path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing %s: %s\n", rep.path, err)
+	}
+}
+
+// restore undoes an overwrite: the previous content and metadata of name
+// are put back.
+func (h *Handler) restore(rep *replacement) {
+	defer h.invalidateFile(rep.name)
+	if err := renameAcross(rep.path, h.filePath(rep.name)); err != nil {
+		log.Printf("Error restoring %s: %s\n", rep.name, err)
+		return
+	}
+
+	var err error
+	if rep.meta != nil {
+		err = h.meta.Put(rep.meta)
+	} else {
+		err = h.meta.Delete(rep.name)
+	}
+	if err != nil {
+		log.Printf("Error restoring metadata for %s: %s\n", rep.name, err)
+	}
+}
+
+// keep completes an overwrite by the content with ETag etag: the previous
+// content becomes the newest version, and the oldest ones beyond the limit
+// are dropped, or it is removed when version history is off.
+func (h *Handler) keep(rep *replacement, etag string) {
+	if rep.version == 0 {
+		rep.discard()
+		return
+	}
+
+	v := &Version{
+		Version:    rep.version,
+		ETag:       rep.etag,
+		Size:       rep.info.Size(),
+		ModifiedAt: rep.info.ModTime().UTC(),
+		ReplacedAt: time.Now().UTC(),
+		ReplacedBy: etag,
+	}
+	if rep.meta != nil {
+		if rep.etag == strconv.Quote(rep.meta.Checksum) {
+			v.Checksum = rep.meta.Checksum
+		}
+		v.ContentType = rep.meta.ContentType
+	}
+
+	data, err := json.Marshal(v)
+	if err == nil {
+		err = os.WriteFile(rep.path+".json", data, 0644)
+	}
+	if err != nil {
+		log.Printf("Error recording version %d of %s: %s\n", rep.version, rep.name, err)
+		return
+	}
+	h.pruneVersions(rep.name)
+}
+
+// versions returns the recorded versions of name, oldest first.
+func (h *Handler) versions(name string) ([]*Version, error) {
+	dir := h.versionDir(name)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*Version
+	for _, e := range entries {
+		n, ok := strings.CutSuffix(e.Name(), ".json")
+		if _, err := strconv.Atoi(n); !ok || err != nil {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		v := &Version{}
+		if err = json.Unmarshal(data, v); err != nil {
+			log.Printf("Error reading version %s of %s: %s\n", n, name, err)
+			continue
+		}
+		res = append(res, v)
+	}
+	slices.SortFunc(res, func(a, b *Version) int { return a.Version - b.Version })
+	return res, nil
+}
+
+func (h *Handler) pruneVersions(name string) {
+	limit := h.config.Versions.Max
+	if limit <= 0 {
+		return
+	}
+
+	versions, err := h.versions(name)
+	if err != nil {
+		log.Printf("Error listing versions of %s: %s\n", name, err)
+		return
+	}
+	for _, v := range versions[:max(len(versions)-limit, 0)] {
+		path := filepath.Join(h.versionDir(name), strconv.Itoa(v.Version))
+		if err = errors.Join(os.Remove(path), os.Remove(path+".json")); err != nil {
+			log.Printf("Error pruning version %d of %s: %s\n", v.Version, name, err)
+		}
+	}
+}
+
+func (h *Handler) listVersions(w http.ResponseWriter, r *http.Request) {
+	if h.config.Versions == nil {
+		writeError(w, ErrVersionsDisabled)
+		return
+	}
+	name, err := h.pathName(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !h.checkInternal(w, r, name) {
+		return
+	}
+
+	versions, err := h.versions(name)
+	if err != nil {
+		log.Printf("Error listing versions of %s: %s\n", name, err)
+		writeError(w, ErrInternal)
+		return
+	}
+	if len(versions) == 0 {
+		if _, err = h.statFile(name); err != nil {
+			writeError(w, withParams(ErrNotFound, map[string]any{"name": name}))
+			return
+		}
+	}
+	if versions == nil {
+		versions = []*Version{}
+	}
+	utils.JSONResponse(w, http.StatusOK, versions)
+}
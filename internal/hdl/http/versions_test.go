@@ -0,0 +1,257 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestOverwrite(t *testing.T) {
+	testDir := t.TempDir()
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize: 1 << 20,
+			Versions:      &config.VersionsConfig{},
+		},
+	)
+	hdl.OnUploaded(
+		func(ctx context.Context, f FileInfo) error {
+			if f.Size == int64(len("rejected")) {
+				return errors.New("rejected by policy")
+			}
+			return nil
+		},
+	)
+
+	etagOf := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return strconv.Quote(hex.EncodeToString(sum[:]))
+	}
+	upload := func(name, content string, fields map[string]string, ifMatch string) *httptest.ResponseRecorder {
+		req := newUploadRequest(name, content, fields)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	overwrite := map[string]string{"conflict": ConflictOverwrite}
+	versions := func(name string) []Version {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/files/"+name+"/versions", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var res []Version
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res
+	}
+	content := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(testDir, name))
+		assert.Nil(t, err)
+		return string(data)
+	}
+	errorCode := func(rec *httptest.ResponseRecorder) string {
+		res := utils.ErrorResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res.Code
+	}
+
+	t.Run(
+		"Last writer wins and learns what it replaced", func(t *testing.T) {
+			rec := upload("doc.txt", "first", nil, "")
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, etagOf("first"), rec.Header().Get("ETag"))
+			created := decodeUpload(t, rec)
+
+			assert.Equal(t, http.StatusConflict, upload("doc.txt", "second", nil, "").Code)
+
+			rec = upload("doc.txt", "second", overwrite, "")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, etagOf("second"), rec.Header().Get("ETag"))
+			res := decodeUpload(t, rec)
+			assert.Equal(t, etagOf("first"), res.PreviousETag)
+			assert.Equal(t, created.ID, res.ID)
+			assert.Equal(t, "second", content("doc.txt"))
+
+			v := versions("doc.txt")
+			assert.Len(t, v, 1)
+			assert.Equal(t, 1, v[0].Version)
+			assert.Equal(t, etagOf("first"), v[0].ETag)
+			assert.Equal(t, etagOf("second"), v[0].ReplacedBy)
+			assert.Equal(t, int64(5), v[0].Size)
+			data, err := os.ReadFile(filepath.Join(testDir, versionsDir, "doc.txt", "1"))
+			assert.Nil(t, err)
+			assert.Equal(t, "first", string(data))
+
+			rec = upload("doc.txt", "third", map[string]string{"conflict": "merge"}, "")
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Equal(t, "invalid_conflict_policy", errorCode(rec))
+		},
+	)
+
+	t.Run(
+		"If-Match only replaces the content it names", func(t *testing.T) {
+			rec := upload("doc.txt", "stale", nil, etagOf("first"))
+			assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+			assert.Equal(t, etagOf("second"), rec.Header().Get("ETag"))
+			assert.Equal(t, "precondition_failed", errorCode(rec))
+			assert.Equal(t, "second", content("doc.txt"))
+
+			rec = upload("doc.txt", "third", nil, `W/`+etagOf("second"))
+			assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+
+			rec = upload("doc.txt", "third", nil, etagOf("other")+", "+etagOf("second"))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, etagOf("second"), decodeUpload(t, rec).PreviousETag)
+			assert.Len(t, versions("doc.txt"), 2)
+
+			assert.Equal(t, http.StatusPreconditionFailed, upload("missing.txt", "new", nil, "*").Code)
+			assert.NoFileExists(t, filepath.Join(testDir, "missing.txt"))
+		},
+	)
+
+	t.Run(
+		"Locked files are not overwritten", func(t *testing.T) {
+			l := acquireLock(t, hdl, "doc.txt", "")
+			assert.Equal(t, http.StatusLocked, upload("doc.txt", "fourth", overwrite, "").Code)
+			assert.Len(t, versions("doc.txt"), 2)
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newLockRequest(http.MethodDelete, "doc.txt", "", l.Token))
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+		},
+	)
+
+	t.Run(
+		"Rejected overwrites restore the previous content", func(t *testing.T) {
+			rec := upload("doc.txt", "rejected", overwrite, "")
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+			assert.Equal(t, "third", content("doc.txt"))
+
+			m, err := hdl.meta.Get("doc.txt")
+			assert.Nil(t, err)
+			assert.Equal(t, int64(5), m.Size)
+			assert.Len(t, versions("doc.txt"), 2)
+
+			rec = upload("doc.txt", "fourth", overwrite, "")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			v := versions("doc.txt")
+			assert.Len(t, v, 3)
+			assert.Equal(t, etagOf("third"), v[2].ETag)
+		},
+	)
+
+	t.Run(
+		"Concurrent overwrites keep a complete chain", func(t *testing.T) {
+			const writers = 16
+			payload := func(i int) string {
+				return strings.Repeat(fmt.Sprintf("writer %02d;", i), 4096)
+			}
+
+			rec := upload("asset.bin", payload(0), nil, "")
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			var wg sync.WaitGroup
+			previous := make(chan string, writers)
+			for i := 1; i <= writers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					rec := upload("asset.bin", payload(i), overwrite, "")
+					if assert.Equal(t, http.StatusOK, rec.Code) {
+						previous <- decodeUpload(t, rec).PreviousETag
+					}
+				}(i)
+			}
+			wg.Wait()
+			close(previous)
+
+			final := content("asset.bin")
+			all := make(map[string]bool)
+			for i := 0; i <= writers; i++ {
+				all[etagOf(payload(i))] = true
+			}
+			assert.True(t, all[etagOf(final)], "final content is one of the writes")
+
+			v := versions("asset.bin")
+			assert.Len(t, v, writers)
+			seen := map[string]bool{etagOf(final): true}
+			for i, version := range v {
+				data, err := os.ReadFile(filepath.Join(testDir, versionsDir, "asset.bin", strconv.Itoa(version.Version)))
+				assert.Nil(t, err)
+				assert.Equal(t, version.ETag, etagOf(string(data)))
+				assert.False(t, seen[version.ETag])
+				seen[version.ETag] = true
+
+				next := etagOf(final)
+				if i < len(v)-1 {
+					next = v[i+1].ETag
+				}
+				assert.Equal(t, next, version.ReplacedBy)
+			}
+			assert.Equal(t, all, seen)
+
+			replaced := make(map[string]bool)
+			for etag := range previous {
+				replaced[etag] = true
+			}
+			assert.Len(t, replaced, writers)
+			for _, version := range v {
+				assert.True(t, replaced[version.ETag])
+			}
+		},
+	)
+
+	t.Run(
+		"Old versions are pruned", func(t *testing.T) {
+			hdl.config.Versions.Max = 2
+			defer func() { hdl.config.Versions.Max = 0 }()
+
+			assert.Equal(t, http.StatusOK, upload("doc.txt", "fifth", overwrite, "").Code)
+			v := versions("doc.txt")
+			assert.Len(t, v, 2)
+			assert.Equal(t, []int{3, 4}, []int{v[0].Version, v[1].Version})
+			assert.NoFileExists(t, filepath.Join(testDir, versionsDir, "doc.txt", "1"))
+		},
+	)
+
+	t.Run(
+		"Without version history", func(t *testing.T) {
+			plain := setupTestHandler(testDir)
+			rec := httptest.NewRecorder()
+			plain.ServeHTTP(rec, newUploadRequest("plain.txt", "one", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			rec = httptest.NewRecorder()
+			plain.ServeHTTP(rec, newUploadRequest("plain.txt", "two", overwrite))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, etagOf("one"), decodeUpload(t, rec).PreviousETag)
+			assert.Equal(t, "two", content("plain.txt"))
+
+			matches, err := filepath.Glob(filepath.Join(testDir, ".replaced-*"))
+			assert.Nil(t, err)
+			assert.Empty(t, matches)
+
+			rec = httptest.NewRecorder()
+			plain.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/files/plain.txt/versions", nil))
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+			assert.Equal(t, "versions_disabled", errorCode(rec))
+		},
+	)
+}
@@ -0,0 +1,183 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/internal/storage"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const (
+	readDirBatch          = 1024
+	defaultMaxWalkEntries = 100000
+)
+
+var errWalkLimit = errors.New("walk limit reached")
+
+func (h *Handler) maxWalkEntries() int {
+	if h.config.MaxWalkEntries > 0 {
+		return h.config.MaxWalkEntries
+	}
+	return defaultMaxWalkEntries
+}
+
+// walkDir visits the entries below root, reading each directory in batches
+// so memory stays bounded, and stops after maxWalkEntries entries. Returning
+// filepath.SkipDir from fn for a directory skips it. The result reports
+// whether the walk was cut short.
+func (h *Handler) walkDir(ctx context.Context, root string, recursive bool, fn func(path string, d fs.DirEntry) error) (bool, error) {
+	truncated, err := walkEntries(ctx, root, recursive, h.maxWalkEntries(), fn)
+	if truncated {
+		h.walkTruncations.Inc()
+		log.Printf("Walk of %s stopped after %d entries\n", root, h.maxWalkEntries())
+	}
+	return truncated, err
+}
+
+// walkEntries is walkDir with an explicit cap; a limit of 0 walks everything.
+// ctx is checked before every batch, so a cancelled walk stops within one.
+func walkEntries(ctx context.Context, root string, recursive bool, limit int, fn func(path string, d fs.DirEntry) error) (bool, error) {
+	seen := 0
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		file, err := os.Open(dir)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		for {
+			if err := storage.Canceled(ctx); err != nil {
+				return err
+			}
+			batch, err := file.ReadDir(readDirBatch)
+			for _, d := range batch {
+				if limit > 0 && seen >= limit {
+					return errWalkLimit
+				}
+				seen++
+
+				p := filepath.Join(dir, d.Name())
+				ferr := fn(p, d)
+				if errors.Is(ferr, filepath.SkipDir) {
+					continue
+				}
+				if ferr != nil {
+					return ferr
+				}
+				if recursive && d.IsDir() {
+					if ferr = walk(p); ferr != nil {
+						return ferr
+					}
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err := walk(root)
+	if errors.Is(err, errWalkLimit) {
+		return true, nil
+	}
+	return false, err
+}
+
+// dirFull reports whether dir already holds maxFilesPerDir entries, counting
+// those in the same directory under every storage root.
+func (h *Handler) dirFull(dir string) (bool, error) {
+	limit := h.config.MaxFilesPerDir
+	if limit <= 0 {
+		return false, nil
+	}
+
+	count := 0
+	for _, d := range h.rootedDirs(dir) {
+		n, err := countEntries(d, limit-count)
+		if os.IsNotExist(err) && d != dir {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if count += n; count >= limit {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// rootedDirs returns dir under each storage root, dir itself first.
+func (h *Handler) rootedDirs(dir string) []string {
+	dirs := []string{dir}
+	for _, root := range h.rootDirs() {
+		if !within(dir, root) {
+			continue
+		}
+		rel, _ := filepath.Rel(root, dir)
+		for _, other := range h.rootDirs() {
+			if other != root {
+				dirs = append(dirs, filepath.Join(other, rel))
+			}
+		}
+		break
+	}
+	return dirs
+}
+
+// countEntries counts the entries of dir, stopping at limit.
+func countEntries(dir string, limit int) (int, error) {
+	file, err := os.Open(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	count := 0
+	for count < limit {
+		batch, err := file.ReadDir(min(readDirBatch, limit-count))
+		count += len(batch)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// checkDirCap rejects a new file at path when its directory is at the
+// configured cap.
+func (h *Handler) checkDirCap(w http.ResponseWriter, path string) bool {
+	full, err := h.dirFull(filepath.Dir(path))
+	if err != nil {
+		log.Printf("Error counting entries next to %s: %s\n", path, err)
+		writeError(w, ErrReadingDir)
+		return false
+	}
+	if full {
+		h.dirCapRejections.Inc()
+		err = fmt.Errorf("%w (%d entries)", ErrDirectoryFull, h.config.MaxFilesPerDir)
+		writeError(w, withParams(err, map[string]any{"limit": h.config.MaxFilesPerDir}))
+		return false
+	}
+	return true
+}
+
+func truncatedHint(truncated bool, limit int) string {
+	if !truncated {
+		return ""
+	}
+	return fmt.Sprintf("listing stopped after %d entries; page through GET /changes for the full catalog", limit)
+}
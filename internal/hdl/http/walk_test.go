@@ -0,0 +1,99 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/JMURv/media-server/pkg/config"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWalkCaps(t *testing.T) {
+	testDir := t.TempDir()
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, os.WriteFile(filepath.Join(testDir, fmt.Sprintf("file%d.txt", i)), []byte("data"), 0644))
+	}
+
+	hdl := New(
+		port, testDir, &config.HTTPConfig{
+			MaxUploadSize:   1024 * 1024,
+			MaxStreamBuffer: 1024,
+			DefaultPage:     1,
+			DefaultSize:     10,
+			MaxWalkEntries:  3,
+			MaxFilesPerDir:  1,
+		},
+	)
+	stats := func() Stats {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		res := Stats{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res
+	}
+
+	t.Run(
+		"Listing stops at the walk cap", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/list", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			res := utils.PaginatedResponse{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+			assert.LessOrEqual(t, res.Count, 3)
+			assert.True(t, res.Truncated)
+			assert.Contains(t, res.Hint, "/changes")
+		},
+	)
+
+	t.Run(
+		"Stats report truncation and limits", func(t *testing.T) {
+			res := stats()
+			assert.True(t, res.Truncated)
+			assert.LessOrEqual(t, res.Files, 3)
+			assert.Equal(t, 3, res.Limits.MaxWalkEntries)
+			assert.Equal(t, 1, res.Limits.MaxFilesPerDir)
+			assert.Equal(t, uint64(2), res.Limits.WalkTruncations)
+		},
+	)
+
+	t.Run(
+		"Uploads refused once a directory is full", func(t *testing.T) {
+			entries, err := os.ReadDir(testDir)
+			assert.Nil(t, err)
+			hdl.config.MaxFilesPerDir = len(entries) + 1
+
+			rec := httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("first.txt", "data", nil))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, newUploadRequest("second.txt", "data", nil))
+			assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+			assert.Contains(t, rec.Body.String(), "nested paths")
+			assert.NoFileExists(t, filepath.Join(testDir, "second.txt"))
+
+			rec = httptest.NewRecorder()
+			hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, "/files/third.txt", nil))
+			assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+			assert.Equal(t, uint64(2), stats().Limits.DirCapRejections)
+		},
+	)
+
+	t.Run(
+		"Unlimited by default", func(t *testing.T) {
+			full, err := setupTestHandler(testDir).dirFull(testDir)
+			assert.Nil(t, err)
+			assert.False(t, full)
+		},
+	)
+}
@@ -0,0 +1,191 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/JMURv/media-server/internal/audio"
+	"github.com/JMURv/media-server/internal/jobs"
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	TaskWaveform = "waveform"
+
+	waveformsDir          = ".waveforms"
+	defaultWaveformPoints = 800
+)
+
+type waveformCache struct {
+	ETag string `json:"etag"`
+	*audio.Analysis
+}
+
+func (h *Handler) waveformPath(name string) string {
+	return filepath.Join(h.savePath, waveformsDir, filepath.FromSlash(name)+".json")
+}
+
+func (h *Handler) cachedWaveform(name, etag string) *audio.Analysis {
+	data, err := os.ReadFile(h.waveformPath(name))
+	if err != nil {
+		return nil
+	}
+
+	c := &waveformCache{}
+	if err = json.Unmarshal(data, c); err != nil || c.ETag != etag || c.Analysis == nil {
+		return nil
+	}
+	return c.Analysis
+}
+
+func (h *Handler) ffmpegPath() string {
+	if h.config.Transcode != nil && h.config.Transcode.FFmpeg != "" {
+		return h.config.Transcode.FFmpeg
+	}
+	return "ffmpeg"
+}
+
+// analyzeAudio returns the cached analysis of name, computing it when the
+// cache is stale. Identical concurrent requests share one computation.
+func (h *Handler) analyzeAudio(ctx context.Context, name string) (*audio.Analysis, error) {
+	v, err := h.flights.Do(
+		ctx, "waveform\x00"+name, func(ctx context.Context) (any, error) {
+			return h.computeWaveform(ctx, name)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*audio.Analysis), nil
+}
+
+func (h *Handler) computeWaveform(ctx context.Context, name string) (*audio.Analysis, error) {
+	unlock := h.waveformMu.Lock(name)
+	defer unlock()
+
+	file, err := h.openFile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	contentType, err := h.contentType(name, file)
+	if err != nil || !strings.HasPrefix(contentType, "audio/") {
+		return nil, ErrUnsupportedMediaType
+	}
+
+	etag, _ := h.validators(name, file)
+	if res := h.cachedWaveform(name, etag); res != nil {
+		return res, nil
+	}
+
+	var res *audio.Analysis
+	if format := audio.FormatFor(contentType); format != "" {
+		res, err = audio.Analyze(file, format)
+	}
+	if res == nil {
+		if err != nil {
+			log.Printf("Falling back to ffmpeg for %s: %s\n", name, err)
+		}
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if res, err = audio.AnalyzeFFmpeg(ctx, h.ffmpegPath(), file); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.Marshal(waveformCache{ETag: etag, Analysis: res})
+	if err != nil {
+		return nil, err
+	}
+	path := h.waveformPath(name)
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(path+".tmp", path); err != nil {
+		return nil, err
+	}
+	h.trackArtifact(ArtifactWaveform, name, path)
+	return res, nil
+}
+
+func (h *Handler) waveformTask(ctx context.Context, name string) error {
+	_, err := h.analyzeAudio(ctx, name)
+	return err
+}
+
+func (h *Handler) waveform(w http.ResponseWriter, r *http.Request) {
+	name, err := h.pathName(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	points := defaultWaveformPoints
+	if v := r.URL.Query().Get("points"); v != "" {
+		if points, err = strconv.Atoi(v); err != nil || points < 1 || points > audio.Resolution {
+			writeError(w, ErrInvalidPoints)
+			return
+		}
+	}
+
+	file, err := h.openFile(r.Context(), name)
+	if err != nil {
+		writeError(w, ErrNotFound)
+		return
+	}
+	defer file.Close()
+	if bodyLength(file) == 0 {
+		errorResponse(w, http.StatusUnsupportedMediaType, ErrEmptyFile)
+		return
+	}
+
+	contentType, err := h.contentType(name, file)
+	if err != nil || !strings.HasPrefix(contentType, "audio/") {
+		writeError(w, ErrUnsupportedMediaType)
+		return
+	}
+
+	etag, _ := h.validators(name, file)
+	if res := h.cachedWaveform(name, etag); res != nil {
+		utils.JSONResponse(w, http.StatusOK, audio.Resample(res.Peaks, points))
+		return
+	}
+
+	if h.jobs == nil {
+		writeError(w, ErrJobsDisabled)
+		return
+	}
+
+	for _, job := range h.jobs.List(name) {
+		if slices.Contains(job.Pending(), TaskWaveform) && job.State != jobs.StateFailed {
+			utils.JSONResponse(w, http.StatusAccepted, job)
+			return
+		}
+	}
+
+	job, err := h.jobs.Enqueue(name, []string{TaskWaveform})
+	if errors.Is(err, jobs.ErrQueueFull) {
+		w.Header().Set("Retry-After", "5")
+		writeError(w, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Error enqueueing waveform for %s: %s\n", name, err)
+		writeError(w, ErrInternal)
+		return
+	}
+	utils.JSONResponse(w, http.StatusAccepted, job)
+}
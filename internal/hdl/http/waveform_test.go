@@ -0,0 +1,167 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/jobs"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+const fakeFFmpegPCM = `#!/bin/sh
+cat > /dev/null
+printf '\000\100\000\100%.0s' $(seq 1 4800)
+`
+
+func sineWAV(rate int, tone, silence time.Duration) []byte {
+	var samples []int16
+	for i := range int(tone.Seconds() * float64(rate)) {
+		samples = append(samples, int16(0.5*32767*math.Sin(2*math.Pi*1000*float64(i)/float64(rate))))
+	}
+	samples = append(samples, make([]int16, int(silence.Seconds()*float64(rate)))...)
+
+	buf := &bytes.Buffer{}
+	size := uint32(len(samples) * 2)
+	buf.WriteString("RIFF")
+	binary.Write(buf, binary.LittleEndian, 36+size)
+	buf.WriteString("WAVEfmt ")
+	for _, v := range []any{uint32(16), uint16(1), uint16(1), uint32(rate), uint32(rate * 2), uint16(2), uint16(16)} {
+		binary.Write(buf, binary.LittleEndian, v)
+	}
+	buf.WriteString("data")
+	binary.Write(buf, binary.LittleEndian, size)
+	binary.Write(buf, binary.LittleEndian, samples)
+	return buf.Bytes()
+}
+
+func TestWaveform(t *testing.T) {
+	testDir := t.TempDir()
+
+	bin := t.TempDir()
+	ffmpeg := filepath.Join(bin, "ffmpeg")
+	assert.Nil(t, os.WriteFile(ffmpeg, []byte(fakeFFmpegPCM), 0755))
+
+	cfg := &config.HTTPConfig{
+		Jobs:      &config.JobsConfig{Workers: 2},
+		Transcode: &config.TranscodeConfig{FFmpeg: ffmpeg},
+	}
+	hdl := New(port, testDir, cfg)
+
+	write := func(name string, data []byte) {
+		assert.Nil(t, os.WriteFile(filepath.Join(testDir, name), data, 0644))
+		assert.Nil(t, hdl.meta.Put(&meta.File{Name: name, Size: int64(len(data))}))
+	}
+	write("episode.wav", sineWAV(48000, 2*time.Second, time.Second))
+	write("tone.wav", sineWAV(48000, 3*time.Second, 0))
+	write("notes.txt", []byte("not audio"))
+	write("voice.m4a", []byte("aac"))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	t.Run(
+		"Invalid requests", func(t *testing.T) {
+			assert.Equal(t, http.StatusUnsupportedMediaType, get("/files/notes.txt/waveform").Code)
+			assert.Equal(t, http.StatusNotFound, get("/files/missing.wav/waveform").Code)
+			assert.Equal(t, http.StatusBadRequest, get("/files/episode.wav/waveform?points=0").Code)
+			assert.Equal(t, http.StatusBadRequest, get("/files/episode.wav/waveform?points=100000").Code)
+		},
+	)
+
+	t.Run(
+		"Generated once through the worker pool", func(t *testing.T) {
+			rec := get("/files/episode.wav/waveform?points=30")
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			first := &jobs.Job{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(first))
+
+			rec = get("/files/episode.wav/waveform?points=60")
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			second := &jobs.Job{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(second))
+			assert.Equal(t, first.ID, second.ID)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go hdl.jobs.Run(ctx)
+
+			assert.Eventually(
+				t, func() bool { return get("/files/episode.wav/waveform?points=30").Code == http.StatusOK },
+				3*time.Second, 10*time.Millisecond,
+			)
+
+			var peaks []float64
+			assert.Nil(t, json.NewDecoder(get("/files/episode.wav/waveform?points=30").Body).Decode(&peaks))
+			assert.Len(t, peaks, 30)
+			assert.Equal(t, 1.0, peaks[0])
+			assert.InDelta(t, 1.0, peaks[19], 0.01)
+			assert.Equal(t, 0.0, peaks[29])
+		},
+	)
+
+	t.Run(
+		"Probe stores integrated loudness", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go hdl.jobs.Run(ctx)
+
+			job, err := hdl.jobs.Enqueue("tone.wav", []string{TaskProbe})
+			assert.Nil(t, err)
+			assert.Eventually(
+				t, func() bool {
+					j, _ := hdl.jobs.Get(job.ID)
+					return j.State == jobs.StateDone
+				}, 3*time.Second, 10*time.Millisecond,
+			)
+
+			m, err := hdl.meta.Get("tone.wav")
+			assert.Nil(t, err)
+			assert.Equal(t, "audio/wave", m.DetectedType)
+			assert.NotNil(t, m.Loudness)
+			assert.InDelta(t, -9.0, *m.Loudness, 0.1)
+		},
+	)
+
+	t.Run(
+		"Falls back to ffmpeg", func(t *testing.T) {
+			if runtime.GOOS == "windows" {
+				t.Skip("fake ffmpeg is a shell script")
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go hdl.jobs.Run(ctx)
+
+			assert.Equal(t, http.StatusAccepted, get("/files/voice.m4a/waveform?points=5").Code)
+			assert.Eventually(
+				t, func() bool { return get("/files/voice.m4a/waveform?points=5").Code == http.StatusOK },
+				3*time.Second, 10*time.Millisecond,
+			)
+
+			var peaks []float64
+			assert.Nil(t, json.NewDecoder(get("/files/voice.m4a/waveform?points=5").Body).Decode(&peaks))
+			assert.Equal(t, []float64{1, 1, 1, 1, 1}, peaks)
+		},
+	)
+
+	t.Run(
+		"Regenerated when the file changes", func(t *testing.T) {
+			write("episode.wav", sineWAV(48000, time.Second, 0))
+			assert.Equal(t, http.StatusAccepted, get("/files/episode.wav/waveform").Code)
+		},
+	)
+}
@@ -0,0 +1,48 @@
+package http
+
+import (
+	utils "github.com/JMURv/media-server/pkg/utils/http"
+	"net/http"
+)
+
+type RetryResponse struct {
+	Retried int `json:"retried"`
+}
+
+func (h *Handler) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+
+	utils.JSONResponse(w, http.StatusOK, h.webhooks.DeadLetters())
+}
+
+func (h *Handler) retryDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+
+	d, err := h.webhooks.Retry(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	h.emit("admin.webhooks.retry", "", r, map[string]any{"id": d.ID, "target": d.Target})
+	utils.JSONResponse(w, http.StatusAccepted, d)
+}
+
+// retryDeadLetters redelivers the whole dead-letter queue. Entries whose
+// target was removed from the config stay queued.
+func (h *Handler) retryDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if !h.isAdmin(r) {
+		writeError(w, ErrAdminRequired)
+		return
+	}
+
+	n := h.webhooks.RetryAll()
+	h.emit("admin.webhooks.retry", "", r, map[string]any{"retried": n})
+	utils.JSONResponse(w, http.StatusAccepted, RetryResponse{Retried: n})
+}
@@ -0,0 +1,200 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/JMURv/media-server/internal/webhook"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookDeadLetters(t *testing.T) {
+	testDir := t.TempDir()
+
+	var failing atomic.Bool
+	var mu sync.Mutex
+	var delivered []webhook.Event
+	hook := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				assert.Equal(t, webhook.Sign("secret", body), r.Header.Get(webhook.SignatureHeader))
+				if failing.Load() {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+
+				e := webhook.Event{}
+				assert.Nil(t, json.Unmarshal(body, &e))
+				mu.Lock()
+				delivered = append(delivered, e)
+				mu.Unlock()
+			},
+		),
+	)
+	defer hook.Close()
+
+	cfg := &config.HTTPConfig{
+		MaxUploadSize: 1024 * 1024,
+		Webhooks: []*config.WebhookConfig{
+			{URL: hook.URL, Secret: "secret", Events: []string{"file.created"}, MaxRetries: 1, Backoff: time.Millisecond},
+		},
+		WebhookDLQSize: 2,
+		AdminToken:     "admin-secret",
+	}
+	hdl := New(port, testDir, cfg)
+
+	upload := func(name string) {
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, newUploadRequest(name, name, nil))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		hdl.webhooks.Wait()
+	}
+	doAs := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+	do := func(method, path string) *httptest.ResponseRecorder {
+		return doAs(method, path, "admin-secret")
+	}
+	list := func() []*webhook.DeadLetter {
+		rec := do(http.MethodGet, "/admin/webhooks/dlq")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var res []*webhook.DeadLetter
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res
+	}
+	eventName := func(d *webhook.DeadLetter) string {
+		e := webhook.Event{}
+		assert.Nil(t, json.Unmarshal(d.Event, &e))
+		return e.Name
+	}
+
+	t.Run(
+		"Exhausted deliveries are kept", func(t *testing.T) {
+			assert.Empty(t, list())
+
+			failing.Store(true)
+			upload("a.txt")
+
+			res := list()
+			assert.Len(t, res, 1)
+			d := res[0]
+			assert.NotEmpty(t, d.ID)
+			assert.Equal(t, hook.URL, d.Target)
+			assert.Equal(t, "a.txt", eventName(d))
+			assert.Equal(t, 2, d.Attempts)
+			assert.Equal(t, http.StatusInternalServerError, d.LastStatus)
+			assert.Contains(t, d.LastError, "500")
+			assert.False(t, d.CreatedAt.IsZero())
+			assert.False(t, d.FailedAt.Before(d.CreatedAt))
+			assert.Len(t, d.History, 2)
+			assert.Greater(t, d.History[0].LatencySeconds, 0.0)
+		},
+	)
+
+	t.Run(
+		"Survive restarts", func(t *testing.T) {
+			hdl = New(port, testDir, cfg)
+			res := list()
+			assert.Len(t, res, 1)
+			assert.Equal(t, "a.txt", eventName(res[0]))
+		},
+	)
+
+	t.Run(
+		"Oldest are evicted", func(t *testing.T) {
+			upload("b.txt")
+			upload("c.txt")
+
+			res := list()
+			assert.Len(t, res, 2)
+			assert.Equal(t, "b.txt", eventName(res[0]))
+			assert.Equal(t, "c.txt", eventName(res[1]))
+
+			rec := httptest.NewRecorder()
+			hdl.metrics.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			assert.Contains(t, rec.Body.String(), "webhook_dlq_evictions_total 1")
+			assert.Contains(t, rec.Body.String(), "webhook_dlq_size 2")
+		},
+	)
+
+	t.Run(
+		"Retry one", func(t *testing.T) {
+			assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/admin/webhooks/dlq/unknown/retry").Code)
+
+			id := list()[0].ID
+			failing.Store(false)
+			rec := do(http.MethodPost, "/admin/webhooks/dlq/"+id+"/retry")
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			hdl.webhooks.Wait()
+
+			res := list()
+			assert.Len(t, res, 1)
+			assert.Equal(t, "c.txt", eventName(res[0]))
+			mu.Lock()
+			assert.Len(t, delivered, 1)
+			assert.Equal(t, "b.txt", delivered[0].Name)
+			mu.Unlock()
+		},
+	)
+
+	t.Run(
+		"Failed retries return with their attempts", func(t *testing.T) {
+			failing.Store(true)
+			rec := do(http.MethodPost, "/admin/webhooks/dlq/retry")
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			assert.JSONEq(t, `{"retried":1}`, rec.Body.String())
+			hdl.webhooks.Wait()
+
+			res := list()
+			assert.Len(t, res, 1)
+			assert.Equal(t, 4, res[0].Attempts)
+			assert.Len(t, res[0].History, 4)
+		},
+	)
+
+	t.Run(
+		"Removed targets stay queued", func(t *testing.T) {
+			id := list()[0].ID
+			cfg.Webhooks[0].URL = hook.URL + "/moved"
+			defer func() { cfg.Webhooks[0].URL = hook.URL }()
+
+			assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/admin/webhooks/dlq/"+id+"/retry").Code)
+			rec := do(http.MethodPost, "/admin/webhooks/dlq/retry")
+			assert.JSONEq(t, `{"retried":0}`, rec.Body.String())
+			assert.Len(t, list(), 1)
+		},
+	)
+
+	t.Run(
+		"Retry all", func(t *testing.T) {
+			failing.Store(false)
+			rec := do(http.MethodPost, "/admin/webhooks/dlq/retry")
+			assert.JSONEq(t, `{"retried":1}`, rec.Body.String())
+			hdl.webhooks.Wait()
+			assert.Empty(t, list())
+		},
+	)
+
+	t.Run(
+		"Admin only", func(t *testing.T) {
+			for _, token := range []string{"", "wrong"} {
+				assert.Equal(t, http.StatusForbidden, doAs(http.MethodGet, "/admin/webhooks/dlq", token).Code)
+				assert.Equal(t, http.StatusForbidden, doAs(http.MethodPost, "/admin/webhooks/dlq/retry", token).Code)
+				assert.Equal(t, http.StatusForbidden, doAs(http.MethodPost, "/admin/webhooks/dlq/any/retry", token).Code)
+			}
+		},
+	)
+}
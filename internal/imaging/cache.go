@@ -0,0 +1,115 @@
+package imaging
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+const DefaultCacheSize = 64 << 20
+
+// Variant is a transformed image, with the version of the source image it
+// was rendered from.
+type Variant struct {
+	Data        []byte
+	ContentType string
+	Source      string
+}
+
+type entry struct {
+	key string
+	*Variant
+}
+
+type Cache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+func NewCache(maxBytes int64) *Cache {
+	if maxBytes <= 0 {
+		maxBytes = DefaultCacheSize
+	}
+	return &Cache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the variant under key, whatever version of the source it was
+// rendered from.
+func (c *Cache) Get(key string) (*Variant, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*entry).Variant, true
+}
+
+// Put stores v under key, replacing the variant rendered from an earlier
+// version of the source.
+func (c *Cache) Put(key string, v *Variant) {
+	if int64(len(v.Data)) > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.size -= int64(len(el.Value.(*entry).Data))
+		c.order.Remove(el)
+		delete(c.items, key)
+	}
+
+	c.items[key] = c.order.PushFront(&entry{key: key, Variant: v})
+	c.size += int64(len(v.Data))
+
+	for c.size > c.maxBytes {
+		el := c.order.Back()
+		e := el.Value.(*entry)
+		c.order.Remove(el)
+		delete(c.items, e.key)
+		c.size -= int64(len(e.Data))
+	}
+}
+
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// DropPrefix removes the entries whose keys start with prefix and returns
+// how many bytes they held.
+func (c *Cache) DropPrefix(prefix string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var freed int64
+	for key, el := range c.items {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		e := el.Value.(*entry)
+		c.order.Remove(el)
+		delete(c.items, key)
+		c.size -= int64(len(e.Data))
+		freed += int64(len(e.Data))
+	}
+	return freed
+}
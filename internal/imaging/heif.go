@@ -0,0 +1,52 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+)
+
+const (
+	FormatHEIC = "heic"
+	FormatHEIF = "heif"
+)
+
+// HEIFHeaderSize is enough of a file for HEIFFormat to read its brands.
+const HEIFHeaderSize = 64
+
+var (
+	heicBrands = []string{"heic", "heix", "heim", "heis", "hevc", "hevx", "hevm", "hevs"}
+	heifBrands = []string{"mif1", "msf1"}
+	avifBrands = []string{"avif", "avis"}
+)
+
+// HEIFFormat returns FormatHEIC or FormatHEIF when header, the first bytes
+// of a file, opens an HEIC or HEIF image, and "" otherwise. It reads the
+// brands of the leading ftyp box; AVIF shares the container and the generic
+// brands, and is told apart by its own.
+func HEIFFormat(header []byte) string {
+	if len(header) < 16 || !bytes.Equal(header[4:8], []byte("ftyp")) {
+		return ""
+	}
+	size := int(binary.BigEndian.Uint32(header[:4]))
+	if size < 16 || size%4 != 0 {
+		return ""
+	}
+	size = min(size, len(header)/4*4)
+
+	// The major brand, then the compatible brands after the minor version.
+	brands := []string{string(header[8:12])}
+	for i := 16; i+4 <= size; i += 4 {
+		brands = append(brands, string(header[i:i+4]))
+	}
+
+	switch {
+	case slices.ContainsFunc(brands, func(b string) bool { return slices.Contains(avifBrands, b) }):
+		return ""
+	case slices.ContainsFunc(brands, func(b string) bool { return slices.Contains(heicBrands, b) }):
+		return FormatHEIC
+	case slices.ContainsFunc(brands, func(b string) bool { return slices.Contains(heifBrands, b) }):
+		return FormatHEIF
+	}
+	return ""
+}
@@ -0,0 +1,285 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	FitContain = "contain"
+	FitCover   = "cover"
+	FitFill    = "fill"
+)
+
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+	FormatWebP = "webp"
+)
+
+const (
+	DefaultMaxDimension = 4096
+	maxSourcePixels     = 50_000_000
+	maxPresize          = 4 << 20
+)
+
+var ErrInvalidTransform = errors.New("invalid transformation")
+var ErrUnsupportedImage = errors.New("unsupported image")
+var ErrImageTooLarge = errors.New("image dimensions exceed limits")
+
+var contentTypes = map[string]string{
+	FormatJPEG: "image/jpeg",
+	FormatPNG:  "image/png",
+	FormatGIF:  "image/gif",
+	FormatWebP: "image/webp",
+}
+
+// Limits bound the source images that are accepted and decoded. Zero values
+// mean no limit on a single side and the built-in cap on total pixels.
+type Limits struct {
+	MaxDimension int
+	MaxPixels    int64
+}
+
+func (l Limits) check(cfg image.Config) error {
+	maxPixels := l.MaxPixels
+	if maxPixels <= 0 {
+		maxPixels = maxSourcePixels
+	}
+	if (l.MaxDimension > 0 && max(cfg.Width, cfg.Height) > l.MaxDimension) ||
+		int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return fmt.Errorf("%w: %dx%d", ErrImageTooLarge, cfg.Width, cfg.Height)
+	}
+	return nil
+}
+
+// Probe reads only the image header from src and checks the declared
+// dimensions against limits, so oversized images are refused before any
+// pixel memory is allocated.
+func Probe(src io.Reader, limits Limits) (image.Config, string, error) {
+	cfg, format, err := image.DecodeConfig(src)
+	if err != nil {
+		return cfg, "", fmt.Errorf("%w: %w", ErrUnsupportedImage, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return cfg, "", fmt.Errorf("%w: invalid dimensions %dx%d", ErrUnsupportedImage, cfg.Width, cfg.Height)
+	}
+	return cfg, format, limits.check(cfg)
+}
+
+// Negotiate picks the output format for a request that names none from its
+// Accept header: WebP when the client lists it, otherwise "" to keep the
+// source format. Wildcards do not count, as they say nothing about which
+// codecs the client has. AVIF is not offered since it cannot be encoded.
+func Negotiate(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		typ, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || typ != contentTypes[FormatWebP] {
+			continue
+		}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q <= 0 {
+			return ""
+		}
+		return FormatWebP
+	}
+	return ""
+}
+
+type Transform struct {
+	Width  int
+	Height int
+	Fit    string
+	Format string
+}
+
+func ParseSpec(spec string) (Transform, error) {
+	t := Transform{}
+	for _, field := range strings.Fields(strings.ToLower(spec)) {
+		switch {
+		case field == FitContain || field == FitCover || field == FitFill:
+			t.Fit = field
+		case field == "jpg":
+			t.Format = FormatJPEG
+		case contentTypes[field] != "":
+			t.Format = field
+		case strings.Contains(field, "x"):
+			w, h, _ := strings.Cut(field, "x")
+			var err error
+			if t.Width, err = strconv.Atoi(w); err != nil {
+				return t, fmt.Errorf("%w: %q", ErrInvalidTransform, field)
+			}
+			if t.Height, err = strconv.Atoi(h); err != nil {
+				return t, fmt.Errorf("%w: %q", ErrInvalidTransform, field)
+			}
+		case strings.HasSuffix(field, "w"), strings.HasSuffix(field, "h"):
+			n, err := strconv.Atoi(field[:len(field)-1])
+			if err != nil {
+				return t, fmt.Errorf("%w: %q", ErrInvalidTransform, field)
+			}
+			if field[len(field)-1] == 'w' {
+				t.Width = n
+			} else {
+				t.Height = n
+			}
+		default:
+			return t, fmt.Errorf("%w: %q", ErrInvalidTransform, field)
+		}
+	}
+	return t, t.validate()
+}
+
+func ParseQuery(q url.Values) (Transform, error) {
+	t := Transform{
+		Fit:    strings.ToLower(q.Get("fit")),
+		Format: strings.ToLower(q.Get("format")),
+	}
+	if t.Format == "jpg" {
+		t.Format = FormatJPEG
+	}
+
+	var err error
+	if v := q.Get("w"); v != "" {
+		if t.Width, err = strconv.Atoi(v); err != nil {
+			return t, fmt.Errorf("%w: w=%q", ErrInvalidTransform, v)
+		}
+	}
+	if v := q.Get("h"); v != "" {
+		if t.Height, err = strconv.Atoi(v); err != nil {
+			return t, fmt.Errorf("%w: h=%q", ErrInvalidTransform, v)
+		}
+	}
+	return t, t.validate()
+}
+
+func (t Transform) validate() error {
+	if t.Width < 0 || t.Height < 0 {
+		return fmt.Errorf("%w: negative size", ErrInvalidTransform)
+	}
+	if t.Width == 0 && t.Height == 0 && t.Format == "" {
+		return fmt.Errorf("%w: nothing to do", ErrInvalidTransform)
+	}
+	if t.Fit != "" && t.Fit != FitContain && t.Fit != FitCover && t.Fit != FitFill {
+		return fmt.Errorf("%w: fit %q", ErrInvalidTransform, t.Fit)
+	}
+	if (t.Fit == FitCover || t.Fit == FitFill) && (t.Width == 0 || t.Height == 0) {
+		return fmt.Errorf("%w: fit %q needs width and height", ErrInvalidTransform, t.Fit)
+	}
+	if t.Format != "" && contentTypes[t.Format] == "" {
+		return fmt.Errorf("%w: format %q", ErrInvalidTransform, t.Format)
+	}
+	return nil
+}
+
+func (t Transform) Within(maxDimension int) bool {
+	if maxDimension <= 0 {
+		maxDimension = DefaultMaxDimension
+	}
+	return t.Width <= maxDimension && t.Height <= maxDimension
+}
+
+func (t Transform) Key() string {
+	fit := t.Fit
+	if fit == "" {
+		fit = FitContain
+	}
+	return fmt.Sprintf("%dx%d-%s-%s", t.Width, t.Height, fit, t.Format)
+}
+
+func (t Transform) size(src image.Rectangle) (image.Rectangle, image.Rectangle) {
+	sw, sh := src.Dx(), src.Dy()
+	w, h := t.Width, t.Height
+
+	switch {
+	case w == 0 && h == 0:
+		return src, image.Rect(0, 0, sw, sh)
+	case h == 0:
+		h = max(1, sh*w/sw)
+		return src, image.Rect(0, 0, w, h)
+	case w == 0:
+		w = max(1, sw*h/sh)
+		return src, image.Rect(0, 0, w, h)
+	}
+
+	switch t.Fit {
+	case FitFill:
+		return src, image.Rect(0, 0, w, h)
+	case FitCover:
+		crop := src
+		if sw*h > sh*w {
+			cw := sh * w / h
+			crop.Min.X += (sw - cw) / 2
+			crop.Max.X = crop.Min.X + cw
+		} else {
+			ch := sw * h / w
+			crop.Min.Y += (sh - ch) / 2
+			crop.Max.Y = crop.Min.Y + ch
+		}
+		return crop, image.Rect(0, 0, w, h)
+	default:
+		if sw*h > sh*w {
+			h = max(1, sh*w/sw)
+		} else {
+			w = max(1, sw*h/sh)
+		}
+		return src, image.Rect(0, 0, w, h)
+	}
+}
+
+func Apply(dst io.Writer, src io.ReadSeeker, t Transform, limits Limits) (string, error) {
+	cfg, format, err := Probe(src, limits)
+	if err != nil {
+		return "", err
+	}
+	if _, err = src.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if buf, ok := dst.(*bytes.Buffer); ok {
+		_, size := t.size(image.Rect(0, 0, cfg.Width, cfg.Height))
+		buf.Grow(min(size.Dx()*size.Dy(), maxPresize))
+	}
+
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrUnsupportedImage, err)
+	}
+
+	crop, size := t.size(img.Bounds())
+	if size != img.Bounds() || crop != img.Bounds() {
+		out := image.NewNRGBA(size)
+		draw.CatmullRom.Scale(out, size, img, crop, draw.Src, nil)
+		img = out
+	}
+
+	if t.Format != "" {
+		format = t.Format
+	}
+	switch format {
+	case FormatJPEG:
+		err = jpeg.Encode(dst, img, &jpeg.Options{Quality: 85})
+	case FormatPNG:
+		err = png.Encode(dst, img)
+	case FormatGIF:
+		err = gif.Encode(dst, img, nil)
+	case FormatWebP:
+		err = nativewebp.Encode(dst, img, nil)
+	default:
+		return "", fmt.Errorf("%w: format %q", ErrUnsupportedImage, format)
+	}
+	if err != nil {
+		return "", err
+	}
+	return contentTypes[format], nil
+}
@@ -0,0 +1,161 @@
+package imaging
+
+import (
+	"fmt"
+	"golang.org/x/image/draw"
+	"image"
+	"io"
+	"math"
+	"strings"
+)
+
+const (
+	placeholderSize = 32
+	blurHashX       = 4
+	blurHashY       = 3
+)
+
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+type Placeholder struct {
+	BlurHash      string
+	DominantColor string
+}
+
+func NewPlaceholder(src io.ReadSeeker, limits Limits) (*Placeholder, error) {
+	if _, _, err := Probe(src, limits); err != nil {
+		return nil, err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedImage, err)
+	}
+
+	small := image.NewNRGBA(image.Rect(0, 0, placeholderSize, placeholderSize))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	return &Placeholder{
+		BlurHash:      blurHash(small),
+		DominantColor: dominantColor(small),
+	}, nil
+}
+
+func encode83(sb *strings.Builder, v, length int) {
+	for i := 1; i <= length; i++ {
+		digit := (v / int(math.Pow(83, float64(length-i)))) % 83
+		sb.WriteByte(base83[digit])
+	}
+}
+
+func toLinear(v uint8) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func toSRGB(v float64) int {
+	v = max(0, min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+func blurHash(img *image.NRGBA) string {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	factors := make([][3]float64, 0, blurHashX*blurHashY)
+	for j := range blurHashY {
+		for i := range blurHashX {
+			norm := 2.0
+			if i == 0 && j == 0 {
+				norm = 1
+			}
+
+			var f [3]float64
+			for y := range h {
+				for x := range w {
+					basis := math.Cos(math.Pi*float64(i*x)/float64(w)) * math.Cos(math.Pi*float64(j*y)/float64(h))
+					c := img.NRGBAAt(x, y)
+					f[0] += basis * toLinear(c.R)
+					f[1] += basis * toLinear(c.G)
+					f[2] += basis * toLinear(c.B)
+				}
+			}
+
+			scale := norm / float64(w*h)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	sb := &strings.Builder{}
+	encode83(sb, (blurHashX-1)+(blurHashY-1)*9, 1)
+
+	maxValue := 1.0
+	if len(factors) > 1 {
+		actual := 0.0
+		for _, f := range factors[1:] {
+			actual = max(actual, math.Abs(f[0]), math.Abs(f[1]), math.Abs(f[2]))
+		}
+		quantised := int(max(0, min(82, math.Floor(actual*166-0.5))))
+		maxValue = float64(quantised+1) / 166
+		encode83(sb, quantised, 1)
+	} else {
+		encode83(sb, 0, 1)
+	}
+
+	dc := factors[0]
+	encode83(sb, toSRGB(dc[0])<<16|toSRGB(dc[1])<<8|toSRGB(dc[2]), 4)
+
+	for _, f := range factors[1:] {
+		q := func(v float64) int {
+			return int(max(0, min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+		}
+		encode83(sb, q(f[0])*19*19+q(f[1])*19+q(f[2]), 2)
+	}
+	return sb.String()
+}
+
+func dominantColor(img *image.NRGBA) string {
+	type bucket struct {
+		n       int
+		r, g, b int
+	}
+
+	buckets := make(map[int]*bucket)
+	var top *bucket
+	for y := range img.Bounds().Dy() {
+		for x := range img.Bounds().Dx() {
+			c := img.NRGBAAt(x, y)
+			if c.A < 128 {
+				continue
+			}
+
+			key := int(c.R>>4)<<8 | int(c.G>>4)<<4 | int(c.B>>4)
+			b, ok := buckets[key]
+			if !ok {
+				b = &bucket{}
+				buckets[key] = b
+			}
+			b.n++
+			b.r, b.g, b.b = b.r+int(c.R), b.g+int(c.G), b.b+int(c.B)
+			if top == nil || b.n > top.n {
+				top = b
+			}
+		}
+	}
+
+	if top == nil {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", top.r/top.n, top.g/top.n, top.b/top.n)
+}
@@ -0,0 +1,389 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/internal/metrics"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StatePending = "pending"
+	StateRunning = "running"
+	StateDone    = "done"
+	StateFailed  = "failed"
+)
+
+const (
+	defaultWorkers   = 2
+	defaultQueueSize = 100
+	defaultRetention = 24 * time.Hour
+)
+
+var ErrQueueFull = errors.New("job queue is full")
+var ErrNotFound = errors.New("job not found")
+var ErrUnknownTask = errors.New("unknown task")
+
+type TaskFunc func(ctx context.Context, name string) error
+
+type Job struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Tasks     []string  `json:"tasks"`
+	Done      []string  `json:"done"`
+	State     string    `json:"state"`
+	Error     string    `json:"error,omitempty"`
+	Attempts  int       `json:"attempts"`
+	Progress  float64   `json:"progress"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (j *Job) Pending() []string {
+	res := make([]string, 0, len(j.Tasks))
+	for _, task := range j.Tasks {
+		if !slices.Contains(j.Done, task) {
+			res = append(res, task)
+		}
+	}
+	return res
+}
+
+func (j *Job) finished() bool {
+	return j.State == StateDone || j.State == StateFailed
+}
+
+type progressKey struct{}
+
+type progressReporter struct {
+	pool *Pool
+	job  *Job
+}
+
+func ReportProgress(ctx context.Context, fraction float64) {
+	r, ok := ctx.Value(progressKey{}).(*progressReporter)
+	if !ok {
+		return
+	}
+
+	r.pool.mu.Lock()
+	defer r.pool.mu.Unlock()
+	fraction = min(max(fraction, 0), 1)
+	r.job.Progress = (float64(len(r.job.Done)) + fraction) / float64(len(r.job.Tasks))
+}
+
+type Options struct {
+	Workers   int
+	QueueSize int
+	Retention time.Duration
+}
+
+type Pool struct {
+	dir       string
+	workers   int
+	retention time.Duration
+	queue     chan string
+	tasks     map[string]TaskFunc
+	onDone    func(*Job)
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+
+	completed *metrics.Counter
+	failed    *metrics.Counter
+}
+
+func New(dir string, opts Options, reg *metrics.Registry) (*Pool, error) {
+	if opts.Workers <= 0 {
+		opts.Workers = defaultWorkers
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = defaultRetention
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	p := &Pool{
+		dir:       dir,
+		workers:   opts.Workers,
+		retention: opts.Retention,
+		tasks:     make(map[string]TaskFunc),
+		jobs:      make(map[string]*Job),
+		completed: reg.Counter("jobs_completed_total", "Post-processing jobs completed."),
+		failed:    reg.Counter("jobs_failed_total", "Post-processing jobs failed."),
+	}
+
+	incomplete, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+
+	p.queue = make(chan string, max(opts.QueueSize, len(incomplete)))
+	for _, job := range incomplete {
+		p.queue <- job.ID
+	}
+
+	reg.GaugeFunc("jobs_pending", "Post-processing jobs waiting or running.", func() float64 { return float64(len(p.queue)) })
+	return p, nil
+}
+
+func (p *Pool) path(id string) string {
+	return filepath.Join(p.dir, id+".json")
+}
+
+func (p *Pool) load() ([]*Job, error) {
+	entries, err := os.ReadDir(p.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*Job
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(p.dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		job := &Job{}
+		if err = json.Unmarshal(data, job); err != nil {
+			log.Printf("Skipping corrupt job %s: %s\n", e.Name(), err)
+			continue
+		}
+
+		p.jobs[job.ID] = job
+		if !job.finished() {
+			job.State = StatePending
+			res = append(res, job)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt.Before(res[j].CreatedAt) })
+	return res, nil
+}
+
+func (p *Pool) save(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	tmp := p.path(job.ID) + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path(job.ID))
+}
+
+func (p *Pool) Register(task string, fn TaskFunc) {
+	p.tasks[task] = fn
+}
+
+func (p *Pool) Validate(tasks []string) error {
+	for _, task := range tasks {
+		if _, ok := p.tasks[task]; !ok {
+			return fmt.Errorf("%w: %q", ErrUnknownTask, task)
+		}
+	}
+	return nil
+}
+
+func (p *Pool) OnDone(fn func(*Job)) {
+	p.onDone = fn
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (p *Pool) Enqueue(name string, tasks []string) (*Job, error) {
+	now := time.Now().UTC()
+	job := &Job{
+		ID:        newID(),
+		Name:      name,
+		Tasks:     tasks,
+		Done:      make([]string, 0, len(tasks)),
+		State:     StatePending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.queue) == cap(p.queue) {
+		return nil, ErrQueueFull
+	}
+	if err := p.save(job); err != nil {
+		return nil, err
+	}
+
+	p.jobs[job.ID] = job
+	p.queue <- job.ID
+
+	res := *job
+	return &res, nil
+}
+
+func (p *Pool) Get(id string) (*Job, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	job, ok := p.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	res := *job
+	return &res, nil
+}
+
+func (p *Pool) List(name string) []*Job {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	res := make([]*Job, 0)
+	for _, job := range p.jobs {
+		if name == "" || job.Name == name {
+			j := *job
+			res = append(res, &j)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt.Before(res[j].CreatedAt) })
+	return res
+}
+
+func (p *Pool) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range p.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case id := <-p.queue:
+					p.process(ctx, id)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *Pool) update(job *Job, fn func(*Job)) Job {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fn(job)
+	job.UpdatedAt = time.Now().UTC()
+	if err := p.save(job); err != nil {
+		log.Printf("Error persisting job %s: %s\n", job.ID, err)
+	}
+	return *job
+}
+
+func (p *Pool) process(ctx context.Context, id string) {
+	p.mu.Lock()
+	job, ok := p.jobs[id]
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	snapshot := p.update(
+		job, func(j *Job) {
+			j.State = StateRunning
+			j.Attempts++
+			j.Progress = float64(len(j.Done)) / float64(max(len(j.Tasks), 1))
+		},
+	)
+
+	taskCtx := context.WithValue(ctx, progressKey{}, &progressReporter{pool: p, job: job})
+	for _, task := range snapshot.Pending() {
+		fn, ok := p.tasks[task]
+		err := fmt.Errorf("%w: %q", ErrUnknownTask, task)
+		if ok {
+			err = fn(taskCtx, job.Name)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			log.Printf("Job %s task %s for %s failed: %s\n", job.ID, task, job.Name, err)
+			p.failed.Inc()
+			p.finish(
+				job, func(j *Job) {
+					j.State = StateFailed
+					j.Error = fmt.Sprintf("%s: %s", task, err)
+				},
+			)
+			return
+		}
+
+		p.update(
+			job, func(j *Job) {
+				j.Done = append(j.Done, task)
+				j.Progress = float64(len(j.Done)) / float64(len(j.Tasks))
+			},
+		)
+	}
+
+	p.completed.Inc()
+	p.finish(
+		job, func(j *Job) {
+			j.State = StateDone
+			j.Progress = 1
+		},
+	)
+}
+
+func (p *Pool) finish(job *Job, fn func(*Job)) {
+	res := p.update(job, fn)
+	p.prune(res.UpdatedAt)
+
+	if p.onDone != nil {
+		p.onDone(&res)
+	}
+}
+
+func (p *Pool) prune(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id, job := range p.jobs {
+		if job.finished() && now.Sub(job.UpdatedAt) > p.retention {
+			delete(p.jobs, id)
+			if err := os.Remove(p.path(id)); err != nil && !os.IsNotExist(err) {
+				log.Printf("Error removing job %s: %s\n", id, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	redacted          = "[REDACTED]"
+	subscriberBacklog = 64
+)
+
+var (
+	sensitiveKey  = regexp.MustCompile(`(?i)(token|secret|password|passwd|api[_-]?key|authorization|cookie|signature)`)
+	sensitiveText = regexp.MustCompile(
+		`(?i)(bearer\s+|(?:token|secret|password|passwd|api[_-]?key|signature|sig)["']?\s*[=:]\s*["']?)[^\s&"',]+`,
+	)
+)
+
+// output and flags are captured before any Buffer is installed, since
+// installing one routes the log package through slog.
+var (
+	output = log.Writer()
+	flags  = log.Flags()
+)
+
+type Record struct {
+	Time    time.Time      `json:"time"`
+	Level   string         `json:"level"`
+	Message string         `json:"message"`
+	Attrs   map[string]any `json:"attrs,omitempty"`
+
+	level slog.Level
+}
+
+// Buffer keeps the most recent log records in a ring and fans new ones out to
+// subscribers. Subscribers that fall behind are dropped instead of blocking
+// the caller that is logging.
+type Buffer struct {
+	mu      sync.Mutex
+	out     *log.Logger
+	level   slog.Level
+	records []Record
+	next    int
+	full    bool
+	subs    map[*Subscription]struct{}
+}
+
+type Subscription struct {
+	C     chan Record
+	level slog.Level
+}
+
+func New(size int, level slog.Level) *Buffer {
+	return &Buffer{
+		out:     log.New(output, "", flags),
+		level:   level,
+		records: make([]Record, size),
+		subs:    make(map[*Subscription]struct{}),
+	}
+}
+
+// Install makes b the default slog handler. The log package writes through
+// the default handler too, so existing log.Printf calls are captured.
+func Install(b *Buffer) {
+	slog.SetDefault(slog.New(&handler{b: b}))
+}
+
+// Recent returns up to limit of the newest records at or above level, oldest
+// first.
+func (b *Buffer) Recent(limit int, level slog.Level) []Record {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := b.next
+	if b.full {
+		n = len(b.records)
+	}
+
+	var res []Record
+	for i := 0; i < n && len(res) < limit; i++ {
+		rec := b.records[(b.next-1-i+len(b.records))%len(b.records)]
+		if rec.level >= level {
+			res = append(res, rec)
+		}
+	}
+	slices.Reverse(res)
+	return res
+}
+
+// Subscribe returns a subscription receiving new records at or above level.
+// Its channel is closed when the subscriber is dropped for falling behind or
+// unsubscribes.
+func (b *Buffer) Subscribe(level slog.Level) *Subscription {
+	s := &Subscription{C: make(chan Record, subscriberBacklog), level: level}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[s] = struct{}{}
+	return s
+}
+
+func (b *Buffer) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.C)
+	}
+}
+
+func (b *Buffer) add(rec Record) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.records) > 0 {
+		b.records[b.next] = rec
+		b.next = (b.next + 1) % len(b.records)
+		b.full = b.full || b.next == 0
+	}
+
+	for s := range b.subs {
+		if rec.level < s.level {
+			continue
+		}
+		select {
+		case s.C <- rec:
+		default:
+			delete(b.subs, s)
+			close(s.C)
+		}
+	}
+}
+
+type handler struct {
+	b      *Buffer
+	attrs  []slog.Attr
+	groups []string
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= min(h.b.level, slog.LevelInfo)
+}
+
+func (h *handler) Handle(_ context.Context, r slog.Record) error {
+	level := inferLevel(r)
+	attrs := map[string]any{}
+	prefix := strings.Join(h.groups, ".")
+	for _, a := range h.attrs {
+		addAttr(attrs, "", a)
+	}
+	r.Attrs(
+		func(a slog.Attr) bool {
+			addAttr(attrs, prefix, a)
+			return true
+		},
+	)
+
+	if level >= slog.LevelInfo {
+		h.b.out.Print(format(r.Message, attrs))
+	}
+	if level < h.b.level {
+		return nil
+	}
+
+	rec := Record{
+		Time:    r.Time.UTC(),
+		Level:   level.String(),
+		Message: Redact(r.Message),
+		level:   level,
+	}
+	if len(attrs) > 0 {
+		rec.Attrs = redactAttrs(attrs)
+	}
+	h.b.add(rec)
+	return nil
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append([]slog.Attr(nil), h.attrs...)
+	prefix := strings.Join(h.groups, ".")
+	for _, a := range attrs {
+		if prefix != "" {
+			a.Key = prefix + "." + a.Key
+		}
+		c.attrs = append(c.attrs, a)
+	}
+	return &c
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.groups = append(append([]string(nil), h.groups...), name)
+	return &c
+}
+
+// inferLevel raises records bridged from the log package, which all arrive
+// at info, when the message follows the repo's "Error ..." convention.
+func inferLevel(r slog.Record) slog.Level {
+	if r.Level != slog.LevelInfo {
+		return r.Level
+	}
+	switch {
+	case strings.HasPrefix(r.Message, "Error"), strings.HasPrefix(r.Message, "Panic"):
+		return slog.LevelError
+	case strings.HasPrefix(r.Message, "Warn"):
+		return slog.LevelWarn
+	}
+	return r.Level
+}
+
+func addAttr(attrs map[string]any, prefix string, a slog.Attr) {
+	key := a.Key
+	if prefix != "" {
+		key = prefix + "." + key
+	}
+
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		for _, g := range v.Group() {
+			addAttr(attrs, key, g)
+		}
+		return
+	}
+	attrs[key] = v.Any()
+}
+
+// Redact masks credentials that appear in free text, such as bearer tokens
+// and key=value pairs with sensitive names.
+func Redact(s string) string {
+	return sensitiveText.ReplaceAllString(s, "${1}"+redacted)
+}
+
+// Sensitive reports whether values under key are credentials.
+func Sensitive(key string) bool {
+	return sensitiveKey.MatchString(key)
+}
+
+func redactAttrs(attrs map[string]any) map[string]any {
+	for k, v := range attrs {
+		if sensitiveKey.MatchString(k) {
+			attrs[k] = redacted
+			continue
+		}
+		if s, ok := v.(string); ok {
+			attrs[k] = Redact(s)
+		}
+	}
+	return attrs
+}
+
+func format(msg string, attrs map[string]any) string {
+	if len(attrs) == 0 {
+		return msg
+	}
+
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString(msg)
+	for _, k := range keys {
+		fmt.Fprintf(&sb, " %s=%v", k, attrs[k])
+	}
+	return sb.String()
+}
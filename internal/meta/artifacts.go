@@ -0,0 +1,133 @@
+package meta
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ArtifactsFile has no .json suffix so Walk does not mistake it for a file's
+// metadata.
+const ArtifactsFile = ".artifacts"
+
+// Artifact is a file derived from a stored one, such as a cached preview or
+// waveform. Path is relative to the save path, and Checksum is the source's
+// at the time the artifact was derived from it.
+type Artifact struct {
+	Path      string    `json:"path"`
+	Kind      string    `json:"kind"`
+	Source    string    `json:"source"`
+	SourceID  string    `json:"source_id,omitempty"`
+	Checksum  string    `json:"checksum,omitempty"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Artifacts indexes derived artifacts by path. The index is written out on
+// every change.
+type Artifacts struct {
+	mu    sync.Mutex
+	path  string
+	items map[string]Artifact
+}
+
+func OpenArtifacts(savePath string) (*Artifacts, error) {
+	a := &Artifacts{
+		path:  filepath.Join(savePath, Dir, ArtifactsFile),
+		items: map[string]Artifact{},
+	}
+
+	data, err := os.ReadFile(a.path)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Artifact
+	if err = json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, art := range list {
+		a.items[art.Path] = art
+	}
+	return a, nil
+}
+
+// Add records art, replacing any artifact at the same path.
+func (a *Artifacts) Add(art Artifact) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.items[art.Path] = art
+	return a.save()
+}
+
+// Remove forgets the artifacts at paths.
+func (a *Artifacts) Remove(paths ...string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	n := len(a.items)
+	for _, p := range paths {
+		delete(a.items, p)
+	}
+	if len(a.items) == n {
+		return nil
+	}
+	return a.save()
+}
+
+func (a *Artifacts) Get(path string) (Artifact, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	art, ok := a.items[path]
+	return art, ok
+}
+
+// Of returns the artifacts derived from source.
+func (a *Artifacts) Of(source string) []Artifact {
+	return a.list(func(art Artifact) bool { return art.Source == source })
+}
+
+// All returns every artifact, ordered by path.
+func (a *Artifacts) All() []Artifact {
+	return a.list(func(Artifact) bool { return true })
+}
+
+func (a *Artifacts) list(keep func(Artifact) bool) []Artifact {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var res []Artifact
+	for _, art := range a.items {
+		if keep(art) {
+			res = append(res, art)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Path < res[j].Path })
+	return res
+}
+
+func (a *Artifacts) save() error {
+	list := make([]Artifact, 0, len(a.items))
+	for _, art := range a.items {
+		list = append(list, art)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(a.path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := a.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.path)
+}
@@ -0,0 +1,75 @@
+package meta
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DownloadsFile has no .json suffix so Walk does not mistake it for a file's
+// metadata.
+const DownloadsFile = ".downloads"
+
+// Downloads counts how many times each file was downloaded. Counts are kept
+// in memory and written out by Save.
+type Downloads struct {
+	mu     sync.Mutex
+	path   string
+	counts map[string]int64
+}
+
+func OpenDownloads(savePath string) (*Downloads, error) {
+	d := &Downloads{
+		path:   filepath.Join(savePath, Dir, DownloadsFile),
+		counts: map[string]int64{},
+	}
+
+	data, err := os.ReadFile(d.path)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &d.counts); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Downloads) Inc(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.counts[name]++
+}
+
+func (d *Downloads) Get(name string) int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.counts[name]
+}
+
+func (d *Downloads) Forget(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.counts, name)
+}
+
+func (d *Downloads) Save() error {
+	d.mu.Lock()
+	data, err := json.Marshal(d.counts)
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(d.path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := d.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.path)
+}
@@ -0,0 +1,93 @@
+package meta
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const idsDir = ".ids"
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewID returns a ULID: a 48-bit millisecond timestamp followed by 80 random
+// bits, encoded as 26 characters of Crockford base32 so IDs sort by creation.
+func NewID() string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixMilli())<<16)
+	if _, err := rand.Read(b[6:]); err != nil {
+		panic(err)
+	}
+
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	out := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out)
+}
+
+// ValidID reports whether id looks like a ULID produced by NewID.
+func ValidID(id string) bool {
+	if len(id) != 26 || id[0] > '7' {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if strings.IndexByte(crockford, id[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Store) idPath(id string) string {
+	return filepath.Join(s.root, idsDir, id)
+}
+
+// Resolve returns the name of the file currently holding id. Index entries
+// left behind by an interrupted write are ignored unless the file's metadata
+// still carries the same ID.
+func (s *Store) Resolve(id string) (string, error) {
+	if !ValidID(id) {
+		return "", ErrNotFound
+	}
+
+	s.mu.RLock()
+	data, err := os.ReadFile(s.idPath(id))
+	s.mu.RUnlock()
+	if os.IsNotExist(err) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	name := string(data)
+	f, err := s.Get(name)
+	if err != nil || f.ID != id {
+		return "", ErrNotFound
+	}
+	return name, nil
+}
+
+func (s *Store) index(id, name string) error {
+	path := s.idPath(id)
+	if data, err := os.ReadFile(path); err == nil && string(data) == name {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(name), 0644)
+}
+
+func (s *Store) unindex(id, name string) {
+	if data, err := os.ReadFile(s.idPath(id)); err == nil && string(data) == name {
+		os.Remove(s.idPath(id))
+	}
+}
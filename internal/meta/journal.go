@@ -0,0 +1,220 @@
+package meta
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const JournalFile = ".journal.jsonl"
+
+const (
+	ChangeCreated  = "created"
+	ChangeModified = "modified"
+	ChangeDeleted  = "deleted"
+)
+
+var ErrCursorExpired = errors.New("cursor is older than the retained history")
+
+type Change struct {
+	Seq      uint64     `json:"seq"`
+	Type     string     `json:"type"`
+	Name     string     `json:"name,omitempty"`
+	Time     time.Time  `json:"time"`
+	ModTime  *time.Time `json:"mtime,omitempty"`
+	Size     int64      `json:"size,omitempty"`
+	Checksum string     `json:"checksum,omitempty"`
+}
+
+// Journal is an append-only log of file changes kept for a retention window.
+// Entries that fall out of the window are dropped; the newest dropped entry,
+// or the journal creation time, is remembered as a floor so that cursors
+// before it can be told apart from cursors that have nothing new to read.
+type Journal struct {
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	retention time.Duration
+	entries   []Change
+	floor     Change
+	last      uint64
+	dropped   int
+}
+
+func OpenJournal(savePath string, retention time.Duration) (*Journal, error) {
+	path := filepath.Join(savePath, Dir, JournalFile)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	j := &Journal{path: path, retention: retention, floor: Change{Time: time.Now().UTC()}}
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	if err := j.compact(time.Now()); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *Journal) load() error {
+	file, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		c := Change{}
+		if err = json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			continue
+		}
+
+		j.last = max(j.last, c.Seq)
+		if c.Type == "" {
+			j.floor = c
+			continue
+		}
+		j.entries = append(j.entries, c)
+	}
+	return scanner.Err()
+}
+
+// compact drops expired entries and rewrites the file starting with the
+// floor marker.
+func (j *Journal) compact(now time.Time) error {
+	if j.file != nil {
+		j.file.Close()
+	}
+
+	j.expire(now)
+	tmp := j.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	enc.Encode(j.floor)
+	for _, c := range j.entries {
+		enc.Encode(c)
+	}
+	if err = errors.Join(w.Flush(), file.Close()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+
+	j.dropped = 0
+	j.file, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+func (j *Journal) expire(now time.Time) {
+	if j.retention <= 0 {
+		return
+	}
+
+	cutoff := now.Add(-j.retention)
+	n := sort.Search(len(j.entries), func(i int) bool { return j.entries[i].Time.After(cutoff) })
+	if n > 0 {
+		j.floor = Change{Seq: j.entries[n-1].Seq, Time: j.entries[n-1].Time}
+		j.entries = j.entries[n:]
+		j.dropped += n
+	}
+}
+
+func (j *Journal) Append(c Change) (Change, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	c.Seq = j.last + 1
+	c.Time = time.Now().UTC()
+	data, err := json.Marshal(c)
+	if err != nil {
+		return c, err
+	}
+	if _, err = j.file.Write(append(data, '\n')); err != nil {
+		return c, err
+	}
+
+	j.last = c.Seq
+	j.entries = append(j.entries, c)
+	if j.expire(c.Time); j.dropped > len(j.entries) {
+		if err = j.compact(c.Time); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// Since returns up to limit changes recorded after the cursor seq, and
+// whether more are available.
+func (j *Journal) Since(seq uint64, limit int) ([]Change, bool, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.expire(time.Now())
+	if seq < j.floor.Seq {
+		return nil, false, ErrCursorExpired
+	}
+
+	i := sort.Search(len(j.entries), func(i int) bool { return j.entries[i].Seq > seq })
+	res := j.entries[i:]
+	more := limit > 0 && len(res) > limit
+	if more {
+		res = res[:limit]
+	}
+	return append([]Change(nil), res...), more, nil
+}
+
+// Cursor returns the cursor that precedes every change recorded after t.
+func (j *Journal) Cursor(t time.Time) (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.expire(time.Now())
+	if t.Before(j.floor.Time) {
+		return 0, ErrCursorExpired
+	}
+
+	i := sort.Search(len(j.entries), func(i int) bool { return j.entries[i].Time.After(t) })
+	if i == 0 {
+		return j.floor.Seq, nil
+	}
+	return j.entries[i-1].Seq, nil
+}
+
+// Floor returns the oldest cursor the journal still answers, which precedes
+// every change it retains.
+func (j *Journal) Floor() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.expire(time.Now())
+	return j.floor.Seq
+}
+
+func (j *Journal) Last() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.last
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
@@ -0,0 +1,219 @@
+package meta
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const Dir = ".meta"
+
+var ErrNotFound = errors.New("metadata not found")
+
+// File states. Files recorded before states existed, and files uploaded with
+// no post-processing configured, have no state and are ready.
+const (
+	StateProcessing  = "processing"
+	StateReady       = "ready"
+	StateQuarantined = "quarantined"
+	StateFailed      = "failed"
+)
+
+type File struct {
+	ID               string    `json:"id,omitempty"`
+	Name             string    `json:"name"`
+	OriginalName     string    `json:"original_name,omitempty"`
+	Size             int64     `json:"size"`
+	Checksum         string    `json:"checksum,omitempty"`
+	ContentType      string    `json:"content_type,omitempty"`
+	DetectedType     string    `json:"detected_type,omitempty"`
+	Width            int       `json:"width,omitempty"`
+	Height           int       `json:"height,omitempty"`
+	Loudness         *float64  `json:"loudness,omitempty"`
+	BlurHash         string    `json:"blurhash,omitempty"`
+	DominantColor    string    `json:"dominant_color,omitempty"`
+	PlaceholderError string    `json:"placeholder_error,omitempty"`
+	OriginalFormat   string    `json:"original_format,omitempty"`
+	ConversionError  string    `json:"conversion_error,omitempty"`
+	Tags             []string  `json:"tags,omitempty"`
+	Backend          string    `json:"backend,omitempty"`
+	Root             string    `json:"root,omitempty"`
+	State            string    `json:"state,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	Lock             *Lock     `json:"lock,omitempty"`
+	Hold             *Hold     `json:"hold,omitempty"`
+
+	AvailableFrom  *time.Time `json:"available_from,omitempty"`
+	AvailableUntil *time.Time `json:"available_until,omitempty"`
+}
+
+// CurrentState returns f's state, treating files without one as ready.
+func (f *File) CurrentState() string {
+	if f == nil || f.State == "" {
+		return StateReady
+	}
+	return f.State
+}
+
+// Available reports whether now falls within f's availability window,
+// widened by skew on both ends. Files without a window are always available.
+func (f *File) Available(now time.Time, skew time.Duration) bool {
+	if f == nil {
+		return true
+	}
+	if f.AvailableFrom != nil && now.Before(f.AvailableFrom.Add(-skew)) {
+		return false
+	}
+	return f.AvailableUntil == nil || now.Before(f.AvailableUntil.Add(skew))
+}
+
+type Lock struct {
+	Token     string    `json:"token"`
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (l *Lock) Active() bool {
+	return l != nil && time.Now().Before(l.ExpiresAt)
+}
+
+type Hold struct {
+	Reason   string     `json:"reason,omitempty"`
+	PlacedBy string     `json:"placed_by"`
+	PlacedAt time.Time  `json:"placed_at"`
+	Until    *time.Time `json:"until,omitempty"`
+}
+
+// Active reports whether the hold still applies. A hold without an until
+// time lasts until it is released.
+func (h *Hold) Active() bool {
+	return h != nil && (h.Until == nil || time.Now().Before(*h.Until))
+}
+
+type Store struct {
+	mu       sync.RWMutex
+	root     string
+	foldCase atomic.Bool
+}
+
+func New(savePath string) *Store {
+	return &Store{
+		root: filepath.Join(savePath, Dir),
+	}
+}
+
+// FoldCase keys metadata by lowercased name, so that on a case-insensitive
+// save path names differing only in case share one record.
+func (s *Store) FoldCase(fold bool) {
+	s.foldCase.Store(fold)
+}
+
+func (s *Store) path(name string) string {
+	if s.foldCase.Load() {
+		name = strings.ToLower(name)
+	}
+	return filepath.Join(s.root, name+".json")
+}
+
+func (s *Store) Get(name string) (*File, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.read(name)
+}
+
+func (s *Store) read(name string) (*File, error) {
+	data, err := os.ReadFile(s.path(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := &File{}
+	if err = json.Unmarshal(data, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Put saves f, assigning it an ID first if it has none. The ID is indexed
+// so Resolve can find the file by it.
+func (s *Store) Put(f *File) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f.ID == "" {
+		f.ID = NewID()
+	}
+	if prev, err := s.read(f.Name); err == nil && prev.ID != "" && prev.ID != f.ID {
+		s.unindex(prev.ID, f.Name)
+	}
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	path := s.path(f.Name)
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return s.index(f.ID, f.Name)
+}
+
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f, err := s.read(name); err == nil && f.ID != "" {
+		s.unindex(f.ID, f.Name)
+	}
+	if err := os.Remove(s.path(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *Store) Walk(fn func(*File) error) error {
+	err := filepath.WalkDir(
+		s.root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || !strings.HasSuffix(path, ".json") {
+				return nil
+			}
+
+			rel, err := filepath.Rel(s.root, strings.TrimSuffix(path, ".json"))
+			if err != nil {
+				return err
+			}
+
+			f, err := s.Get(filepath.ToSlash(rel))
+			if err != nil {
+				return nil
+			}
+			return fn(f)
+		},
+	)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,135 @@
+package meta
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TombstonesFile has no .json suffix so Walk does not mistake it for a
+// file's metadata.
+const TombstonesFile = ".tombstones"
+
+// Tombstone records that the file Name was deleted, so sync clients can
+// tell a file deleted on the server from one that never existed. Seq is the
+// change journal entry of the delete.
+type Tombstone struct {
+	Name      string    `json:"name"`
+	Seq       uint64    `json:"seq"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// Tombstones indexes the tombstones of deleted files by name. The index is
+// written out on every change.
+type Tombstones struct {
+	mu    sync.Mutex
+	path  string
+	items map[string]Tombstone
+}
+
+func OpenTombstones(savePath string) (*Tombstones, error) {
+	t := &Tombstones{
+		path:  filepath.Join(savePath, Dir, TombstonesFile),
+		items: map[string]Tombstone{},
+	}
+
+	data, err := os.ReadFile(t.path)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Tombstone
+	if err = json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, ts := range list {
+		t.items[ts.Name] = ts
+	}
+	return t, nil
+}
+
+// Add records ts, replacing any tombstone of the same name.
+func (t *Tombstones) Add(ts Tombstone) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.items[ts.Name] = ts
+	return t.save()
+}
+
+// Remove drops the tombstone of name, once a file is stored under it again.
+func (t *Tombstones) Remove(name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.items[name]; !ok {
+		return nil
+	}
+	delete(t.items, name)
+	return t.save()
+}
+
+func (t *Tombstones) Get(name string) (Tombstone, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ts, ok := t.items[name]
+	return ts, ok
+}
+
+// Since returns the tombstones of deletes journaled after seq, oldest first.
+func (t *Tombstones) Since(seq uint64) []Tombstone {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := make([]Tombstone, 0)
+	for _, ts := range t.items {
+		if ts.Seq > seq {
+			res = append(res, ts)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Seq < res[j].Seq })
+	return res
+}
+
+// Prune drops the tombstones of files deleted before cutoff and returns how
+// many it dropped.
+func (t *Tombstones) Prune(cutoff time.Time) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := len(t.items)
+	for name, ts := range t.items {
+		if ts.DeletedAt.Before(cutoff) {
+			delete(t.items, name)
+		}
+	}
+	if pruned := n - len(t.items); pruned > 0 {
+		return pruned, t.save()
+	}
+	return 0, nil
+}
+
+func (t *Tombstones) save() error {
+	list := make([]Tombstone, 0, len(t.items))
+	for _, ts := range t.items {
+		list = append(list, ts)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(t.path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := t.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.path)
+}
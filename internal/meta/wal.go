@@ -0,0 +1,157 @@
+package meta
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const WALFile = ".wal.jsonl"
+
+// Catalog mutations recorded by the write-ahead log.
+const (
+	OpPut    = "put"
+	OpDelete = "delete"
+)
+
+// Intent is a catalog mutation recorded before the file it concerns is
+// written or removed, and marked done once both are. An intent still
+// pending at startup means the process stopped in between, so its name
+// has to be reconciled against the file system.
+type Intent struct {
+	Seq  uint64    `json:"seq"`
+	Op   string    `json:"op,omitempty"`
+	Name string    `json:"name,omitempty"`
+	File *File     `json:"file,omitempty"`
+	Time time.Time `json:"time,omitempty"`
+	Done bool      `json:"done,omitempty"`
+}
+
+// WAL is the write-ahead log of catalog mutations. The file is emptied
+// whenever no intent is pending, so it only ever holds the few that are in
+// flight.
+type WAL struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	durable bool
+	last    uint64
+	pending map[uint64]Intent
+}
+
+// OpenWAL opens the log under savePath and loads the intents a previous
+// run left pending. With durable, each intent is synced to disk before
+// Begin returns.
+func OpenWAL(savePath string, durable bool) (*WAL, error) {
+	path := filepath.Join(savePath, Dir, WALFile)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	w := &WAL{path: path, durable: durable, pending: make(map[uint64]Intent)}
+	if err := w.load(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	w.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	return w, err
+}
+
+func (w *WAL) load() error {
+	file, err := os.Open(w.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// A line cut short by a crash fails to decode and is skipped: its
+	// intent was never acted on.
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		in := Intent{}
+		if err = json.Unmarshal(scanner.Bytes(), &in); err != nil {
+			continue
+		}
+
+		w.last = max(w.last, in.Seq)
+		if in.Done {
+			delete(w.pending, in.Seq)
+			continue
+		}
+		w.pending[in.Seq] = in
+	}
+	return scanner.Err()
+}
+
+// Pending returns the intents not marked done, oldest first.
+func (w *WAL) Pending() []Intent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	res := make([]Intent, 0, len(w.pending))
+	for _, in := range w.pending {
+		res = append(res, in)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Seq < res[j].Seq })
+	return res
+}
+
+// Begin records that op is about to be applied to name, with f the entry a
+// put will save.
+func (w *WAL) Begin(op, name string, f *File) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	in := Intent{Seq: w.last + 1, Op: op, Name: name, File: f, Time: time.Now().UTC()}
+	if err := w.write(in); err != nil {
+		return 0, err
+	}
+	if w.durable {
+		if err := w.file.Sync(); err != nil {
+			return 0, err
+		}
+	}
+
+	w.last = in.Seq
+	w.pending[in.Seq] = in
+	return in.Seq, nil
+}
+
+// Done marks the intent seq as applied.
+func (w *WAL) Done(seq uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.pending[seq]; !ok {
+		return nil
+	}
+	delete(w.pending, seq)
+	if len(w.pending) == 0 {
+		return w.file.Truncate(0)
+	}
+	return w.write(Intent{Seq: seq, Done: true})
+}
+
+func (w *WAL) write(in Intent) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	_, err = w.file.Write(append(data, '\n'))
+	return err
+}
+
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
@@ -0,0 +1,134 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	VersionFile = ".version"
+	LockFile    = ".migrate.lock"
+)
+
+var (
+	ErrNewerVersion = errors.New("save path was written by a newer version")
+	ErrLocked       = errors.New("another instance is migrating the save path")
+)
+
+// Step upgrades the save path from the previous version to Version. Steps
+// must be idempotent: an interrupted run repeats the step it stopped in.
+type Step struct {
+	Version int
+	Name    string
+	Run     func(root string) error
+}
+
+// Latest is the on-disk version this build writes.
+func Latest() int {
+	return steps[len(steps)-1].Version
+}
+
+// Version returns the on-disk version recorded in root, or 0 when the save
+// path predates versioning.
+func Version(root string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(root, VersionFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("parsing %s: %w", VersionFile, err)
+	}
+	return v, nil
+}
+
+func setVersion(root string, v int) error {
+	path := filepath.Join(root, VersionFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(v)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Pending returns the steps root still needs, in order. It refuses save
+// paths written by a newer build rather than risk misreading them.
+func Pending(root string) ([]Step, error) {
+	v, err := Version(root)
+	if err != nil {
+		return nil, err
+	}
+	if v > Latest() {
+		return nil, fmt.Errorf("%w: on-disk version %d, this build supports %d", ErrNewerVersion, v, Latest())
+	}
+
+	for i, s := range steps {
+		if s.Version > v {
+			return steps[i:], nil
+		}
+	}
+	return nil, nil
+}
+
+// Run applies the pending steps to root, recording the version after each
+// one so a crash resumes where it stopped. A lock file keeps two instances
+// from migrating the same save path at once.
+func Run(root string) error {
+	pending, err := Pending(root)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+
+	unlock, err := lock(root)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Another instance may have finished while we waited for the lock.
+	if pending, err = Pending(root); err != nil || len(pending) == 0 {
+		return err
+	}
+
+	start := time.Now()
+	for i, s := range pending {
+		log.Printf("Applying migration %d/%d: %d %s\n", i+1, len(pending), s.Version, s.Name)
+		if err = s.Run(root); err != nil {
+			return fmt.Errorf("migration %d %s: %w", s.Version, s.Name, err)
+		}
+		if err = setVersion(root, s.Version); err != nil {
+			return fmt.Errorf("recording version %d: %w", s.Version, err)
+		}
+	}
+	log.Printf("Migrated save path to version %d in %s\n", Latest(), time.Since(start))
+	return nil
+}
+
+func lock(root string) (func(), error) {
+	path := filepath.Join(root, LockFile)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		holder, _ := os.ReadFile(path)
+		return nil, fmt.Errorf(
+			"%w: lock held by pid %s (remove %s if that process is gone)",
+			ErrLocked, strings.TrimSpace(string(holder)), path,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintln(f, os.Getpid())
+	f.Close()
+	return func() { os.Remove(path) }, nil
+}
@@ -0,0 +1,105 @@
+package migrate
+
+import (
+	"errors"
+	"github.com/JMURv/media-server/internal/meta"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	t.Run(
+		"Legacy save paths are upgraded", func(t *testing.T) {
+			root := t.TempDir()
+			assert.Nil(t, os.MkdirAll(filepath.Join(root, "docs"), os.ModePerm))
+			assert.Nil(t, os.WriteFile(filepath.Join(root, "docs", "a.txt"), []byte("hello"), 0644))
+			assert.Nil(t, os.WriteFile(filepath.Join(root, ".hidden"), []byte("x"), 0644))
+
+			// Sidecars written before IDs existed carry none.
+			assert.Nil(t, os.WriteFile(filepath.Join(root, "b.png"), []byte("png"), 0644))
+			assert.Nil(t, os.MkdirAll(filepath.Join(root, meta.Dir), os.ModePerm))
+			assert.Nil(t, os.WriteFile(filepath.Join(root, meta.Dir, "b.png.json"), []byte(`{"name":"b.png"}`), 0644))
+			store := meta.New(root)
+
+			pending, err := Pending(root)
+			assert.Nil(t, err)
+			assert.Len(t, pending, len(steps))
+
+			assert.Nil(t, Run(root))
+			v, err := Version(root)
+			assert.Nil(t, err)
+			assert.Equal(t, Latest(), v)
+			assert.NoFileExists(t, filepath.Join(root, LockFile))
+
+			m, err := store.Get("docs/a.txt")
+			assert.Nil(t, err)
+			assert.Equal(t, int64(5), m.Size)
+			assert.True(t, meta.ValidID(m.ID))
+			name, err := store.Resolve(m.ID)
+			assert.Nil(t, err)
+			assert.Equal(t, "docs/a.txt", name)
+
+			m, err = store.Get("b.png")
+			assert.Nil(t, err)
+			assert.True(t, meta.ValidID(m.ID))
+
+			_, err = store.Get(".hidden")
+			assert.True(t, errors.Is(err, meta.ErrNotFound))
+		},
+	)
+
+	t.Run(
+		"Steps are idempotent", func(t *testing.T) {
+			root := t.TempDir()
+			assert.Nil(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0644))
+			assert.Nil(t, Run(root))
+
+			m, _ := meta.New(root).Get("a.txt")
+			for _, s := range steps {
+				assert.Nil(t, s.Run(root), s.Name)
+			}
+			again, _ := meta.New(root).Get("a.txt")
+			assert.Equal(t, m.ID, again.ID)
+
+			pending, err := Pending(root)
+			assert.Nil(t, err)
+			assert.Empty(t, pending)
+		},
+	)
+
+	t.Run(
+		"Interrupted runs resume", func(t *testing.T) {
+			root := t.TempDir()
+			assert.Nil(t, setVersion(root, 1))
+			pending, err := Pending(root)
+			assert.Nil(t, err)
+			assert.Equal(t, 2, pending[0].Version)
+		},
+	)
+
+	t.Run(
+		"Newer on-disk versions are refused", func(t *testing.T) {
+			root := t.TempDir()
+			assert.Nil(t, os.WriteFile(filepath.Join(root, VersionFile), []byte(strconv.Itoa(Latest()+1)), 0644))
+			_, err := Pending(root)
+			assert.True(t, errors.Is(err, ErrNewerVersion))
+			assert.True(t, errors.Is(Run(root), ErrNewerVersion))
+		},
+	)
+
+	t.Run(
+		"Concurrent migrations are locked out", func(t *testing.T) {
+			root := t.TempDir()
+			assert.Nil(t, os.WriteFile(filepath.Join(root, LockFile), []byte("4242\n"), 0644))
+			err := Run(root)
+			assert.True(t, errors.Is(err, ErrLocked))
+			assert.Contains(t, err.Error(), "4242")
+
+			v, _ := Version(root)
+			assert.Zero(t, v)
+		},
+	)
+}
@@ -0,0 +1,76 @@
+package migrate
+
+import (
+	"errors"
+	"github.com/JMURv/media-server/internal/meta"
+	"io/fs"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// steps lists every migration in version order. New steps are appended with
+// the next version; released steps are never edited or removed.
+var steps = []Step{
+	{Version: 1, Name: "record metadata for files uploaded before sidecars existed", Run: recordMetadata},
+	{Version: 2, Name: "assign IDs to metadata written before IDs existed", Run: assignIDs},
+}
+
+func recordMetadata(root string) error {
+	store := meta.New(root)
+	return filepath.WalkDir(
+		root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path == root {
+				return nil
+			}
+			if strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			name := filepath.ToSlash(rel)
+			if _, err = store.Get(name); !errors.Is(err, meta.ErrNotFound) {
+				return err
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return store.Put(
+				&meta.File{
+					Name:         name,
+					OriginalName: name,
+					Size:         info.Size(),
+					ContentType:  mime.TypeByExtension(filepath.Ext(name)),
+					CreatedAt:    info.ModTime().UTC(),
+					UpdatedAt:    info.ModTime().UTC(),
+				},
+			)
+		},
+	)
+}
+
+func assignIDs(root string) error {
+	store := meta.New(root)
+	return store.Walk(
+		func(f *meta.File) error {
+			if f.ID != "" {
+				return nil
+			}
+			return store.Put(f)
+		},
+	)
+}
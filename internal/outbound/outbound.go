@@ -0,0 +1,203 @@
+// Package outbound builds the HTTP clients server-side requests, such as
+// webhook deliveries and replication, are made with, so proxy, TLS and
+// source address settings apply to all of them alike.
+package outbound
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/pkg/config"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	Webhooks    = "webhooks"
+	Replication = "replication"
+	Validation  = "validation"
+)
+
+const (
+	ProxyEnvironment = "environment"
+	ProxyDirect      = "direct"
+)
+
+// Timeouts are the default request timeouts of each feature.
+var Timeouts = map[string]time.Duration{
+	Webhooks:    10 * time.Second,
+	Replication: 30 * time.Second,
+	Validation:  5 * time.Second,
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var ErrInvalidProxy = errors.New("invalid proxy url")
+var ErrInvalidCABundle = errors.New("invalid ca bundle")
+var ErrInvalidTLSVersion = errors.New("invalid tls version")
+var ErrInvalidSource = errors.New("invalid source address")
+var ErrUnknownFeature = errors.New("unknown feature")
+
+// Settings are the effective outbound settings.
+type Settings struct {
+	Proxy         string            `json:"proxy"`
+	CAFile        string            `json:"ca_file,omitempty"`
+	MinTLSVersion string            `json:"min_tls_version"`
+	SourceAddr    string            `json:"source_addr,omitempty"`
+	Timeouts      map[string]string `json:"timeouts"`
+}
+
+type Factory struct {
+	transport *http.Transport
+	timeouts  map[string]time.Duration
+	settings  Settings
+}
+
+// New validates cfg and returns a factory for clients configured by it. A
+// nil cfg uses the proxy from the environment, the system roots and TLS 1.2.
+func New(cfg *config.OutboundConfig) (*Factory, error) {
+	if cfg == nil {
+		cfg = &config.OutboundConfig{}
+	}
+
+	f := &Factory{
+		transport: http.DefaultTransport.(*http.Transport).Clone(),
+		timeouts:  make(map[string]time.Duration, len(Timeouts)),
+		settings:  Settings{Proxy: ProxyEnvironment, CAFile: cfg.CAFile, Timeouts: make(map[string]string)},
+	}
+	for feature, d := range Timeouts {
+		f.timeouts[feature] = d
+	}
+	for feature, d := range cfg.Timeouts {
+		if _, ok := Timeouts[feature]; !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownFeature, feature)
+		}
+		if d > 0 {
+			f.timeouts[feature] = d
+		}
+	}
+	for feature, d := range f.timeouts {
+		f.settings.Timeouts[feature] = d.String()
+	}
+
+	switch cfg.Proxy {
+	case "", ProxyEnvironment:
+		f.transport.Proxy = http.ProxyFromEnvironment
+	case ProxyDirect:
+		f.transport.Proxy = nil
+		f.settings.Proxy = ProxyDirect
+	default:
+		u, err := url.Parse(cfg.Proxy)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidProxy, cfg.Proxy)
+		}
+		f.transport.Proxy = http.ProxyURL(u)
+		f.settings.Proxy = u.Redacted()
+	}
+
+	f.settings.MinTLSVersion = cfg.MinTLSVersion
+	if f.settings.MinTLSVersion == "" {
+		f.settings.MinTLSVersion = "1.2"
+	}
+	version, ok := tlsVersions[f.settings.MinTLSVersion]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTLSVersion, cfg.MinTLSVersion)
+	}
+	f.transport.TLSClientConfig = &tls.Config{MinVersion: version}
+
+	if cfg.CAFile != "" {
+		pool, err := caPool(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		f.transport.TLSClientConfig.RootCAs = pool
+	}
+
+	ip, err := sourceIP(cfg.SourceAddr, cfg.Interface)
+	if err != nil {
+		return nil, err
+	}
+	if ip != nil {
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, LocalAddr: &net.TCPAddr{IP: ip}}
+		f.transport.DialContext = dialer.DialContext
+		f.settings.SourceAddr = ip.String()
+	}
+	return f, nil
+}
+
+// caPool returns the system roots plus the certificates in the PEM file at
+// path.
+func caPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCABundle, err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%w: no certificates in %s", ErrInvalidCABundle, path)
+	}
+	return pool, nil
+}
+
+// sourceIP returns the address outbound connections are bound to: addr, or
+// the first address of the network interface iface, preferring IPv4.
+func sourceIP(addr, iface string) (net.IP, error) {
+	switch {
+	case addr != "" && iface != "":
+		return nil, fmt.Errorf("%w: set sourceAddr or interface, not both", ErrInvalidSource)
+	case addr != "":
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSource, addr)
+		}
+		return ip, nil
+	case iface == "":
+		return nil, nil
+	}
+
+	i, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSource, err)
+	}
+	addrs, err := i.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSource, err)
+	}
+
+	var ips []net.IP
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok {
+			ips = append(ips, n.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("%w: interface %s has no addresses", ErrInvalidSource, iface)
+	}
+	sort.SliceStable(ips, func(a, b int) bool { return ips[a].To4() != nil && ips[b].To4() == nil })
+	return ips[0], nil
+}
+
+// Client returns a client for feature. A positive timeout, the feature's own
+// setting, takes precedence over the configured and default ones.
+func (f *Factory) Client(feature string, timeout time.Duration) *http.Client {
+	if timeout <= 0 {
+		timeout = f.timeouts[feature]
+	}
+	return &http.Client{Timeout: timeout, Transport: f.transport}
+}
+
+func (f *Factory) Settings() Settings {
+	return f.settings
+}
@@ -0,0 +1,140 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrEmpty = errors.New("queue is empty")
+
+type Queue struct {
+	dir    string
+	notify chan struct{}
+
+	mu   sync.Mutex
+	seqs []uint64
+	last uint64
+}
+
+func Open(dir string) (*Queue, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Queue{
+		dir:    dir,
+		notify: make(chan struct{}, 1),
+	}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if e.IsDir() || !ok {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		q.seqs = append(q.seqs, seq)
+		q.last = max(q.last, seq)
+	}
+
+	sort.Slice(q.seqs, func(i, j int) bool { return q.seqs[i] < q.seqs[j] })
+	return q, nil
+}
+
+func (q *Queue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d.json", seq))
+}
+
+func (q *Queue) Push(v any) (uint64, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+
+	q.mu.Lock()
+	seq := q.last + 1
+	tmp := q.path(seq) + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err == nil {
+		err = os.Rename(tmp, q.path(seq))
+	}
+	if err != nil {
+		q.mu.Unlock()
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	q.last = seq
+	q.seqs = append(q.seqs, seq)
+	q.mu.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return seq, nil
+}
+
+func (q *Queue) Peek(v any) (uint64, error) {
+	for {
+		q.mu.Lock()
+		if len(q.seqs) == 0 {
+			q.mu.Unlock()
+			return 0, ErrEmpty
+		}
+		seq := q.seqs[0]
+		q.mu.Unlock()
+
+		data, err := os.ReadFile(q.path(seq))
+		if err == nil {
+			err = json.Unmarshal(data, v)
+		}
+		if err == nil {
+			return seq, nil
+		}
+
+		log.Printf("Skipping corrupt queue entry %s: %s\n", q.path(seq), err)
+		if err = q.Pop(seq); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (q *Queue) Pop(seq uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.seqs) == 0 || q.seqs[0] != seq {
+		return nil
+	}
+
+	q.seqs = q.seqs[1:]
+	if err := os.Remove(q.path(seq)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.seqs)
+}
+
+func (q *Queue) Notify() <-chan struct{} {
+	return q.notify
+}
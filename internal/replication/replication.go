@@ -0,0 +1,358 @@
+package replication
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/internal/metrics"
+	"github.com/JMURv/media-server/internal/queue"
+	"github.com/JMURv/media-server/pkg/config"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OpPut    = "put"
+	OpDelete = "delete"
+
+	defaultTimeout    = 30 * time.Second
+	defaultMaxBackoff = time.Minute
+	initialBackoff    = time.Second
+)
+
+var ErrUnexpectedStatus = errors.New("unexpected status code from peer")
+
+type OpenFunc func(ctx context.Context, name string) (io.ReadCloser, error)
+
+type Event struct {
+	Op   string    `json:"op"`
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+}
+
+type Stats struct {
+	Pending   int    `json:"pending"`
+	LagSecs   int64  `json:"lag_seconds"`
+	Pushed    uint64 `json:"pushed"`
+	Errors    uint64 `json:"errors"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+type Diff struct {
+	Missing   []string `json:"missing"`
+	Different []string `json:"different"`
+	Extra     []string `json:"extra"`
+	Repaired  bool     `json:"repaired"`
+}
+
+type Replicator struct {
+	cfg    *config.ReplicationConfig
+	open   OpenFunc
+	client *http.Client
+	queue  *queue.Queue
+
+	mu        sync.Mutex
+	lastError string
+
+	pushed *metrics.Counter
+	errors *metrics.Counter
+}
+
+// New returns a replicator that pushes with client, or with a default one
+// when client is nil.
+func New(
+	cfg *config.ReplicationConfig, dir string, open OpenFunc, client *http.Client, reg *metrics.Registry,
+) (*Replicator, error) {
+	if cfg.PeerURL == "" {
+		return nil, errors.New("peer url is required")
+	}
+
+	if client == nil {
+		timeout := cfg.Timeout
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+		client = &http.Client{Timeout: timeout}
+	}
+
+	q, err := queue.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Replicator{
+		cfg:    cfg,
+		open:   open,
+		client: client,
+		queue:  q,
+		pushed: reg.Counter("replication_pushed_total", "Mutation events replayed to the peer."),
+		errors: reg.Counter("replication_errors_total", "Failed attempts to replay events to the peer."),
+	}
+	reg.GaugeFunc("replication_pending", "Events waiting to be replayed.", func() float64 { return float64(r.Stats().Pending) })
+	reg.GaugeFunc("replication_lag_seconds", "Age of the oldest pending event.", func() float64 { return float64(r.Stats().LagSecs) })
+	return r, nil
+}
+
+func (r *Replicator) Enqueue(op, name string) {
+	if _, err := r.queue.Push(&Event{Op: op, Name: name, Time: time.Now().UTC()}); err != nil {
+		log.Printf("Error persisting replication event for %s: %s\n", name, err)
+	}
+}
+
+func (r *Replicator) Stats() Stats {
+	r.mu.Lock()
+	res := Stats{
+		Pending:   r.queue.Len(),
+		Pushed:    r.pushed.Value(),
+		Errors:    r.errors.Value(),
+		LastError: r.lastError,
+	}
+	r.mu.Unlock()
+
+	head := &Event{}
+	if _, err := r.queue.Peek(head); err == nil {
+		res.LagSecs = int64(time.Since(head.Time).Seconds())
+	}
+	return res
+}
+
+func (r *Replicator) Run(ctx context.Context) {
+	maxBackoff := r.cfg.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+
+	backoff := min(initialBackoff, maxBackoff)
+	for {
+		ev := &Event{}
+		seq, err := r.queue.Peek(ev)
+		if err != nil {
+			if !errors.Is(err, queue.ErrEmpty) {
+				log.Printf("Error reading replication queue: %s\n", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.queue.Notify():
+			case <-time.After(maxBackoff):
+			}
+			continue
+		}
+
+		if err := r.push(ctx, ev); err != nil {
+			r.errors.Inc()
+			r.mu.Lock()
+			r.lastError = err.Error()
+			r.mu.Unlock()
+			log.Printf("Replication of %s %s failed, retrying in %s: %s\n", ev.Op, ev.Name, backoff, err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxBackoff)
+			continue
+		}
+
+		r.pushed.Inc()
+		backoff = min(initialBackoff, maxBackoff)
+
+		r.mu.Lock()
+		r.lastError = ""
+		r.mu.Unlock()
+
+		if err = r.queue.Pop(seq); err != nil {
+			log.Printf("Error removing replication event %d: %s\n", seq, err)
+		}
+	}
+}
+
+func (r *Replicator) do(req *http.Request) (*http.Response, error) {
+	if r.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.cfg.Token)
+	}
+	return r.client.Do(req)
+}
+
+func (r *Replicator) push(ctx context.Context, ev *Event) error {
+	if ev.Op == OpDelete {
+		return r.remove(ctx, ev.Name)
+	}
+
+	status, err := r.upload(ctx, ev.Name)
+	if err != nil || status != http.StatusConflict {
+		return err
+	}
+
+	if err = r.remove(ctx, ev.Name); err != nil {
+		return err
+	}
+	if status, err = r.upload(ctx, ev.Name); err == nil && status == http.StatusConflict {
+		return ErrUnexpectedStatus
+	}
+	return err
+}
+
+func (r *Replicator) upload(ctx context.Context, name string) (int, error) {
+	file, err := r.open(ctx, name)
+	if os.IsNotExist(err) {
+		return http.StatusOK, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	go func() {
+		part, err := writer.CreateFormFile("file", name)
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cfg.PeerURL+"/upload", pr)
+	if err != nil {
+		pr.Close()
+		return 0, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	res, err := r.do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusConflict:
+		return res.StatusCode, nil
+	default:
+		return res.StatusCode, fmt.Errorf("%w: %d", ErrUnexpectedStatus, res.StatusCode)
+	}
+}
+
+func (r *Replicator) remove(ctx context.Context, name string) error {
+	u := r.cfg.PeerURL + "/delete?filename=" + url.QueryEscape(name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("%w: %d", ErrUnexpectedStatus, res.StatusCode)
+	}
+	return nil
+}
+
+func (r *Replicator) remoteChecksums(ctx context.Context) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.cfg.PeerURL+"/admin/export", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := r.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %d", ErrUnexpectedStatus, res.StatusCode)
+	}
+
+	tr := tar.NewReader(res.Body)
+	if _, err = tr.Next(); err != nil {
+		return nil, err
+	}
+
+	manifest := struct {
+		Files []struct {
+			Name     string `json:"name"`
+			Checksum string `json:"checksum"`
+		} `json:"files"`
+	}{}
+	if err = json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, err
+	}
+
+	sums := make(map[string]string, len(manifest.Files))
+	for _, f := range manifest.Files {
+		sums[f.Name] = f.Checksum
+	}
+	return sums, nil
+}
+
+func (r *Replicator) Reconcile(ctx context.Context, local map[string]string, repair bool) (*Diff, error) {
+	remote, err := r.remoteChecksums(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &Diff{
+		Missing:   make([]string, 0),
+		Different: make([]string, 0),
+		Extra:     make([]string, 0),
+		Repaired:  repair,
+	}
+	for name, sum := range local {
+		if strings.Contains(name, "/") {
+			continue
+		}
+
+		rsum, ok := remote[name]
+		switch {
+		case !ok:
+			diff.Missing = append(diff.Missing, name)
+		case rsum != sum:
+			diff.Different = append(diff.Different, name)
+		}
+	}
+	for name := range remote {
+		if _, ok := local[name]; !ok && !strings.Contains(name, "/") {
+			diff.Extra = append(diff.Extra, name)
+		}
+	}
+
+	sort.Strings(diff.Missing)
+	sort.Strings(diff.Different)
+	sort.Strings(diff.Extra)
+
+	if repair {
+		for _, name := range append(diff.Missing, diff.Different...) {
+			r.Enqueue(OpPut, name)
+		}
+		for _, name := range diff.Extra {
+			r.Enqueue(OpDelete, name)
+		}
+	}
+
+	log.Printf(
+		"Replication reconcile: %d missing, %d different, %d extra\n",
+		len(diff.Missing), len(diff.Different), len(diff.Extra),
+	)
+	return diff, nil
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// CaseInsensitive reports whether b treats names that differ only in case as
+// one object, as the default filesystems of macOS and Windows do. It writes
+// a probe object and opens it again under a lowercased name.
+func CaseInsensitive(ctx context.Context, b Backend) (bool, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return false, err
+	}
+	probe := ".CaseProbe-" + hex.EncodeToString(suffix)
+
+	if _, err := b.Put(ctx, probe, strings.NewReader("")); err != nil {
+		return false, err
+	}
+	defer b.Remove(context.WithoutCancel(ctx), probe)
+
+	file, err := b.Open(ctx, strings.ToLower(probe))
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	file.Close()
+	return true, nil
+}
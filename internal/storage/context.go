@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Canceled returns nil while ctx is live. Once it is done, the error matches
+// context.Canceled or context.DeadlineExceeded and, when ctx was cancelled
+// with a cause, the cause as well.
+func Canceled(ctx context.Context) error {
+	err := ctx.Err()
+	if err == nil {
+		return nil
+	}
+	if cause := context.Cause(ctx); cause != err {
+		return fmt.Errorf("%w: %w", err, cause)
+	}
+	return err
+}
+
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// Reader returns r failing with Canceled(ctx) once ctx is done. It is checked
+// on every read, so a copy stops within one buffer of cancellation.
+func Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &ctxReader{ctx: ctx, r: r}
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := Canceled(r.ctx); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+type Local struct {
+	root string
+}
+
+func NewLocal(root string) *Local {
+	return &Local{
+		root: root,
+	}
+}
+
+func (l *Local) path(name string) (string, error) {
+	if name == "" || !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return filepath.Join(l.root, filepath.FromSlash(name)), nil
+}
+
+func (l *Local) Open(ctx context.Context, name string) (io.ReadSeekCloser, error) {
+	if err := Canceled(ctx); err != nil {
+		return nil, err
+	}
+	path, err := l.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("open %s: %w", name, ErrNotFound)
+	}
+	return file, err
+}
+
+func (l *Local) Put(ctx context.Context, name string, r io.Reader) (int64, error) {
+	path, err := l.path(name)
+	if err != nil {
+		return 0, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".put-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, Reader(ctx, r))
+	if err = errors.Join(err, tmp.Close()); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(tmp.Name(), path)
+}
+
+func (l *Local) Remove(ctx context.Context, name string) error {
+	if err := Canceled(ctx); err != nil {
+		return err
+	}
+	path, err := l.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("remove %s: %w", name, ErrNotFound)
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Memory keeps objects in memory. It is meant for tests and for embedders
+// that need a scratch backend, and can mimic a case-insensitive filesystem.
+type Memory struct {
+	caseInsensitive bool
+
+	mu      sync.RWMutex
+	objects map[string][]byte
+}
+
+func NewMemory(caseInsensitive bool) *Memory {
+	return &Memory{
+		caseInsensitive: caseInsensitive,
+		objects:         make(map[string][]byte),
+	}
+}
+
+func (m *Memory) key(name string) (string, error) {
+	if name == "" || !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	if m.caseInsensitive {
+		return strings.ToLower(name), nil
+	}
+	return name, nil
+}
+
+type memoryObject struct {
+	*bytes.Reader
+}
+
+func (memoryObject) Close() error {
+	return nil
+}
+
+func (m *Memory) Open(ctx context.Context, name string) (io.ReadSeekCloser, error) {
+	if err := Canceled(ctx); err != nil {
+		return nil, err
+	}
+	key, err := m.key(name)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	data, ok := m.objects[key]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("open %s: %w", name, ErrNotFound)
+	}
+	return memoryObject{bytes.NewReader(data)}, nil
+}
+
+func (m *Memory) Put(ctx context.Context, name string, r io.Reader) (int64, error) {
+	key, err := m.key(name)
+	if err != nil {
+		return 0, err
+	}
+	data, err := io.ReadAll(Reader(ctx, r))
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	m.objects[key] = data
+	m.mu.Unlock()
+	return int64(len(data)), nil
+}
+
+func (m *Memory) Remove(ctx context.Context, name string) error {
+	if err := Canceled(ctx); err != nil {
+		return err
+	}
+	key, err := m.key(name)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.objects[key]; !ok {
+		return fmt.Errorf("remove %s: %w", name, ErrNotFound)
+	}
+	delete(m.objects, key)
+	return nil
+}
@@ -0,0 +1,348 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/internal/metrics"
+	"github.com/JMURv/media-server/pkg/config"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	TypeHTTP = "http"
+
+	defaultReadAhead = 1 << 20
+)
+
+// ErrChanged is returned by reads from a remote object that was replaced
+// after it was opened.
+var ErrChanged = errors.New("object changed while reading")
+
+type remoteMetrics struct {
+	gets  *metrics.Counter
+	saved *metrics.Counter
+	bytes *metrics.Counter
+}
+
+func newRemoteMetrics(reg *metrics.Registry) *remoteMetrics {
+	return &remoteMetrics{
+		gets:  reg.Counter("remote_gets_total", "GET requests made to remote backends."),
+		saved: reg.Counter("remote_gets_saved_total", "Remote opens served from the spill cache without a request."),
+		bytes: reg.Counter("remote_read_bytes_total", "Bytes downloaded from remote backends."),
+	}
+}
+
+// Remote is an http object store. Open returns a reader that turns seeks into
+// ranged GETs, revalidated against the ETag the object had when opened.
+type Remote struct {
+	url       string
+	headers   map[string]string
+	client    *http.Client
+	readAhead int
+	spill     *Spill
+	metrics   *remoteMetrics
+}
+
+func NewRemote(cfg *config.BackendConfig, spill *Spill, m *remoteMetrics) *Remote {
+	readAhead := int(cfg.ReadAhead)
+	if readAhead <= 0 {
+		readAhead = defaultReadAhead
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = cfg.Timeout
+
+	return &Remote{
+		url:       strings.TrimSuffix(cfg.URL, "/"),
+		headers:   cfg.Headers,
+		client:    &http.Client{Transport: transport},
+		readAhead: readAhead,
+		spill:     spill,
+		metrics:   m,
+	}
+}
+
+func (rb *Remote) objectURL(name string) (string, error) {
+	if name == "" || !fs.ValidPath(name) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	parts := strings.Split(name, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return rb.url + "/" + strings.Join(parts, "/"), nil
+}
+
+func (rb *Remote) do(ctx context.Context, method, u string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range rb.headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if method == http.MethodGet {
+		rb.metrics.gets.Inc()
+	}
+	return rb.client.Do(req)
+}
+
+func (rb *Remote) Open(ctx context.Context, name string) (io.ReadSeekCloser, error) {
+	if err := Canceled(ctx); err != nil {
+		return nil, err
+	}
+	u, err := rb.objectURL(name)
+	if err != nil {
+		return nil, err
+	}
+	if rb.spill != nil {
+		if f, ok := rb.spill.open(name); ok {
+			rb.metrics.saved.Inc()
+			return f, nil
+		}
+	}
+
+	resp, err := rb.do(ctx, http.MethodHead, u, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if err = statusError("open", name, resp); err != nil {
+		return nil, err
+	}
+
+	obj := &RemoteObject{
+		ctx:  ctx,
+		rb:   rb,
+		url:  u,
+		etag: resp.Header.Get("ETag"),
+		info: remoteInfo{name: path.Base(name), size: resp.ContentLength},
+	}
+	// If-Range only ever matches a strong validator.
+	if strings.HasPrefix(obj.etag, "W/") {
+		obj.etag = ""
+	}
+	if obj.info.size < 0 {
+		return nil, fmt.Errorf("open %s: remote reported no length", name)
+	}
+	obj.info.modTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	if rb.spill != nil && obj.info.size <= rb.spill.maxFileSize {
+		if f, err := rb.spill.store(name, obj); err == nil {
+			return f, nil
+		} else if errors.Is(err, ErrChanged) || Canceled(ctx) != nil {
+			return nil, err
+		}
+		// Spilling failed locally; stream from the remote instead.
+		obj.Seek(0, io.SeekStart)
+	}
+	return obj, nil
+}
+
+func (rb *Remote) Put(ctx context.Context, name string, r io.Reader) (int64, error) {
+	u, err := rb.objectURL(name)
+	if err != nil {
+		return 0, err
+	}
+	if rb.spill != nil {
+		rb.spill.forget(name)
+		defer rb.spill.forget(name)
+	}
+
+	body := &countingReader{r: Reader(ctx, r)}
+	resp, err := rb.do(ctx, http.MethodPut, u, body, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if err = statusError("put", name, resp); err != nil {
+		return 0, err
+	}
+	return body.n, nil
+}
+
+func (rb *Remote) Remove(ctx context.Context, name string) error {
+	if err := Canceled(ctx); err != nil {
+		return err
+	}
+	u, err := rb.objectURL(name)
+	if err != nil {
+		return err
+	}
+	if rb.spill != nil {
+		rb.spill.forget(name)
+	}
+
+	resp, err := rb.do(ctx, http.MethodDelete, u, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return statusError("remove", name, resp)
+}
+
+func statusError(op, name string, resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%s %s: %w", op, name, ErrNotFound)
+	case resp.StatusCode == http.StatusPreconditionFailed:
+		return fmt.Errorf("%s %s: %w", op, name, ErrChanged)
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("%s %s: remote responded %s", op, name, resp.Status)
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type remoteInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i remoteInfo) Name() string       { return i.name }
+func (i remoteInfo) Size() int64        { return i.size }
+func (i remoteInfo) Mode() fs.FileMode  { return 0444 }
+func (i remoteInfo) ModTime() time.Time { return i.modTime }
+func (i remoteInfo) IsDir() bool        { return false }
+func (i remoteInfo) Sys() any           { return nil }
+
+// RemoteObject reads a remote object through a read-ahead buffer. Sequential
+// reads share one GET; a seek outside the buffer starts another from the new
+// offset, so seeking back within the last buffer costs nothing. Every GET is
+// made with If-Range, so a replaced object fails with ErrChanged rather than
+// mixing old and new content.
+type RemoteObject struct {
+	ctx  context.Context
+	rb   *Remote
+	url  string
+	etag string
+	info remoteInfo
+
+	off    int64
+	buf    []byte
+	bufOff int64
+
+	body    io.ReadCloser
+	bodyOff int64
+}
+
+func (o *RemoteObject) Stat() (fs.FileInfo, error) {
+	return o.info, nil
+}
+
+func (o *RemoteObject) Read(p []byte) (int, error) {
+	if o.off >= o.info.size {
+		return 0, io.EOF
+	}
+	if o.off < o.bufOff || o.off >= o.bufOff+int64(len(o.buf)) {
+		if err := o.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, o.buf[o.off-o.bufOff:])
+	o.off += int64(n)
+	return n, nil
+}
+
+func (o *RemoteObject) fill() error {
+	if o.body == nil || o.bodyOff != o.off {
+		if err := o.get(); err != nil {
+			return err
+		}
+	}
+	if o.buf == nil {
+		o.buf = make([]byte, min(int64(o.rb.readAhead), o.info.size))
+	}
+
+	n, err := io.ReadFull(o.body, o.buf[:cap(o.buf)])
+	o.rb.metrics.bytes.Add(uint64(n))
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		o.closeBody()
+		err = nil
+		if n == 0 {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	o.buf, o.bufOff = o.buf[:n], o.off
+	o.bodyOff = o.off + int64(n)
+	return err
+}
+
+func (o *RemoteObject) get() error {
+	o.closeBody()
+
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-", o.off)}}
+	if o.etag != "" {
+		header.Set("If-Range", o.etag)
+	}
+	resp, err := o.rb.do(o.ctx, http.MethodGet, o.url, nil, header)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+	case resp.StatusCode == http.StatusOK && o.etag != "" && resp.Header.Get("ETag") != o.etag:
+		err = fmt.Errorf("read %s: %w", o.info.name, ErrChanged)
+	case resp.StatusCode == http.StatusOK && o.off == 0:
+	case resp.StatusCode == http.StatusOK:
+		err = fmt.Errorf("read %s: remote does not support ranges", o.info.name)
+	default:
+		err = statusError("read", o.info.name, resp)
+		if err == nil {
+			err = fmt.Errorf("read %s: remote responded %s", o.info.name, resp.Status)
+		}
+	}
+	if err != nil {
+		resp.Body.Close()
+		return err
+	}
+	o.body, o.bodyOff = resp.Body, o.off
+	return nil
+}
+
+func (o *RemoteObject) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.off
+	case io.SeekEnd:
+		offset += o.info.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("seek %s: negative position", o.info.name)
+	}
+	o.off = offset
+	return offset, nil
+}
+
+func (o *RemoteObject) closeBody() {
+	if o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+}
+
+func (o *RemoteObject) Close() error {
+	o.closeBody()
+	return nil
+}
@@ -0,0 +1,310 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/internal/metrics"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStore serves objects with ETags, so ranges and If-Range behave as they
+// do on an object store.
+type fakeStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	version int
+	gets    int
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{objects: make(map[string][]byte)}
+}
+
+func (s *fakeStore) set(name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[name] = data
+	s.version++
+}
+
+func (s *fakeStore) getCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gets
+}
+
+func (s *fakeStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		s.set(name, data)
+		return
+	case http.MethodDelete:
+		s.mu.Lock()
+		_, ok := s.objects[name]
+		delete(s.objects, name)
+		s.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		return
+	}
+
+	s.mu.Lock()
+	data, ok := s.objects[name]
+	version := s.version
+	if r.Method == http.MethodGet {
+		s.gets++
+	}
+	s.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("ETag", fmt.Sprintf(`"%d-%d"`, version, len(data)))
+	http.ServeContent(w, r, name, time.Unix(1700000000, 0), bytes.NewReader(data))
+}
+
+func TestRemote(t *testing.T) {
+	store := newFakeStore()
+	srv := httptest.NewServer(store)
+	defer srv.Close()
+
+	reg := metrics.NewRegistry()
+	m := newRemoteMetrics(reg)
+	remote := NewRemote(&config.BackendConfig{Type: TypeHTTP, URL: srv.URL + "/", ReadAhead: 16}, nil, m)
+	ctx := context.Background()
+
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	store.set("dir/object.bin", data)
+
+	readAt := func(f io.ReadSeeker, off int64, n int) []byte {
+		_, err := f.Seek(off, io.SeekStart)
+		assert.Nil(t, err)
+		buf := make([]byte, n)
+		_, err = io.ReadFull(f, buf)
+		assert.Nil(t, err)
+		return buf
+	}
+
+	t.Run(
+		"Sequential reads share one GET", func(t *testing.T) {
+			before := store.getCount()
+			f, err := remote.Open(ctx, "dir/object.bin")
+			assert.Nil(t, err)
+			defer f.Close()
+
+			size, err := f.Seek(0, io.SeekEnd)
+			assert.Nil(t, err)
+			assert.Equal(t, int64(100), size)
+			_, err = f.Seek(0, io.SeekStart)
+			assert.Nil(t, err)
+
+			got, err := io.ReadAll(f)
+			assert.Nil(t, err)
+			assert.Equal(t, data, got)
+			assert.Equal(t, 1, store.getCount()-before)
+		},
+	)
+
+	t.Run(
+		"Backward seeks", func(t *testing.T) {
+			f, err := remote.Open(ctx, "dir/object.bin")
+			assert.Nil(t, err)
+			defer f.Close()
+
+			before := store.getCount()
+			assert.Equal(t, data[0:10], readAt(f, 0, 10))
+			// Sniffing a type and seeking back stays within the buffer.
+			assert.Equal(t, data[0:10], readAt(f, 0, 10))
+			assert.Equal(t, data[4:12], readAt(f, 4, 8))
+			assert.Equal(t, 1, store.getCount()-before)
+
+			assert.Equal(t, data[40:60], readAt(f, 40, 20))
+			assert.Equal(t, data[2:30], readAt(f, 2, 28))
+			assert.Equal(t, data[95:100], readAt(f, 95, 5))
+			assert.Equal(t, 4, store.getCount()-before)
+
+			n, err := f.Read(make([]byte, 1))
+			assert.Zero(t, n)
+			assert.Equal(t, io.EOF, err)
+			_, err = f.Seek(-1, io.SeekStart)
+			assert.NotNil(t, err)
+		},
+	)
+
+	t.Run(
+		"Replaced objects fail If-Range", func(t *testing.T) {
+			f, err := remote.Open(ctx, "dir/object.bin")
+			assert.Nil(t, err)
+			defer f.Close()
+			assert.Equal(t, data[0:10], readAt(f, 0, 10))
+
+			changed := bytes.Repeat([]byte{0xff}, 100)
+			store.set("dir/object.bin", changed)
+			defer store.set("dir/object.bin", data)
+
+			// Still buffered, and read before the change.
+			assert.Equal(t, data[10:16], readAt(f, 10, 6))
+
+			_, err = f.Seek(50, io.SeekStart)
+			assert.Nil(t, err)
+			_, err = f.Read(make([]byte, 10))
+			assert.True(t, errors.Is(err, ErrChanged), err)
+
+			g, err := remote.Open(ctx, "dir/object.bin")
+			assert.Nil(t, err)
+			defer g.Close()
+			assert.Equal(t, changed[50:60], readAt(g, 50, 10))
+		},
+	)
+
+	t.Run(
+		"Stat", func(t *testing.T) {
+			f, err := remote.Open(ctx, "dir/object.bin")
+			assert.Nil(t, err)
+			defer f.Close()
+
+			info, err := f.(interface {
+				Stat() (fs.FileInfo, error)
+			}).Stat()
+			assert.Nil(t, err)
+			assert.Equal(t, "object.bin", info.Name())
+			assert.Equal(t, int64(100), info.Size())
+			assert.True(t, info.Mode().IsRegular())
+			assert.Equal(t, time.Unix(1700000000, 0).UTC(), info.ModTime().UTC())
+		},
+	)
+
+	t.Run(
+		"Put and remove", func(t *testing.T) {
+			n, err := remote.Put(ctx, "new.txt", strings.NewReader("hello"))
+			assert.Nil(t, err)
+			assert.Equal(t, int64(5), n)
+
+			f, err := remote.Open(ctx, "new.txt")
+			assert.Nil(t, err)
+			got, _ := io.ReadAll(f)
+			f.Close()
+			assert.Equal(t, "hello", string(got))
+
+			assert.Nil(t, remote.Remove(ctx, "new.txt"))
+			_, err = remote.Open(ctx, "new.txt")
+			assert.True(t, errors.Is(err, ErrNotFound), err)
+			assert.True(t, errors.Is(remote.Remove(ctx, "new.txt"), ErrNotFound))
+
+			_, err = remote.Open(ctx, "../escape")
+			assert.True(t, errors.Is(err, ErrInvalidName), err)
+		},
+	)
+}
+
+func TestSpill(t *testing.T) {
+	store := newFakeStore()
+	srv := httptest.NewServer(store)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	spill, err := NewSpill(&config.SpillConfig{Dir: dir, MaxSize: 20, MaxFileSize: 10})
+	assert.Nil(t, err)
+	reg := metrics.NewRegistry()
+	m := newRemoteMetrics(reg)
+	remote := NewRemote(&config.BackendConfig{Type: TypeHTTP, URL: srv.URL, ReadAhead: 4}, spill, m)
+	ctx := context.Background()
+
+	store.set("a.txt", []byte("aaaaaaaa"))
+	store.set("b.txt", []byte("bbbbbbbb"))
+	store.set("c.txt", []byte("cccccccc"))
+	store.set("large.bin", []byte("larger than ten bytes"))
+
+	read := func(name string) string {
+		f, err := remote.Open(ctx, name)
+		if !assert.Nil(t, err) {
+			return ""
+		}
+		defer f.Close()
+		data, err := io.ReadAll(f)
+		assert.Nil(t, err)
+		// Seeking back never reaches the remote either.
+		_, err = f.Seek(0, io.SeekStart)
+		assert.Nil(t, err)
+		return string(data)
+	}
+
+	t.Run(
+		"Small objects are served locally", func(t *testing.T) {
+			before := store.getCount()
+			assert.Equal(t, "aaaaaaaa", read("a.txt"))
+			assert.Equal(t, 1, store.getCount()-before)
+			assert.Equal(t, int64(8), spill.Size())
+
+			assert.Equal(t, "aaaaaaaa", read("a.txt"))
+			assert.Equal(t, "aaaaaaaa", read("a.txt"))
+			assert.Equal(t, 1, store.getCount()-before)
+			assert.Equal(t, uint64(2), m.saved.Value())
+		},
+	)
+
+	t.Run(
+		"Large objects are streamed", func(t *testing.T) {
+			before := store.getCount()
+			assert.Equal(t, "larger than ten bytes", read("large.bin"))
+			assert.Equal(t, "larger than ten bytes", read("large.bin"))
+			assert.Equal(t, 2, store.getCount()-before)
+			assert.Equal(t, int64(8), spill.Size())
+		},
+	)
+
+	t.Run(
+		"Least recently used are evicted", func(t *testing.T) {
+			read("b.txt")
+			read("a.txt")
+			read("c.txt")
+			assert.Equal(t, int64(16), spill.Size())
+
+			before := store.getCount()
+			read("a.txt")
+			read("c.txt")
+			assert.Equal(t, 0, store.getCount()-before)
+			read("b.txt")
+			assert.Equal(t, 1, store.getCount()-before)
+		},
+	)
+
+	t.Run(
+		"Writes through the backend replace the copy", func(t *testing.T) {
+			_, err := remote.Put(ctx, "a.txt", strings.NewReader("AAAA"))
+			assert.Nil(t, err)
+			assert.Equal(t, "AAAA", read("a.txt"))
+
+			assert.Nil(t, remote.Remove(ctx, "a.txt"))
+			_, err = remote.Open(ctx, "a.txt")
+			assert.True(t, errors.Is(err, ErrNotFound), err)
+		},
+	)
+
+	t.Run(
+		"Emptied at startup", func(t *testing.T) {
+			_, err := NewSpill(&config.SpillConfig{Dir: dir})
+			assert.Nil(t, err)
+			left, _ := filepath.Glob(filepath.Join(dir, "*"+spillExt))
+			assert.Empty(t, left)
+		},
+	)
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/pkg/config"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	defaultSpillSize     = 256 << 20
+	defaultSpillFileSize = 8 << 20
+
+	spillExt = ".spill"
+)
+
+type spillEntry struct {
+	name string
+	path string
+	info remoteInfo
+}
+
+// Spill keeps whole copies of small remote objects on local disk, evicting
+// the least recently used beyond its size. Entries are only dropped when the
+// object is replaced or removed through the backend, so changes made to the
+// remote directly are not seen until eviction or restart.
+type Spill struct {
+	dir         string
+	maxBytes    int64
+	maxFileSize int64
+
+	mu    sync.Mutex
+	size  int64
+	order *list.List
+	items map[string]*list.Element
+}
+
+// NewSpill empties the spill files left in cfg.Dir by a previous run, since
+// nothing tells whether their objects changed since.
+func NewSpill(cfg *config.SpillConfig) (*Spill, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("spill dir is required")
+	}
+	s := &Spill{
+		dir:         cfg.Dir,
+		maxBytes:    cfg.MaxSize,
+		maxFileSize: cfg.MaxFileSize,
+		order:       list.New(),
+		items:       make(map[string]*list.Element),
+	}
+	if s.maxBytes <= 0 {
+		s.maxBytes = defaultSpillSize
+	}
+	if s.maxFileSize <= 0 {
+		s.maxFileSize = defaultSpillFileSize
+	}
+	s.maxFileSize = min(s.maxFileSize, s.maxBytes)
+
+	if err := os.MkdirAll(s.dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	stale, err := filepath.Glob(filepath.Join(s.dir, "*"+spillExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range stale {
+		os.Remove(p)
+	}
+	return s, nil
+}
+
+// Size returns the bytes held in the spill directory.
+func (s *Spill) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+type spillFile struct {
+	*os.File
+	info remoteInfo
+}
+
+func (f spillFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (s *Spill) open(name string) (io.ReadSeekCloser, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[name]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*spillEntry)
+	f, err := os.Open(e.path)
+	if err != nil {
+		s.remove(el)
+		return nil, false
+	}
+	s.order.MoveToFront(el)
+	return spillFile{File: f, info: e.info}, true
+}
+
+// store downloads obj into the spill directory and opens the copy.
+func (s *Spill) store(name string, obj *RemoteObject) (io.ReadSeekCloser, error) {
+	sum := sha256.Sum256([]byte(name))
+	tmp, err := os.CreateTemp(s.dir, hex.EncodeToString(sum[:8])+"-*"+spillExt)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := io.Copy(tmp, obj)
+	obj.Close()
+	if err == nil && n != obj.info.size {
+		err = fmt.Errorf("spill %s: got %d of %d bytes", name, n, obj.info.size)
+	}
+	if _, serr := tmp.Seek(0, io.SeekStart); err == nil {
+		err = serr
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	e := &spillEntry{name: name, path: tmp.Name(), info: obj.info}
+	s.mu.Lock()
+	if el, ok := s.items[name]; ok {
+		s.remove(el)
+	}
+	s.items[name] = s.order.PushFront(e)
+	s.size += e.info.size
+	for s.size > s.maxBytes && s.order.Len() > 1 {
+		s.remove(s.order.Back())
+	}
+	s.mu.Unlock()
+	return spillFile{File: tmp, info: e.info}, nil
+}
+
+func (s *Spill) forget(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[name]; ok {
+		s.remove(el)
+	}
+}
+
+func (s *Spill) remove(el *list.Element) {
+	e := el.Value.(*spillEntry)
+	s.order.Remove(el)
+	delete(s.items, e.name)
+	s.size -= e.info.size
+	// Readers holding the file open keep reading it where unlinking an open
+	// file is allowed; elsewhere it is left for the next startup.
+	if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing spilled %s: %s\n", e.name, err)
+	}
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/JMURv/media-server/internal/metrics"
+	"github.com/JMURv/media-server/pkg/config"
+	"io"
+)
+
+const TypeLocal = "local"
+
+var ErrNotFound = errors.New("object not found")
+var ErrExists = errors.New("object already exists")
+var ErrTooLarge = errors.New("object too large")
+var ErrInvalidName = errors.New("invalid object name")
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+var ErrUnsupportedBackend = errors.New("unsupported backend type")
+
+type Backend interface {
+	Open(ctx context.Context, name string) (io.ReadSeekCloser, error)
+	Put(ctx context.Context, name string, r io.Reader) (int64, error)
+	Remove(ctx context.Context, name string) error
+}
+
+func NewBackends(cfg map[string]*config.BackendConfig, reg *metrics.Registry) (map[string]Backend, error) {
+	res := make(map[string]Backend, len(cfg))
+	var remote *remoteMetrics
+	var spills []*Spill
+	for name, c := range cfg {
+		switch c.Type {
+		case TypeLocal:
+			if c.Path == "" {
+				return nil, fmt.Errorf("backend %q: path is required", name)
+			}
+			res[name] = NewLocal(c.Path)
+		case TypeHTTP:
+			if c.URL == "" {
+				return nil, fmt.Errorf("backend %q: url is required", name)
+			}
+			var spill *Spill
+			if c.Spill != nil {
+				var err error
+				if spill, err = NewSpill(c.Spill); err != nil {
+					return nil, fmt.Errorf("backend %q: %w", name, err)
+				}
+				spills = append(spills, spill)
+			}
+			if remote == nil {
+				remote = newRemoteMetrics(reg)
+			}
+			res[name] = NewRemote(c, spill, remote)
+		default:
+			return nil, fmt.Errorf("backend %q: %w: %q", name, ErrUnsupportedBackend, c.Type)
+		}
+	}
+
+	if len(spills) > 0 {
+		reg.GaugeFunc(
+			"remote_spill_bytes", "Bytes of remote objects held in spill directories.", func() float64 {
+				var size int64
+				for _, s := range spills {
+					size += s.Size()
+				}
+				return float64(size)
+			},
+		)
+	}
+	return res, nil
+}
@@ -0,0 +1,238 @@
+// Package traffic counts the bytes each principal moves in and out, per UTC
+// day. Counts are kept in memory and flushed to one JSON file per day, so a
+// restart loses at most what was counted since the last flush.
+package traffic
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DayLayout is how days are written in file names and reports.
+const DayLayout = "2006-01-02"
+
+type Bytes struct {
+	Ingress int64 `json:"ingress"`
+	Egress  int64 `json:"egress"`
+}
+
+// Bucket is what Principal moved on Day.
+type Bucket struct {
+	Day       string `json:"day"`
+	Principal string `json:"principal"`
+	Bytes
+}
+
+// Counter counts the bytes of one principal on one day. It is safe to add
+// to from many requests at once.
+type Counter struct {
+	ingress atomic.Int64
+	egress  atomic.Int64
+}
+
+func (c *Counter) AddIngress(n int64) {
+	c.ingress.Add(n)
+}
+
+func (c *Counter) AddEgress(n int64) {
+	c.egress.Add(n)
+}
+
+type key struct {
+	day       string
+	principal string
+}
+
+type Meter struct {
+	dir string
+	now func() time.Time
+
+	mu       sync.Mutex
+	counters map[key]*Counter
+}
+
+func Open(dir string) *Meter {
+	return &Meter{dir: dir, now: time.Now, counters: make(map[key]*Counter)}
+}
+
+// Counter returns the counter of principal for today. A request keeps the
+// counter it started with, so its bytes count towards the day it began on.
+func (m *Meter) Counter(principal string) *Counter {
+	k := key{day: m.now().UTC().Format(DayLayout), principal: principal}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.counters[k]
+	if !ok {
+		c = &Counter{}
+		m.counters[k] = c
+	}
+	return c
+}
+
+func (m *Meter) path(day string) string {
+	return filepath.Join(m.dir, day+".json")
+}
+
+func (m *Meter) read(day string) (map[string]Bytes, error) {
+	data, err := os.ReadFile(m.path(day))
+	if os.IsNotExist(err) {
+		return map[string]Bytes{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]Bytes)
+	if err = json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (m *Meter) write(day string, counts map[string]Bytes) error {
+	data, err := json.Marshal(counts)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(m.dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp := m.path(day) + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path(day))
+}
+
+// Flush adds what was counted since the last flush to the day files. Counts
+// that fail to be written stay in memory for the next flush. Counters of
+// days before yesterday are dropped once flushed; requests still holding
+// one are not expected to run that long.
+func (m *Meter) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	yesterday := m.now().UTC().AddDate(0, 0, -1).Format(DayLayout)
+	pending := make(map[string]map[key]Bytes)
+	for k, c := range m.counters {
+		b := Bytes{Ingress: c.ingress.Swap(0), Egress: c.egress.Swap(0)}
+		if b.Ingress != 0 || b.Egress != 0 {
+			if pending[k.day] == nil {
+				pending[k.day] = make(map[key]Bytes)
+			}
+			pending[k.day][k] = b
+		}
+		if k.day < yesterday {
+			delete(m.counters, k)
+		}
+	}
+
+	var err error
+	for day, deltas := range pending {
+		if err = m.add(day, deltas); err != nil {
+			for k, b := range deltas {
+				c, ok := m.counters[k]
+				if !ok {
+					c = &Counter{}
+					m.counters[k] = c
+				}
+				c.AddIngress(b.Ingress)
+				c.AddEgress(b.Egress)
+			}
+		}
+	}
+	return err
+}
+
+func (m *Meter) add(day string, deltas map[key]Bytes) error {
+	counts, err := m.read(day)
+	if err != nil {
+		return err
+	}
+	for k, b := range deltas {
+		total := counts[k.principal]
+		total.Ingress += b.Ingress
+		total.Egress += b.Egress
+		counts[k.principal] = total
+	}
+	return m.write(day, counts)
+}
+
+// Report returns the bytes moved on each day from from to to, inclusive,
+// by principal or, when it is empty, by everyone. Buckets are ordered by
+// day, then principal, and days nothing moved on are left out. Counts not
+// flushed yet are included.
+func (m *Meter) Report(principal string, from, to time.Time) ([]Bucket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res := make([]Bucket, 0)
+	last := to.UTC().Format(DayLayout)
+	for d := from.UTC(); d.Format(DayLayout) <= last; d = d.AddDate(0, 0, 1) {
+		day := d.Format(DayLayout)
+		counts, err := m.read(day)
+		if err != nil {
+			return nil, err
+		}
+		for k, c := range m.counters {
+			if k.day != day {
+				continue
+			}
+			total := counts[k.principal]
+			total.Ingress += c.ingress.Load()
+			total.Egress += c.egress.Load()
+			counts[k.principal] = total
+		}
+
+		for name, b := range counts {
+			if (principal == "" || name == principal) && (b.Ingress != 0 || b.Egress != 0) {
+				res = append(res, Bucket{Day: day, Principal: name, Bytes: b})
+			}
+		}
+	}
+	slices.SortFunc(
+		res, func(a, b Bucket) int {
+			return strings.Compare(a.Day+"\x00"+a.Principal, b.Day+"\x00"+b.Principal)
+		},
+	)
+	return res, nil
+}
+
+// Prune removes the files of days before t and returns how many it removed.
+func (m *Meter) Prune(t time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries, err := os.ReadDir(m.dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := t.UTC().Format(DayLayout)
+	n := 0
+	for _, e := range entries {
+		day, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if _, err = time.Parse(DayLayout, day); err != nil || day >= cutoff {
+			continue
+		}
+		if err = os.Remove(filepath.Join(m.dir, e.Name())); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,186 @@
+package trash
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/JMURv/media-server/internal/meta"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ReasonExpired = "expired"
+	ReasonQuota   = "quota"
+)
+
+type Entry struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Size      int64      `json:"size"`
+	DeletedAt time.Time  `json:"deleted_at"`
+	Meta      *meta.File `json:"meta,omitempty"`
+}
+
+type Purged struct {
+	Entry
+	Reason string `json:"reason"`
+}
+
+// Store keeps deleted files under dir, each as <id> with its entry beside it
+// in <id>.json. Entries are indexed in memory, oldest-deleted first, so
+// purges never walk the directory.
+type Store struct {
+	dir string
+
+	// Move moves a deleted file into the trash, os.Rename when nil. Set it
+	// to a move that copies when files may live on other filesystems.
+	Move func(src, dst string) error
+
+	mu      sync.Mutex
+	entries []*Entry
+	bytes   int64
+}
+
+func Open(dir string) (*Store, error) {
+	s := &Store{dir: dir}
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		id, ok := strings.CutSuffix(f.Name(), ".json")
+		if !ok {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		e := &Entry{}
+		if err = json.Unmarshal(data, e); err != nil || e.ID != id {
+			continue
+		}
+		if _, err = os.Stat(filepath.Join(dir, id)); err != nil {
+			continue
+		}
+		s.entries = append(s.entries, e)
+		s.bytes += e.Size
+	}
+	slices.SortFunc(s.entries, func(a, b *Entry) int { return a.DeletedAt.Compare(b.DeletedAt) })
+	return s, nil
+}
+
+// Put moves the file at path into the trash as a deleted copy of name.
+func (s *Store) Put(name, path string, m *meta.File) (*Entry, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{
+		ID:        meta.NewID(),
+		Name:      name,
+		Size:      info.Size(),
+		DeletedAt: time.Now().UTC(),
+		Meta:      m,
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(s.dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	entry := filepath.Join(s.dir, e.ID+".json")
+	if err = os.WriteFile(entry, data, 0644); err != nil {
+		return nil, err
+	}
+	move := s.Move
+	if move == nil {
+		move = os.Rename
+	}
+	if err = move(path, filepath.Join(s.dir, e.ID)); err != nil {
+		os.Remove(entry)
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.entries = append(s.entries, e)
+	s.bytes += e.Size
+	s.mu.Unlock()
+	return e, nil
+}
+
+// Purge permanently removes the entries deleted before cutoff and then, when
+// the trash holds more than high bytes, the oldest entries until it holds at
+// most low. A zero cutoff or a negative high skips that step.
+func (s *Store) Purge(cutoff time.Time, high, low int64) ([]Purged, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var res []Purged
+	var errs []error
+	overQuota := high >= 0 && s.bytes > high
+	kept := s.entries[:0]
+	for _, e := range s.entries {
+		reason := ""
+		switch {
+		case !cutoff.IsZero() && e.DeletedAt.Before(cutoff):
+			reason = ReasonExpired
+		case overQuota && s.bytes > low:
+			reason = ReasonQuota
+		}
+		if reason == "" {
+			kept = append(kept, e)
+			continue
+		}
+
+		if err := s.remove(e.ID); err != nil {
+			errs = append(errs, err)
+			kept = append(kept, e)
+			continue
+		}
+		s.bytes -= e.Size
+		res = append(res, Purged{Entry: *e, Reason: reason})
+	}
+	clear(s.entries[len(kept):])
+	s.entries = kept
+	return res, errors.Join(errs...)
+}
+
+func (s *Store) remove(id string) error {
+	if err := os.Remove(filepath.Join(s.dir, id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(filepath.Join(s.dir, id+".json")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List returns the entries, most recently deleted first.
+func (s *Store) List() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]Entry, len(s.entries))
+	for i, e := range s.entries {
+		res[len(res)-1-i] = *e
+	}
+	return res
+}
+
+// Usage returns how many entries the trash holds and their total size.
+func (s *Store) Usage() (int, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries), s.bytes
+}
@@ -0,0 +1,116 @@
+package usage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const snapshotLayout = "20060102T150405Z"
+
+var ErrNoSnapshot = errors.New("no usage snapshot at or before the requested time")
+
+type Snapshot struct {
+	Time time.Time        `json:"time"`
+	Dirs map[string]Usage `json:"dirs"`
+}
+
+// Snapshots stores one JSON file per snapshot, named by its UTC time so
+// that names sort chronologically.
+type Snapshots struct {
+	dir string
+}
+
+func OpenSnapshots(dir string) *Snapshots {
+	return &Snapshots{dir: dir}
+}
+
+func (s *Snapshots) Save(snap *Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(s.dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.dir, snap.Time.UTC().Format(snapshotLayout)+".json")
+	if err = os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// times lists the snapshot times, oldest first.
+func (s *Snapshots) times() ([]time.Time, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var res []time.Time
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if t, err := time.Parse(snapshotLayout, name); err == nil {
+			res = append(res, t)
+		}
+	}
+	slices.SortFunc(res, func(a, b time.Time) int { return a.Compare(b) })
+	return res, nil
+}
+
+// At returns the latest snapshot taken at or before t.
+func (s *Snapshots) At(t time.Time) (*Snapshot, error) {
+	times, err := s.times()
+	if err != nil {
+		return nil, err
+	}
+
+	i, found := slices.BinarySearchFunc(times, t, func(a, b time.Time) int { return a.Compare(b) })
+	if !found {
+		i--
+	}
+	if i < 0 {
+		return nil, ErrNoSnapshot
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, times[i].Format(snapshotLayout)+".json"))
+	if err != nil {
+		return nil, err
+	}
+	snap := &Snapshot{}
+	if err = json.Unmarshal(data, snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// Prune removes snapshots taken before t and returns how many it removed.
+func (s *Snapshots) Prune(t time.Time) (int, error) {
+	times, err := s.times()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, st := range times {
+		if !st.Before(t) {
+			break
+		}
+		if err = os.Remove(filepath.Join(s.dir, st.Format(snapshotLayout)+".json")); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,129 @@
+// Package usage tracks how much storage each part of the save path uses.
+// The index keeps totals per directory, so grouped usage is computed from
+// directory counts rather than a walk of the tree; snapshots of it are kept
+// on disk for reporting past usage.
+package usage
+
+import (
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+type Usage struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+type Group struct {
+	Prefix string `json:"prefix"`
+	Usage
+}
+
+type Index struct {
+	mu    sync.RWMutex
+	files map[string]int64
+	dirs  map[string]Usage
+}
+
+func NewIndex() *Index {
+	return &Index{files: map[string]int64{}, dirs: map[string]Usage{}}
+}
+
+func dir(name string) string {
+	if d := path.Dir(name); d != "." {
+		return d
+	}
+	return ""
+}
+
+// Set records name, a slash-separated path, as size bytes.
+func (x *Index) Set(name string, size int64) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.remove(name)
+	x.files[name] = size
+	u := x.dirs[dir(name)]
+	u.Files++
+	u.Bytes += size
+	x.dirs[dir(name)] = u
+}
+
+func (x *Index) Remove(name string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(name)
+}
+
+func (x *Index) remove(name string) {
+	size, ok := x.files[name]
+	if !ok {
+		return
+	}
+	delete(x.files, name)
+
+	d := dir(name)
+	u := x.dirs[d]
+	u.Files--
+	u.Bytes -= size
+	if u.Files == 0 {
+		delete(x.dirs, d)
+		return
+	}
+	x.dirs[d] = u
+}
+
+// Reset replaces the index with files, as found by a full walk.
+func (x *Index) Reset(files map[string]int64) {
+	dirs := make(map[string]Usage)
+	for name, size := range files {
+		u := dirs[dir(name)]
+		u.Files++
+		u.Bytes += size
+		dirs[dir(name)] = u
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.files, x.dirs = files, dirs
+}
+
+// Dirs returns a copy of the usage of the files directly in each directory,
+// keyed by its slash-separated path; the root is "".
+func (x *Index) Dirs() map[string]Usage {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	res := make(map[string]Usage, len(x.dirs))
+	for d, u := range x.dirs {
+		res[d] = u
+	}
+	return res
+}
+
+// Groups sums per-directory usage under prefixes of depth path segments,
+// sorted by prefix. Files in shallower directories are grouped under their
+// own directory; depth 0 gives the total under "".
+func Groups(dirs map[string]Usage, depth int) []Group {
+	sums := make(map[string]Usage)
+	for d, u := range dirs {
+		prefix := d
+		if segs := strings.Split(d, "/"); d != "" && len(segs) > depth {
+			prefix = strings.Join(segs[:depth], "/")
+		}
+
+		sum := sums[prefix]
+		sum.Files += u.Files
+		sum.Bytes += u.Bytes
+		sums[prefix] = sum
+	}
+
+	res := make([]Group, 0, len(sums))
+	for prefix, u := range sums {
+		res = append(res, Group{Prefix: prefix, Usage: u})
+	}
+	slices.SortFunc(res, func(a, b Group) int { return strings.Compare(a.Prefix, b.Prefix) })
+	return res
+}
@@ -0,0 +1,154 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/JMURv/media-server/internal/metrics"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	dlqFile           = "dlq.json"
+	defaultDLQSize    = 1000
+	maxAttemptHistory = 20
+)
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+var ErrTargetRemoved = errors.New("webhook target is no longer configured")
+
+// Attempt is a single delivery attempt. Status is zero when no response
+// arrived.
+type Attempt struct {
+	Time           time.Time `json:"time"`
+	Status         int       `json:"status,omitempty"`
+	LatencySeconds float64   `json:"latency_seconds"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// DeadLetter is an event whose delivery to Target ran out of retries. The
+// event is kept as sent so a retry delivers, and signs, the same body.
+type DeadLetter struct {
+	ID         string          `json:"id"`
+	Target     string          `json:"target"`
+	Event      json.RawMessage `json:"event"`
+	Attempts   int             `json:"attempts"`
+	LastError  string          `json:"last_error"`
+	LastStatus int             `json:"last_status,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	FailedAt   time.Time       `json:"failed_at"`
+	History    []Attempt       `json:"history"`
+}
+
+func (d *DeadLetter) clone() *DeadLetter {
+	res := *d
+	res.History = slices.Clone(d.History)
+	return &res
+}
+
+// dlq keeps dead letters oldest first in a single file, which is rewritten
+// on every change. It is capped, evicting the oldest entries first.
+type dlq struct {
+	mu        sync.Mutex
+	path      string
+	size      int
+	entries   []*DeadLetter
+	evictions *metrics.Counter
+}
+
+func openDLQ(dir string, size int, reg *metrics.Registry) (*dlq, error) {
+	if size <= 0 {
+		size = defaultDLQSize
+	}
+
+	q := &dlq{
+		path:      filepath.Join(dir, dlqFile),
+		size:      size,
+		evictions: reg.Counter("webhook_dlq_evictions_total", "Dead letters evicted to keep the queue under its cap."),
+	}
+	reg.GaugeFunc("webhook_dlq_size", "Webhook deliveries waiting in the dead-letter queue.", func() float64 { return float64(q.len()) })
+
+	data, err := os.ReadFile(q.path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &q.entries); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *dlq) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// list returns copies of the entries, since retried ones are updated by
+// their delivery while callers may still be reading them.
+func (q *dlq) list() []*DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	res := make([]*DeadLetter, len(q.entries))
+	for i, d := range q.entries {
+		res[i] = d.clone()
+	}
+	return res
+}
+
+func (q *dlq) add(d *DeadLetter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.entries = append(q.entries, d)
+	if n := len(q.entries) - q.size; n > 0 {
+		q.entries = slices.Delete(q.entries, 0, n)
+		q.evictions.Add(uint64(n))
+	}
+	q.save()
+}
+
+// take removes the entries matched by fn and returns them.
+func (q *dlq) take(fn func(*DeadLetter) bool) []*DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var res []*DeadLetter
+	q.entries = slices.DeleteFunc(
+		q.entries, func(d *DeadLetter) bool {
+			if fn(d) {
+				res = append(res, d)
+				return true
+			}
+			return false
+		},
+	)
+	if len(res) > 0 {
+		q.save()
+	}
+	return res
+}
+
+func (q *dlq) save() {
+	data, err := json.Marshal(q.entries)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(q.path), os.ModePerm)
+	}
+	if err == nil {
+		tmp := q.path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, q.path)
+		}
+	}
+	if err != nil {
+		log.Printf("Error saving webhook dead letters: %s\n", err)
+	}
+}
@@ -0,0 +1,258 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/JMURv/media-server/internal/metrics"
+	"github.com/JMURv/media-server/pkg/config"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	FileCreated      = "file.created"
+	FileUpdated      = "file.updated"
+	FileDeleted      = "file.deleted"
+	FileTransitioned = "file.transitioned"
+	FileProcessed    = "file.processed"
+	FileStateChanged = "file.state_changed"
+	TrashPurged      = "trash.purged"
+	ReportGenerated  = "report.generated"
+)
+
+const SignatureHeader = "X-Signature"
+
+const defaultMaxRetries = 3
+
+type Event struct {
+	Type string    `json:"type"`
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+type Notifier struct {
+	targets []*config.WebhookConfig
+	client  *http.Client
+	backoff time.Duration
+	wg      sync.WaitGroup
+	dlq     *dlq
+	latency *metrics.Histogram
+}
+
+// New returns a notifier for targets that delivers with client, or with a
+// default one when client is nil. Deliveries that run out of retries are kept
+// in a dead-letter queue of up to dlqSize entries under dir.
+func New(
+	targets []*config.WebhookConfig, dir string, dlqSize int, client *http.Client, reg *metrics.Registry,
+) (*Notifier, error) {
+	q, err := openDLQ(dir, dlqSize, reg)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &Notifier{
+		targets: targets,
+		client:  client,
+		backoff: time.Second,
+		dlq:     q,
+		latency: reg.Histogram(
+			"webhook_delivery_seconds", "Time taken by webhook delivery attempts.", metrics.DefaultBuckets,
+		),
+	}, nil
+}
+
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *Notifier) Notify(typ, name string, data any) {
+	if len(n.targets) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(
+		&Event{
+			Type: typ,
+			Name: name,
+			Time: time.Now().UTC(),
+			Data: data,
+		},
+	)
+	if err != nil {
+		log.Printf("Error encoding webhook event: %s\n", err)
+		return
+	}
+
+	for _, t := range n.targets {
+		if len(t.Events) > 0 && !slices.Contains(t.Events, typ) {
+			continue
+		}
+
+		n.wg.Add(1)
+		go func(t *config.WebhookConfig) {
+			defer n.wg.Done()
+			n.deliver(t, &DeadLetter{Target: t.URL, Event: body})
+		}(t)
+	}
+}
+
+// deliver sends d.Event to t with retries. When they run out, d is recorded
+// with its attempts in the dead-letter queue.
+func (n *Notifier) deliver(t *config.WebhookConfig, d *DeadLetter) {
+	retries := t.MaxRetries
+	if retries <= 0 {
+		retries = defaultMaxRetries
+	}
+
+	backoff := n.backoff
+	if t.Backoff > 0 {
+		backoff = t.Backoff
+	}
+	for attempt := 0; ; attempt++ {
+		a := n.send(t, d.Event)
+		if a.Error == "" {
+			return
+		}
+		d.record(a)
+
+		if attempt >= retries {
+			log.Printf("Webhook delivery to %s failed after %d attempts: %s\n", t.URL, attempt+1, a.Error)
+			if d.ID == "" {
+				d.ID = newID()
+				d.CreatedAt = d.History[0].Time
+			}
+			n.dlq.add(d)
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (d *DeadLetter) record(a Attempt) {
+	d.Attempts++
+	d.LastError, d.LastStatus, d.FailedAt = a.Error, a.Status, a.Time
+	d.History = append(d.History, a)
+	if n := len(d.History) - maxAttemptHistory; n > 0 {
+		d.History = slices.Delete(d.History, 0, n)
+	}
+}
+
+func (n *Notifier) send(t *config.WebhookConfig, body []byte) (a Attempt) {
+	start := time.Now()
+	a.Time = start.UTC()
+	defer func() {
+		a.LatencySeconds = time.Since(start).Seconds()
+		n.latency.Observe(a.LatencySeconds)
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if t.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(t.Secret, body))
+	}
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	defer res.Body.Close()
+
+	a.Status = res.StatusCode
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		a.Error = fmt.Sprintf("unexpected status code: %d", res.StatusCode)
+	}
+	return a
+}
+
+// DeadLetters returns the deliveries that ran out of retries, oldest first.
+func (n *Notifier) DeadLetters() []*DeadLetter {
+	return n.dlq.list()
+}
+
+// Retry takes the dead letter id out of the queue and delivers it again in
+// the background, with the usual retries. It returns to the queue, with the
+// new attempts added, if they fail too.
+func (n *Notifier) Retry(id string) (*DeadLetter, error) {
+	var res *DeadLetter
+	n.dlq.take(
+		func(d *DeadLetter) bool {
+			if d.ID != id || n.target(d.Target) == nil {
+				return false
+			}
+			res = d
+			return true
+		},
+	)
+	if res != nil {
+		snapshot := res.clone()
+		n.redeliver(res)
+		return snapshot, nil
+	}
+
+	if slices.ContainsFunc(n.dlq.list(), func(d *DeadLetter) bool { return d.ID == id }) {
+		return nil, ErrTargetRemoved
+	}
+	return nil, ErrDeadLetterNotFound
+}
+
+// RetryAll redelivers every dead letter whose target is still configured and
+// returns how many were retried.
+func (n *Notifier) RetryAll() int {
+	taken := n.dlq.take(func(d *DeadLetter) bool { return n.target(d.Target) != nil })
+	for _, d := range taken {
+		n.redeliver(d)
+	}
+	return len(taken)
+}
+
+func (n *Notifier) redeliver(d *DeadLetter) {
+	t := n.target(d.Target)
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.deliver(t, d)
+	}()
+}
+
+func (n *Notifier) target(url string) *config.WebhookConfig {
+	for _, t := range n.targets {
+		if t.URL == url {
+			return t
+		}
+	}
+	return nil
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
@@ -1,34 +1,620 @@
 package config
 
 import (
+	"fmt"
 	"gopkg.in/yaml.v3"
 	"os"
+	"path/filepath"
+	"time"
 )
 
 type Config struct {
 	Port     int         `yaml:"port" env-default:"8080"`
 	SavePath string      `yaml:"savePath" env-default:"uploads"`
 	HTTP     *HTTPConfig `yaml:"app"`
+
+	// Path is the absolute path the config was loaded from and LoadedAt
+	// when, both set by Load.
+	Path     string    `yaml:"-"`
+	LoadedAt time.Time `yaml:"-"`
 }
 
 type HTTPConfig struct {
+	CreateDirs    bool   `yaml:"createDirs"`
+	TempDir       string `yaml:"tempDir"`
+	StrictTempDir bool   `yaml:"strictTempDir"`
+	DurableWrites bool   `yaml:"durableWrites"`
+
+	FollowSymlinks bool     `yaml:"followSymlinks"`
+	Ignore         []string `yaml:"ignore"`
+
 	MaxStreamBuffer int   `yaml:"maxStreamBuffer"`
+	MaxRanges       int   `yaml:"maxRanges"`
 	MaxUploadSize   int64 `yaml:"maxUploadSize"`
+	MaxFileSize     int64 `yaml:"maxFileSize"`
+	MaxRequestBody  int64 `yaml:"maxRequestBody"`
 	DefaultPage     int   `yaml:"defaultPage"`
 	DefaultSize     int   `yaml:"defaultSize"`
+	MaxWalkEntries  int   `yaml:"maxWalkEntries"`
+	MaxFilesPerDir  int   `yaml:"maxFilesPerDir"`
+
+	// BodyLimits replaces MaxRequestBody, the cap on the bodies of requests
+	// other than uploads, for single routes, keyed by pattern as in
+	// "POST /verify".
+	BodyLimits map[string]int64 `yaml:"bodyLimits"`
+
+	// ListCursorTTL is how long a /list continuation token stays valid.
+	ListCursorTTL time.Duration `yaml:"listCursorTTL"`
+
+	// AbsoluteMaxUploadSize caps the per-request X-Max-Upload-Override admins
+	// may send. Overrides are ignored while it is unset.
+	AbsoluteMaxUploadSize int64 `yaml:"absoluteMaxUploadSize"`
+
+	// AllowEmptyFiles set to false refuses zero-byte uploads with a 422.
+	// They are accepted while it is unset.
+	AllowEmptyFiles *bool `yaml:"allowEmptyFiles"`
+
+	MaxImageDimension int   `yaml:"maxImageDimension"`
+	MaxImagePixels    int64 `yaml:"maxImagePixels"`
+
+	// CoalesceTimeout bounds an image transform, preview or waveform shared
+	// by identical concurrent requests, and how long they wait for it.
+	CoalesceTimeout time.Duration `yaml:"coalesceTimeout"`
+
+	UploadTimeout    time.Duration `yaml:"uploadTimeout"`
+	MinUploadRate    int64         `yaml:"minUploadRate"`
+	UploadRateWindow time.Duration `yaml:"uploadRateWindow"`
+
+	BasePath       string   `yaml:"basePath"`
+	PublicBaseURL  string   `yaml:"publicBaseURL"`
+	TrustedProxies []string `yaml:"trustedProxies"`
+
+	// UploadFields are the multipart fields POST /upload reads the file
+	// from, in order of preference.
+	UploadFields []string `yaml:"uploadFields"`
+
+	Naming              string `yaml:"naming"`
+	RestoreOriginalName bool   `yaml:"restoreOriginalName"`
+	RotateIDOnOverwrite bool   `yaml:"rotateIDOnOverwrite"`
+
+	StrictContentType  bool     `yaml:"strictContentType"`
+	ForceDownloadTypes []string `yaml:"forceDownloadTypes"`
+	NeverServeTypes    []string `yaml:"neverServeTypes"`
+	FilenamePolicy     string   `yaml:"filenamePolicy"`
+	MaxFilenameBytes   int      `yaml:"maxFilenameBytes"`
+
+	// EncodedSlashes lets a %2F in a file name act as a directory separator,
+	// which is how routes such as /files/{name}/lock reach nested files;
+	// metadata is also served under /meta/{name...}, which takes them as
+	// they are. Off, requests carrying one are refused.
+	EncodedSlashes bool `yaml:"encodedSlashes"`
+
+	// MediaKinds overrides the kind, one of image, video, audio or other,
+	// that media types are listed and streamed as, keyed by media type or by
+	// top-level type as "type/*". Files of kind other are not streamed,
+	// subtitles aside.
+	MediaKinds map[string]string `yaml:"mediaKinds"`
+
+	LockTTL    time.Duration `yaml:"lockTTL"`
+	MaxLockTTL time.Duration `yaml:"maxLockTTL"`
+
+	DownloadSessionTTL time.Duration `yaml:"downloadSessionTTL"`
+
+	SigningSecret string           `yaml:"signingSecret"`
+	AdminToken    string           `yaml:"adminToken"`
+	ReadToken     string           `yaml:"readToken"`
+	Policy        *PolicyConfig    `yaml:"policy"`
+	BasicAuth     *BasicAuthConfig `yaml:"basicAuth"`
+	Images        *ImagesConfig    `yaml:"images"`
+	Previews      *PreviewsConfig  `yaml:"previews"`
+	HEIC          *HEICConfig      `yaml:"heic"`
+
+	ImportConflict string `yaml:"importConflict"`
+
+	AuditLog   string                    `yaml:"auditLog"`
+	Webhooks   []*WebhookConfig          `yaml:"webhooks"`
+	Validation *ValidationConfig         `yaml:"validation"`
+	Backends   map[string]*BackendConfig `yaml:"backends"`
+	Lifecycle  *LifecycleConfig          `yaml:"lifecycle"`
+
+	WebhookDLQSize int `yaml:"webhookDLQSize"`
+
+	Replication *ReplicationConfig `yaml:"replication"`
+	Shadow      *ShadowConfig      `yaml:"shadow"`
+	Events      *EventsConfig      `yaml:"events"`
+	Jobs        *JobsConfig        `yaml:"jobs"`
+	Transcode   *TranscodeConfig   `yaml:"transcode"`
+
+	ExistenceCache *ExistenceCacheConfig `yaml:"existenceCache"`
+	HotCache       *HotCacheConfig       `yaml:"hotCache"`
+	Changes        *ChangesConfig        `yaml:"changes"`
+	Usage          *UsageConfig          `yaml:"usage"`
+	Traffic        *TrafficConfig        `yaml:"traffic"`
+	Browse         *BrowseConfig         `yaml:"browse"`
+	Fallbacks      *FallbacksConfig      `yaml:"fallbacks"`
+	Multipart      *MultipartConfig      `yaml:"multipart"`
+	Logs           *LogsConfig           `yaml:"logs"`
+	Shedding       *SheddingConfig       `yaml:"shedding"`
+	Bandwidth      *BandwidthConfig      `yaml:"bandwidth"`
+	Messages       *MessagesConfig       `yaml:"messages"`
+	CORS           *CORSConfig           `yaml:"cors"`
+	Trash          *TrashConfig          `yaml:"trash"`
+	GC             *GCConfig             `yaml:"gc"`
+	Report         *ReportConfig         `yaml:"report"`
+	Idempotency    *IdempotencyConfig    `yaml:"idempotency"`
+	Encryption     *EncryptionConfig     `yaml:"encryption"`
+	Receipts       *ReceiptsConfig       `yaml:"receipts"`
+	Versions       *VersionsConfig       `yaml:"versions"`
+	Availability   *AvailabilityConfig   `yaml:"availability"`
+	Adopt          *AdoptConfig          `yaml:"adopt"`
+	Outbound       *OutboundConfig       `yaml:"outbound"`
+	Roots          *RootsConfig          `yaml:"roots"`
+
+	Debug *DebugConfig `yaml:"debug"`
+}
+
+// SheddingConfig limits concurrent requests globally and per route class.
+// Zero limits are off. Requests over a limit wait in a queue of MaxQueue for
+// up to QueueTimeout, then get 503 with Retry-After.
+type SheddingConfig struct {
+	MaxInFlight  int           `yaml:"maxInFlight"`
+	Read         int           `yaml:"read"`
+	Write        int           `yaml:"write"`
+	Stream       int           `yaml:"stream"`
+	MaxQueue     int           `yaml:"maxQueue"`
+	QueueTimeout time.Duration `yaml:"queueTimeout"`
+	RetryAfter   time.Duration `yaml:"retryAfter"`
+}
+
+// BandwidthConfig shares Budget bytes per second across concurrent streams.
+// Every active stream gets a share in proportion to its weight, and a share
+// a slow client leaves unused goes to the others. Streams of files up to
+// SmallFileSize weigh SmallFileWeight, others 1, so thumbnails and short
+// clips are not starved behind long videos.
+type BandwidthConfig struct {
+	Budget          int64 `yaml:"budget"`
+	SmallFileSize   int64 `yaml:"smallFileSize"`
+	SmallFileWeight int   `yaml:"smallFileWeight"`
+}
+
+// CORSConfig lets pages on other origins load streams and text tracks. An
+// origin of "*" allows any.
+type CORSConfig struct {
+	AllowedOrigins []string      `yaml:"allowedOrigins"`
+	MaxAge         time.Duration `yaml:"maxAge"`
+}
+
+// MessagesConfig adds a localized message to error responses, in the
+// language picked from Accept-Language or DefaultLanguage. Unknown languages
+// fall back to English.
+type MessagesConfig struct {
+	DefaultLanguage string `yaml:"defaultLanguage"`
+}
+
+type WebhookConfig struct {
+	URL        string        `yaml:"url"`
+	Secret     string        `yaml:"secret"`
+	Events     []string      `yaml:"events"`
+	MaxRetries int           `yaml:"maxRetries"`
+	Backoff    time.Duration `yaml:"backoff"`
+}
+
+// BackendConfig is a local directory at Path, or an http object store whose
+// objects are at URL/<name>, such as a bucket endpoint or a signing proxy in
+// front of one. Headers are sent with every request to it, and Timeout bounds
+// the wait for response headers only, so long streams are not cut off.
+// Reads fetch ReadAhead bytes at a time; objects up to Spill.MaxFileSize are
+// downloaded whole into Spill.Dir instead, and served from there until
+// replaced or removed through the server.
+type BackendConfig struct {
+	Type      string            `yaml:"type"`
+	Path      string            `yaml:"path"`
+	URL       string            `yaml:"url"`
+	Headers   map[string]string `yaml:"headers"`
+	Timeout   time.Duration     `yaml:"timeout"`
+	ReadAhead int64             `yaml:"readAhead"`
+	Spill     *SpillConfig      `yaml:"spill"`
+}
+
+// SpillConfig is a directory, for the spill cache only, bounded to MaxSize
+// bytes by evicting the least recently used objects.
+type SpillConfig struct {
+	Dir         string `yaml:"dir"`
+	MaxSize     int64  `yaml:"maxSize"`
+	MaxFileSize int64  `yaml:"maxFileSize"`
+}
+
+// ShadowConfig mirrors every write to Backend, one of Backends, in the
+// background, to try it under real traffic before making it primary.
+// Clients are only ever served from the primary store. Up to QueueSize
+// operations wait to be mirrored; more are dropped and counted. A
+// CompareReads share of downloads, from 0 to 1, also has its content
+// compared between the two, keeping the last MaxDivergences mismatches for
+// GET /admin/shadow.
+type ShadowConfig struct {
+	Backend        string  `yaml:"backend"`
+	QueueSize      int     `yaml:"queueSize"`
+	CompareReads   float64 `yaml:"compareReads"`
+	MaxDivergences int     `yaml:"maxDivergences"`
+}
+
+// PolicyConfig authorizes requests by the principal whose token they carry
+// as a bearer token. Requests without a known token are the principal
+// "anonymous", and the admin token is always allowed. Once a policy is set,
+// anything no rule allows is denied.
+type PolicyConfig struct {
+	Principals []*PrincipalConfig `yaml:"principals"`
+	Rules      []*PolicyRule      `yaml:"rules"`
+}
+
+type PrincipalConfig struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+}
+
+// PolicyRule allows, or with Effect "deny" denies, Principals the Actions
+// upload, download, list, delete, move and admin, "*" matching any of
+// either. With Prefixes it only applies to files under one of them, and so
+// never to requests that do not name a file. A deny overrides any allow.
+type PolicyRule struct {
+	Name       string   `yaml:"name"`
+	Effect     string   `yaml:"effect"`
+	Principals []string `yaml:"principals"`
+	Actions    []string `yaml:"actions"`
+	Prefixes   []string `yaml:"prefixes"`
+}
+
+// BasicAuthConfig lets clients sign in with a username and password.
+// Users lists them inline, their passwords plain or bcrypt hashed;
+// HtpasswdFile adds the bcrypt entries of an htpasswd file, re-read when it
+// changes. Scope "write", the default, asks for credentials on requests
+// that change files or need admin, "all" on every request but the public
+// ones. A user is the policy principal of their name, so rules can make
+// one read-only.
+type BasicAuthConfig struct {
+	Realm        string             `yaml:"realm"`
+	Scope        string             `yaml:"scope"`
+	Users        []*BasicUserConfig `yaml:"users"`
+	HtpasswdFile string             `yaml:"htpasswdFile"`
+}
+
+type BasicUserConfig struct {
+	Name     string `yaml:"name"`
+	Password string `yaml:"password"`
+}
+
+type LifecycleConfig struct {
+	Interval time.Duration    `yaml:"interval"`
+	DryRun   bool             `yaml:"dryRun"`
+	Rules    []*LifecycleRule `yaml:"rules"`
+}
+
+type LifecycleRule struct {
+	Name   string        `yaml:"name"`
+	Prefix string        `yaml:"prefix"`
+	Glob   string        `yaml:"glob"`
+	Tag    string        `yaml:"tag"`
+	Action string        `yaml:"action"`
+	Age    time.Duration `yaml:"age"`
+}
+
+type ReplicationConfig struct {
+	PeerURL    string        `yaml:"peerURL"`
+	Token      string        `yaml:"token"`
+	Timeout    time.Duration `yaml:"timeout"`
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+}
+
+type EventsConfig struct {
+	Type       string        `yaml:"type"`
+	URL        string        `yaml:"url"`
+	Brokers    []string      `yaml:"brokers"`
+	Topic      string        `yaml:"topic"`
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+}
+
+type ImagesConfig struct {
+	RequireSignature bool              `yaml:"requireSignature"`
+	PresetsOnly      bool              `yaml:"presetsOnly"`
+	Presets          map[string]string `yaml:"presets"`
+	WarmPresets      []string          `yaml:"warmPresets"`
+	Negotiate        bool              `yaml:"negotiate"`
+	MaxDimension     int               `yaml:"maxDimension"`
+	CacheSize        int64             `yaml:"cacheSize"`
+	MaxStale         time.Duration     `yaml:"maxStale"`
+}
+
+type PreviewsConfig struct {
+	Command    []string      `yaml:"command"`
+	Types      []string      `yaml:"types"`
+	Format     string        `yaml:"format"`
+	Timeout    time.Duration `yaml:"timeout"`
+	MaxSize    int64         `yaml:"maxSize"`
+	FailureTTL time.Duration `yaml:"failureTTL"`
+}
+
+// HEICConfig converts uploads recognized as HEIC or HEIF by their magic
+// bytes to JPEG with Command, in which {input} and {output} are replaced by
+// the paths to read and write. Without a command uploads are stored as
+// received and only their format is recorded. KeepOriginal keeps the
+// received file too, served by /stream with ?original=true.
+type HEICConfig struct {
+	Command      []string      `yaml:"command"`
+	Timeout      time.Duration `yaml:"timeout"`
+	KeepOriginal bool          `yaml:"keepOriginal"`
+}
+
+type JobsConfig struct {
+	Workers   int           `yaml:"workers"`
+	QueueSize int           `yaml:"queueSize"`
+	Tasks     []string      `yaml:"tasks"`
+	Retention time.Duration `yaml:"retention"`
+
+	// ScanCommand enables the scan task. The stored file's path is appended
+	// to it and an exit status of 1 quarantines the file, as clamscan does.
+	ScanCommand []string `yaml:"scanCommand"`
+}
+
+type ExistenceCacheConfig struct {
+	Size        int           `yaml:"size"`
+	TTL         time.Duration `yaml:"ttl"`
+	NegativeTTL time.Duration `yaml:"negativeTTL"`
+	Watch       bool          `yaml:"watch"`
+}
+
+type HotCacheConfig struct {
+	MaxSize     int64 `yaml:"maxSize"`
+	MaxFileSize int64 `yaml:"maxFileSize"`
+}
+
+// ChangesConfig enables GET /changes, which keeps entries for Retention.
+// Deleted files leave a tombstone, kept for TombstoneRetention, that
+// GET /changes?tombstones=true lists and, with Gone, GET /files/{name}/meta
+// answers 410 with instead of 404.
+type ChangesConfig struct {
+	Retention          time.Duration `yaml:"retention"`
+	MaxPage            int           `yaml:"maxPage"`
+	TombstoneRetention time.Duration `yaml:"tombstoneRetention"`
+	Gone               bool          `yaml:"gone"`
+}
+
+// UsageConfig enables GET /stats/usage. Usage is snapshotted every
+// SnapshotInterval, aligned to UTC, for reports as of a past time, and
+// snapshots older than Retention are removed.
+type UsageConfig struct {
+	SnapshotInterval time.Duration `yaml:"snapshotInterval"`
+	Retention        time.Duration `yaml:"retention"`
+	MaxGroups        int           `yaml:"maxGroups"`
+}
+
+// TrafficConfig counts the request and response body bytes of each
+// principal per UTC day, for GET /stats/traffic. Counts are flushed to disk
+// every FlushInterval, which bounds what a crash loses, and days older than
+// Retention are removed.
+type TrafficConfig struct {
+	FlushInterval time.Duration `yaml:"flushInterval"`
+	Retention     time.Duration `yaml:"retention"`
+}
+
+// BrowseConfig enables GET /browse/, an HTML index of the save path with
+// PageSize entries a page. Browsing needs the list permission, so with a
+// policy anonymous visitors only browse what rules allow them to list.
+type BrowseConfig struct {
+	PageSize int `yaml:"pageSize"`
+}
+
+// FallbacksConfig serves a stored default file in place of missing ones on
+// GET /stream/ and /uploads/. The first rule whose Prefix, a directory such
+// as "avatars", covers the missing name decides: its File is served with
+// 200, or with 404 when NotFound. Every File must exist at startup.
+type FallbacksConfig struct {
+	Rules []*FallbackRule `yaml:"rules"`
+}
+
+type FallbackRule struct {
+	Prefix   string `yaml:"prefix"`
+	File     string `yaml:"file"`
+	NotFound bool   `yaml:"notFound"`
+}
+
+// VersionsConfig keeps the content files had before they were overwritten,
+// listed by GET /files/{name}/versions. Up to Max versions are kept per
+// file, dropping the oldest first; zero keeps them all.
+type VersionsConfig struct {
+	Max int `yaml:"max"`
+}
+
+// TrashConfig enables soft delete: deleted files are moved to the trash,
+// listed by GET /trash, and purged once they are older than MaxAge. When the
+// trash grows past MaxSize bytes, the oldest entries are purged until it is
+// back under Watermark, a fraction of MaxSize. Zero limits are not enforced.
+type TrashConfig struct {
+	MaxSize       int64         `yaml:"maxSize"`
+	MaxAge        time.Duration `yaml:"maxAge"`
+	Watermark     float64       `yaml:"watermark"`
+	PurgeInterval time.Duration `yaml:"purgeInterval"`
+}
+
+// GCConfig runs the garbage collection of derived artifacts, such as cached
+// previews and waveforms whose source is gone or has changed, every
+// Interval. POST /admin/gc runs it on demand either way.
+type GCConfig struct {
+	Interval time.Duration `yaml:"interval"`
+}
+
+// ReportConfig sends a digest of storage health on Schedule, a crontab(5)
+// spec evaluated in UTC, "@daily" by default. Each report covers the time
+// since the previous one and goes to the webhooks, as a report.generated
+// event, when Webhook is set, and by mail when SMTP is. A failed report is
+// retried once after RetryDelay, a minute by default. POST
+// /admin/report/run sends one on demand.
+type ReportConfig struct {
+	Schedule   string        `yaml:"schedule"`
+	Webhook    bool          `yaml:"webhook"`
+	SMTP       *SMTPConfig   `yaml:"smtp"`
+	RetryDelay time.Duration `yaml:"retryDelay"`
+}
+
+// SMTPConfig is a mail server reports are sent through, Addr being its
+// host:port. Username and Password, when set, sign in with PLAIN auth.
+type SMTPConfig struct {
+	Addr     string   `yaml:"addr"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+// IdempotencyConfig honours the Idempotency-Key header on POST /upload. The
+// response to a successful upload is kept for Retention, 24h by default, and
+// replayed to retries by the same principal with the same key and content.
+type IdempotencyConfig struct {
+	Retention time.Duration `yaml:"retention"`
+}
+
+// EncryptionConfig encrypts stored files at rest, each with a data key of its
+// own wrapped by a master key. Keys maps key ids to base64 encoded 32 byte
+// master keys; new files are wrapped by ActiveKey, and the others are kept to
+// read files wrapped before a rotation. With KMS set, data keys are wrapped
+// by running that command instead and Keys is ignored.
+type EncryptionConfig struct {
+	Keys      map[string]string `yaml:"keys"`
+	ActiveKey string            `yaml:"activeKey"`
+	KMS       []string          `yaml:"kms"`
+}
+
+// ReceiptsConfig signs the receipts uploads ask for with ?receipt=true using
+// ActiveKey, one of Keys, which maps key ids to base64 encoded Ed25519
+// private keys or seeds. RetiredKeys maps the ids of keys rotated out to
+// their base64 encoded public keys, still published so the receipts they
+// signed can be verified.
+type ReceiptsConfig struct {
+	Keys        map[string]string `yaml:"keys"`
+	ActiveKey   string            `yaml:"activeKey"`
+	RetiredKeys map[string]string `yaml:"retiredKeys"`
+}
+
+// RootsConfig stores files under Paths as well as the save path, such as
+// one directory per disk. Placement is "fill", the default, which takes the
+// save path and then each of Paths in order, moving on once one would be
+// left with less than Reserve bytes free, or "balanced", which takes the
+// root with the most free space. Paths may be added by reloading the config
+// while running; new uploads then go to them, and files already stored stay
+// where they are.
+type RootsConfig struct {
+	Paths     []string `yaml:"paths"`
+	Placement string   `yaml:"placement"`
+	Reserve   int64    `yaml:"reserve"`
+}
+
+// AvailabilityConfig tunes how available_from and available_until windows
+// are enforced. Windows apply without it, answering 404 outside them.
+type AvailabilityConfig struct {
+	// Forbidden answers 403 with the window instead of 404.
+	Forbidden bool `yaml:"forbidden"`
+	// ClockSkew widens every window on both ends, so a schedule set by a
+	// client whose clock disagrees with the server's is not cut short.
+	ClockSkew time.Duration `yaml:"clockSkew"`
+	// DeleteAfter, when set, deletes files this long after available_until.
+	DeleteAfter   time.Duration `yaml:"deleteAfter"`
+	SweepInterval time.Duration `yaml:"sweepInterval"`
+}
+
+// AdoptConfig enables POST /admin/adopt, which moves or hard-links files
+// already on the volume into the store. Only paths within AllowedSources
+// may be adopted.
+type AdoptConfig struct {
+	AllowedSources []string `yaml:"allowedSources"`
+}
+
+// ValidationConfig has an external service check uploads before they are
+// stored: a JSON summary of each is POSTed to URL, signed with Secret when
+// set. A 2xx answer accepts the upload, under the name it returns if any,
+// and a 4xx rejects it with the service's error. OnFailure, "reject" or
+// "accept", settles uploads the service gives no verdict on, by timing out
+// or otherwise. The tenant sent along is read from TenantHeader.
+type ValidationConfig struct {
+	URL          string        `yaml:"url"`
+	Secret       string        `yaml:"secret"`
+	Timeout      time.Duration `yaml:"timeout"`
+	OnFailure    string        `yaml:"onFailure"`
+	TenantHeader string        `yaml:"tenantHeader"`
+}
+
+// OutboundConfig applies to every HTTP request the server makes itself,
+// such as webhook deliveries and replication. Proxy is a URL, "direct" for
+// none, or empty for the standard HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+// environment variables. CAFile adds PEM certificates to the system roots.
+// Connections are made from SourceAddr, or from the first address of
+// Interface. Timeouts are keyed by feature and yield to a feature's own
+// timeout setting.
+type OutboundConfig struct {
+	Proxy         string                   `yaml:"proxy"`
+	CAFile        string                   `yaml:"caFile"`
+	MinTLSVersion string                   `yaml:"minTLSVersion"`
+	SourceAddr    string                   `yaml:"sourceAddr"`
+	Interface     string                   `yaml:"interface"`
+	Timeouts      map[string]time.Duration `yaml:"timeouts"`
+}
+
+type LogsConfig struct {
+	BufferSize int    `yaml:"bufferSize"`
+	Level      string `yaml:"level"`
+}
+
+type MultipartConfig struct {
+	TTL         time.Duration `yaml:"ttl"`
+	MinPartSize int64         `yaml:"minPartSize"`
+	MaxParts    int           `yaml:"maxParts"`
+}
+
+type DebugConfig struct {
+	Addr         string `yaml:"addr"`
+	MaxBenchSize int64  `yaml:"maxBenchSize"`
+}
+
+type TranscodeConfig struct {
+	FFmpeg      string                      `yaml:"ffmpeg"`
+	Concurrency int                         `yaml:"concurrency"`
+	Nice        int                         `yaml:"nice"`
+	CPUBudget   int                         `yaml:"cpuBudget"`
+	Presets     map[string]*TranscodePreset `yaml:"presets"`
+}
+
+type TranscodePreset struct {
+	VideoCodec   string `yaml:"videoCodec"`
+	AudioCodec   string `yaml:"audioCodec"`
+	Height       int    `yaml:"height"`
+	VideoBitrate string `yaml:"videoBitrate"`
+	AudioBitrate string `yaml:"audioBitrate"`
+	Container    string `yaml:"container"`
 }
 
 func MustLoad(configPath string) *Config {
+	conf, err := Load(configPath)
+	if err != nil {
+		panic(err.Error())
+	}
+	return conf
+}
+
+// Load reads the config at configPath, for reloads that must not take the
+// server down on a bad file.
+func Load(configPath string) (*Config, error) {
 	var conf Config
 
 	data, err := os.ReadFile(configPath)
 	if err != nil {
-		panic("failed to read config: " + err.Error())
+		return nil, fmt.Errorf("failed to read config: %w", err)
 	}
 
 	if err = yaml.Unmarshal(data, &conf); err != nil {
-		panic("failed to unmarshal config: " + err.Error())
+		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
-	return &conf
+	conf.Path, conf.LoadedAt = configPath, time.Now().UTC()
+	if abs, err := filepath.Abs(configPath); err == nil {
+		conf.Path = abs
+	}
+	return &conf, nil
 }
@@ -0,0 +1,60 @@
+package filename
+
+import (
+	"golang.org/x/text/unicode/norm"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+var translit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "h", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "sch", 'ъ': "",
+	'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'ß': "ss", 'æ': "ae", 'ø': "o", 'œ': "oe", 'ł': "l", 'đ': "d",
+}
+
+func Slug(name string) string {
+	ext := filepath.Ext(name)
+	base := slugify(strings.TrimSuffix(name, ext))
+	if base == "" {
+		base = "file"
+	}
+
+	if ext = slugify(ext); ext != "" {
+		return base + "." + ext
+	}
+	return base
+}
+
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(norm.NFC.String(s)) {
+		if t, ok := translit[r]; ok {
+			b.WriteString(t)
+			dash = false
+			continue
+		}
+
+		for _, d := range norm.NFD.String(string(r)) {
+			if unicode.Is(unicode.Mn, d) {
+				continue
+			}
+
+			if d < unicode.MaxASCII && (unicode.IsLetter(d) || unicode.IsDigit(d)) {
+				b.WriteRune(d)
+				dash = false
+				continue
+			}
+
+			if !dash && b.Len() > 0 {
+				b.WriteByte('-')
+				dash = true
+			}
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
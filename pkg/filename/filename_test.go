@@ -0,0 +1,80 @@
+package filename
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	emoji := strings.Repeat("😀", 150) + ".png"
+	tests := []struct {
+		name      string
+		input     string
+		rejectErr error
+		sanitized string
+	}{
+		{name: "Plain", input: "photo.jpg", sanitized: "photo.jpg"},
+		{name: "Unicode", input: "фото отпуск.jpg", sanitized: "фото отпуск.jpg"},
+		{name: "NFD to NFC", input: "é.txt", sanitized: "é.txt"},
+		{name: "Reserved", input: "con.txt", rejectErr: ErrReservedName, sanitized: "_con.txt"},
+		{name: "Reserved upper", input: "LPT1", rejectErr: ErrReservedName, sanitized: "_LPT1"},
+		{name: "Reserved multi ext", input: "aux.tar.gz", rejectErr: ErrReservedName, sanitized: "_aux.tar.gz"},
+		{name: "Not reserved", input: "console.txt", sanitized: "console.txt"},
+		{name: "Newline", input: "a\nb.jpg", rejectErr: ErrControlChars, sanitized: "ab.jpg"},
+		{name: "Null byte", input: "a\x00.jpg", rejectErr: ErrControlChars, sanitized: "a.jpg"},
+		{name: "Tab and DEL", input: "a\tb\x7f.txt", rejectErr: ErrControlChars, sanitized: "ab.txt"},
+		{name: "Leading dot", input: ".htaccess", rejectErr: ErrLeadingTrailing, sanitized: "htaccess"},
+		{name: "Trailing dot", input: "file.txt.", rejectErr: ErrLeadingTrailing, sanitized: "file.txt"},
+		{name: "Spaces", input: "  file.txt  ", rejectErr: ErrLeadingTrailing, sanitized: "file.txt"},
+		{name: "Dot dot", input: "..", rejectErr: ErrLeadingTrailing},
+		{name: "Traversal", input: "../etc/passwd", rejectErr: ErrInvalidChars, sanitized: "_etc_passwd"},
+		{name: "Separators", input: `a/b\c.txt`, rejectErr: ErrInvalidChars, sanitized: "a_b_c.txt"},
+		{name: "Windows chars", input: `what?<x>|"y":*.txt`, rejectErr: ErrInvalidChars, sanitized: "what__x___y___.txt"},
+		{name: "Invalid utf8", input: "a\xffb.txt", rejectErr: ErrInvalidUTF8, sanitized: "ab.txt"},
+		{name: "Empty", input: "", rejectErr: ErrEmpty},
+		{name: "Only control", input: "\n\r", rejectErr: ErrControlChars},
+		{name: "Too long", input: emoji, rejectErr: ErrTooLong, sanitized: strings.Repeat("😀", 62) + ".png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				res, err := Validate(tt.input, 0)
+				if tt.rejectErr != nil {
+					assert.ErrorIs(t, err, tt.rejectErr)
+				} else {
+					assert.Nil(t, err)
+					assert.Equal(t, tt.sanitized, res)
+				}
+
+				res, err = Sanitize(tt.input, 0)
+				if tt.sanitized == "" {
+					assert.ErrorIs(t, err, ErrEmpty)
+					return
+				}
+				assert.Nil(t, err)
+				assert.Equal(t, tt.sanitized, res)
+				assert.LessOrEqual(t, len(res), DefaultMaxBytes)
+
+				_, err = Validate(res, 0)
+				assert.Nil(t, err)
+			},
+		)
+	}
+}
+
+func TestSlug(t *testing.T) {
+	tests := map[string]string{
+		"Photo.JPG":          "photo.jpg",
+		"Привет Мир (1).PNG": "privet-mir-1.png",
+		"Crème Brûlée.txt":   "creme-brulee.txt",
+		"Йошкар-Ола.mp4":     "yoshkar-ola.mp4",
+		"___.gif":            "file.gif",
+		"noext":              "noext",
+	}
+
+	for in, exp := range tests {
+		assert.Equal(t, exp, Slug(in), in)
+	}
+}
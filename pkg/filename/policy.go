@@ -0,0 +1,128 @@
+package filename
+
+import (
+	"errors"
+	"golang.org/x/text/unicode/norm"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	ModeReject   = "reject"
+	ModeSanitize = "sanitize"
+)
+
+const DefaultMaxBytes = 255
+
+var ErrEmpty = errors.New("filename is empty")
+var ErrInvalidUTF8 = errors.New("filename is not valid utf-8")
+var ErrControlChars = errors.New("filename contains control characters")
+var ErrInvalidChars = errors.New("filename contains path separators or reserved characters")
+var ErrLeadingTrailing = errors.New("filename starts or ends with a dot or space")
+var ErrReservedName = errors.New("filename is a reserved device name")
+var ErrTooLong = errors.New("filename is too long")
+
+const invalidChars = `/\<>:"|?*`
+
+var reserved = map[string]struct{}{
+	"CON": {}, "PRN": {}, "AUX": {}, "NUL": {},
+	"COM1": {}, "COM2": {}, "COM3": {}, "COM4": {}, "COM5": {}, "COM6": {}, "COM7": {}, "COM8": {}, "COM9": {},
+	"LPT1": {}, "LPT2": {}, "LPT3": {}, "LPT4": {}, "LPT5": {}, "LPT6": {}, "LPT7": {}, "LPT8": {}, "LPT9": {},
+}
+
+type Policy struct {
+	Mode     string
+	MaxBytes int
+}
+
+func (p Policy) Apply(name string) (string, error) {
+	if p.Mode == ModeReject {
+		return Validate(name, p.MaxBytes)
+	}
+	return Sanitize(name, p.MaxBytes)
+}
+
+func Validate(name string, maxBytes int) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", ErrInvalidUTF8
+	}
+
+	name = norm.NFC.String(name)
+	switch {
+	case name == "":
+		return "", ErrEmpty
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return "", ErrControlChars
+	case strings.ContainsAny(name, invalidChars):
+		return "", ErrInvalidChars
+	case strings.Trim(name, ". ") != name:
+		return "", ErrLeadingTrailing
+	case isReserved(name):
+		return "", ErrReservedName
+	case len(name) > limit(maxBytes):
+		return "", ErrTooLong
+	}
+	return name, nil
+}
+
+func Sanitize(name string, maxBytes int) (string, error) {
+	name = norm.NFC.String(strings.ToValidUTF8(name, ""))
+	name = strings.Map(
+		func(r rune) rune {
+			if unicode.IsControl(r) {
+				return -1
+			}
+			if strings.ContainsRune(invalidChars, r) {
+				return '_'
+			}
+			return r
+		}, name,
+	)
+
+	name = strings.Trim(truncate(strings.Trim(name, ". "), limit(maxBytes)), ". ")
+	if isReserved(name) {
+		name = truncate("_"+name, limit(maxBytes))
+	}
+
+	if name == "" {
+		return "", ErrEmpty
+	}
+	return name, nil
+}
+
+func isReserved(name string) bool {
+	base, _, _ := strings.Cut(name, ".")
+	_, ok := reserved[strings.ToUpper(strings.TrimRight(base, " "))]
+	return ok
+}
+
+func limit(maxBytes int) int {
+	if maxBytes <= 0 {
+		return DefaultMaxBytes
+	}
+	return maxBytes
+}
+
+func truncate(name string, maxBytes int) string {
+	if len(name) <= maxBytes {
+		return name
+	}
+
+	ext := filepath.Ext(name)
+	if len(ext) >= maxBytes/2 {
+		ext = ""
+	}
+
+	base := name[:len(name)-len(filepath.Ext(name))]
+	if ext == "" {
+		base = name
+	}
+
+	n := maxBytes - len(ext)
+	for n > 0 && !utf8.RuneStart(base[n]) {
+		n--
+	}
+	return strings.TrimRight(base[:n], ". ") + ext
+}
@@ -0,0 +1,58 @@
+package media_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/JMURv/media-server/pkg/media"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+)
+
+func Example() {
+	dir, _ := os.MkdirTemp("", "media-example-*")
+	defer os.RemoveAll(dir)
+
+	h := media.New(dir, &config.HTTPConfig{BasePath: "/media", DefaultPage: 1, DefaultSize: 10})
+	h.StartWorkers()
+	defer h.Shutdown(context.Background())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(
+		"GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "ok")
+		},
+	)
+	h.RegisterRoutes(mux)
+
+	// The parent server's own middleware wraps the media routes too.
+	requestID := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Request-Id", "req-1")
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+
+	srv := httptest.NewServer(requestID(mux))
+	defer srv.Close()
+
+	for _, path := range []string{"/healthz", "/media/list", "/media/stats", "/list"} {
+		res, err := http.Get(srv.URL + path)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		res.Body.Close()
+		fmt.Println(path, res.StatusCode, res.Header.Get("X-Request-Id"))
+	}
+
+	// Output:
+	// /healthz 200 req-1
+	// /media/list 200 req-1
+	// /media/stats 200 req-1
+	// /list 404 req-1
+}
@@ -0,0 +1,21 @@
+// Package media lets other Go servers embed the media endpoints: build a
+// Handler with New, mount it with RegisterRoutes next to the server's own
+// routes, start its background jobs with StartWorkers and stop them with
+// Shutdown.
+//
+// Set BasePath in the config to the prefix the handler is mounted under, so
+// the URLs it returns point back at it.
+package media
+
+import (
+	handler "github.com/JMURv/media-server/internal/hdl/http"
+	"github.com/JMURv/media-server/pkg/config"
+)
+
+type Handler = handler.Handler
+
+// New returns a handler storing files under savePath. It panics on invalid
+// configuration, as the standalone server does at startup.
+func New(savePath string, cfg *config.HTTPConfig) *Handler {
+	return handler.New("", savePath, cfg)
+}
@@ -0,0 +1,82 @@
+package mediatest
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/JMURv/media-server/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	var root string
+
+	t.Run(
+		"Serves the root", func(t *testing.T) {
+			srv := New(t, nil)
+			root = srv.Root()
+			assert.DirExists(t, root)
+
+			body := &bytes.Buffer{}
+			w := multipart.NewWriter(body)
+			part, _ := w.CreateFormFile("file", "uploaded.txt")
+			part.Write([]byte("over the wire"))
+			w.Close()
+
+			res, err := srv.Client().Post(srv.URL()+"/upload", w.FormDataContentType(), body)
+			assert.Nil(t, err)
+			res.Body.Close()
+			assert.Equal(t, http.StatusCreated, res.StatusCode)
+			assert.FileExists(t, srv.Path("uploaded.txt"))
+
+			srv.Seed("nested/seeded.txt", "from disk", time.Time{})
+			res, err = srv.Client().Get(srv.URL() + "/uploads/nested/seeded.txt")
+			assert.Nil(t, err)
+			data, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			assert.Equal(t, "from disk", string(data))
+		},
+	)
+
+	t.Run(
+		"Cleaned up", func(t *testing.T) {
+			_, err := os.Stat(root)
+			assert.True(t, os.IsNotExist(err))
+		},
+	)
+
+	t.Run(
+		"Seeded times", func(t *testing.T) {
+			srv := New(t, nil)
+			when := time.Date(2020, time.June, 1, 12, 0, 0, 0, time.UTC)
+			srv.Seed("old.txt", "old", when)
+			srv.Seed("default.txt", "default", time.Time{})
+
+			for name, want := range map[string]time.Time{"old.txt": when, "default.txt": Epoch} {
+				res, err := srv.Client().Get(srv.URL() + "/files/" + name + "/meta")
+				assert.Nil(t, err)
+				m := struct {
+					UpdatedAt time.Time `json:"updated_at"`
+				}{}
+				assert.Nil(t, json.NewDecoder(res.Body).Decode(&m))
+				res.Body.Close()
+				assert.True(t, want.Equal(m.UpdatedAt), name)
+			}
+		},
+	)
+
+	t.Run(
+		"Config", func(t *testing.T) {
+			srv := New(t, &config.HTTPConfig{MaxUploadSize: 1024, AdminToken: "secret"})
+			res, err := srv.Client().Get(srv.URL() + "/admin/config")
+			assert.Nil(t, err)
+			res.Body.Close()
+			assert.Equal(t, http.StatusForbidden, res.StatusCode)
+		},
+	)
+}
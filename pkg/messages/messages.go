@@ -0,0 +1,155 @@
+// Package messages resolves error codes to human-readable messages in the
+// client's language. English and Russian catalogs are embedded; embedders can
+// add languages or override messages with Register.
+//
+// Messages may refer to error parameters as {name}; Message fills them in
+// from the params returned with the error.
+package messages
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Fallback is the language used for codes and languages the catalog lacks.
+const Fallback = "en"
+
+//go:embed catalog/*.json
+var embedded embed.FS
+
+var (
+	mu       sync.RWMutex
+	catalogs = map[string]map[string]string{}
+)
+
+func init() {
+	files, err := embedded.ReadDir("catalog")
+	if err != nil {
+		panic(err)
+	}
+	for _, f := range files {
+		data, err := embedded.ReadFile(path.Join("catalog", f.Name()))
+		if err != nil {
+			panic(err)
+		}
+
+		msgs := map[string]string{}
+		if err = json.Unmarshal(data, &msgs); err != nil {
+			panic(fmt.Sprintf("messages: catalog %s: %s", f.Name(), err))
+		}
+		Register(strings.TrimSuffix(f.Name(), ".json"), msgs)
+	}
+}
+
+// Register adds msgs, keyed by error code, to the catalog for lang. Messages
+// already registered for the same code are replaced.
+func Register(lang string, msgs map[string]string) {
+	lang = strings.ToLower(lang)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if catalogs[lang] == nil {
+		catalogs[lang] = make(map[string]string, len(msgs))
+	}
+	for code, msg := range msgs {
+		catalogs[lang][code] = msg
+	}
+}
+
+func registered(lang string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return catalogs[lang] != nil
+}
+
+// match returns the registered language for a tag such as "ru-RU", trying
+// the full tag before its primary subtag.
+func match(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if registered(tag) {
+		return tag
+	}
+	if primary, _, ok := strings.Cut(tag, "-"); ok && registered(primary) {
+		return primary
+	}
+	return ""
+}
+
+// Negotiate picks the registered language that best fits an Accept-Language
+// header. Without a match it returns def if that is registered, and Fallback
+// otherwise.
+func Negotiate(acceptLanguage, def string) string {
+	type choice struct {
+		tag string
+		q   float64
+	}
+
+	var choices []choice
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if tag = strings.TrimSpace(tag); tag != "" && q > 0 {
+			choices = append(choices, choice{tag: tag, q: q})
+		}
+	}
+	slices.SortStableFunc(
+		choices, func(a, b choice) int {
+			switch {
+			case a.q > b.q:
+				return -1
+			case a.q < b.q:
+				return 1
+			}
+			return 0
+		},
+	)
+
+	for _, c := range choices {
+		if c.tag == "*" {
+			break
+		}
+		if lang := match(c.tag); lang != "" {
+			return lang
+		}
+	}
+	if lang := match(def); lang != "" {
+		return lang
+	}
+	return Fallback
+}
+
+// Message returns the message for code in lang with params filled in,
+// falling back to English when lang has none. It reports false when no
+// catalog knows the code.
+func Message(lang, code string, params map[string]any) (string, bool) {
+	mu.RLock()
+	msg, ok := catalogs[strings.ToLower(lang)][code]
+	if !ok {
+		msg, ok = catalogs[Fallback][code]
+	}
+	mu.RUnlock()
+	if !ok {
+		return "", false
+	}
+
+	if len(params) > 0 {
+		pairs := make([]string, 0, 2*len(params))
+		for k, v := range params {
+			pairs = append(pairs, "{"+k+"}", fmt.Sprint(v))
+		}
+		msg = strings.NewReplacer(pairs...).Replace(msg)
+	}
+	return msg, true
+}
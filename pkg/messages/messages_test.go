@@ -0,0 +1,83 @@
+package messages
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		def    string
+		lang   string
+	}{
+		{name: "Exact", accept: "ru", lang: "ru"},
+		{name: "Region", accept: "ru-RU,ru;q=0.9", lang: "ru"},
+		{name: "Case", accept: "RU-ru", lang: "ru"},
+		{name: "Quality", accept: "en;q=0.5, ru;q=0.8", lang: "ru"},
+		{name: "Skips unknown", accept: "fr-CA, ru;q=0.1", lang: "ru"},
+		{name: "Zero quality", accept: "ru;q=0, en", lang: "en"},
+		{name: "Default", accept: "fr", def: "ru", lang: "ru"},
+		{name: "Empty", def: "ru", lang: "ru"},
+		{name: "Unknown default", accept: "fr", def: "de", lang: Fallback},
+		{name: "Wildcard", accept: "*", def: "ru", lang: "ru"},
+		{name: "Malformed quality", accept: "ru;q=x", lang: Fallback},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				assert.Equal(t, tt.lang, Negotiate(tt.accept, tt.def))
+			},
+		)
+	}
+}
+
+func TestMessage(t *testing.T) {
+	t.Run(
+		"Params are filled in", func(t *testing.T) {
+			msg, ok := Message("en", "file_too_big", map[string]any{"limit": 1024})
+			assert.True(t, ok)
+			assert.Equal(t, "The file exceeds the size limit of 1024 bytes.", msg)
+
+			msg, ok = Message("ru", "file_too_big", map[string]any{"limit": 1024})
+			assert.True(t, ok)
+			assert.Equal(t, "Размер файла превышает ограничение в 1024 байт.", msg)
+		},
+	)
+
+	t.Run(
+		"Catalogs cover the same codes", func(t *testing.T) {
+			for code := range catalogs[Fallback] {
+				assert.Contains(t, catalogs["ru"], code)
+			}
+			assert.Len(t, catalogs["ru"], len(catalogs[Fallback]))
+		},
+	)
+
+	t.Run(
+		"Unknown languages and codes", func(t *testing.T) {
+			en, _ := Message("en", "locked", nil)
+			msg, ok := Message("fr", "locked", nil)
+			assert.True(t, ok)
+			assert.Equal(t, en, msg)
+
+			_, ok = Message("en", "no_such_code", nil)
+			assert.False(t, ok)
+		},
+	)
+
+	t.Run(
+		"Registered catalogs", func(t *testing.T) {
+			Register("DE", map[string]string{"locked": "Die Datei ist gesperrt."})
+			assert.Equal(t, "de", Negotiate("de-AT", ""))
+
+			msg, _ := Message("de", "locked", nil)
+			assert.Equal(t, "Die Datei ist gesperrt.", msg)
+
+			en, _ := Message("en", "held", nil)
+			msg, _ = Message("de", "held", nil)
+			assert.Equal(t, en, msg)
+		},
+	)
+}
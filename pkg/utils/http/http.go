@@ -4,22 +4,82 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type Response struct {
 	URL any `json:"url"`
 }
 
+type UploadResponse struct {
+	ID           string   `json:"id,omitempty"`
+	URL          string   `json:"url"`
+	Name         string   `json:"name"`
+	OriginalName string   `json:"original_name"`
+	Identical    bool     `json:"identical,omitempty"`
+	Processing   bool     `json:"processing,omitempty"`
+	Job          string   `json:"job,omitempty"`
+	Tasks        []string `json:"tasks,omitempty"`
+
+	// PreviousETag is the ETag of the content an upload replaced, so
+	// clients can tell whether it was the content they last saw.
+	PreviousETag string `json:"previous_etag,omitempty"`
+
+	Receipt *Receipt `json:"receipt,omitempty"`
+}
+
+// Receipt is signed proof of what an upload stored. Payload is the base64
+// encoded JSON statement, and Signature its base64 encoded Ed25519
+// signature by the key the statement names.
+type Receipt struct {
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
 type PaginatedResponse struct {
-	Data        any  `json:"data"`
-	Count       int  `json:"count"`
-	TotalPages  int  `json:"total_pages"`
-	CurrentPage int  `json:"current_page"`
-	HasNextPage bool `json:"has_next_page"`
+	Data        any    `json:"data"`
+	Count       int    `json:"count"`
+	TotalPages  int    `json:"total_pages"`
+	CurrentPage int    `json:"current_page"`
+	HasNextPage bool   `json:"has_next_page"`
+	Truncated   bool   `json:"truncated,omitempty"`
+	Hint        string `json:"hint,omitempty"`
+	NextCursor  string `json:"next_cursor,omitempty"`
+
+	// Groups counts the matching files per group, ignoring the filter on
+	// the grouped field, when a listing asks for it.
+	Groups map[string]int `json:"groups,omitempty"`
 }
 
+// ErrorResponse is the error envelope. Code is stable and meant for
+// programs; Message is Error localized for people, and Params are the values
+// it was filled in with.
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error   string         `json:"error"`
+	Code    string         `json:"code,omitempty"`
+	Message string         `json:"message,omitempty"`
+	Params  map[string]any `json:"params,omitempty"`
+
+	// Retryable tells clients whether sending the request again unchanged
+	// may succeed.
+	Retryable bool `json:"retryable"`
+}
+
+type LockedResponse struct {
+	ErrorResponse
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type HeldResponse struct {
+	ErrorResponse
+	Reason string     `json:"reason,omitempty"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+type TooLargeResponse struct {
+	ErrorResponse
+	Limit int64 `json:"limit"`
 }
 
 func SuccessPaginatedResponse(w http.ResponseWriter, statusCode int, data any) {
@@ -38,6 +98,12 @@ func SuccessResponse(w http.ResponseWriter, statusCode int, data any) {
 	)
 }
 
+func JSONResponse(w http.ResponseWriter, statusCode int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(data)
+}
+
 func ErrResponse(w http.ResponseWriter, statusCode int, err error) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
@@ -0,0 +1,39 @@
+// Package version reports the build the server runs. Version and Commit are
+// set at link time:
+//
+//	go build -ldflags "-X github.com/JMURv/media-server/pkg/version.Version=v1.2.0 \
+//		-X github.com/JMURv/media-server/pkg/version.Commit=$(git rev-parse HEAD)" ./cmd
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build information. Without a Commit set at link time, the
+// VCS revision Go stamped into the binary is used, if any.
+func Get() Info {
+	commit := Commit
+	if commit == "" {
+		commit = "unknown"
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			for _, s := range bi.Settings {
+				if s.Key == "vcs.revision" {
+					commit = s.Value
+				}
+			}
+		}
+	}
+	return Info{Version: Version, Commit: commit, GoVersion: runtime.Version()}
+}